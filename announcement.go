@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/slack-go/slack"
+)
+
+// Announcer summarizes every production deploy, manual or automatic, in the announcement channel,
+// which is shared across projects unlike the notifyChannel of each phase.
+type Announcer struct {
+	client      *slack.Client
+	projectList *ProjectList
+	// org is the GitHub organization of the repositories of the projects.
+	org string
+	// channel is the announcement channel. Announcements are disabled if empty.
+	channel string
+	// templates override the layout of the announcements.
+	templates *MessageTemplates
+	// workspaces routes the announcements of the projects to the announcement channels of their workspaces, if any.
+	workspaces *SlackWorkspaces
+}
+
+func NewAnnouncer(client *slack.Client, projectList *ProjectList, org, channel string) Announcer {
+	return Announcer{client: client, projectList: projectList, org: org, channel: channel}
+}
+
+// Announce posts the summary of the deploy described by m to the announcement channel,
+// if the deploy is to production.
+// link is the URL of the deploy, like the one of the deploy pull request, and can be empty.
+func (a Announcer) Announce(m DeployMetadata, link string) error {
+	pj := a.projectList.Find(m.Project)
+	client, channel := a.destination(pj)
+	if channel == "" || m.Phase != "production" {
+		return nil
+	}
+
+	requester := m.Requester
+	if requester == "" {
+		requester = "AutoDeploy"
+	}
+	changelog := changelogURL(a.org, pj.GitHubRepository(), m.PreviousTag, m.Tag)
+	// The announcement channel is shared, so the template has no locale
+	vars := map[string]string{
+		"Project":     m.Project,
+		"Phase":       m.Phase,
+		"Tag":         m.Tag,
+		"PreviousTag": m.PreviousTag,
+		"Requester":   requester,
+		"Link":        link,
+		"Changelog":   changelog,
+		"Reason":      m.Reason,
+		"TraceID":     m.TraceID,
+	}
+	msg := a.templates.MessageOr("announcement", "", vars, func() slack.MsgOption {
+		fields := []slack.AttachmentField{
+			{Title: "Project", Value: m.Project, Short: true},
+			{Title: "Tag", Value: m.Tag, Short: true},
+			{Title: "Requester", Value: requester, Short: true},
+		}
+		if changelog != "" {
+			fields = append(fields, slack.AttachmentField{Title: "Changelog", Value: changelog, Short: false})
+		}
+		return slack.MsgOptionAttachments(slack.Attachment{
+			Color:     "#36a64f",
+			Title:     fmt.Sprintf(":rocket: %s was deployed to production", m.Project),
+			TitleLink: link,
+			Fields:    fields,
+		})
+	})
+	_, _, err := client.PostMessage(channel, msg)
+	return err
+}
+
+// destination returns the bot client and the announcement channel of the workspace of the project,
+// or the ones of the primary workspace if the workspace has no announcement channel.
+func (a Announcer) destination(pj DeployProject) (*slack.Client, string) {
+	if ws := a.workspaces.ForProject(pj); ws != nil && ws.client != nil && ws.announcementChannel != "" {
+		return ws.client, ws.announcementChannel
+	}
+	return a.client, a.channel
+}
+
+// Broadcast posts the text to the announcement channel, for the events affecting all the projects like emergency stops.
+func (a Announcer) Broadcast(text string) error {
+	if a.channel == "" {
+		return nil
+	}
+	_, _, err := a.client.PostMessage(a.channel, slack.MsgOptionText(text, false))
+	return err
+}
+
+// AnnouncementHook returns a PostDeployHook that announces the production deploys.
+func AnnouncementHook(announcer Announcer) PostDeployHook {
+	return func(m DeployMetadata, prURL string) error {
+		return announcer.Announce(m, prURL)
+	}
+}
+
+// changelogURL returns the URL to compare the commits the previous and the current image tags were built from,
+// or an empty string if either tag doesn't contain a commit SHA.
+func changelogURL(org, repo, previousTag, tag string) string {
+	from := findCommitSHA(previousTag)
+	to := findCommitSHA(tag)
+	if org == "" || repo == "" || from == "" || to == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://github.com/%s/%s/compare/%s...%s", org, repo, from, to)
+}
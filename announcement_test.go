@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnouncer_Destination(t *testing.T) {
+	primary := slack.New("xoxb-primary")
+	dev := &SlackWorkspace{Name: "dev", client: slack.New("xoxb-dev"), announcementChannel: "CDEV"}
+	staging := &SlackWorkspace{Name: "staging", client: slack.New("xoxb-staging")}
+	workspaces := NewSlackWorkspaces(&SlackWorkspace{Name: "prod-ops", client: primary, announcementChannel: "CPROD"})
+	workspaces.Add(dev)
+	workspaces.Add(staging)
+	a := Announcer{client: primary, channel: "CPROD", workspaces: workspaces}
+
+	client, channel := a.destination(DeployProject{Workspaces: []string{"dev"}})
+	require.Equal(t, dev.client, client)
+	require.Equal(t, "CDEV", channel)
+
+	// The workspaces with no announcement channel fall back to the primary one
+	client, channel = a.destination(DeployProject{Workspaces: []string{"staging"}})
+	require.Equal(t, primary, client)
+	require.Equal(t, "CPROD", channel)
+
+	client, channel = a.destination(DeployProject{})
+	require.Equal(t, primary, client)
+	require.Equal(t, "CPROD", channel)
+}
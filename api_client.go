@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultAPITimeout and defaultAPIRetries are the policy of the API calls unless CONFIG_API_TIMEOUT and CONFIG_API_RETRIES are set.
+const (
+	defaultAPITimeout = time.Minute
+	defaultAPIRetries = 2
+)
+
+// maxAPIRetryWait is the longest wait before retrying a request, even if the API asks for a longer one by Retry-After.
+const maxAPIRetryWait = 30 * time.Second
+
+// APIClientPolicy is the deadline and the retries of the calls to the Slack and GitHub APIs,
+// so that a hung call fails the deploy instead of blocking it forever, and a transient failure doesn't fail it at all.
+type APIClientPolicy struct {
+	// Timeout is the deadline of each call, including its retries and reading the response. No deadline if zero.
+	Timeout time.Duration
+	// Retries is the number of times a call is retried on the transient failures.
+	Retries int
+	// Backoff is the wait before the first retry, which doubles on each retry.
+	Backoff time.Duration
+}
+
+// httpClient returns the client calling the APIs with the policy through http.DefaultTransport.
+func (p APIClientPolicy) httpClient() *http.Client {
+	return &http.Client{Transport: p.transport(nil)}
+}
+
+// transport returns the transport calling the APIs with the policy through base, or http.DefaultTransport if base is nil.
+func (p APIClientPolicy) transport(base http.RoundTripper) http.RoundTripper {
+	return &apiRetryTransport{base: base, policy: p, sleep: sleepContext}
+}
+
+// apiRetryTransport is the http.RoundTripper putting the deadline on the requests, and retrying them on the transient failures.
+//
+// The requests that may have been processed, like the ones timed out, are retried only if they're safe to send again,
+// which are the GETs and the GraphQL queries. The others, like posting a Slack message or merging a pull request,
+// are retried only if they surely weren't processed, like when the connection couldn't be made or the API was unavailable.
+type apiRetryTransport struct {
+	base   http.RoundTripper
+	policy APIClientPolicy
+	// sleep waits for the duration unless the request is canceled. It's replaced in tests.
+	sleep func(req *http.Request, d time.Duration) error
+}
+
+func (t *apiRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		// Read on each request, as it may be replaced by ApplyOutboundConfig after the transport is made
+		base = http.DefaultTransport
+	}
+	cancel := func() {}
+	if t.policy.Timeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), t.policy.Timeout)
+		req = req.WithContext(ctx)
+	}
+	resp, err := t.roundTrip(base, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The deadline covers reading the body too
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (t *apiRetryTransport) roundTrip(base http.RoundTripper, req *http.Request) (*http.Response, error) {
+	idempotent := idempotentRequest(req)
+	wait := t.policy.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := base.RoundTrip(req)
+		retry, after := retryableResponse(resp, err, idempotent)
+		if !retry || attempt >= t.policy.Retries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if after > 0 {
+			wait = after
+		}
+		if wait > maxAPIRetryWait {
+			wait = maxAPIRetryWait
+		}
+		var status string
+		if err != nil {
+			status = err.Error()
+		} else {
+			status = resp.Status
+			resp.Body.Close()
+		}
+		log.Printf("[WARNING] Retrying %s %s%s in %s after %s", req.Method, req.URL.Host, req.URL.Path, wait, status)
+		if err := t.sleep(req, wait); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		wait *= 2
+	}
+}
+
+// cancelOnClose cancels the context of the request once its response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryableResponse returns true if the request is worth retrying, along with the wait the API asks for by Retry-After, if any.
+func retryableResponse(resp *http.Response, err error, idempotent bool) (bool, time.Duration) {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false, 0
+		}
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			// The request wasn't sent
+			return true, 0
+		}
+		var netErr net.Error
+		return idempotent && errors.As(err, &netErr), 0
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		// The request wasn't processed
+		after, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return true, time.Duration(after) * time.Second
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent, 0
+	}
+	return false, 0
+}
+
+// idempotentRequest returns true if the request is safe to send again even if it may have been processed.
+func idempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		if !strings.HasSuffix(req.URL.Path, "/graphql") || req.GetBody == nil {
+			return false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return false
+		}
+		defer body.Close()
+		// githubv4 sends the query as {"query":"query(...){...}"} or {"query":"{...}"}, and the mutation as {"query":"mutation(...){...}"}
+		head, _ := io.ReadAll(io.LimitReader(body, 32))
+		return !bytes.Contains(head, []byte(`"mutation`))
+	}
+	return false
+}
+
+// sleepContext waits for the duration unless the request is canceled, like when the deadline of the call passes.
+func sleepContext(req *http.Request, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIRetryTransport(t *testing.T) {
+	var statuses []int
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		status := statuses[0]
+		statuses = statuses[1:]
+		if status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "3")
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	var waits []time.Duration
+	transport := &apiRetryTransport{policy: APIClientPolicy{Retries: 2, Backoff: time.Second}, sleep: func(req *http.Request, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}}
+	client := &http.Client{Transport: transport}
+	send := func(method, path, body string, s ...int) int {
+		statuses, bodies, waits = s, nil, nil
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The GETs are retried on 5xx with the backoff, up to the retries
+	require.Equal(t, http.StatusOK, send(http.MethodGet, "/repos/org/repo", "", 502, 504, 200))
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, waits)
+	require.Equal(t, http.StatusInternalServerError, send(http.MethodGet, "/repos/org/repo", "", 500, 500, 500))
+	require.Len(t, waits, 2)
+
+	// The mutations may have been processed on 5xx, but not on 503
+	mutation := `{"query":"mutation($input:MergePullRequestInput!){mergePullRequest(input:$input){clientMutationId}}"}`
+	require.Equal(t, http.StatusBadGateway, send(http.MethodPost, "/graphql", mutation, 502, 200))
+	require.Empty(t, waits)
+	require.Equal(t, http.StatusOK, send(http.MethodPost, "/graphql", mutation, 503, 200))
+	require.Equal(t, []time.Duration{3 * time.Second}, waits)
+	require.Equal(t, []string{mutation, mutation}, bodies)
+
+	query := `{"query":"query($name:String!){repository(name:$name){id}}"}`
+	require.Equal(t, http.StatusOK, send(http.MethodPost, "/graphql", query, 502, 200))
+	require.Equal(t, []string{query, query}, bodies)
+
+	// Posting a Slack message is retried only when rate limited
+	require.Equal(t, http.StatusInternalServerError, send(http.MethodPost, "/api/chat.postMessage", "text=hello", 500, 200))
+	require.Equal(t, http.StatusOK, send(http.MethodPost, "/api/chat.postMessage", "text=hello", 429, 200))
+}
+
+func TestAPIRetryTransport_Timeout(t *testing.T) {
+	// The handler runs in the goroutine of the server while the test reads the count
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := APIClientPolicy{Timeout: 100 * time.Millisecond, Retries: 2, Backoff: time.Millisecond}.httpClient()
+	_, err := client.Get(server.URL)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// ApprovalReminderOption configures the ApprovalReminder for a phase.
+// Each duration is like 30m or 2h, and the corresponding step is skipped if empty.
+type ApprovalReminderOption struct {
+	// RemindAfter is the duration after which gocat reminds the thread of the approval message.
+	RemindAfter string `yaml:"remindAfter"`
+	// EscalateAfter is the duration after which gocat DMs EscalateTo.
+	EscalateAfter string `yaml:"escalateAfter"`
+	// EscalateTo is the list of the Slack user IDs of the approvers or the on-call to escalate to.
+	// It can contain the handles of the user groups, like @payments-oncall, whose current members are DMed.
+	// Each can be qualified with the workspace, like dev/@payments-oncall, to DM the users of another workspace.
+	EscalateTo []string `yaml:"escalateTo"`
+	// CancelAfter is the duration after which gocat cancels the deploy, as clicking its Close button does.
+	// It overrides CONFIG_DEPLOY_REQUEST_EXPIRY.
+	CancelAfter string `yaml:"cancelAfter"`
+}
+
+func (o ApprovalReminderOption) enabled() bool {
+	return o.RemindAfter != "" || o.EscalateAfter != "" || o.CancelAfter != ""
+}
+
+func parseOptionalDuration(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Printf("[ERROR] Invalid duration %q: %s", s, err)
+		return 0
+	}
+	return d
+}
+
+type pendingApproval struct {
+	project string
+	phase   string
+	channel string
+	ts      string
+	// workspace is the workspace of the channel, whose bot follows up on the approval.
+	workspace   *SlackWorkspace
+	requestedAt time.Time
+	reminded    bool
+	escalated   bool
+}
+
+// ApprovalReminder follows up on the approval messages nobody has approved for a while.
+// It reminds the thread of the message, then escalates to the configured approvers by DM,
+// and eventually cancels the deploy.
+//
+// Deploys are canceled after expiry, if CONFIG_DEPLOY_REQUEST_EXPIRY opts in to it, even if the phase has no reminder configured,
+// so that abandoned requests don't leave their branches and buttons forever.
+//
+// An approval is considered pending as long as its message has the Deploy button,
+// so that it stops following up once the deploy is approved or closed in any way.
+type ApprovalReminder struct {
+	client            *slack.Client
+	projectList       *ProjectList
+	interactorFactory *InteractorFactory
+	// expiry is the default duration after which deploys are canceled. 0 means never.
+	expiry time.Duration
+	// pending is the map from "<channel>/<ts>" to the *pendingApproval of the approval message.
+	pending *sync.Map
+	// userGroups expands the user groups in escalateTo.
+	userGroups *SlackUserGroups
+	// workspaces resolves the workspaces of the approval messages and of the users in escalateTo qualified with them, like dev/@oncall.
+	workspaces *SlackWorkspaces
+	// now returns the current time, which the approvals have been pending until.
+	now func() time.Time
+}
+
+// NewApprovalReminder returns an ApprovalReminder whose interactorFactory is set later,
+// as the interactors need the ApprovalReminder to track the approval messages they post.
+func NewApprovalReminder(client *slack.Client, projectList *ProjectList, expiry time.Duration) *ApprovalReminder {
+	return &ApprovalReminder{client: client, projectList: projectList, expiry: expiry, pending: &sync.Map{}, now: time.Now}
+}
+
+// Track starts following up on the message the client posted to the channel at ts,
+// if the message is an approval message and either the phase has the reminder configured or the expiry is set.
+func (r *ApprovalReminder) Track(client *slack.Client, project, phase, channel, ts string, blocks []slack.Block) {
+	if r == nil || ts == "" || findApproveActionValue(slack.Blocks{BlockSet: blocks}) == "" {
+		return
+	}
+	if !r.projectList.Find(project).FindPhase(phase).ApprovalReminder.enabled() && r.expiry == 0 {
+		return
+	}
+	ws := r.workspaces.ByClient(client)
+	if ws == nil {
+		ws = &SlackWorkspace{client: r.client, userGroups: r.userGroups}
+	}
+	r.pending.Store(channel+"/"+ts, &pendingApproval{
+		project:     project,
+		phase:       phase,
+		channel:     channel,
+		ts:          ts,
+		workspace:   ws,
+		requestedAt: r.now(),
+	})
+}
+
+func (r *ApprovalReminder) Watch(sec int64) {
+	log.Printf("[INFO] Approval Reminder is started. Interval is %d seconds.", sec)
+	go func() {
+		// We don't stop the ticker as this is a long-running process
+		// with no way to cancel it.
+		t := time.NewTicker(time.Duration(sec) * time.Second)
+		for range t.C {
+			r.Run()
+		}
+	}()
+}
+
+func (r *ApprovalReminder) Run() {
+	r.pending.Range(func(key, value interface{}) bool {
+		p := value.(*pendingApproval)
+		done, err := r.followUp(p)
+		if err != nil {
+			log.Printf("[ERROR] Failed to follow up on the approval of %s %s: %s", p.project, p.phase, err)
+		}
+		if done {
+			r.pending.Delete(key)
+		}
+		return true
+	})
+}
+
+// followUp reminds, escalates, or cancels the pending approval depending on how long it has been pending.
+// It returns true when the approval no longer needs following up.
+func (r *ApprovalReminder) followUp(p *pendingApproval) (bool, error) {
+	opt := r.projectList.Find(p.project).FindPhase(p.phase).ApprovalReminder
+	cancelAfter := r.expiry
+	if d := parseOptionalDuration(opt.CancelAfter); d > 0 {
+		cancelAfter = d
+	}
+	if !opt.enabled() && cancelAfter == 0 {
+		return true, nil
+	}
+
+	history, err := p.workspace.client.GetConversationHistory(&slack.GetConversationHistoryParameters{
+		ChannelID: p.channel,
+		Latest:    p.ts,
+		Inclusive: true,
+		Limit:     1,
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(history.Messages) == 0 || findApproveActionValue(history.Messages[0].Blocks) == "" {
+		// Approved, closed, or deleted
+		return true, nil
+	}
+
+	elapsed := r.now().Sub(p.requestedAt)
+	if cancelAfter > 0 && elapsed >= cancelAfter {
+		return true, r.cancel(p, history.Messages[0].Blocks, cancelAfter)
+	}
+	if d := parseOptionalDuration(opt.EscalateAfter); d > 0 && elapsed >= d && !p.escalated {
+		p.escalated = true
+		return false, r.escalate(p, opt.EscalateTo, elapsed)
+	}
+	if d := parseOptionalDuration(opt.RemindAfter); d > 0 && elapsed >= d && !p.reminded {
+		p.reminded = true
+		_, _, err := p.workspace.client.PostMessage(
+			p.channel,
+			slack.MsgOptionText(fmt.Sprintf(":bell: The deploy of *%s* *%s* has been waiting for approval for %s.", p.project, p.phase, elapsed.Round(time.Minute)), false),
+			slack.MsgOptionTS(p.ts),
+		)
+		return false, err
+	}
+	return false, nil
+}
+
+func (r *ApprovalReminder) escalate(p *pendingApproval, escalateTo []string, elapsed time.Duration) error {
+	permalink, err := p.workspace.client.GetPermalink(&slack.PermalinkParameters{Channel: p.channel, Ts: p.ts})
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf(":rotating_light: The deploy of *%s* *%s* has been waiting for approval for %s.\n%s", p.project, p.phase, elapsed.Round(time.Minute), permalink)
+	if err := r.workspaces.DM(p.workspace, escalateTo, text); err != nil {
+		log.Printf("[WARNING] Failed to escalate the approval of %s %s to some of escalateTo: %s", p.project, p.phase, err)
+	}
+	_, _, err = p.workspace.client.PostMessage(
+		p.channel,
+		slack.MsgOptionText(fmt.Sprintf(":rotating_light: Escalated to %s", r.workspaces.Mentions(p.workspace, escalateTo)), false),
+		slack.MsgOptionTS(p.ts),
+	)
+	return err
+}
+
+// cancel closes the deploy as clicking the Close button of the approval message does,
+// which closes the pull request and deletes the prepared branch in case of GitOps,
+// and replaces the message with the expired one so that it can't be approved anymore.
+func (r *ApprovalReminder) cancel(p *pendingApproval, blocks slack.Blocks, after time.Duration) error {
+	if value := findRejectActionValue(blocks); value != "" {
+		params := strings.Split(value, "|")
+		if len(params) == 2 {
+			if _, err := r.interactorFactory.GetByParams(params[0]).Reject(params[1], "gocat"); err != nil {
+				return err
+			}
+		}
+	}
+	text := fmt.Sprintf(":hourglass: Expired: the deploy of *%s* *%s* was canceled as it wasn't approved in %s.", p.project, p.phase, after)
+	_, _, _, err := p.workspace.client.UpdateMessage(p.channel, p.ts, slack.MsgOptionBlocks(plainBlocks(text)...))
+	return err
+}
+
+// findRejectActionValue returns the value of the Close button that closes the prepared deploy, like the pull request,
+// or an empty string if there's none.
+func findRejectActionValue(blocks slack.Blocks) string {
+	for _, block := range blocks.BlockSet {
+		b, ok := block.(*slack.ActionBlock)
+		if !ok || b.Elements == nil {
+			continue
+		}
+		for _, e := range b.Elements.ElementSet {
+			if btn, ok := e.(*slack.ButtonBlockElement); ok && strings.Contains(strings.Split(btn.Value, "|")[0], "_reject") {
+				return btn.Value
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeApprovalSlack is the Slack API serving the approval message, which records the calls following up on it.
+type fakeApprovalSlack struct {
+	mu     sync.Mutex
+	blocks []slack.Block
+	// calls are the methods called other than reading the approval message, with their channels, like chat.postMessage C1.
+	calls []string
+	texts []string
+}
+
+func (s *fakeApprovalSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/")
+	switch method {
+	case "conversations.history":
+		b, _ := json.Marshal(slack.Blocks{BlockSet: s.blocks})
+		fmt.Fprintf(w, `{"ok": true, "messages": [{"type": "message", "ts": "1.1", "blocks": %s}]}`, b)
+		return
+	case "chat.getPermalink":
+		fmt.Fprint(w, `{"ok": true, "channel": "C1", "permalink": "https://example.slack.com/archives/C1/p11"}`)
+	default:
+		fmt.Fprint(w, `{"ok": true, "channel": "C1", "ts": "2.2"}`)
+	}
+	s.calls = append(s.calls, method+" "+r.Form.Get("channel"))
+	s.texts = append(s.texts, r.Form.Get("text")+r.Form.Get("blocks"))
+}
+
+func (s *fakeApprovalSlack) take() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls, texts := s.calls, s.texts
+	s.calls, s.texts = nil, nil
+	return calls, texts
+}
+
+func approvalMessageBlocks(approve, reject string) []slack.Block {
+	txt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
+	return []slack.Block{
+		slack.NewSectionBlock(txt, nil, slack.NewAccessory(slack.NewButtonBlockElement("", approve, txt))),
+		slack.NewActionBlock("", slack.NewButtonBlockElement("", reject, txt)),
+	}
+}
+
+func TestApprovalReminder_followUp(t *testing.T) {
+	api := &fakeApprovalSlack{blocks: approvalMessageBlocks("deploy_jenkins_approve|myapp_production_main@U1", "close")}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	opt := ApprovalReminderOption{RemindAfter: "30m", EscalateAfter: "1h", EscalateTo: []string{"U9"}}
+	pl := &ProjectList{items: []DeployProject{{ID: "myapp", Phases: []DeployPhase{{Name: "production", ApprovalReminder: opt}}}}}
+	requestedAt := time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)
+	now := requestedAt
+	r := NewApprovalReminder(slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/")), pl, 0)
+	r.now = func() time.Time { return now }
+	r.Track(r.client, "myapp", "production", "C1", "1.1", api.blocks)
+	v, ok := r.pending.Load("C1/1.1")
+	require.True(t, ok)
+	p := v.(*pendingApproval)
+
+	for _, c := range []struct {
+		elapsed time.Duration
+		calls   []string
+		// text is what the last call posts
+		text string
+	}{
+		{elapsed: 10 * time.Minute},
+		{elapsed: 30 * time.Minute, calls: []string{"chat.postMessage C1"}, text: ":bell: The deploy of *myapp* *production* has been waiting for approval for 30m0s."},
+		// The thread is reminded only once
+		{elapsed: 45 * time.Minute},
+		{elapsed: time.Hour, calls: []string{"chat.getPermalink C1", "chat.postMessage U9", "chat.postMessage C1"}, text: ":rotating_light: Escalated to <@U9>"},
+		{elapsed: 3 * time.Hour},
+	} {
+		t.Run(c.elapsed.String(), func(t *testing.T) {
+			now = requestedAt.Add(c.elapsed)
+			done, err := r.followUp(p)
+			require.NoError(t, err)
+			require.False(t, done)
+			calls, texts := api.take()
+			require.Equal(t, c.calls, calls)
+			if c.text != "" {
+				require.Equal(t, c.text, texts[len(texts)-1])
+			}
+		})
+	}
+
+	// It stops following up once the deploy is approved
+	api.mu.Lock()
+	api.blocks = plainBlocks("deployed")
+	api.mu.Unlock()
+	done, err := r.followUp(p)
+	require.NoError(t, err)
+	require.True(t, done)
+}
+
+func TestApprovalReminder_cancel(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		reject  string
+		expiry  time.Duration
+		opt     ApprovalReminderOption
+		elapsed time.Duration
+		done    bool
+		// queries are the GitHub API calls closing the pull request.
+		queries []string
+	}{
+		{
+			name:    "pull request closed after cancelAfter",
+			reject:  "deploy_kustomize_reject|PR_kwDO_12_bot/docker-image-tag-myapp-production-abc",
+			opt:     ApprovalReminderOption{CancelAfter: "2h"},
+			elapsed: 2 * time.Hour,
+			done:    true,
+			queries: []string{"closePullRequest", "ref", "deleteRef", "node"},
+		},
+		{
+			name:    "pending before cancelAfter",
+			reject:  "deploy_kustomize_reject|PR_kwDO_12_bot/docker-image-tag-myapp-production-abc",
+			opt:     ApprovalReminderOption{CancelAfter: "2h"},
+			elapsed: time.Hour,
+		},
+		{
+			name:    "cancelAfter overriding the expiry",
+			reject:  "deploy_kustomize_reject|PR_kwDO_12_bot/docker-image-tag-myapp-production-abc",
+			expiry:  time.Hour,
+			opt:     ApprovalReminderOption{CancelAfter: "2h"},
+			elapsed: time.Hour,
+		},
+		{
+			// The phase has no approvalReminder, so the request is tracked only for the expiry
+			name:    "pull request closed after the expiry",
+			reject:  "deploy_kustomize_reject|PR_kwDO_12_bot/docker-image-tag-myapp-production-abc",
+			expiry:  time.Hour,
+			elapsed: time.Hour,
+			done:    true,
+			queries: []string{"closePullRequest", "ref", "deleteRef", "node"},
+		},
+		{
+			name:    "pending before the expiry",
+			reject:  "deploy_kustomize_reject|PR_kwDO_12_bot/docker-image-tag-myapp-production-abc",
+			expiry:  time.Hour,
+			elapsed: 30 * time.Minute,
+		},
+		{
+			name:    "cancelAfter shorter than the expiry",
+			reject:  "deploy_kustomize_reject|PR_kwDO_12_bot/docker-image-tag-myapp-production-abc",
+			expiry:  2 * time.Hour,
+			opt:     ApprovalReminderOption{CancelAfter: "30m"},
+			elapsed: 30 * time.Minute,
+			done:    true,
+			queries: []string{"closePullRequest", "ref", "deleteRef", "node"},
+		},
+		{
+			name:    "expired with nothing to close",
+			reject:  "close",
+			expiry:  time.Hour,
+			elapsed: time.Hour,
+			done:    true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			api := &fakeApprovalSlack{blocks: approvalMessageBlocks("deploy_kustomize_approve|PR_kwDO_12", c.reject)}
+			slackServer := httptest.NewServer(api)
+			defer slackServer.Close()
+			var mu sync.Mutex
+			var queries []string
+			githubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				b, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				switch body := string(b); {
+				case strings.Contains(body, "closePullRequest"):
+					queries = append(queries, "closePullRequest")
+					fmt.Fprint(w, `{"data": {"closePullRequest": {"pullRequest": {"id": "PR_kwDO_12"}}}}`)
+				case strings.Contains(body, "deleteRef"):
+					queries = append(queries, "deleteRef")
+					fmt.Fprint(w, `{"data": {"deleteRef": {"clientMutationId": ""}}}`)
+				case strings.Contains(body, "qualifiedName"):
+					queries = append(queries, "ref")
+					fmt.Fprint(w, `{"data": {"repository": {"ref": {"id": "REF_1"}}}}`)
+				default:
+					queries = append(queries, "node")
+					fmt.Fprint(w, `{"data": {"node": {"body": ""}}}`)
+				}
+			}))
+			defer githubServer.Close()
+
+			pl := &ProjectList{items: []DeployProject{{ID: "myapp", Phases: []DeployPhase{{Name: "production", ApprovalReminder: c.opt}}}}}
+			requestedAt := time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)
+			r := NewApprovalReminder(slack.New("xoxb-test", slack.OptionAPIURL(slackServer.URL+"/")), pl, c.expiry)
+			r.now = func() time.Time { return requestedAt }
+			github := CreateDevGitHubInstance(githubServer.URL, "zaiminc", "manifests", "refs/heads/master")
+			r.interactorFactory = &InteractorFactory{gitops: map[string]InteractorGitOps{
+				"kustomize": NewInteractorGitOps(InteractorContext{github: github}, "kustomize", gitOpsPlugins["kustomize"]),
+			}}
+			r.Track(r.client, "myapp", "production", "C1", "1.1", api.blocks)
+
+			r.now = func() time.Time { return requestedAt.Add(c.elapsed) }
+			r.Run()
+			_, ok := r.pending.Load("C1/1.1")
+			require.Equal(t, !c.done, ok)
+			require.Equal(t, c.queries, queries)
+			calls, texts := api.take()
+			if !c.done {
+				require.Empty(t, calls)
+				return
+			}
+			// The approval message is replaced so that it can't be approved anymore
+			require.Equal(t, []string{"chat.update C1"}, calls)
+			require.Contains(t, texts[0], ":hourglass: Expired: the deploy of *myapp* *production* was canceled")
+		})
+	}
+}
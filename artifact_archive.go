@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// artifactLifecycleRuleID is the ID of the lifecycle rule of the bucket, which expires the archived artifacts.
+const artifactLifecycleRuleID = "gocat-artifacts"
+
+// ArtifactArchiver archives the artifacts of the deploys to the S3 bucket, so that what was shipped can be
+// reconstructed even after the branches are deleted.
+//
+// The artifacts of a deploy are put under <prefix>/<project>/<phase>/<time>-<trace ID>/ when it ships, like when its pull request
+// is merged, and the deploy is indexed at <prefix>/deploys/<trace ID>.json to be found by its trace ID.
+// The deploys closed without being merged are never archived, and so never replayed. See ArchiveHook.
+// The methods are safe to call on nil, which archives nothing.
+type ArtifactArchiver struct {
+	bucket string
+	prefix string
+	// retentionDays is the number of days the artifacts are kept. 0 keeps them forever.
+	retentionDays int
+	client        s3API
+	now           func() time.Time
+}
+
+type s3API interface {
+	PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
+	GetBucketLifecycleConfiguration(input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error)
+}
+
+func NewArtifactArchiver(bucket, prefix string, retentionDays int) (*ArtifactArchiver, error) {
+	if retentionDays < 0 {
+		return nil, fmt.Errorf("the retention of the artifacts must not be negative: %d", retentionDays)
+	}
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String("ap-northeast-1")},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &ArtifactArchiver{
+		bucket:        bucket,
+		prefix:        strings.Trim(prefix, "/"),
+		retentionDays: retentionDays,
+		client:        s3.New(sess),
+		now:           time.Now,
+	}, nil
+}
+
+// EnsureRetention makes the lifecycle rule of the bucket expire the artifacts after the retention,
+// keeping the other rules of the bucket as they are.
+func (a *ArtifactArchiver) EnsureRetention() error {
+	if a == nil || a.retentionDays == 0 {
+		return nil
+	}
+	var rules []*s3.LifecycleRule
+	out, err := a.client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(a.bucket)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NoSuchLifecycleConfiguration" {
+			return err
+		}
+	} else {
+		for _, r := range out.Rules {
+			if aws.StringValue(r.ID) != artifactLifecycleRuleID {
+				rules = append(rules, r)
+			}
+		}
+	}
+	rules = append(rules, &s3.LifecycleRule{
+		ID:         aws.String(artifactLifecycleRuleID),
+		Status:     aws.String(s3.ExpirationStatusEnabled),
+		Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String(a.keyPrefix())},
+		Expiration: &s3.LifecycleExpiration{Days: aws.Int64(int64(a.retentionDays))},
+	})
+	_, err = a.client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(a.bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	})
+	return err
+}
+
+func (a *ArtifactArchiver) keyPrefix() string {
+	if a.prefix == "" {
+		return ""
+	}
+	return a.prefix + "/"
+}
+
+// Archive puts the artifacts of the deploy to the bucket and returns the S3 URL of the directory they are put in.
+func (a *ArtifactArchiver) Archive(project, phase, traceID string, artifacts map[string][]byte) (string, error) {
+	if a == nil || len(artifacts) == 0 {
+		return "", nil
+	}
+	dir := a.keyPrefix() + path.Join(project, phase, a.now().UTC().Format("20060102T150405Z"))
+	if traceID != "" {
+		dir += "-" + traceID
+	}
+
+	names := make([]string, 0, len(artifacts))
+	for name := range artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := a.put(dir+"/"+name, artifacts[name]); err != nil {
+			return "", fmt.Errorf("unable to archive %s: %w", name, err)
+		}
+	}
+	if traceID != "" {
+		index, err := json.Marshal(archivedDeployIndex{Project: project, Phase: phase, Dir: dir})
+		if err != nil {
+			return "", err
+		}
+		if err := a.put(a.indexKey(traceID), index); err != nil {
+			return "", fmt.Errorf("unable to index the deploy %s: %w", traceID, err)
+		}
+	}
+	url := fmt.Sprintf("s3://%s/%s/", a.bucket, dir)
+	log.Printf("[INFO] Archived %d artifacts of %s %s to %s", len(names), project, phase, url)
+	return url, nil
+}
+
+func (a *ArtifactArchiver) put(key string, content []byte) error {
+	_, err := a.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	})
+	return err
+}
+
+func (a *ArtifactArchiver) get(key string) ([]byte, error) {
+	out, err := a.client.GetObject(&s3.GetObjectInput{Bucket: aws.String(a.bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (a *ArtifactArchiver) indexKey(traceID string) string {
+	return a.keyPrefix() + "deploys/" + traceID + ".json"
+}
+
+type archivedDeployIndex struct {
+	Project string `json:"project"`
+	Phase   string `json:"phase"`
+	Dir     string `json:"dir"`
+}
+
+// ArchivedDeploy is the deploy archived by ArtifactArchiver.
+type ArchivedDeploy struct {
+	TraceID   string
+	Project   string
+	Phase     string
+	Artifacts map[string][]byte
+}
+
+// Find returns the archived deploy of the trace ID.
+func (a *ArtifactArchiver) Find(traceID string) (ArchivedDeploy, error) {
+	d := ArchivedDeploy{TraceID: traceID, Artifacts: map[string][]byte{}}
+	if a == nil {
+		return d, fmt.Errorf("the artifacts of the deploys aren't archived. Set CONFIG_ARTIFACT_S3_BUCKET to archive them")
+	}
+	b, err := a.get(a.indexKey(traceID))
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return d, fmt.Errorf("the deploy %s is not found in the archive. It may have been closed without being merged, or expired after %d days", traceID, a.retentionDays)
+		}
+		return d, err
+	}
+	var index archivedDeployIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		return d, fmt.Errorf("the index of the deploy %s is invalid: %w", traceID, err)
+	}
+	d.Project, d.Phase = index.Project, index.Phase
+
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(a.bucket), Prefix: aws.String(index.Dir + "/")}
+	for {
+		out, err := a.client.ListObjectsV2(input)
+		if err != nil {
+			return d, err
+		}
+		for _, obj := range out.Contents {
+			key := aws.StringValue(obj.Key)
+			if d.Artifacts[strings.TrimPrefix(key, index.Dir+"/")], err = a.get(key); err != nil {
+				return d, err
+			}
+		}
+		if !aws.BoolValue(out.IsTruncated) {
+			return d, nil
+		}
+		input.ContinuationToken = out.NextContinuationToken
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeS3 struct {
+	objects map[string]string
+	rules   []*s3.LifecycleRule
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)] = string(b)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	content, ok := f.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(content))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	out := &s3.ListObjectsV2Output{}
+	prefix := aws.StringValue(input.Bucket) + "/"
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix+aws.StringValue(input.Prefix)) {
+			out.Contents = append(out.Contents, &s3.Object{Key: aws.String(strings.TrimPrefix(key, prefix))})
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeS3) GetBucketLifecycleConfiguration(input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	if f.rules == nil {
+		return nil, awserr.New("NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", nil)
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: f.rules}, nil
+}
+
+func (f *fakeS3) PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	f.rules = input.LifecycleConfiguration.Rules
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+func TestArtifactArchiver(t *testing.T) {
+	client := &fakeS3{objects: map[string]string{}}
+	archiver := &ArtifactArchiver{bucket: "artifacts", prefix: "gocat", retentionDays: 30, client: client, now: func() time.Time {
+		return time.Date(2023, 4, 1, 12, 30, 0, 0, time.UTC)
+	}}
+
+	url, err := archiver.Archive("myapp", "production", "abc123", map[string][]byte{
+		"diff.patch": []byte("-tag: v1\n+tag: v2\n"),
+		"manifests/myapp/overlays/production.yml": []byte("kind: Kustomization\n"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "s3://artifacts/gocat/myapp/production/20230401T123000Z-abc123/", url)
+	require.Equal(t, map[string]string{
+		"artifacts/gocat/deploys/abc123.json":                                                              `{"project":"myapp","phase":"production","dir":"gocat/myapp/production/20230401T123000Z-abc123"}`,
+		"artifacts/gocat/myapp/production/20230401T123000Z-abc123/diff.patch":                              "-tag: v1\n+tag: v2\n",
+		"artifacts/gocat/myapp/production/20230401T123000Z-abc123/manifests/myapp/overlays/production.yml": "kind: Kustomization\n",
+	}, client.objects)
+
+	d, err := archiver.Find("abc123")
+	require.NoError(t, err)
+	require.Equal(t, "myapp", d.Project)
+	require.Equal(t, "production", d.Phase)
+	require.Equal(t, "kind: Kustomization\n", string(d.Artifacts["manifests/myapp/overlays/production.yml"]))
+	require.Len(t, d.Artifacts, 2)
+	_, err = archiver.Find("deadbeef")
+	require.EqualError(t, err, "the deploy deadbeef is not found in the archive. It may have been closed without being merged, or expired after 30 days")
+
+	// The rule is created, and updated later, keeping the other rules of the bucket
+	require.NoError(t, archiver.EnsureRetention())
+	require.Len(t, client.rules, 1)
+	client.rules = append(client.rules, &s3.LifecycleRule{ID: aws.String("logs")})
+	archiver.retentionDays = 90
+	require.NoError(t, archiver.EnsureRetention())
+	require.Len(t, client.rules, 2)
+	require.Equal(t, "logs", aws.StringValue(client.rules[0].ID))
+	require.Equal(t, artifactLifecycleRuleID, aws.StringValue(client.rules[1].ID))
+	require.Equal(t, "gocat/", aws.StringValue(client.rules[1].Filter.Prefix))
+	require.Equal(t, int64(90), aws.Int64Value(client.rules[1].Expiration.Days))
+
+	var nilArchiver *ArtifactArchiver
+	url, err = nilArchiver.Archive("myapp", "production", "abc123", map[string][]byte{"diff.patch": nil})
+	require.NoError(t, err)
+	require.Empty(t, url)
+	require.NoError(t, nilArchiver.EnsureRetention())
+}
+
+func TestReplayManifests(t *testing.T) {
+	d := ArchivedDeploy{Artifacts: map[string][]byte{
+		"metadata.json": []byte(`{"tag": "v1"}`),
+		"diff.patch":    []byte(""),
+		"manifests/myapp/overlays/production/kustomization.yaml": []byte("a"),
+		"manifests/myapp/overlays/production-old/patch.yaml":     []byte("b"),
+	}}
+	require.Equal(t, map[string][]byte{"myapp/overlays/production/kustomization.yaml": []byte("a")}, replayManifests(d, "myapp/overlays/production"))
+}
+
+func TestPhaseManifests(t *testing.T) {
+	repo := map[string][]byte{
+		"myapp/overlays/production/kustomization.yaml": []byte("resources:\n- ../../base\n- ../../../shared/namespace.yaml\n- https://github.com/example/remote//base\npatches:\n- path: replicas.yaml\nimages:\n- name: myapp\n  newTag: v2\n"),
+		"myapp/overlays/production/replicas.yaml":      []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: myapp\nspec:\n  replicas: 3\n"),
+		"myapp/base/kustomization.yaml":                []byte("resources:\n- deployment.yaml\n"),
+		"myapp/base/deployment.yaml":                   []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: myapp\nspec:\n  replicas: 1\n  template:\n    spec:\n      containers:\n      - name: myapp\n        image: myapp:v1\n"),
+		"shared/namespace.yaml":                        []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: myapp\n"),
+		"another/base/kustomization.yaml":              []byte("resources: []\n"),
+	}
+	read := func(dir string) (map[string][]byte, error) {
+		files := map[string][]byte{}
+		for name, content := range repo {
+			if strings.HasPrefix(name, dir+"/") {
+				files[name] = content
+			}
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("directory not found")
+		}
+		return files, nil
+	}
+	files, err := phaseManifests(read, "myapp/overlays/production")
+	require.NoError(t, err)
+	require.Len(t, files, 5)
+	require.NotContains(t, files, "another/base/kustomization.yaml")
+
+	// The remote bases are left to Kustomize, which can't fetch them here
+	_, err = renderManifests(files, "myapp/overlays/production")
+	require.Error(t, err)
+
+	repo["myapp/overlays/production/kustomization.yaml"] = []byte("resources:\n- ../../base\npatches:\n- path: replicas.yaml\nimages:\n- name: myapp\n  newTag: v2\n")
+	files, err = phaseManifests(read, "myapp/overlays/production")
+	require.NoError(t, err)
+	rendered, err := renderManifests(files, "myapp/overlays/production")
+	require.NoError(t, err)
+	require.Contains(t, string(rendered), "replicas: 3")
+	require.Contains(t, string(rendered), "image: myapp:v2")
+}
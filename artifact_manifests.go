@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// kustomizationFileNames are the names of the kustomization files Kustomize looks for in a directory, in order.
+var kustomizationFileNames = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// phaseManifests returns the files of the overlay directory and of the bases and the components it refers to in the repository,
+// keyed by their paths from the root of the repository. read returns the files in a directory, like GitOperator.Files does.
+// The remote bases, like the ones in other repositories, are left to Kustomize.
+func phaseManifests(read func(dir string) (map[string][]byte, error), overlay string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	visited := map[string]bool{}
+	var collect func(dir string) error
+	collect = func(dir string) error {
+		if visited[dir] {
+			return nil
+		}
+		visited[dir] = true
+		dirFiles, err := read(dir)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", dir, err)
+		}
+		for name, content := range dirFiles {
+			files[name] = content
+		}
+		var k types.Kustomization
+		for _, name := range kustomizationFileNames {
+			if b, ok := dirFiles[path.Join(dir, name)]; ok {
+				if err := yaml.Unmarshal(b, &k); err != nil {
+					return fmt.Errorf("unable to parse %s: %w", path.Join(dir, name), err)
+				}
+				break
+			}
+		}
+		refs := append(append(append([]string{}, k.Resources...), k.Bases...), k.Components...)
+		for _, ref := range refs {
+			if strings.Contains(ref, "://") || strings.HasPrefix(ref, "github.com/") {
+				continue
+			}
+			p := path.Clean(path.Join(dir, ref))
+			if _, ok := files[p]; ok || p == ".." || strings.HasPrefix(p, "../") {
+				continue
+			}
+			if err := collect(p); err != nil {
+				// The resources outside the overlay may be the files instead of the directories
+				parent, perr := read(path.Dir(p))
+				content, ok := parent[p]
+				if perr != nil || !ok {
+					return err
+				}
+				files[p] = content
+			}
+		}
+		return nil
+	}
+	if err := collect(overlay); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// renderManifests renders the overlay directory with Kustomize from the files, which are keyed by their paths from the root
+// of the repository, returning the manifests the overlay applies to the cluster.
+func renderManifests(files map[string][]byte, overlay string) ([]byte, error) {
+	fs := filesys.MakeFsInMemory()
+	for name, content := range files {
+		if err := fs.WriteFile("/"+name, content); err != nil {
+			return nil, err
+		}
+	}
+	resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fs, "/"+overlay)
+	if err != nil {
+		return nil, fmt.Errorf("unable to render %s: %w", overlay, err)
+	}
+	return resources.AsYaml()
+}
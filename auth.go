@@ -17,6 +17,11 @@ type slackConfig struct {
 	JenkinsJobToken     string `json:"JENKINS_JOB_TOKEN"`
 	GitHubBotUserToken  string `json:"GITHUB_BOT_USER_TOKEN"`
 	ArgoCDHost          string `json:"ARGOCD_HOST"`
+	// SlackWorkspaces is the JSON array of SlackWorkspaceConfig of the additional workspaces.
+	SlackWorkspaces string `json:"SLACK_WORKSPACES"`
+	// SlackClientSecret and SlackTokenEncryptionKey are for the Add to Slack button.
+	SlackClientSecret       string `json:"SLACK_CLIENT_SECRET"`
+	SlackTokenEncryptionKey string `json:"SLACK_TOKEN_ENCRYPTION_KEY"`
 }
 
 // getSecret fetches slackConfig from AWS Secrets Manager secret
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
 )
 
 type AutoDeploy struct {
@@ -13,23 +21,86 @@ type AutoDeploy struct {
 	git         *GitOperator
 	projectList *ProjectList
 	modelList   *DeployModelList
+	// notified is the map from "<project>/<phase>" to the last tag notified
+	// as requiring a manual deploy because of the phase's autoDeployConstraint.
+	notified *sync.Map
+	// coordinator is used to skip the phases pinned to a tag.
+	coordinator *deploy.Coordinator
+	announcer   Announcer
+	// history records each evaluation for the autodeploy log command.
+	history *AutoDeployHistory
+	// recoverer keeps the watcher of each phase running when a tick panics.
+	recoverer *PanicRecoverer
+	tracer    *DeployTracer
+	// userGroups expands the user groups in onFailure of the phases.
+	userGroups *SlackUserGroups
+	// workspaces routes the notifications of the projects to their workspaces.
+	workspaces *SlackWorkspaces
+	// gate checks the deploys before deploying the phases.
+	gate DeployGate
+	// archive archives the artifacts of the deploys as they ship. See ArchiveHook.
+	archive PostDeployHook
+	// submodules advances the submodules to the deploys merged. See SubmoduleHook.
+	submodules PostDeployHook
+	// syntheticChecks runs the synthetic checks of the phases after deploying them.
+	syntheticChecks SyntheticCheckRunner
+	// webhooks calls the hooks of the phases before and after deploying them.
+	webhooks DeployWebhookRunner
+	// commandHooks runs the command hooks of the phases before and after deploying them.
+	commandHooks *CommandHookRunner
+	// limiter limits the deploys at once, which AutoDeploy skips the phases beyond until the next tick.
+	limiter *DeployLimiter
+	// deploying is the set of "<project>/<phase>" being deployed in the background,
+	// which are skipped by the ticks until their deploys finish, like when the migration gate waits for the migration job.
+	deploying *sync.Map
+	// rollouts follows the Argo Rollouts of the phases deployed in their notifyChannel.
+	rollouts *RolloutController
+	// templates override the layout of the notifications in the notifyChannel of the phases.
+	templates *MessageTemplates
 }
 
-func NewAutoDeploy(client *slack.Client, github *GitHub, git *GitOperator, projectList *ProjectList) AutoDeploy {
+func NewAutoDeploy(client *slack.Client, github *GitHub, git *GitOperator, projectList *ProjectList, coordinator *deploy.Coordinator, announcer Announcer) AutoDeploy {
 	ml := NewDeployModelList(github, git, projectList)
-	return AutoDeploy{client, github, git, projectList, ml}
+	return AutoDeploy{client, github, git, projectList, ml, &sync.Map{}, coordinator, announcer, NewAutoDeployHistory(), nil, nil, nil, nil, DeployGate{projectList: projectList}, nil, nil, SyntheticCheckRunner{}, NewDeployWebhookRunner(), nil, nil, &sync.Map{}, nil, nil}
 }
 
 func (a AutoDeploy) Watch(sec int64) {
 	log.Printf("[INFO] AutoDeploy Watcher is started. Interval is %d seconds.", sec)
-	for _, dp := range a.projectList.Items {
+	var paths []string
+	for _, dp := range a.projectList.All() {
 		for _, phase := range dp.Phases {
 			if !phase.AutoDeploy {
 				continue
 			}
+			if phase.Destination.Kind == "kustomize" {
+				paths = append(paths, phase.Destination.Kustomize.Path)
+			}
 			go a.CheckAndDeploy(sec, dp, phase)
 		}
 	}
+	go a.prefetch(sec, paths)
+}
+
+// prefetch reads the overlays of all the phases AutoDeploy tracks in a single request per tick,
+// so that GetCurrentRevision of each phase doesn't spend the GitHub rate limit on its own.
+// The phases checked before the prefetch of the tick completes fall back to the conditional request of GitHub.GetFile.
+func (a AutoDeploy) prefetch(sec int64, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	interval := time.Duration(sec) * time.Second
+	t := time.NewTicker(interval)
+	for {
+		a.prefetchOnce(paths, interval)
+		<-t.C
+	}
+}
+
+func (a AutoDeploy) prefetchOnce(paths []string, interval time.Duration) {
+	defer a.recoverer.Recover("the prefetch of AutoDeploy", "")
+	if err := a.github.PrefetchFiles(paths, interval); err != nil {
+		log.Printf("[ERROR] Failed to prefetch the overlays for AutoDeploy: %s", err)
+	}
 }
 
 func (a AutoDeploy) CheckAndDeploy(sec int64, dp DeployProject, phase DeployPhase) {
@@ -41,46 +112,390 @@ func (a AutoDeploy) CheckAndDeploy(sec int64, dp DeployProject, phase DeployPhas
 	}
 }
 
+// checkAndDeploy decides whether to deploy the phase in the tick, and deploys it in the background if so,
+// so that the deploys waiting for long, like for the migration job of the migration gate, never hold the ticks.
 func (a AutoDeploy) checkAndDeploy(dp DeployProject, phase DeployPhase) {
-	ecr, err := CreateECRInstance()
-	if err != nil {
-		log.Print(err)
+	defer a.recoverer.Recover(fmt.Sprintf("AutoDeploy of %s %s", dp.ID, phase.Name), phase.NotifyChannel)
+	key := dp.ID + "/" + phase.Name
+	if _, deploying := a.deploying.LoadOrStore(key, true); deploying {
+		log.Printf("[INFO] Auto Deploy (%s:%s) is skipped as the previous deploy is in progress", dp.ID, phase.Name)
 		return
 	}
-
-	currentTag, err := phase.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: a.github})
+	// The slots are taken before deciding, which calls the registries and GitHub the limit protects the quotas of
+	release, err := a.limiter.TryAcquire(dp, phase)
 	if err != nil {
-		log.Print(err)
+		a.deploying.Delete(key)
+		log.Printf("[INFO] Auto Deploy (%s:%s) is skipped: %s", dp.ID, phase.Name, err)
+		a.history.Add(dp.ID, phase.Name, AutoDeployRecord{At: time.Now(), Decision: "skipped as the concurrency limit is reached", Err: err.Error()})
 		return
 	}
-	tag, err := ecr.FindImageTagByRegexp(dp.ECRRegistryId(), dp.ECRRepository(), dp.ImageTagRegexp(), dp.TargetRegexp(), ImageTagVars{Branch: dp.DefaultBranch()})
-	if currentTag == tag || err != nil {
-		log.Printf("[INFO] Auto Deploy (%s:%s) is skipped", dp.ID, phase.Name)
+	d := a.decide(dp, phase, false)
+	if !d.deploy {
+		release()
+		a.deploying.Delete(key)
+		rec := d.rec
+		if rec.Decision == "failed" {
+			log.Printf("[ERROR] Auto Deploy (%s:%s) failed: %s", dp.ID, phase.Name, rec.Err)
+		} else {
+			log.Printf("[INFO] Auto Deploy (%s:%s) is %s", dp.ID, phase.Name, strings.TrimSuffix(rec.Decision+": "+rec.Err, ": "))
+		}
+		a.history.Add(dp.ID, phase.Name, rec)
 		return
 	}
+	go func() {
+		defer a.deploying.Delete(key)
+		defer release()
+		defer a.recoverer.Recover(fmt.Sprintf("AutoDeploy of %s %s", dp.ID, phase.Name), phase.NotifyChannel)
+		a.deploy(dp, phase, d, release)
+	}()
+}
+
+// deploy deploys the tag AutoDeploy decided to deploy the phase with, and releases the slots of the deploy once it's prepared.
+func (a AutoDeploy) deploy(dp DeployProject, phase DeployPhase, d autoDeployDecision, release func()) {
+	rec := d.rec
+	defer func() {
+		a.history.Add(dp.ID, phase.Name, rec)
+	}()
+	fail := func(err error) {
+		log.Print(err)
+		rec.Decision, rec.Err = "failed", err.Error()
+	}
+	branch, currentTag, tag := d.branch, rec.CurrentTag, d.tag
+	// The tag decided on is deployed, even if a newer one is pushed to the branch meanwhile
+	option := DeployOption{Branch: branch, Tag: tag, Wait: true}
 
 	log.Printf("[INFO] Auto Deploy (%s:%s) is started", dp.ID, phase.Name)
 	model, err := a.modelList.Find(phase.Kind)
 	if err != nil {
-		log.Print(err)
+		fail(err)
+		return
+	}
+	option.TraceID = a.tracer.Start(dp.ID, phase.Name, "AutoDeploy found `%s` over `%s`", tag, currentTag)
+	metadata := DeployMetadata{Project: dp.ID, Phase: phase.Name, Branch: branch, PreviousTag: currentTag, Tag: tag, Requester: "AutoDeploy", TraceID: option.TraceID}
+	err = a.webhooks.PreDeploy(phase, metadata)
+	if err == nil {
+		err = a.commandHooks.RunAll(dp, phase, phase.Hooks.PreDeployCommands, newWebhookVars("preDeploy", metadata), threadReporter(nil, metadata))
+	}
+	// The direct commits have no pull request for ModelGitOps to hold, so the migrations are applied before they're pushed
+	if err == nil && phase.CommitStrategy == CommitStrategyDirect && phase.MigrationGate.Enabled() {
+		err = newMigrationGateRunner(a.github, a.git).Run(dp, phase, tag, threadReporter(nil, metadata))
+	}
+	if err != nil {
+		release()
+		a.tracer.Emit(option.TraceID, DeployEventFailed, "%s", err)
+		fail(err)
+		a.notifyFailure(dp, phase, tag, option.TraceID, err)
 		return
 	}
-	_, err = model.Deploy(dp, phase.Name, DeployOption{Branch: dp.DefaultBranch(), Wait: true})
+	o, err := model.Deploy(dp, phase.Name, option)
+	release()
 	if err != nil {
+		a.tracer.Emit(option.TraceID, DeployEventFailed, "AutoDeploy failed: %s", err)
+		fail(err)
+		a.notifyFailure(dp, phase, tag, option.TraceID, err)
+		return
+	}
+	if prepared, ok := o.(GitOpsPrepareOutput); ok {
+		a.tracer.SetArtifacts(option.TraceID, prepared.Artifacts)
+	}
+	if a.submodules != nil {
+		if err := a.submodules(metadata, ""); err != nil {
+			log.Print(err)
+		}
+	}
+	if a.archive != nil {
+		if err := a.archive(metadata, ""); err != nil {
+			log.Print(err)
+		}
+	}
+	if phase.SyntheticChecks.Enabled() {
+		if err := a.syntheticChecks.Run(dp, phase, tag); err != nil {
+			a.tracer.Emit(option.TraceID, DeployEventFailed, "AutoDeploy deployed `%s`, but %s", tag, err)
+			fail(err)
+			a.notifyFailure(dp, phase, tag, option.TraceID, err)
+			return
+		}
+	}
+	a.tracer.Emit(option.TraceID, DeployEventDeployed, "AutoDeploy deployed `%s`", tag)
+	rec.Decision = "deployed"
+	if phase.Rollout.Enabled() && phase.NotifyChannel != "" && a.rollouts != nil {
+		go a.rollouts.Follow(a.workspace(dp).client, phase.NotifyChannel, dp, phase, tag, option.TraceID, 2*time.Hour)
+	}
+	if err := a.webhooks.PostDeploy(phase, metadata); err != nil {
+		log.Print(err)
+	}
+	if err := a.commandHooks.RunAll(dp, phase, phase.Hooks.PostDeployCommands, newWebhookVars("postDeploy", metadata), threadReporter(nil, metadata)); err != nil {
+		log.Print(err)
+	}
+	if err := a.announcer.Announce(DeployMetadata{Project: dp.ID, Phase: phase.Name, PreviousTag: currentTag, Tag: tag}, ""); err != nil {
 		log.Print(err)
+	}
+	if phase.NotifyChannel != "" {
+		vars := deployNotificationVars(map[string]string{"Project": dp.ID, "Phase": phase.Name, "Tag": tag, "Requester": "AutoDeploy", "TraceID": option.TraceID})
+		msg := a.templates.MessageOr("deploy_notification", "", vars, func() slack.MsgOption {
+			fields := []slack.AttachmentField{
+				{Title: "Project", Value: dp.ID, Short: true},
+				{Title: "Phase", Value: phase.Name, Short: true},
+				{Title: "Tag", Value: tag, Short: true},
+				{Title: "Trace", Value: option.TraceID, Short: true},
+			}
+			return slack.MsgOptionAttachments(slack.Attachment{Color: "#36a64f", Title: ":white_check_mark: Succeed to auto deploy", Fields: fields})
+		})
+		_, _, err = a.workspace(dp).client.PostMessage(phase.NotifyChannel, msg)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+	}
+}
+
+// autoDeployDecision is what AutoDeploy decided for a phase, and why.
+type autoDeployDecision struct {
+	// rec is the record of the decision, which has the reason unless the tag is to be deployed.
+	rec    AutoDeployRecord
+	branch string
+	tag    string
+	// deploy is true if the tag is to be deployed over the current revision.
+	deploy bool
+}
+
+// decide runs the checks of AutoDeploy against the phase, and returns whether to deploy the tag found for the tracked branch.
+// It doesn't notify the tags requiring the manual deploy because of autoDeployConstraint if dryRun is true,
+// so that the simulation has no side effect.
+func (a AutoDeploy) decide(dp DeployProject, phase DeployPhase, dryRun bool) (d autoDeployDecision) {
+	d.rec = AutoDeployRecord{At: time.Now()}
+	skip := func(decision string, err error) autoDeployDecision {
+		d.rec.Decision, d.rec.Err = decision, err.Error()
+		return d
+	}
+
+	if err := checkDeployable(a.coordinator, dp.ID, phase.Name); err != nil {
+		return skip("skipped", err)
+	}
+
+	ecr, err := CreateECRInstance()
+	if err != nil {
+		return skip("failed", err)
+	}
+
+	currentTag, err := phase.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: a.github})
+	if err != nil {
+		return skip("failed", err)
+	}
+	d.rec.CurrentTag = currentTag
+	d.branch, err = a.trackedBranch(dp, phase)
+	if err != nil {
+		return skip("failed", fmt.Errorf("unable to find the branch to deploy: %w", err))
+	}
+	query := dp.ImageTagQuery(phase, ImageTagVars{Branch: d.branch})
+	if phase.AutoDeployConstraint != "" {
+		query = dp.SemverTagQuery(phase, phase.AutoDeployConstraint)
+		if !dryRun {
+			a.notifyManualDeploy(ecr, dp, phase, currentTag)
+		}
+	}
+	d.tag, err = ecr.FindImageTag(query)
+	d.rec.FoundTag = d.tag
+	if err != nil {
+		return skip("skipped as no tag is found", err)
+	}
+	if currentTag == d.tag && !a.digestChanged(ecr, query, phase, d.tag) {
+		d.rec.Decision = "skipped as already deployed"
+		return d
+	}
+
+	if err := phase.TagPolicy.Check(d.tag); err != nil {
+		return skip("skipped as the tagPolicy denied the tag", err)
+	}
+
+	m := DeployMetadata{Project: dp.ID, Phase: phase.Name, Branch: d.branch, Tag: d.tag, PreviousTag: currentTag, Requester: "AutoDeploy"}
+	if err := a.gate.Check(dp, phase, m, ""); err != nil {
+		switch {
+		case errors.Is(err, ErrErrorBudgetExhausted):
+			return skip("skipped as the error budget is exhausted", err)
+		case errors.Is(err, ErrPolicyDenied):
+			return skip("skipped as the deploy policy denied it", err)
+		case errors.Is(err, ErrNotBusinessDay):
+			return skip("skipped as it's not a business day", err)
+		}
+		return skip("skipped", err)
+	}
+	d.deploy = true
+	return d
+}
+
+// Simulate runs the checks of AutoDeploy against the phase once without deploying, and returns what AutoDeploy would do and why.
+func (a AutoDeploy) Simulate(dp DeployProject, phase DeployPhase) string {
+	d := a.decide(dp, phase, true)
+	lines := []string{fmt.Sprintf("*Simulated AutoDeploy of %s %s* (nothing is deployed)", dp.ID, phase.Name)}
+	if !phase.AutoDeploy {
+		lines = append(lines, ":warning: autoDeploy is off for the phase, so AutoDeploy doesn't check it")
+	}
+	lines = append(lines,
+		fmt.Sprintf("Tracked branch: `%s`", d.branch),
+		fmt.Sprintf("Current revision: `%s`", d.rec.CurrentTag),
+		fmt.Sprintf("Resolved tag: `%s`", d.tag),
+	)
+	full := a.limiter.Full(dp, phase)
+	switch {
+	case !d.deploy:
+		lines = append(lines, "Decision: "+strings.TrimSuffix(d.rec.Decision+": "+d.rec.Err, ": "))
+	case full != "":
+		lines = append(lines, fmt.Sprintf("Decision: would be skipped as the concurrency limit is reached: %s", full))
+	default:
+		lines = append(lines, fmt.Sprintf("Decision: would deploy `%s` over `%s`", d.tag, d.rec.CurrentTag))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// notifyFailure notifies the failure of the deploy to the notifyChannel of the phase mentioning onFailure of the phase,
+// in the layout of the deploy_failure template if any, and DMs the members of onFailure, so that the on-call notices it.
+func (a AutoDeploy) notifyFailure(dp DeployProject, phase DeployPhase, tag string, traceID string, deployErr error) {
+	if len(phase.OnFailure) == 0 {
 		return
 	}
+	text := fmt.Sprintf(":x: AutoDeploy failed to deploy `%s` to *%s* *%s*: %s%s", tag, dp.ID, phase.Name, describeError(deployErr), traceLine(traceID))
+	ws := a.workspace(dp)
 	if phase.NotifyChannel != "" {
+		mentions := a.workspaces.Mentions(ws, phase.OnFailure)
+		vars := deployNotificationVars(map[string]string{"Project": dp.ID, "Phase": phase.Name, "Tag": tag, "Requester": "AutoDeploy", "TraceID": traceID, "Error": describeError(deployErr), "Mentions": mentions})
+		msg := a.templates.MessageOr("deploy_failure", "", vars, func() slack.MsgOption {
+			return slack.MsgOptionText(mentions+"\n"+text, false)
+		})
+		if _, _, err := ws.client.PostMessage(phase.NotifyChannel, msg); err != nil {
+			log.Print(err)
+		}
+	}
+	if err := a.workspaces.DM(ws, phase.OnFailure, text); err != nil {
+		log.Printf("[WARNING] Failed to DM the failure of AutoDeploy of %s %s to some of onFailure: %s", dp.ID, phase.Name, err)
+	}
+}
+
+// workspace returns the workspace the notifications of the project go to.
+func (a AutoDeploy) workspace(dp DeployProject) *SlackWorkspace {
+	if ws := a.workspaces.ForProject(dp); ws != nil {
+		return ws
+	}
+	return &SlackWorkspace{client: a.client, userGroups: a.userGroups}
+}
+
+// notifyManualDeploy notifies the notifyChannel of the phase when the latest semver tag
+// doesn't satisfy the autoDeployConstraint of the phase, like a minor or major bump for ~1.4,
+// so that someone deploys it manually after reviewing it.
+// Each tag is notified only once.
+func (a AutoDeploy) notifyManualDeploy(ecr ECRClient, dp DeployProject, phase DeployPhase, currentTag string) {
+	if phase.NotifyChannel == "" {
+		return
+	}
+	latest, err := ecr.FindImageTag(dp.SemverTagQuery(phase, ""))
+	if err != nil || latest == currentTag {
+		return
+	}
+	constraint, err := ParseSemverConstraint(phase.AutoDeployConstraint)
+	if err != nil {
+		log.Printf("[ERROR] Invalid autoDeployConstraint of %s:%s: %s", dp.ID, phase.Name, err)
+		return
+	}
+	if v, ok := ParseSemver(latest); !ok || constraint.Check(v) {
+		return
+	}
+	key := dp.ID + "/" + phase.Name
+	if last, ok := a.notified.Load(key); ok && last == latest {
+		return
+	}
+	a.notified.Store(key, latest)
+
+	vars := deployNotificationVars(map[string]string{"Project": dp.ID, "Phase": phase.Name, "Tag": latest, "Constraint": phase.AutoDeployConstraint})
+	msg := a.templates.MessageOr("manual_deploy", "", vars, func() slack.MsgOption {
 		fields := []slack.AttachmentField{
 			{Title: "Project", Value: dp.ID, Short: true},
 			{Title: "Phase", Value: phase.Name, Short: true},
-			{Title: "Tag", Value: tag, Short: true},
+			{Title: "Tag", Value: latest, Short: true},
+			{Title: "Constraint", Value: phase.AutoDeployConstraint, Short: true},
 		}
-		msg := slack.Attachment{Color: "#36a64f", Title: ":white_check_mark: Succeed to auto deploy", Fields: fields}
-		_, _, err = a.client.PostMessage(phase.NotifyChannel, slack.MsgOptionAttachments(msg))
+		return slack.MsgOptionAttachments(slack.Attachment{
+			Color:  "#daa038",
+			Title:  ":raised_hand: Manual deploy required",
+			Text:   fmt.Sprintf("`%s` is out of the auto deploy constraint. Run `deploy %s %s %s` to deploy it.", latest, dp.ID, phase.Name, latest),
+			Fields: fields,
+		})
+	})
+	if _, _, err := a.workspace(dp).client.PostMessage(phase.NotifyChannel, msg); err != nil {
+		log.Print(err)
+	}
+}
+
+// digestChanged returns true if the phase pins digests and the image deployed has a different digest from the one tagged with tag,
+// meaning that the mutable tag, like latest, was pushed again after the last deploy.
+func (a AutoDeploy) digestChanged(ecr ECRClient, query ImageTagQuery, phase DeployPhase, tag string) bool {
+	if !phase.PinDigest || phase.Destination.Kind != "kustomize" {
+		return false
+	}
+	current, err := phase.Destination.Kustomize.GetCurrentDigest(GetCurrentRevisionInput{github: a.github})
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	latest, err := ecr.ImageDigest(query, tag)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return current != latest
+}
+
+// trackedBranch returns the branch to deploy to the phase, following the trackBranch of the phase.
+func (a AutoDeploy) trackedBranch(dp DeployProject, phase DeployPhase) (string, error) {
+	if phase.TrackBranch == "" {
+		return dp.DefaultBranch(), nil
+	}
+	if !strings.ContainsAny(phase.TrackBranch, "*?[") {
+		return phase.TrackBranch, nil
+	}
+
+	branches, err := a.github.ListBranch(dp.GitHubRepository())
+	if err != nil {
+		return "", err
+	}
+	return latestBranch(branches, phase.TrackBranch)
+}
+
+// latestBranch returns the latest branch matching the glob pattern in version order,
+// where the numbers in the branch names are compared numerically, like release/1.10 over release/1.9.
+func latestBranch(branches []string, pattern string) (string, error) {
+	var latest string
+	for _, b := range branches {
+		ok, err := path.Match(pattern, b)
 		if err != nil {
-			log.Print(err)
-			return
+			return "", fmt.Errorf("invalid trackBranch %q: %w", pattern, err)
+		}
+		if ok && (latest == "" || compareNatural(b, latest) > 0) {
+			latest = b
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no branch matches %s", pattern)
+	}
+	return latest, nil
+}
+
+var naturalChunkPattern = regexp.MustCompile(`[0-9]+|[^0-9]+`)
+
+// compareNatural compares a and b chunk by chunk, numerically for the chunks of digits and lexically otherwise.
+func compareNatural(a, b string) int {
+	ca, cb := naturalChunkPattern.FindAllString(a, -1), naturalChunkPattern.FindAllString(b, -1)
+	for i := 0; i < len(ca) && i < len(cb); i++ {
+		na, errA := strconv.Atoi(ca[i])
+		nb, errB := strconv.Atoi(cb[i])
+		switch {
+		case errA == nil && errB == nil && na != nb:
+			if na < nb {
+				return -1
+			}
+			return 1
+		case ca[i] != cb[i]:
+			return strings.Compare(ca[i], cb[i])
 		}
 	}
+	return len(ca) - len(cb)
 }
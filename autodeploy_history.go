@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAutoDeployRecords is the number of the latest AutoDeploy evaluations kept per project and phase.
+const maxAutoDeployRecords = 20
+
+// AutoDeployRecord is the result of an AutoDeploy evaluation of a phase,
+// kept to answer "why didn't it deploy?".
+type AutoDeployRecord struct {
+	At         time.Time `json:"at"`
+	CurrentTag string    `json:"currentTag"`
+	FoundTag   string    `json:"foundTag"`
+	// Decision is what AutoDeploy did, like deployed or skipped, with the reason.
+	Decision string `json:"decision"`
+	Err      string `json:"error,omitempty"`
+}
+
+// Format returns the record with the time in the time zone of the calendar.
+func (r AutoDeployRecord) Format(calendar BusinessCalendar) string {
+	s := fmt.Sprintf("%s %s (current: `%s`, found: `%s`)", calendar.Format(r.At, "2006-01-02 15:04:05"), r.Decision, r.CurrentTag, r.FoundTag)
+	if r.Err != "" {
+		s += ": " + r.Err
+	}
+	return s
+}
+
+// AutoDeployHistory keeps the latest AutoDeploy evaluations of each project and phase in memory.
+type AutoDeployHistory struct {
+	mu      sync.Mutex
+	records map[string][]AutoDeployRecord
+}
+
+func NewAutoDeployHistory() *AutoDeployHistory {
+	return &AutoDeployHistory{records: map[string][]AutoDeployRecord{}}
+}
+
+func (h *AutoDeployHistory) Add(project, phase string, r AutoDeployRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := project + "/" + phase
+	records := append(h.records[key], r)
+	if n := len(records); n > maxAutoDeployRecords {
+		records = records[n-maxAutoDeployRecords:]
+	}
+	h.records[key] = records
+}
+
+// List returns the records of the project and phase, from the oldest to the newest.
+func (h *AutoDeployHistory) List(project, phase string) []AutoDeployRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]AutoDeployRecord(nil), h.records[project+"/"+phase]...)
+}
+
+// Describe returns the records of the project and phase as a Slack message, with the times in the time zone of the calendar.
+func (h *AutoDeployHistory) Describe(project, phase string, calendar BusinessCalendar) string {
+	records := h.List(project, phase)
+	if len(records) == 0 {
+		return fmt.Sprintf("No AutoDeploy evaluation of *%s* *%s* is recorded since gocat started", project, phase)
+	}
+	lines := []string{fmt.Sprintf("*AutoDeploy log of %s %s*", project, phase)}
+	for _, r := range records {
+		lines = append(lines, r.Format(calendar))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// autoDeployHistoryAPIHandler is a http.Handler to read the AutoDeploy evaluations, guarded by the token of the config API.
+//
+//	GET /autodeploy/history?project=myapp&phase=production  returns the records of the phase as a JSON array, from the oldest to the newest.
+type autoDeployHistoryAPIHandler struct {
+	token   string
+	history *AutoDeployHistory
+}
+
+func (h autoDeployHistoryAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !hasBearerToken(r, h.token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	project, phase := r.URL.Query().Get("project"), r.URL.Query().Get("phase")
+	if project == "" || phase == "" {
+		http.Error(w, "project and phase are required", http.StatusBadRequest)
+		return
+	}
+	records := h.history.List(project, phase)
+	if records == nil {
+		records = []AutoDeployRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(records)
+}
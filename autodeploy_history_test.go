@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoDeployHistory(t *testing.T) {
+	h := NewAutoDeployHistory()
+	for i := 0; i < maxAutoDeployRecords+5; i++ {
+		h.Add("myapp", "staging", AutoDeployRecord{FoundTag: fmt.Sprint(i), Decision: "skipped"})
+	}
+	h.Add("myapp", "production", AutoDeployRecord{Decision: "deployed"})
+
+	records := h.List("myapp", "staging")
+	require.Len(t, records, maxAutoDeployRecords)
+	require.Equal(t, "5", records[0].FoundTag)
+	require.Equal(t, fmt.Sprint(maxAutoDeployRecords+4), records[len(records)-1].FoundTag)
+	require.Len(t, h.List("myapp", "production"), 1)
+	require.Empty(t, h.List("other", "staging"))
+}
+
+func TestAutoDeployHistoryAPIHandler(t *testing.T) {
+	h := NewAutoDeployHistory()
+	at := time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)
+	h.Add("myapp", "production", AutoDeployRecord{At: at, CurrentTag: "v1", FoundTag: "v2", Decision: "skipped: out of the deploy window"})
+	h.Add("myapp", "production", AutoDeployRecord{At: at.Add(time.Minute), CurrentTag: "v1", FoundTag: "v2", Decision: "failed", Err: "denied"})
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		autoDeployHistoryAPIHandler{token: "secret", history: h}.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/autodeploy/history?project=myapp&phase=production", token).Code)
+	}
+	rec := httptest.NewRecorder()
+	autoDeployHistoryAPIHandler{history: h}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/autodeploy/history?project=myapp&phase=production", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serve(http.MethodGet, "/autodeploy/history?project=myapp&phase=production", "secret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.JSONEq(t, `[
+		{"at": "2024-03-11T09:00:00Z", "currentTag": "v1", "foundTag": "v2", "decision": "skipped: out of the deploy window"},
+		{"at": "2024-03-11T09:01:00Z", "currentTag": "v1", "foundTag": "v2", "decision": "failed", "error": "denied"}
+	]`, rec.Body.String())
+
+	rec = serve(http.MethodGet, "/autodeploy/history?project=myapp&phase=staging", "secret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `[]`, rec.Body.String())
+
+	require.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/autodeploy/history?project=myapp", "secret").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodPost, "/autodeploy/history?project=myapp&phase=production", "secret").Code)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestBranch(t *testing.T) {
+	branches := []string{"master", "develop", "release/1.9", "release/1.10", "feature/release"}
+
+	got, err := latestBranch(branches, "release/*")
+	require.NoError(t, err)
+	require.Equal(t, "release/1.10", got)
+
+	got, err = latestBranch(branches, "release/1.9*")
+	require.NoError(t, err)
+	require.Equal(t, "release/1.9", got)
+
+	_, err = latestBranch(branches, "hotfix/*")
+	require.Error(t, err)
+}
+
+func TestAutoDeploy_ConcurrencyLimit(t *testing.T) {
+	a := AutoDeploy{history: NewAutoDeployHistory(), limiter: NewDeployLimiter(1), deploying: &sync.Map{}}
+	pj := DeployProject{ID: "myapp"}
+	phase := DeployPhase{Name: "staging"}
+	release, err := a.limiter.TryAcquire(DeployProject{ID: "api"}, phase)
+	require.NoError(t, err)
+	defer release()
+
+	// The phase is skipped before asking GitHub and the registries, which AutoDeploy has none of here
+	a.checkAndDeploy(pj, phase)
+	records := a.history.List("myapp", "staging")
+	require.Len(t, records, 1)
+	require.Equal(t, "skipped as the concurrency limit is reached", records[0].Decision)
+	_, deploying := a.deploying.Load("myapp/staging")
+	require.False(t, deploying)
+}
+
+func TestAutoDeploy_deployDecidedTag(t *testing.T) {
+	var options []DeployOption
+	a := AutoDeploy{history: NewAutoDeployHistory(), modelList: &DeployModelList{"fake": deployModelFunc(func(pj DeployProject, phase string, option DeployOption) (DeployOutput, error) {
+		options = append(options, option)
+		return GitOpsPrepareOutput{status: DeployStatusSuccess}, nil
+	})}}
+	pj := DeployProject{ID: "myapp"}
+	a.announcer = Announcer{projectList: &ProjectList{items: []DeployProject{pj}}}
+
+	// The tag is passed with or without autoDeployConstraint, so that the one pushed meanwhile is never deployed unchecked
+	for _, phase := range []DeployPhase{{Name: "staging", Kind: "fake"}, {Name: "production", Kind: "fake", AutoDeployConstraint: "^1.0.0"}} {
+		options = nil
+		a.deploy(pj, phase, autoDeployDecision{rec: AutoDeployRecord{CurrentTag: "abcdef0"}, branch: "master", tag: "1234567", deploy: true}, func() {})
+		require.Len(t, options, 1)
+		require.Equal(t, "master", options[0].Branch)
+		require.Equal(t, "1234567", options[0].Tag)
+		require.Equal(t, "deployed", a.history.List("myapp", phase.Name)[0].Decision)
+	}
+}
+
+// deployModelFunc is a DeployModel deploying by the function.
+type deployModelFunc func(pj DeployProject, phase string, option DeployOption) (DeployOutput, error)
+
+func (f deployModelFunc) Deploy(pj DeployProject, phase string, option DeployOption) (DeployOutput, error) {
+	return f(pj, phase, option)
+}
@@ -9,6 +9,7 @@ import (
 	"text/template"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/aws/aws-sdk-go/service/ecs"
@@ -21,7 +22,13 @@ type ECRClient struct {
 
 type ImageTagVars struct {
 	Branch string
-	Phase  string
+	// BranchSlug is the branch name lowercased, with non-alphanumeric characters replaced with hyphens.
+	// It's computed from Branch when finding the image tag.
+	BranchSlug string
+	Phase      string
+	// Path is the path of the component in the monorepo the image is built from.
+	// It's empty unless the phase has images configured.
+	Path string
 }
 
 func (self ImageTagVars) Parse(s string) (string, error) {
@@ -34,6 +41,9 @@ func (self ImageTagVars) Parse(s string) (string, error) {
 	return b.String(), err
 }
 
+// ecrEndpoint overrides the endpoint of ECR if not empty, which is the fake registry of the dev mode. See DevRegistry.
+var ecrEndpoint string
+
 func CreateECRInstance() (ECRClient, error) {
 	sess, err := session.NewSession(&aws.Config{
 		Region: aws.String("ap-northeast-1")},
@@ -41,36 +51,195 @@ func CreateECRInstance() (ECRClient, error) {
 	if err != nil {
 		return ECRClient{}, err
 	}
-	return ECRClient{client: ecr.New(sess, aws.NewConfig().WithRegion("ap-northeast-1"))}, nil
+	config := aws.NewConfig().WithRegion("ap-northeast-1")
+	if ecrEndpoint != "" {
+		config = config.WithEndpoint(ecrEndpoint).WithCredentials(credentials.NewStaticCredentials("dev", "dev", ""))
+	}
+	return ECRClient{client: ecr.New(sess, config)}, nil
+}
+
+// ImageTagQuery is the query to find the image tag to deploy for an image.
+type ImageTagQuery struct {
+	// Image is the docker repository of the image, like 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp.
+	Image        string
+	FilterRegexp string
+	TargetRegexp string
+	Order        TagOrder
+	// Constraint is the semver constraint, like ~1.4, the tag must satisfy if not empty.
+	// Tags that aren't semantic versions never satisfy it.
+	Constraint string
+	Vars       ImageTagVars
+}
+
+func (q ImageTagQuery) registryID() string {
+	path := strings.Split(q.Image, ".")
+	if len(path) < 2 {
+		return ""
+	}
+	return path[0]
+}
+
+func (q ImageTagQuery) repository() string {
+	path := strings.Split(q.Image, "/")
+	if len(path) < 2 {
+		return ""
+	}
+	return strings.Join(path[1:], "/")
+}
+
+// FindImageTag finds the image tag matching the query.
+func (e ECRClient) FindImageTag(q ImageTagQuery) (string, error) {
+	tags, err := e.FindImageTags([]ImageTagQuery{q})
+	if err != nil {
+		return "", err
+	}
+	return tags[0], nil
+}
+
+// FindImageTags finds the image tags matching the queries, in the same order as the queries.
+//
+// This is used to resolve all the images of a monorepo for one deploy.
+// Images are described once per ECR repository, so the queries for the images
+// that share the repository, like ones distinguished only by tag prefixes, take a single round-trip.
+func (e ECRClient) FindImageTags(queries []ImageTagQuery) ([]string, error) {
+	described := map[string][]*ecr.ImageDetail{}
+	tags := make([]string, len(queries))
+	for i, q := range queries {
+		registryID, repo := q.registryID(), q.repository()
+		key := registryID + "/" + repo
+		details, ok := described[key]
+		if !ok {
+			var err error
+			details, err = e.describeImages(&registryID, &repo, nil)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", q.Image, err)
+			}
+			described[key] = details
+		}
+		tag, err := findImageTag(details, q)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.Image, err)
+		}
+		tags[i] = tag
+	}
+	return tags, nil
+}
+
+// ImageDigest returns the digest of the image tagged with tag in the repository of the query,
+// which changes when a mutable tag like latest is pushed again.
+func (e ECRClient) ImageDigest(q ImageTagQuery, tag string) (string, error) {
+	registryID, repo := q.registryID(), q.repository()
+	outputs, err := e.client.DescribeImages(&ecr.DescribeImagesInput{
+		RegistryId:     &registryID,
+		RepositoryName: &repo,
+		ImageIds:       []*ecr.ImageIdentifier{{ImageTag: aws.String(tag)}},
+	})
+	if err != nil {
+		return "", registryError(err)
+	}
+	if len(outputs.ImageDetails) == 0 || outputs.ImageDetails[0].ImageDigest == nil {
+		return "", withCode(ErrCodeImageTagNotFound, fmt.Errorf("image %s:%s not found", repo, tag))
+	}
+	return *outputs.ImageDetails[0].ImageDigest, nil
+}
+
+// ImageScanFindings returns the number of the vulnerabilities of each severity, like CRITICAL and HIGH,
+// the ECR image scan found in the image tagged with tag. It returns nil if the image hasn't been scanned.
+func (e ECRClient) ImageScanFindings(q ImageTagQuery, tag string) (map[string]int64, error) {
+	registryID, repo := q.registryID(), q.repository()
+	outputs, err := e.client.DescribeImages(&ecr.DescribeImagesInput{
+		RegistryId:     &registryID,
+		RepositoryName: &repo,
+		ImageIds:       []*ecr.ImageIdentifier{{ImageTag: aws.String(tag)}},
+	})
+	if err != nil {
+		return nil, registryError(err)
+	}
+	if len(outputs.ImageDetails) == 0 {
+		return nil, withCode(ErrCodeImageTagNotFound, fmt.Errorf("image %s:%s not found", repo, tag))
+	}
+	summary := outputs.ImageDetails[0].ImageScanFindingsSummary
+	if summary == nil {
+		return nil, nil
+	}
+	findings := map[string]int64{}
+	for severity, count := range summary.FindingSeverityCounts {
+		findings[severity] = aws.Int64Value(count)
+	}
+	return findings, nil
 }
 
 func (e ECRClient) FindImageTagByRegexp(registryId string, repo string, rawFilterRegexp string, rawTargetRegexp string, vars ImageTagVars) (string, error) {
+	details, err := e.describeImages(&registryId, &repo, nil)
+	if err != nil {
+		return "", err
+	}
+	return findImageTag(details, ImageTagQuery{FilterRegexp: rawFilterRegexp, TargetRegexp: rawTargetRegexp, Vars: vars})
+}
+
+var branchSlugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// branchSlug returns the branch name lowercased, with non-alphanumeric characters replaced with hyphens.
+func branchSlug(branch string) string {
+	return strings.Trim(branchSlugPattern.ReplaceAllString(strings.ToLower(branch), "-"), "-")
+}
+
+func findImageTag(details []*ecr.ImageDetail, q ImageTagQuery) (string, error) {
+	vars := q.Vars
+	vars.BranchSlug = branchSlug(vars.Branch)
 	vars.Branch = strings.Replace(vars.Branch, "/", "_", -1)
-	filterRegexp, err := vars.Parse(rawFilterRegexp)
+	filterRegexp, err := vars.Parse(q.FilterRegexp)
 	if err != nil {
-		return "", fmt.Errorf("[ERROR] filterRegexp cannot be parsed: %s", rawFilterRegexp)
+		return "", fmt.Errorf("[ERROR] filterRegexp cannot be parsed: %s", q.FilterRegexp)
 	}
-	targetRegexp, err := vars.Parse(rawTargetRegexp)
+	targetRegexp, err := vars.Parse(q.TargetRegexp)
 	if err != nil {
-		return "", fmt.Errorf("[ERROR] targetRegexp cannot be parsed: %s", rawTargetRegexp)
+		return "", fmt.Errorf("[ERROR] targetRegexp cannot be parsed: %s", q.TargetRegexp)
+	}
+	var constraint *SemverConstraint
+	if q.Constraint != "" {
+		c, err := ParseSemverConstraint(q.Constraint)
+		if err != nil {
+			return "", err
+		}
+		constraint = &c
 	}
-	arr := e.describeImages(&registryId, &repo, nil)
-	for _, v := range arr {
-		for _, vv1 := range v.ImageTags {
-			if regexp.MustCompile(filterRegexp).FindStringSubmatch(*vv1) == nil {
+	var candidates []imageTagCandidate
+	for _, v := range details {
+		tag := findTargetTag(v, filterRegexp, targetRegexp)
+		if tag == "" {
+			continue
+		}
+		if constraint != nil {
+			if sv, ok := ParseSemver(tag); !ok || !constraint.Check(sv) {
 				continue
 			}
-			for _, vv2 := range v.ImageTags {
-				if regexp.MustCompile(targetRegexp).FindStringSubmatch(*vv2) != nil {
-					return *vv2, nil
-				}
+		}
+		candidates = append(candidates, imageTagCandidate{tag: tag, pushedAt: aws.TimeValue(v.ImagePushedAt)})
+	}
+	if len(candidates) == 0 {
+		return "", withCode(ErrCodeImageTagNotFound, fmt.Errorf("[ERROR] NotFound specified image tag"))
+	}
+	sortImageTagCandidates(candidates, q.Order)
+	return candidates[0].tag, nil
+}
+
+// findTargetTag returns the tag of the image matching targetRegexp if any of the tags of the image matches filterRegexp.
+func findTargetTag(image *ecr.ImageDetail, filterRegexp string, targetRegexp string) string {
+	for _, vv1 := range image.ImageTags {
+		if regexp.MustCompile(filterRegexp).FindStringSubmatch(*vv1) == nil {
+			continue
+		}
+		for _, vv2 := range image.ImageTags {
+			if regexp.MustCompile(targetRegexp).FindStringSubmatch(*vv2) != nil {
+				return *vv2
 			}
 		}
 	}
-	return "", fmt.Errorf("[ERROR] NotFound specified image tag")
+	return ""
 }
 
-func (e ECRClient) describeImages(registryId *string, repo *string, nextToken *string) []*ecr.ImageDetail {
+func (e ECRClient) describeImages(registryId *string, repo *string, nextToken *string) ([]*ecr.ImageDetail, error) {
 	input := &ecr.DescribeImagesInput{
 		RegistryId:     registryId,
 		RepositoryName: repo,
@@ -79,12 +248,16 @@ func (e ECRClient) describeImages(registryId *string, repo *string, nextToken *s
 	outputs, err := e.client.DescribeImages(input)
 	if err != nil {
 		log.Printf("Failed to describe images: %v", err)
-		return []*ecr.ImageDetail{}
+		return nil, registryError(err)
 	}
 	if outputs.NextToken != nil {
-		return append(outputs.ImageDetails, e.describeImages(registryId, repo, outputs.NextToken)...)
+		next, err := e.describeImages(registryId, repo, outputs.NextToken)
+		if err != nil {
+			return nil, err
+		}
+		return append(outputs.ImageDetails, next...), nil
 	}
-	return outputs.ImageDetails
+	return outputs.ImageDetails, nil
 }
 
 type LambdaClient struct {
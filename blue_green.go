@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	yaml "gopkg.in/yaml.v2"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+const (
+	ColorBlue  = "blue"
+	ColorGreen = "green"
+)
+
+var colorPattern = regexp.MustCompile(`\b(blue|green)\b`)
+
+// BlueGreenOption configures the blue/green deploys of a kustomize phase.
+//
+// The phase has an overlay for each color, and a file in the manifest repository, like the Service or the Ingress,
+// that selects the live color. Deploys update the overlay of the idle color,
+// and the switch command opens the pull request flipping the file to the idle color once it's verified,
+// which is approved in Slack just like the deploys. It's supported by the kustomize kind only.
+type BlueGreenOption struct {
+	// Blue and Green are the paths of kustomization.yaml of the overlays of the colors.
+	Blue  string `yaml:"blue"`
+	Green string `yaml:"green"`
+	// SwitchPath is the path of the file selecting the live color, like service.yaml whose selector has color: blue.
+	SwitchPath string `yaml:"switchPath"`
+	// Key is the key in SwitchPath whose values name the live color, like the selector label,
+	// or the service name of the Ingress backend as in myapp-blue. It's color if empty.
+	Key string `yaml:"key"`
+	// VerifyURL is the URL that responds with a 2xx status if the idle color is ready to serve,
+	// which the switch command checks before switching to it.
+	// It's a Go template rendered with BlueGreenVars, like https://{{.Color}}.myapp.internal/healthz.
+	VerifyURL string `yaml:"verifyURL"`
+}
+
+func (o BlueGreenOption) Enabled() bool {
+	return o.Blue != "" && o.Green != "" && o.SwitchPath != ""
+}
+
+func (o BlueGreenOption) validate(kind string) error {
+	if o.Blue == "" && o.Green == "" && o.SwitchPath == "" {
+		return nil
+	}
+	if !o.Enabled() {
+		return fmt.Errorf("blue, green, and switchPath are all required")
+	}
+	if kind != "kustomize" {
+		return fmt.Errorf("the %s kind doesn't deploy to the idle color. Only the kustomize kind is supported", kind)
+	}
+	return nil
+}
+
+func (o BlueGreenOption) key() string {
+	if o.Key == "" {
+		return "color"
+	}
+	return o.Key
+}
+
+// Overlay returns the path of kustomization.yaml of the overlay of the color.
+func (o BlueGreenOption) Overlay(color string) string {
+	if color == ColorGreen {
+		return o.Green
+	}
+	return o.Blue
+}
+
+func (o BlueGreenOption) keyPattern() *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^(\s*-?\s*["']?` + regexp.QuoteMeta(o.key()) + `["']?\s*:\s*)(.*)$`)
+}
+
+// LiveColor returns the color the content of SwitchPath selects.
+func (o BlueGreenOption) LiveColor(content []byte) (string, error) {
+	colors := map[string]bool{}
+	for _, m := range o.keyPattern().FindAllSubmatch(content, -1) {
+		for _, c := range colorPattern.FindAllString(string(m[2]), -1) {
+			colors[c] = true
+		}
+	}
+	switch {
+	case colors[ColorBlue] && colors[ColorGreen]:
+		return "", fmt.Errorf("%s selects both blue and green with the key %s", o.SwitchPath, o.key())
+	case colors[ColorBlue]:
+		return ColorBlue, nil
+	case colors[ColorGreen]:
+		return ColorGreen, nil
+	}
+	return "", fmt.Errorf("%s has no blue or green in the values of the key %s", o.SwitchPath, o.key())
+}
+
+// Switch returns the content of SwitchPath with the values of the key naming the color from replaced by the color to.
+func (o BlueGreenOption) Switch(content []byte, from, to string) []byte {
+	word := regexp.MustCompile(`\b` + from + `\b`)
+	return o.keyPattern().ReplaceAllFunc(content, func(line []byte) []byte {
+		m := o.keyPattern().FindSubmatch(line)
+		return append(append([]byte{}, m[1]...), word.ReplaceAll(m[2], []byte(to))...)
+	})
+}
+
+// BlueGreenSwitchOverWrite switches the content of SwitchPath from the live color to the idle one.
+// It fails if the content doesn't select the color from anymore, like when another switch was merged in the meantime.
+type BlueGreenSwitchOverWrite struct {
+	option   BlueGreenOption
+	from, to string
+}
+
+func (o BlueGreenSwitchOverWrite) Update(b []byte) (interface{}, error) {
+	live, err := o.option.LiveColor(b)
+	if err != nil {
+		return nil, err
+	}
+	if live != o.from {
+		return nil, fmt.Errorf("%s selects %s now, not %s", o.option.SwitchPath, live, o.from)
+	}
+	return o.option.Switch(b, o.from, o.to), nil
+}
+
+// idleColor returns the other color.
+func idleColor(live string) string {
+	if live == ColorBlue {
+		return ColorGreen
+	}
+	return ColorBlue
+}
+
+// BlueGreenVars is the set of variables available in BlueGreenOption.VerifyURL.
+type BlueGreenVars struct {
+	Project string
+	Phase   string
+	Color   string
+	Tag     string
+}
+
+func (self BlueGreenVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+// BlueGreenSwitcher puts the idle color of the blue/green phases into service.
+type BlueGreenSwitcher struct {
+	github     *GitHub
+	git        *GitOperator
+	httpClient *http.Client
+}
+
+func NewBlueGreenSwitcher(github *GitHub, git *GitOperator) BlueGreenSwitcher {
+	return BlueGreenSwitcher{github: github, git: git, httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// readFile reads the file on the default branch fetched right now instead of the files GitHub caches,
+// so that the colors are never decided by SwitchPath before the last switch was merged.
+func (s BlueGreenSwitcher) readFile(filePath string) ([]byte, error) {
+	ref, err := s.git.FetchDefaultBranch()
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.git.Files(ref, path.Dir(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", filePath, err)
+	}
+	b, ok := files[filePath]
+	if !ok {
+		return nil, fmt.Errorf("%s is not found", filePath)
+	}
+	return b, nil
+}
+
+// Colors returns the live and the idle colors of the phase.
+func (s BlueGreenSwitcher) Colors(phase DeployPhase) (live string, idle string, err error) {
+	content, err := s.readFile(phase.BlueGreen.SwitchPath)
+	if err != nil {
+		return "", "", err
+	}
+	live, err = phase.BlueGreen.LiveColor(content)
+	if err != nil {
+		return "", "", err
+	}
+	return live, idleColor(live), nil
+}
+
+// IdlePhase returns the phase whose path and destination are the overlay of the idle color, which deploys update.
+func (s BlueGreenSwitcher) IdlePhase(phase DeployPhase) (DeployPhase, error) {
+	_, idle, err := s.Colors(phase)
+	if err != nil {
+		return phase, err
+	}
+	phase.Path = phase.BlueGreen.Overlay(idle)
+	phase.Destination.Kustomize.Path = phase.Path
+	return phase, nil
+}
+
+// BlueGreenSwitchOutput is the result of BlueGreenSwitcher.Switch.
+type BlueGreenSwitchOutput struct {
+	From string
+	To   string
+	// Tag is the image tag of the color put into service.
+	Tag               string
+	PullRequestID     string
+	PullRequestNumber int
+	Branch            string
+}
+
+// Switch verifies the idle color of the phase, and opens the pull request changing SwitchPath to select it.
+// The idle color must have an image deployed, and respond to VerifyURL if any.
+func (s BlueGreenSwitcher) Switch(pj DeployProject, phase DeployPhase, requester User) (BlueGreenSwitchOutput, error) {
+	var o BlueGreenSwitchOutput
+	bg := phase.BlueGreen
+	if err := bg.validate(phase.Kind); err != nil {
+		return o, fmt.Errorf("%s %s can't be switched: %w", pj.ID, phase.Name, err)
+	}
+	content, err := s.readFile(bg.SwitchPath)
+	if err != nil {
+		return o, err
+	}
+	live, err := bg.LiveColor(content)
+	if err != nil {
+		return o, err
+	}
+	o.From, o.To = live, idleColor(live)
+
+	o.Tag, err = s.deployedTag(phase, o.To)
+	if err != nil {
+		return o, fmt.Errorf("unable to find the tag deployed to %s of %s %s: %w", o.To, pj.ID, phase.Name, err)
+	}
+	if o.Tag == "" {
+		return o, fmt.Errorf("nothing is deployed to %s of %s %s", o.To, pj.ID, phase.Name)
+	}
+	if err := s.verify(pj, phase, o.To, o.Tag); err != nil {
+		return o, err
+	}
+
+	o.Branch = fmt.Sprintf("bot/switch-%s-%s-%s-%s", pj.ID, phase.Name, o.To, RandString(5))
+	message := fmt.Sprintf("Switch to %s. project: %s, phase: %s, tag: %s.", o.To, pj.ID, phase.Name, o.Tag)
+	diff, err := s.git.PushOverWrite(o.Branch, bg.SwitchPath, BlueGreenSwitchOverWrite{option: bg, from: o.From, to: o.To}, message)
+	if err != nil {
+		return o, err
+	}
+
+	metadata := DeployMetadata{
+		Project:          pj.ID,
+		Phase:            phase.Name,
+		Requester:        requester.SlackDisplayName,
+		RequesterSlackID: requester.SlackUserID,
+		Kind:             DeployKindBlueGreenSwitch,
+	}
+	title := fmt.Sprintf("Switch %s %s to %s", pj.ID, phase.Name, o.To)
+	body := fmt.Sprintf("Switch %s %s from %s to %s with `%s`\nRequested by %s\n\n```diff\n%s```\n\n%s", pj.ID, phase.Name, o.From, o.To, o.Tag, requester.SlackDisplayName, diff, metadata.PullRequestFooter())
+	o.PullRequestID, o.PullRequestNumber, err = s.github.CreatePullRequest(o.Branch, title, body)
+	if err != nil {
+		return o, err
+	}
+	if err := s.github.SetNewPullRequestMetadata("", o.PullRequestID, phase.PullRequest); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// deployedTag returns the tag of the image of the phase in the overlay of the color on the default branch fetched right now.
+func (s BlueGreenSwitcher) deployedTag(phase DeployPhase, color string) (string, error) {
+	b, err := s.readFile(phase.BlueGreen.Overlay(color))
+	if err != nil {
+		return "", err
+	}
+	var kf types.Kustomization
+	if err := yaml.Unmarshal(b, &kf); err != nil {
+		return "", fmt.Errorf("the file should be kustomization format: %w", err)
+	}
+	for _, image := range kf.Images {
+		if image.Name == phase.Destination.Kustomize.Image {
+			return image.NewTag, nil
+		}
+	}
+	return "", nil
+}
+
+// verify checks the color responds to VerifyURL with a 2xx status.
+func (s BlueGreenSwitcher) verify(pj DeployProject, phase DeployPhase, color, tag string) error {
+	if phase.BlueGreen.VerifyURL == "" {
+		return nil
+	}
+	url, err := BlueGreenVars{Project: pj.ID, Phase: phase.Name, Color: color, Tag: tag}.Parse(phase.BlueGreen.VerifyURL)
+	if err != nil {
+		return fmt.Errorf("unable to render the verifyURL of %s %s: %w", pj.ID, phase.Name, err)
+	}
+	resp, err := s.httpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("unable to verify %s of %s %s: %w", color, pj.ID, phase.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+	return fmt.Errorf("%s of %s %s with `%s` failed the verification: %s %s", color, pj.ID, phase.Name, tag, resp.Status, strings.TrimSpace(string(b)))
+}
+
+// blueGreenSwitchBlocks returns the approval message of the switch.
+func blueGreenSwitchBlocks(github *GitHub, userID string, pj DeployProject, phase string, o BlueGreenSwitchOutput) []slack.Block {
+	question := fmt.Sprintf("%s から *%s* (`%s`) に切り替えますか?", o.From, o.To, o.Tag)
+	return pullRequestApprovalBlocks(github, userID, pj, phase, question, "Switch", o.PullRequestID, o.PullRequestNumber, o.Branch)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlueGreenOptionSwitch(t *testing.T) {
+	o := BlueGreenOption{Blue: "overlays/production-blue/kustomization.yaml", Green: "overlays/production-green/kustomization.yaml", SwitchPath: "overlays/production/service.yaml"}
+	service := `apiVersion: v1
+kind: Service
+metadata:
+  name: myapp
+  labels:
+    team: blue-team
+spec:
+  selector:
+    app: myapp
+    color: blue
+`
+	live, err := o.LiveColor([]byte(service))
+	require.NoError(t, err)
+	require.Equal(t, ColorBlue, live)
+
+	switched := string(o.Switch([]byte(service), ColorBlue, ColorGreen))
+	require.Contains(t, switched, "    color: green\n")
+	// Values of the other keys are kept as they are
+	require.Contains(t, switched, "team: blue-team")
+
+	live, err = o.LiveColor([]byte(switched))
+	require.NoError(t, err)
+	require.Equal(t, ColorGreen, live)
+	require.Equal(t, o.Green, o.Overlay(live))
+
+	o.Key = "name"
+	ingress := `spec:
+  rules:
+  - http:
+      paths:
+      - backend:
+          service:
+            name: myapp-green
+`
+	live, err = o.LiveColor([]byte(ingress))
+	require.NoError(t, err)
+	require.Equal(t, ColorGreen, live)
+	require.Contains(t, string(o.Switch([]byte(ingress), ColorGreen, ColorBlue)), "name: myapp-blue\n")
+
+	_, err = o.LiveColor([]byte("name: myapp-blue\nname: myapp-green\n"))
+	require.EqualError(t, err, "overlays/production/service.yaml selects both blue and green with the key name")
+
+	_, err = o.LiveColor([]byte("name: myapp\n"))
+	require.Error(t, err)
+}
+
+func TestBlueGreenSwitchOverWrite(t *testing.T) {
+	o := BlueGreenOption{Blue: "overlays/production-blue/kustomization.yaml", Green: "overlays/production-green/kustomization.yaml", SwitchPath: "overlays/production/service.yaml"}
+	b, err := BlueGreenSwitchOverWrite{option: o, from: ColorBlue, to: ColorGreen}.Update([]byte("spec:\n  selector:\n    color: blue\n"))
+	require.NoError(t, err)
+	require.Equal(t, "spec:\n  selector:\n    color: green\n", string(b.([]byte)))
+
+	// Another switch was merged since the colors were read
+	_, err = BlueGreenSwitchOverWrite{option: o, from: ColorBlue, to: ColorGreen}.Update([]byte("spec:\n  selector:\n    color: green\n"))
+	require.EqualError(t, err, "overlays/production/service.yaml selects green now, not blue")
+}
+
+func TestBlueGreenOptionValidate(t *testing.T) {
+	o := BlueGreenOption{Blue: "overlays/production-blue/kustomization.yaml", Green: "overlays/production-green/kustomization.yaml", SwitchPath: "overlays/production/service.yaml"}
+	require.NoError(t, o.validate("kustomize"))
+	require.NoError(t, BlueGreenOption{}.validate("kanvas"))
+	require.EqualError(t, o.validate("kanvas"), "the kanvas kind doesn't deploy to the idle color. Only the kustomize kind is supported")
+	require.EqualError(t, BlueGreenOption{Blue: o.Blue}.validate("kustomize"), "blue, green, and switchPath are all required")
+}
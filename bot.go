@@ -1,68 +1,361 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
 )
 
 func main() {
+	devMode := flag.Bool("dev", false, "run with the fake Slack, GitHub, registry, and cluster for local development, without any credentials")
+	flag.Parse()
+	if flag.Arg(0) == "config" && flag.Arg(1) == "migrate" {
+		migrateConfigCommand(flag.Args()[2:])
+		return
+	}
+	var dev *DevEnvironment
+	if *devMode {
+		var err error
+		if dev, err = StartDevEnvironment(os.Getenv("CONFIG_DEV_ADDR")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	config, err := InitConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := ApplyOutboundConfig(OutboundConfig{
+		ProxyURL: config.OutboundProxyURL,
+		NoProxy:  config.OutboundNoProxy,
+		CABundle: config.OutboundCABundle,
+		Timeout:  config.OutboundTimeout,
+	}); err != nil {
+		log.Fatal(err)
+	}
+	if flag.Arg(0) == "preflight" {
+		if !runPreflight(os.Stdout, preflightChecks(config)) {
+			os.Exit(1)
+		}
+		return
+	}
 
-	client := slack.New(
-		config.SlackOAuthToken,
-		slack.OptionLog(log.New(os.Stdout, "slack-bot: ", log.Lshortfile|log.LstdFlags)),
-	)
+	mux, err := newBot(config, dev)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dev != nil {
+		go dev.Slack.ReadCommands(os.Stdin)
+	}
+
+	// As the Go documentation says, ListenAndServe always returns a non-nil error,
+	// and the error is usually ErrServerClosed on graceful stop.
+	//
+	// Therefore, we exit with 0 when the error is ErrServerClosed,
+	// and log the error then exit with 1 otherwise for diagnosis.
+	if err := http.ListenAndServe(":3000", mux); err != http.ErrServerClosed {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// newBot wires gocat up with the config, and returns the handler of its endpoints.
+// It talks to the fakes of the dev environment instead of Slack and GitHub if dev isn't nil.
+func newBot(config *CatConfig, dev *DevEnvironment) (*http.ServeMux, error) {
+	mux := http.NewServeMux()
+	apiPolicy := APIClientPolicy{Timeout: config.APITimeout, Retries: config.APIRetries, Backoff: time.Second}
+	slackOptions := []slack.Option{slack.OptionLog(log.New(os.Stdout, "slack-bot: ", log.Lshortfile|log.LstdFlags)), slack.OptionHTTPClient(apiPolicy.httpClient())}
+	if dev != nil {
+		slackOptions = append(slackOptions, slack.OptionAPIURL(dev.URL+"/api/"))
+	}
+	client := slack.New(config.SlackOAuthToken, slackOptions...)
 	github := CreateGitHubInstance(config.GitHubAccessToken, config.ManifestRepositoryOrg, config.ManifestRepositoryName, config.GitHubDefaultBranch)
+	if dev != nil {
+		github = CreateDevGitHubInstance(dev.URL+"/github", config.ManifestRepositoryOrg, config.ManifestRepositoryName, config.GitHubDefaultBranch)
+	}
+	github.mergeMethod = config.GitHubMergeMethod
+	github.UsePolicy(apiPolicy)
+	if config.ManifestForkRepository != "" {
+		github.UseFork(config.ManifestForkRepository)
+	}
+	// The janitor below looks after the directory selected too
+	config.GitRoot = selectGitRoot(config.GitRoot, config.GitStorage, config.MemFSMaxRepoSize, github.RepositoryDiskUsage)
 	git := CreateGitOperatorInstance(
 		config.GitHubUserName,
 		config.GitHubAccessToken,
 		config.ManifestRepository,
 		config.GitHubDefaultBranch,
-		os.Getenv("GOCAT_GITROOT"),
+		config.GitRoot,
+		config.EnableSparseCheckout,
+		config.ManifestRemoteName,
 	)
-	userList := UserList{github: github, slackClient: client}
-	projectList := NewProjectList()
-	interactorContext := InteractorContext{projectList: &projectList, userList: &userList, github: github, git: git, client: client, config: *config}
+	git.UseFork(config.ManifestForkRepository)
+	git.UseMirror(config.ManifestMirror, slackMirrorAlert(client, config.AnnouncementChannel))
+	git.UsePullRequests(github.ListOpenPullRequests)
+	git.OnDefaultBranchPush(github.InvalidateFiles)
+	userList := UserList{github: github, slackClient: client, workspace: config.SlackWorkspaceName, primary: true, syncByEmail: config.EnableGitHubUserSync}
+	projectList := NewProjectList(config.FeatureFlags)
+	var templates *MessageTemplates
+	if config.MessageTemplateDir != "" {
+		var err error
+		if templates, err = LoadMessageTemplates(config.MessageTemplateDir); err != nil {
+			return nil, err
+		}
+	}
+	// The workspaces are added once their interactors are built below, the primary one first,
+	// but the announcer and the hooks are given them now to route the notifications of the projects to them.
+	workspaces := &SlackWorkspaces{}
+	announcer := NewAnnouncer(client, &projectList, config.ManifestRepositoryOrg, config.AnnouncementChannel)
+	announcer.templates = templates
+	announcer.workspaces = workspaces
+	approvalReminder := NewApprovalReminder(client, &projectList, config.DeployRequestExpiry)
+	userGroups := NewSlackUserGroups(client)
+	approvalReminder.userGroups = userGroups
+	rollouts := NewRolloutController()
+	reporter, err := NewErrorReporter(config.ErrorReportingDSN)
+	if err != nil {
+		return nil, err
+	}
+	recoverer := NewPanicRecoverer(reporter, client, config.AnnouncementChannel)
+	tracer := NewDeployTracer()
+	if config.EventKafkaRESTURL != "" {
+		stream, err := NewDeployEventStream(config.EventKafkaRESTURL, config.EventKafkaTopic, config.EventKafkaFormat)
+		if err != nil {
+			return nil, err
+		}
+		stream.Start()
+		tracer.stream = stream
+	}
+	if config.EnableDeployPipeline {
+		tracer.pipelines = NewDeployPipelineBoard(client)
+	}
+	var archiver *ArtifactArchiver
+	if config.ArtifactS3Bucket != "" {
+		archiver, err = NewArtifactArchiver(config.ArtifactS3Bucket, config.ArtifactS3Prefix, config.ArtifactRetentionDays)
+		if err != nil {
+			return nil, err
+		}
+		if err := archiver.EnsureRetention(); err != nil {
+			log.Printf("[ERROR] Failed to set the retention of the artifacts in %s: %s", config.ArtifactS3Bucket, err)
+		}
+	}
+	syntheticChecks := NewSyntheticCheckRunner(*config)
+	commandHooks := NewCommandHookRunner(*config)
+	limiter := NewDeployLimiter(config.MaxConcurrentDeploys)
+	postDeployHooks := NewPostDeployHooks(client, workspaces, templates, &github, &git, &projectList, announcer, tracer, syntheticChecks, commandHooks, archiver)
+	prefs := NewUserPreferenceStore(configNamespace())
+	if err := prefs.Load(context.Background()); err != nil {
+		log.Printf("[ERROR] Failed to load the user preferences: %s", err)
+	}
+	var previewer *RolloutPreviewer
+	if config.EnableRolloutPreview {
+		previewer = NewRolloutPreviewer()
+	}
+	coordinator := deploy.NewCoordinator(configNamespace(), deployCoordinatorConfigMapName)
+	if dev != nil {
+		coordinator.UseClientset(dev.Kubernetes)
+	}
+	gate := NewDeployGate(*config, &projectList, coordinator, tracer)
+	interactorContext := InteractorContext{projectList: &projectList, userList: &userList, github: github, git: git, client: client, config: *config, postDeployHooks: postDeployHooks, approvalReminder: approvalReminder, rollouts: rollouts, recoverer: recoverer, tracer: tracer, archiver: archiver, prefs: prefs, previewer: previewer, commandHooks: commandHooks, limiter: limiter, templates: templates, gate: gate}
 	interactorFactory := NewInteractorFactory(interactorContext)
-	autoDeploy := NewAutoDeploy(client, &github, &git, &projectList)
+	approvalReminder.interactorFactory = &interactorFactory
+	workspaces.Add(&SlackWorkspace{
+		Name:                config.SlackWorkspaceName,
+		client:              client,
+		verificationToken:   config.SlackVerificationToken,
+		announcementChannel: config.AnnouncementChannel,
+		userList:            &userList,
+		userGroups:          userGroups,
+		interactorFactory:   &interactorFactory,
+	})
+	// newWorkspace builds the workspace of the bot token other than the primary one, with its own users and interactors.
+	// The verification token is the one of the app, which is shared by the workspaces installed by the Add to Slack button.
+	newWorkspace := func(name, token string) *SlackWorkspace {
+		wsClient := slack.New(token, slack.OptionLog(log.New(os.Stdout, "slack-bot("+name+"): ", log.Lshortfile|log.LstdFlags)), slack.OptionHTTPClient(apiPolicy.httpClient()))
+		wsUserList := UserList{github: github, slackClient: wsClient, workspace: name, syncByEmail: config.EnableGitHubUserSync}
+		wsContext := interactorContext
+		wsContext.client = wsClient
+		wsContext.userList = &wsUserList
+		wsInteractorFactory := NewInteractorFactory(wsContext)
+		return &SlackWorkspace{
+			Name:              name,
+			client:            wsClient,
+			verificationToken: config.SlackVerificationToken,
+			userList:          &wsUserList,
+			userGroups:        NewSlackUserGroups(wsClient),
+			interactorFactory: &wsInteractorFactory,
+		}
+	}
+	for _, c := range config.SlackWorkspaces {
+		if workspaces.ByName(c.Name) != nil {
+			return nil, fmt.Errorf("Slack workspace %s is defined twice", c.Name)
+		}
+		ws := newWorkspace(c.Name, c.OAuthToken)
+		ws.verificationToken = c.VerificationToken
+		ws.announcementChannel = c.AnnouncementChannel
+		workspaces.Add(ws)
+	}
+	var installStore *SlackInstallationStore
+	if config.SlackClientID != "" {
+		cipher, err := NewTokenCipher(config.SlackTokenEncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		installStore = NewSlackInstallationStore(configNamespace(), cipher, config.SlackAllowedTeams)
+		if err := LoadSlackInstallations(context.Background(), installStore, workspaces, newWorkspace); err != nil {
+			log.Printf("[ERROR] Unable to load the Slack installations: %s", err)
+		}
+	}
+	if workspaces.Multiple() || installStore != nil {
+		if err := workspaces.ResolveTeamIDs(); err != nil {
+			return nil, err
+		}
+	}
+	approvalReminder.workspaces = workspaces
+	configStore := NewConfigStore(configNamespace())
+	// Polling jobs use the background instance so that they don't starve the interactive deploys of the rate limit
+	backgroundGitHub := github.Background()
+	tracer.issues = NewFailureIssueReporter(&backgroundGitHub, &projectList, &userList, workspaces)
+	autoDeploy := NewAutoDeploy(client, &backgroundGitHub, &git, &projectList, coordinator, announcer)
+	autoDeploy.recoverer = recoverer
+	autoDeploy.tracer = tracer
+	autoDeploy.userGroups = userGroups
+	autoDeploy.workspaces = workspaces
+	autoDeploy.templates = templates
+	autoDeploy.gate = gate
+	autoDeploy.submodules = SubmoduleHook(&git, &projectList)
+	autoDeploy.archive = ArchiveHook(archiver, &git, &projectList, tracer, cosignCLI{})
+	autoDeploy.syntheticChecks = syntheticChecks
+	autoDeploy.commandHooks = commandHooks
+	autoDeploy.limiter = limiter
+	autoDeploy.rollouts = rollouts
 
 	log.SetOutput(os.Stdout)
 	if config.EnableAutoDeploy {
 		autoDeploy.Watch(60)
 	}
+	approvalReminder.Watch(60)
+	if config.EnableStalenessWatcher {
+		NewStalenessWatcher(client, &backgroundGitHub, &projectList).Watch(3600)
+	}
+	if config.GitRoot != "" {
+		janitor := NewGitRootJanitor(config.GitRoot, config.GitRootQuota, git.getLocalRepoRoot())
+		janitor.Watch(600)
+	}
 
-	http.Handle("/events", SlackListener{
-		client:            client,
-		verificationToken: config.SlackVerificationToken,
-		projectList:       &projectList,
-		userList:          &userList,
-		interactorFactory: &interactorFactory,
-	})
-	http.Handle("/interaction", interactionHandler{
+	aliases := NewCommandAliasList()
+	refresher := NewListRefresher(&projectList, workspaces, aliases)
+	refresher.client, refresher.adminChannel = client, config.AdminChannel
+	refresher.leader = NewLeaderElector(config.LeaderElectionLease)
+	refresher.leader.Start()
+	refresher.Start(config.ListRefreshInterval)
+	slackListener := &SlackListener{
+		client:             client,
+		verificationToken:  config.SlackVerificationToken,
+		projectList:        &projectList,
+		userList:           &userList,
+		interactorFactory:  &interactorFactory,
+		coordinator:        coordinator,
+		approvalReaction:   config.ApprovalReaction,
+		approvalReminder:   approvalReminder,
+		ephemeralResponses: config.EphemeralResponses,
+		autoDeployHistory:  autoDeploy.history,
+		autoDeploy:         &autoDeploy,
+		announcer:          announcer,
+		github:             &github,
+		configStore:        configStore,
+		jobRunner:          NewJobRunner(&github, &git),
+		blueGreen:          NewBlueGreenSwitcher(&github, &git),
+		secretRotator:      NewSecretRotator(&github, &git),
+		envSetter:          NewEnvSetter(&github, &git),
+		replayer:           NewDeployReplayer(&github, &git, archiver),
+		recoverer:          recoverer,
+		tracer:             tracer,
+		aliases:            aliases,
+		workspaces:         workspaces,
+		refresher:          refresher,
+		prefs:              prefs,
+		templates:          templates,
+	}
+	slackListener.restrictReadCommands = config.RestrictReadCommands
+	mux.Handle("/events", slackListener)
+	var triggerSources []TriggerSource
+	if config.TriggerSQSQueueURL != "" {
+		source, err := NewSQSTriggerSource(config.TriggerSQSQueueURL)
+		if err != nil {
+			return nil, err
+		}
+		triggerSources = append(triggerSources, source)
+	}
+	if config.TriggerNATSURL != "" {
+		source, err := NewNATSTriggerSource(config.TriggerNATSURL, config.TriggerNATSSubject, config.TriggerNATSRootCA)
+		if err != nil {
+			return nil, err
+		}
+		triggerSources = append(triggerSources, source)
+	}
+	NewTriggerIngester(slackListener, triggerSources...).Watch(10)
+	interactions := interactionHandler{
 		verificationToken: config.SlackVerificationToken,
 		client:            client,
 		projectList:       &projectList,
 		userList:          &userList,
 		interactorFactory: &interactorFactory,
-	})
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		coordinator:       coordinator,
+		approvalReminder:  approvalReminder,
+		github:            &github,
+		rollouts:          rollouts,
+		recoverer:         recoverer,
+		commands:          slackListener,
+		workspaces:        workspaces,
+		rollbacker:        NewRollbacker(&github, &git),
+		tracer:            tracer,
+		refresher:         refresher,
+	}
+	mux.Handle("/interaction", interactions)
+	if dev != nil {
+		dev.Slack.Connect(config.SlackVerificationToken, slackListener, interactions)
+	}
+	if config.GitHubWebhookSecret != "" {
+		botLogin, err := github.ViewerLogin()
+		if err != nil {
+			log.Printf("[WARNING] Unable to get the GitHub login of gocat: %s", err)
+		}
+		mux.Handle("/github", githubWebhookHandler{
+			secret:          config.GitHubWebhookSecret,
+			client:          client,
+			botLogin:        botLogin,
+			postDeployHooks: postDeployHooks,
+			projectList:     &projectList,
+			tracer:          tracer,
+		})
+	}
+	if installStore != nil {
+		installHandler := newSlackInstallHandler(config.SlackClientID, config.SlackClientSecret, config.SlackRedirectURL, installStore, workspaces, newWorkspace)
+		mux.Handle("/slack/install", installHandler)
+		mux.Handle("/slack/oauth_redirect", installHandler)
+	}
+	if config.ConfigAPIToken != "" {
+		mux.Handle("/config", configAPIHandler{
+			token:  config.ConfigAPIToken,
+			store:  configStore,
+			reload: refresher.Refresh,
+		})
+		mux.Handle("/autodeploy/history", autoDeployHistoryAPIHandler{
+			token:   config.ConfigAPIToken,
+			history: autoDeploy.history,
+		})
+	}
+	mux.Handle("/metrics", gitHubRateLimitMetricsHandler{limiter: github.rateLimiter})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "hello")
 	})
-
-	// As the Go documentation says, ListenAndServe always returns a non-nil error,
-	// and the error is usually ErrServerClosed on graceful stop.
-	//
-	// Therefore, we exit with 0 when the error is ErrServerClosed,
-	// and log the error then exit with 1 otherwise for diagnosis.
-	if err := http.ListenAndServe(":3000", nil); err != http.ErrServerClosed {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
+	return mux, nil
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// branchProtections are the rules of the branch protection of GitHub that block gocat from pushing to or merging into the branch,
+// each with the phrases GitHub explains it with, in either the output of the rejected push or the error of the rejected merge.
+// The first rule found in the error wins, so the specific rules come before the generic ones.
+var branchProtections = []struct {
+	code    ErrorCode
+	name    string
+	phrases []string
+}{
+	{ErrCodeProtectionPullRequest, "requiring pull requests", []string{"changes must be made through a pull request"}},
+	{ErrCodeProtectionReviews, "requiring approving reviews", []string{"approving review", "review is required", "changes requested", "review required"}},
+	{ErrCodeProtectionSignedCommits, "requiring signed commits", []string{"verified signature", "signed commit"}},
+	{ErrCodeProtectionLinearHistory, "requiring linear history", []string{"must not contain merge commits", "merge commits are not allowed", "linear history"}},
+	{ErrCodeProtectionStatusChecks, "requiring status checks", []string{"status check"}},
+	{ErrCodeProtectedBranch, "protecting the branch", []string{"protected branch", "repository rule violation"}},
+}
+
+// branchProtectionError explains which protection of the branch blocked the push or the merge, if any, with its code.
+// output is what the remote said other than the error, like the progress of the push, where GitHub explains the rejection.
+// It returns the error as is if it isn't caused by the branch protection.
+func branchProtectionError(err error, output string) error {
+	if err == nil {
+		return nil
+	}
+	text := strings.ToLower(err.Error() + "\n" + output)
+	for _, p := range branchProtections {
+		for _, phrase := range p.phrases {
+			if strings.Contains(text, phrase) {
+				return withCode(p.code, fmt.Errorf("the branch protection %s blocked it: %w", p.name, err))
+			}
+		}
+	}
+	return err
+}
+
+// parseMergeMethod returns the merge method of CONFIG_GITHUB_MERGE_METHOD.
+func parseMergeMethod(s string) (githubv4.PullRequestMergeMethod, error) {
+	switch s {
+	case "", "merge":
+		return githubv4.PullRequestMergeMethodMerge, nil
+	case "squash":
+		return githubv4.PullRequestMergeMethodSquash, nil
+	case "rebase":
+		return githubv4.PullRequestMergeMethodRebase, nil
+	}
+	return "", fmt.Errorf("unknown merge method %q. It's either merge, squash, or rebase", s)
+}
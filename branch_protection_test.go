@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shurcooL/githubv4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranchProtectionError(t *testing.T) {
+	for _, c := range []struct {
+		err    string
+		output string
+		code   ErrorCode
+	}{
+		{"At least 1 approving review is required by reviewers with write access.", "", ErrCodeProtectionReviews},
+		{"Base branch requires signed commits", "", ErrCodeProtectionSignedCommits},
+		{"Merge commits are not allowed on this repository.", "", ErrCodeProtectionLinearHistory},
+		{`Required status check "ci" is expected.`, "", ErrCodeProtectionStatusChecks},
+		// The push explains the rejection in the output
+		{"command error on refs/heads/master: protected branch hook declined", "remote: error: GH006: Protected branch update failed for refs/heads/master.\nremote: error: Changes must be made through a pull request.", ErrCodeProtectionPullRequest},
+		{"command error on refs/heads/master: protected branch hook declined", "remote: error: Commits must have verified signatures.", ErrCodeProtectionSignedCommits},
+		{"command error on refs/heads/master: protected branch hook declined", "", ErrCodeProtectedBranch},
+	} {
+		err := branchProtectionError(errors.New(c.err), c.output)
+		require.Equal(t, c.code, errorCodeOf(err), c.err)
+		require.Contains(t, describeError(err), "Hint: ")
+	}
+
+	// The other errors are kept as is
+	err := errors.New("Could not resolve to a node with the global id of 'PR'")
+	require.Equal(t, err, branchProtectionError(err, ""))
+	require.NoError(t, branchProtectionError(nil, "remote: error: Changes must be made through a pull request."))
+
+	// The codes of the branch protection take precedence over the generic ones of git
+	require.Equal(t, ErrCodeProtectionPullRequest, errorCodeOf(gitError(branchProtectionError(errors.New("protected branch hook declined"), "Changes must be made through a pull request"))))
+}
+
+func TestParseMergeMethod(t *testing.T) {
+	m, err := parseMergeMethod("")
+	require.NoError(t, err)
+	require.Equal(t, githubv4.PullRequestMergeMethodMerge, m)
+	m, err = parseMergeMethod("squash")
+	require.NoError(t, err)
+	require.Equal(t, githubv4.PullRequestMergeMethodSquash, m)
+	_, err = parseMergeMethod("fast-forward")
+	require.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// The time zone database is embedded as the container image may not have one
+	_ "time/tzdata"
+)
+
+// BusinessCalendar is the time zone and the holidays of a project.
+//
+// Anything shown or decided by the time of the day, like the times in the status and autodeploy log commands,
+// honors the time zone of the project instead of the one of the server, which is usually UTC in a container.
+type BusinessCalendar struct {
+	Location *time.Location
+	// builtin is the name of the builtin holiday calendar, like jp for the Japanese public holidays.
+	builtin string
+	// holidays are the extra holidays in the YYYY-MM-DD format, like company holidays.
+	holidays map[string]bool
+}
+
+// holidayCalendars are the builtin holiday calendars, which return true if the date is a holiday.
+var holidayCalendars = map[string]func(time.Time) bool{
+	"jp": isJapaneseHoliday,
+}
+
+// NewBusinessCalendar returns the calendar in the time zone, like Asia/Tokyo, with the builtin holiday calendar and the extra holidays.
+// The server's local time zone is used if timeZone is empty.
+func NewBusinessCalendar(timeZone string, builtin string, holidays []string) (BusinessCalendar, error) {
+	c := BusinessCalendar{Location: time.Local, builtin: builtin, holidays: map[string]bool{}}
+	if timeZone != "" {
+		loc, err := time.LoadLocation(timeZone)
+		if err != nil {
+			return c, fmt.Errorf("unknown time zone %q: %w", timeZone, err)
+		}
+		c.Location = loc
+	}
+	if _, ok := holidayCalendars[builtin]; builtin != "" && !ok {
+		return c, fmt.Errorf("unknown holiday calendar %q", builtin)
+	}
+	for _, h := range holidays {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", h); err != nil {
+			return c, fmt.Errorf("invalid holiday %q: %w", h, err)
+		}
+		c.holidays[h] = true
+	}
+	return c, nil
+}
+
+// In returns t in the time zone of the calendar.
+func (c BusinessCalendar) In(t time.Time) time.Time {
+	if c.Location == nil {
+		return t
+	}
+	return t.In(c.Location)
+}
+
+// Format formats t in the time zone of the calendar, with the name of the time zone like JST.
+func (c BusinessCalendar) Format(t time.Time, layout string) string {
+	return c.In(t).Format(layout + " MST")
+}
+
+// IsHoliday returns true if the date of t in the time zone of the calendar is a holiday.
+func (c BusinessCalendar) IsHoliday(t time.Time) bool {
+	t = c.In(t)
+	if c.holidays[t.Format("2006-01-02")] {
+		return true
+	}
+	if f, ok := holidayCalendars[c.builtin]; ok {
+		return f(t)
+	}
+	return false
+}
+
+// IsBusinessDay returns true if the date of t in the time zone of the calendar is neither a weekend nor a holiday.
+func (c BusinessCalendar) IsBusinessDay(t time.Time) bool {
+	t = c.In(t)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	return !c.IsHoliday(t)
+}
+
+// isJapaneseHoliday returns true if the date of t is a Japanese public holiday,
+// including the substitute holidays and the citizens' holidays, under the rules in effect since 2020.
+func isJapaneseHoliday(t time.Time) bool {
+	y, m, d := t.Date()
+	date := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if isJapaneseNationalHoliday(date) {
+		return true
+	}
+	// A substitute holiday is the first non-holiday after a holiday on Sunday
+	for prev := date.AddDate(0, 0, -1); isJapaneseNationalHoliday(prev); prev = prev.AddDate(0, 0, -1) {
+		if prev.Weekday() == time.Sunday {
+			return true
+		}
+	}
+	// A citizens' holiday is a day between two holidays
+	return date.Weekday() != time.Sunday && isJapaneseNationalHoliday(date.AddDate(0, 0, -1)) && isJapaneseNationalHoliday(date.AddDate(0, 0, 1))
+}
+
+// isJapaneseNationalHoliday returns true if the date is one of the holidays defined by the Act on National Holidays.
+func isJapaneseNationalHoliday(date time.Time) bool {
+	y, m, d := date.Date()
+	// nthMonday returns true if the date is the nth Monday of the month
+	nthMonday := func(n int) bool {
+		return date.Weekday() == time.Monday && (d-1)/7+1 == n
+	}
+	switch m {
+	case time.January:
+		return d == 1 || nthMonday(2)
+	case time.February:
+		return d == 11 || d == 23
+	case time.March:
+		return d == equinoxDay(y, 20.8431)
+	case time.April:
+		return d == 29
+	case time.May:
+		return d == 3 || d == 4 || d == 5
+	case time.July:
+		return nthMonday(3)
+	case time.August:
+		return d == 11
+	case time.September:
+		return nthMonday(3) || d == equinoxDay(y, 23.2488)
+	case time.October:
+		return nthMonday(2)
+	case time.November:
+		return d == 3 || d == 23
+	}
+	return false
+}
+
+// equinoxDay approximates the day of the vernal or autumnal equinox of the year, which is valid from 1980 to 2099.
+func equinoxDay(year int, base float64) int {
+	return int(base+0.242194*float64(year-1980)) - (year-1980)/4
+}
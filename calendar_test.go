@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusinessCalendar(t *testing.T) {
+	c, err := NewBusinessCalendar("Asia/Tokyo", "jp", []string{"2024-12-30", ""})
+	require.NoError(t, err)
+
+	holidays := []string{
+		"2024-01-01", // New Year's Day
+		"2024-01-08", // Coming of Age Day
+		"2024-02-12", // Substitute holiday for the National Foundation Day on Sunday
+		"2024-03-20", // Vernal Equinox Day
+		"2024-05-06", // Substitute holiday for the Children's Day on Sunday
+		"2024-09-23", // Substitute holiday for the Autumnal Equinox Day on Sunday
+		"2024-10-14", // Sports Day
+		"2026-09-22", // Citizens' holiday between the Respect for the Aged Day and the Autumnal Equinox Day
+		"2024-12-30", // Extra holiday
+	}
+	for _, d := range holidays {
+		date, err := time.ParseInLocation("2006-01-02", d, c.Location)
+		require.NoError(t, err)
+		require.True(t, c.IsHoliday(date), d)
+		require.False(t, c.IsBusinessDay(date), d)
+	}
+
+	for _, d := range []string{"2024-01-09", "2024-05-07", "2024-09-24"} {
+		date, err := time.ParseInLocation("2006-01-02", d, c.Location)
+		require.NoError(t, err)
+		require.True(t, c.IsBusinessDay(date), d)
+	}
+
+	// 2024-01-01 is already a holiday in Tokyo at 15:00 UTC on 2023-12-31
+	require.True(t, c.IsHoliday(time.Date(2023, 12, 31, 15, 0, 0, 0, time.UTC)))
+	require.Equal(t, "2024-01-01 00:00 JST", c.Format(time.Date(2023, 12, 31, 15, 0, 0, 0, time.UTC), "2006-01-02 15:04"))
+
+	_, err = NewBusinessCalendar("Mars/Olympus", "", nil)
+	require.Error(t, err)
+	_, err = NewBusinessCalendar("", "us", nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/slackcmd"
+)
+
+// deployCoordinatorConfigMapName is the name of the configmap the deploy.Coordinator stores
+// the lock and pin state of each project and phase in.
+const deployCoordinatorConfigMapName = "gocat-deploy"
+
+// runCommand runs the command parsed by slackcmd.Parse and returns the blocks to post as the result.
+// channel is the channel the command is run in, which the commands uploading files upload them to.
+func (s *SlackListener) runCommand(cmd slackcmd.Command, userID string, channel string) ([]slack.Block, error) {
+	ctx := context.Background()
+	if readOnlyCommand(cmd) {
+		if err := s.checkViewer(userID); err != nil {
+			return nil, err
+		}
+	}
+	switch c := cmd.(type) {
+	case *slackcmd.Lock:
+		pj, phase, err := s.commandTarget(c.Project, c.Env, userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.coordinator.Lock(ctx, pj.ID, phase, userID, c.Reason); err != nil {
+			return nil, err
+		}
+		return plainBlocks(fmt.Sprintf("Locked *%s* *%s* for %s", pj.ID, phase, c.Reason)), nil
+	case *slackcmd.Unlock:
+		pj, phase, err := s.commandTarget(c.Project, c.Env, userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.coordinator.Unlock(ctx, pj.ID, phase, userID, false); err != nil {
+			return nil, err
+		}
+		return plainBlocks(fmt.Sprintf("Unlocked *%s* *%s*", pj.ID, phase)), nil
+	case *slackcmd.Pin:
+		pj, phase, err := s.commandTarget(c.Project, c.Env, userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.coordinator.Pin(ctx, pj.ID, phase, userID, c.Tag, c.Reason); err != nil {
+			return nil, err
+		}
+		return plainBlocks(fmt.Sprintf("Pinned *%s* *%s* to `%s`. AutoDeploy and deploys are blocked until `unpin %s %s`.", pj.ID, phase, c.Tag, pj.ID, phase)), nil
+	case *slackcmd.Unpin:
+		pj, phase, err := s.commandTarget(c.Project, c.Env, userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.coordinator.Unpin(ctx, pj.ID, phase); err != nil {
+			return nil, err
+		}
+		return plainBlocks(fmt.Sprintf("Unpinned *%s* *%s*", pj.ID, phase)), nil
+	case *slackcmd.Status:
+		return s.status(ctx, c)
+	case *slackcmd.EmergencyStop:
+		if err := s.checkAdmin(userID); err != nil {
+			return nil, err
+		}
+		if err := s.coordinator.EmergencyStop(ctx, userID, c.Reason); err != nil {
+			return nil, err
+		}
+		text := fmt.Sprintf(":rotating_light: All deploys are stopped by <@%s>", userID)
+		if c.Reason != "" {
+			text += " for " + c.Reason
+		}
+		text += ". AutoDeploy and deploy commands are blocked until `emergency-resume`."
+		s.broadcast(text)
+		return plainBlocks(text), nil
+	case *slackcmd.EmergencyResume:
+		if err := s.checkAdmin(userID); err != nil {
+			return nil, err
+		}
+		if err := s.coordinator.Resume(ctx); err != nil {
+			return nil, err
+		}
+		text := fmt.Sprintf(":white_check_mark: Deploys are resumed by <@%s>", userID)
+		s.broadcast(text)
+		return plainBlocks(text), nil
+	case *slackcmd.RateLimit:
+		if err := s.checkAdmin(userID); err != nil {
+			return nil, err
+		}
+		return plainBlocks(describeGitHubRateLimits(s.github.RateLimits())), nil
+	case *slackcmd.Queue:
+		return s.queue(userID), nil
+	case *slackcmd.ProjectAdd:
+		if err := s.checkAdmin(userID); err != nil {
+			return nil, err
+		}
+		return projectAddBlocks(), nil
+	case *slackcmd.RunJob:
+		return s.runJob(c, userID, channel)
+	case *slackcmd.Switch:
+		return s.switchColor(c, userID)
+	case *slackcmd.EnvSet:
+		return s.setEnv(c, userID)
+	case *slackcmd.RotateSecret:
+		return s.rotateSecret(c, userID)
+	case *slackcmd.Explain:
+		pj, err := s.projectList.FindByAlias(c.Project)
+		if err != nil {
+			return nil, err
+		}
+		phase := s.toPhase(c.Env)
+		ph := pj.FindPhase(phase)
+		if ph.Name == "" {
+			return nil, fmt.Errorf("phase %s not found for project %s", phase, pj.ID)
+		}
+		return plainBlocks(explainDeploy(pj, ph, s.github)), nil
+	case *slackcmd.Trace:
+		trace, ok := s.tracer.Get(c.ID)
+		if !ok {
+			return nil, fmt.Errorf("trace %s not found. Traces are kept for the latest %d deploys since gocat started", c.ID, maxDeployTraces)
+		}
+		return plainBlocks(trace.Format(s.projectList.Find(trace.Project).Calendar())), nil
+	case *slackcmd.History:
+		return s.history(c)
+	case *slackcmd.Diff:
+		return s.diff(c)
+	case *slackcmd.Prefs:
+		return s.preferences(ctx, c, userID)
+	case *slackcmd.Rollback:
+		pj, phase, err := s.commandTarget(c.Project, c.Env, userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkDeployable(s.coordinator, pj.ID, phase); err != nil {
+			return nil, err
+		}
+		if ph := pj.FindPhase(phase); ph.Name == "" || ph.Kind != "kustomize" {
+			return nil, fmt.Errorf("only the phases of Kustomize can be rolled back, and %s %s isn't", pj.ID, phase)
+		}
+		return rollbackBlocks(pj, phase), nil
+	case *slackcmd.Redeploy:
+		return s.redeploy(c, userID)
+	case *slackcmd.Retry:
+		return s.retry(c, userID, channel)
+	case *slackcmd.Features:
+		if err := s.checkAdmin(userID); err != nil {
+			return nil, err
+		}
+		if c.Project == "" {
+			return plainBlocks(describeFeatures(s.projectList.FeatureFlags(), s.projectList.All(), nil)), nil
+		}
+		pj, err := s.projectList.FindByAlias(c.Project)
+		if err != nil {
+			return nil, err
+		}
+		return plainBlocks(describeFeatures(s.projectList.FeatureFlags(), nil, &pj)), nil
+	case *slackcmd.ConfigExport:
+		if err := s.checkAdmin(userID); err != nil {
+			return nil, err
+		}
+		return s.exportConfig(ctx, userID)
+	case *slackcmd.ConfigImport:
+		if err := s.checkAdmin(userID); err != nil {
+			return nil, err
+		}
+		return s.importConfig(ctx, c.Path, c.Apply, channel)
+	case *slackcmd.SimulateAutoDeploy:
+		pj, err := s.projectList.FindByAlias(c.Project)
+		if err != nil {
+			return nil, err
+		}
+		phase := s.toPhase(c.Env)
+		ph := pj.FindPhase(phase)
+		if ph.Name == "" {
+			return nil, fmt.Errorf("phase %s not found for project %s", phase, pj.ID)
+		}
+		return plainBlocks(s.autoDeploy.Simulate(pj, ph)), nil
+	case *slackcmd.AutoDeployLog:
+		pj, err := s.projectList.FindByAlias(c.Project)
+		if err != nil {
+			return nil, err
+		}
+		return plainBlocks(s.autoDeployHistory.Describe(pj.ID, s.toPhase(c.Env), pj.Calendar())), nil
+	default:
+		return nil, fmt.Errorf("unsupported command: %s", cmd.Name())
+	}
+}
+
+// runJob runs the job of the phase, and reports the result of the Job to the channel when it's applied directly.
+func (s *SlackListener) runJob(c *slackcmd.RunJob, userID string, channel string) ([]slack.Block, error) {
+	pj, phase, err := s.commandTarget(c.Project, c.Env, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDeployable(s.coordinator, pj.ID, phase); err != nil {
+		return nil, err
+	}
+	ph := pj.FindPhase(phase)
+	job, ok := ph.FindJob(c.Job)
+	if !ok {
+		var names []string
+		for _, j := range ph.Jobs {
+			names = append(names, j.Name)
+		}
+		return nil, fmt.Errorf("job %s is not found in %s %s. Available jobs: %s", c.Job, pj.ID, phase, strings.Join(names, ", "))
+	}
+
+	o, err := s.jobRunner.Run(pj, ph, job, c.Tag)
+	if err != nil {
+		return nil, err
+	}
+	if o.CommitSHA != "" {
+		return plainBlocks(fmt.Sprintf("<@%s> committed the job *%s* of *%s* *%s* with `%s` to %s\nhttps://github.com/%s/%s/commit/%s", userID, job.Name, pj.ID, phase, o.Tag, o.Path, s.github.org, s.github.repo, o.CommitSHA)), nil
+	}
+
+	go func() {
+		text := fmt.Sprintf(":white_check_mark: Job %s/%s succeeded", o.Namespace, o.Name)
+		if err := NewModelJob(s.github).Watch(o.Name, o.Namespace); err != nil {
+			text = fmt.Sprintf(":x: Job %s/%s failed: %s", o.Namespace, o.Name, err)
+		}
+		if _, _, err := s.client.PostMessage(channel, slack.MsgOptionText(text, false)); err != nil {
+			log.Printf("[ERROR] Failed to post the result of the job %s/%s: %s", o.Namespace, o.Name, err)
+		}
+	}()
+	return plainBlocks(fmt.Sprintf("<@%s> created the job *%s* of *%s* *%s* with `%s` as %s/%s", userID, job.Name, pj.ID, phase, o.Tag, o.Namespace, o.Name)), nil
+}
+
+// switchColor opens the pull request putting the idle color of the blue/green phase into service, and returns the message to approve it.
+func (s *SlackListener) switchColor(c *slackcmd.Switch, userID string) ([]slack.Block, error) {
+	pj, phase, err := s.commandTarget(c.Project, c.Env, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDeployable(s.coordinator, pj.ID, phase); err != nil {
+		return nil, err
+	}
+	ph := pj.FindPhase(phase)
+	if !ph.BlueGreen.Enabled() {
+		return nil, fmt.Errorf("%s %s is not a blue/green phase", pj.ID, phase)
+	}
+
+	o, err := s.blueGreen.Switch(pj, ph, s.userList.FindBySlackUserID(userID))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] The switch of %s %s from %s to %s is requested by %s", pj.ID, phase, o.From, o.To, userID)
+	return blueGreenSwitchBlocks(s.github, userID, pj, phase, o), nil
+}
+
+// rotateSecret opens the pull request rotating the secret, and returns the message to approve it.
+func (s *SlackListener) rotateSecret(c *slackcmd.RotateSecret, userID string) ([]slack.Block, error) {
+	pj, phase, err := s.commandTarget(c.Project, c.Env, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDeployable(s.coordinator, pj.ID, phase); err != nil {
+		return nil, err
+	}
+	ph := pj.FindPhase(phase)
+	secret, ok := ph.FindSecret(c.Secret)
+	if !ok {
+		var names []string
+		for _, secret := range ph.Secrets {
+			names = append(names, secret.Name)
+		}
+		return nil, fmt.Errorf("secret %s is not found in %s %s. Available secrets: %s", c.Secret, pj.ID, phase, strings.Join(names, ", "))
+	}
+
+	user := s.userList.FindBySlackUserID(userID)
+	o, err := s.secretRotator.Rotate(pj, ph, secret, user, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Rotation of the secret %s of %s %s is requested by %s", secret.Name, pj.ID, phase, userID)
+	return secretRotationBlocks(s.github, userID, pj, phase, secret, o), nil
+}
+
+// setEnv opens the pull request setting the environment value of the phase, and returns the message to approve it.
+func (s *SlackListener) setEnv(c *slackcmd.EnvSet, userID string) ([]slack.Block, error) {
+	pj, phase, err := s.commandTarget(c.Project, c.Env, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDeployable(s.coordinator, pj.ID, phase); err != nil {
+		return nil, err
+	}
+	ph := pj.FindPhase(phase)
+	if !ph.Env.Enabled() {
+		return nil, fmt.Errorf("env of %s %s is not configured", pj.ID, phase)
+	}
+	if !ph.Env.Editable(c.Key) {
+		return nil, fmt.Errorf("%s is not editable in %s %s. Editable keys: %s", c.Key, pj.ID, phase, strings.Join(ph.Env.Keys, ", "))
+	}
+
+	user := s.userList.FindBySlackUserID(userID)
+	o, err := s.envSetter.Set(pj, ph, c.Key, c.Value, user)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Setting %s of %s %s is requested by %s", c.Key, pj.ID, phase, userID)
+	return envSetBlocks(s.github, userID, pj, phase, c.Key, c.Value, o), nil
+}
+
+// preferences shows the preferences of the user, or sets the one of the command.
+func (s *SlackListener) preferences(ctx context.Context, c *slackcmd.Prefs, userID string) ([]slack.Block, error) {
+	p := s.prefs.Get(userID)
+	if c.Key == "" {
+		return plainBlocks(fmt.Sprintf("Preferences of <@%s>:\n%s", userID, p)), nil
+	}
+	if err := p.Set(c.Key, c.Value); err != nil {
+		return nil, err
+	}
+	if err := s.prefs.Save(ctx, userID, p); err != nil {
+		return nil, err
+	}
+	return plainBlocks(fmt.Sprintf("Updated the preferences of <@%s>:\n%s", userID, p)), nil
+}
+
+// redeploy opens the pull request replaying the archived deploy.
+func (s *SlackListener) redeploy(c *slackcmd.Redeploy, userID string) ([]slack.Block, error) {
+	d, err := s.replayer.archiver.Find(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	pj, phase, err := s.commandTarget(d.Project, d.Phase, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDeployable(s.coordinator, pj.ID, phase); err != nil {
+		return nil, err
+	}
+	ph := pj.FindPhase(phase)
+	if ph.Name == "" {
+		return nil, fmt.Errorf("phase %s not found for project %s", phase, pj.ID)
+	}
+	user := s.userList.FindBySlackUserID(userID)
+	trace := s.tracer.Start(pj.ID, phase, "redeploy of %s requested by <@%s>", d.TraceID, userID)
+	s.tracer.SetRequester(trace, userID)
+	o, err := s.replayer.Replay(pj, ph, d, user, trace)
+	if err != nil {
+		s.tracer.Emit(trace, DeployEventFailed, "failed to open the pull request of the redeploy: %s", err)
+		return nil, err
+	}
+	s.tracer.Emit(trace, DeployEventAwaitingApproval, "opened #%d for approval", o.PullRequestNumber)
+	log.Printf("[INFO] Redeploy of %s to %s %s is requested by %s", d.TraceID, pj.ID, phase, userID)
+	return deployReplayBlocks(s.github, userID, pj, phase, d, o), nil
+}
+
+// retry runs the failed stage of the deploy again, as the Retry button of its failure message does.
+func (s *SlackListener) retry(c *slackcmd.Retry, userID string, channel string) ([]slack.Block, error) {
+	if !s.userList.FindBySlackUserID(userID).IsDeveloper() {
+		return nil, fmt.Errorf("<@%s> is not allowed to run this command. Please contact admin.", userID)
+	}
+	return retryDeploy(s.tracer, s.interactorFactory, s.coordinator, c.ID, userID, channel)
+}
+
+// commandTarget resolves the project and the phase of a command that changes the deploy state,
+// which only developers are allowed to run.
+func (s *SlackListener) commandTarget(project, env, userID string) (DeployProject, string, error) {
+	if !s.userList.FindBySlackUserID(userID).IsDeveloper() {
+		return DeployProject{}, "", fmt.Errorf("<@%s> is not allowed to run this command. Please contact admin.", userID)
+	}
+	pj, err := s.projectList.FindByAlias(project)
+	if err != nil {
+		return DeployProject{}, "", err
+	}
+	return pj, s.toPhase(env), nil
+}
+
+// checkAdmin returns an error if the user isn't allowed to run the commands affecting all the projects.
+func (s *SlackListener) checkAdmin(userID string) error {
+	if !s.userList.FindBySlackUserID(userID).IsAdmin() {
+		return fmt.Errorf("<@%s> is not allowed to run this command. Please contact admin.", userID)
+	}
+	return nil
+}
+
+// readOnlyCommand returns true if the command only shows the state of the deploys, which the viewers are allowed to run.
+func readOnlyCommand(cmd slackcmd.Command) bool {
+	switch cmd.(type) {
+	case *slackcmd.Status, *slackcmd.History, *slackcmd.Diff, *slackcmd.Trace, *slackcmd.Explain, *slackcmd.Queue, *slackcmd.AutoDeployLog, *slackcmd.SimulateAutoDeploy:
+		return true
+	}
+	return false
+}
+
+// checkViewer returns an error if the read-only commands are restricted to the users with the roles, and the user has none.
+func (s *SlackListener) checkViewer(userID string) error {
+	if !s.restrictReadCommands || s.userList.FindBySlackUserID(userID).CanView() {
+		return nil
+	}
+	return fmt.Errorf("<@%s> is not allowed to run this command. Please contact admin.", userID)
+}
+
+// checkRequester returns an error if the user isn't allowed to request deploys. The viewers aren't, as their role is read-only,
+// and neither are the users without the roles if the read-only commands are restricted to the users with the roles.
+func (s *SlackListener) checkRequester(userID string) error {
+	user := s.userList.FindBySlackUserID(userID)
+	if user.IsDeveloper() || !(user.IsViewer() || s.restrictReadCommands) {
+		return nil
+	}
+	return fmt.Errorf("<@%s> is not allowed to deploy. Please contact admin.", userID)
+}
+
+// broadcast posts the text to the announcement channel, logging the failure
+// as the command itself has already succeeded.
+func (s *SlackListener) broadcast(text string) {
+	if err := s.announcer.Broadcast(text); err != nil {
+		log.Printf("[ERROR] Failed to broadcast to the announcement channel: %s", err)
+	}
+}
+
+func (s *SlackListener) status(ctx context.Context, c *slackcmd.Status) ([]slack.Block, error) {
+	pj, err := s.projectList.FindByAlias(c.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	var phases []string
+	if c.Env != "" {
+		phases = append(phases, s.toPhase(c.Env))
+	} else {
+		for _, phase := range pj.Phases {
+			phases = append(phases, phase.Name)
+		}
+	}
+
+	var lines []string
+	for _, phase := range phases {
+		value, err := s.coordinator.Status(ctx, pj.ID, phase)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("*%s* %s", phase, describeDeployState(value, pj.Calendar())))
+	}
+	return plainBlocks(fmt.Sprintf("*%s*\n%s", pj.ID, strings.Join(lines, "\n"))), nil
+}
+
+func describeDeployState(value deploy.ConfigMapValue, calendar BusinessCalendar) string {
+	var states []string
+	if value.Locked && len(value.History) > 0 {
+		last := value.History[len(value.History)-1]
+		states = append(states, fmt.Sprintf(":lock: locked by <@%s> for %s", last.User, last.Reason))
+	}
+	if value.Pin != nil {
+		pin := fmt.Sprintf(":pushpin: pinned to `%s` by <@%s> since %s", value.Pin.Tag, value.Pin.User, calendar.Format(value.Pin.At.Time, "2006-01-02 15:04"))
+		if value.Pin.Reason != "" {
+			pin += " for " + value.Pin.Reason
+		}
+		states = append(states, pin)
+	}
+	if len(states) == 0 {
+		return "no lock or pin"
+	}
+	return strings.Join(states, ", ")
+}
+
+// checkDeployable returns an error telling why the phase of the project can't be deployed now,
+// which is either because all deploys are stopped or the phase is pinned.
+func checkDeployable(coordinator *deploy.Coordinator, project, phase string) error {
+	if err := checkEmergencyStop(coordinator); err != nil {
+		return err
+	}
+	return checkPinned(coordinator, project, phase)
+}
+
+// checkEmergencyStop returns an error if all deploys are stopped by emergency-stop.
+// Deploys, manual or automatic, and approvals of pending deploys are blocked until emergency-resume.
+func checkEmergencyStop(coordinator *deploy.Coordinator) error {
+	if coordinator == nil {
+		return nil
+	}
+	state, err := coordinator.EmergencyStopStatus(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to check if deploys are stopped: %w", err)
+	}
+	if state != nil {
+		reason := ""
+		if state.Reason != "" {
+			reason = " for " + state.Reason
+		}
+		return fmt.Errorf("%w by <@%s> since %s%s. Wait for `emergency-resume` to deploy", deploy.ErrEmergencyStopped, state.User, state.At.Format("2006-01-02 15:04"), reason)
+	}
+	return nil
+}
+
+// checkPinned returns an error telling the phase of the project is pinned, if it is.
+// Deploys to a pinned phase, manual or automatic, are blocked until it's unpinned.
+func checkPinned(coordinator *deploy.Coordinator, project, phase string) error {
+	if coordinator == nil {
+		return nil
+	}
+	value, err := coordinator.Status(context.Background(), project, phase)
+	if err != nil {
+		return fmt.Errorf("unable to check if %s %s is pinned: %w", project, phase, err)
+	}
+	if value.Pin != nil {
+		return fmt.Errorf("%w: %s %s is pinned to %s by <@%s>. Run `unpin %s %s` to deploy", deploy.ErrPinned, project, phase, value.Pin.Tag, value.Pin.User, project, phase)
+	}
+	return nil
+}
+
+func plainBlocks(texts ...string) (blocks []slack.Block) {
+	for _, text := range texts {
+		block := slack.NewTextBlockObject("mrkdwn", text, false, false)
+		blocks = append(blocks, slack.NewSectionBlock(block, nil, nil))
+	}
+	return
+}
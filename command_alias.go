@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/zaiminc/gocat/slackcmd"
+)
+
+// commandAliasConfigMapType is the type of the configmaps defining the command aliases.
+// Each key of the data is the name of an alias, and the value is the command it expands to, like:
+//
+//	ship: deploy $1 production
+//	qa: deploy $1 staging branch
+const commandAliasConfigMapType = "command-alias"
+
+// CommandAliasList is the command aliases of the workspace, defined by admins in the command-alias configmaps.
+type CommandAliasList struct {
+	mu      sync.Mutex
+	aliases slackcmd.Aliases
+}
+
+func NewCommandAliasList() *CommandAliasList {
+	l := &CommandAliasList{}
+	l.Reload()
+	return l
+}
+
+func (l *CommandAliasList) Reload() {
+	aliases := slackcmd.Aliases{}
+	if cml := getConfigMapList(commandAliasConfigMapType); cml != nil {
+		for _, cm := range readableConfigMaps(commandAliasConfigMapType, cml.Items) {
+			for name, command := range cm.Data {
+				aliases[name] = command
+			}
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.aliases = aliases
+}
+
+// Expand expands the alias the text of the mention starts with. See slackcmd.Aliases.
+func (l *CommandAliasList) Expand(text string) (string, error) {
+	if l == nil {
+		return text, nil
+	}
+	l.mu.Lock()
+	aliases := l.aliases
+	l.mu.Unlock()
+	return aliases.Expand(text)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultHookCommandTimeout is how long a command hook may run unless its timeout is set.
+const defaultHookCommandTimeout = 10 * time.Minute
+
+// hookOutputLines is the number of the last lines of the output of a command hook posted to Slack.
+const hookOutputLines = 30
+
+// hookNamePattern is the pattern of the names of the command hooks, which are DNS labels.
+// They're at most 46 characters long, so that the names of their Jobs, gocat-hook-<name>-<random>, are at most 63.
+var hookNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,44}[a-z0-9])?$`)
+
+// HookCommand is a command run before or after the deploys of a phase, for the steps no builtin feature covers,
+// like warming up a cache or running a smoke test script.
+//
+// It runs in a sandboxed Kubernetes Job in CONFIG_HOOK_NAMESPACE, not in gocat, so that it can't reach the credentials of gocat:
+// the Job has no service account token, runs as non-root with a read-only root filesystem and no capabilities, and is killed at its timeout.
+type HookCommand struct {
+	// Name names the Job, so it's a DNS label like smoke-test.
+	Name string `yaml:"name"`
+	// Image is the image the command runs in, which must start with one of CONFIG_HOOK_ALLOWED_IMAGES.
+	// Defaults to the image of the phase with the tag deployed, which is always allowed.
+	Image string `yaml:"image"`
+	// Command is the command and its arguments, like ["sh", "-c", "./smoke-test.sh"].
+	// GOCAT_EVENT, GOCAT_PROJECT, GOCAT_PHASE, GOCAT_BRANCH, GOCAT_PREVIOUS_TAG, GOCAT_TAG, and GOCAT_TRACE_ID are set in its environment.
+	Command []string `yaml:"command"`
+	// Timeout defaults to 10m.
+	Timeout string `yaml:"timeout"`
+}
+
+func (c HookCommand) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("a command has no name")
+	}
+	if !hookNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("the name of the command %s must be a DNS label of at most 46 characters, like smoke-test", c.Name)
+	}
+	if len(c.Command) == 0 {
+		return fmt.Errorf("the command %s has no command", c.Name)
+	}
+	if _, err := time.ParseDuration(c.Timeout); c.Timeout != "" && err != nil {
+		return fmt.Errorf("invalid timeout of the command %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+func (c HookCommand) timeout() time.Duration {
+	if d := parseOptionalDuration(c.Timeout); d > 0 {
+		return d
+	}
+	return defaultHookCommandTimeout
+}
+
+// CommandHookRunner runs the command hooks of the phases as Kubernetes Jobs.
+//
+// RunAll is safe to call on nil, which runs nothing.
+type CommandHookRunner struct {
+	// mu guards clientset, which is created on the first command run, as the hooks of the deploys run concurrently.
+	mu        sync.Mutex
+	clientset kubernetes.Interface
+	namespace string
+	// allowedImages are the prefixes of the images, like 123.dkr.ecr.ap-northeast-1.amazonaws.com/tools/, the commands can run in.
+	allowedImages []string
+	// interval is the interval the Jobs are polled at.
+	interval time.Duration
+}
+
+func NewCommandHookRunner(config CatConfig) *CommandHookRunner {
+	return &CommandHookRunner{namespace: config.HookNamespace, allowedImages: config.HookAllowedImages, interval: 5 * time.Second}
+}
+
+func (r *CommandHookRunner) client() (kubernetes.Interface, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clientset != nil {
+		return r.clientset, nil
+	}
+	client, err := newKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	r.clientset = client
+	return client, nil
+}
+
+// image returns the image the command runs in, or an error if it isn't allowed.
+func (r *CommandHookRunner) image(pj DeployProject, phase DeployPhase, c HookCommand, tag string) (string, error) {
+	if c.Image == "" {
+		return pj.ImageTagQuery(phase, ImageTagVars{}).Image + ":" + tag, nil
+	}
+	for _, prefix := range r.allowedImages {
+		if prefix != "" && strings.HasPrefix(c.Image, prefix) {
+			return c.Image, nil
+		}
+	}
+	return "", fmt.Errorf("the image %s of the command %s isn't allowed by CONFIG_HOOK_ALLOWED_IMAGES", c.Image, c.Name)
+}
+
+// hookJob returns the sandboxed Job running the command.
+func hookJob(namespace, name, image string, c HookCommand, vars WebhookVars) *batchv1.Job {
+	no, yes := false, true
+	backoffLimit, ttl := int32(0), int32(3600)
+	deadline := int64(c.timeout().Seconds())
+	env := []v1.EnvVar{
+		{Name: "GOCAT_EVENT", Value: vars.Event},
+		{Name: "GOCAT_PROJECT", Value: vars.Project},
+		{Name: "GOCAT_PHASE", Value: vars.Phase},
+		{Name: "GOCAT_BRANCH", Value: vars.Branch},
+		{Name: "GOCAT_PREVIOUS_TAG", Value: vars.PreviousTag},
+		{Name: "GOCAT_TAG", Value: vars.Tag},
+		{Name: "GOCAT_TRACE_ID", Value: vars.TraceID},
+	}
+	labels := map[string]string{"app.kubernetes.io/managed-by": "gocat", "gocat/project": vars.Project, "gocat/hook": c.Name}
+	return &batchv1.Job{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: meta_v1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					RestartPolicy:                v1.RestartPolicyNever,
+					AutomountServiceAccountToken: &no,
+					EnableServiceLinks:           &no,
+					SecurityContext: &v1.PodSecurityContext{
+						RunAsNonRoot:   &yes,
+						SeccompProfile: &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+					},
+					Containers: []v1.Container{{
+						Name:    "hook",
+						Image:   image,
+						Command: c.Command,
+						Env:     env,
+						Resources: v1.ResourceRequirements{
+							Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m"), v1.ResourceMemory: resource.MustParse("512Mi")},
+						},
+						SecurityContext: &v1.SecurityContext{
+							AllowPrivilegeEscalation: &no,
+							ReadOnlyRootFilesystem:   &yes,
+							Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// Run runs the command in a Job and waits for it to finish, returning the last lines of its output.
+func (r *CommandHookRunner) Run(pj DeployProject, phase DeployPhase, c HookCommand, vars WebhookVars) (string, error) {
+	image, err := r.image(pj, phase, c, vars.Tag)
+	if err != nil {
+		return "", err
+	}
+	client, err := r.client()
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	name := fmt.Sprintf("gocat-hook-%s-%s", c.Name, RandString(5))
+	job, err := client.BatchV1().Jobs(r.namespace).Create(ctx, hookJob(r.namespace, name, image, c, vars), meta_v1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to create the job of the command %s: %w", c.Name, err)
+	}
+
+	// The Job fails by itself at its active deadline, which this waits a little longer for
+	deadline := time.Now().Add(c.timeout() + time.Minute)
+	for job.Status.Succeeded == 0 && job.Status.Failed == 0 {
+		if time.Now().After(deadline) {
+			return r.output(ctx, client, name), fmt.Errorf("the job %s of the command %s timed out", name, c.Name)
+		}
+		time.Sleep(r.interval)
+		job, err = client.BatchV1().Jobs(r.namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("unable to get the job %s of the command %s: %w", name, c.Name, err)
+		}
+	}
+	output := r.output(ctx, client, name)
+	if job.Status.Failed > 0 {
+		return output, fmt.Errorf("the job %s of the command %s failed", name, c.Name)
+	}
+	return output, nil
+}
+
+// output returns the last lines of the logs of the pod of the Job, or an empty string if they can't be read.
+func (r *CommandHookRunner) output(ctx context.Context, client kubernetes.Interface, job string) string {
+	pods, err := client.CoreV1().Pods(r.namespace).List(ctx, meta_v1.ListOptions{LabelSelector: "job-name=" + job})
+	if err != nil || len(pods.Items) == 0 {
+		return ""
+	}
+	lines := int64(hookOutputLines)
+	logs, err := client.CoreV1().Pods(r.namespace).GetLogs(pods.Items[0].Name, &v1.PodLogOptions{TailLines: &lines}).Stream(ctx)
+	if err != nil {
+		log.Printf("[WARNING] Unable to read the logs of the job %s: %s", job, err)
+		return ""
+	}
+	defer logs.Close()
+	b, _ := io.ReadAll(io.LimitReader(logs, 1<<16))
+	return strings.TrimSpace(string(b))
+}
+
+// RunAll runs the commands in order, reporting the output of each, and returns the error of the first failing one.
+// The failure of a preDeploy command is ErrPreDeployHookFailed.
+func (r *CommandHookRunner) RunAll(pj DeployProject, phase DeployPhase, commands []HookCommand, vars WebhookVars, report func(text string)) error {
+	if r == nil {
+		return nil
+	}
+	for _, c := range commands {
+		output, err := r.Run(pj, phase, c, vars)
+		if output != "" {
+			output = "\n```\n" + output + "\n```"
+		}
+		if err != nil {
+			report(fmt.Sprintf(":x: The %s command %s of %s %s failed: %s%s", vars.Event, c.Name, pj.ID, phase.Name, err, output))
+			if vars.Event == "preDeploy" {
+				return fmt.Errorf("%w: %s. The deploy is aborted", ErrPreDeployHookFailed, err)
+			}
+			return err
+		}
+		report(fmt.Sprintf(":white_check_mark: The %s command %s of %s %s succeeded%s", vars.Event, c.Name, pj.ID, phase.Name, output))
+	}
+	return nil
+}
+
+// CommandHook returns a PostDeployHook that runs the postDeploy commands of the phase, if any,
+// and posts their output to the Slack thread the deploy was requested in, by the bot of the workspace of the project.
+func CommandHook(client *slack.Client, workspaces *SlackWorkspaces, projectList *ProjectList, runner *CommandHookRunner) PostDeployHook {
+	return func(m DeployMetadata, prURL string) error {
+		pj := projectList.Find(m.Project)
+		phase := pj.FindPhase(m.Phase)
+		if len(phase.Hooks.PostDeployCommands) == 0 {
+			return nil
+		}
+		return runner.RunAll(pj, phase, phase.Hooks.PostDeployCommands, newWebhookVars("postDeploy", m), threadReporter(workspaces.ClientFor(pj, client), m))
+	}
+}
+
+// threadReporter returns the reporter posting to the Slack thread the deploy was requested in, which only logs if there's none.
+func threadReporter(client *slack.Client, m DeployMetadata) func(text string) {
+	return func(text string) {
+		log.Printf("[INFO] %s", text)
+		if client == nil || m.SlackChannel == "" {
+			return
+		}
+		if _, _, err := client.PostMessage(m.SlackChannel, slack.MsgOptionText(text, false), slack.MsgOptionTS(m.SlackThreadTS)); err != nil {
+			log.Printf("[ERROR] Failed to post the output of the hook of %s %s: %s", m.Project, m.Phase, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCommandHookRunner_RunAll(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var created string
+	// The Jobs finish as soon as they're created, succeeding unless they run false
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		if job.Spec.Template.Spec.Containers[0].Command[0] == "false" {
+			job.Status.Failed = 1
+		} else {
+			job.Status.Succeeded = 1
+		}
+		created = job.Name
+		return false, nil, nil
+	})
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Name: created + "-abcde", Labels: map[string]string{"job-name": created}}}
+		return true, &v1.PodList{Items: []v1.Pod{pod}}, nil
+	})
+	r := &CommandHookRunner{clientset: clientset, namespace: "hooks", allowedImages: []string{"registry/tools/"}}
+
+	pj := DeployProject{ID: "myapp", dockerRegistry: "registry/myapp"}
+	phase := DeployPhase{Name: "production"}
+	vars := WebhookVars{Event: "preDeploy", Project: "myapp", Phase: "production", Tag: "v1.2.0"}
+	var reports []string
+	report := func(text string) { reports = append(reports, text) }
+
+	smoke := HookCommand{Name: "smoke", Command: []string{"./smoke-test.sh"}}
+	require.NoError(t, r.RunAll(pj, phase, []HookCommand{smoke}, vars, report))
+	require.Len(t, reports, 1)
+	require.True(t, strings.HasPrefix(reports[0], ":white_check_mark: The preDeploy command smoke of myapp production succeeded\n```\nfake logs"), reports[0])
+
+	jobs, err := clientset.BatchV1().Jobs("hooks").List(context.Background(), meta_v1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, jobs.Items, 1)
+	spec := jobs.Items[0].Spec.Template.Spec
+	require.Equal(t, "registry/myapp:v1.2.0", spec.Containers[0].Image)
+	require.False(t, *spec.AutomountServiceAccountToken)
+	require.True(t, *spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem)
+	require.Contains(t, spec.Containers[0].Env, v1.EnvVar{Name: "GOCAT_TAG", Value: "v1.2.0"})
+
+	failing := HookCommand{Name: "check", Image: "registry/tools/check:1", Command: []string{"false"}}
+	err = r.RunAll(pj, phase, []HookCommand{failing, smoke}, vars, report)
+	require.True(t, errors.Is(err, ErrPreDeployHookFailed))
+	require.Len(t, reports, 2)
+
+	// The images not allowed aren't run
+	untrusted := HookCommand{Name: "untrusted", Image: "docker.io/someone/tool", Command: []string{"sh"}}
+	err = r.RunAll(pj, phase, []HookCommand{untrusted}, vars, report)
+	require.ErrorContains(t, err, "isn't allowed by CONFIG_HOOK_ALLOWED_IMAGES")
+
+	var nilRunner *CommandHookRunner
+	require.NoError(t, nilRunner.RunAll(pj, phase, []HookCommand{smoke}, vars, report))
+	require.Error(t, PhaseHooks{PreDeployCommands: []HookCommand{{Name: "empty"}}}.validate())
+
+	// The names make the names of the Jobs
+	require.NoError(t, HookCommand{Name: "smoke-test", Command: []string{"sh"}}.validate())
+	for _, name := range []string{"Smoke_Test", "smoke-", "smoke.test", strings.Repeat("a", 47)} {
+		require.Error(t, HookCommand{Name: name, Command: []string{"sh"}}.validate(), name)
+	}
+}
@@ -3,24 +3,91 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 type CatConfig struct {
-	ManifestRepository     string
-	ManifestRepositoryName string
-	ManifestRepositoryOrg  string
-	GitHubUserName         string // optional (default: gocat)
-	GitHubAccessToken      string
-	GitHubDefaultBranch    string
-	SlackOAuthToken        string
-	SlackVerificationToken string
-	JenkinsHost            string
-	JenkinsBotToken        string
-	JenkinsJobToken        string
-	ArgoCDHost             string
-	EnableAutoDeploy       bool // optional (default: false)
+	ManifestRepository      string
+	ManifestRepositoryName  string
+	ManifestRepositoryOrg   string
+	GitHubUserName          string // optional (default: gocat)
+	GitHubAccessToken       string
+	GitHubDefaultBranch     string
+	GitHubMergeMethod       githubv4.PullRequestMergeMethod // optional (default: merge)
+	ManifestForkRepository  string                          // optional (default: empty, which pushes the deploy branches to ManifestRepository)
+	ManifestRemoteName      string                          // optional (default: origin)
+	ManifestMirror          string                          // optional (default: empty, which pushes to ManifestRepository only)
+	SlackOAuthToken         string
+	SlackVerificationToken  string
+	JenkinsHost             string
+	JenkinsBotToken         string
+	JenkinsJobToken         string
+	ArgoCDHost              string
+	EnableAutoDeploy        bool // optional (default: false)
+	EnableSparseCheckout    bool // optional (default: false)
+	EnableStalenessWatcher  bool // optional (default: false)
+	RestrictReadCommands    bool // optional (default: false)
+	EnableGitHubUserSync    bool // optional (default: false)
+	EnableRolloutPreview    bool // optional (default: false)
+	EnableDeployPipeline    bool // optional (default: false)
+	GitRoot                 string
+	GitRootQuota            int64                  // optional (default: 0, which means unlimited)
+	GitStorage              string                 // optional (default: auto)
+	MemFSMaxRepoSize        int64                  // optional (default: 256Mi, 0 disables the guard)
+	GitHubWebhookSecret     string                 // optional (default: empty, which disables the GitHub webhook endpoint)
+	ApprovalReaction        string                 // optional (default: empty, which disables approval by reactions)
+	DeployRequestExpiry     time.Duration          // optional (default: 0, which disables the expiry)
+	ListRefreshInterval     time.Duration          // optional (default: 5m, 0 disables the refresh in the background)
+	OutboundTimeout         time.Duration          // optional (default: 0, which keeps the timeouts of Go)
+	APITimeout              time.Duration          // optional (default: 1m, 0 disables the deadline)
+	APIRetries              int                    // optional (default: 2)
+	FeatureFlags            FeatureFlags           // optional (default: empty, which enables no feature)
+	EphemeralResponses      bool                   // optional (default: true)
+	AnnouncementChannel     string                 // optional (default: empty, which disables announcements)
+	AdminChannel            string                 // optional (default: empty, which disables the config diffs on reload)
+	LeaderElectionLease     string                 // optional (default: empty, which makes every replica the leader)
+	ConfigAPIToken          string                 // optional (default: empty, which disables the config API endpoint)
+	ErrorReportingDSN       string                 // optional (default: empty, which disables the error reporting)
+	SlackWorkspaceName      string                 // optional (default: default)
+	SlackWorkspaces         []SlackWorkspaceConfig // optional (default: empty, which installs gocat to the workspace of SlackOAuthToken only)
+	SlackClientID           string                 // optional (default: empty, which disables the Add to Slack button)
+	SlackClientSecret       string
+	SlackRedirectURL        string
+	SlackTokenEncryptionKey string
+	SlackAllowedTeams       []string // optional (default: empty, which allows the Add to Slack button to install gocat to no workspace)
+	TriggerSQSQueueURL      string   // optional (default: empty, which disables the ingestion from SQS)
+	TriggerNATSURL          string   // optional (default: empty, which disables the ingestion from NATS)
+	TriggerNATSSubject      string   // optional (default: gocat.deploy)
+	TriggerNATSRootCA       string   // optional (default: empty, which verifies the server with the CA certificates of the system)
+	EventKafkaRESTURL       string   // optional (default: empty, which disables the deploy events)
+	EventKafkaTopic         string   // optional (default: gocat.deploy-events)
+	EventKafkaFormat        string   // optional (default: json)
+	ArtifactS3Bucket        string   // optional (default: empty, which disables the archive of the deploy artifacts)
+	ArtifactS3Prefix        string   // optional (default: gocat)
+	ArtifactRetentionDays   int      // optional (default: 365, 0 keeps the artifacts forever)
+	PrometheusURL           string   // optional (default: empty, which disables the SLO gates of Prometheus)
+	DatadogSite             string   // optional (default: datadoghq.com)
+	DatadogAPIKey           string   // optional (default: empty, which disables the SLO gates of Datadog)
+	DatadogAppKey           string
+	ChecklyAPIKey           string // optional (default: empty, which disables the synthetic checks of Checkly)
+	ChecklyAccountID        string
+	OPAURL                  string   // optional (default: empty, which disables the deploy policy)
+	OPAPolicyPath           string   // optional (default: gocat/deploy/deny)
+	HookNamespace           string   // optional (default: CONFIG_NAMESPACE)
+	HookAllowedImages       []string // optional (default: empty, which allows the command hooks to run only in the images of their phases)
+	MaxConcurrentDeploys    int      // optional (default: 0, which is unlimited)
+	MessageTemplateDir      string   // optional (default: empty, which keeps the built-in layouts of the messages)
+	OutboundProxyURL        string   // optional (default: empty, which uses the proxy of HTTPS_PROXY and the others)
+	OutboundNoProxy         string   // optional (default: empty)
+	OutboundCABundle        string   // optional (default: empty, which trusts the CAs of the system only)
 }
 
 func findRepositoryName(repo string) string {
@@ -51,12 +118,193 @@ func InitConfig() (*CatConfig, error) {
 	var Config = &CatConfig{}
 	Config.ManifestRepository = os.Getenv("CONFIG_MANIFEST_REPOSITORY")
 	Config.EnableAutoDeploy = os.Getenv("CONFIG_ENABLE_AUTO_DEPLOY") == "true"
+	Config.EnableSparseCheckout = os.Getenv("CONFIG_GIT_SPARSE_CHECKOUT") == "true"
+	Config.EnableStalenessWatcher = os.Getenv("CONFIG_ENABLE_STALENESS_WATCHER") == "true"
+	Config.RestrictReadCommands = os.Getenv("CONFIG_RESTRICT_READ_COMMANDS") == "true"
+	Config.EnableGitHubUserSync = os.Getenv("CONFIG_ENABLE_GITHUB_USER_SYNC") == "true"
+	Config.EnableRolloutPreview = os.Getenv("CONFIG_ENABLE_ROLLOUT_PREVIEW") == "true"
+	Config.EnableDeployPipeline = os.Getenv("CONFIG_ENABLE_DEPLOY_PIPELINE") == "true"
+	Config.EphemeralResponses = os.Getenv("CONFIG_EPHEMERAL_RESPONSES") != "false"
+	Config.AnnouncementChannel = os.Getenv("CONFIG_ANNOUNCEMENT_CHANNEL")
+	Config.AdminChannel = os.Getenv("CONFIG_ADMIN_CHANNEL")
+	Config.LeaderElectionLease = os.Getenv("CONFIG_LEADER_ELECTION_LEASE")
+	Config.ApprovalReaction = strings.Trim(os.Getenv("CONFIG_APPROVAL_REACTION"), ":")
+	gitRoot, err := expandGitRoot(os.Getenv("GOCAT_GITROOT"))
+	if err != nil {
+		return nil, fmt.Errorf("GOCAT_GITROOT is invalid: %w", err)
+	}
+	Config.GitRoot = gitRoot
+	if v := os.Getenv("CONFIG_GITROOT_QUOTA"); v != "" {
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_GITROOT_QUOTA is invalid: %w", err)
+		}
+		Config.GitRootQuota = q.Value()
+	}
+	Config.GitStorage = os.Getenv("CONFIG_GIT_STORAGE")
+	switch Config.GitStorage {
+	case "":
+		Config.GitStorage = GitStorageAuto
+	case GitStorageAuto, GitStorageDisk:
+	case GitStorageMemory:
+		if Config.GitRoot != "" {
+			return nil, fmt.Errorf("CONFIG_GIT_STORAGE can't be %s with GOCAT_GITROOT", GitStorageMemory)
+		}
+	default:
+		return nil, fmt.Errorf("CONFIG_GIT_STORAGE is invalid: %s", Config.GitStorage)
+	}
+	Config.MemFSMaxRepoSize = defaultMemFSMaxRepoSize
+	if v := os.Getenv("CONFIG_MEMFS_MAX_REPO_SIZE"); v != "" {
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_MEMFS_MAX_REPO_SIZE is invalid: %w", err)
+		}
+		Config.MemFSMaxRepoSize = q.Value()
+	}
+	Config.GitHubWebhookSecret = os.Getenv("CONFIG_GITHUB_WEBHOOK_SECRET")
+	Config.ConfigAPIToken = os.Getenv("CONFIG_API_TOKEN")
+	Config.ErrorReportingDSN = os.Getenv("CONFIG_ERROR_REPORTING_DSN")
+	Config.MessageTemplateDir = os.Getenv("CONFIG_MESSAGE_TEMPLATE_DIR")
+	Config.SlackWorkspaceName = os.Getenv("CONFIG_SLACK_WORKSPACE_NAME")
+	if Config.SlackWorkspaceName == "" {
+		Config.SlackWorkspaceName = defaultSlackWorkspaceName
+	}
+	Config.SlackClientID = os.Getenv("CONFIG_SLACK_CLIENT_ID")
+	Config.SlackRedirectURL = os.Getenv("CONFIG_SLACK_REDIRECT_URL")
+	for _, team := range strings.Split(os.Getenv("CONFIG_SLACK_ALLOWED_TEAMS"), ",") {
+		if team = strings.TrimSpace(team); team != "" {
+			Config.SlackAllowedTeams = append(Config.SlackAllowedTeams, team)
+		}
+	}
+	Config.TriggerSQSQueueURL = os.Getenv("CONFIG_TRIGGER_SQS_QUEUE_URL")
+	Config.TriggerNATSURL = os.Getenv("CONFIG_TRIGGER_NATS_URL")
+	Config.TriggerNATSSubject = os.Getenv("CONFIG_TRIGGER_NATS_SUBJECT")
+	Config.TriggerNATSRootCA = os.Getenv("CONFIG_TRIGGER_NATS_ROOT_CA")
+	if Config.TriggerNATSSubject == "" {
+		Config.TriggerNATSSubject = "gocat.deploy"
+	}
+	Config.EventKafkaRESTURL = os.Getenv("CONFIG_EVENT_KAFKA_REST_URL")
+	Config.EventKafkaTopic = os.Getenv("CONFIG_EVENT_KAFKA_TOPIC")
+	if Config.EventKafkaTopic == "" {
+		Config.EventKafkaTopic = "gocat.deploy-events"
+	}
+	Config.EventKafkaFormat = os.Getenv("CONFIG_EVENT_KAFKA_FORMAT")
+	if Config.EventKafkaFormat == "" {
+		Config.EventKafkaFormat = "json"
+	}
+	Config.ArtifactS3Bucket = os.Getenv("CONFIG_ARTIFACT_S3_BUCKET")
+	Config.ArtifactS3Prefix = os.Getenv("CONFIG_ARTIFACT_S3_PREFIX")
+	if Config.ArtifactS3Prefix == "" {
+		Config.ArtifactS3Prefix = "gocat"
+	}
+	Config.ArtifactRetentionDays = 365
+	if v := os.Getenv("CONFIG_ARTIFACT_RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("CONFIG_ARTIFACT_RETENTION_DAYS is invalid: %s", v)
+		}
+		Config.ArtifactRetentionDays = days
+	}
+	Config.PrometheusURL = os.Getenv("CONFIG_PROMETHEUS_URL")
+	Config.DatadogSite = os.Getenv("CONFIG_DATADOG_SITE")
+	if Config.DatadogSite == "" {
+		Config.DatadogSite = "datadoghq.com"
+	}
+	Config.DatadogAPIKey = os.Getenv("CONFIG_DATADOG_API_KEY")
+	Config.DatadogAppKey = os.Getenv("CONFIG_DATADOG_APP_KEY")
+	Config.ChecklyAPIKey = os.Getenv("CONFIG_CHECKLY_API_KEY")
+	Config.ChecklyAccountID = os.Getenv("CONFIG_CHECKLY_ACCOUNT_ID")
+	Config.OPAURL = os.Getenv("CONFIG_OPA_URL")
+	Config.OPAPolicyPath = os.Getenv("CONFIG_OPA_POLICY_PATH")
+	if Config.OPAPolicyPath == "" {
+		Config.OPAPolicyPath = defaultPolicyPath
+	}
+	Config.HookNamespace = os.Getenv("CONFIG_HOOK_NAMESPACE")
+	if Config.HookNamespace == "" {
+		Config.HookNamespace = configNamespace()
+	}
+	for _, image := range strings.Split(os.Getenv("CONFIG_HOOK_ALLOWED_IMAGES"), ",") {
+		if image = strings.TrimSpace(image); image != "" {
+			Config.HookAllowedImages = append(Config.HookAllowedImages, image)
+		}
+	}
+	if v := os.Getenv("CONFIG_MAX_CONCURRENT_DEPLOYS"); v != "" {
+		max, err := strconv.Atoi(v)
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf("CONFIG_MAX_CONCURRENT_DEPLOYS is invalid: %s", v)
+		}
+		Config.MaxConcurrentDeploys = max
+	}
+	if v := os.Getenv("CONFIG_DEPLOY_REQUEST_EXPIRY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_DEPLOY_REQUEST_EXPIRY is invalid: %w", err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("CONFIG_DEPLOY_REQUEST_EXPIRY is invalid: %s is negative", v)
+		}
+		Config.DeployRequestExpiry = d
+	}
+	Config.ListRefreshInterval = defaultListRefreshInterval
+	if v := os.Getenv("CONFIG_LIST_REFRESH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("CONFIG_LIST_REFRESH_INTERVAL is invalid: %s", v)
+		}
+		Config.ListRefreshInterval = d
+	}
+	Config.APITimeout = defaultAPITimeout
+	if v := os.Getenv("CONFIG_API_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("CONFIG_API_TIMEOUT is invalid: %s", v)
+		}
+		Config.APITimeout = d
+	}
+	Config.APIRetries = defaultAPIRetries
+	if v := os.Getenv("CONFIG_API_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("CONFIG_API_RETRIES is invalid: %s", v)
+		}
+		Config.APIRetries = n
+	}
+	Config.OutboundProxyURL = os.Getenv("CONFIG_OUTBOUND_PROXY_URL")
+	if v := Config.OutboundProxyURL; v != "" {
+		if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("CONFIG_OUTBOUND_PROXY_URL is invalid. Set like `http://proxy.internal:3128`")
+		}
+	}
+	Config.OutboundNoProxy = os.Getenv("CONFIG_OUTBOUND_NO_PROXY")
+	Config.OutboundCABundle = os.Getenv("CONFIG_OUTBOUND_CA_BUNDLE")
+	if Config.FeatureFlags, err = ParseFeatureFlags(os.Getenv("CONFIG_FEATURE_FLAGS")); err != nil {
+		return nil, fmt.Errorf("CONFIG_FEATURE_FLAGS is invalid: %w", err)
+	}
+	if v := os.Getenv("CONFIG_OUTBOUND_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("CONFIG_OUTBOUND_TIMEOUT is invalid: %s", v)
+		}
+		Config.OutboundTimeout = d
+	}
 	Config.ArgoCDHost = os.Getenv("CONFIG_ARGOCD_HOST")
 	Config.JenkinsHost = os.Getenv("CONFIG_JENKINS_HOST")
 	Config.GitHubUserName = os.Getenv("CONFIG_GITHUB_USER_NAME")
 	Config.GitHubDefaultBranch = os.Getenv("CONFIG_GITHUB_DEFAULT_BRANCH")
+	if Config.GitHubMergeMethod, err = parseMergeMethod(os.Getenv("CONFIG_GITHUB_MERGE_METHOD")); err != nil {
+		return nil, fmt.Errorf("CONFIG_GITHUB_MERGE_METHOD is invalid: %w", err)
+	}
 	Config.ManifestRepositoryName = findRepositoryName(Config.ManifestRepository)
 	Config.ManifestRepositoryOrg = findRepositoryOrg(Config.ManifestRepository)
+	Config.ManifestForkRepository = os.Getenv("CONFIG_MANIFEST_FORK_REPOSITORY")
+	if Config.ManifestForkRepository != "" && findRepositoryOrg(Config.ManifestForkRepository) == "" {
+		return nil, fmt.Errorf("CONFIG_MANIFEST_FORK_REPOSITORY is invalid. Set like `https://github.com/bot/repo.git`")
+	}
+	Config.ManifestRemoteName = os.Getenv("CONFIG_MANIFEST_REMOTE_NAME")
+	if Config.ManifestRemoteName == forkRemoteName || Config.ManifestRemoteName == mirrorRemoteName {
+		return nil, fmt.Errorf("CONFIG_MANIFEST_REMOTE_NAME can't be %q, which gocat uses for the remote of the %s", Config.ManifestRemoteName, Config.ManifestRemoteName)
+	}
+	Config.ManifestMirror = os.Getenv("CONFIG_MANIFEST_MIRROR_REPOSITORY")
 	if Config.GitHubUserName == "" {
 		Config.GitHubUserName = "gocat"
 	}
@@ -76,6 +324,12 @@ func InitConfig() (*CatConfig, error) {
 		Config.SlackVerificationToken = secret.VerificationToken
 		Config.JenkinsBotToken = secret.JenkinsBotUserToken
 		Config.JenkinsJobToken = secret.JenkinsJobToken
+		Config.SlackClientSecret = secret.SlackClientSecret
+		Config.SlackTokenEncryptionKey = secret.SlackTokenEncryptionKey
+		Config.SlackWorkspaces, err = ParseSlackWorkspaceConfigs(secret.SlackWorkspaces)
+		if err != nil {
+			return nil, fmt.Errorf("SLACK_WORKSPACES is invalid: %w", err)
+		}
 		return Config, nil
 
 	default:
@@ -91,6 +345,13 @@ func InitConfig() (*CatConfig, error) {
 		Config.SlackVerificationToken = os.Getenv("CONFIG_SLACK_VERIFICATION_TOKEN")
 		Config.JenkinsBotToken = os.Getenv("CONFIG_JENKINS_BOT_TOKEN")
 		Config.JenkinsJobToken = os.Getenv("CONFIG_JENKINS_JOB_TOKEN")
+		Config.SlackClientSecret = os.Getenv("CONFIG_SLACK_CLIENT_SECRET")
+		Config.SlackTokenEncryptionKey = os.Getenv("CONFIG_SLACK_TOKEN_ENCRYPTION_KEY")
+		workspaces, err := ParseSlackWorkspaceConfigs(os.Getenv("CONFIG_SLACK_WORKSPACES"))
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_SLACK_WORKSPACES is invalid: %w", err)
+		}
+		Config.SlackWorkspaces = workspaces
 		return Config, nil
 	}
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// configAPIHandler is a http.Handler to export and import the gocat configuration, for the config managed in a repository.
+//
+//	GET  /config             returns the current configuration as YAML.
+//	POST /config             returns the diff the YAML in the request body would make.
+//	POST /config?apply=true  applies the YAML in the request body, and returns the diff it made.
+//
+// Requests must have the token in the Authorization header as a bearer token.
+type configAPIHandler struct {
+	token string
+	store *ConfigStore
+	// reload is called after the configuration is applied.
+	reload func()
+}
+
+func (h configAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !hasBearerToken(r, h.token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		doc, err := h.store.Export(ctx)
+		if err != nil {
+			log.Printf("[ERROR] Failed to export the config: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := doc.YAML()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(b)
+	case http.MethodPost:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		diff, err := importConfig(ctx, h.store, b, r.URL.Query().Get("apply") == "true", h.reload)
+		if err != nil {
+			log.Printf("[ERROR] Failed to import the config: %s", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, diff)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// hasBearerToken returns true if the request has the token in the Authorization header. It's always false for the empty token.
+func hasBearerToken(r *http.Request, token string) bool {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(auth), []byte(token)) == 1
+}
+
+// importConfig returns the diff the YAML makes to the current configuration, and applies it if apply is true.
+func importConfig(ctx context.Context, store *ConfigStore, b []byte, apply bool, reload func()) (string, error) {
+	doc, err := ParseConfigDocument(b)
+	if err != nil {
+		return "", err
+	}
+	diff, err := store.Diff(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	if !apply || diff == "" {
+		return diff, nil
+	}
+	if err := store.Apply(ctx, doc); err != nil {
+		return "", err
+	}
+	if reload != nil {
+		reload()
+	}
+	return diff, nil
+}
+
+// exportConfig uploads the current configuration as a YAML file to the admin by DM, with the secrets redacted,
+// as the channel the command is run in may have the members who aren't allowed to see the configuration.
+func (s *SlackListener) exportConfig(ctx context.Context, userID string) ([]slack.Block, error) {
+	doc, err := s.configStore.Export(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := doc.Redacted().YAML()
+	if err != nil {
+		return nil, err
+	}
+	dm, _, _, err := s.client.OpenConversation(&slack.OpenConversationParameters{Users: []string{userID}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the DM with <@%s>: %w", userID, err)
+	}
+	if _, err := s.client.UploadFile(slack.FileUploadParameters{
+		Content:  string(b),
+		Filename: "gocat-config.yaml",
+		Title:    "gocat config",
+		Channels: []string{dm.ID},
+	}); err != nil {
+		return nil, fmt.Errorf("unable to upload the config: %w", err)
+	}
+	return plainBlocks(fmt.Sprintf("Sent %d configmaps to <@%s> by DM, with the secrets redacted. Fill them in, and run `config diff <path>` and `config apply <path>` with the reviewed file in the manifest repository to import it.", len(doc.ConfigMaps), userID)), nil
+}
+
+// importConfig previews or applies the configuration at the path in the manifest repository.
+func (s *SlackListener) importConfig(ctx context.Context, path string, apply bool, channel string) ([]slack.Block, error) {
+	b, err := s.github.GetFile(path)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := importConfig(ctx, s.configStore, b, apply, s.refresher.Refresh)
+	if err != nil {
+		return nil, fmt.Errorf("unable to import %s: %w", path, err)
+	}
+	if diff == "" {
+		return plainBlocks(fmt.Sprintf("No change in %s", path)), nil
+	}
+
+	header := fmt.Sprintf("Changes in %s. Run `config apply %s` to apply them.", path, path)
+	if apply {
+		header = fmt.Sprintf("Applied %s", path)
+	}
+	if len(diff) <= slackOutputMaxInline {
+		return plainBlocks(header, "```\n"+diff+"```"), nil
+	}
+	if _, err := s.client.UploadFile(slack.FileUploadParameters{
+		Content:  diff,
+		Filename: "gocat-config.diff",
+		Title:    header,
+		Channels: []string{channel},
+	}); err != nil {
+		return nil, fmt.Errorf("unable to upload the diff: %w", err)
+	}
+	return plainBlocks(header + " See the attached diff."), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigAPIHandler(t *testing.T) {
+	store := NewConfigStore("default")
+	store.clientset = fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "myapp", Namespace: "default", Labels: map[string]string{configMapTypeLabel: "project"}},
+		Data:       map[string]string{"GitHubRepository": "myapp", "Phases": "- name: staging\n  path: a\n"},
+	})
+	pl := &ProjectList{}
+	reloads := 0
+	reload := func() {
+		reloads++
+		cms, err := store.clientset.CoreV1().ConfigMaps("default").List(context.Background(), meta_v1.ListOptions{})
+		require.NoError(t, err)
+		pl.reload(cms.Items)
+	}
+	reload()
+	h := configAPIHandler{token: "secret", store: store, reload: reload}
+	serve := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/config", token, "").Code)
+	}
+	// The API is disabled without the token
+	rec := httptest.NewRecorder()
+	configAPIHandler{store: store}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serve(http.MethodGet, "/config", "secret", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	exported := rec.Body.String()
+	require.Contains(t, exported, "myapp")
+
+	rec = serve(http.MethodPost, "/config?apply=true", "secret", "configMaps: [")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, 1, reloads)
+
+	updated := strings.Replace(exported, "path: a", "path: a\n      - name: production\n        path: b", 1)
+	require.NotEqual(t, exported, updated)
+
+	// The diff is previewed without applying it
+	rec = serve(http.MethodPost, "/config", "secret", updated)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "+- name: production")
+	require.Equal(t, 1, reloads)
+	require.Empty(t, pl.Find("myapp").FindPhase("production").Name)
+
+	rec = serve(http.MethodPost, "/config?apply=true", "secret", updated)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "+- name: production")
+	require.Equal(t, 2, reloads)
+	require.Equal(t, "b", pl.Find("myapp").FindPhase("production").Path)
+
+	require.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodDelete, "/config", "secret", "").Code)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// configMapTypeLabel is the label that tells gocat what each configmap defines.
+const configMapTypeLabel = "gocat.zaim.net/configmap-type"
+
+// exportedConfigMapTypes are the types of the configmaps that make up the gocat configuration,
+// which are the projects, the users with their roles, and the command aliases.
+var exportedConfigMapTypes = []string{"project", "githubuser-mapping", "rolebinding", commandAliasConfigMapType}
+
+// ConfigDocument is the whole gocat configuration exported as YAML,
+// so that it can be kept in a config repository and changed through pull requests.
+type ConfigDocument struct {
+	ConfigMaps []ConfigDocumentItem `yaml:"configMaps"`
+}
+
+type ConfigDocumentItem struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// APIVersion is the version of the schema of the data, which is empty for the configmaps written before the schema was versioned.
+	APIVersion string            `yaml:"apiVersion,omitempty"`
+	Data       map[string]string `yaml:"data"`
+}
+
+func (i ConfigDocumentItem) key() string {
+	return i.Type + "/" + i.Name
+}
+
+func (i ConfigDocumentItem) version() string {
+	if i.APIVersion == "" {
+		return configAPIVersionLegacy
+	}
+	return i.APIVersion
+}
+
+// ParseConfigDocument parses the YAML exported by ConfigStore.Export.
+func ParseConfigDocument(b []byte) (ConfigDocument, error) {
+	var doc ConfigDocument
+	if err := yaml.UnmarshalStrict(b, &doc); err != nil {
+		return doc, fmt.Errorf("unable to parse the config: %w", err)
+	}
+	if len(doc.ConfigMaps) == 0 {
+		return doc, fmt.Errorf("the config has no configMaps")
+	}
+	seen := map[string]bool{}
+	for _, item := range doc.ConfigMaps {
+		if item.Name == "" || !isExportedConfigMapType(item.Type) {
+			return doc, fmt.Errorf("invalid configMap %q: name and type, which is one of %s, are required", item.key(), strings.Join(exportedConfigMapTypes, ", "))
+		}
+		if err := checkConfigVersion(item.Type, item.version(), item.Data); err != nil {
+			return doc, fmt.Errorf("invalid configMap %q: %w", item.key(), err)
+		}
+		for k, v := range item.Data {
+			if strings.Contains(v, redactedConfigValue) {
+				return doc, fmt.Errorf("invalid configMap %q: %s is %s. Fill in the secrets redacted by config export", item.key(), k, redactedConfigValue)
+			}
+		}
+		if seen[item.Name] {
+			return doc, fmt.Errorf("duplicate configMap %q", item.Name)
+		}
+		seen[item.Name] = true
+	}
+	return doc, nil
+}
+
+// redactedConfigValue replaces the secrets in the configuration exported to Slack.
+const redactedConfigValue = "REDACTED"
+
+// secretConfigKeyPattern matches the keys of the secrets, like oauthToken, verificationToken, and webhookSecret.
+var secretConfigKeyPattern = regexp.MustCompile(`(?i)(token|secret|password|apikey|api_key|authorization|credential)`)
+
+// Redacted returns the copy of the document with the values of the secret keys replaced by redactedConfigValue,
+// both the keys of the data and the ones in the YAML of the values, like the headers of the hooks in Phases.
+// The values with nothing to redact are kept as they are.
+func (d ConfigDocument) Redacted() ConfigDocument {
+	var redacted ConfigDocument
+	for _, item := range d.ConfigMaps {
+		data := map[string]string{}
+		for k, v := range item.Data {
+			data[k] = redactConfigValue(k, v)
+		}
+		item.Data = data
+		redacted.ConfigMaps = append(redacted.ConfigMaps, item)
+	}
+	return redacted
+}
+
+func redactConfigValue(key, value string) string {
+	if secretConfigKeyPattern.MatchString(key) {
+		return redactedConfigValue
+	}
+	var node yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(value), &node); err != nil || !redactYAMLNode(&node) {
+		return value
+	}
+	b, err := yamlv3.Marshal(&node)
+	if err != nil {
+		return redactedConfigValue
+	}
+	return string(b)
+}
+
+// redactYAMLNode redacts the values of the secret keys in the node, and returns true if it redacted any.
+func redactYAMLNode(n *yamlv3.Node) bool {
+	redacted := false
+	if n.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if v := n.Content[i+1]; secretConfigKeyPattern.MatchString(n.Content[i].Value) && v.Kind == yamlv3.ScalarNode {
+				v.Value, v.Tag, v.Style = redactedConfigValue, "!!str", 0
+				redacted = true
+			}
+		}
+	}
+	for _, c := range n.Content {
+		if redactYAMLNode(c) {
+			redacted = true
+		}
+	}
+	return redacted
+}
+
+func (d ConfigDocument) YAML() ([]byte, error) {
+	return yaml.Marshal(d)
+}
+
+func isExportedConfigMapType(t string) bool {
+	for _, v := range exportedConfigMapTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigStore exports the gocat configuration stored in the configmaps, and applies the reviewed one back.
+type ConfigStore struct {
+	clientset kubernetes.Interface
+	namespace string
+}
+
+func NewConfigStore(namespace string) *ConfigStore {
+	return &ConfigStore{namespace: namespace}
+}
+
+func (s *ConfigStore) client() (kubernetes.Interface, error) {
+	if s.clientset != nil {
+		return s.clientset, nil
+	}
+	client, err := newKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	s.clientset = client
+	return client, nil
+}
+
+// Export returns the current configuration, sorted by the type and the name of the configmaps.
+func (s *ConfigStore) Export(ctx context.Context) (ConfigDocument, error) {
+	var doc ConfigDocument
+	client, err := s.client()
+	if err != nil {
+		return doc, err
+	}
+	for _, t := range exportedConfigMapTypes {
+		cml, err := client.CoreV1().ConfigMaps(s.namespace).List(ctx, meta_v1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", configMapTypeLabel, t)})
+		if err != nil {
+			return doc, fmt.Errorf("unable to list the %s configmaps: %w", t, err)
+		}
+		sort.Slice(cml.Items, func(i, j int) bool {
+			return cml.Items[i].Name < cml.Items[j].Name
+		})
+		for _, cm := range cml.Items {
+			doc.ConfigMaps = append(doc.ConfigMaps, ConfigDocumentItem{Name: cm.Name, Type: t, APIVersion: cm.Annotations[configAPIVersionAnnotation], Data: cm.Data})
+		}
+	}
+	return doc, nil
+}
+
+// Diff returns the changes Apply makes to the current configuration to match doc, in the unified diff format.
+// It's empty if there's no change.
+func (s *ConfigStore) Diff(ctx context.Context, doc ConfigDocument) (string, error) {
+	current, err := s.Export(ctx)
+	if err != nil {
+		return "", err
+	}
+	return diffConfigDocuments(current, doc), nil
+}
+
+// Apply creates or updates the configmaps in doc.
+// Configmaps missing in doc are kept as they are, so that a partial document never deletes projects by accident.
+func (s *ConfigStore) Apply(ctx context.Context, doc ConfigDocument) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	configMaps := client.CoreV1().ConfigMaps(s.namespace)
+	for _, item := range doc.ConfigMaps {
+		cm, err := configMaps.Get(ctx, item.Name, meta_v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			cm = &v1.ConfigMap{
+				ObjectMeta: meta_v1.ObjectMeta{Name: item.Name, Labels: map[string]string{configMapTypeLabel: item.Type}},
+				Data:       item.Data,
+			}
+			if item.APIVersion != "" {
+				cm.Annotations = map[string]string{configAPIVersionAnnotation: item.APIVersion}
+			}
+			if _, err := configMaps.Create(ctx, cm, meta_v1.CreateOptions{}); err != nil {
+				return fmt.Errorf("unable to create %s: %w", item.key(), err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to get %s: %w", item.key(), err)
+		}
+		if cm.Labels == nil {
+			cm.Labels = map[string]string{}
+		}
+		cm.Labels[configMapTypeLabel] = item.Type
+		// The documents exported before the schema was versioned keep the version of the configmap
+		if item.APIVersion != "" {
+			if cm.Annotations == nil {
+				cm.Annotations = map[string]string{}
+			}
+			cm.Annotations[configAPIVersionAnnotation] = item.APIVersion
+		}
+		cm.Data = item.Data
+		if _, err := configMaps.Update(ctx, cm, meta_v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("unable to update %s: %w", item.key(), err)
+		}
+	}
+	return nil
+}
+
+// diffConfigDocuments returns the diff of the configmaps in desired from the ones in current, key by key.
+func diffConfigDocuments(current, desired ConfigDocument) string {
+	existing := map[string]ConfigDocumentItem{}
+	for _, item := range current.ConfigMaps {
+		existing[item.key()] = item
+	}
+
+	var b strings.Builder
+	for _, item := range desired.ConfigMaps {
+		old, ok := existing[item.key()]
+		if !ok {
+			fmt.Fprintf(&b, "+++ %s (new)\n", item.key())
+		}
+		keys := map[string]bool{}
+		for k := range old.Data {
+			keys[k] = true
+		}
+		for k := range item.Data {
+			keys[k] = true
+		}
+		var sorted []string
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		var changes []string
+		if item.APIVersion != "" && item.version() != old.version() {
+			changes = append(changes, fmt.Sprintf("@@ apiVersion %s -> %s @@", old.version(), item.version()))
+		}
+		for _, k := range sorted {
+			if old.Data[k] == item.Data[k] {
+				continue
+			}
+			changes = append(changes, fmt.Sprintf("@@ %s @@", k))
+			changes = append(changes, diffLines(old.Data[k], item.Data[k])...)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		if ok {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", item.key(), item.key())
+		}
+		b.WriteString(strings.Join(changes, "\n") + "\n")
+	}
+	return b.String()
+}
+
+// diffLines returns the lines removed from a with the - prefix, and the ones added in b with the + prefix,
+// along with the common lines with the space prefix, based on their longest common subsequence.
+func diffLines(a, b string) []string {
+	var x, y []string
+	if a != "" {
+		x = strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	}
+	if b != "" {
+		y = strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			lines = append(lines, " "+x[i])
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+x[i])
+			i++
+		default:
+			lines = append(lines, "+"+y[j])
+			j++
+		}
+	}
+	return lines
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewConfigStore("default")
+	s.clientset = fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "myapp", Namespace: "default", Labels: map[string]string{configMapTypeLabel: "project"}},
+			Data:       map[string]string{"GitHubRepository": "myapp", "Phases": "- name: staging\n  path: a\n- name: production\n  path: b\n"},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "gocat-deploy", Namespace: "default"},
+			Data:       map[string]string{"myapp-staging": "{}"},
+		},
+	)
+
+	doc, err := s.Export(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ConfigDocument{ConfigMaps: []ConfigDocumentItem{
+		{Name: "myapp", Type: "project", Data: map[string]string{"GitHubRepository": "myapp", "Phases": "- name: staging\n  path: a\n- name: production\n  path: b\n"}},
+	}}, doc)
+
+	b, err := doc.YAML()
+	require.NoError(t, err)
+	parsed, err := ParseConfigDocument(b)
+	require.NoError(t, err)
+	require.Equal(t, doc, parsed)
+
+	diff, err := s.Diff(ctx, parsed)
+	require.NoError(t, err)
+	require.Empty(t, diff)
+
+	parsed.ConfigMaps[0].Data["Phases"] = "- name: staging\n  path: a\n- name: production\n  path: c\n"
+	parsed.ConfigMaps = append(parsed.ConfigMaps, ConfigDocumentItem{Name: "roles", Type: "rolebinding", Data: map[string]string{"Admin": "alice"}})
+	diff, err = s.Diff(ctx, parsed)
+	require.NoError(t, err)
+	require.Equal(t, `--- project/myapp
++++ project/myapp
+@@ Phases @@
+ - name: staging
+   path: a
+ - name: production
+-  path: b
++  path: c
++++ rolebinding/roles (new)
+@@ Admin @@
++alice
+`, diff)
+
+	require.NoError(t, s.Apply(ctx, parsed))
+	doc, err = s.Export(ctx)
+	require.NoError(t, err)
+	require.Equal(t, parsed, doc)
+}
+
+func TestParseConfigDocument(t *testing.T) {
+	_, err := ParseConfigDocument([]byte("configMaps:\n- name: myapp\n  type: unknown\n"))
+	require.Error(t, err)
+
+	_, err = ParseConfigDocument([]byte("configMaps:\n- name: myapp\n  type: project\n- name: myapp\n  type: rolebinding\n"))
+	require.EqualError(t, err, `duplicate configMap "myapp"`)
+}
+
+func TestConfigDocument_Redacted(t *testing.T) {
+	doc := ConfigDocument{ConfigMaps: []ConfigDocumentItem{
+		{Name: "notifier", Type: "project", Data: map[string]string{
+			"Team":         "T0001",
+			"WebhookToken": "xoxb-secret",
+		}},
+		{Name: "myapp", Type: "project", Data: map[string]string{
+			"Phases": "- name: production\n  hooks:\n  - url: https://example.com\n    authorization: Bearer secret\n",
+			"Image":  "myapp",
+		}},
+	}}
+	redacted := doc.Redacted()
+	require.Equal(t, "T0001", redacted.ConfigMaps[0].Data["Team"])
+	require.Equal(t, redactedConfigValue, redacted.ConfigMaps[0].Data["WebhookToken"])
+	require.Equal(t, "myapp", redacted.ConfigMaps[1].Data["Image"])
+	require.NotContains(t, redacted.ConfigMaps[1].Data["Phases"], "secret")
+	require.Contains(t, redacted.ConfigMaps[1].Data["Phases"], "https://example.com")
+	require.Equal(t, "xoxb-secret", doc.ConfigMaps[0].Data["WebhookToken"])
+
+	b, err := redacted.YAML()
+	require.NoError(t, err)
+	_, err = ParseConfigDocument(b)
+	require.ErrorContains(t, err, "Fill in the secrets redacted by config export")
+}
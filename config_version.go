@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+)
+
+// configAPIVersionAnnotation is the annotation of the version of the schema the data of the configmap is written in.
+const configAPIVersionAnnotation = "gocat.zaim.net/api-version"
+
+// The versions of the schema of the configmaps.
+const (
+	// configAPIVersionLegacy is the version of the configmaps without the annotation, which were written before the schema was versioned.
+	// They're read as leniently as before, so the unknown keys, like the misspelled ones, are ignored.
+	configAPIVersionLegacy = "v0"
+	// configAPIVersion is the latest version, which this gocat writes and `gocat config migrate` upgrades the configmaps to.
+	// The unknown keys of the projects and the rolebindings make the configmaps invalid instead of being ignored.
+	configAPIVersion = "v1"
+)
+
+// configMigration upgrades the data of a configmap of the type t from the version from to the version to.
+type configMigration struct {
+	from    string
+	to      string
+	migrate func(t string, data map[string]string) (map[string]string, error)
+}
+
+// configMigrations are the migrations in order from the oldest version.
+// A breaking change of the schema bumps configAPIVersion and adds the migration to it here,
+// so that the configmaps written for the older gocat are upgraded instead of being misread.
+var configMigrations = []configMigration{
+	// The data is the same, but the keys are validated from v1 on
+	{from: configAPIVersionLegacy, to: "v1", migrate: func(t string, data map[string]string) (map[string]string, error) {
+		if unknown := unknownConfigKeys(t, data); len(unknown) > 0 {
+			return nil, fmt.Errorf("unknown keys %s, which need to be fixed or removed by hand", strings.Join(unknown, ", "))
+		}
+		return data, nil
+	}},
+}
+
+// configKeys are the keys of the data of the configmaps of the types with the fixed keys.
+// The other types, like githubuser-mapping, are keyed by the names of the users and such.
+var configKeys = map[string][]string{
+	"project": {
+		"Alias", "BranchNameTemplate", "CommitMessageTemplate", "DefaultBranch", "DisableBranchDeploy", "DockerRegistry",
+		"FilterRegexp", "FuncName", "GitHubRepository", "HolidayCalendar", "Holidays", "JenkinsJob", "Kind", "MaxConcurrentDeploys", "Features",
+		"Phases", "PullRequestBodyTemplate", "PullRequestTitleTemplate", "Steps", "TagStrategy", "TargetRegexp", "TimeZone", "Workspaces",
+	},
+	"rolebinding": {"Admin", "Developer", "Viewer"},
+}
+
+// unknownConfigKeys returns the keys of the data unknown to the type, sorted.
+func unknownConfigKeys(t string, data map[string]string) []string {
+	keys, ok := configKeys[t]
+	if !ok {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, k := range keys {
+		known[k] = true
+	}
+	var unknown []string
+	for k := range data {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// configMapAPIVersion returns the version of the schema of the configmap.
+func configMapAPIVersion(cm v1.ConfigMap) string {
+	if v := cm.Annotations[configAPIVersionAnnotation]; v != "" {
+		return v
+	}
+	return configAPIVersionLegacy
+}
+
+// checkConfigVersion checks this gocat can read the data of the configmap of the type t in the version,
+// which fails for the versions newer than configAPIVersion, like after gocat is rolled back.
+func checkConfigVersion(t, version string, data map[string]string) error {
+	switch version {
+	case configAPIVersionLegacy:
+		return nil
+	case configAPIVersion:
+		if unknown := unknownConfigKeys(t, data); len(unknown) > 0 {
+			return fmt.Errorf("unknown keys %s", strings.Join(unknown, ", "))
+		}
+		return nil
+	}
+	for _, m := range configMigrations {
+		if m.from == version {
+			return fmt.Errorf("apiVersion %s is outdated. Run gocat config migrate to upgrade it to %s", version, configAPIVersion)
+		}
+	}
+	return fmt.Errorf("unsupported apiVersion %s, which is newer than %s this gocat supports", version, configAPIVersion)
+}
+
+// readableConfigMaps returns the configmaps of the type t this gocat can read, logging the others.
+func readableConfigMaps(t string, cms []v1.ConfigMap) []v1.ConfigMap {
+	var readable []v1.ConfigMap
+	for _, cm := range cms {
+		if err := checkConfigVersion(t, configMapAPIVersion(cm), cm.Data); err != nil {
+			log.Printf("[ERROR] The %s configmap %s is ignored: %s", t, cm.Name, err)
+			continue
+		}
+		readable = append(readable, cm)
+	}
+	return readable
+}
+
+// migrateConfigItem upgrades the item to configAPIVersion, applying the migrations from its version in order.
+func migrateConfigItem(item ConfigDocumentItem) (ConfigDocumentItem, error) {
+	version := item.version()
+	if version == configAPIVersion {
+		return item, nil
+	}
+	data := item.Data
+	for _, m := range configMigrations {
+		if m.from != version {
+			continue
+		}
+		var err error
+		if data, err = m.migrate(item.Type, data); err != nil {
+			return item, fmt.Errorf("unable to migrate %s from %s to %s: %w", item.key(), m.from, m.to, err)
+		}
+		version = m.to
+	}
+	if version != configAPIVersion {
+		return item, fmt.Errorf("unable to migrate %s from apiVersion %s, which this gocat doesn't know", item.key(), item.version())
+	}
+	item.APIVersion, item.Data = version, data
+	return item, nil
+}
+
+// migrateConfig returns the document with all the configmaps upgraded to configAPIVersion.
+// It fails with the errors of all the configmaps unable to be migrated, so they can be fixed at once.
+func migrateConfig(doc ConfigDocument) (ConfigDocument, error) {
+	var migrated ConfigDocument
+	var errs []string
+	for _, item := range doc.ConfigMaps {
+		m, err := migrateConfigItem(item)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		migrated.ConfigMaps = append(migrated.ConfigMaps, m)
+	}
+	if len(errs) > 0 {
+		return migrated, errors.New(strings.Join(errs, "\n"))
+	}
+	return migrated, nil
+}
+
+// MigrateConfig upgrades the configmaps in the store to configAPIVersion, and returns the diff it makes.
+// It only returns the diff without applying it unless apply is true.
+func MigrateConfig(ctx context.Context, store *ConfigStore, apply bool) (string, error) {
+	current, err := store.Export(ctx)
+	if err != nil {
+		return "", err
+	}
+	doc, err := migrateConfig(current)
+	if err != nil {
+		return "", err
+	}
+	diff := diffConfigDocuments(current, doc)
+	if !apply || diff == "" {
+		return diff, nil
+	}
+	return diff, store.Apply(ctx, doc)
+}
+
+// migrateConfigCommand runs `gocat config migrate [-apply]`, which prints the diff of the migration of the configmaps
+// in CONFIG_NAMESPACE, and applies it with -apply. It exits with 1 on failure.
+func migrateConfigCommand(args []string) {
+	fs := flag.NewFlagSet("config migrate", flag.ExitOnError)
+	apply := fs.Bool("apply", false, "apply the migration instead of only printing the diff")
+	_ = fs.Parse(args)
+
+	diff, err := MigrateConfig(context.Background(), NewConfigStore(configNamespace()), *apply)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	switch {
+	case diff == "":
+		fmt.Printf("All the configmaps are in apiVersion %s\n", configAPIVersion)
+	case *apply:
+		fmt.Print(diff)
+		fmt.Printf("Migrated the configmaps to apiVersion %s\n", configAPIVersion)
+	default:
+		fmt.Print(diff)
+		fmt.Println("Run with -apply to migrate the configmaps")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckConfigVersion(t *testing.T) {
+	data := map[string]string{"Kind": "kustomize", "Phase": "- name: staging"}
+	require.NoError(t, checkConfigVersion("project", configAPIVersionLegacy, data))
+	require.EqualError(t, checkConfigVersion("project", "v1", data), "unknown keys Phase")
+	require.NoError(t, checkConfigVersion("githubuser-mapping", "v1", map[string]string{"alice": "alice-gh"}))
+	require.EqualError(t, checkConfigVersion("project", "v2", nil), "unsupported apiVersion v2, which is newer than v1 this gocat supports")
+
+	cm := v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "myapp", Annotations: map[string]string{configAPIVersionAnnotation: "v2"}}, Data: map[string]string{"Kind": "kustomize"}}
+	_, err := parseProject(cm, nil)
+	require.Error(t, err)
+	require.Empty(t, readableConfigMaps("project", []v1.ConfigMap{cm}))
+}
+
+func TestMigrateConfig(t *testing.T) {
+	ctx := context.Background()
+	s := NewConfigStore("default")
+	s.clientset = fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "myapp", Namespace: "default", Labels: map[string]string{configMapTypeLabel: "project"}},
+			Data:       map[string]string{"Kind": "kustomize"},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "roles", Namespace: "default", Labels: map[string]string{configMapTypeLabel: "rolebinding"}, Annotations: map[string]string{configAPIVersionAnnotation: "v1"}},
+			Data:       map[string]string{"Admin": "alice"},
+		},
+	)
+
+	diff, err := MigrateConfig(ctx, s, false)
+	require.NoError(t, err)
+	require.Equal(t, "--- project/myapp\n+++ project/myapp\n@@ apiVersion v0 -> v1 @@\n", diff)
+
+	_, err = MigrateConfig(ctx, s, true)
+	require.NoError(t, err)
+	doc, err := s.Export(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "v1", doc.ConfigMaps[0].APIVersion)
+	diff, err = MigrateConfig(ctx, s, false)
+	require.NoError(t, err)
+	require.Empty(t, diff)
+
+	// The unknown keys are reported instead of being dropped
+	_, err = migrateConfig(ConfigDocument{ConfigMaps: []ConfigDocumentItem{{Name: "web", Type: "project", Data: map[string]string{"Kind": "kustomize", "Phase": ""}}}})
+	require.EqualError(t, err, "unable to migrate project/web from v0 to v1: unknown keys Phase, which need to be fixed or removed by hand")
+}
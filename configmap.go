@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 
 	"k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -20,12 +23,7 @@ func getConfigMapList(t string) (cml *v1.ConfigMapList) {
 		return
 	}
 
-	ns := os.Getenv("CONFIG_NAMESPACE")
-	if ns == "" {
-		ns = "default"
-	}
-
-	cml, err = client.CoreV1().ConfigMaps(ns).List(context.Background(), meta_v1.ListOptions{LabelSelector: fmt.Sprintf("gocat.zaim.net/configmap-type=%s", t)})
+	cml, err = client.CoreV1().ConfigMaps(configNamespace()).List(context.Background(), meta_v1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", configMapTypeLabel, t)})
 	if err != nil {
 		log.Print("[ERROR] ", err)
 		return
@@ -34,17 +32,77 @@ func getConfigMapList(t string) (cml *v1.ConfigMapList) {
 	return cml
 }
 
+// createConfigMap creates the configmap of the type, like project, that getConfigMapList finds.
+// The configmaps of the gocat configuration are written in the latest version of the schema.
+func createConfigMap(name string, t string, data map[string]string) error {
+	client, err := newKubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{configMapTypeLabel: t},
+		},
+		Data: data,
+	}
+	if isExportedConfigMapType(t) {
+		cm.Annotations = map[string]string{configAPIVersionAnnotation: configAPIVersion}
+	}
+	_, err = client.CoreV1().ConfigMaps(configNamespace()).Create(context.Background(), cm, meta_v1.CreateOptions{})
+	return err
+}
+
+// annotateConfigMap sets the annotation of the configmap, leaving its data untouched.
+func annotateConfigMap(name, key, value string) error {
+	client, err := newKubernetesClient()
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"annotations": map[string]string{key: value}}})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().ConfigMaps(configNamespace()).Patch(context.Background(), name, types.MergePatchType, patch, meta_v1.PatchOptions{})
+	return err
+}
+
+// configNamespace returns the namespace of the configmaps gocat reads and writes.
+func configNamespace() string {
+	ns := os.Getenv("CONFIG_NAMESPACE")
+	if ns == "" {
+		ns = "default"
+	}
+	return ns
+}
+
+// devKubernetesClient is the fake cluster of the dev mode, which newKubernetesClient returns in place of the real one if set.
+var devKubernetesClient kubernetes.Interface
+
 func newKubernetesClient() (kubernetes.Interface, error) {
-	if os.Getenv("LOCAL") != "" {
-		config, err := clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
-		if err != nil {
-			return nil, err
-		}
-		return kubernetes.NewForConfig(config)
+	if devKubernetesClient != nil {
+		return devKubernetesClient, nil
 	}
-	config, err := rest.InClusterConfig()
+	config, err := kubernetesConfig()
 	if err != nil {
 		return nil, err
 	}
 	return kubernetes.NewForConfig(config)
 }
+
+// newDynamicClient returns the client for the custom resources gocat has no typed client for, like the Argo Rollouts.
+func newDynamicClient() (dynamic.Interface, error) {
+	config, err := kubernetesConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(config)
+}
+
+func kubernetesConfig() (*rest.Config, error) {
+	if os.Getenv("LOCAL") != "" {
+		return clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
+	}
+	return rest.InClusterConfig()
+}
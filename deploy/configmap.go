@@ -11,6 +11,7 @@ import (
 type ConfigMapValue struct {
 	Locked  bool              `json:"locked"`
 	History []LockHistoryItem `json:"history"`
+	Pin     *PinState         `json:"pin,omitempty"`
 }
 
 type LockHistoryItem struct {
@@ -0,0 +1,69 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var ErrEmergencyStopped = fmt.Errorf("all deployments are stopped")
+var ErrNotEmergencyStopped = fmt.Errorf("deployments are not stopped")
+
+// emergencyStopKey is the key within the ConfigMap for the EmergencyStopState.
+// It can't collide with the keys for projects and environments, which are always "<project>-<environment>".
+const emergencyStopKey = "emergency-stop"
+
+// EmergencyStopState is who stopped all the deployments and why.
+//
+// While stopped, neither AutoDeploy nor users can deploy any project to any environment,
+// which is useful during major incidents.
+type EmergencyStopState struct {
+	User   string      `json:"user"`
+	At     metav1.Time `json:"at"`
+	Reason string      `json:"reason"`
+}
+
+// EmergencyStop stops all the deployments until Resume is called.
+//
+// Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
+func (c *Coordinator) EmergencyStop(ctx context.Context, user, reason string) error {
+	return c.updateData(ctx, emergencyStopKey, func(data string) (string, error) {
+		if data != "" {
+			return "", ErrEmergencyStopped
+		}
+		b, err := json.Marshal(EmergencyStopState{User: user, At: metav1.Now(), Reason: reason})
+		return string(b), err
+	})
+}
+
+// Resume resumes the deployments stopped by EmergencyStop.
+//
+// Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
+func (c *Coordinator) Resume(ctx context.Context) error {
+	return c.updateData(ctx, emergencyStopKey, func(data string) (string, error) {
+		if data == "" {
+			return "", ErrNotEmergencyStopped
+		}
+		return "", nil
+	})
+}
+
+// EmergencyStopStatus returns the EmergencyStopState, or nil if the deployments aren't stopped.
+func (c *Coordinator) EmergencyStopStatus(ctx context.Context) (*EmergencyStopState, error) {
+	configMap, err := c.getOrCreateConfigMap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get or create configmap: %w", err)
+	}
+
+	data := configMap.Data[emergencyStopKey]
+	if data == "" {
+		return nil, nil
+	}
+	var state EmergencyStopState
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
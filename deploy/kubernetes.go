@@ -51,3 +51,9 @@ func (c *Coordinator) kubernetesClientSet() (clientset.Interface, error) {
 
 	return clientset, nil
 }
+
+// UseClientset makes the coordinator use the clientset instead of the KUBECONFIG file or the in-cluster configuration,
+// like the fake cluster of the dev mode.
+func (c *Coordinator) UseClientset(cs clientset.Interface) {
+	c.clientset = cs
+}
@@ -0,0 +1,126 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var ErrPinned = fmt.Errorf("deployment is pinned")
+var ErrNotPinned = fmt.Errorf("deployment is not pinned")
+
+// PinState is the tag a project and environment is pinned to.
+//
+// While pinned, neither AutoDeploy nor users can deploy the project to the environment,
+// which is useful during incident investigations.
+type PinState struct {
+	Tag    string      `json:"tag"`
+	User   string      `json:"user"`
+	At     metav1.Time `json:"at"`
+	Reason string      `json:"reason"`
+}
+
+// Pin pins the given project and environment to the tag.
+//
+// Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
+func (c *Coordinator) Pin(ctx context.Context, project, environment, user, tag, reason string) error {
+	return c.updateValue(ctx, project, environment, func(value *ConfigMapValue) error {
+		if value.Pin != nil {
+			return ErrPinned
+		}
+		value.Pin = &PinState{
+			Tag:    tag,
+			User:   user,
+			At:     metav1.Now(),
+			Reason: reason,
+		}
+		return nil
+	})
+}
+
+// Unpin unpins the given project and environment.
+// Anyone can unpin, as unpinning is usually done by someone else than the one who pinned after an incident.
+//
+// Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
+func (c *Coordinator) Unpin(ctx context.Context, project, environment string) error {
+	return c.updateValue(ctx, project, environment, func(value *ConfigMapValue) error {
+		if value.Pin == nil {
+			return ErrNotPinned
+		}
+		value.Pin = nil
+		return nil
+	})
+}
+
+// Status returns the lock and pin state of the given project and environment.
+func (c *Coordinator) Status(ctx context.Context, project, environment string) (ConfigMapValue, error) {
+	configMap, err := c.getOrCreateConfigMap(ctx)
+	if err != nil {
+		return ConfigMapValue{}, fmt.Errorf("unable to get or create configmap: %w", err)
+	}
+
+	return strToConfigMapValue(configMap.Data[c.configMapKey(project, environment)])
+}
+
+// updateValue updates the value for the given project and environment with the update function,
+// retrying on conflicts.
+func (c *Coordinator) updateValue(ctx context.Context, project, environment string, update func(*ConfigMapValue) error) error {
+	return c.updateData(ctx, c.configMapKey(project, environment), func(data string) (string, error) {
+		value, err := strToConfigMapValue(data)
+		if err != nil {
+			return "", fmt.Errorf("unable to unmarshal str into value: %w", err)
+		}
+		if err := update(&value); err != nil {
+			return "", err
+		}
+		return configMapValueToStr(value)
+	})
+}
+
+// updateData updates the data for the given key of the ConfigMap with the update function,
+// retrying on conflicts.
+// The key is removed if the update function returns an empty string.
+func (c *Coordinator) updateData(ctx context.Context, key string, update func(data string) (string, error)) error {
+	var retried int
+	for {
+		err := c.tryUpdateData(ctx, key, update)
+		if err == nil {
+			return nil
+		}
+
+		if !kerrors.IsConflict(err) {
+			return err
+		}
+
+		if retried >= MaxConfigMapUpdateRetries {
+			return fmt.Errorf("unable to update configmap after %d retries: %w", MaxConfigMapUpdateRetries, err)
+		}
+		retried++
+	}
+}
+
+func (c *Coordinator) tryUpdateData(ctx context.Context, key string, update func(data string) (string, error)) error {
+	configMap, err := c.getOrCreateConfigMap(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get or create configmap: %w", err)
+	}
+
+	data, err := update(configMap.Data[key])
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	if data == "" {
+		delete(configMap.Data, key)
+	} else {
+		configMap.Data[key] = data
+	}
+
+	_, err = c.updateConfigMap(ctx, configMap)
+	return err
+}
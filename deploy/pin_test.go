@@ -0,0 +1,66 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPinUnpin(t *testing.T) {
+	c := NewCoordinator("default", "gocat-test")
+	c.clientset = fake.NewSimpleClientset()
+
+	ctx := context.Background()
+
+	status, err := c.Status(ctx, "myproject1", "production")
+	require.NoError(t, err)
+	require.Nil(t, status.Pin)
+
+	require.NoError(t, c.Pin(ctx, "myproject1", "production", "user1", "v1.2.3", "incident investigation"))
+	require.ErrorIs(t, c.Pin(ctx, "myproject1", "production", "user2", "v1.2.4", ""), ErrPinned)
+
+	status, err = c.Status(ctx, "myproject1", "production")
+	require.NoError(t, err)
+	require.NotNil(t, status.Pin)
+	require.Equal(t, "v1.2.3", status.Pin.Tag)
+	require.Equal(t, "user1", status.Pin.User)
+	require.Equal(t, "incident investigation", status.Pin.Reason)
+
+	status, err = c.Status(ctx, "myproject1", "staging")
+	require.NoError(t, err)
+	require.Nil(t, status.Pin)
+
+	require.NoError(t, c.Unpin(ctx, "myproject1", "production"))
+	require.ErrorIs(t, c.Unpin(ctx, "myproject1", "production"), ErrNotPinned)
+}
+
+func TestEmergencyStopResume(t *testing.T) {
+	c := NewCoordinator("default", "gocat-test")
+	c.clientset = fake.NewSimpleClientset()
+
+	ctx := context.Background()
+
+	state, err := c.EmergencyStopStatus(ctx)
+	require.NoError(t, err)
+	require.Nil(t, state)
+	require.ErrorIs(t, c.Resume(ctx), ErrNotEmergencyStopped)
+
+	require.NoError(t, c.EmergencyStop(ctx, "admin1", "major incident"))
+	require.ErrorIs(t, c.EmergencyStop(ctx, "admin2", ""), ErrEmergencyStopped)
+
+	state, err = c.EmergencyStopStatus(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	require.Equal(t, "admin1", state.User)
+	require.Equal(t, "major incident", state.Reason)
+
+	// Projects and environments aren't affected by the emergency stop key
+	require.NoError(t, c.Pin(ctx, "myproject1", "production", "user1", "v1.2.3", ""))
+
+	require.NoError(t, c.Resume(ctx))
+	state, err = c.EmergencyStopStatus(ctx)
+	require.NoError(t, err)
+	require.Nil(t, state)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zaiminc/gocat/deploy"
+)
+
+// DeployGate checks the deploys of all the paths, including AutoDeploy, right before they ship.
+type DeployGate struct {
+	projectList *ProjectList
+	// coordinator blocks the deploys while all deploys are stopped or the phase is pinned.
+	coordinator  *deploy.Coordinator
+	errorBudgets ErrorBudgetClient
+	policy       DeployPolicy
+	// tracer records the approvals the gate lets through, like the ones while the error budget is exhausted.
+	tracer *DeployTracer
+	// now returns the current time, which defaults to time.Now.
+	now func() time.Time
+}
+
+// ErrNotBusinessDay is returned when the phase deploys only on the business days.
+var ErrNotBusinessDay = errors.New("not a business day")
+
+func NewDeployGate(config CatConfig, projectList *ProjectList, coordinator *deploy.Coordinator, tracer *DeployTracer) DeployGate {
+	return DeployGate{
+		projectList:  projectList,
+		coordinator:  coordinator,
+		errorBudgets: NewErrorBudgetClient(config),
+		policy:       NewDeployPolicy(config),
+		tracer:       tracer,
+	}
+}
+
+// Check returns the error telling why the deploy of m can't ship now.
+// approver is the Slack user ID of who approved it, the requester if nothing is approved, or empty for AutoDeploy.
+func (g DeployGate) Check(pj DeployProject, phase DeployPhase, m DeployMetadata, approver string) error {
+	// The phase may have been pinned, or all deploys stopped, since the deploy was requested
+	if err := checkDeployable(g.coordinator, pj.ID, phase.Name); err != nil {
+		return err
+	}
+	if approver != "" {
+		if err := checkTwoPersonRule(g.projectList, m, approver); err != nil {
+			return err
+		}
+	}
+	if err := g.checkBusinessDay(pj, phase); err != nil {
+		return err
+	}
+	if err := g.checkSLOGate(pj, phase, m, approver); err != nil {
+		return err
+	}
+	return g.checkPolicy(pj, phase, m, approver)
+}
+
+// isApprovalHeld returns true if a gate holds the approval, whose message is kept to be approved again later.
+func isApprovalHeld(err error) bool {
+	for _, held := range []error{ErrTwoPersonRule, ErrMigrationPending, ErrErrorBudgetExhausted, ErrPolicyDenied, ErrPreDeployHookFailed, ErrNotBusinessDay, deploy.ErrPinned} {
+		if errors.Is(err, held) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBusinessDay returns ErrNotBusinessDay if today is a weekend or a holiday in the calendar of the project.
+func (g DeployGate) checkBusinessDay(pj DeployProject, phase DeployPhase) error {
+	if !phase.BusinessDaysOnly {
+		return nil
+	}
+	now := time.Now
+	if g.now != nil {
+		now = g.now
+	}
+	calendar := pj.Calendar()
+	if t := now(); !calendar.IsBusinessDay(t) {
+		return fmt.Errorf("%w: %s %s allows the deploys only on the business days, and %s isn't one", ErrNotBusinessDay, pj.ID, phase.Name, calendar.In(t).Format("Mon 2006-01-02"))
+	}
+	return nil
+}
+
+// checkSLOGate returns ErrErrorBudgetExhausted if the error budget is exhausted, unless the approver may override it.
+func (g DeployGate) checkSLOGate(pj DeployProject, phase DeployPhase, m DeployMetadata, approver string) error {
+	if !phase.SLOGate.Enabled() {
+		return nil
+	}
+	err := g.errorBudgets.Check(pj, phase)
+	if err == nil {
+		return nil
+	}
+	gate := phase.SLOGate
+	if approver != "" && gate.canApprove(m.RequesterSlackIDs(), approver) {
+		log.Printf("[INFO] %s %s is approved by %s while %s", pj.ID, phase.Name, approver, err)
+		g.tracer.Record(m.TraceID, "approved by <@%s> while %s", approver, err)
+		return nil
+	}
+	if approver != "" && gate.requiresApproval() {
+		return fmt.Errorf("%w. It can be approved only by %s", err, gate.approvers())
+	}
+	return err
+}
+
+// checkPolicy returns ErrPolicyDenied if the deploy policy denies the deploy.
+func (g DeployGate) checkPolicy(pj DeployProject, phase DeployPhase, m DeployMetadata, approver string) error {
+	if !g.policy.Enabled() {
+		return nil
+	}
+	in := g.policy.Input(pj, phase, m)
+	in.Approver = approver
+	in.Auto = approver == ""
+	if err := g.policy.Evaluate(in); err != nil {
+		if approver != "" {
+			g.tracer.Record(m.TraceID, "the approval by <@%s> was %s", approver, err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeployGate_Check(t *testing.T) {
+	var inputs []PolicyInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input PolicyInput `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		inputs = append(inputs, body.Input)
+		if body.Input.Auto {
+			w.Write([]byte(`{"result": ["no AutoDeploy to production"]}`))
+			return
+		}
+		w.Write([]byte(`{"result": []}`))
+	}))
+	defer server.Close()
+
+	pj := DeployProject{ID: "myapp", Phases: []DeployPhase{{Name: "production", TwoPersonRule: true}}}
+	pl := &ProjectList{items: []DeployProject{pj}}
+	g := NewDeployGate(CatConfig{OPAURL: server.URL, OPAPolicyPath: defaultPolicyPath}, pl, nil, nil)
+	g.policy.httpClient = server.Client()
+	g.policy.now = func() time.Time { return time.Date(2024, 3, 8, 9, 30, 0, 0, time.UTC) }
+	g.policy.scanFindings = func(pj DeployProject, phase DeployPhase, tag string) (map[string]int64, error) { return nil, nil }
+
+	phase := pj.FindPhase("production")
+	m := DeployMetadata{Project: "myapp", Phase: "production", Tag: "v1.2.0", RequesterSlackID: "U1"}
+	require.True(t, errors.Is(g.Check(pj, phase, m, "U1"), ErrTwoPersonRule))
+	require.Empty(t, inputs)
+
+	require.NoError(t, g.Check(pj, phase, m, "U2"))
+	require.Equal(t, "U2", inputs[0].Approver)
+	require.False(t, inputs[0].Auto)
+
+	// AutoDeploy has no approver
+	require.True(t, errors.Is(g.Check(pj, phase, m, ""), ErrPolicyDenied))
+	require.True(t, inputs[1].Auto)
+}
+
+func TestDeployGate_CheckPinned(t *testing.T) {
+	coordinator := deploy.NewCoordinator("default", "gocat-test")
+	coordinator.UseClientset(fake.NewSimpleClientset())
+	pj := DeployProject{ID: "myapp", Phases: []DeployPhase{{Name: "production"}}}
+	g := NewDeployGate(CatConfig{}, &ProjectList{items: []DeployProject{pj}}, coordinator, nil)
+	m := DeployMetadata{Project: "myapp", Phase: "production", Tag: "v1.2.0", RequesterSlackID: "U1"}
+	require.NoError(t, g.Check(pj, pj.FindPhase("production"), m, "U2"))
+
+	// The approval of the deploy requested before the phase is pinned is blocked too
+	require.NoError(t, coordinator.Pin(context.Background(), "myapp", "production", "U3", "v1.1.0", "incident"))
+	require.True(t, errors.Is(g.Check(pj, pj.FindPhase("production"), m, "U2"), deploy.ErrPinned))
+}
+
+func TestDeployGate_CheckBusinessDay(t *testing.T) {
+	calendar, err := NewBusinessCalendar("Asia/Tokyo", "jp", []string{"2024-03-12"})
+	require.NoError(t, err)
+	pj := DeployProject{ID: "myapp", Phases: []DeployPhase{{Name: "production", BusinessDaysOnly: true}, {Name: "staging"}}, calendar: calendar}
+	g := NewDeployGate(CatConfig{}, &ProjectList{items: []DeployProject{pj}}, nil, nil)
+	m := DeployMetadata{Project: "myapp", Phase: "production", Tag: "v1.2.0", RequesterSlackID: "U1"}
+
+	for _, c := range []struct {
+		now      time.Time
+		business bool
+	}{
+		// Monday 09:00 in JST
+		{time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), true},
+		// Saturday in JST, though still Friday in UTC
+		{time.Date(2024, 3, 8, 16, 0, 0, 0, time.UTC), false},
+		// The extra holiday
+		{time.Date(2024, 3, 12, 3, 0, 0, 0, time.UTC), false},
+		// Vernal Equinox Day
+		{time.Date(2024, 3, 20, 3, 0, 0, 0, time.UTC), false},
+	} {
+		g.now = func() time.Time { return c.now }
+		err := g.Check(pj, pj.FindPhase("production"), m, "U2")
+		if c.business {
+			require.NoError(t, err, c.now)
+		} else {
+			require.True(t, errors.Is(err, ErrNotBusinessDay), c.now)
+			require.True(t, isApprovalHeld(err))
+		}
+		// The phases not restricted to the business days deploy anytime
+		require.NoError(t, g.Check(pj, pj.FindPhase("staging"), m, "U2"))
+	}
+}
+
+func TestIsApprovalHeld(t *testing.T) {
+	require.True(t, isApprovalHeld(fmt.Errorf("%w. It can be approved only by <@U0SRE>", ErrErrorBudgetExhausted)))
+	require.True(t, isApprovalHeld(deploy.ErrPinned))
+	require.False(t, isApprovalHeld(errors.New("unable to merge")))
+	require.False(t, isApprovalHeld(nil))
+}
+
+func TestDeployGate_CheckJenkins(t *testing.T) {
+	var inputs []PolicyInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input PolicyInput `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		inputs = append(inputs, body.Input)
+		w.Write([]byte(`{"result": ["no deploys today"]}`))
+	}))
+	defer server.Close()
+
+	pj := DeployProject{ID: "myapp", Kind: "jenkins", Phases: []DeployPhase{{Name: "production", Kind: "jenkins", TwoPersonRule: true}}}
+	pl := &ProjectList{items: []DeployProject{pj}}
+	g := NewDeployGate(CatConfig{OPAURL: server.URL, OPAPolicyPath: defaultPolicyPath}, pl, nil, nil)
+	g.policy.httpClient = server.Client()
+	g.policy.scanFindings = func(pj DeployProject, phase DeployPhase, tag string) (map[string]int64, error) { return nil, nil }
+	i := NewInteractorJenkins(InteractorContext{projectList: pl, gate: g})
+
+	blocks, err := i.Request(pj, "production", "feature_x", "U1", "C1")
+	require.NoError(t, err)
+	value := blocks[0].(*slack.SectionBlock).Accessory.ButtonElement.Value
+	require.Equal(t, "deploy_jenkins_approve|myapp_production_feature_x@U1", value)
+	params := strings.SplitN(value, "|", 2)[1]
+
+	// The requester can't approve the deploy they requested
+	_, err = i.ApproveInPlace(params, "U1", "C1", "")
+	require.True(t, errors.Is(err, ErrTwoPersonRule))
+	require.Empty(t, inputs)
+
+	_, err = i.ApproveInPlace(params, "U2", "C1", "")
+	require.True(t, errors.Is(err, ErrPolicyDenied))
+	require.Equal(t, "U1", inputs[0].Requester)
+	require.Equal(t, "U2", inputs[0].Approver)
+	require.Equal(t, "feature_x", inputs[0].Branch)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxDeploySlotWait is how long the deploys requested in Slack wait for a slot before they fail.
+const maxDeploySlotWait = 30 * time.Minute
+
+// DeployLimiter limits the deploys prepared at once, globally, per project, and per phase,
+// so that a surge of deploys, like the ones AutoDeploy finds all at once after a release, can't saturate the quotas of GitHub and the registries.
+//
+// The deploys requested in Slack wait for a slot up to maxDeploySlotWait, or fail right away if they can't wait, like Jenkins,
+// while AutoDeploy skips the phase until the next tick before it calls the registries and GitHub.
+// The methods are safe to call on nil, which limits nothing.
+type DeployLimiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// max is the maximum number of the deploys at once across all the projects. 0 means unlimited.
+	max int
+	// running is the number of the deploys running, keyed by "", the project ID, and "<project>/<phase>".
+	running map[string]int
+}
+
+func NewDeployLimiter(max int) *DeployLimiter {
+	l := &DeployLimiter{max: max, running: map[string]int{}}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// limits returns the keys of the slots the deploy of the phase takes, and their maximums, which are 0 if unlimited.
+func (l *DeployLimiter) limits(pj DeployProject, phase DeployPhase) map[string]int {
+	return map[string]int{
+		"":                       l.max,
+		pj.ID:                    pj.MaxConcurrentDeploys,
+		pj.ID + "/" + phase.Name: phase.MaxConcurrentDeploys,
+	}
+}
+
+// full returns the description of the limit reached, or an empty string if the deploy can take its slots. It's called with the lock held.
+func (l *DeployLimiter) full(pj DeployProject, phase DeployPhase) string {
+	for key, max := range l.limits(pj, phase) {
+		if max <= 0 || l.running[key] < max {
+			continue
+		}
+		switch key {
+		case "":
+			return fmt.Sprintf("%d deploys are running across all the projects (CONFIG_MAX_CONCURRENT_DEPLOYS)", max)
+		case pj.ID:
+			return fmt.Sprintf("%d deploys of %s are running (MaxConcurrentDeploys)", max, pj.ID)
+		default:
+			return fmt.Sprintf("%d deploys of %s %s are running (maxConcurrentDeploys)", max, pj.ID, phase.Name)
+		}
+	}
+	return ""
+}
+
+func (l *DeployLimiter) take(pj DeployProject, phase DeployPhase) func() {
+	for key := range l.limits(pj, phase) {
+		l.running[key]++
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for key := range l.limits(pj, phase) {
+				l.running[key]--
+			}
+			l.cond.Broadcast()
+		})
+	}
+}
+
+// Acquire waits for the slots of the deploy of the phase until ctx is done, and returns the function releasing them.
+// waiting is called once with the limit reached if the deploy has to wait.
+func (l *DeployLimiter) Acquire(ctx context.Context, pj DeployProject, phase DeployPhase, waiting func(reason string)) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	// Wakes up the wait below once ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.cond.Broadcast()
+			l.mu.Unlock()
+		case <-done:
+		}
+	}()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	notified := false
+	for reason := l.full(pj, phase); reason != ""; reason = l.full(pj, phase) {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("gave up waiting for a deploy slot as %s: %w", reason, err)
+		}
+		if !notified && waiting != nil {
+			notified = true
+			// waiting may post to Slack, which shouldn't block the others releasing their slots
+			l.mu.Unlock()
+			waiting(reason)
+			l.mu.Lock()
+			continue
+		}
+		l.cond.Wait()
+	}
+	return l.take(pj, phase), nil
+}
+
+// Full returns the limit the deploy of the phase would reach without taking the slots, or an empty string if it wouldn't.
+func (l *DeployLimiter) Full(pj DeployProject, phase DeployPhase) string {
+	if l == nil {
+		return ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.full(pj, phase)
+}
+
+// TryAcquire takes the slots of the deploy of the phase if they're available, and returns the function releasing them.
+// Otherwise, it returns the limit reached as the error.
+func (l *DeployLimiter) TryAcquire(pj DeployProject, phase DeployPhase) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if reason := l.full(pj, phase); reason != "" {
+		return nil, fmt.Errorf("the concurrency limit is reached: %s", reason)
+	}
+	return l.take(pj, phase), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployLimiter(t *testing.T) {
+	l := NewDeployLimiter(3)
+	pj := DeployProject{ID: "myapp", MaxConcurrentDeploys: 2}
+	production := DeployPhase{Name: "production", MaxConcurrentDeploys: 1}
+	staging := DeployPhase{Name: "staging"}
+
+	release, err := l.TryAcquire(pj, production)
+	require.NoError(t, err)
+	_, err = l.TryAcquire(pj, production)
+	require.ErrorContains(t, err, "1 deploys of myapp production are running")
+	releaseStaging, err := l.TryAcquire(pj, staging)
+	require.NoError(t, err)
+	_, err = l.TryAcquire(pj, staging)
+	require.ErrorContains(t, err, "2 deploys of myapp are running")
+	releaseOther, err := l.TryAcquire(DeployProject{ID: "api"}, staging)
+	require.NoError(t, err)
+	_, err = l.TryAcquire(DeployProject{ID: "web"}, staging)
+	require.ErrorContains(t, err, "3 deploys are running across all the projects")
+
+	// Acquire waits until the running deploy releases its slots
+	acquired := make(chan struct{})
+	var reasons []string
+	go func() {
+		release, err := l.Acquire(context.Background(), pj, production, func(reason string) { reasons = append(reasons, reason) })
+		require.NoError(t, err)
+		release()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	// Releasing twice releases once
+	release()
+	<-acquired
+	require.Len(t, reasons, 1)
+
+	// Acquire gives up once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	blocker, err := l.TryAcquire(pj, production)
+	require.NoError(t, err)
+	_, err = l.Acquire(ctx, pj, production, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	blocker()
+
+	releaseStaging()
+	releaseOther()
+	require.Equal(t, map[string]int{"": 0, "myapp": 0, "myapp/production": 0, "myapp/staging": 0, "api": 0, "api/staging": 0}, l.running)
+
+	var nilLimiter *DeployLimiter
+	release, err = nilLimiter.Acquire(context.Background(), pj, production, nil)
+	require.NoError(t, err)
+	release()
+}
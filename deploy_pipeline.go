@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// PipelineStep is a step of the deploy shown in the pipeline message.
+type PipelineStep string
+
+const (
+	PipelineStepPrepare PipelineStep = "Prepare"
+	PipelineStepApprove PipelineStep = "Approve"
+	PipelineStepMerge   PipelineStep = "Merge"
+	// PipelineStepSync is Argo Rollouts rolling the tag out, which is shown for the phases with the rollout.
+	PipelineStepSync PipelineStep = "Sync"
+	// PipelineStepVerify is the synthetic checks after the deploy, which is shown for the phases with them.
+	PipelineStepVerify PipelineStep = "Verify"
+	// PipelineStepDeploy is the deploy of the kinds with no pull request, like Jenkins, which start deploying once approved.
+	PipelineStepDeploy PipelineStep = "Deploy"
+)
+
+type PipelineStepStatus string
+
+const (
+	PipelineStepPending PipelineStepStatus = "pending"
+	PipelineStepRunning PipelineStepStatus = "running"
+	PipelineStepDone    PipelineStepStatus = "done"
+	PipelineStepFailed  PipelineStepStatus = "failed"
+	PipelineStepSkipped PipelineStepStatus = "skipped"
+)
+
+func (s PipelineStepStatus) emoji() string {
+	switch s {
+	case PipelineStepRunning:
+		return ":hourglass_flowing_sand:"
+	case PipelineStepDone:
+		return ":white_check_mark:"
+	case PipelineStepFailed:
+		return ":x:"
+	case PipelineStepSkipped:
+		return ":heavy_minus_sign:"
+	default:
+		return ":white_circle:"
+	}
+}
+
+// pipelineSteps returns the steps of the deploys of the phase.
+func pipelineSteps(phase DeployPhase) []PipelineStep {
+	steps := []PipelineStep{PipelineStepPrepare, PipelineStepApprove, PipelineStepMerge}
+	if phase.Rollout.Enabled() {
+		steps = append(steps, PipelineStepSync)
+	}
+	if phase.SyntheticChecks.Enabled() {
+		steps = append(steps, PipelineStepVerify)
+	}
+	return steps
+}
+
+// directPipelineSteps are the steps of the deploys of the kinds with no pull request, like Jenkins.
+var directPipelineSteps = []PipelineStep{PipelineStepApprove, PipelineStepDeploy}
+
+// DeployPipeline is the checklist of the steps of a deploy.
+type DeployPipeline struct {
+	TraceID  string
+	Project  string
+	Phase    string
+	Steps    []PipelineStep
+	Statuses map[PipelineStep]PipelineStepStatus
+	channel  string
+	// threadTS is the message of the deploy, like the approval message, whose thread the pipeline is posted in.
+	threadTS string
+	ts       string
+	// version is bumped on every change, and posted is the version last posted, so that only the latest one is posted.
+	version int
+	posted  int
+	// postMu serializes the posts of the pipeline, which are made without the lock of the board.
+	postMu *sync.Mutex
+}
+
+// set sets the status of the step, and starts the next pending step once the step is done or skipped.
+// It returns false if the pipeline has no such step.
+func (p *DeployPipeline) set(step PipelineStep, status PipelineStepStatus) bool {
+	for n, s := range p.Steps {
+		if s != step {
+			continue
+		}
+		p.Statuses[s] = status
+		if (status == PipelineStepDone || status == PipelineStepSkipped) && n+1 < len(p.Steps) && p.Statuses[p.Steps[n+1]] == PipelineStepPending {
+			p.Statuses[p.Steps[n+1]] = PipelineStepRunning
+		}
+		return true
+	}
+	return false
+}
+
+// apply updates the steps with the event of the lifecycle of the deploy.
+// The failure fails the last running step, which is Verify while Sync is still running,
+// and the skipped deploy skips the rest of the steps.
+func (p *DeployPipeline) apply(typ DeployEventType) bool {
+	switch typ {
+	case DeployEventAwaitingApproval:
+		return p.set(PipelineStepPrepare, PipelineStepDone)
+	case DeployEventApproved:
+		return p.set(PipelineStepApprove, PipelineStepDone)
+	case DeployEventDeployed:
+		return p.set(PipelineStepDeploy, PipelineStepDone)
+	case DeployEventFailed:
+		for n := len(p.Steps) - 1; n >= 0; n-- {
+			if p.Statuses[p.Steps[n]] == PipelineStepRunning {
+				return p.set(p.Steps[n], PipelineStepFailed)
+			}
+		}
+	case DeployEventSkipped:
+		changed := false
+		for _, s := range p.Steps {
+			if status := p.Statuses[s]; status == PipelineStepPending || status == PipelineStepRunning {
+				p.Statuses[s] = PipelineStepSkipped
+				changed = true
+			}
+		}
+		return changed
+	}
+	return false
+}
+
+// Text returns the checklist with the emoji of the status of each step.
+func (p *DeployPipeline) Text() string {
+	lines := []string{fmt.Sprintf("*Deploy pipeline of %s %s*", p.Project, p.Phase)}
+	var steps []string
+	for _, s := range p.Steps {
+		steps = append(steps, fmt.Sprintf("%s %s", p.Statuses[s].emoji(), s))
+	}
+	lines = append(lines, strings.Join(steps, "  →  "))
+	return strings.Join(lines, "\n") + traceLine(p.TraceID)
+}
+
+// DeployPipelineBoard posts the pipeline of each deploy to Slack as a checklist in the thread of the message of the deploy,
+// like the approval message, and keeps it updated as the deploy progresses.
+// The pipeline is posted once the message of the deploy is posted, which Thread tells the board.
+//
+// The pipelines are kept in memory for the latest deploys only, as the traces are,
+// so the deploys requested before gocat restarted are no longer updated.
+type DeployPipelineBoard struct {
+	client *slack.Client
+	mu     sync.Mutex
+	// pipelines are keyed by the trace ID of the deploy.
+	pipelines map[string]*DeployPipeline
+	order     []string
+}
+
+func NewDeployPipelineBoard(client *slack.Client) *DeployPipelineBoard {
+	return &DeployPipelineBoard{client: client, pipelines: map[string]*DeployPipeline{}}
+}
+
+// Show starts the pipeline of the deploy in the channel with the first step running.
+// It's posted once the thread of the deploy is known, and right away if threadTS is given.
+func (b *DeployPipelineBoard) Show(id, channel, threadTS, project, phase string, steps []PipelineStep) {
+	if b == nil || id == "" || len(steps) == 0 {
+		return
+	}
+	b.mu.Lock()
+	p := &DeployPipeline{TraceID: id, Project: project, Phase: phase, Steps: steps, Statuses: map[PipelineStep]PipelineStepStatus{}, channel: channel, threadTS: threadTS, version: 1, postMu: &sync.Mutex{}}
+	for _, s := range steps {
+		p.Statuses[s] = PipelineStepPending
+	}
+	p.Statuses[steps[0]] = PipelineStepRunning
+	b.pipelines[id] = p
+	b.order = append(b.order, id)
+	if n := len(b.order); n > maxDeployTraces {
+		for _, old := range b.order[:n-maxDeployTraces] {
+			delete(b.pipelines, old)
+		}
+		b.order = b.order[n-maxDeployTraces:]
+	}
+	b.mu.Unlock()
+	b.post(p)
+}
+
+// Thread posts the pipeline of the deploy in the thread of the message of the deploy, unless it's posted in another thread already.
+func (b *DeployPipelineBoard) Thread(id, channel, ts string) {
+	b.update(id, func(p *DeployPipeline) bool {
+		if p.threadTS != "" || ts == "" {
+			return false
+		}
+		p.channel, p.threadTS = channel, ts
+		return true
+	})
+}
+
+// Set sets the status of the step of the deploy, if the deploy has its pipeline shown and the step.
+func (b *DeployPipelineBoard) Set(id string, step PipelineStep, status PipelineStepStatus) {
+	b.update(id, func(p *DeployPipeline) bool { return p.set(step, status) })
+}
+
+// Apply updates the pipeline of the deploy with the event of its lifecycle.
+func (b *DeployPipelineBoard) Apply(id string, typ DeployEventType) {
+	b.update(id, func(p *DeployPipeline) bool { return p.apply(typ) })
+}
+
+// Get returns the copy of the pipeline of the deploy.
+func (b *DeployPipelineBoard) Get(id string) (DeployPipeline, bool) {
+	if b == nil {
+		return DeployPipeline{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.pipelines[id]
+	if !ok {
+		return DeployPipeline{}, false
+	}
+	c := *p
+	c.Statuses = map[PipelineStep]PipelineStepStatus{}
+	for s, status := range p.Statuses {
+		c.Statuses[s] = status
+	}
+	return c, true
+}
+
+func (b *DeployPipelineBoard) update(id string, f func(p *DeployPipeline) bool) {
+	if b == nil || id == "" {
+		return
+	}
+	b.mu.Lock()
+	p, ok := b.pipelines[id]
+	if !ok || !f(p) {
+		b.mu.Unlock()
+		return
+	}
+	p.version++
+	b.mu.Unlock()
+	b.post(p)
+}
+
+// post posts the message of the pipeline in the thread of the deploy, or updates it once posted.
+// The Slack calls are made without the lock of the board, one at a time per pipeline, each posting the latest version,
+// so that the last change is always the one shown.
+func (b *DeployPipelineBoard) post(p *DeployPipeline) {
+	if b.client == nil {
+		return
+	}
+	p.postMu.Lock()
+	defer p.postMu.Unlock()
+	b.mu.Lock()
+	channel, threadTS, ts, text, version := p.channel, p.threadTS, p.ts, p.Text(), p.version
+	upToDate := version == p.posted
+	b.mu.Unlock()
+	if channel == "" || threadTS == "" || upToDate {
+		return
+	}
+	option := slack.MsgOptionText(text, false)
+	var err error
+	if ts == "" {
+		_, ts, err = b.client.PostMessage(channel, option, slack.MsgOptionTS(threadTS))
+	} else {
+		_, _, _, err = b.client.UpdateMessage(channel, ts, option)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to post the pipeline of %s %s: %s", p.Project, p.Phase, err)
+		return
+	}
+	b.mu.Lock()
+	p.ts, p.posted = ts, version
+	b.mu.Unlock()
+}
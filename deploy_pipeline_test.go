@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployPipelineBoard(t *testing.T) {
+	tracer := NewDeployTracer()
+	tracer.pipelines = NewDeployPipelineBoard(nil)
+	phase := DeployPhase{Name: "production", SyntheticChecks: SyntheticCheckOption{Checks: []SyntheticCheck{{URL: "https://example.com/healthz"}}}}
+	require.Equal(t, []PipelineStep{PipelineStepPrepare, PipelineStepApprove, PipelineStepMerge, PipelineStepVerify}, pipelineSteps(phase))
+
+	id := tracer.Start("myapp", "production", "requested")
+	tracer.ShowPipeline(id, "C1", "", pipelineSteps(phase))
+	tracer.Emit(id, DeployEventAwaitingApproval, "opened the pull request")
+	tracer.Step(id, PipelineStepApprove, PipelineStepDone)
+	tracer.Step(id, PipelineStepMerge, PipelineStepDone)
+	// The phase has no Sync, so setting it does nothing
+	tracer.Step(id, PipelineStepSync, PipelineStepDone)
+
+	p, ok := tracer.pipelines.Get(id)
+	require.True(t, ok)
+	require.Equal(t, "*Deploy pipeline of myapp production*\n"+
+		":white_check_mark: Prepare  →  :white_check_mark: Approve  →  :white_check_mark: Merge  →  :hourglass_flowing_sand: Verify"+traceLine(id), p.Text())
+
+	tracer.Emit(id, DeployEventFailed, "synthetic checks failed")
+	p, _ = tracer.pipelines.Get(id)
+	require.Equal(t, PipelineStepFailed, p.Statuses[PipelineStepVerify])
+
+	// The deploy already deployed skips the rest of the steps
+	skipped := tracer.Start("myapp", "production", "requested")
+	tracer.ShowPipeline(skipped, "C1", "", pipelineSteps(phase))
+	tracer.Emit(skipped, DeployEventSkipped, "already deployed")
+	p, _ = tracer.pipelines.Get(skipped)
+	for _, s := range p.Steps {
+		require.Equal(t, PipelineStepSkipped, p.Statuses[s])
+	}
+
+	var nilTracer *DeployTracer
+	nilTracer.ShowPipeline(id, "C1", "", pipelineSteps(phase))
+	nilTracer.Step(id, PipelineStepMerge, PipelineStepDone)
+}
+
+func TestDeployPipelineBoard_Thread(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		mu.Lock()
+		calls = append(calls, r.URL.Path+" "+r.Form.Get("thread_ts")+" "+r.Form.Get("ts"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"channel":"C1","ts":"2.0"}`))
+	}))
+	defer server.Close()
+	board := NewDeployPipelineBoard(slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/")))
+
+	// The pipeline waits for the message of the deploy to be posted in its thread
+	board.Show("a1", "C1", "", "myapp", "production", pipelineSteps(DeployPhase{}))
+	board.Set("a1", PipelineStepPrepare, PipelineStepDone)
+	require.Empty(t, calls)
+
+	board.Thread("a1", "C1", "1.0")
+	board.Apply("a1", DeployEventApproved)
+	// Another thread never moves the pipeline posted already
+	board.Thread("a1", "C1", "3.0")
+	require.Equal(t, []string{"/chat.postMessage 1.0 ", "/chat.update  2.0"}, calls)
+}
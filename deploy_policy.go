@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrPolicyDenied is returned when the deploy policy denies the deploy.
+var ErrPolicyDenied = errors.New("denied by the deploy policy")
+
+// defaultPolicyPath is the deny rule of the gocat.deploy package, used unless CONFIG_OPA_POLICY_PATH is set.
+const defaultPolicyPath = "gocat/deploy/deny"
+
+// PolicyInput is the input of the deploy policy in Rego, like:
+//
+//	deny[msg] { input.phase == "production"; input.weekday == "Friday"; msg := "no production deploys on Friday" }
+type PolicyInput struct {
+	Project     string `json:"project"`
+	Phase       string `json:"phase"`
+	Branch      string `json:"branch"`
+	Tag         string `json:"tag"`
+	PreviousTag string `json:"previousTag"`
+	// Requester and Approver are the Slack user IDs, empty for AutoDeploy and the deploys requested before the requester was recorded.
+	Requester string `json:"requester"`
+	Approver  string `json:"approver"`
+	// Auto is true if AutoDeploy deploys it.
+	Auto bool `json:"auto"`
+	// Time is in RFC 3339 in the time zone of the project.
+	Time    string `json:"time"`
+	Weekday string `json:"weekday"`
+	Hour    int    `json:"hour"`
+	Holiday bool   `json:"holiday"`
+	// Vulnerabilities counts the ECR scan findings by severity, like CRITICAL. It's null if the findings can't be read.
+	Vulnerabilities map[string]int64 `json:"vulnerabilities"`
+}
+
+// DeployPolicy evaluates the deploy policy in OPA. It's disabled unless CONFIG_OPA_URL is set.
+type DeployPolicy struct {
+	// url is the URL of the data API of the rule, like http://opa:8181/v1/data/gocat/deploy/deny.
+	url        string
+	httpClient *http.Client
+	now        func() time.Time
+	// scanFindings returns the vulnerabilities of the image of the phase tagged with the tag.
+	scanFindings func(pj DeployProject, phase DeployPhase, tag string) (map[string]int64, error)
+}
+
+func NewDeployPolicy(config CatConfig) DeployPolicy {
+	p := DeployPolicy{
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		now:          time.Now,
+		scanFindings: ecrScanFindings,
+	}
+	if config.OPAURL != "" {
+		p.url = strings.TrimSuffix(config.OPAURL, "/") + "/v1/data/" + strings.Trim(config.OPAPolicyPath, "/")
+	}
+	return p
+}
+
+func ecrScanFindings(pj DeployProject, phase DeployPhase, tag string) (map[string]int64, error) {
+	q := pj.ImageTagQuery(phase, ImageTagVars{})
+	if q.repository() == "" {
+		// The phases with no image in ECR, like the ones of Lambda, have nothing scanned
+		return nil, nil
+	}
+	ecr, err := CreateECRInstance()
+	if err != nil {
+		return nil, err
+	}
+	return ecr.ImageScanFindings(q, tag)
+}
+
+func (p DeployPolicy) Enabled() bool {
+	return p.url != ""
+}
+
+// Input returns the input of the deploy.
+func (p DeployPolicy) Input(pj DeployProject, phase DeployPhase, m DeployMetadata) PolicyInput {
+	calendar := pj.Calendar()
+	now := calendar.In(p.now())
+	in := PolicyInput{
+		Project:     pj.ID,
+		Phase:       phase.Name,
+		Branch:      m.Branch,
+		Tag:         m.Tag,
+		PreviousTag: m.PreviousTag,
+		Requester:   m.RequesterSlackID,
+		Time:        now.Format(time.RFC3339),
+		Weekday:     now.Weekday().String(),
+		Hour:        now.Hour(),
+		Holiday:     calendar.IsHoliday(now),
+	}
+	if m.Tag != "" {
+		findings, err := p.scanFindings(pj, phase, m.Tag)
+		if err != nil {
+			log.Printf("[WARNING] Unable to read the vulnerabilities of %s %s %s for the deploy policy: %s", pj.ID, phase.Name, m.Tag, err)
+		}
+		in.Vulnerabilities = findings
+	}
+	return in
+}
+
+// Evaluate returns ErrPolicyDenied with the messages of the deny rule, failing closed if it can't be evaluated.
+func (p DeployPolicy) Evaluate(in PolicyInput) error {
+	if !p.Enabled() {
+		return nil
+	}
+	denied, err := p.query(in)
+	if err != nil {
+		return fmt.Errorf("%w: unable to evaluate the deploy policy of %s %s: %s", ErrPolicyDenied, in.Project, in.Phase, err)
+	}
+	if len(denied) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrPolicyDenied, strings.Join(denied, ", "))
+}
+
+// query returns the messages of the deny rule, which is either a set of strings or a boolean.
+func (p DeployPolicy) query(in PolicyInput) ([]string, error) {
+	b, err := json.Marshal(map[string]interface{}{"input": in})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.httpClient.Post(p.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		// Result is missing if the rule is undefined, which allows the deploy
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Result) == 0 {
+		return nil, nil
+	}
+	var denied bool
+	if err := json.Unmarshal(out.Result, &denied); err == nil {
+		if denied {
+			return []string{"the deploy is denied"}, nil
+		}
+		return nil, nil
+	}
+	var messages []string
+	if err := json.Unmarshal(out.Result, &messages); err != nil {
+		return nil, fmt.Errorf("the rule returned neither a set of strings nor a boolean: %s", out.Result)
+	}
+	return messages, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployPolicy_Evaluate(t *testing.T) {
+	var inputs []PolicyInput
+	result := `{"result": ["no production deploys on Friday"]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/data/gocat/deploy/deny" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Input PolicyInput `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		inputs = append(inputs, body.Input)
+		w.Write([]byte(result))
+	}))
+	defer server.Close()
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	pj := DeployProject{ID: "myapp", calendar: BusinessCalendar{Location: tokyo, holidays: map[string]bool{}}}
+	phase := DeployPhase{Name: "production"}
+	p := NewDeployPolicy(CatConfig{OPAURL: server.URL + "/", OPAPolicyPath: defaultPolicyPath})
+	p.httpClient = server.Client()
+	p.now = func() time.Time { return time.Date(2024, 3, 8, 9, 30, 0, 0, time.UTC) }
+	p.scanFindings = func(pj DeployProject, phase DeployPhase, tag string) (map[string]int64, error) {
+		return map[string]int64{"CRITICAL": 1}, nil
+	}
+
+	in := p.Input(pj, phase, DeployMetadata{Branch: "release/1.2", Tag: "v1.2.0", RequesterSlackID: "U1"})
+	in.Approver = "U2"
+	err = p.Evaluate(in)
+	require.True(t, errors.Is(err, ErrPolicyDenied))
+	require.Contains(t, err.Error(), "no production deploys on Friday")
+	require.Equal(t, PolicyInput{
+		Project:         "myapp",
+		Phase:           "production",
+		Branch:          "release/1.2",
+		Tag:             "v1.2.0",
+		Requester:       "U1",
+		Approver:        "U2",
+		Time:            "2024-03-08T18:30:00+09:00",
+		Weekday:         "Friday",
+		Hour:            18,
+		Vulnerabilities: map[string]int64{"CRITICAL": 1},
+	}, inputs[0])
+
+	// The undefined rule, the empty set, and false allow the deploy
+	for _, r := range []string{`{}`, `{"result": []}`, `{"result": false}`} {
+		result = r
+		require.NoError(t, p.Evaluate(in))
+	}
+	result = `{"result": true}`
+	require.True(t, errors.Is(p.Evaluate(in), ErrPolicyDenied))
+
+	// The policy that can't be evaluated denies the deploy
+	p.url = server.URL + "/broken"
+	require.True(t, errors.Is(p.Evaluate(in), ErrPolicyDenied))
+
+	require.NoError(t, NewDeployPolicy(CatConfig{}).Evaluate(in))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// DeployReasonPolicy requires the deploys of a phase, typically production, to have a reason.
+// The reason is stored in the deploy pull request body and its metadata, so that it's kept in the deploy history.
+type DeployReasonPolicy struct {
+	// Required makes deploys without a reason rejected.
+	Required bool `yaml:"required"`
+	// TicketPattern is the regexp of the ticket reference, like [A-Z]+-[0-9]+ for JIRA-1234,
+	// that the reason must contain.
+	TicketPattern string `yaml:"ticketPattern"`
+}
+
+// Validate returns an error if the reason doesn't satisfy the policy.
+func (p DeployReasonPolicy) Validate(reason string) error {
+	if !p.Required {
+		return nil
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required to deploy. Add `--reason <reason>` to the deploy command")
+	}
+	if p.TicketPattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(p.TicketPattern)
+	if err != nil {
+		return fmt.Errorf("invalid ticketPattern %q: %w", p.TicketPattern, err)
+	}
+	if !re.MatchString(reason) {
+		return fmt.Errorf("the reason must contain a ticket reference matching `%s`", p.TicketPattern)
+	}
+	return nil
+}
+
+var deployReasonPattern = regexp.MustCompile(`\s+--reason[= ]\s*(.+)$`)
+
+// parseDeployReason splits the --reason option off the command text, returning the text without it and the reason.
+// The reason can be quoted, like --reason "hotfix for JIRA-1234".
+func parseDeployReason(text string) (string, string) {
+	text = html.UnescapeString(text)
+	loc := deployReasonPattern.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return text, ""
+	}
+	reason := strings.TrimSpace(text[loc[2]:loc[3]])
+	reason = strings.Trim(reason, `"'“”`)
+	return text[:loc[0]], reason
+}
+
+// requestDeploy requests the deploy after validating its reason against the reasonPolicy of the phase,
+// and its tag, if specified, against the tagPolicy of the phase.
+// The options other than the branch are passed through only if the interactor is an OptionRequester.
+func requestDeploy(interactor DeployUsecase, pj DeployProject, phase string, option DeployOption, assigner string, channel string) ([]slack.Block, error) {
+	if err := pj.FindPhase(phase).ReasonPolicy.Validate(option.Reason); err != nil {
+		return nil, err
+	}
+	if option.Tag != "" {
+		if err := pj.FindPhase(phase).TagPolicy.Check(option.Tag); err != nil {
+			return nil, err
+		}
+	}
+	if option.Reason != "" {
+		log.Printf("[INFO] Deploy of %s %s is requested by %s for the reason: %s", pj.ID, phase, assigner, option.Reason)
+	}
+	if r, ok := interactor.(OptionRequester); ok {
+		return r.RequestWithOption(pj, phase, option, assigner, channel)
+	}
+	if option.Tag != "" || option.Reason != "" {
+		return nil, fmt.Errorf("deploying %s with a tag or a reason is not supported", pj.ID)
+	}
+	return interactor.Request(pj, phase, option.Branch, assigner, channel)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeployReason(t *testing.T) {
+	text, reason := parseDeployReason(`<@U0123> deploy api production --reason "hotfix for JIRA-1234"`)
+	assert.Equal(t, "<@U0123> deploy api production", text)
+	assert.Equal(t, "hotfix for JIRA-1234", reason)
+
+	text, reason = parseDeployReason(`<@U0123> deploy api production --reason=JIRA-1234`)
+	assert.Equal(t, "<@U0123> deploy api production", text)
+	assert.Equal(t, "JIRA-1234", reason)
+
+	text, reason = parseDeployReason(`<@U0123> deploy api production`)
+	assert.Equal(t, "<@U0123> deploy api production", text)
+	assert.Equal(t, "", reason)
+}
+
+func TestDeployReasonPolicy_Validate(t *testing.T) {
+	require.NoError(t, DeployReasonPolicy{}.Validate(""))
+	require.Error(t, DeployReasonPolicy{Required: true}.Validate(""))
+	require.NoError(t, DeployReasonPolicy{Required: true}.Validate("hotfix"))
+
+	policy := DeployReasonPolicy{Required: true, TicketPattern: `[A-Z]+-[0-9]+`}
+	require.Error(t, policy.Validate("hotfix"))
+	require.NoError(t, policy.Validate("hotfix for JIRA-1234"))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+)
+
+// retryActionPrefix is the prefix of the values of the Retry buttons of the failed deploys, like deploy_retry|1a2b3c4d.
+const retryActionPrefix = "deploy_retry|"
+
+// DeployRetry is the failed stage of a deploy, and the inputs the deploy resolved before the stage,
+// which the retry command and the Retry button run the stage again with.
+type DeployRetry struct {
+	// Stage is the failed stage, which is either PipelineStepPrepare or PipelineStepMerge.
+	Stage PipelineStep
+	// Kind is the kind of the interactor that retries the stage.
+	Kind    string
+	TraceID string
+	Project string
+	Phase   string
+	// Option is the option the deploy was prepared with, for retrying Prepare.
+	Option DeployOption
+	// PullRequestID and PullRequestNumber are the approved pull request, for retrying Merge.
+	PullRequestID     string
+	PullRequestNumber string
+}
+
+// retryBlocks returns the message of the failed stage with the Retry button.
+func retryBlocks(text string, id string) []slack.Block {
+	txt := slack.NewTextBlockObject("mrkdwn", text, false, false)
+	btn := slack.NewButtonBlockElement("", retryActionPrefix+id, slack.NewTextBlockObject("plain_text", "Retry", false, false))
+	return []slack.Block{slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))}
+}
+
+// retryDeploy runs the failed stage of the deploy again, for the retry command and the Retry button.
+// The deploy is checked against the emergency-stop and the lock of the phase again, as they may have changed since it failed.
+func retryDeploy(tracer *DeployTracer, factory *InteractorFactory, coordinator *deploy.Coordinator, id string, userID string, channel string) ([]slack.Block, error) {
+	trace, ok := tracer.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("trace %s not found. Traces are kept for the latest %d deploys since gocat started", id, maxDeployTraces)
+	}
+	if err := checkDeployable(coordinator, trace.Project, trace.Phase); err != nil {
+		return nil, err
+	}
+	r, ok := tracer.TakeRetry(id)
+	if !ok {
+		return nil, fmt.Errorf("the deploy %s has no failed stage to retry. Only the failed Prepare and Merge of the latest attempt can be retried", id)
+	}
+	retrier, ok := factory.get(r.Kind).(Retrier)
+	if !ok {
+		return nil, fmt.Errorf("the deploys of %s can't be retried", r.Kind)
+	}
+	log.Printf("[INFO] %s of the deploy %s of %s %s is retried by %s", r.Stage, id, r.Project, r.Phase, userID)
+	return retrier.Retry(r, userID, channel)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployTracer_TakeRetry(t *testing.T) {
+	tracer := NewDeployTracer()
+	id := tracer.Start("myapp", "production", "requested")
+	tracer.SetRetry(id, DeployRetry{Stage: PipelineStepPrepare, Kind: "kustomize", Project: "myapp", Phase: "production", Option: DeployOption{Tag: "v1.2.3"}})
+
+	// The stage can be retried only after the deploy failed
+	_, ok := tracer.TakeRetry(id)
+	require.False(t, ok)
+
+	tracer.Emit(id, DeployEventFailed, "failed to prepare")
+	r, ok := tracer.TakeRetry(id)
+	require.True(t, ok)
+	require.Equal(t, DeployRetry{Stage: PipelineStepPrepare, Kind: "kustomize", TraceID: id, Project: "myapp", Phase: "production", Option: DeployOption{Tag: "v1.2.3"}}, r)
+
+	// It's retried only once at a time
+	_, ok = tracer.TakeRetry(id)
+	require.False(t, ok)
+
+	var nilTracer *DeployTracer
+	nilTracer.SetRetry(id, r)
+	_, ok = nilTracer.TakeRetry(id)
+	require.False(t, ok)
+}
+
+func TestRetryDeploy(t *testing.T) {
+	tracer := NewDeployTracer()
+	factory := &InteractorFactory{}
+
+	_, err := retryDeploy(tracer, factory, nil, "1a2b3c4d", "U1", "C1")
+	require.EqualError(t, err, "trace 1a2b3c4d not found. Traces are kept for the latest 200 deploys since gocat started")
+
+	id := tracer.Start("myapp", "production", "requested")
+	tracer.Emit(id, DeployEventFailed, "failed to prepare")
+	_, err = retryDeploy(tracer, factory, nil, id, "U1", "C1")
+	require.EqualError(t, err, "the deploy "+id+" has no failed stage to retry. Only the failed Prepare and Merge of the latest attempt can be retried")
+
+	tracer.SetRetry(id, DeployRetry{Stage: PipelineStepPrepare, Kind: "jenkins", Project: "myapp", Phase: "production"})
+	_, err = retryDeploy(tracer, factory, nil, id, "U1", "C1")
+	require.EqualError(t, err, "the deploys of jenkins can't be retried")
+}
+
+func TestRetryBlocks(t *testing.T) {
+	blocks := retryBlocks("failed to prepare", "1a2b3c4d")
+	require.Len(t, blocks, 1)
+	section := blocks[0].(*slack.SectionBlock)
+	require.Equal(t, "failed to prepare", section.Text.Text)
+	require.Equal(t, "deploy_retry|1a2b3c4d", section.Accessory.ButtonElement.Value)
+	require.Equal(t, "Retry", section.Accessory.ButtonElement.Text.Text)
+}
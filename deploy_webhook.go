@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrPreDeployHookFailed is returned when a preDeploy webhook of the phase fails, which aborts the deploy.
+var ErrPreDeployHookFailed = errors.New("preDeploy hook failed")
+
+// defaultWebhookRetries is how many times a webhook is retried unless its retries is set.
+const defaultWebhookRetries = 2
+
+// webhookEnvPrefix is the prefix of the environment variables expanded in the headers of the webhooks,
+// so that the project config, editable by more people than the deployment of gocat, can't send the other secrets of gocat anywhere.
+const webhookEnvPrefix = "GOCAT_HOOK_"
+
+// PhaseHooks are the webhooks called before and after the deploys of a phase, like to freeze a feature flag or to notify an external system.
+type PhaseHooks struct {
+	// PreDeploy are called in order when the deploy is approved, before the direct commit is pushed, or before AutoDeploy deploys the phase.
+	// The deploy is aborted if any of them fails.
+	PreDeploy []DeployWebhook `yaml:"preDeploy"`
+	// PostDeploy are called in order after the deploy succeeds. Their failures are only logged.
+	PostDeploy []DeployWebhook `yaml:"postDeploy"`
+	// PreDeployCommands are run in order after PreDeploy, and abort the deploy if any fails, as PreDeploy do.
+	// Their output is posted to the Slack thread the deploy was requested in.
+	PreDeployCommands []HookCommand `yaml:"preDeployCommands"`
+	// PostDeployCommands are run in order after PostDeploy. Their output is posted as PreDeployCommands' is.
+	PostDeployCommands []HookCommand `yaml:"postDeployCommands"`
+}
+
+func (h PhaseHooks) validate() error {
+	for _, w := range append(append([]DeployWebhook{}, h.PreDeploy...), h.PostDeploy...) {
+		if w.URL == "" {
+			return fmt.Errorf("the webhook %q has no url", w.Name)
+		}
+		if w.Retries < 0 {
+			return fmt.Errorf("the webhook %s has negative retries", w.name())
+		}
+		for k, v := range w.Headers {
+			if names := webhookHeaderEnvNames(v); len(names) > 0 {
+				return fmt.Errorf("the header %s of the webhook %s refers to %s, which must be prefixed with %s", k, w.name(), strings.Join(names, ", "), webhookEnvPrefix)
+			}
+		}
+	}
+	for _, c := range append(append([]HookCommand{}, h.PreDeployCommands...), h.PostDeployCommands...) {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeployWebhook is a webhook of a phase.
+type DeployWebhook struct {
+	Name string `yaml:"name"`
+	// URL is a Go template rendered with WebhookVars, like https://flags.example.com/api/{{.Project}}/freeze.
+	URL string `yaml:"url"`
+	// Method defaults to POST.
+	Method string `yaml:"method"`
+	// Headers are the headers of the request, like Authorization.
+	// $GOCAT_HOOK_VAR and ${GOCAT_HOOK_VAR} in their values are expanded with the environment variables of gocat
+	// prefixed with GOCAT_HOOK_ so that the tokens stay out of the project config. The other variables aren't allowed.
+	Headers map[string]string `yaml:"headers"`
+	// Body is the JSON body of the request. It's a Go template rendered with WebhookVars,
+	// like {"project": "{{.Project}}", "tag": "{{.Tag}}"}.
+	Body string `yaml:"body"`
+	// Status is the status the webhook expects. Any 2xx is expected if zero.
+	Status int `yaml:"status"`
+	// Retries is how many times the request is retried until it responds with the expected status. Defaults to 2.
+	Retries int `yaml:"retries"`
+}
+
+func (w DeployWebhook) name() string {
+	if w.Name != "" {
+		return w.Name
+	}
+	return w.URL
+}
+
+func (w DeployWebhook) method() string {
+	if w.Method == "" {
+		return http.MethodPost
+	}
+	return strings.ToUpper(w.Method)
+}
+
+func (w DeployWebhook) retries() int {
+	if w.Retries == 0 {
+		return defaultWebhookRetries
+	}
+	return w.Retries
+}
+
+func (w DeployWebhook) expects(status int) bool {
+	if w.Status == 0 {
+		return status >= 200 && status < 300
+	}
+	return status == w.Status
+}
+
+// WebhookVars is the set of variables available in DeployWebhook.URL and DeployWebhook.Body.
+type WebhookVars struct {
+	// Event is either preDeploy or postDeploy.
+	Event       string
+	Project     string
+	Phase       string
+	Branch      string
+	PreviousTag string
+	Tag         string
+	Requester   string
+	TraceID     string
+}
+
+func (self WebhookVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+func newWebhookVars(event string, m DeployMetadata) WebhookVars {
+	return WebhookVars{
+		Event:       event,
+		Project:     m.Project,
+		Phase:       m.Phase,
+		Branch:      m.Branch,
+		PreviousTag: m.PreviousTag,
+		Tag:         m.Tag,
+		Requester:   m.Requester,
+		TraceID:     m.TraceID,
+	}
+}
+
+// DeployWebhookRunner calls the webhooks of the phases.
+type DeployWebhookRunner struct {
+	httpClient *http.Client
+	// interval is the interval the webhooks are retried at.
+	interval time.Duration
+}
+
+func NewDeployWebhookRunner() DeployWebhookRunner {
+	return DeployWebhookRunner{httpClient: &http.Client{Timeout: 30 * time.Second}, interval: 5 * time.Second}
+}
+
+// PreDeploy calls the preDeploy webhooks of the phase of the deploy, and returns ErrPreDeployHookFailed for the first one failing.
+func (r DeployWebhookRunner) PreDeploy(phase DeployPhase, m DeployMetadata) error {
+	vars := newWebhookVars("preDeploy", m)
+	for _, w := range phase.Hooks.PreDeploy {
+		if err := r.call(w, vars); err != nil {
+			return fmt.Errorf("%w: %s of %s %s: %s. The deploy is aborted", ErrPreDeployHookFailed, w.name(), m.Project, m.Phase, err)
+		}
+		log.Printf("[INFO] The preDeploy hook %s of %s %s succeeded", w.name(), m.Project, m.Phase)
+	}
+	return nil
+}
+
+// PostDeploy calls all the postDeploy webhooks of the phase of the deploy, and returns the errors of the ones failing.
+func (r DeployWebhookRunner) PostDeploy(phase DeployPhase, m DeployMetadata) error {
+	vars := newWebhookVars("postDeploy", m)
+	var errs []string
+	for _, w := range phase.Hooks.PostDeploy {
+		if err := r.call(w, vars); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", w.name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("postDeploy hooks of %s %s failed: %s", m.Project, m.Phase, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// call calls the webhook, retrying it until it responds with the expected status.
+func (r DeployWebhookRunner) call(w DeployWebhook, vars WebhookVars) error {
+	url, err := vars.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("unable to render the url: %w", err)
+	}
+	body, err := vars.Parse(w.Body)
+	if err != nil {
+		return fmt.Errorf("unable to render the body: %w", err)
+	}
+	if body != "" && !json.Valid([]byte(body)) {
+		return fmt.Errorf("the body rendered is invalid JSON: %s", body)
+	}
+	var last error
+	for i := 0; i <= w.retries(); i++ {
+		if i > 0 {
+			time.Sleep(r.interval)
+		}
+		if last = r.do(w, url, body); last == nil {
+			return nil
+		}
+		log.Printf("[WARNING] The webhook %s failed (%d/%d): %s", w.name(), i+1, w.retries()+1, last)
+	}
+	return last
+}
+
+func (r DeployWebhookRunner) do(w DeployWebhook, url, body string) error {
+	req, err := http.NewRequest(w.method(), url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, expandWebhookHeader(v))
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !w.expects(resp.StatusCode) {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		return fmt.Errorf("%s %s responded with %s: %s", w.method(), url, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// expandWebhookHeader expands the environment variables prefixed with webhookEnvPrefix in the value of the header,
+// leaving the others empty.
+func expandWebhookHeader(v string) string {
+	return os.Expand(v, func(name string) string {
+		if !strings.HasPrefix(name, webhookEnvPrefix) {
+			return ""
+		}
+		return os.Getenv(name)
+	})
+}
+
+// webhookHeaderEnvNames returns the names of the environment variables in the value of the header not allowed to be expanded.
+func webhookHeaderEnvNames(v string) []string {
+	var names []string
+	os.Expand(v, func(name string) string {
+		if !strings.HasPrefix(name, webhookEnvPrefix) {
+			names = append(names, name)
+		}
+		return ""
+	})
+	return names
+}
+
+// WebhookHook returns a PostDeployHook that calls the postDeploy webhooks of the phase, if any.
+func WebhookHook(projectList *ProjectList, runner DeployWebhookRunner) PostDeployHook {
+	return func(m DeployMetadata, prURL string) error {
+		phase := projectList.Find(m.Project).FindPhase(m.Phase)
+		return runner.PostDeploy(phase, m)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployWebhookRunner(t *testing.T) {
+	t.Setenv("GOCAT_HOOK_FLAG_TOKEN", "secret")
+	var bodies []string
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/freeze/myapp":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			b, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, r.Method+" "+string(b))
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r := DeployWebhookRunner{httpClient: server.Client()}
+	freeze := DeployWebhook{
+		Name:    "freeze",
+		URL:     server.URL + "/freeze/{{.Project}}",
+		Headers: map[string]string{"Authorization": "Bearer ${GOCAT_HOOK_FLAG_TOKEN}"},
+		Body:    `{"event": "{{.Event}}", "tag": "{{.Tag}}"}`,
+		Status:  http.StatusAccepted,
+	}
+	m := DeployMetadata{Project: "myapp", Phase: "production", Tag: "v1.2.0"}
+	phase := DeployPhase{Name: "production", Hooks: PhaseHooks{PreDeploy: []DeployWebhook{freeze}}}
+	require.NoError(t, phase.Hooks.validate())
+
+	// The webhook is retried until it responds with the expected status
+	require.NoError(t, r.PreDeploy(phase, m))
+	require.Equal(t, []string{`POST {"event": "preDeploy", "tag": "v1.2.0"}`}, bodies)
+
+	missing := DeployWebhook{URL: server.URL + "/missing", Retries: 1}
+	phase.Hooks.PreDeploy = append(phase.Hooks.PreDeploy, missing)
+	err := r.PreDeploy(phase, m)
+	require.True(t, errors.Is(err, ErrPreDeployHookFailed))
+	require.Contains(t, err.Error(), "404 Not Found")
+
+	phase.Hooks = PhaseHooks{PostDeploy: []DeployWebhook{missing, freeze}}
+	err = r.PostDeploy(phase, m)
+	require.Error(t, err)
+	// The failing postDeploy hook doesn't prevent the others
+	require.Equal(t, `POST {"event": "postDeploy", "tag": "v1.2.0"}`, bodies[len(bodies)-1])
+
+	require.Error(t, PhaseHooks{PostDeploy: []DeployWebhook{{Name: "no url"}}}.validate())
+
+	// Only the variables for the hooks are expanded, so the other secrets of gocat never leave it
+	t.Setenv("GOCAT_GITHUB_ACCESS_TOKEN", "ghp_secret")
+	leak := DeployWebhook{Name: "leak", URL: server.URL, Headers: map[string]string{"Authorization": "Bearer $GOCAT_GITHUB_ACCESS_TOKEN"}}
+	require.EqualError(t, PhaseHooks{PreDeploy: []DeployWebhook{leak}}.validate(), "the header Authorization of the webhook leak refers to GOCAT_GITHUB_ACCESS_TOKEN, which must be prefixed with GOCAT_HOOK_")
+	require.Equal(t, "Bearer ", expandWebhookHeader("Bearer $GOCAT_GITHUB_ACCESS_TOKEN"))
+	require.Equal(t, "Bearer secret", expandWebhookHeader("Bearer ${GOCAT_HOOK_FLAG_TOKEN}"))
+}
@@ -31,6 +31,20 @@ func (self DestinationKustomize) GetCurrentRevision(input GetCurrentRevisionInpu
 	return "", nil
 }
 
+// GetCurrentDigest returns the digest of the image deployed, or an empty string if it's deployed by the tag only.
+func (self DestinationKustomize) GetCurrentDigest(input GetCurrentRevisionInput) (string, error) {
+	kf, err := input.github.GetKustomization(self.Path)
+	if err != nil {
+		return "", err
+	}
+	for _, image := range kf.Images {
+		if image.Name == self.Image {
+			return image.Digest, nil
+		}
+	}
+	return "", nil
+}
+
 type DestinationECS struct {
 	TaskDefinitionArn string `yaml:"taskDefinitionArn"`
 	Image             string `yaml:"image"`
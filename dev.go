@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// The dev mode, which gocat runs in with --dev, lets the contributors exercise the full deploy flows on their machines without any credentials.
+// gocat talks to the fakes served by the dev server instead of Slack, GitHub, ECR, and Kubernetes:
+//
+//   - DevSlack, which reads the commands from stdin or the web form at the URL of the dev server
+//   - DevGitHub on top of the local bare repository standing in for the manifest repository
+//   - DevRegistry, which has the images of CONFIG_DEV_IMAGES
+//   - the fake cluster, which has the project myapp with the phases staging and production, and the dev user bound to Developer and Admin
+//
+// The bare repository lives in a temporary directory, and gocat clones it in memory unless GOCAT_GITROOT is set, so every run starts from scratch.
+
+const (
+	defaultDevAddr = "127.0.0.1:3001"
+	devRegistry    = "000000000000.dkr.ecr.ap-northeast-1.amazonaws.com"
+	// devSeedTag is the tag the manifests of myapp start with, which no image in the dev registry has,
+	// so that the first deploy always changes the manifests.
+	devSeedTag = "0000000"
+)
+
+// DevEnvironment is the set of the fakes of the dev mode, served by the dev server at URL.
+type DevEnvironment struct {
+	URL string
+	Dir string
+	// Remote is the path to the bare repository standing in for the manifest repository.
+	Remote   string
+	Slack    *DevSlack
+	GitHub   *DevGitHub
+	Registry *DevRegistry
+	// Kubernetes is the fake cluster, which has the configmaps of the project and the users.
+	Kubernetes *fake.Clientset
+	server     *http.Server
+}
+
+// StartDevEnvironment starts the dev environment at addr for --dev, and sets the environment variables InitConfig reads to the fakes,
+// leaving the ones already set alone.
+func StartDevEnvironment(addr string) (*DevEnvironment, error) {
+	if addr == "" {
+		addr = defaultDevAddr
+	}
+	defaultBranch := os.Getenv("CONFIG_GITHUB_DEFAULT_BRANCH")
+	if defaultBranch == "" {
+		defaultBranch = "refs/heads/master"
+	}
+	images := os.Getenv("CONFIG_DEV_IMAGES")
+	if images == "" {
+		images = "myapp=master,1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b"
+	}
+	env, err := NewDevEnvironment(addr, defaultBranch, images, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range map[string]string{
+		"CONFIG_MANIFEST_REPOSITORY":      env.Remote,
+		"CONFIG_GITHUB_DEFAULT_BRANCH":    defaultBranch,
+		"CONFIG_GITHUB_ACCESS_TOKEN":      "dev",
+		"CONFIG_SLACK_OAUTH_TOKEN":        "dev",
+		"CONFIG_SLACK_VERIFICATION_TOKEN": "dev",
+		"CONFIG_JENKINS_BOT_TOKEN":        "dev",
+		"CONFIG_JENKINS_JOB_TOKEN":        "dev",
+	} {
+		if os.Getenv(k) == "" {
+			os.Setenv(k, v)
+		}
+	}
+	log.Printf("[INFO] Running in the dev mode. Type the commands, or open %s. The manifest repository is %s", env.URL, env.Remote)
+	return env, nil
+}
+
+// NewDevEnvironment seeds the fakes, and starts the dev server at addr, which may be 127.0.0.1:0 for a free port.
+// The images are in the format of CONFIG_DEV_IMAGES, and DevSlack prints the messages to out.
+//
+// gocat finds the fake cluster and the fake ECR through the package variables, so only one dev environment can be used at a time.
+func NewDevEnvironment(addr, defaultBranch string, images string, out io.Writer) (*DevEnvironment, error) {
+	registry := NewDevRegistry()
+	if err := registry.ParseDevImages(images); err != nil {
+		return nil, fmt.Errorf("CONFIG_DEV_IMAGES is invalid: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "gocat-dev")
+	if err != nil {
+		return nil, err
+	}
+	remote := filepath.Join(dir, "dev", "manifests.git")
+	if err := seedDevRemote(remote, plumbing.ReferenceName(defaultBranch)); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("unable to seed the manifest repository: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	env := &DevEnvironment{
+		URL:        "http://" + listener.Addr().String(),
+		Dir:        dir,
+		Remote:     remote,
+		Registry:   registry,
+		GitHub:     NewDevGitHub(remote, "manifests", defaultBranch, devUserName),
+		Kubernetes: fake.NewSimpleClientset(devConfigMaps()...),
+	}
+	env.Slack = NewDevSlack(env.URL, out, registry)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", env.Slack.ServeAPI)
+	mux.HandleFunc("/response/", env.Slack.ServeResponse)
+	mux.Handle("/github/", http.StripPrefix("/github", env.GitHub))
+	mux.Handle("/ecr", registry)
+	mux.Handle("/ecr/", registry)
+	mux.Handle("/", env.Slack.ServePage(env.GitHub))
+	env.server = &http.Server{Handler: mux}
+	go func() {
+		if err := env.server.Serve(listener); err != http.ErrServerClosed {
+			log.Printf("[ERROR] The dev server stopped: %s", err)
+		}
+	}()
+
+	devKubernetesClient = env.Kubernetes
+	ecrEndpoint = env.URL + "/ecr"
+	return env, nil
+}
+
+// Close stops the dev server, removes the bare repository, and makes gocat find the real cluster and ECR again.
+func (e *DevEnvironment) Close() error {
+	devKubernetesClient = nil
+	ecrEndpoint = ""
+	err := e.server.Close()
+	if rerr := os.RemoveAll(e.Dir); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// devConfigMaps returns the configmaps of the fake cluster, which are the project myapp and the dev user.
+func devConfigMaps() []runtime.Object {
+	configMap := func(name, t string, data map[string]string) runtime.Object {
+		return &v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:      name,
+				Namespace: configNamespace(),
+				Labels:    map[string]string{configMapTypeLabel: t},
+			},
+			Data: data,
+		}
+	}
+	return []runtime.Object{
+		configMap("myapp", "project", map[string]string{
+			"Kind":           "kustomize",
+			"Alias":          "myapp",
+			"DockerRegistry": devRegistry + "/myapp",
+			"Phases": `- name: staging
+  path: myapp/overlays/staging/kustomization.yaml
+- name: production
+  path: myapp/overlays/production/kustomization.yaml
+`,
+		}),
+		configMap("dev-githubuser-mapping", "githubuser-mapping", map[string]string{devUserName: devUserName}),
+		configMap("dev-rolebinding", "rolebinding", map[string]string{"Developer": devUserName, "Admin": devUserName}),
+	}
+}
+
+// seedDevRemote creates the bare repository at remote with the manifests of myapp on the default branch.
+func seedDevRemote(remote string, defaultBranch plumbing.ReferenceName) error {
+	bare, err := git.PlainInit(remote, true)
+	if err != nil {
+		return err
+	}
+	if err := bare.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, defaultBranch)); err != nil {
+		return err
+	}
+
+	fs := memfs.New()
+	r, err := git.Init(memory.NewStorage(), fs)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	for _, phase := range []string{"staging", "production"} {
+		path := fmt.Sprintf("myapp/overlays/%s/kustomization.yaml", phase)
+		kustomization := strings.Join([]string{
+			"kind: Kustomization",
+			"apiVersion: kustomize.config.k8s.io/v1beta1",
+			"images:",
+			"- name: " + devRegistry + "/myapp",
+			"  newTag: " + devSeedTag,
+			"",
+		}, "\n")
+		if err := util.WriteFile(fs, path, []byte(kustomization), 0644); err != nil {
+			return err
+		}
+		if _, err := w.Add(path); err != nil {
+			return err
+		}
+	}
+	hash, err := w.Commit("Add the manifests of myapp", &git.CommitOptions{Author: &object.Signature{Name: devUserName, When: time.Now()}})
+	if err != nil {
+		return err
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference(defaultBranch, hash)); err != nil {
+		return err
+	}
+	if _, err := r.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{remote}}); err != nil {
+		return err
+	}
+	return r.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("%s:%s", defaultBranch, defaultBranch))},
+	})
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/shurcooL/githubv4"
+)
+
+// DevGitHub is the fake GitHub of the dev mode, which serves the GraphQL queries and the REST endpoints gocat uses
+// on top of the local bare repository standing in for the manifest repository.
+//
+// The pull requests are kept in memory, and merging one fast-forwards the default branch of the repository to the head branch,
+// so that the next deploy sees the tag the merged one deployed. The other repositories, like the ones of the applications, are empty.
+type DevGitHub struct {
+	mu sync.Mutex
+	// remote is the path to the bare repository, and repo is its name gocat knows it by.
+	remote        string
+	repo          string
+	defaultBranch plumbing.ReferenceName
+	// user is the login of the only member of the organization, who is the dev user of DevSlack.
+	user         string
+	pullRequests map[string]*devPullRequest
+	numbers      int
+}
+
+type devPullRequest struct {
+	ID          string
+	Number      int
+	BaseRefName string
+	HeadRefName string
+	Title       string
+	Body        string
+	State       string
+}
+
+func NewDevGitHub(remote, repo, defaultBranch, user string) *DevGitHub {
+	if defaultBranch == "" {
+		defaultBranch = "refs/heads/master"
+	}
+	return &DevGitHub{remote: remote, repo: repo, defaultBranch: plumbing.ReferenceName(defaultBranch), user: user, pullRequests: map[string]*devPullRequest{}}
+}
+
+// CreateDevGitHubInstance is CreateGitHubInstance talking to the fake GitHub at apiURL instead of api.github.com.
+func CreateDevGitHubInstance(apiURL, org, repo, defaultBranch string) GitHub {
+	limiter := NewGitHubRateLimiter()
+	httpClient := &http.Client{Transport: &gitHubRateLimitTransport{base: devGitHubTransport{apiURL: apiURL}, limiter: limiter}}
+	client := githubv4.NewClient(httpClient)
+	return GitHub{client: *client, httpClient: httpClient, org: org, repo: repo, defaultBranch: defaultBranch, files: newGitHubFileCache(), rateLimiter: limiter}
+}
+
+// devGitHubTransport sends the requests to api.github.com to the fake GitHub.
+type devGitHubTransport struct {
+	apiURL string
+}
+
+func (t devGitHubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "api.github.com" {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	u, err := url.Parse(strings.TrimSuffix(t.apiURL, "/") + req.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = req.URL.RawQuery
+	r := req.Clone(req.Context())
+	r.URL = u
+	r.Host = u.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// PullRequests returns the pull requests opened so far, the newest first.
+func (g *DevGitHub) PullRequests() []devPullRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var prs []devPullRequest
+	for _, pr := range g.pullRequests {
+		prs = append(prs, *pr)
+	}
+	sort.Slice(prs, func(a, b int) bool { return prs[a].Number > prs[b].Number })
+	return prs
+}
+
+func (g *DevGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/graphql":
+		g.serveGraphQL(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/repos/") && strings.Contains(r.URL.Path, "/contents/"):
+		g.serveContents(w, r)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/repos/"):
+		// Tags and releases of the applications, which the dev mode has no repositories of
+		log.Printf("[INFO] The dev GitHub ignored POST %s", r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"sha": strings.Repeat("0", 40)})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveContents serves the raw file on the default branch, like GitHub.GetFile reads.
+func (g *DevGitHub) serveContents(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/repos/"), "/", 4)
+	if len(parts) < 4 || parts[1] != g.repo {
+		http.NotFound(w, r)
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	commit, err := g.commit(g.defaultBranch.String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := commit.File(strings.TrimPrefix(parts[3], "contents/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	content, err := f.Contents()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write([]byte(content))
+}
+
+func (g *DevGitHub) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.mu.Lock()
+	data, err := g.resolve(req.Query, req.Variables)
+	g.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Printf("[ERROR] The dev GitHub failed the query %s: %s", req.Query, err)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": nil, "errors": []map[string]string{{"message": err.Error()}}})
+		return
+	}
+	// The client fails on the fields it doesn't select, so the answer is pruned to the selection
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": parseDevSelection(req.Query).prune(data)})
+}
+
+type devObject = map[string]interface{}
+
+// devSelection is the tree of the fields a query selects, whose leaves are nil.
+// The fields of the inline fragments, like ... on Commit, are merged into the enclosing selection.
+type devSelection map[string]devSelection
+
+// parseDevSelection parses the selection of the query, skipping the arguments of the fields.
+func parseDevSelection(query string) devSelection {
+	var tokens []string
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '(':
+			// Skip the arguments, which may contain nested parentheses, braces, and strings
+			depth, quoted := 0, false
+			for ; i < len(query); i++ {
+				switch ch := query[i]; {
+				case quoted && ch == '\\':
+					i++
+				case ch == '"':
+					quoted = !quoted
+				case !quoted && ch == '(':
+					depth++
+				case !quoted && ch == ')':
+					depth--
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		case c == '{' || c == '}' || c == ':':
+			tokens = append(tokens, string(c))
+		case strings.HasPrefix(query[i:], "..."):
+			tokens = append(tokens, "...")
+			i += 2
+		case c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			j := i
+			for j < len(query) && (query[j] == '_' || query[j] == '$' || query[j] == '!' || query[j] >= '0' && query[j] <= '9' || query[j] >= 'A' && query[j] <= 'Z' || query[j] >= 'a' && query[j] <= 'z') {
+				j++
+			}
+			tokens = append(tokens, query[i:j])
+			i = j - 1
+		}
+	}
+	// The operation, like mutation($input:CreatePullRequestInput!), precedes the outermost selection
+	for i, t := range tokens {
+		if t == "{" {
+			sel, _ := parseDevSelectionSet(tokens, i)
+			return sel
+		}
+	}
+	return nil
+}
+
+func parseDevSelectionSet(tokens []string, i int) (devSelection, int) {
+	sel := devSelection{}
+	for i++; i < len(tokens) && tokens[i] != "}"; i++ {
+		if tokens[i] == "..." {
+			// ... on Type { fields }
+			for i < len(tokens) && tokens[i] != "{" {
+				i++
+			}
+			var fragment devSelection
+			fragment, i = parseDevSelectionSet(tokens, i)
+			sel.merge(fragment)
+			continue
+		}
+		name := tokens[i]
+		if i+2 < len(tokens) && tokens[i+1] == ":" {
+			// The alias is the key of the answer
+			i += 2
+		}
+		var child devSelection
+		if i+1 < len(tokens) && tokens[i+1] == "{" {
+			child, i = parseDevSelectionSet(tokens, i+1)
+		}
+		if sel[name] == nil {
+			sel[name] = child
+		} else {
+			sel[name].merge(child)
+		}
+	}
+	return sel, i
+}
+
+func (s devSelection) merge(o devSelection) {
+	for k, v := range o {
+		if s[k] == nil {
+			s[k] = v
+		} else {
+			s[k].merge(v)
+		}
+	}
+}
+
+// prune drops the fields of the answer the selection doesn't select.
+func (s devSelection) prune(v interface{}) interface{} {
+	if s == nil {
+		return v
+	}
+	switch v := v.(type) {
+	case devObject:
+		pruned := devObject{}
+		for k, sub := range s {
+			if value, ok := v[k]; ok {
+				pruned[k] = sub.prune(value)
+			}
+		}
+		return pruned
+	case []devObject:
+		pruned := make([]interface{}, len(v))
+		for i, e := range v {
+			pruned[i] = s.prune(e)
+		}
+		return pruned
+	case []interface{}:
+		pruned := make([]interface{}, len(v))
+		for i, e := range v {
+			pruned[i] = s.prune(e)
+		}
+		return pruned
+	}
+	return v
+}
+
+// resolve answers the query by the fields gocat selects in it, as the queries are generated from the structs in github.go.
+func (g *DevGitHub) resolve(query string, vars map[string]interface{}) (devObject, error) {
+	input, _ := vars["input"].(map[string]interface{})
+	str := func(m map[string]interface{}, key string) string {
+		s, _ := m[key].(string)
+		return s
+	}
+	switch {
+	case strings.Contains(query, "createPullRequest("):
+		g.numbers++
+		pr := &devPullRequest{
+			ID:          fmt.Sprintf("PR_dev_%d", g.numbers),
+			Number:      g.numbers,
+			BaseRefName: str(input, "baseRefName"),
+			HeadRefName: str(input, "headRefName"),
+			Title:       str(input, "title"),
+			Body:        str(input, "body"),
+			State:       "OPEN",
+		}
+		g.pullRequests[pr.ID] = pr
+		log.Printf("[INFO] The dev GitHub opened the pull request #%d %s from %s", pr.Number, pr.Title, pr.HeadRefName)
+		return devObject{"createPullRequest": devObject{"pullRequest": pr.object()}}, nil
+	case strings.Contains(query, "updatePullRequest("):
+		pr, err := g.pullRequest(str(input, "pullRequestId"))
+		if err != nil {
+			return nil, err
+		}
+		if title, ok := input["title"].(string); ok {
+			pr.Title = title
+		}
+		if body, ok := input["body"].(string); ok {
+			pr.Body = body
+		}
+		return devObject{"updatePullRequest": devObject{"pullRequest": devObject{"id": pr.ID}}}, nil
+	case strings.Contains(query, "mergePullRequest("):
+		pr, err := g.pullRequest(str(input, "pullRequestId"))
+		if err != nil {
+			return nil, err
+		}
+		if err := g.merge(pr); err != nil {
+			return nil, err
+		}
+		return devObject{"mergePullRequest": devObject{"pullRequest": devObject{"id": pr.ID}}}, nil
+	case strings.Contains(query, "closePullRequest("):
+		pr, err := g.pullRequest(str(input, "pullRequestId"))
+		if err != nil {
+			return nil, err
+		}
+		pr.State = "CLOSED"
+		log.Printf("[INFO] The dev GitHub closed the pull request #%d", pr.Number)
+		return devObject{"closePullRequest": devObject{"pullRequest": devObject{"id": pr.ID}}}, nil
+	case strings.Contains(query, "deleteRef("):
+		// The ID of the ref is its name. See the ref query below
+		if err := g.deleteRef(str(input, "refId")); err != nil {
+			return nil, err
+		}
+		return devObject{"deleteRef": devObject{"clientMutationId": ""}}, nil
+	case strings.Contains(query, "addComment("):
+		log.Printf("[INFO] The dev GitHub got the comment on %s: %s", str(input, "subjectId"), str(input, "body"))
+		return devObject{"addComment": devObject{"clientMutationId": ""}}, nil
+	case strings.Contains(query, "requestReviews("):
+		return devObject{"requestReviews": devObject{"pullRequest": devObject{"id": str(input, "pullRequestId")}}}, nil
+	case strings.Contains(query, "addLabelsToLabelable("), strings.Contains(query, "addProjectCard("):
+		return nil, fmt.Errorf("the labels and the project columns aren't supported by the dev GitHub")
+	case strings.Contains(query, "node(id:"):
+		pr, err := g.pullRequest(str(vars, "id"))
+		if err != nil {
+			return nil, err
+		}
+		return devObject{"node": pr.object()}, nil
+	case strings.Contains(query, "viewer{"):
+		return devObject{"viewer": devObject{"login": "gocat"}}, nil
+	case strings.Contains(query, "organization(login:"):
+		member := devObject{"id": "U_" + g.user, "login": g.user, "email": "", "organizationVerifiedDomainEmails": []string{}}
+		return devObject{"organization": devObject{"membersWithRole": devObject{
+			"nodes":    []devObject{member},
+			"pageInfo": devObject{"endCursor": "", "hasNextPage": false},
+		}}}, nil
+	case strings.Contains(query, "repository("):
+		repository, err := g.repository(query, vars)
+		if err != nil {
+			return nil, err
+		}
+		return devObject{"repository": repository}, nil
+	}
+	return nil, fmt.Errorf("the query isn't supported by the dev GitHub")
+}
+
+// repository answers the fields of the repository the query selects. The repositories other than the manifest repository are empty.
+func (g *DevGitHub) repository(query string, vars map[string]interface{}) (devObject, error) {
+	name, _ := vars["repo"].(string)
+	if name == "" {
+		name, _ = vars["name"].(string)
+	}
+	known := name == g.repo
+	switch {
+	case strings.Contains(query, "pullRequests("):
+		nodes := []devObject{}
+		for _, pr := range g.sortedPullRequests() {
+			if known && pr.State == "OPEN" {
+				nodes = append(nodes, pr.object())
+			}
+		}
+		return devObject{"pullRequests": devObject{"nodes": nodes}}, nil
+	case strings.Contains(query, "pullRequest(number:"):
+		number, _ := vars["number"].(float64)
+		for _, pr := range g.pullRequests {
+			if known && pr.Number == int(number) {
+				return devObject{"pullRequest": pr.object()}, nil
+			}
+		}
+		return nil, fmt.Errorf("the pull request #%d is not found", int(number))
+	case strings.Contains(query, "refs("):
+		nodes := []devObject{}
+		if known {
+			r, err := git.PlainOpen(g.remote)
+			if err != nil {
+				return nil, err
+			}
+			branches, err := r.Branches()
+			if err != nil {
+				return nil, err
+			}
+			_ = branches.ForEach(func(ref *plumbing.Reference) error {
+				nodes = append(nodes, devObject{"name": ref.Name().Short()})
+				return nil
+			})
+		}
+		return devObject{"refs": devObject{"nodes": nodes}}, nil
+	case strings.Contains(query, "ref(qualifiedName:"):
+		refName, _ := vars["branch"].(string)
+		if refName == "" {
+			refName, _ = vars["ref"].(string)
+		}
+		if !known {
+			return devObject{"ref": nil}, nil
+		}
+		commit, err := g.commit(refName)
+		if err != nil {
+			return devObject{"ref": nil}, nil
+		}
+		target := devObject{"commitUrl": fmt.Sprintf("https://github.com/%s/%s/commit/%s", "dev", g.repo, commit.Hash)}
+		if strings.Contains(query, "history(") {
+			target["history"] = devObject{"edges": g.history(commit)}
+		}
+		return devObject{"ref": devObject{"id": refName, "target": target}}, nil
+	case strings.Contains(query, "object(expression:"):
+		expr, _ := vars["rev"].(string)
+		if expr == "" {
+			expr, _ = vars["expression"].(string)
+		}
+		if !known {
+			return devObject{"object": nil}, nil
+		}
+		oid, err := g.resolveExpression(expr)
+		if err != nil {
+			return devObject{"object": nil}, nil
+		}
+		return devObject{"object": devObject{"oid": oid}}, nil
+	case strings.Contains(query, "labels("):
+		return devObject{"labels": devObject{"nodes": []devObject{}}}, nil
+	case strings.Contains(query, "milestones("):
+		return devObject{"milestones": devObject{"nodes": []devObject{}}}, nil
+	}
+	return devObject{"id": "R_dev_" + name}, nil
+}
+
+func (pr devPullRequest) object() devObject {
+	return devObject{
+		"id":          pr.ID,
+		"number":      pr.Number,
+		"baseRefName": pr.BaseRefName,
+		"headRefName": pr.HeadRefName,
+		"title":       pr.Title,
+		"body":        pr.Body,
+		"bodyHTML":    pr.Body,
+	}
+}
+
+func (g *DevGitHub) sortedPullRequests() []*devPullRequest {
+	var prs []*devPullRequest
+	for _, pr := range g.pullRequests {
+		prs = append(prs, pr)
+	}
+	sort.Slice(prs, func(a, b int) bool { return prs[a].Number > prs[b].Number })
+	return prs
+}
+
+func (g *DevGitHub) pullRequest(id string) (*devPullRequest, error) {
+	pr, ok := g.pullRequests[id]
+	if !ok {
+		return nil, fmt.Errorf("Could not resolve to a node with the global id of '%s'", id)
+	}
+	return pr, nil
+}
+
+// branchRef returns the full name of the branch, which gocat passes either as the short name or as the full one.
+func branchRef(name string) plumbing.ReferenceName {
+	if strings.HasPrefix(name, "refs/") {
+		return plumbing.ReferenceName(name)
+	}
+	return plumbing.NewBranchReferenceName(name)
+}
+
+func (g *DevGitHub) commit(refName string) (*object.Commit, error) {
+	r, err := git.PlainOpen(g.remote)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := r.Reference(branchRef(refName), true)
+	if err != nil {
+		return nil, err
+	}
+	return r.CommitObject(ref.Hash())
+}
+
+func (g *DevGitHub) history(commit *object.Commit) []devObject {
+	edges := []devObject{}
+	iter := object.NewCommitPreorderIter(commit, nil, nil)
+	_ = iter.ForEach(func(c *object.Commit) error {
+		if len(edges) == 100 {
+			return storer.ErrStop
+		}
+		edges = append(edges, devObject{"node": devObject{
+			"id":            c.Hash.String(),
+			"oid":           c.Hash.String(),
+			"message":       c.Message,
+			"committedDate": c.Committer.When.Format(time.RFC3339),
+		}})
+		return nil
+	})
+	return edges
+}
+
+// resolveExpression resolves the revision, or the file at the revision if the expression is like master:path/to/file.
+func (g *DevGitHub) resolveExpression(expr string) (string, error) {
+	r, err := git.PlainOpen(g.remote)
+	if err != nil {
+		return "", err
+	}
+	rev, path, isFile := strings.Cut(expr, ":")
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return "", err
+	}
+	if !isFile {
+		return hash.String(), nil
+	}
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return "", err
+	}
+	f, err := commit.File(path)
+	if err != nil {
+		return "", err
+	}
+	return f.Hash.String(), nil
+}
+
+// merge fast-forwards the base branch to the head branch of the pull request.
+// The pull requests that can't be fast-forwarded, like the ones behind the deploys merged after they were opened, are rejected.
+func (g *DevGitHub) merge(pr *devPullRequest) error {
+	if pr.State != "OPEN" {
+		return fmt.Errorf("the pull request #%d is not open", pr.Number)
+	}
+	r, err := git.PlainOpen(g.remote)
+	if err != nil {
+		return err
+	}
+	base, err := g.commit(pr.BaseRefName)
+	if err != nil {
+		return err
+	}
+	head, err := g.commit(pr.HeadRefName)
+	if err != nil {
+		return fmt.Errorf("the head branch %s of the pull request #%d is not found: %w", pr.HeadRefName, pr.Number, err)
+	}
+	if ok, err := base.IsAncestor(head); err != nil || !ok {
+		return fmt.Errorf("the pull request #%d can't be merged as %s has moved. The dev GitHub only fast-forwards", pr.Number, pr.BaseRefName)
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference(branchRef(pr.BaseRefName), head.Hash)); err != nil {
+		return err
+	}
+	pr.State = "MERGED"
+	log.Printf("[INFO] The dev GitHub merged the pull request #%d into %s", pr.Number, pr.BaseRefName)
+	return nil
+}
+
+func (g *DevGitHub) deleteRef(name string) error {
+	r, err := git.PlainOpen(g.remote)
+	if err != nil {
+		return err
+	}
+	return r.Storer.RemoveReference(branchRef(name))
+}
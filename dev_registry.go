@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DevRegistry is the in-memory image registry of the dev mode, which speaks the subset of the ECR API gocat uses to find the image tags.
+// The images are pushed by the !push command of DevSlack, or seeded by CONFIG_DEV_IMAGES.
+type DevRegistry struct {
+	mu sync.Mutex
+	// images are keyed by the repository name, like myapp, in the order they are pushed.
+	images map[string][]devImage
+}
+
+type devImage struct {
+	Tags     []string
+	Digest   string
+	PushedAt time.Time
+}
+
+func NewDevRegistry() *DevRegistry {
+	return &DevRegistry{images: map[string][]devImage{}}
+}
+
+// Push adds the image tagged with the tags to the repository, moving the tags from the images pushed before as a registry does.
+func (r *DevRegistry) Push(repo string, tags ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.images[repo] {
+		image := &r.images[repo][i]
+		var kept []string
+		for _, t := range image.Tags {
+			if !hasTag(tags, t) {
+				kept = append(kept, t)
+			}
+		}
+		image.Tags = kept
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%d", repo, strings.Join(tags, ","), time.Now().UnixNano())))
+	r.images[repo] = append(r.images[repo], devImage{Tags: tags, Digest: fmt.Sprintf("sha256:%x", sum), PushedAt: time.Now()})
+}
+
+// ParseDevImages pushes the images of CONFIG_DEV_IMAGES, which are separated by semicolons,
+// each of which is the repository and the tags of the image separated by commas, like myapp=master,1a2b3c4;myapp=v1.0.0.
+func (r *DevRegistry) ParseDevImages(s string) error {
+	for _, image := range strings.Split(s, ";") {
+		image = strings.TrimSpace(image)
+		if image == "" {
+			continue
+		}
+		repo, tags, ok := strings.Cut(image, "=")
+		if !ok || repo == "" || tags == "" {
+			return fmt.Errorf("invalid image %q, which needs to be like myapp=master,1a2b3c4", image)
+		}
+		r.Push(repo, strings.Split(tags, ",")...)
+	}
+	return nil
+}
+
+type devECRImageID struct {
+	ImageDigest string `json:"imageDigest,omitempty"`
+	ImageTag    string `json:"imageTag,omitempty"`
+}
+
+type devECRImageDetail struct {
+	RegistryID     string   `json:"registryId"`
+	RepositoryName string   `json:"repositoryName"`
+	ImageDigest    string   `json:"imageDigest"`
+	ImageTags      []string `json:"imageTags"`
+	ImagePushedAt  float64  `json:"imagePushedAt"`
+}
+
+// ServeHTTP serves DescribeImages of the ECR API, which is JSON-RPC with the operation in the X-Amz-Target header.
+func (r *DevRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	target := req.Header.Get("X-Amz-Target")
+	if !strings.HasSuffix(target, ".DescribeImages") {
+		devECRError(w, "UnsupportedOperation", fmt.Sprintf("%s isn't supported by the dev registry", target))
+		return
+	}
+	var input struct {
+		RegistryID     string          `json:"registryId"`
+		RepositoryName string          `json:"repositoryName"`
+		ImageIds       []devECRImageID `json:"imageIds"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		devECRError(w, "InvalidParameterException", err.Error())
+		return
+	}
+	r.mu.Lock()
+	images, ok := r.images[input.RepositoryName]
+	details := []devECRImageDetail{}
+	for _, image := range images {
+		if len(image.Tags) == 0 || !devImageMatches(image, input.ImageIds) {
+			continue
+		}
+		details = append(details, devECRImageDetail{
+			RegistryID:     input.RegistryID,
+			RepositoryName: input.RepositoryName,
+			ImageDigest:    image.Digest,
+			ImageTags:      image.Tags,
+			ImagePushedAt:  float64(image.PushedAt.UnixNano()) / 1e9,
+		})
+	}
+	r.mu.Unlock()
+	if !ok {
+		devECRError(w, "RepositoryNotFoundException", fmt.Sprintf("The repository %s doesn't exist in the dev registry. Push the images by !push", input.RepositoryName))
+		return
+	}
+	// ECR returns the images in no particular order, so we return the latest first as it often does
+	sort.SliceStable(details, func(a, b int) bool { return details[a].ImagePushedAt > details[b].ImagePushedAt })
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"imageDetails": details})
+}
+
+func devImageMatches(image devImage, ids []devECRImageID) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if id.ImageDigest == image.Digest || (id.ImageTag != "" && hasTag(image.Tags, id.ImageTag)) {
+			return true
+		}
+	}
+	return false
+}
+
+func devECRError(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"__type": code, "message": message})
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	devUserID    = "U0DEV"
+	devUserName  = "dev"
+	devBotUserID = "UGOCAT"
+	devChannelID = "CDEV"
+	devTeamID    = "TDEV"
+)
+
+// DevSlack is the fake Slack of the dev mode. It serves the subset of the Slack Web API gocat calls,
+// printing the messages gocat posts, and sends the commands read from stdin or the web form at its URL
+// to gocat as the mentions and the button clicks of the dev user.
+//
+// The buttons and the options of the selects in the messages are numbered, and !click <number> clicks them.
+type DevSlack struct {
+	mu sync.Mutex
+	// url is the URL of the dev server, which the response URLs of the interactions point to.
+	url      string
+	out      io.Writer
+	messages []*devMessage
+	choices  []devChoice
+	ts       int64
+	// token is the verification token of the events and the interactions DevSlack sends.
+	token        string
+	events       http.Handler
+	interactions http.Handler
+	registry     *DevRegistry
+}
+
+type devMessage struct {
+	Channel   string
+	TS        string
+	User      string
+	Text      string
+	Blocks    json.RawMessage
+	Ephemeral bool
+	Deleted   bool
+}
+
+// devChoice is a button, or an option of a select, in a message, which the dev user clicks by its number.
+type devChoice struct {
+	Number   int
+	Channel  string
+	TS       string
+	Type     string
+	ActionID string
+	BlockID  string
+	Label    string
+	Value    string
+}
+
+func NewDevSlack(url string, out io.Writer, registry *DevRegistry) *DevSlack {
+	return &DevSlack{url: url, out: out, registry: registry, ts: time.Now().Unix() * 1000000}
+}
+
+// Connect makes DevSlack send the events and the interactions to the handlers, which are the ones of /events and /interaction.
+func (s *DevSlack) Connect(token string, events, interactions http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token, s.events, s.interactions = token, events, interactions
+}
+
+func (s *DevSlack) nextTS() string {
+	s.ts++
+	return fmt.Sprintf("%d.%06d", s.ts/1000000, s.ts%1000000)
+}
+
+// ServeAPI serves the Slack Web API at /api/<method>.
+func (s *DevSlack) ServeAPI(w http.ResponseWriter, r *http.Request) {
+	params := url.Values{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err == nil {
+			params = r.MultipartForm.Value
+		}
+	} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		for k, v := range body {
+			if str, ok := v.(string); ok {
+				params.Set(k, str)
+			} else if b, err := json.Marshal(v); err == nil {
+				params.Set(k, string(b))
+			}
+		}
+	} else if err := r.ParseForm(); err == nil {
+		params = r.Form
+	}
+	resp := s.call(strings.TrimPrefix(r.URL.Path, "/api/"), params)
+	resp["ok"] = true
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *DevSlack) call(method string, params url.Values) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := map[string]interface{}{"id": devUserID, "name": devUserName, "profile": map[string]string{"display_name": devUserName, "real_name": devUserName, "email": devUserName + "@example.com"}}
+	switch method {
+	case "chat.postMessage", "chat.postEphemeral":
+		m := &devMessage{Channel: params.Get("channel"), TS: s.nextTS(), User: "gocat", Text: params.Get("text"), Blocks: json.RawMessage(params.Get("blocks")), Ephemeral: method == "chat.postEphemeral"}
+		s.post(m, "")
+		if m.Ephemeral {
+			return map[string]interface{}{"message_ts": m.TS}
+		}
+		return map[string]interface{}{"channel": m.Channel, "ts": m.TS}
+	case "chat.update":
+		if m := s.find(params.Get("channel"), params.Get("ts")); m != nil {
+			m.Text, m.Blocks = params.Get("text"), json.RawMessage(params.Get("blocks"))
+			s.post(m, "updated")
+		}
+		return map[string]interface{}{"channel": params.Get("channel"), "ts": params.Get("ts")}
+	case "chat.delete":
+		if m := s.find(params.Get("channel"), params.Get("ts")); m != nil {
+			m.Deleted = true
+		}
+		return map[string]interface{}{"channel": params.Get("channel"), "ts": params.Get("ts")}
+	case "chat.getPermalink":
+		return map[string]interface{}{"channel": params.Get("channel"), "permalink": s.url + "/#" + params.Get("message_ts")}
+	case "auth.test":
+		return map[string]interface{}{"url": s.url, "team": "dev", "user": "gocat", "team_id": devTeamID, "user_id": devBotUserID}
+	case "users.list":
+		bot := map[string]interface{}{"id": devBotUserID, "name": "gocat", "is_bot": true, "profile": map[string]string{"display_name": "gocat"}}
+		return map[string]interface{}{"members": []interface{}{user, bot}, "response_metadata": map[string]string{"next_cursor": ""}}
+	case "users.info":
+		return map[string]interface{}{"user": user}
+	case "conversations.open":
+		return map[string]interface{}{"channel": map[string]string{"id": devUserID}}
+	case "conversations.history":
+		return map[string]interface{}{"messages": []interface{}{}}
+	case "usergroups.list":
+		return map[string]interface{}{"usergroups": []interface{}{}}
+	case "files.upload":
+		fmt.Fprintf(s.out, "[%s] gocat uploaded %s\n", params.Get("channels"), params.Get("filename"))
+		return map[string]interface{}{"file": map[string]string{"id": "F" + s.nextTS()}}
+	case "views.open":
+		fmt.Fprintln(s.out, "gocat opened a modal, which the dev Slack doesn't support")
+	}
+	return map[string]interface{}{}
+}
+
+// ServeResponse serves the response URLs of the interactions at /response/<channel>/<ts>.
+func (s *DevSlack) ServeResponse(w http.ResponseWriter, r *http.Request) {
+	channel, ts, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/response/"), "/")
+	body, _ := io.ReadAll(r.Body)
+	var resp struct {
+		Text            string          `json:"text"`
+		Blocks          json.RawMessage `json:"blocks"`
+		ResponseType    string          `json:"response_type"`
+		ReplaceOriginal bool            `json:"replace_original"`
+		DeleteOriginal  bool            `json:"delete_original"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		// The close button responds with the JSON-like text in single quotes
+		resp.DeleteOriginal = strings.Contains(string(body), "delete_original")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.find(channel, ts)
+	switch {
+	case resp.DeleteOriginal && m != nil:
+		m.Deleted = true
+		fmt.Fprintf(s.out, "[%s %s] deleted\n", channel, ts)
+	case resp.ReplaceOriginal && m != nil:
+		m.Text, m.Blocks = resp.Text, resp.Blocks
+		s.post(m, "updated")
+	default:
+		s.post(&devMessage{Channel: channel, TS: s.nextTS(), User: "gocat", Text: resp.Text, Blocks: resp.Blocks, Ephemeral: resp.ResponseType != "in_channel"}, "")
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *DevSlack) find(channel, ts string) *devMessage {
+	for _, m := range s.messages {
+		if m.Channel == channel && m.TS == ts {
+			return m
+		}
+	}
+	return nil
+}
+
+// post adds the message, or updates it if it's already added, and prints it with its choices numbered. It's called with the lock held.
+func (s *DevSlack) post(m *devMessage, note string) {
+	if s.find(m.Channel, m.TS) == nil {
+		s.messages = append(s.messages, m)
+	}
+	header := fmt.Sprintf("[%s %s] %s", m.Channel, m.TS, m.User)
+	if m.Ephemeral {
+		header += " (only visible to you)"
+	}
+	if note != "" {
+		header += " " + note
+	}
+	lines, choices := renderDevBlocks(m.Blocks)
+	if m.Text != "" && len(lines) == 0 {
+		lines = []string{m.Text}
+	}
+	var labels []string
+	for _, c := range choices {
+		c.Number = len(s.choices) + 1
+		c.Channel, c.TS = m.Channel, m.TS
+		s.choices = append(s.choices, c)
+		labels = append(labels, fmt.Sprintf("[%d] %s", c.Number, c.Label))
+	}
+	fmt.Fprintln(s.out, header)
+	for _, l := range lines {
+		fmt.Fprintln(s.out, "  "+strings.ReplaceAll(l, "\n", "\n  "))
+	}
+	if len(labels) > 0 {
+		fmt.Fprintln(s.out, "  "+strings.Join(labels, "  "))
+	}
+}
+
+// choicesOf returns the choices of the latest rendering of the message, as the updated messages have their choices renumbered.
+func (s *DevSlack) choicesOf(m *devMessage) []devChoice {
+	_, rendered := renderDevBlocks(m.Blocks)
+	var choices []devChoice
+	for i := len(s.choices) - 1; i >= 0 && len(choices) < len(rendered); i-- {
+		if c := s.choices[i]; c.Channel == m.Channel && c.TS == m.TS {
+			choices = append([]devChoice{c}, choices...)
+		}
+	}
+	return choices
+}
+
+// renderDevBlocks returns the texts and the choices in the blocks, walking them generically so that any block gocat builds is rendered.
+func renderDevBlocks(raw json.RawMessage) ([]string, []devChoice) {
+	var blocks interface{}
+	if len(raw) == 0 || json.Unmarshal(raw, &blocks) != nil {
+		return nil, nil
+	}
+	var lines []string
+	var choices []devChoice
+	var blockID string
+	text := func(v interface{}) string {
+		if t, ok := v.(map[string]interface{}); ok {
+			s, _ := t["text"].(string)
+			return s
+		}
+		return ""
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch n := v.(type) {
+		case []interface{}:
+			for _, e := range n {
+				walk(e)
+			}
+		case map[string]interface{}:
+			if id, ok := n["block_id"].(string); ok {
+				blockID = id
+			}
+			actionID, _ := n["action_id"].(string)
+			switch n["type"] {
+			case "button":
+				value, _ := n["value"].(string)
+				choices = append(choices, devChoice{Type: "button", ActionID: actionID, BlockID: blockID, Label: text(n["text"]), Value: value})
+			case "static_select":
+				var options []interface{}
+				if o, ok := n["options"].([]interface{}); ok {
+					options = o
+				}
+				if groups, ok := n["option_groups"].([]interface{}); ok {
+					for _, g := range groups {
+						if o, ok := g.(map[string]interface{})["options"].([]interface{}); ok {
+							options = append(options, o...)
+						}
+					}
+				}
+				for _, o := range options {
+					option, _ := o.(map[string]interface{})
+					value, _ := option["value"].(string)
+					choices = append(choices, devChoice{Type: "static_select", ActionID: actionID, BlockID: blockID, Label: text(n["placeholder"]) + ": " + text(option["text"]), Value: value})
+				}
+			case "mrkdwn", "plain_text":
+				if s, _ := n["text"].(string); s != "" {
+					lines = append(lines, s)
+				}
+			default:
+				for _, key := range []string{"text", "fields", "elements", "accessory"} {
+					walk(n[key])
+				}
+			}
+		}
+	}
+	walk(blocks)
+	return lines, choices
+}
+
+// Mention sends the text to gocat as the mention of the dev user, and waits for gocat to handle it.
+func (s *DevSlack) Mention(text string) {
+	s.mu.Lock()
+	ts := s.nextTS()
+	s.post(&devMessage{Channel: devChannelID, TS: ts, User: devUserName, Text: text}, "")
+	events, token := s.events, s.token
+	s.mu.Unlock()
+	if events == nil {
+		return
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"token":   token,
+		"team_id": devTeamID,
+		"type":    "event_callback",
+		"event": map[string]interface{}{
+			"type":     "app_mention",
+			"user":     devUserID,
+			"text":     fmt.Sprintf("<@%s> %s", devBotUserID, text),
+			"ts":       ts,
+			"channel":  devChannelID,
+			"event_ts": ts,
+		},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(string(body)))
+	events.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+// Click clicks the choice of the number as the dev user, and waits for gocat to handle it.
+func (s *DevSlack) Click(number int) error {
+	s.mu.Lock()
+	if number < 1 || number > len(s.choices) {
+		s.mu.Unlock()
+		return fmt.Errorf("no such button: %d", number)
+	}
+	c := s.choices[number-1]
+	m := s.find(c.Channel, c.TS)
+	if m == nil || m.Deleted {
+		s.mu.Unlock()
+		return fmt.Errorf("the message of the button %d is deleted", number)
+	}
+	current := false
+	for _, cc := range s.choicesOf(m) {
+		current = current || cc.Number == number
+	}
+	if !current {
+		s.mu.Unlock()
+		return fmt.Errorf("the button %d is no longer in the message, which is updated", number)
+	}
+	message := map[string]interface{}{"type": "message", "ts": m.TS, "text": m.Text}
+	if len(m.Blocks) > 0 {
+		message["blocks"] = m.Blocks
+	}
+	interactions, token := s.interactions, s.token
+	s.mu.Unlock()
+	if interactions == nil {
+		return nil
+	}
+
+	action := map[string]interface{}{"type": c.Type, "action_id": c.ActionID, "block_id": c.BlockID, "action_ts": c.TS}
+	if c.Type == "static_select" {
+		action["selected_option"] = map[string]interface{}{"value": c.Value, "text": map[string]string{"type": "plain_text", "text": c.Label}}
+	} else {
+		action["value"] = c.Value
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"token":        token,
+		"trigger_id":   "dev",
+		"user":         map[string]string{"id": devUserID, "name": devUserName},
+		"team":         map[string]string{"id": devTeamID},
+		"channel":      map[string]string{"id": c.Channel},
+		"container":    map[string]interface{}{"type": "message", "channel_id": c.Channel, "message_ts": c.TS, "is_ephemeral": m.Ephemeral},
+		"message":      message,
+		"response_url": fmt.Sprintf("%s/response/%s/%s", s.url, c.Channel, c.TS),
+		"actions":      []interface{}{action},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/interaction", strings.NewReader(url.Values{"payload": {string(payload)}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	interactions.ServeHTTP(httptest.NewRecorder(), req)
+	return nil
+}
+
+const devSlackHelp = `The dev mode commands:
+  <command>                   mentions gocat with the command, like: deploy myapp staging
+  !click <number>             clicks the button numbered in the messages
+  !push <repository> <tags>   pushes the image tagged with the comma-separated tags to the dev registry, like: !push myapp master,1a2b3c4
+  !help                       shows this help`
+
+// Run runs the line of stdin or the web form, which is either a dev mode command starting with ! or a command to mention gocat with.
+func (s *DevSlack) Run(line string) {
+	line = strings.TrimSpace(line)
+	fields := strings.Fields(line)
+	switch {
+	case line == "":
+	case fields[0] == "!help":
+		fmt.Fprintln(s.out, devSlackHelp)
+	case fields[0] == "!click":
+		n := 0
+		if len(fields) == 2 {
+			n, _ = strconv.Atoi(fields[1])
+		}
+		if err := s.Click(n); err != nil {
+			fmt.Fprintln(s.out, err)
+		}
+	case fields[0] == "!push":
+		if len(fields) != 3 {
+			fmt.Fprintln(s.out, "usage: !push <repository> <tags>")
+			return
+		}
+		s.registry.Push(fields[1], strings.Split(fields[2], ",")...)
+		fmt.Fprintf(s.out, "Pushed %s:%s to the dev registry\n", fields[1], fields[2])
+	case strings.HasPrefix(fields[0], "!"):
+		fmt.Fprintf(s.out, "Unknown command %s\n%s\n", fields[0], devSlackHelp)
+	default:
+		s.Mention(line)
+	}
+}
+
+// ReadCommands runs the lines read from r until it's closed.
+func (s *DevSlack) ReadCommands(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		s.Run(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("[ERROR] Failed to read the commands: %s", err)
+	}
+}
+
+var devSlackPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>gocat dev</title>
+<style>body{font-family:sans-serif;max-width:60em;margin:auto} .m{border-bottom:1px solid #ddd;padding:.5em 0} .e{color:#777} pre{white-space:pre-wrap;margin:.2em 0} form{display:inline}</style>
+</head>
+<body>
+<h1>gocat dev</h1>
+{{range .Messages}}<div class="m{{if .Ephemeral}} e{{end}}">
+<b>{{.User}}</b> <small>{{.Channel}} {{.TS}}{{if .Ephemeral}} (only visible to you){{end}}</small>
+{{range .Lines}}<pre>{{.}}</pre>{{end}}
+{{range .Choices}}<form method="post" action="/click"><input type="hidden" name="number" value="{{.Number}}"><button>{{.Label}}</button></form> {{end}}
+</div>{{end}}
+<form method="post" action="/command" style="display:block;margin-top:1em">
+<input name="text" size="60" autofocus placeholder="deploy myapp staging, or !push myapp master,1a2b3c4"> <button>Send</button> <a href="/">Refresh</a>
+</form>
+{{if .PullRequests}}<h2>Pull requests</h2><ul>{{range .PullRequests}}<li>#{{.Number}} {{.Title}} ({{.State}})</li>{{end}}</ul>{{end}}
+</body>
+</html>
+`))
+
+// devRenderedMessage is a message as the dev user sees it, with the texts and the current choices rendered from the blocks.
+type devRenderedMessage struct {
+	devMessage
+	Lines   []string
+	Choices []devChoice
+}
+
+// Messages returns the messages not deleted, the oldest first.
+func (s *DevSlack) Messages() []devRenderedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []devRenderedMessage
+	for _, m := range s.messages {
+		if m.Deleted {
+			continue
+		}
+		lines, _ := renderDevBlocks(m.Blocks)
+		if len(lines) == 0 && m.Text != "" {
+			lines = []string{m.Text}
+		}
+		messages = append(messages, devRenderedMessage{devMessage: *m, Lines: lines, Choices: s.choicesOf(m)})
+	}
+	return messages
+}
+
+// ServePage serves the web form at / listing the messages, and runs the commands and the clicks posted from it.
+func (s *DevSlack) ServePage(github *DevGitHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/command":
+			s.runAndRedirect(w, r, r.FormValue("text"))
+			return
+		case r.Method == http.MethodPost && r.URL.Path == "/click":
+			s.runAndRedirect(w, r, "!click "+r.FormValue("number"))
+			return
+		case r.URL.Path != "/":
+			http.NotFound(w, r)
+			return
+		}
+		data := map[string]interface{}{"Messages": s.Messages()}
+		if github != nil {
+			data["PullRequests"] = github.PullRequests()
+		}
+		if err := devSlackPage.Execute(w, data); err != nil {
+			log.Printf("[ERROR] Failed to render the dev page: %s", err)
+		}
+	}
+}
+
+// runAndRedirect runs the line, waiting a while for gocat to respond so that the page shows the response, and redirects to the page.
+func (s *DevSlack) runAndRedirect(w http.ResponseWriter, r *http.Request, line string) {
+	done := make(chan struct{})
+	go func() {
+		s.Run(line)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
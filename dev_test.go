@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevGitHub(t *testing.T) {
+	remote := filepath.Join(t.TempDir(), "manifests.git")
+	require.NoError(t, seedDevRemote(remote, "refs/heads/master"))
+
+	// gocat pushes the branch of the deploy
+	fs := memfs.New()
+	r, err := git.Clone(memory.NewStorage(), fs, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, w.Checkout(&git.CheckoutOptions{Branch: "refs/heads/bot/deploy", Create: true}))
+	require.NoError(t, util.WriteFile(fs, "myapp/overlays/staging/kustomization.yaml", []byte("newTag: 1a2b3c4\n"), 0644))
+	_, err = w.Add("myapp/overlays/staging/kustomization.yaml")
+	require.NoError(t, err)
+	hash, err := w.Commit("deploy", &git.CommitOptions{Author: &object.Signature{Name: "gocat", When: time.Now()}})
+	require.NoError(t, err)
+	require.NoError(t, r.Push(&git.PushOptions{RefSpecs: []gitconfig.RefSpec{"refs/heads/bot/deploy:refs/heads/bot/deploy"}}))
+
+	dev := NewDevGitHub(remote, "manifests", "refs/heads/master", "dev")
+	server := httptest.NewServer(http.StripPrefix("/github", dev))
+	defer server.Close()
+	github := CreateDevGitHubInstance(server.URL+"/github", "dev", "manifests", "refs/heads/master")
+
+	prID, number, err := github.CreatePullRequest("bot/deploy", "Deploy myapp", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, number)
+	require.NoError(t, github.MergePullRequest(prID))
+
+	// Merging fast-forwards the default branch, which the next deploy reads
+	bare, err := git.PlainOpen(remote)
+	require.NoError(t, err)
+	ref, err := bare.Reference("refs/heads/master", true)
+	require.NoError(t, err)
+	require.Equal(t, hash, ref.Hash())
+	b, err := github.GetFile("myapp/overlays/staging/kustomization.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "newTag: 1a2b3c4\n", string(b))
+	require.Equal(t, "MERGED", dev.PullRequests()[0].State)
+}
+
+func TestDevRegistry(t *testing.T) {
+	registry := NewDevRegistry()
+	require.NoError(t, registry.ParseDevImages("myapp=master,1a2b3c4"))
+	require.Error(t, registry.ParseDevImages("myapp"))
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	defer func(e string) { ecrEndpoint = e }(ecrEndpoint)
+	ecrEndpoint = server.URL
+	ecr, err := CreateECRInstance()
+	require.NoError(t, err)
+	q := ImageTagQuery{Image: devRegistry + "/myapp", FilterRegexp: "^master$", TargetRegexp: "^[0-9a-f]{7}$"}
+	tag, err := ecr.FindImageTag(q)
+	require.NoError(t, err)
+	require.Equal(t, "1a2b3c4", tag)
+
+	// The tags move to the image pushed later
+	registry.Push("myapp", "master", "5d6e7f8")
+	tag, err = ecr.FindImageTag(q)
+	require.NoError(t, err)
+	require.Equal(t, "5d6e7f8", tag)
+
+	_, err = ecr.FindImageTag(ImageTagQuery{Image: devRegistry + "/unknown", FilterRegexp: "^master$"})
+	require.Error(t, err)
+}
+
+func TestDevSelection(t *testing.T) {
+	sel := parseDevSelection(`mutation($input:CreatePullRequestInput!){createPullRequest(input:$input){pullRequest{id,number,commits(last: 1){nodes{commit{...on Commit{oid}}}}}}}`)
+	data := devObject{"createPullRequest": devObject{"pullRequest": devObject{
+		"id":       "PR_1",
+		"number":   1,
+		"bodyHTML": "unselected",
+		"commits":  devObject{"nodes": []devObject{{"commit": devObject{"oid": "abc", "url": "unselected"}}}},
+	}}}
+	require.Equal(t, devObject{"createPullRequest": devObject{"pullRequest": devObject{
+		"id":      "PR_1",
+		"number":  1,
+		"commits": devObject{"nodes": []interface{}{devObject{"commit": devObject{"oid": "abc"}}}},
+	}}}, sel.prune(data))
+}
+
+func TestRenderDevBlocks(t *testing.T) {
+	lines, choices := renderDevBlocks([]byte(`[
+		{"type":"section","text":{"type":"mrkdwn","text":"Deploy *master*?"}},
+		{"type":"actions","block_id":"b1","elements":[
+			{"type":"button","action_id":"approve","text":{"type":"plain_text","text":"Deploy"},"value":"deploy_kustomize_approve|PR_1"},
+			{"type":"static_select","action_id":"branch","placeholder":{"type":"plain_text","text":"Branch"},"options":[{"text":{"type":"plain_text","text":"main"},"value":"main"}]}
+		]}
+	]`))
+	require.Equal(t, []string{"Deploy *master*?"}, lines)
+	require.Equal(t, []devChoice{
+		{Type: "button", ActionID: "approve", BlockID: "b1", Label: "Deploy", Value: "deploy_kustomize_approve|PR_1"},
+		{Type: "static_select", ActionID: "branch", BlockID: "b1", Label: "Branch: main", Value: "main"},
+	}, choices)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// directMessageEchoPattern matches the commands changing what's deployed, which are echoed to the project's channel when sent by DM.
+var directMessageEchoPattern = regexp.MustCompile(`\b(deploy|switch|run|rotate-secret) ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\b`)
+
+// handleDirectMessageEvent handles the commands sent to gocat by DM in the same way as the mentions in channels,
+// which is handy for personal workflows like status.
+// It needs the message.im event and the im:history scope.
+func (s *SlackListener) handleDirectMessageEvent(ev *slackevents.MessageEvent) error {
+	// Skip the messages of bots, including the responses of gocat itself, and the edits and the deletes
+	if ev.BotID != "" || ev.SubType != "" || ev.User == "" {
+		return nil
+	}
+	if text, err := s.aliases.Expand(ev.Text); err == nil {
+		s.echoDirectMessage(ev.User, text)
+	}
+	return s.handleMessageEvent(&slackevents.AppMentionEvent{
+		Type:            ev.Type,
+		User:            ev.User,
+		Text:            ev.Text,
+		TimeStamp:       ev.TimeStamp,
+		ThreadTimeStamp: ev.ThreadTimeStamp,
+		Channel:         ev.Channel,
+		EventTimeStamp:  ev.EventTimeStamp,
+	})
+}
+
+// echoDirectMessage posts the deploy command sent by DM to the notifyChannel of the phase,
+// so that deploys don't happen out of sight of the team.
+// The commands the user isn't allowed to run aren't echoed, so that anyone can't post to the channels by DM.
+func (s *SlackListener) echoDirectMessage(user, text string) {
+	match := directMessageEchoPattern.FindStringSubmatch(text)
+	if match == nil {
+		return
+	}
+	if err := s.checkDirectMessageCommand(user, match[1]); err != nil {
+		// The error is responded to the DM by handleMessageEvent
+		return
+	}
+	pj, err := s.projectList.FindByAlias(match[2])
+	if err != nil {
+		// The error is responded to the DM by handleMessageEvent
+		return
+	}
+	channel := pj.FindPhase(s.toPhase(match[3])).NotifyChannel
+	if channel == "" {
+		return
+	}
+	echo := fmt.Sprintf(":speech_balloon: <@%s> ran `%s` by DM", user, strings.TrimSpace(match[0]))
+	if _, _, err := s.client.PostMessage(channel, slack.MsgOptionText(echo, false)); err != nil {
+		log.Printf("[ERROR] Failed to echo the command sent by DM to %s: %s", channel, err)
+	}
+}
+
+// checkDirectMessageCommand returns an error if the user isn't allowed to run the command echoed by echoDirectMessage,
+// in the same way as handleMessageEvent checks deploy, and commandTarget checks the others.
+func (s *SlackListener) checkDirectMessageCommand(user, command string) error {
+	if command == "deploy" {
+		return s.checkRequester(user)
+	}
+	if !s.userList.FindBySlackUserID(user).IsDeveloper() {
+		return fmt.Errorf("<@%s> is not allowed to run this command. Please contact admin.", user)
+	}
+	return nil
+}
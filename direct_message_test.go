@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectMessageEchoPattern(t *testing.T) {
+	for text, want := range map[string][]string{
+		"deploy myapp production":             {"deploy myapp production", "deploy", "myapp", "production"},
+		"deploy myapp stg branch":             {"deploy myapp stg", "deploy", "myapp", "stg"},
+		"run myapp production job db-migrate": {"run myapp production", "run", "myapp", "production"},
+		"status myapp production":             nil,
+		"explain myapp production":            nil,
+	} {
+		require.Equal(t, want, directMessageEchoPattern.FindStringSubmatch(text), text)
+	}
+}
+
+func TestSlackListener_CheckDirectMessageCommand(t *testing.T) {
+	s := SlackListener{userList: &UserList{Items: []User{
+		{SlackUserID: "UVIEWER", isViewer: true},
+		{SlackUserID: "UDEV", isDeveloper: true},
+	}}}
+
+	require.NoError(t, s.checkDirectMessageCommand("UNKNOWN", "deploy"))
+	require.Error(t, s.checkDirectMessageCommand("UVIEWER", "deploy"))
+	require.NoError(t, s.checkDirectMessageCommand("UDEV", "deploy"))
+	require.Error(t, s.checkDirectMessageCommand("UNKNOWN", "run"))
+	require.Error(t, s.checkDirectMessageCommand("UVIEWER", "switch"))
+	require.NoError(t, s.checkDirectMessageCommand("UDEV", "rotate-secret"))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// ErrorCode is the stable identifier of a failure users can act on,
+// shown in Slack with the hint to fix it instead of the raw error of the underlying library.
+type ErrorCode string
+
+const (
+	ErrCodeGitAuth            ErrorCode = "E_GIT_AUTH"
+	ErrCodeGitPushRejected    ErrorCode = "E_GIT_PUSH_REJECTED"
+	ErrCodeGit                ErrorCode = "E_GIT"
+	ErrCodeGitHubAuth         ErrorCode = "E_GITHUB_AUTH"
+	ErrCodeGitHubRateLimit    ErrorCode = "E_GITHUB_RATE_LIMIT"
+	ErrCodeGitHubAPI          ErrorCode = "E_GITHUB_API"
+	ErrCodeRegistryAuth       ErrorCode = "E_REGISTRY_AUTH"
+	ErrCodeRegistryNotFound   ErrorCode = "E_REGISTRY_NOT_FOUND"
+	ErrCodeRegistry           ErrorCode = "E_REGISTRY"
+	ErrCodeImageTagNotFound   ErrorCode = "E_IMAGE_TAG_NOT_FOUND"
+	ErrCodePluginFailed       ErrorCode = "E_PLUGIN_FAILED"
+	ErrCodePluginNotInstalled ErrorCode = "E_PLUGIN_NOT_INSTALLED"
+	ErrCodeImageUnsigned      ErrorCode = "E_IMAGE_UNSIGNED"
+	ErrCodeTagNotAllowed      ErrorCode = "E_TAG_NOT_ALLOWED"
+	ErrCodeImageArchitecture  ErrorCode = "E_IMAGE_ARCHITECTURE"
+	ErrCodeMetadataMissing    ErrorCode = "E_METADATA_MISSING"
+	// The codes of the branch protection of the manifest repository. See branchProtectionError.
+	ErrCodeProtectionPullRequest   ErrorCode = "E_PROTECTION_PULL_REQUEST"
+	ErrCodeProtectionReviews       ErrorCode = "E_PROTECTION_REVIEWS"
+	ErrCodeProtectionSignedCommits ErrorCode = "E_PROTECTION_SIGNED_COMMITS"
+	ErrCodeProtectionLinearHistory ErrorCode = "E_PROTECTION_LINEAR_HISTORY"
+	ErrCodeProtectionStatusChecks  ErrorCode = "E_PROTECTION_STATUS_CHECKS"
+	ErrCodeProtectedBranch         ErrorCode = "E_PROTECTED_BRANCH"
+)
+
+// errorHints are the actions to take for each error code.
+var errorHints = map[ErrorCode]string{
+	ErrCodeGitAuth:                 "the token gocat pushes the manifests with is expired or revoked. Rotate CONFIG_GITHUB_ACCESS_TOKEN and restart gocat",
+	ErrCodeGitPushRejected:         "the branch was updated or is protected. Retry the deploy, or check the branch protection of the manifest repository",
+	ErrCodeGit:                     "check the access of CONFIG_GITHUB_ACCESS_TOKEN to CONFIG_MANIFEST_REPOSITORY",
+	ErrCodeGitHubAuth:              "GitHub rejected the token. Rotate CONFIG_GITHUB_ACCESS_TOKEN and restart gocat",
+	ErrCodeGitHubRateLimit:         "wait for the reset shown by `ratelimit`, or ask an admin to use a token with a higher limit",
+	ErrCodeGitHubAPI:               "check the GitHub status page and the permissions of CONFIG_GITHUB_ACCESS_TOKEN",
+	ErrCodeRegistryAuth:            "gocat isn't allowed to read the ECR repository. Grant ecr:DescribeImages to the IAM role of gocat",
+	ErrCodeRegistryNotFound:        "the ECR repository doesn't exist. Check dockerRegistry in the project config",
+	ErrCodeRegistry:                "check the ECR repository and the AWS credentials of gocat",
+	ErrCodeImageTagNotFound:        "no image was pushed for the branch yet, or filterRegexp/targetRegexp in the project config doesn't match it",
+	ErrCodePluginFailed:            "check the output of the plugin above, then retry the deploy",
+	ErrCodePluginNotInstalled:      "the plugin command isn't installed in the gocat container image",
+	ErrCodeImageUnsigned:           "the image isn't signed as imageSignature of the phase requires. Check the signing step of the build, or the key and the identity in the project config",
+	ErrCodeTagNotAllowed:           "the phase doesn't accept the tag. Deploy an allowed tag with --tag, or check tagPolicy of the phase in the project config",
+	ErrCodeImageArchitecture:       "the image isn't built for all the architectures of the cluster. Build it for them, like with docker buildx --platform, or check architectures of the phase in the project config",
+	ErrCodeMetadataMissing:         "the pull request was opened before gocat embedded the metadata its gates check. Close it and request the deploy again",
+	ErrCodeProtectionPullRequest:   "the default branch only accepts pull requests. Set commitStrategy of the phase to pullRequest, or let the user of CONFIG_GITHUB_ACCESS_TOKEN bypass the protection",
+	ErrCodeProtectionReviews:       "approve the pull request on GitHub and click Deploy again, or let the user of CONFIG_GITHUB_ACCESS_TOKEN bypass the required reviews. Set CONFIG_GITHUB_WEBHOOK_SECRET to follow the reviews in Slack",
+	ErrCodeProtectionSignedCommits: "the commits gocat pushes aren't signed. Set CONFIG_GITHUB_MERGE_METHOD to squash, which makes GitHub sign the merged commit, and commitStrategy of the phase to pullRequest",
+	ErrCodeProtectionLinearHistory: "the branch doesn't accept merge commits. Set CONFIG_GITHUB_MERGE_METHOD to squash or rebase",
+	ErrCodeProtectionStatusChecks:  "wait for the required status checks of the pull request to pass, and click Deploy again",
+	ErrCodeProtectedBranch:         "check the branch protection rules of the default branch of CONFIG_MANIFEST_REPOSITORY",
+}
+
+// CodedError is the error with the ErrorCode. Error returns the message of the wrapped error as is,
+// so the code is only added where the error is shown to users. See describeError.
+type CodedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// withCode wraps the error with the code. It returns nil for nil, and the error as is if it already has a code,
+// as the code of the innermost layer is the most specific.
+func withCode(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return err
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+// errorCodeOf returns the code of the error, or an empty string if it has none.
+func errorCodeOf(err error) ErrorCode {
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return ""
+}
+
+// describeError returns the message of the error for Slack, prefixed with the code and followed by the hint if it has one.
+// It returns an empty string for nil.
+func describeError(err error) string {
+	if err == nil {
+		return ""
+	}
+	code := errorCodeOf(err)
+	if code == "" {
+		return err.Error()
+	}
+	message := fmt.Sprintf("%s: %s", code, err.Error())
+	if hint, ok := errorHints[code]; ok {
+		message += "\nHint: " + hint
+	}
+	return message
+}
+
+// gitError classifies the error of go-git talking to the remote.
+func gitError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed):
+		return withCode(ErrCodeGitAuth, err)
+	case errors.Is(err, git.ErrNonFastForwardUpdate), errors.Is(err, git.ErrForceNeeded):
+		return withCode(ErrCodeGitPushRejected, err)
+	}
+	return withCode(ErrCodeGit, err)
+}
+
+// registryError classifies the error of the ECR API.
+func registryError(err error) error {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return withCode(ErrCodeRegistry, err)
+	}
+	switch aerr.Code() {
+	case "AccessDeniedException", "UnrecognizedClientException", "ExpiredTokenException", "InvalidSignatureException":
+		return withCode(ErrCodeRegistryAuth, err)
+	case "RepositoryNotFoundException":
+		return withCode(ErrCodeRegistryNotFound, err)
+	}
+	return withCode(ErrCodeRegistry, err)
+}
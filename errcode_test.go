@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeError(t *testing.T) {
+	err := fmt.Errorf("unable to deploy: %w", gitError(transport.ErrAuthorizationFailed))
+	require.Equal(t, ErrCodeGitAuth, errorCodeOf(err))
+	require.Equal(t, "unable to deploy: authorization failed", err.Error())
+	require.Equal(t, "E_GIT_AUTH: unable to deploy: authorization failed\n"+
+		"Hint: the token gocat pushes the manifests with is expired or revoked. Rotate CONFIG_GITHUB_ACCESS_TOKEN and restart gocat", describeError(err))
+
+	// The innermost code wins
+	require.Equal(t, ErrCodeGitAuth, errorCodeOf(withCode(ErrCodePluginFailed, err)))
+
+	require.Equal(t, ErrCodeRegistryNotFound, errorCodeOf(registryError(awserr.New("RepositoryNotFoundException", "not found", nil))))
+	require.Equal(t, ErrCodeRegistryAuth, errorCodeOf(registryError(awserr.New("AccessDeniedException", "denied", nil))))
+	require.Equal(t, ErrCodeRegistry, errorCodeOf(registryError(errors.New("timeout"))))
+
+	require.Nil(t, withCode(ErrCodeGit, nil))
+	require.Equal(t, "plain", describeError(errors.New("plain")))
+	require.Equal(t, "", describeError(nil))
+}
+
+func TestGitHubRateLimitTransportUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/zaiminc/gocat":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message": "Bad credentials"}`)
+		case "/repos/zaiminc/private":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message": "Requires authentication"}`)
+		case "/repos/zaiminc/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "Resource not accessible by integration"}`)
+		case "/repos/zaiminc/secondary":
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "You have exceeded a secondary rate limit"}`)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &gitHubRateLimitTransport{base: http.DefaultTransport, limiter: NewGitHubRateLimiter()}}
+	_, err := client.Get(server.URL + "/repos/zaiminc/gocat")
+	require.Error(t, err)
+	require.Equal(t, ErrCodeGitHubAuth, errorCodeOf(err))
+
+	_, err = client.Get(server.URL + "/repos/zaiminc/secondary")
+	require.Error(t, err)
+	require.Equal(t, ErrCodeGitHubRateLimit, errorCodeOf(err))
+
+	// The other responses are left to the callers with their bodies intact
+	for path, body := range map[string]string{
+		"/repos/zaiminc/private":   `{"message": "Requires authentication"}`,
+		"/repos/zaiminc/forbidden": `{"message": "Resource not accessible by integration"}`,
+	} {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, body, string(b))
+	}
+}
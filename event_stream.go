@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeployEventType is the step of the lifecycle of a deploy.
+type DeployEventType string
+
+const (
+	DeployEventRequested        DeployEventType = "requested"
+	DeployEventAwaitingApproval DeployEventType = "awaiting_approval"
+	DeployEventApproved         DeployEventType = "approved"
+	DeployEventDeployed         DeployEventType = "deployed"
+	DeployEventSkipped          DeployEventType = "skipped"
+	DeployEventFailed           DeployEventType = "failed"
+)
+
+// DeployEvent is the event of the lifecycle of a deploy, which the data platform builds the metrics from,
+// like the lead time from requested to deployed and the change failure rate.
+type DeployEvent struct {
+	Type    DeployEventType `json:"type"`
+	TraceID string          `json:"traceId"`
+	Project string          `json:"project"`
+	Phase   string          `json:"phase"`
+	Message string          `json:"message"`
+	// Time is in milliseconds since the epoch, which is the timestamp-millis of Avro.
+	Time int64 `json:"time"`
+}
+
+// deployEventAvroSchema is the Avro schema of DeployEvent registered by the REST Proxy.
+const deployEventAvroSchema = `{"type": "record", "name": "DeployEvent", "namespace": "net.zaim.gocat", "fields": [` +
+	`{"name": "type", "type": "string"}, ` +
+	`{"name": "traceId", "type": "string"}, ` +
+	`{"name": "project", "type": "string"}, ` +
+	`{"name": "phase", "type": "string"}, ` +
+	`{"name": "message", "type": "string"}, ` +
+	`{"name": "time", "type": {"type": "long", "logicalType": "timestamp-millis"}}]}`
+
+// deployEventBatchSize is the maximum number of the events sent in a request, so that the backlog piled up
+// while the REST Proxy was slow or down catches up quickly.
+const deployEventBatchSize = 100
+
+// deployEventMaxRetryInterval caps the interval of the retries, which doubles from retryInterval on each failure.
+const deployEventMaxRetryInterval = time.Minute
+
+// DeployEventStream sends the deploy events to the topic of Kafka through the REST Proxy, keyed by the project,
+// in the background.
+//
+// The events are queued without a bound and kept until the REST Proxy takes them, however slow or long down it is,
+// so that the deploys never wait for Kafka and no event is lost on the way. A deploy emits only a handful of events.
+//
+// The methods are safe to call on nil, which drops the events.
+type DeployEventStream struct {
+	// url is the URL of the topic, like http://kafka-rest:8082/topics/gocat.deploy-events.
+	url        string
+	format     string
+	httpClient *http.Client
+	// mu guards pending, the events waiting to be sent from the oldest.
+	mu      sync.Mutex
+	pending []DeployEvent
+	// wake is signaled when an event is queued.
+	wake chan struct{}
+	// retryInterval is the first interval of the retries, which is replaced in tests.
+	retryInterval time.Duration
+}
+
+// NewDeployEventStream returns the DeployEventStream sending to the topic of the REST Proxy at restURL,
+// in the format, which is either json or avro.
+func NewDeployEventStream(restURL, topic, format string) (*DeployEventStream, error) {
+	if format != "json" && format != "avro" {
+		return nil, fmt.Errorf("unknown format %q of the deploy events, which is either json or avro", format)
+	}
+	return &DeployEventStream{
+		url:           strings.TrimSuffix(restURL, "/") + "/topics/" + topic,
+		format:        format,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		wake:          make(chan struct{}, 1),
+		retryInterval: time.Second,
+	}, nil
+}
+
+// Start sends the events in the background, in batches of up to deployEventBatchSize in the order they're emitted.
+// The batches the REST Proxy fails to take are retried with the backoff, except the ones it rejects as invalid,
+// which would never be taken.
+func (s *DeployEventStream) Start() {
+	log.Printf("[INFO] Deploy events are sent to %s in %s.", s.url, s.format)
+	go func() {
+		interval := s.retryInterval
+		for range s.wake {
+			for batch := s.peek(); len(batch) > 0; batch = s.peek() {
+				err := s.send(batch)
+				var rejected deployEventRejectedError
+				if errors.As(err, &rejected) {
+					log.Printf("[ERROR] Dropped %d deploy events rejected by the REST Proxy: %s", len(batch), err)
+				} else if err != nil {
+					log.Printf("[WARNING] Failed to send %d deploy events, retrying in %s: %s", len(batch), interval, err)
+					time.Sleep(interval)
+					if interval *= 2; interval > deployEventMaxRetryInterval {
+						interval = deployEventMaxRetryInterval
+					}
+					continue
+				}
+				interval = s.retryInterval
+				s.remove(len(batch))
+			}
+		}
+	}()
+}
+
+func (s *DeployEventStream) Emit(e DeployEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.pending = append(s.pending, e)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// The sender is already woken up, and sends this one along with the others
+	}
+}
+
+// peek returns the oldest events to send next, which stay queued until remove.
+func (s *DeployEventStream) peek() []DeployEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.pending)
+	if n > deployEventBatchSize {
+		n = deployEventBatchSize
+	}
+	return append([]DeployEvent(nil), s.pending[:n]...)
+}
+
+// remove removes the oldest n events, which are sent.
+func (s *DeployEventStream) remove(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append([]DeployEvent(nil), s.pending[n:]...)
+}
+
+// deployEventRejectedError is the error of the events the REST Proxy rejected as invalid, like the ones not matching the schema,
+// which are never retried.
+type deployEventRejectedError struct {
+	status string
+}
+
+func (e deployEventRejectedError) Error() string {
+	return "rejected with " + e.status
+}
+
+// body returns the request body of the REST Proxy v2 API and its content type.
+func (s *DeployEventStream) body(events []DeployEvent) ([]byte, string, error) {
+	var records []map[string]interface{}
+	for _, e := range events {
+		records = append(records, map[string]interface{}{"key": e.Project, "value": e})
+	}
+	req := map[string]interface{}{
+		"records": records,
+	}
+	contentType := "application/vnd.kafka.json.v2+json"
+	if s.format == "avro" {
+		req["key_schema"] = `{"type": "string"}`
+		req["value_schema"] = deployEventAvroSchema
+		contentType = "application/vnd.kafka.avro.v2+json"
+	}
+	b, err := json.Marshal(req)
+	return b, contentType, err
+}
+
+func (s *DeployEventStream) send(events []DeployEvent) error {
+	b, contentType, err := s.body(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The client errors other than the timeouts and the rate limits mean the events are invalid
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("POST %s: %w", s.url, deployEventRejectedError{status: resp.Status})
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployEventStream(t *testing.T) {
+	type request struct {
+		path        string
+		contentType string
+		body        map[string]interface{}
+	}
+	requests := make(chan request, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		_ = json.Unmarshal(b, &body)
+		requests <- request{path: r.URL.Path, contentType: r.Header.Get("Content-Type"), body: body}
+	}))
+	defer server.Close()
+
+	stream, err := NewDeployEventStream(server.URL+"/", "gocat.deploy-events", "json")
+	require.NoError(t, err)
+	stream.Start()
+
+	tracer := NewDeployTracer()
+	tracer.now = func() time.Time { return time.UnixMilli(1700000000000) }
+	tracer.stream = stream
+	id := tracer.Start("myapp", "production", "requested by <@U1>")
+
+	req := <-requests
+	require.Equal(t, "/topics/gocat.deploy-events", req.path)
+	require.Equal(t, "application/vnd.kafka.json.v2+json", req.contentType)
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"key": "myapp",
+		"value": map[string]interface{}{
+			"type": "requested", "traceId": id, "project": "myapp", "phase": "production",
+			"message": "requested by <@U1>", "time": float64(1700000000000),
+		},
+	}}, req.body["records"])
+
+	tracer.Emit(id, DeployEventDeployed, "merged #%d", 42)
+	req = <-requests
+	value := req.body["records"].([]interface{})[0].(map[string]interface{})["value"].(map[string]interface{})
+	require.Equal(t, "deployed", value["type"])
+	require.Equal(t, "merged #42", value["message"])
+
+	// Unknown traces aren't emitted, like the ones of the deploys requested before gocat restarted
+	tracer.Emit("deadbeef", DeployEventDeployed, "merged")
+	require.Empty(t, requests)
+
+	// unless the deploy is known by its metadata, like the one merged on GitHub
+	tracer.EmitFor(DeployMetadata{Project: "myapp", Phase: "production", TraceID: "deadbeef"}, DeployEventDeployed, "merged on GitHub")
+	req = <-requests
+	value = req.body["records"].([]interface{})[0].(map[string]interface{})["value"].(map[string]interface{})
+	require.Equal(t, "deadbeef", value["traceId"])
+	require.Equal(t, "myapp", value["project"])
+}
+
+func TestDeployEventStream_Retry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		failures = 2
+		sent     []string
+	)
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body struct {
+			Records []struct {
+				Value DeployEvent `json:"value"`
+			} `json:"records"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		for _, record := range body.Records {
+			sent = append(sent, record.Value.Message)
+		}
+		if len(sent) == 150 {
+			close(done)
+		}
+	}))
+	defer server.Close()
+
+	stream, err := NewDeployEventStream(server.URL, "gocat.deploy-events", "json")
+	require.NoError(t, err)
+	stream.retryInterval = time.Millisecond
+	// The events emitted while the REST Proxy is down are all sent in order once it's back
+	for i := 0; i < 150; i++ {
+		stream.Emit(DeployEvent{Type: DeployEventRequested, Project: "myapp", Message: strconv.Itoa(i)})
+	}
+	stream.Start()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the events weren't sent")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, message := range sent {
+		require.Equal(t, strconv.Itoa(i), message)
+	}
+	require.Eventually(t, func() bool { return len(stream.peek()) == 0 }, 5*time.Second, time.Millisecond)
+}
+
+func TestDeployEventStreamAvro(t *testing.T) {
+	stream, err := NewDeployEventStream("http://kafka-rest:8082", "gocat.deploy-events", "avro")
+	require.NoError(t, err)
+	b, contentType, err := stream.body([]DeployEvent{{Type: DeployEventFailed, Project: "myapp"}})
+	require.NoError(t, err)
+	require.Equal(t, "application/vnd.kafka.avro.v2+json", contentType)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &body))
+	require.Equal(t, deployEventAvroSchema, body["value_schema"])
+
+	_, err = NewDeployEventStream("http://kafka-rest:8082", "gocat.deploy-events", "protobuf")
+	require.Error(t, err)
+
+	var nilStream *DeployEventStream
+	require.NotPanics(t, func() { nilStream.Emit(DeployEvent{}) })
+}
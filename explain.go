@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// explainDeploy describes what a deploy of the phase touches, resolved from the current config only,
+// which helps to check the config of a new project before deploying it for real.
+//
+// The manifest repository isn't read, so the files are the ones the deploy writes if they exist.
+func explainDeploy(pj DeployProject, phase DeployPhase, github *GitHub) string {
+	kind := phase.Kind
+	if kind == "" {
+		kind = pj.Kind
+	}
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	add("*%s* *%s* (%s)", pj.ID, phase.Name, kind)
+	branch := phase.TrackBranch
+	if branch == "" {
+		branch = pj.DefaultBranch()
+	}
+	for _, q := range pj.ImageTagQueries(phase, ImageTagVars{Branch: branch}) {
+		filter := q.FilterRegexp
+		if filter == "" {
+			filter = "the default tag strategy"
+		}
+		add("Image: `%s`, tags of `%s` in %s/%s by %s", q.Image, branch, github.org, pj.GitHubRepository(), filter)
+	}
+
+	switch kind {
+	case "kustomize":
+		lines = append(lines, explainKustomize(pj, phase, github)...)
+	case "kanvas":
+		add("Runs `kanvas apply` with `%s` in %s/%s for the environment %s, which opens the pull request", phase.Path, github.org, pj.GitHubRepository(), phase.Name)
+		add("Branch: `%s`", explainBranchName(pj, phase))
+	case "job":
+		add("Creates the Job of `%s` in %s/%s with the image tag in the cluster", phase.Path, github.org, github.repo)
+	case "lambda":
+		add("Invokes the Lambda function `%s`", pj.FuncName())
+	case "jenkins":
+		add("Builds the Jenkins job `%s`", pj.JenkinsJob())
+	default:
+		if _, ok := gitOpsPlugins[kind]; ok {
+			add("Prepared by the gitops plugin %s with the options %v", kind, phase.PluginOptions)
+			break
+		}
+		add("Unknown kind %q", kind)
+	}
+
+	if policy := phase.ImageSignature; policy.Enabled() {
+		verified := "signatures"
+		if policy.AttestationType != "" {
+			verified = policy.AttestationType + " attestations"
+		}
+		add("Before preparing: verifies the %s of the images with cosign, and %ss the unverified ones", verified, policy.Mode)
+	}
+	if len(phase.Architectures) > 0 {
+		add("Before preparing: checks the images are built for %s", strings.Join(phase.Architectures, ", "))
+	}
+	if phase.Promotion.Enabled() {
+		add("Before preparing: copies the images to `%s` with crane", phase.Promotion.Registry)
+	}
+	if policy := phase.TagPolicy; policy.Enabled() {
+		add("Tags: allows %v, denies %v", policy.Allow, policy.Deny)
+	}
+	if phase.MaxConcurrentDeploys > 0 || pj.MaxConcurrentDeploys > 0 {
+		add("Concurrency: at most %d deploys of the phase and %d of the project at once (0 is unlimited)", phase.MaxConcurrentDeploys, pj.MaxConcurrentDeploys)
+	}
+	if phase.SBOM {
+		add("Before preparing: fetches the SBOMs of the images with cosign, summarizes them in the pull request, and archives them")
+	}
+	if phase.TwoPersonRule {
+		add("Approval: by someone other than the requester")
+	}
+	if phase.MigrationGate.Enabled() {
+		add("Before merging: migration gate (checkURL `%s`, job `%s`)", phase.MigrationGate.CheckURL, phase.MigrationGate.Job)
+	}
+	if gate := phase.SLOGate; gate.Enabled() {
+		onExhausted := "blocked"
+		if gate.requiresApproval() {
+			onExhausted = "approved only by " + gate.approvers()
+		}
+		add("Before merging: SLO gate (%s `%s`), %s at or below %.1f%% of the error budget left", gate.Provider, gate.Query, onExhausted, gate.MinRemaining*100)
+	}
+	if hooks := phase.Hooks.PreDeploy; len(hooks) > 0 {
+		var names []string
+		for _, w := range hooks {
+			names = append(names, w.name())
+		}
+		add("Before merging: calls the preDeploy webhooks %s, aborting the deploy if any fails", strings.Join(names, ", "))
+	}
+	if commands := phase.Hooks.PreDeployCommands; len(commands) > 0 {
+		var names []string
+		for _, c := range commands {
+			names = append(names, c.Name)
+		}
+		add("Before merging: runs the preDeploy commands %s as Jobs, aborting the deploy if any fails", strings.Join(names, ", "))
+	}
+	if phase.SyntheticChecks.Enabled() {
+		var names []string
+		for _, c := range phase.SyntheticChecks.Checks {
+			names = append(names, c.name())
+		}
+		add("After merging: waits up to %s for the synthetic checks %s before notifying the success", phase.SyntheticChecks.timeout(), strings.Join(names, ", "))
+	}
+	if hooks := phase.Hooks.PostDeploy; len(hooks) > 0 {
+		var names []string
+		for _, w := range hooks {
+			names = append(names, w.name())
+		}
+		add("After merging: calls the postDeploy webhooks %s", strings.Join(names, ", "))
+	}
+	if commands := phase.Hooks.PostDeployCommands; len(commands) > 0 {
+		var names []string
+		for _, c := range commands {
+			names = append(names, c.Name)
+		}
+		add("After merging: runs the postDeploy commands %s as Jobs", strings.Join(names, ", "))
+	}
+	if phase.Rollout.Enabled() {
+		add("After merging: follows the rollout %s/%s", phase.Rollout.namespace(), phase.Rollout.Name)
+	}
+	if phase.AppRepoTag.Enabled {
+		add("After merging: tags %s/%s", github.org, pj.GitHubRepository())
+	}
+	if phase.FailureIssue.Enabled {
+		add("On failure: opens an issue in %s/%s, closed by the next successful deploy", github.org, pj.GitHubRepository())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func explainKustomize(pj DeployProject, phase DeployPhase, github *GitHub) []string {
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	add("Repository: %s/%s", github.org, github.repo)
+	switch {
+	case phase.CommitStrategy == CommitStrategyDirect:
+		add("Branch: pushed straight to `%s` with no pull request", github.defaultBranch)
+	case phase.StackDeploys:
+		add("Branch: the head of the open deploy pull request of the phase if any, or `%s` from `%s`", explainBranchName(pj, phase), github.defaultBranch)
+	default:
+		add("Branch: `%s` from `%s`, merged by the pull request", explainBranchName(pj, phase), github.defaultBranch)
+	}
+	if o := phase.PullRequest; len(o.Labels) > 0 || o.Milestone != "" {
+		add("Pull request: labels %s, milestone %q", strings.Join(o.Labels, ", "), o.Milestone)
+	}
+
+	paths := []string{phase.Path}
+	if phase.BlueGreen.Enabled() {
+		paths = []string{phase.BlueGreen.Blue, phase.BlueGreen.Green}
+		add("Blue/green: the overlay of the idle color selected by `%s`. `switch` opens the pull request changing the `%s` values in it", phase.BlueGreen.SwitchPath, phase.BlueGreen.key())
+	}
+	add("Files:")
+	for _, p := range paths {
+		add("• `%s`", p)
+		for _, q := range pj.ImageTagQueries(phase, ImageTagVars{}) {
+			add("    `images[name=%s].newTag`", q.Image)
+			if phase.PinDigest {
+				add("    `images[name=%s].digest`", q.Image)
+			}
+		}
+		add("• `%s` if it has `data.MEMCACHED_PREFIX`", strings.Replace(p, "kustomization.yaml", "configmap.yaml", -1))
+	}
+	return lines
+}
+
+// explainBranchName returns the name of the deploy branch with a placeholder for the tag.
+func explainBranchName(pj DeployProject, phase DeployPhase) string {
+	name, err := pj.DeployBranchName(phase.Name, "TAG", pj.DefaultBranch(), "")
+	if err != nil {
+		return err.Error()
+	}
+	return name
+}
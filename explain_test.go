@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainDeploy(t *testing.T) {
+	github := &GitHub{org: "zaiminc", repo: "manifests", defaultBranch: "main"}
+	pj := DeployProject{
+		ID:               "myapp",
+		Kind:             "kustomize",
+		gitHubRepository: "myapp",
+		dockerRegistry:   "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp",
+		filterRegexp:     "^{{.Branch}}-",
+	}
+	phase := DeployPhase{
+		Name:          "production",
+		Path:          "myapp/overlays/production/kustomization.yaml",
+		PinDigest:     true,
+		TwoPersonRule: true,
+		PullRequest:   PullRequestOption{Labels: []string{"deploy"}},
+	}
+
+	require.Equal(t, "*myapp* *production* (kustomize)\n"+
+		"Image: `123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp`, tags of `master` in zaiminc/myapp by ^{{.Branch}}-\n"+
+		"Repository: zaiminc/manifests\n"+
+		"Branch: `bot/docker-image-tag-myapp-production-TAG` from `main`, merged by the pull request\n"+
+		"Pull request: labels deploy, milestone \"\"\n"+
+		"Files:\n"+
+		"• `myapp/overlays/production/kustomization.yaml`\n"+
+		"    `images[name=123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp].newTag`\n"+
+		"    `images[name=123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp].digest`\n"+
+		"• `myapp/overlays/production/configmap.yaml` if it has `data.MEMCACHED_PREFIX`\n"+
+		"Approval: by someone other than the requester", explainDeploy(pj, phase, github))
+
+	phase = DeployPhase{
+		Name:           "sandbox",
+		Path:           "myapp/overlays/sandbox/kustomization.yaml",
+		CommitStrategy: CommitStrategyDirect,
+		TrackBranch:    "develop",
+	}
+	require.Contains(t, explainDeploy(pj, phase, github), "tags of `develop`")
+	require.Contains(t, explainDeploy(pj, phase, github), "Branch: pushed straight to `main` with no pull request")
+
+	phase = DeployPhase{Name: "production", Kind: "lambda"}
+	pj.funcName = "myapp-deploy"
+	require.Contains(t, explainDeploy(pj, phase, github), "Invokes the Lambda function `myapp-deploy`")
+}
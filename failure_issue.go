@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// FailureIssueOption opens a GitHub issue in the repository of the project when a deploy of the phase fails,
+// including the ones of AutoDeploy, so that the failure is tracked until it's fixed.
+// The issue is assigned to the requester, the assignees, and the GitHub users of onFailure of the phase, like the on-call:
+//
+//	failureIssue:
+//	  enabled: true
+//	  assignees: [payments-oncall-bot]
+//	  labels: [deploy-failure]
+//
+// The issue is closed when a later deploy of the phase succeeds.
+type FailureIssueOption struct {
+	Enabled bool `yaml:"enabled"`
+	// Assignees are the GitHub logins, like the one of the on-call, assigned to the issue along with the requester of the deploy.
+	Assignees []string `yaml:"assignees"`
+	// Labels are the labels of the issue.
+	Labels []string `yaml:"labels"`
+}
+
+// FailureIssueReporter opens and closes the issues of the failed deploys of the phases with failureIssue, following the events of the deploys.
+// The failures of the phase while its issue is open are commented on the issue instead of opening another one.
+//
+// The open issues are found by their titles and labels on GitHub, so that the ones opened before gocat restarted,
+// or by another replica, are commented on and closed as well.
+// The methods are safe to call on nil, which does nothing.
+type FailureIssueReporter struct {
+	github      *GitHub
+	projectList *ProjectList
+	userList    *UserList
+	// workspaces resolves onFailure of the phases to the GitHub users to assign.
+	workspaces *SlackWorkspaces
+	mu         sync.Mutex
+	// open is the map from "<project>/<phase>" to the number of the issue this gocat opened for the failures of the phase,
+	// which covers the ones GitHub hasn't indexed for the search yet.
+	open map[string]int
+}
+
+func NewFailureIssueReporter(github *GitHub, projectList *ProjectList, userList *UserList, workspaces *SlackWorkspaces) *FailureIssueReporter {
+	return &FailureIssueReporter{github: github, projectList: projectList, userList: userList, workspaces: workspaces, open: map[string]int{}}
+}
+
+// Apply opens or comments on the issue of the phase of the deploy if it failed, or closes it if the deploy succeeded, in the background.
+func (r *FailureIssueReporter) Apply(trace DeployTrace, typ DeployEventType, message string) {
+	if r == nil || (typ != DeployEventFailed && typ != DeployEventDeployed) {
+		return
+	}
+	pj := r.projectList.Find(trace.Project)
+	phase := pj.FindPhase(trace.Phase)
+	if !phase.FailureIssue.Enabled {
+		return
+	}
+	go func() {
+		var err error
+		if typ == DeployEventFailed {
+			err = r.report(pj, phase, trace, message)
+		} else {
+			err = r.resolve(pj, phase, trace)
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to update the issue of the failed deploys of %s %s: %s", trace.Project, trace.Phase, err)
+		}
+	}()
+}
+
+// failureIssueTitle returns the title of the issue of the failed deploys of the phase, which the open issue is found by.
+func failureIssueTitle(project, phase string) string {
+	return fmt.Sprintf("Deploy of %s to %s failed", project, phase)
+}
+
+// openIssues returns the numbers of the open issues of the failed deploys of the phase. It's called with the lock held.
+func (r *FailureIssueReporter) openIssues(pj DeployProject, phase DeployPhase) ([]int, error) {
+	numbers, err := r.github.FindOpenIssues(pj.GitHubRepository(), failureIssueTitle(pj.ID, phase.Name), phase.FailureIssue.Labels)
+	if err != nil {
+		return nil, err
+	}
+	if number, ok := r.open[pj.ID+"/"+phase.Name]; ok && !containsInt(numbers, number) {
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// report opens the issue of the failure, or comments on the open one.
+func (r *FailureIssueReporter) report(pj DeployProject, phase DeployPhase, trace DeployTrace, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := trace.Project + "/" + trace.Phase
+	body := failureIssueBody(trace, message, pj.Calendar())
+	numbers, err := r.openIssues(pj, phase)
+	if err != nil {
+		return err
+	}
+	if len(numbers) > 0 {
+		r.open[key] = numbers[0]
+		return r.github.CommentOnIssue(pj.GitHubRepository(), numbers[0], "The deploy failed again.\n\n"+body)
+	}
+
+	option := phase.FailureIssue
+	assignees := append([]string{}, option.Assignees...)
+	if trace.Requester != "" {
+		if login := r.userList.FindBySlackUserID(trace.Requester).GitHubUserName; login != "" && !containsString(assignees, login) {
+			assignees = append(assignees, login)
+		}
+	}
+	onCall, err := r.workspaces.GitHubLogins(r.workspaces.ForProject(pj), phase.OnFailure)
+	if err != nil {
+		log.Printf("[WARNING] Failed to resolve some of onFailure of %s %s to assign: %s", pj.ID, phase.Name, err)
+	}
+	for _, login := range onCall {
+		if !containsString(assignees, login) {
+			assignees = append(assignees, login)
+		}
+	}
+	number, err := r.github.CreateIssue(pj.GitHubRepository(), failureIssueTitle(trace.Project, trace.Phase), body+"\n\nThis issue is closed when a later deploy of the phase succeeds.", assignees, option.Labels)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Opened the issue #%d of the failed deploy %s of %s %s", number, trace.ID, trace.Project, trace.Phase)
+	r.open[key] = number
+	return nil
+}
+
+// resolve closes the open issues of the phase, if any.
+func (r *FailureIssueReporter) resolve(pj DeployProject, phase DeployPhase, trace DeployTrace) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	numbers, err := r.openIssues(pj, phase)
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		if err := r.github.CommentOnIssue(pj.GitHubRepository(), number, fmt.Sprintf("The deploy `%s` succeeded.", trace.ID)); err != nil {
+			return err
+		}
+		if err := r.github.CloseIssue(pj.GitHubRepository(), number); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Closed the issue #%d of the failed deploys of %s %s", number, trace.Project, trace.Phase)
+	}
+	delete(r.open, trace.Project+"/"+trace.Phase)
+	return nil
+}
+
+func containsInt(ns []int, n int) bool {
+	for _, v := range ns {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// failureIssueBody returns the description of the failed deploy with its timeline.
+func failureIssueBody(trace DeployTrace, message string, calendar BusinessCalendar) string {
+	requester := "AutoDeploy"
+	if trace.Requester != "" {
+		requester = fmt.Sprintf("Slack user `%s`", trace.Requester)
+	}
+	lines := []string{
+		fmt.Sprintf("The deploy of %s to %s failed.", trace.Project, trace.Phase),
+		"",
+		fmt.Sprintf("Trace ID: `%s`", trace.ID),
+		fmt.Sprintf("Requested by: %s", requester),
+		fmt.Sprintf("Failed step: %s", message),
+		"",
+		"<details><summary>Timeline</summary>",
+		"",
+		"```",
+	}
+	for _, e := range trace.Events {
+		lines = append(lines, calendar.Format(e.At, "2006-01-02 15:04:05")+" "+e.Text)
+	}
+	lines = append(lines, "```", "", "</details>")
+	return strings.Join(lines, "\n")
+}
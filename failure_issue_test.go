@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureIssueReporter(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var created map[string]interface{}
+	// found is the issues the search finds, which lags behind the ones opened
+	found := `{"items": []}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/search/issues" {
+			require.Equal(t, `repo:zaiminc/myapp is:issue is:open in:title "Deploy of myapp to production failed" label:"deploy-failure"`, r.URL.Query().Get("q"))
+			fmt.Fprint(w, found)
+			return
+		}
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		if r.URL.Path == "/repos/zaiminc/myapp/issues" {
+			created = body
+			fmt.Fprint(w, `{"number": 42}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	github := CreateDevGitHubInstance(server.URL, "zaiminc", "manifests", "refs/heads/master")
+	userList := &UserList{Items: []User{{SlackUserID: "U1", GitHubUserName: "alice"}, {SlackUserID: "U2", GitHubUserName: "bob"}}}
+	workspaces := NewSlackWorkspaces(&SlackWorkspace{Name: "primary", userList: userList})
+	r := NewFailureIssueReporter(&github, &ProjectList{}, userList, workspaces)
+	pj := DeployProject{ID: "myapp", gitHubRepository: "myapp"}
+	phase := DeployPhase{Name: "production", OnFailure: []string{"U2", "U1"}, FailureIssue: FailureIssueOption{Enabled: true, Assignees: []string{"oncall"}, Labels: []string{"deploy-failure"}}}
+	trace := DeployTrace{ID: "1a2b3c4d", Project: "myapp", Phase: "production", Requester: "U1", Events: []DeployTraceEvent{
+		{At: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), Text: "requested by <@U1> with the branch master"},
+		{At: time.Date(2024, 4, 1, 0, 1, 0, 0, time.UTC), Text: "failed to prepare: image not found"},
+	}}
+
+	// The failures while the issue is open are commented on it, even before the search finds it
+	require.NoError(t, r.report(pj, phase, trace, "failed to prepare: image not found"))
+	require.NoError(t, r.report(pj, phase, trace, "failed to prepare: image not found"))
+	require.Equal(t, "Deploy of myapp to production failed", created["title"])
+	require.Equal(t, []interface{}{"oncall", "alice", "bob"}, created["assignees"])
+	require.Equal(t, []interface{}{"deploy-failure"}, created["labels"])
+	require.Contains(t, created["body"], "Trace ID: `1a2b3c4d`\nRequested by: Slack user `U1`\nFailed step: failed to prepare: image not found\n")
+	require.Contains(t, created["body"], "2024-04-01 00:01:00 UTC failed to prepare: image not found\n")
+
+	// The successful deploy closes the issues, including the one opened before a restart
+	found = `{"items": [{"number": 41, "title": "Deploy of myapp to production failed"}, {"number": 40, "title": "Deploy of myapp to production failed again"}]}`
+	require.NoError(t, r.resolve(pj, phase, DeployTrace{ID: "5e6f7a8b", Project: "myapp", Phase: "production"}))
+	found = `{"items": []}`
+	require.NoError(t, r.resolve(pj, phase, DeployTrace{ID: "9c0d1e2f", Project: "myapp", Phase: "production"}))
+	require.Equal(t, []string{
+		"GET /search/issues",
+		"POST /repos/zaiminc/myapp/issues",
+		"GET /search/issues",
+		"POST /repos/zaiminc/myapp/issues/42/comments",
+		"GET /search/issues",
+		"POST /repos/zaiminc/myapp/issues/41/comments",
+		"PATCH /repos/zaiminc/myapp/issues/41",
+		"POST /repos/zaiminc/myapp/issues/42/comments",
+		"PATCH /repos/zaiminc/myapp/issues/42",
+		"GET /search/issues",
+	}, requests)
+
+	// Another replica comments on the issue it finds instead of opening another one
+	requests = nil
+	found = `{"items": [{"number": 43, "title": "Deploy of myapp to production failed"}]}`
+	other := NewFailureIssueReporter(&github, &ProjectList{}, userList, workspaces)
+	require.NoError(t, other.report(pj, phase, trace, "failed to prepare: image not found"))
+	require.Equal(t, []string{"GET /search/issues", "POST /repos/zaiminc/myapp/issues/43/comments"}, requests)
+
+	var nilReporter *FailureIssueReporter
+	require.NotPanics(t, func() { nilReporter.Apply(trace, DeployEventFailed, "failed") })
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Feature is a behavior of gocat rolled out project by project, which is off unless CONFIG_FEATURE_FLAGS
+// or the Features key of the project turns it on.
+type Feature struct {
+	Name        string
+	Description string
+}
+
+// FeaturePinDigest pins the image digests for all the phases of the project, as pinDigest of the phases does.
+// Disabling it with -pin-digest in the Features key unpins the phases setting pinDigest as well.
+const FeaturePinDigest = "pin-digest"
+
+// FeaturePreserveYAML edits kustomization.yaml as the YAML nodes on deploys, keeping its comments and the order of its keys,
+// instead of rewriting it from the Kustomization object.
+const FeaturePreserveYAML = "preserve-yaml"
+
+// features are the features gated by the flags.
+var features = []Feature{
+	{Name: FeaturePinDigest, Description: "Pins the image digests in kustomization.yaml for all the phases, as pinDigest of the phases does"},
+	{Name: FeaturePreserveYAML, Description: "Keeps the comments and the order of the keys of kustomization.yaml on deploys"},
+}
+
+func findFeature(name string) (Feature, bool) {
+	for _, f := range features {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Feature{}, false
+}
+
+// FeatureFlags is the map from the names of the features to the IDs of the projects they're enabled for, where * means all the projects.
+// It's set by CONFIG_FEATURE_FLAGS like:
+//
+//	pin-digest=myapp,web;another-feature=*
+//
+// The projects override it with the Features key of their configmaps, like "pin-digest,-another-feature",
+// where the names prefixed with - are the features disabled for the project.
+type FeatureFlags map[string][]string
+
+// ParseFeatureFlags parses the value of CONFIG_FEATURE_FLAGS.
+func ParseFeatureFlags(s string) (FeatureFlags, error) {
+	flags := FeatureFlags{}
+	for _, entry := range strings.Split(s, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, projects, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || strings.TrimSpace(projects) == "" {
+			return nil, fmt.Errorf("%q must be like %s=myapp,web", entry, name)
+		}
+		if _, ok := findFeature(name); !ok {
+			return nil, fmt.Errorf("unknown feature %s", name)
+		}
+		for _, id := range strings.Split(projects, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				flags[name] = append(flags[name], id)
+			}
+		}
+	}
+	return flags, nil
+}
+
+// enabled returns true if the feature is enabled for the project by the flags.
+func (f FeatureFlags) enabled(name, projectID string) bool {
+	for _, id := range f[name] {
+		if id == "*" || id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProjectFeatures parses the Features key of the project, returning the map from the names of the features to
+// whether they're enabled for the project.
+func parseProjectFeatures(s string) (map[string]bool, error) {
+	overrides := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		enabled := !strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		if _, ok := findFeature(name); !ok {
+			return nil, fmt.Errorf("unknown feature %s", name)
+		}
+		overrides[name] = enabled
+	}
+	return overrides, nil
+}
+
+// resolveFeatures returns the names of the features enabled for the project by the flags and the overrides of the project, sorted.
+func resolveFeatures(flags FeatureFlags, projectID string, overrides map[string]bool) []string {
+	var enabled []string
+	for _, f := range features {
+		on, ok := overrides[f.Name]
+		if !ok {
+			on = flags.enabled(f.Name, projectID)
+		}
+		if on {
+			enabled = append(enabled, f.Name)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+// describeFeatures returns the text of the features and the projects they're enabled for,
+// or the ones enabled for pj only if it's not nil, for the features command.
+func describeFeatures(flags FeatureFlags, projects []DeployProject, pj *DeployProject) string {
+	lines := []string{"*Features*"}
+	for _, f := range features {
+		if pj != nil {
+			state := "off"
+			if pj.FeatureEnabled(f.Name) {
+				state = "on"
+			}
+			if f.Name == FeaturePinDigest && state == "off" {
+				if phases := pj.pinnedPhases(); len(phases) > 0 {
+					state += fmt.Sprintf(", but pinDigest of %s pins the digests", strings.Join(phases, ", "))
+				}
+			}
+			lines = append(lines, fmt.Sprintf("• `%s` is %s for %s: %s", f.Name, state, pj.ID, f.Description))
+			continue
+		}
+		var ids []string
+		for _, p := range projects {
+			if p.FeatureEnabled(f.Name) {
+				ids = append(ids, p.ID)
+			}
+		}
+		enabledFor := "no project"
+		if len(ids) > 0 {
+			enabledFor = strings.Join(ids, ", ")
+		}
+		flagged := "none"
+		if len(flags[f.Name]) > 0 {
+			flagged = strings.Join(flags[f.Name], ",")
+		}
+		lines = append(lines, fmt.Sprintf("• `%s`: %s\n    CONFIG_FEATURE_FLAGS: %s, enabled for: %s", f.Name, f.Description, flagged, enabledFor))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseFeatureFlags(t *testing.T) {
+	flags, err := ParseFeatureFlags("pin-digest=myapp, web;")
+	require.NoError(t, err)
+	require.Equal(t, FeatureFlags{"pin-digest": {"myapp", "web"}}, flags)
+
+	_, err = ParseFeatureFlags("ast-yaml=*")
+	require.EqualError(t, err, "unknown feature ast-yaml")
+	_, err = ParseFeatureFlags("pin-digest")
+	require.Error(t, err)
+}
+
+func TestProjectFeatures(t *testing.T) {
+	flags := FeatureFlags{"pin-digest": {"*"}}
+
+	parse := func(features string) DeployProject {
+		pj, err := parseProject(v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "myapp"},
+			Data:       map[string]string{"Kind": "kustomize", "Features": features, "Phases": "- name: staging\n- name: production\n  pinDigest: true\n"},
+		}, flags)
+		require.NoError(t, err)
+		return pj
+	}
+	pj := parse("")
+	require.True(t, pj.FeatureEnabled(FeaturePinDigest))
+	require.True(t, pj.Phases[0].PinDigest)
+	require.False(t, pj.Phases[0].preserveYAML)
+
+	// Disabling the feature unpins the phases pinning by themselves as well
+	pj = parse("-pin-digest,preserve-yaml")
+	require.False(t, pj.FeatureEnabled(FeaturePinDigest))
+	require.False(t, pj.Phases[0].PinDigest)
+	require.False(t, pj.Phases[1].PinDigest)
+	require.True(t, pj.Phases[1].preserveYAML)
+	require.Equal(t, "*Features*\n• `pin-digest` is off for myapp: "+features[0].Description+"\n• `preserve-yaml` is on for myapp: "+features[1].Description, describeFeatures(flags, nil, &pj))
+	require.Equal(t, "*Features*\n• `pin-digest`: "+features[0].Description+"\n    CONFIG_FEATURE_FLAGS: *, enabled for: no project\n• `preserve-yaml`: "+features[1].Description+"\n    CONFIG_FEATURE_FLAGS: none, enabled for: myapp", describeFeatures(flags, []DeployProject{pj}, nil))
+
+	// The phases pinning by themselves are reported while the feature is off for the project
+	pj, err := parseProject(v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "myapp"},
+		Data:       map[string]string{"Kind": "kustomize", "Phases": "- name: staging\n- name: production\n  pinDigest: true\n"},
+	}, nil)
+	require.NoError(t, err)
+	require.Contains(t, describeFeatures(nil, nil, &pj), "`pin-digest` is off, but pinDigest of production pins the digests for myapp")
+
+	_, err = parseProject(v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "myapp"}, Data: map[string]string{"Features": "unknown"}}, flags)
+	require.EqualError(t, err, "invalid Features: unknown feature unknown")
+}
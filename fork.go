@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"golang.org/x/xerrors"
+)
+
+// The fork mode, enabled by CONFIG_MANIFEST_FORK_REPOSITORY, is for the manifest repositories that don't let gocat push branches to them.
+// gocat pushes the deploy branches to the fork instead, like the one of the bot account, and opens the cross-repository pull requests
+// from the fork to the manifest repository. The default branch of the fork is fast-forwarded to the one of the manifest repository on every push,
+// so the fork never needs to be synced by hand unless it has commits of its own.
+//
+// The pushes to the default branch, like the ones of commitStrategy: direct, still go to the manifest repository.
+
+// forkRemoteName is the name of the remote of the fork in the clone of the manifest repository.
+const forkRemoteName = "fork"
+
+// UseFork makes the operator push the deploy branches to the fork at repoURL, which is in the same format as repo.
+func (g *GitOperator) UseFork(repoURL string) {
+	g.fork = repoURL
+}
+
+// headRemote returns the remote the deploy branches are pushed to and fetched from, which is the fork in the fork mode and origin otherwise.
+// It adds the remote of the fork to the clone if missing.
+func (g GitOperator) headRemote() (string, error) {
+	if g.fork == "" {
+		return g.originRemote(), nil
+	}
+	if _, err := g.ensureRemote(forkRemoteName, g.fork); err != nil {
+		return "", fmt.Errorf("unable to add the fork %s: %w", g.fork, err)
+	}
+	return forkRemoteName, nil
+}
+
+// ensureRemote returns the remote of the name in the clone, adding it if missing,
+// or replacing it if its URL was changed since the clone under gitRoot was made.
+func (g GitOperator) ensureRemote(name, url string) (*git.Remote, error) {
+	remote, err := g.repository.Remote(name)
+	if err == nil && len(remote.Config().URLs) > 0 && remote.Config().URLs[0] == url {
+		return remote, nil
+	}
+	if err == nil {
+		if err := g.repository.DeleteRemote(name); err != nil {
+			return nil, err
+		}
+	}
+	return g.repository.CreateRemote(&config.RemoteConfig{Name: name, URLs: []string{url}})
+}
+
+// syncFork fast-forwards the default branch of the fork to the one fetched from the manifest repository.
+// The default branch of the fork is never overwritten, so the commits only in the fork, if any, stop the sync until they're dealt with by hand.
+// The deploy branches are made from the default branch of the manifest repository regardless, so it's not fatal if the sync fails.
+func (g GitOperator) syncFork() {
+	remote, err := g.repository.Remote(forkRemoteName)
+	if err != nil {
+		fmt.Println("[ERROR] Failed to find the fork: ", xerrors.New(err.Error()))
+		return
+	}
+	fetched, err := g.fetchDefaultBranch()
+	if err != nil {
+		fmt.Println("[ERROR] Failed to fetch the default branch to sync the fork: ", xerrors.New(err.Error()))
+		return
+	}
+	err = remote.Push(&git.PushOptions{
+		RemoteName: forkRemoteName,
+		Progress:   os.Stdout,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", fetched, g.defaultBranchRef()))},
+		Auth:       g.auth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		fmt.Println("[ERROR] Failed to sync the fork: ", xerrors.New(err.Error()))
+	}
+}
+
+// UseFork makes the pull requests cross-repository ones from the branches of the fork at repoURL.
+func (g *GitHub) UseFork(repoURL string) {
+	g.forkOrg = findRepositoryOrg(repoURL)
+	g.forkRepo = findRepositoryName(repoURL)
+}
+
+// headRef returns the head of the pull request from the branch, which is qualified with the owner of the fork in the fork mode.
+func (g GitHub) headRef(branch string) string {
+	if g.forkOrg == "" {
+		return branch
+	}
+	return g.forkOrg + ":" + strings.TrimPrefix(branch, "refs/heads/")
+}
+
+// headRepository returns the copy of the instance for the repository the deploy branches are in, which is the fork in the fork mode.
+func (g GitHub) headRepository() GitHub {
+	if g.forkOrg == "" {
+		return g
+	}
+	g.org, g.repo = g.forkOrg, g.forkRepo
+	return g
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+func TestGit_Fork(t *testing.T) {
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "manifests.git")
+	require.NoError(t, seedDevRemote(upstream, plumbing.Master))
+	fork := filepath.Join(dir, "fork.git")
+	_, err := git.PlainInit(fork, true)
+	require.NoError(t, err)
+
+	var o GitOperator
+	o.repo = upstream
+	o.defaultBranch = "refs/heads/master"
+	require.NoError(t, o.Clone())
+	o.UseFork(fork)
+
+	phase := DeployPhase{Path: "myapp/overlays/staging/kustomization.yaml"}
+	_, err = o.PushDockerImageTag("bot/deploy", phase, "1a2b3c4", devRegistry+"/myapp", "deploy")
+	require.NoError(t, err)
+
+	// The deploy branch is pushed to the fork only, whose default branch is synced with the upstream
+	u, err := git.PlainOpen(upstream)
+	require.NoError(t, err)
+	f, err := git.PlainOpen(fork)
+	require.NoError(t, err)
+	_, err = u.Reference("refs/heads/bot/deploy", true)
+	require.Error(t, err)
+	_, err = f.Reference("refs/heads/bot/deploy", true)
+	require.NoError(t, err)
+	upstreamMaster, err := u.Reference(plumbing.Master, true)
+	require.NoError(t, err)
+	forkMaster, err := f.Reference(plumbing.Master, true)
+	require.NoError(t, err)
+	require.Equal(t, upstreamMaster.Hash(), forkMaster.Hash())
+
+	// The stacked deploys are pushed on top of the branch in the fork
+	diff, err := o.StackDockerImageTags("bot/deploy", phase, []types.Image{{Name: devRegistry + "/myapp", NewTag: "5d6e7f8"}}, "deploy")
+	require.NoError(t, err)
+	// The diff covers the whole branch, from the tag on the default branch
+	require.Contains(t, diff, "+  newTag: 5d6e7f8")
+	require.Contains(t, diff, "-  newTag: ")
+	require.NotContains(t, diff, "1a2b3c4")
+
+	// The commits only in the fork aren't overwritten by the sync
+	own, err := f.CommitObject(forkMaster.Hash())
+	require.NoError(t, err)
+	own.Message = "only in the fork"
+	obj := f.Storer.NewEncodedObject()
+	require.NoError(t, own.Encode(obj))
+	ownHash, err := f.Storer.SetEncodedObject(obj)
+	require.NoError(t, err)
+	require.NoError(t, f.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, ownHash)))
+	_, err = o.PushDockerImageTag("bot/deploy2", phase, "9a8b7c6", devRegistry+"/myapp", "deploy")
+	require.NoError(t, err)
+	forkMaster, err = f.Reference(plumbing.Master, true)
+	require.NoError(t, err)
+	require.Equal(t, ownHash, forkMaster.Hash())
+}
+
+func TestGitHub_Fork(t *testing.T) {
+	g := GitHub{org: "zaiminc", repo: "manifests"}
+	require.Equal(t, "bot/deploy", g.headRef("bot/deploy"))
+	require.Equal(t, "zaiminc", g.headRepository().org)
+
+	g.UseFork("https://github.com/gocat-bot/manifests-fork.git")
+	require.Equal(t, "gocat-bot:bot/deploy", g.headRef("bot/deploy"))
+	require.Equal(t, "gocat-bot:bot/deploy", g.headRef("refs/heads/bot/deploy"))
+	head := g.headRepository()
+	require.Equal(t, "gocat-bot", head.org)
+	require.Equal(t, "manifests-fork", head.repo)
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -23,6 +29,7 @@ import (
 	"github.com/go-git/go-git/v5/storage/memory"
 	"golang.org/x/xerrors"
 	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 	"sigs.k8s.io/kustomize/api/types"
 )
 
@@ -33,8 +40,8 @@ type GitOperator struct {
 	// repo is the remote repository that contains the gitops config
 	// we are going to modify, or the kanvas config we are going to use for deployment.
 	//
-	// It needs to be in the form of "https://github.com/owner/repo.git",
-	// not "owner/repo" or "repo".
+	// It needs to be the URL like "https://github.com/owner/repo.git" or "git@github.com:owner/repo.git",
+	// or the path to the local repository, not "owner/repo" or "repo".
 	repo          string
 	repository    *git.Repository
 	username      string
@@ -44,9 +51,42 @@ type GitOperator struct {
 	// or the kustomize config we are going to modify.
 	// If empty, we will use in-memory filesystem.
 	gitRoot string
+	// sparseCheckout makes the operator clone without checking out the worktree,
+	// and materialize only the directories each operation needs.
+	//
+	// This is useful for monorepos where the gitops config lives in a small subset
+	// of a large repository, as it drastically reduces the memory usage in memfs mode
+	// and the disk usage in osfs mode.
+	sparseCheckout bool
+	// sops decrypts and updates the SOPS-encrypted files the operator modifies. The sops command is used if nil.
+	sops SOPSClient
+	// fork is the URL of the fork the deploy branches are pushed to instead of repo. See UseFork.
+	fork string
+	// remoteName is the name of the remote of repo in the clone. Defaults to "origin". See originRemote.
+	remoteName string
+	// mirror is the URL of the secondary remote the pushes are mirrored to. See UseMirror.
+	mirror string
+	// mirrorAlert is called with the errors of the mirror. See UseMirror.
+	mirrorAlert func(error)
+	// openPullRequests lists the open pull requests, whose branches are never overwritten. See UsePullRequests.
+	openPullRequests func() ([]OpenPullRequest, error)
+	// pushedDefaultBranch is called after each push to the default branch. See OnDefaultBranchPush.
+	pushedDefaultBranch func()
+	// mu serializes the operations checking out and committing to the clone, which the deploys of the projects share.
+	// It's a pointer as the operator is passed by value. See lock.
+	mu *sync.Mutex
+}
+
+func CreateGitOperatorInstance(username, token, repo, defaultBranch, gitRoot string, sparseCheckout bool, remoteName string) (g GitOperator) {
+	g = newGitOperator(username, token, repo, defaultBranch, gitRoot, sparseCheckout, remoteName)
+	if err := g.Clone(); err != nil {
+		fmt.Println("[ERROR] Failed to Clone: ", xerrors.New(err.Error()))
+	}
+	return
 }
 
-func CreateGitOperatorInstance(username, token, repo, defaultBranch, gitRoot string) (g GitOperator) {
+// newGitOperator returns the operator of the repository without cloning it.
+func newGitOperator(username, token, repo, defaultBranch, gitRoot string, sparseCheckout bool, remoteName string) (g GitOperator) {
 	g.auth = &http.BasicAuth{
 		Username: username, // yes, this can be anything except an empty string
 		Password: token,
@@ -55,12 +95,34 @@ func CreateGitOperatorInstance(username, token, repo, defaultBranch, gitRoot str
 	g.username = username
 	g.defaultBranch = defaultBranch
 	g.gitRoot = gitRoot
-	if err := g.Clone(); err != nil {
-		fmt.Println("[ERROR] Failed to Clone: ", xerrors.New(err.Error()))
-	}
+	g.sparseCheckout = sparseCheckout
+	g.remoteName = remoteName
+	g.mu = &sync.Mutex{}
 	return
 }
 
+// lock locks the clone until the returned function is called.
+// It locks nothing for the operators made without newGitOperator, like the ones in the tests.
+func (g GitOperator) lock() func() {
+	if g.mu == nil {
+		return func() {}
+	}
+	g.mu.Lock()
+	return g.mu.Unlock
+}
+
+// UsePullRequests makes the operator refuse to overwrite the deploy branches left on the remote
+// while they have open pull requests, which are the deploys in progress, not the leftovers of the crashed runs.
+func (g *GitOperator) UsePullRequests(list func() ([]OpenPullRequest, error)) {
+	g.openPullRequests = list
+}
+
+// OnDefaultBranchPush makes the operator call f after each push to the default branch,
+// like GitHub.InvalidateFiles to stop serving the files cached before the push.
+func (g *GitOperator) OnDefaultBranchPush(f func()) {
+	g.pushedDefaultBranch = f
+}
+
 // getLocalRepoRoot returns the path from the gocat's current working directory
 // to the root of the local git repository.
 //
@@ -82,59 +144,87 @@ func CreateGitOperatorInstance(username, token, repo, defaultBranch, gitRoot str
 //
 // it returns "/path/to/gitroot/$host/$owner/$repo".
 //
+// The remotes of ssh://, the scp-like git@host:owner/repo.git, and the local paths, including the ones on Windows, are put likewise.
+// See parseRepoURL for the details.
+//
 // If gitRoot is empty, which means we are using in-memory filesystem,
 // we will return an empty string.
 func (g *GitOperator) getLocalRepoRoot() string {
 	if g.gitRoot != "" {
-		// Without this modification, we will end up with a nested directory structure like this:
-		//
-		// - $GOCAT_GITROOT
-		//   - https:
-		//     - github.com
-		//       - zaiminc
-		//         - gocat.git
-		//
-		// which is not what we want.
-		//
-		// Instead, we want:
-		//
-		// - $GOCAT_GITROOT
-		//  - github.com
-		//    - zaiminc
-		//      - gocat
-		repo := strings.ReplaceAll(g.repo, "https://", "")
-		repo = strings.ReplaceAll(repo, "/", string(os.PathSeparator))
-		repo = strings.TrimSuffix(repo, ".git")
-		return filepath.Join(g.gitRoot, repo)
+		// We don't nest the clone under the scheme of the URL like $GOCAT_GITROOT/https:/github.com/zaiminc/gocat.git,
+		// but put it at $GOCAT_GITROOT/github.com/zaiminc/gocat regardless of the scheme and the OS.
+		u, err := parseRepoURL(g.repo)
+		if err != nil {
+			// The URL go-git can't clone either. We still return the path under gitRoot not to touch anything else.
+			fmt.Println("[ERROR] Failed to parse the repository URL: ", xerrors.New(err.Error()))
+			return filepath.Join(g.gitRoot, url.PathEscape(g.repo))
+		}
+		return u.Dir(g.gitRoot)
 	}
 	return ""
 }
 
+// touch updates the modification time of the local repository root,
+// so that GitRootJanitor can tell which clones are recently used.
+func (g GitOperator) touch() {
+	if p := g.getLocalRepoRoot(); p != "" {
+		now := time.Now()
+		if err := os.Chtimes(p, now, now); err != nil {
+			fmt.Println("[ERROR] Failed to touch the local repository: ", xerrors.New(err.Error()))
+		}
+	}
+}
+
 func (g *GitOperator) Clone() error {
-	var (
-		storage storage.Storer
-		fs      billy.Filesystem
-	)
-	if g.gitRoot != "" {
-		repoRoot := g.getLocalRepoRoot()
-		fs = osfs.New(repoRoot)
-		storage = filesystem.NewStorage(
-			osfs.New(filepath.Join(repoRoot, ".git")),
-			cache.NewObjectLRUDefault(),
-		)
-	} else {
-		storage = memory.NewStorage()
-		fs = memfs.New()
+	storage, fs := g.storage()
+	opts := &git.CloneOptions{
+		URL:        g.Repo(),
+		RemoteName: g.originRemote(),
+		Auth:       g.auth,
+		NoCheckout: g.sparseCheckout,
 	}
-	r, err := git.Clone(storage, fs, &git.CloneOptions{
-		URL:  g.Repo(),
-		Auth: g.auth,
-	})
+	if !g.sparseCheckout {
+		// The overlays of some phases may live in the submodules
+		opts.RecurseSubmodules = git.DefaultSubmoduleRecursionDepth
+	}
+	r, err := git.Clone(storage, fs, opts)
 	g.repository = r
 
+	return gitError(err)
+}
+
+// CloneOrOpen clones the repository, or opens the existing clone under gitRoot
+// if the repository has already been cloned, like by a previous deploy.
+// The caller needs to pull the latest changes, like by checkoutMainBranch, to use the opened clone.
+func (g *GitOperator) CloneOrOpen() error {
+	err := g.Clone()
+	if !errors.Is(err, git.ErrRepositoryAlreadyExists) {
+		return err
+	}
+	storage, fs := g.storage()
+	r, err := git.Open(storage, fs)
+	if err != nil {
+		return fmt.Errorf("unable to open the existing clone at %s: %w", g.getLocalRepoRoot(), err)
+	}
+	g.repository = r
+	// The clone may have been made before the remote name was configured
+	_, err = g.ensureRemote(g.originRemote(), g.Repo())
 	return err
 }
 
+// storage returns the storage and the worktree filesystem for the repository,
+// which are under gitRoot, or in memory if gitRoot is empty.
+func (g *GitOperator) storage() (storage.Storer, billy.Filesystem) {
+	if g.gitRoot == "" {
+		return memory.NewStorage(), memfs.New()
+	}
+	repoRoot := g.getLocalRepoRoot()
+	return filesystem.NewStorage(
+		osfs.New(filepath.Join(repoRoot, ".git")),
+		cache.NewObjectLRUDefault(),
+	), osfs.New(repoRoot)
+}
+
 func (g GitOperator) Clean() error {
 	if p := g.getLocalRepoRoot(); p != "" {
 		// Do our best not to delete unrelated and unintended files!
@@ -158,27 +248,97 @@ func (g GitOperator) Repo() string {
 }
 
 func (g GitOperator) DeleteBranch(branch string) (err error) {
+	defer g.lock()()
+	return g.deleteBranch(branch)
+}
+
+func (g GitOperator) deleteBranch(branch string) (err error) {
 	return g.repository.Storer.RemoveReference(plumbing.ReferenceName(branch))
 }
 
-func (g GitOperator) PushDockerImageTag(id string, phase DeployPhase, tag string, targetTag string) (branch string, err error) {
-	branch = fmt.Sprintf("bot/docker-image-tag-%s-%s-%s", id, phase.Name, tag)
+// PushDockerImageTag commits the change of the image tag to the new branch and pushes it.
+// It returns the unified diff of the commit.
+// See DeployProject.DeployBranchName for the name of the branch.
+func (g GitOperator) PushDockerImageTag(branch string, phase DeployPhase, tag string, targetTag string, message string) (diff string, err error) {
+	return g.PushDockerImageTags(branch, phase, []types.Image{{Name: targetTag, NewTag: tag}}, message)
+}
+
+// PushDockerImageTags is the same as PushDockerImageTag, but changes the tags of multiple images in a single commit.
+func (g GitOperator) PushDockerImageTags(branch string, phase DeployPhase, images []types.Image, message string) (diff string, err error) {
+	_, diff, err = g.pushDockerImageTags(branch, "", plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch)), phase, images, message)
+	return
+}
+
+// StackDockerImageTags is the same as PushDockerImageTags, but pushes the commit on top of the existing remote branch,
+// like the head of an open deploy pull request, instead of creating the new branch from the default branch.
+// The diff is the one of the whole branch, including the commits stacked before.
+func (g GitOperator) StackDockerImageTags(branch string, phase DeployPhase, images []types.Image, message string) (diff string, err error) {
+	_, diff, err = g.pushDockerImageTags(branch, branch, plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch)), phase, images, message)
+	return
+}
 
-	w, err := g.createAndCheckoutNewBranch(branch)
+// PushDockerImageTagsDirectly is the same as PushDockerImageTags, but pushes the commit straight to the default branch
+// instead of the new branch, for the phases not requiring pull requests.
+// It returns the SHA of the commit along with the diff.
+//
+// The push fails if the default branch is protected, or has moved since it was pulled.
+func (g GitOperator) PushDockerImageTagsDirectly(branch string, phase DeployPhase, images []types.Image, message string) (sha string, diff string, err error) {
+	target := g.defaultBranchRef()
+	hash, diff, err := g.pushDockerImageTags(branch, "", target, phase, images, message)
 	if err != nil {
-		return "", err
+		return "", "", fmt.Errorf("unable to push to %s directly. Check if the branch is protected: %w", target.Short(), err)
 	}
+	return hash.String(), diff, nil
+}
 
-	err = g.commit(w, phase.Path, KustomizationOverWrite{tag, targetTag})
+// pushDockerImageTags commits the change of the image tags to the new local branch, and pushes it to target on the remote.
+// The local branch is created from the remote branch onto if given, or from the default branch otherwise.
+func (g GitOperator) pushDockerImageTags(branch string, onto string, target plumbing.ReferenceName, phase DeployPhase, images []types.Image, message string) (hash plumbing.Hash, diff string, err error) {
+	defer g.lock()()
+	// All the paths pushing the image tags, like the deploys, the rollbacks, and the promotions, pass here
+	if err := checkImageTags(phase, images); err != nil {
+		return hash, "", err
+	}
+	// Both kustomization.yaml and configmap.yaml we modify live in the overlay directory,
+	// so that's the only directory we need in case of the sparse checkout.
+	var w *git.Worktree
+	if onto != "" {
+		w, err = g.checkoutRemoteBranch(branch, onto, path.Dir(phase.Path))
+	} else {
+		w, err = g.createAndCheckoutNewBranch(branch, path.Dir(phase.Path))
+	}
 	if err != nil {
-		fmt.Println("[ERROR] Failed to Marshal kustomize.yaml: ", xerrors.New(err.Error()))
-		return
+		return plumbing.ZeroHash, "", err
 	}
 
-	err = g.commit(w, strings.Replace(phase.Path, "kustomization.yaml", "configmap.yaml", -1), MemcachedOverWrite{})
-	if err != nil {
-		fmt.Println("[ERROR] Failed to Write MEMCACHED_PREFIX \\n: ", xerrors.New(err.Error()))
-		return
+	var sub *git.Submodule
+	var subPath, subDiff string
+	if !g.sparseCheckout {
+		sub, subPath, err = submoduleOf(w, phase.Path)
+		if err != nil {
+			return plumbing.ZeroHash, "", err
+		}
+	}
+	if sub != nil {
+		subDiff, err = g.commitImagesInSubmodule(sub, subPath, branch, target == g.defaultBranchRef(), images, phase.preserveYAML, message)
+		if err != nil {
+			fmt.Println("[ERROR] Failed to commit in the submodule: ", xerrors.New(err.Error()))
+			return
+		}
+	} else {
+		for _, image := range images {
+			err = g.commit(w, phase.Path, KustomizationOverWrite{image.NewTag, image.Name, image.Digest, phase.preserveYAML})
+			if err != nil {
+				fmt.Println("[ERROR] Failed to Marshal kustomize.yaml: ", xerrors.New(err.Error()))
+				return
+			}
+		}
+
+		err = g.commit(w, strings.Replace(phase.Path, "kustomization.yaml", "configmap.yaml", -1), MemcachedOverWrite{})
+		if err != nil {
+			fmt.Println("[ERROR] Failed to Write MEMCACHED_PREFIX \\n: ", xerrors.New(err.Error()))
+			return
+		}
 	}
 
 	err = g.verify(w)
@@ -186,8 +346,8 @@ func (g GitOperator) PushDockerImageTag(id string, phase DeployPhase, tag string
 		return
 	}
 
-	hash, _ := w.Commit(
-		fmt.Sprintf("Change docker image tag. target: %s, phase: %s, tag: %s.", phase.Path, phase.Name, tag),
+	hash, _ = w.Commit(
+		message,
 		&git.CommitOptions{
 			Author: &object.Signature{
 				Name:  g.username,
@@ -197,70 +357,629 @@ func (g GitOperator) PushDockerImageTag(id string, phase DeployPhase, tag string
 		})
 	if err := g.repository.Storer.SetReference(plumbing.NewReferenceFromStrings(branch, hash.String())); err != nil {
 		fmt.Println("[ERROR] Failed to SetReference: ", xerrors.New(err.Error()))
+		return plumbing.ZeroHash, "", err
+	}
+
+	if onto != "" {
+		// The commit ships with the ones stacked before it, so the diff covers the whole branch
+		diff, err = g.branchDiff(hash)
+	} else {
+		diff, err = g.diff(hash)
+	}
+	if err != nil {
+		// The diff is informational, so we don't fail the deploy.
+		fmt.Println("[ERROR] Failed to get diff: ", xerrors.New(err.Error()))
+	}
+	// The superproject only bumps the pointer, so the diff of the tags is the one in the submodule
+	diff = subDiff + diff
+
+	err = g.push(branch, target)
+	return
+}
+
+// PushOverWrite commits the change o makes to the file at filePath to the new branch, and pushes it to the remote.
+// It returns the diff of the commit, or an error if the file is missing or unchanged.
+func (g GitOperator) PushOverWrite(branch string, filePath string, o OverWrite, message string) (string, error) {
+	defer g.lock()()
+	w, err := g.createAndCheckoutNewBranch(branch, path.Dir(filePath))
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Filesystem.Stat(filePath); err != nil {
+		return "", fmt.Errorf("%s is not found: %w", filePath, err)
+	}
+	if err := g.commit(w, filePath, o); err != nil {
+		return "", err
+	}
+	if err := g.verify(w); err != nil {
+		return "", err
+	}
+	status, err := w.Status()
+	if err != nil {
 		return "", err
 	}
+	if status.File(filePath).Staging == git.Unmodified {
+		return "", fmt.Errorf("%s is already up to date", filePath)
+	}
 
-	// push
-	remote, err := g.repository.Remote("origin")
+	hash, err := w.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  g.username,
+			Email: "",
+			When:  time.Now(),
+		},
+	})
 	if err != nil {
-		fmt.Println("[ERROR] Failed to Add remote origin: ", xerrors.New(err.Error()))
-		return
+		return "", err
 	}
+	if err := g.repository.Storer.SetReference(plumbing.NewReferenceFromStrings(branch, hash.String())); err != nil {
+		return "", err
+	}
+	diff, err := g.diff(hash)
+	if err != nil {
+		// The diff is informational
+		fmt.Println("[ERROR] Failed to get diff: ", xerrors.New(err.Error()))
+	}
+	if err := g.push(branch, plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch))); err != nil {
+		return "", err
+	}
+	return diff, nil
+}
+
+// push pushes the local branch to target on the remote, or on the fork in the fork mode unless target is the default branch,
+// and then to the mirror, if any.
+func (g GitOperator) push(branch string, target plumbing.ReferenceName) error {
+	remoteName := g.originRemote()
+	if target != g.defaultBranchRef() {
+		var err error
+		if remoteName, err = g.headRemote(); err != nil {
+			return err
+		}
+		if remoteName == forkRemoteName {
+			g.syncFork()
+		}
+	}
+	remote, err := g.repository.Remote(remoteName)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to find the remote %s: %s\n", remoteName, xerrors.New(err.Error()))
+		return err
+	}
+	refSpec := config.RefSpec(plumbing.ReferenceName(branch) + ":" + target)
+	var leases []config.RefSpec
+	if target != g.defaultBranchRef() {
+		// The branch can be left on the remote by the previous run that crashed before opening the pull request,
+		// which would reject the push of the same deploy again. It's overwritten only if it has no open pull request,
+		// and as long as it's still at the commit seen here, just like push --force-with-lease.
+		stale, err := remoteBranchHash(remote, target, g.auth)
+		if err != nil {
+			return gitError(fmt.Errorf("unable to list the branches of %s: %w", remoteName, err))
+		}
+		if !stale.IsZero() {
+			if err := g.checkNoOpenPullRequest(target); err != nil {
+				return err
+			}
+			fmt.Printf("[INFO] Overwriting %s left on %s at %s\n", target.Short(), remoteName, stale)
+			refSpec = "+" + refSpec
+			leases = append(leases, config.RefSpec(stale.String()+":"+target.String()))
+		}
+	}
+	// GitHub explains the rejection by the branch protection in the progress
+	var progress bytes.Buffer
 	err = remote.Push(&git.PushOptions{
-		Progress: os.Stdout,
-		RefSpecs: []config.RefSpec{
-			config.RefSpec(plumbing.ReferenceName(branch) + ":" + plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch))),
+		RemoteName:        remoteName,
+		Progress:          io.MultiWriter(os.Stdout, &progress),
+		RefSpecs:          []config.RefSpec{refSpec},
+		RequireRemoteRefs: leases,
+		Auth:              g.auth,
+	})
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to Push %s: %s\n", remoteName, xerrors.New(err.Error()))
+		return gitError(branchProtectionError(err, progress.String()))
+	}
+	if target == g.defaultBranchRef() && g.pushedDefaultBranch != nil {
+		g.pushedDefaultBranch()
+	}
+	g.pushMirror(branch, target)
+	return nil
+}
+
+// checkNoOpenPullRequest returns an error if the branch has an open pull request.
+func (g GitOperator) checkNoOpenPullRequest(branch plumbing.ReferenceName) error {
+	if g.openPullRequests == nil {
+		return nil
+	}
+	prs, err := g.openPullRequests()
+	if err != nil {
+		return fmt.Errorf("unable to list the open pull requests to overwrite %s: %w", branch.Short(), err)
+	}
+	for _, pr := range prs {
+		if pr.HeadRefName == branch.Short() {
+			return fmt.Errorf("%s already has the open pull request #%d. Close it to deploy again", branch.Short(), pr.Number)
+		}
+	}
+	return nil
+}
+
+// remoteBranchHash returns the commit the branch points to on the remote, or the zero hash if the remote has no such branch.
+func remoteBranchHash(remote *git.Remote, branch plumbing.ReferenceName, auth transport.AuthMethod) (plumbing.Hash, error) {
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err == transport.ErrEmptyRemoteRepository {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for _, ref := range refs {
+		if ref.Name() == branch && ref.Type() == plumbing.HashReference {
+			return ref.Hash(), nil
+		}
+	}
+	return plumbing.ZeroHash, nil
+}
+
+// PushFileDirectly writes the content to the file at filePath, creating it if missing,
+// and pushes the commit straight to the default branch. It returns the SHA of the commit.
+func (g GitOperator) PushFileDirectly(branch string, filePath string, content []byte, message string) (string, error) {
+	defer g.lock()()
+	w, err := g.createAndCheckoutNewBranch(branch, path.Dir(filePath))
+	if err != nil {
+		return "", err
+	}
+
+	if err := w.Filesystem.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return "", err
+	}
+	file, err := w.Filesystem.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	if _, err := w.Add(filePath); err != nil {
+		return "", err
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return "", err
+	}
+	if status.File(filePath).Staging == git.Unmodified {
+		return "", fmt.Errorf("%s is already up to date", filePath)
+	}
+
+	hash, err := w.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  g.username,
+			Email: "",
+			When:  time.Now(),
 		},
-		Auth: g.auth,
 	})
 	if err != nil {
-		fmt.Println("[ERROR] Failed to Push origin: ", xerrors.New(err.Error()))
+		return "", err
 	}
-	return
+	if err := g.repository.Storer.SetReference(plumbing.NewReferenceFromStrings(branch, hash.String())); err != nil {
+		return "", err
+	}
+
+	target := g.defaultBranchRef()
+	if err := g.push(branch, target); err != nil {
+		return "", fmt.Errorf("unable to push to %s directly. Check if the branch is protected: %w", target.Short(), err)
+	}
+	return hash.String(), nil
+}
+
+// maxImageHistoryCommits is the number of the commits of the kustomization PreviousImages walks back at most.
+const maxImageHistoryCommits = 200
+
+// PreviousImages returns the images of the kustomization at the path on the default branch, and the image each of them
+// had before its current tag, keyed by the name, found by walking back the history of the file.
+// The images with no previous tag in the history are missing in previous.
+func (g GitOperator) PreviousImages(kustomizationPath string) (current []types.Image, previous map[string]types.Image, err error) {
+	defer g.lock()()
+	if _, err := g.checkoutMainBranch(path.Dir(kustomizationPath)); err != nil {
+		return nil, nil, err
+	}
+	head, err := g.repository.Reference(g.defaultBranchRef(), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	commits, err := g.repository.Log(&git.LogOptions{From: head.Hash(), FileName: &kustomizationPath})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer commits.Close()
+
+	previous = map[string]types.Image{}
+	for n := 0; n < maxImageHistoryCommits; n++ {
+		c, err := commits.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		f, err := c.File(kustomizationPath)
+		if err != nil {
+			// The file is deleted in the commit
+			break
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return nil, nil, err
+		}
+		var obj types.Kustomization
+		if err := yaml.Unmarshal([]byte(content), &obj); err != nil {
+			// The broken revisions are skipped
+			continue
+		}
+		if current == nil {
+			current = obj.Images
+			continue
+		}
+		for _, image := range obj.Images {
+			if _, ok := previous[image.Name]; ok {
+				continue
+			}
+			for _, cur := range current {
+				if cur.Name == image.Name && (cur.NewTag != image.NewTag || cur.Digest != image.Digest) {
+					previous[image.Name] = image
+				}
+			}
+		}
+		if len(previous) == len(current) {
+			break
+		}
+	}
+	if current == nil {
+		return nil, nil, fmt.Errorf("%s is not found", kustomizationPath)
+	}
+	return current, previous, nil
+}
+
+// PushFiles replaces the files in the directory with the given ones on the new branch, removing the files missing in them,
+// and pushes it to the remote. files are keyed by their paths from the root of the repository, like the ones Files returns.
+// It returns the diff of the commit, or an error if nothing changes.
+func (g GitOperator) PushFiles(branch string, dir string, files map[string][]byte, message string) (string, error) {
+	defer g.lock()()
+	w, err := g.createAndCheckoutNewBranch(branch, dir)
+	if err != nil {
+		return "", err
+	}
+	current, err := g.files(branch, dir)
+	if err != nil {
+		return "", fmt.Errorf("%s is not found: %w", dir, err)
+	}
+	for name := range current {
+		if _, ok := files[name]; !ok {
+			if _, err := w.Remove(name); err != nil {
+				return "", err
+			}
+		}
+	}
+	for name, content := range files {
+		if err := util.WriteFile(w.Filesystem, name, content, 0644); err != nil {
+			return "", err
+		}
+		if _, err := w.Add(name); err != nil {
+			return "", err
+		}
+	}
+	status, err := w.Status()
+	if err != nil {
+		return "", err
+	}
+	// Unlike verify, the files may be added or deleted, but only in the directory
+	modified := false
+	for name, st := range status {
+		if st.Staging == git.Unmodified || st.Staging == git.Untracked {
+			continue
+		}
+		if !strings.HasPrefix(name, dir+"/") {
+			return "", fmt.Errorf("there are some extra file updates outside of %s: %s", dir, name)
+		}
+		modified = true
+	}
+	if !modified {
+		return "", fmt.Errorf("%s is already up to date", dir)
+	}
+
+	hash, err := w.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  g.username,
+			Email: "",
+			When:  time.Now(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := g.repository.Storer.SetReference(plumbing.NewReferenceFromStrings(branch, hash.String())); err != nil {
+		return "", err
+	}
+	diff, err := g.diff(hash)
+	if err != nil {
+		// The diff is informational
+		fmt.Println("[ERROR] Failed to get diff: ", xerrors.New(err.Error()))
+	}
+	if err := g.push(branch, plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch))); err != nil {
+		return "", err
+	}
+	return diff, nil
+}
+
+// Files returns the files in the directory at the commit the local branch points to, like the overlay of the phase
+// at the deploy commit, keyed by their paths from the root of the repository.
+func (g GitOperator) Files(branch string, dir string) (map[string][]byte, error) {
+	defer g.lock()()
+	return g.files(branch, dir)
+}
+
+func (g GitOperator) files(branch string, dir string) (map[string][]byte, error) {
+	ref, err := g.repository.Reference(plumbing.ReferenceName(branch), true)
+	if err != nil {
+		return nil, err
+	}
+	c, err := g.repository.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if dir != "." && dir != "" {
+		if tree, err = tree.Tree(dir); err != nil {
+			return nil, err
+		}
+	}
+	files := map[string][]byte{}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		files[path.Join(dir, f.Name)] = []byte(content)
+		return nil
+	})
+	return files, err
+}
+
+// FetchDefaultBranch fetches the default branch without touching the worktree, and returns the name of its remote-tracking
+// reference, which Files reads the files the deploys shipped at, like right after their pull requests are merged.
+func (g GitOperator) FetchDefaultBranch() (string, error) {
+	defer g.lock()()
+	return g.fetchDefaultBranch()
+}
+
+func (g GitOperator) fetchDefaultBranch() (string, error) {
+	if err := g.repository.Fetch(&git.FetchOptions{RemoteName: g.originRemote(), Auth: g.auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return "", gitError(err)
+	}
+	return plumbing.NewRemoteReferenceName(g.originRemote(), g.defaultBranchRef().Short()).String(), nil
+}
+
+// diff returns the unified diff of the commit against its first parent.
+func (g GitOperator) diff(hash plumbing.Hash) (string, error) {
+	c, err := g.repository.CommitObject(hash)
+	if err != nil {
+		return "", err
+	}
+	parent, err := c.Parent(0)
+	if err != nil {
+		return "", err
+	}
+	patch, err := parent.Patch(c)
+	if err != nil {
+		return "", err
+	}
+	return patch.String(), nil
 }
 
-func (g GitOperator) checkoutMainBranch() (*git.Worktree, error) {
+// branchDiff returns the unified diff of the commit against where its branch forked from the default branch on the remote.
+func (g GitOperator) branchDiff(hash plumbing.Hash) (string, error) {
+	c, err := g.repository.CommitObject(hash)
+	if err != nil {
+		return "", err
+	}
+	ref, err := g.repository.Reference(plumbing.NewRemoteReferenceName(g.originRemote(), g.defaultBranchRef().Short()), true)
+	if err != nil {
+		return "", err
+	}
+	head, err := g.repository.CommitObject(ref.Hash())
+	if err != nil {
+		return "", err
+	}
+	bases, err := c.MergeBase(head)
+	if err != nil {
+		return "", err
+	}
+	if len(bases) == 0 {
+		return "", fmt.Errorf("%s has no common ancestor with %s", hash, ref.Name().Short())
+	}
+	patch, err := bases[0].Patch(c)
+	if err != nil {
+		return "", err
+	}
+	return patch.String(), nil
+}
+
+// checkoutMainBranch checks out the default branch and pulls the latest changes from the remote.
+//
+// dirs is the list of directories to materialize when the sparse checkout is enabled.
+// It is ignored otherwise, and an empty dirs results in the full checkout in either case.
+func (g GitOperator) checkoutMainBranch(dirs ...string) (*git.Worktree, error) {
 	w, err := g.repository.Worktree()
 	if err != nil {
 		return nil, err
 	}
 
-	refName := plumbing.Master
-	if g.defaultBranch != "" {
-		refName = plumbing.ReferenceName(g.defaultBranch)
+	refName := g.defaultBranchRef()
+
+	g.touch()
+
+	if g.isSparse(dirs) {
+		if err := g.sparseCheckoutBranch(w, refName, dirs); err != nil {
+			fmt.Println("[ERROR] Failed to Checkout master sparsely: ", xerrors.New(err.Error()))
+			return nil, err
+		}
+		return w, nil
 	}
 
-	if err := w.Checkout(&git.CheckoutOptions{
+	err = w.Checkout(&git.CheckoutOptions{
 		Create: false,
 		Branch: refName,
-	}); err != nil {
+	})
+	if errors.Is(err, git.ErrUnstagedChanges) {
+		// The files tracked by Git LFS may have their content in place of the pointers, like when git-lfs smudged them in the clone under gitRoot
+		n, rerr := g.restoreLFSPointers(w)
+		if rerr != nil {
+			fmt.Println("[ERROR] Failed to restore the LFS pointers: ", xerrors.New(rerr.Error()))
+		} else if n > 0 {
+			fmt.Printf("[INFO] Restored %d files tracked by Git LFS to their pointers\n", n)
+			err = w.Checkout(&git.CheckoutOptions{Create: false, Branch: refName})
+		}
+	}
+	if err != nil {
 		fmt.Println("[ERROR] Failed to Checkout master: ", xerrors.New(err.Error()))
 		return nil, err
 	}
 
-	if err := w.Pull(&git.PullOptions{RemoteName: "origin", Auth: g.auth}); err != nil && err != git.NoErrAlreadyUpToDate {
-		fmt.Println("[ERROR] Failed to Pull origin/master: ", xerrors.New(err.Error()))
+	if err := w.Pull(&git.PullOptions{RemoteName: g.originRemote(), Auth: g.auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+		fmt.Printf("[ERROR] Failed to Pull %s/%s: %s\n", g.originRemote(), refName.Short(), xerrors.New(err.Error()))
 		fmt.Println("[INFO] Running Clone to see if it fixes the issue")
 		if err := g.Clone(); err != nil {
 			fmt.Println("[ERROR] Failed to Clone: ", xerrors.New(err.Error()))
 		}
 	}
 
+	if err := g.updateSubmodules(w); err != nil {
+		fmt.Println("[ERROR] Failed to update the submodules: ", xerrors.New(err.Error()))
+		return nil, err
+	}
+
 	return w, nil
 }
 
-func (g GitOperator) createAndCheckoutNewBranch(branch string) (*git.Worktree, error) {
-	if err := g.DeleteBranch(branch); err != nil {
+// defaultBranchRef returns the reference name of the default branch of the repository.
+func (g GitOperator) defaultBranchRef() plumbing.ReferenceName {
+	if g.defaultBranch != "" {
+		return plumbing.ReferenceName(g.defaultBranch)
+	}
+	return plumbing.Master
+}
+
+func (g GitOperator) isSparse(dirs []string) bool {
+	return g.sparseCheckout && len(dirs) > 0
+}
+
+// sparseCheckoutBranch fetches the remote and resets the index to the latest commit of refName,
+// writing only the files under dirs to the worktree.
+//
+// We don't use go-git's SparseCheckoutDirectories because it drops the entries outside of dirs from the index,
+// which results in commits that delete everything but dirs.
+// Instead, we keep the full index so that commits contain the whole tree, and leave the rest of the worktree empty.
+func (g GitOperator) sparseCheckoutBranch(w *git.Worktree, refName plumbing.ReferenceName, dirs []string) error {
+	if err := g.repository.Fetch(&git.FetchOptions{RemoteName: g.originRemote(), Auth: g.auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return gitError(err)
+	}
+
+	remoteRef, err := g.repository.Reference(plumbing.NewRemoteReferenceName(g.originRemote(), refName.Short()), true)
+	if err != nil {
+		return err
+	}
+	if err := g.repository.Storer.SetReference(plumbing.NewHashReference(refName, remoteRef.Hash())); err != nil {
+		return err
+	}
+	if err := g.repository.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, refName)); err != nil {
+		return err
+	}
+
+	// MixedReset updates the index without touching the worktree.
+	if err := w.Reset(&git.ResetOptions{Commit: remoteRef.Hash(), Mode: git.MixedReset}); err != nil {
+		return err
+	}
+
+	commit, err := g.repository.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := materializeDir(w.Filesystem, commit, dir); err != nil {
+			return fmt.Errorf("unable to checkout %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// materializeDir writes the files under dir in the commit to fs.
+func materializeDir(fs billy.Filesystem, commit *object.Commit, dir string) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	if dir != "." && dir != "" {
+		tree, err = tree.Tree(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tree.Files().ForEach(func(f *object.File) error {
+		name := path.Join(dir, f.Name)
+		if err := fs.MkdirAll(path.Dir(name), 0755); err != nil {
+			return err
+		}
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		dst, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, r); err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	})
+}
+
+func (g GitOperator) createAndCheckoutNewBranch(branch string, dirs ...string) (*git.Worktree, error) {
+	if err := g.deleteBranch(branch); err != nil {
 		fmt.Println("[ERROR] Failed to DeleteBranch: ", xerrors.New(err.Error()))
 	}
 
 	// checkout
 
-	w, err := g.checkoutMainBranch()
+	w, err := g.checkoutMainBranch(dirs...)
 	if err != nil {
 		return nil, err
 	}
 
+	if g.isSparse(dirs) {
+		// Checking out the new branch with go-git would materialize the whole worktree,
+		// so we point the new branch and HEAD to the current commit by ourselves.
+		head, err := g.repository.Head()
+		if err != nil {
+			return nil, err
+		}
+		refName := plumbing.ReferenceName(branch)
+		if err := g.repository.Storer.SetReference(plumbing.NewHashReference(refName, head.Hash())); err != nil {
+			return nil, err
+		}
+		if err := g.repository.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, refName)); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+
 	err = w.Checkout(&git.CheckoutOptions{
 		Create: true,
 		Branch: plumbing.ReferenceName(branch),
@@ -273,6 +992,66 @@ func (g GitOperator) createAndCheckoutNewBranch(branch string) (*git.Worktree, e
 	return w, nil
 }
 
+// checkoutRemoteBranch fetches the remote branch from the remote, or from the fork in the fork mode,
+// and checks out the new local branch pointing to it.
+func (g GitOperator) checkoutRemoteBranch(branch string, remoteBranch string, dirs ...string) (*git.Worktree, error) {
+	if err := g.deleteBranch(branch); err != nil {
+		fmt.Println("[ERROR] Failed to DeleteBranch: ", xerrors.New(err.Error()))
+	}
+
+	w, err := g.checkoutMainBranch(dirs...)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteName, err := g.headRemote()
+	if err != nil {
+		return nil, err
+	}
+	remoteRef := plumbing.NewRemoteReferenceName(remoteName, remoteBranch)
+	refSpec := config.RefSpec(fmt.Sprintf("+refs/heads/%s:%s", remoteBranch, remoteRef))
+	if err := g.repository.Fetch(&git.FetchOptions{RemoteName: remoteName, RefSpecs: []config.RefSpec{refSpec}, Auth: g.auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, gitError(fmt.Errorf("unable to fetch %s: %w", remoteBranch, err))
+	}
+	ref, err := g.repository.Reference(remoteRef, true)
+	if err != nil {
+		return nil, err
+	}
+
+	refName := plumbing.ReferenceName(branch)
+	if err := g.repository.Storer.SetReference(plumbing.NewHashReference(refName, ref.Hash())); err != nil {
+		return nil, err
+	}
+
+	if g.isSparse(dirs) {
+		if err := g.repository.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, refName)); err != nil {
+			return nil, err
+		}
+		if err := w.Reset(&git.ResetOptions{Commit: ref.Hash(), Mode: git.MixedReset}); err != nil {
+			return nil, err
+		}
+		commit, err := g.repository.CommitObject(ref.Hash())
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			if err := materializeDir(w.Filesystem, commit, dir); err != nil {
+				return nil, fmt.Errorf("unable to checkout %s: %w", dir, err)
+			}
+		}
+		return w, nil
+	}
+
+	if err := w.Checkout(&git.CheckoutOptions{Branch: refName}); err != nil {
+		fmt.Println("[ERROR] Failed to Checkout the remote branch: ", xerrors.New(err.Error()))
+		return nil, err
+	}
+	if err := g.updateSubmodules(w); err != nil {
+		return nil, fmt.Errorf("unable to update the submodules: %w", err)
+	}
+	return w, nil
+}
+
 type ConfigMap struct {
 	APIVersion string            `yaml:"apiVersion"`
 	Kind       string            `yaml:"kind"`
@@ -291,7 +1070,24 @@ func (g GitOperator) verify(w *git.Worktree) (err error) {
 		return
 	}
 
+	var lfsTracked func(name string) bool
 	for path, status := range status {
+		// In case of the sparse checkout, files outside of the checked out directories
+		// are missing in the worktree but kept intact in the index, so they are never committed.
+		if g.sparseCheckout && status.Staging == git.Unmodified {
+			continue
+		}
+		// Likewise, the files tracked by Git LFS are committed as the pointers in the index even if their content is in the worktree
+		if status.Staging == git.Unmodified {
+			if lfsTracked == nil {
+				if lfsTracked, err = g.headLFSMatcher(); err != nil {
+					return err
+				}
+			}
+			if lfsTracked(path) {
+				continue
+			}
+		}
 		if status.Staging != git.Modified {
 			fmt.Printf("[ERROR] There are some extra file updates. File: %v %s", status, path)
 			return xerrors.New("There are some extra file updates")
@@ -323,12 +1119,16 @@ func (g GitOperator) commit(w *git.Worktree, targetFilePath string, o OverWrite)
 		return
 	}
 
+	if isSOPSEncrypted(b) {
+		o = SOPSOverWrite{overWrite: o, sops: g.sopsClient()}
+	}
+
 	obj, err := o.Update(b)
 	if err != nil {
 		return
 	}
 
-	rb, err := yaml.Marshal(&obj)
+	rb, err := marshalOverWrite(obj)
 	if err != nil {
 		fmt.Println("[ERROR] Failed to Marshal kustomize.yaml: ", xerrors.New(err.Error()))
 		return
@@ -352,10 +1152,13 @@ func (g GitOperator) commit(w *git.Worktree, targetFilePath string, o OverWrite)
 		return
 	}
 
-	_, err = file.Write([]byte("\n"))
-	if err != nil {
-		fmt.Println("[ERROR] Failed to Write \\n: ", xerrors.New(err.Error()))
-		return
+	// The files rendered by the OverWrites themselves end as they did
+	if _, rendered := obj.([]byte); !rendered {
+		_, err = file.Write([]byte("\n"))
+		if err != nil {
+			fmt.Println("[ERROR] Failed to Write \\n: ", xerrors.New(err.Error()))
+			return
+		}
 	}
 
 	// git add
@@ -370,9 +1173,18 @@ func (g GitOperator) commit(w *git.Worktree, targetFilePath string, o OverWrite)
 type KustomizationOverWrite struct {
 	tag       string
 	targetTag string
+	// digest is written along with the tag, or cleared if empty,
+	// as kustomize prefers the digest to the tag and a stale digest would make the new tag ignored.
+	digest string
+	// preserve edits the images as the YAML nodes, keeping the comments and the order of the keys of the file,
+	// instead of rewriting the file from the Kustomization object. See FeaturePreserveYAML.
+	preserve bool
 }
 
 func (o KustomizationOverWrite) Update(b []byte) (interface{}, error) {
+	if o.preserve {
+		return o.updateNodes(b)
+	}
 	obj := types.Kustomization{}
 	err := yaml.Unmarshal([]byte(b), &obj)
 	if err != nil {
@@ -382,6 +1194,7 @@ func (o KustomizationOverWrite) Update(b []byte) (interface{}, error) {
 	for i, image := range obj.Images {
 		if image.Name == o.targetTag {
 			obj.Images[i].NewTag = o.tag
+			obj.Images[i].Digest = o.digest
 			updated = true
 		}
 	}
@@ -390,11 +1203,60 @@ func (o KustomizationOverWrite) Update(b []byte) (interface{}, error) {
 		obj.Images = append(obj.Images, types.Image{
 			Name:   o.targetTag,
 			NewTag: o.tag,
+			Digest: o.digest,
 		})
 	}
 	return obj, nil
 }
 
+func (o KustomizationOverWrite) updateNodes(b []byte) (interface{}, error) {
+	docs, err := decodeYAMLDocuments(b)
+	if err != nil {
+		return nil, err
+	}
+	var m *yamlv3.Node
+	if len(docs) > 0 {
+		m = yamlMapping(docs[0])
+	}
+	if m == nil {
+		return nil, fmt.Errorf("kustomization.yaml is not a mapping")
+	}
+	images := yamlValue(m, "images")
+	if images == nil || images.Kind != yamlv3.SequenceNode {
+		if images == nil {
+			images = &yamlv3.Node{}
+			m.Content = append(m.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: "images"}, images)
+		}
+		// Replaces the empty values, like images: [] or images: null
+		*images = yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq"}
+	}
+	updated := false
+	for _, image := range images.Content {
+		if image.Kind != yamlv3.MappingNode || yamlString(image, "name") != o.targetTag {
+			continue
+		}
+		o.setNodes(image)
+		updated = true
+	}
+	if !updated {
+		image := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		setYAMLStrings(image, nil, map[string]string{"name": o.targetTag})
+		o.setNodes(image)
+		images.Style = 0
+		images.Content = append(images.Content, image)
+	}
+	return encodeYAMLDocuments(docs)
+}
+
+func (o KustomizationOverWrite) setNodes(image *yamlv3.Node) {
+	setYAMLStrings(image, nil, map[string]string{"newTag": o.tag})
+	if o.digest != "" {
+		setYAMLStrings(image, nil, map[string]string{"digest": o.digest})
+	} else {
+		deleteYAMLKey(image, "digest")
+	}
+}
+
 type MemcachedOverWrite struct {
 }
 
@@ -409,3 +1271,41 @@ func (o MemcachedOverWrite) Update(b []byte) (interface{}, error) {
 	}
 	return obj, nil
 }
+
+func (g GitOperator) sopsClient() SOPSClient {
+	if g.sops == nil {
+		return sopsCLI{}
+	}
+	return g.sops
+}
+
+// AnnotationOverWrite sets the annotations on the metadata of the documents of the kind in the manifest,
+// or of all the documents if the kind is empty, keeping the comments, the order of the keys, and the other documents intact.
+// The annotations are set on spec.template.metadata as well for the kinds templating another resource.
+type AnnotationOverWrite struct {
+	kind        string
+	annotations map[string]string
+}
+
+func (o AnnotationOverWrite) Update(b []byte) (interface{}, error) {
+	docs, err := decodeYAMLDocuments(b)
+	if err != nil {
+		return nil, err
+	}
+	updated := false
+	for _, doc := range docs {
+		m := yamlMapping(doc)
+		if m == nil || (o.kind != "" && yamlString(m, "kind") != o.kind) {
+			continue
+		}
+		setYAMLStrings(m, []string{"metadata", "annotations"}, o.annotations)
+		if template := yamlValue(yamlValue(m, "spec"), "template"); template != nil && template.Kind == yamlv3.MappingNode {
+			setYAMLStrings(template, []string{"metadata", "annotations"}, o.annotations)
+		}
+		updated = true
+	}
+	if !updated {
+		return nil, fmt.Errorf("no %s found in the manifest", o.kind)
+	}
+	return encodeYAMLDocuments(docs)
+}
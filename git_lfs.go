@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// go-git doesn't support Git LFS, so the files tracked by it are the pointer files in the clones of GitOperator,
+// which is fine as gocat never needs their content. We keep them that way, never materializing nor committing their content:
+// the files the smudge filter of git-lfs or the tools run in the worktree replaced with their content are restored to the pointers,
+// and they're left out of the extra file updates verify rejects.
+
+// lfsMatcher returns the function telling whether the file is tracked by Git LFS by the .gitattributes files in the commit.
+// The files in the sparse checkout are matched as well, as the .gitattributes files are read from the commit, not from the worktree.
+func lfsMatcher(commit *object.Commit) (func(name string) bool, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var patterns []gitattributes.MatchAttribute
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if path.Base(f.Name) != ".gitattributes" {
+			return nil
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		var domain []string
+		if dir := path.Dir(f.Name); dir != "." {
+			domain = strings.Split(dir, "/")
+		}
+		attrs, err := gitattributes.ReadAttributes(strings.NewReader(content), domain, len(domain) == 0)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", f.Name, err)
+		}
+		patterns = append(patterns, attrs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return func(string) bool { return false }, nil
+	}
+	m := gitattributes.NewMatcher(patterns)
+	return func(name string) bool {
+		results, _ := m.Match(strings.Split(name, "/"), []string{"filter"})
+		filter, ok := results["filter"]
+		return ok && filter.IsValueSet() && filter.Value() == "lfs"
+	}, nil
+}
+
+// headLFSMatcher returns the lfsMatcher of the commit HEAD points to.
+func (g GitOperator) headLFSMatcher() (func(name string) bool, error) {
+	head, err := g.repository.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := g.repository.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return lfsMatcher(commit)
+}
+
+// restoreLFSPointers restores the files tracked by Git LFS modified in the worktree to the pointers in the index,
+// and returns the number of the files restored. The other modifications are left as they are.
+func (g GitOperator) restoreLFSPointers(w *git.Worktree) (int, error) {
+	status, err := w.Status()
+	if err != nil {
+		return 0, err
+	}
+	tracked, err := g.headLFSMatcher()
+	if err != nil {
+		return 0, err
+	}
+	idx, err := g.repository.Storer.Index()
+	if err != nil {
+		return 0, err
+	}
+	restored := 0
+	for name, st := range status {
+		if st.Staging != git.Unmodified || st.Worktree != git.Modified || !tracked(name) {
+			continue
+		}
+		entry, err := idx.Entry(name)
+		if err != nil {
+			return restored, err
+		}
+		blob, err := g.repository.BlobObject(entry.Hash)
+		if err != nil {
+			return restored, err
+		}
+		r, err := blob.Reader()
+		if err != nil {
+			return restored, err
+		}
+		pointer, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return restored, err
+		}
+		mode, err := entry.Mode.ToOSFileMode()
+		if err != nil {
+			return restored, err
+		}
+		if err := util.WriteFile(w.Filesystem, name, pointer, mode); err != nil {
+			return restored, fmt.Errorf("unable to restore the LFS pointer of %s: %w", name, err)
+		}
+		restored++
+	}
+	return restored, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/slack-go/slack"
+	"golang.org/x/xerrors"
+)
+
+// The mirror, enabled by CONFIG_MANIFEST_MIRROR_REPOSITORY, is the secondary remote every push to the manifest repository is mirrored to,
+// like the internal mirror the air-gapped clusters sync from. The deploy branch is pushed to the mirror right after the manifest repository,
+// along with the default branch fetched from the manifest repository, so that the deploys merged in the manifest repository reach the mirror by the next push at the latest.
+//
+// The deploy branches of the mirror are overwritten, but the default branch is only fast-forwarded, so that the mirror never goes back
+// to the default branch of an outdated clone. The mirror is pushed with the same credentials.
+//
+// The failures to mirror don't fail the deploys, which have already reached the manifest repository.
+// They're logged and alerted instead, and the next push catches the mirror up.
+
+// mirrorRemoteName is the name of the remote of the mirror in the clone of the manifest repository.
+const mirrorRemoteName = "mirror"
+
+// originRemote returns the name of the remote of the manifest repository in the clone, which is origin unless configured otherwise.
+func (g GitOperator) originRemote() string {
+	if g.remoteName == "" {
+		return "origin"
+	}
+	return g.remoteName
+}
+
+// UseMirror makes the operator mirror the pushes to the repository at repoURL, which is in the same format as repo.
+// alert is called with the error when the mirror fails to follow a push, if not nil.
+func (g *GitOperator) UseMirror(repoURL string, alert func(error)) {
+	g.mirror = repoURL
+	g.mirrorAlert = alert
+}
+
+// pushMirror pushes the local branch to target on the mirror, along with the default branch, if the mirror is enabled.
+// The push to the manifest repository has already succeeded, so the failure is only logged and alerted.
+func (g GitOperator) pushMirror(branch string, target plumbing.ReferenceName) {
+	if g.mirror == "" {
+		return
+	}
+	if err := g.mirrorPush(branch, target); err != nil {
+		fmt.Println("[ERROR] Failed to push to the mirror: ", xerrors.New(err.Error()))
+		if g.mirrorAlert != nil {
+			g.mirrorAlert(err)
+		}
+	}
+}
+
+func (g GitOperator) mirrorPush(branch string, target plumbing.ReferenceName) error {
+	remote, err := g.ensureRemote(mirrorRemoteName, g.mirror)
+	if err != nil {
+		return fmt.Errorf("unable to add the mirror %s: %w", g.mirror, err)
+	}
+	var refSpecs []config.RefSpec
+	if ref := g.defaultBranchRef(); target != ref {
+		// The deploy branch is gocat's own, which can be left on the mirror by the previous deploy
+		refSpecs = append(refSpecs, config.RefSpec(fmt.Sprintf("+%s:%s", branch, target)))
+		fetched, err := g.fetchDefaultBranch()
+		if err != nil {
+			return fmt.Errorf("unable to fetch %s to mirror: %w", ref.Short(), err)
+		}
+		refSpecs = append(refSpecs, config.RefSpec(fmt.Sprintf("%s:%s", fetched, ref)))
+	} else {
+		refSpecs = append(refSpecs, config.RefSpec(fmt.Sprintf("%s:%s", branch, target)))
+	}
+	err = remote.Push(&git.PushOptions{
+		RemoteName: mirrorRemoteName,
+		Progress:   os.Stdout,
+		RefSpecs:   refSpecs,
+		Auth:       g.auth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return gitError(fmt.Errorf("unable to mirror %s to %s: %w", target.Short(), g.mirror, err))
+	}
+	return nil
+}
+
+// slackMirrorAlert returns the alert of UseMirror posting to the channel, or nil if channel is empty.
+func slackMirrorAlert(client *slack.Client, channel string) func(error) {
+	if channel == "" {
+		return nil
+	}
+	return func(err error) {
+		text := fmt.Sprintf(":warning: gocat failed to mirror the manifest repository, which the next deploy retries: %s", err)
+		if _, _, err := client.PostMessage(channel, slack.MsgOptionText(text, false)); err != nil {
+			log.Printf("[ERROR] Failed to post the mirror alert: %s", err)
+		}
+	}
+}
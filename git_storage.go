@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/shurcooL/githubv4"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// The storage modes of the clone of the manifest repository, set by CONFIG_GIT_STORAGE.
+const (
+	// GitStorageAuto clones into memory unless the repository is larger than CONFIG_MEMFS_MAX_REPO_SIZE.
+	GitStorageAuto = "auto"
+	// GitStorageMemory always clones into memory.
+	GitStorageMemory = "memory"
+	// GitStorageDisk always clones onto the disk, into the temporary directory if GOCAT_GITROOT is empty.
+	GitStorageDisk = "disk"
+)
+
+// defaultMemFSMaxRepoSize is the size of the largest repository cloned into memory unless CONFIG_MEMFS_MAX_REPO_SIZE is set.
+// The clone in memory takes a few times as much as the size GitHub reports, which is the one of the packed objects.
+const defaultMemFSMaxRepoSize = 256 << 20
+
+// RepositoryDiskUsage returns the size of the repository in bytes GitHub reports.
+func (g GitHub) RepositoryDiskUsage() (int64, error) {
+	var query struct {
+		Repository struct {
+			// DiskUsage is in kilobytes
+			DiskUsage int
+		} `graphql:"repository(owner: $org, name: $repo)"`
+	}
+	variables := map[string]interface{}{
+		"repo": githubv4.String(g.repo),
+		"org":  githubv4.String(g.org),
+	}
+	if err := g.client.Query(context.Background(), &query, variables); err != nil {
+		return 0, err
+	}
+	return int64(query.Repository.DiskUsage) << 10, nil
+}
+
+// selectGitRoot returns the directory to clone the manifest repository into, or an empty string to clone it into memory,
+// so that the repositories too large for the memory are cloned onto the disk instead of getting gocat killed by OOM.
+// GOCAT_GITROOT, if set, is always used.
+func selectGitRoot(gitRoot string, mode string, maxMemFSSize int64, diskUsage func() (int64, error)) string {
+	if gitRoot != "" {
+		return gitRoot
+	}
+	fallback := filepath.Join(os.TempDir(), "gocat")
+	switch mode {
+	case GitStorageMemory:
+		return ""
+	case GitStorageDisk:
+		return fallback
+	}
+	if maxMemFSSize <= 0 {
+		return ""
+	}
+	size, err := diskUsage()
+	if err != nil {
+		// Keep cloning into memory as before, as the size is only a guess of the memory the clone takes
+		log.Printf("[WARNING] Unable to get the size of the manifest repository, cloning it into memory: %s", err)
+		return ""
+	}
+	if size > maxMemFSSize {
+		log.Printf("[INFO] The manifest repository is %s, which is larger than %s. Cloning it into %s instead of memory", resource.NewQuantity(size, resource.BinarySI), resource.NewQuantity(maxMemFSSize, resource.BinarySI), fallback)
+		return fallback
+	}
+	return ""
+}
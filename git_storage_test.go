@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectGitRoot(t *testing.T) {
+	fallback := filepath.Join(os.TempDir(), "gocat")
+	size := func(n int64) func() (int64, error) {
+		return func() (int64, error) { return n, nil }
+	}
+	failed := func() (int64, error) { return 0, errors.New("timeout") }
+
+	require.Equal(t, "/data/gocat", selectGitRoot("/data/gocat", GitStorageAuto, 100, size(1000)))
+	require.Equal(t, "", selectGitRoot("", GitStorageMemory, 100, size(1000)))
+	require.Equal(t, fallback, selectGitRoot("", GitStorageDisk, 100, size(10)))
+	require.Equal(t, "", selectGitRoot("", GitStorageAuto, 100, size(100)))
+	require.Equal(t, fallback, selectGitRoot("", GitStorageAuto, 100, size(101)))
+	require.Equal(t, "", selectGitRoot("", GitStorageAuto, 0, size(1000)))
+	require.Equal(t, "", selectGitRoot("", GitStorageAuto, 100, failed))
+}
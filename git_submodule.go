@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// The repository may include the overlays of some phases via git submodules.
+// The submodules are cloned and updated to the commits the superproject points to along with the superproject,
+// except for the sparse checkout, which doesn't support them.
+//
+// The tags of the phase inside a submodule are committed to the branch of the same name in the submodule,
+// which is pushed to the origin of the submodule, and the superproject commits the new pointer to the commit.
+// Once the superproject ships the pointer, the default branch of the submodule is fast-forwarded to the commit (see AdvanceSubmodule),
+// so that the commit never dangles after the branch is gone. The direct commits are pushed straight to the default branch of the submodule.
+// The submodules are pushed to their own origins, not to the fork or the mirror of the superproject.
+// go-git looks the submodules up by their names when checking out, so the names need to be their paths, which git submodule add defaults to.
+
+// updateSubmodules initializes the submodules of the worktree, and updates them to the commits the superproject points to.
+func (g GitOperator) updateSubmodules(w *git.Worktree) error {
+	subs, err := w.Submodules()
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	return subs.Update(&git.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		Auth:              g.auth,
+	})
+}
+
+// submoduleOf returns the submodule the file at filePath lives in, and the path of the file relative to the submodule.
+func submoduleOf(w *git.Worktree, filePath string) (*git.Submodule, string, error) {
+	subs, err := w.Submodules()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, sub := range subs {
+		prefix := strings.TrimSuffix(sub.Config().Path, "/") + "/"
+		if strings.HasPrefix(filePath, prefix) {
+			return sub, strings.TrimPrefix(filePath, prefix), nil
+		}
+	}
+	return nil, "", nil
+}
+
+// commitImagesInSubmodule commits the tags of the phase inside the submodule to the branch in the submodule, pushes it,
+// and stages the new pointer to the commit in the superproject. It returns the diff of the commit in the submodule.
+// preserveYAML edits kustomization.yaml as the YAML nodes. See FeaturePreserveYAML.
+func (g GitOperator) commitImagesInSubmodule(sub *git.Submodule, filePath string, branch string, direct bool, images []types.Image, preserveYAML bool, message string) (string, error) {
+	r, err := sub.Repository()
+	if err != nil {
+		return "", fmt.Errorf("unable to open the submodule %s: %w", sub.Config().Path, err)
+	}
+	sw, err := r.Worktree()
+	if err != nil {
+		return "", err
+	}
+	// The submodule is checked out at the commit the superproject points to, which is where the branch starts from.
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+	ref := plumbing.NewBranchReferenceName(branch)
+	if err := r.Storer.SetReference(plumbing.NewHashReference(ref, head.Hash())); err != nil {
+		return "", err
+	}
+	if err := sw.Checkout(&git.CheckoutOptions{Branch: ref}); err != nil {
+		return "", fmt.Errorf("unable to checkout %s in the submodule %s: %w", branch, sub.Config().Path, err)
+	}
+
+	sg := g
+	sg.repository = r
+	for _, image := range images {
+		if err := sg.commit(sw, filePath, KustomizationOverWrite{image.NewTag, image.Name, image.Digest, preserveYAML}); err != nil {
+			return "", err
+		}
+	}
+	if err := sg.commit(sw, strings.Replace(filePath, "kustomization.yaml", "configmap.yaml", -1), MemcachedOverWrite{}); err != nil {
+		return "", err
+	}
+	if err := sg.verify(sw); err != nil {
+		return "", err
+	}
+	hash, err := sw.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{
+			Name: g.username,
+			When: time.Now(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	diff, err := sg.diff(hash)
+	if err != nil {
+		fmt.Println("[ERROR] Failed to get diff in the submodule: ", err)
+	}
+	target := ref
+	if direct {
+		if target, err = submoduleDefaultBranch(sub, r, g.auth); err != nil {
+			return "", err
+		}
+	}
+	if err := pushSubmodule(r, ref, target, !direct, g.auth); err != nil {
+		return "", fmt.Errorf("unable to push %s of the submodule %s: %w", target.Short(), sub.Config().Path, err)
+	}
+
+	if err := g.bumpSubmodule(sub.Config().Path, hash); err != nil {
+		return "", fmt.Errorf("unable to bump the pointer to the submodule %s: %w", sub.Config().Path, err)
+	}
+	return diff, nil
+}
+
+// bumpSubmodule stages the pointer to the commit of the submodule at subPath in the superproject.
+// It updates the index by itself, as go-git's Worktree.Add doesn't stage the submodules.
+func (g GitOperator) bumpSubmodule(subPath string, hash plumbing.Hash) error {
+	idx, err := g.repository.Storer.Index()
+	if err != nil {
+		return err
+	}
+	entry, err := idx.Entry(subPath)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	return g.repository.Storer.SetIndex(idx)
+}
+
+// pushSubmodule pushes the local branch of the submodule to target on the origin of the submodule.
+// The deploy branches are gocat's own, so they're overwritten, while the default branch is only fast-forwarded.
+func pushSubmodule(r *git.Repository, branch, target plumbing.ReferenceName, force bool, auth transport.AuthMethod) error {
+	refSpec := config.RefSpec(branch + ":" + target)
+	if force {
+		refSpec = "+" + refSpec
+	}
+	err := r.Push(&git.PushOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{refSpec},
+		Auth:       auth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return gitError(err)
+	}
+	return nil
+}
+
+// submoduleDefaultBranch returns the branch of the submodule in .gitmodules, or the HEAD of its origin if unset.
+func submoduleDefaultBranch(sub *git.Submodule, r *git.Repository, auth transport.AuthMethod) (plumbing.ReferenceName, error) {
+	if branch := sub.Config().Branch; branch != "" {
+		return plumbing.NewBranchReferenceName(branch), nil
+	}
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return "", err
+	}
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", gitError(fmt.Errorf("unable to list the branches of the submodule %s: %w", sub.Config().Path, err))
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target(), nil
+		}
+	}
+	return "", fmt.Errorf("unable to find the default branch of the submodule %s. Set its branch in .gitmodules", sub.Config().Path)
+}
+
+// AdvanceSubmodule fast-forwards the default branch of the submodule the phase lives in, if any,
+// to the commit the default branch of the superproject points to, like after the deploy pull request bumping it is merged.
+func (g GitOperator) AdvanceSubmodule(phase DeployPhase) error {
+	defer g.lock()()
+	if g.sparseCheckout {
+		return nil
+	}
+	w, err := g.checkoutMainBranch()
+	if err != nil {
+		return err
+	}
+	sub, _, err := submoduleOf(w, phase.Path)
+	if err != nil || sub == nil {
+		return err
+	}
+	r, err := sub.Repository()
+	if err != nil {
+		return fmt.Errorf("unable to open the submodule %s: %w", sub.Config().Path, err)
+	}
+	// The submodule has been updated to the pointer by checkoutMainBranch
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	target, err := submoduleDefaultBranch(sub, r, g.auth)
+	if err != nil {
+		return err
+	}
+	ref := plumbing.NewBranchReferenceName("gocat-advance")
+	if err := r.Storer.SetReference(plumbing.NewHashReference(ref, head.Hash())); err != nil {
+		return err
+	}
+	defer r.Storer.RemoveReference(ref)
+	if err := pushSubmodule(r, ref, target, false, g.auth); err != nil {
+		return fmt.Errorf("unable to fast-forward %s of the submodule %s to %s: %w", target.Short(), sub.Config().Path, head.Hash(), err)
+	}
+	return nil
+}
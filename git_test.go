@@ -1,6 +1,22 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+)
 
 func TestGit_FSOS(t *testing.T) {
 	if testing.Short() {
@@ -30,3 +46,538 @@ func TestGit_Mem(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestGit_SparseCheckout(t *testing.T) {
+	remote := t.TempDir()
+	r, err := git.PlainInit(remote, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"myapp/overlays/staging/kustomization.yaml": "images:\n- name: myapp\n  newTag: aaaaaaa\n",
+		"other/overlays/staging/kustomization.yaml": "images:\n- name: other\n  newTag: bbbbbbb\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(remote, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(remote, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	o.sparseCheckout = true
+
+	if err := o.Clone(); err != nil {
+		t.Fatal(err)
+	}
+
+	wt, err := o.createAndCheckoutNewBranch("bot/test", "myapp/overlays/staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Filesystem.Stat("myapp/overlays/staging/kustomization.yaml"); err != nil {
+		t.Errorf("expected the overlay to be checked out: %v", err)
+	}
+	if _, err := wt.Filesystem.Stat("other/overlays/staging/kustomization.yaml"); err == nil {
+		t.Errorf("expected the other overlay not to be checked out")
+	}
+
+	err = o.commit(wt, "myapp/overlays/staging/kustomization.yaml", KustomizationOverWrite{tag: "ccccccc", targetTag: "myapp"})
+	require.NoError(t, err)
+	require.NoError(t, o.verify(wt))
+
+	// Files outside of the sparse checkout directories must be kept in the commit.
+	hash, err := wt.Commit("update", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+	c, err := o.repository.CommitObject(hash)
+	require.NoError(t, err)
+	_, err = c.File("other/overlays/staging/kustomization.yaml")
+	require.NoError(t, err)
+	f, err := c.File("myapp/overlays/staging/kustomization.yaml")
+	require.NoError(t, err)
+	content, err := f.Contents()
+	require.NoError(t, err)
+	require.Contains(t, content, "ccccccc")
+}
+
+func TestKustomizationOverWrite_Digest(t *testing.T) {
+	b := []byte("images:\n- name: myapp\n  newTag: latest\n  digest: sha256:aaaa\n")
+
+	got, err := KustomizationOverWrite{tag: "latest", targetTag: "myapp", digest: "sha256:bbbb"}.Update(b)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:bbbb", got.(types.Kustomization).Images[0].Digest)
+
+	// A stale digest must not be left behind, or kustomize ignores the new tag
+	got, err = KustomizationOverWrite{tag: "abcdef0", targetTag: "myapp"}.Update(b)
+	require.NoError(t, err)
+	require.Equal(t, "abcdef0", got.(types.Kustomization).Images[0].NewTag)
+	require.Equal(t, "", got.(types.Kustomization).Images[0].Digest)
+}
+
+func TestKustomizationOverWrite_Preserve(t *testing.T) {
+	b := []byte("resources:\n- ../../base\n# the tag deployed by gocat\nimages:\n- name: myapp # the app\n  newTag: latest\n  digest: sha256:aaaa\n")
+
+	got, err := KustomizationOverWrite{tag: "abcdef0", targetTag: "myapp", preserve: true}.Update(b)
+	require.NoError(t, err)
+	require.Equal(t, "resources:\n  - ../../base\n# the tag deployed by gocat\nimages:\n  - name: myapp # the app\n    newTag: abcdef0\n", string(got.([]byte)))
+
+	got, err = KustomizationOverWrite{tag: "1.0", targetTag: "worker", digest: "sha256:bbbb", preserve: true}.Update([]byte("resources: []\n"))
+	require.NoError(t, err)
+	require.Equal(t, "resources: []\nimages:\n  - name: worker\n    newTag: \"1.0\"\n    digest: sha256:bbbb\n", string(got.([]byte)))
+}
+
+func TestGit_PushFiles(t *testing.T) {
+	remote := t.TempDir()
+	r, err := git.PlainInit(remote, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	files := map[string]string{
+		"myapp/overlays/production/kustomization.yaml": "images:\n- name: myapp\n  newTag: bbbbbbb\n",
+		"myapp/overlays/production/patch.yaml":         "replicas: 3\n",
+		"other/overlays/production/kustomization.yaml": "images:\n- name: other\n  newTag: ccccccc\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Join(remote, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(remote, name), []byte(content), 0644))
+		_, err := w.Add(name)
+		require.NoError(t, err)
+	}
+	_, err = w.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	require.NoError(t, o.Clone())
+
+	// The overlay at the archived deploy had no patch
+	archived := map[string][]byte{
+		"myapp/overlays/production/kustomization.yaml": []byte("images:\n- name: myapp\n  newTag: aaaaaaa\n"),
+	}
+	diff, err := o.PushFiles("bot/redeploy", "myapp/overlays/production", archived, "redeploy")
+	require.NoError(t, err)
+	require.Contains(t, diff, "+  newTag: aaaaaaa")
+
+	restored, err := o.Files("bot/redeploy", "myapp/overlays/production")
+	require.NoError(t, err)
+	require.Equal(t, archived, restored)
+	ref, err := r.Reference("refs/heads/bot/redeploy", true)
+	require.NoError(t, err)
+	c, err := r.CommitObject(ref.Hash())
+	require.NoError(t, err)
+	_, err = c.File("other/overlays/production/kustomization.yaml")
+	require.NoError(t, err)
+	_, err = c.File("myapp/overlays/production/patch.yaml")
+	require.Error(t, err)
+
+	_, err = o.PushFiles("bot/redeploy-again", "myapp/overlays/production", map[string][]byte{
+		"myapp/overlays/production/kustomization.yaml": []byte(files["myapp/overlays/production/kustomization.yaml"]),
+		"myapp/overlays/production/patch.yaml":         []byte(files["myapp/overlays/production/patch.yaml"]),
+	}, "redeploy")
+	require.EqualError(t, err, "myapp/overlays/production is already up to date")
+}
+
+func TestGit_PushOverStaleBranch(t *testing.T) {
+	remote := t.TempDir()
+	r, err := git.PlainInit(remote, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	const name = "myapp/overlays/production/kustomization.yaml"
+	require.NoError(t, os.MkdirAll(filepath.Join(remote, filepath.Dir(name)), 0755))
+	commit := func(content string) plumbing.Hash {
+		require.NoError(t, os.WriteFile(filepath.Join(remote, name), []byte(content), 0644))
+		_, err := w.Add(name)
+		require.NoError(t, err)
+		hash, err := w.Commit("update", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+		require.NoError(t, err)
+		return hash
+	}
+	base := commit("images:\n- name: myapp\n  newTag: aaaaaaa\n")
+	// The previous run crashed after pushing the branch, which has diverged from master since
+	stale := commit("images:\n- name: myapp\n  newTag: bbbbbbb\n")
+	require.NoError(t, r.Storer.SetReference(plumbing.NewHashReference("refs/heads/bot/deploy", stale)))
+	require.NoError(t, w.Reset(&git.ResetOptions{Commit: base, Mode: git.HardReset}))
+	commit("images:\n- name: myapp\n  newTag: aaaaaaa\n- name: worker\n  newTag: ccccccc\n")
+
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	require.NoError(t, o.Clone())
+
+	content := []byte("images:\n- name: myapp\n  newTag: bbbbbbb\n- name: worker\n  newTag: ccccccc\n")
+	// The branch of the deploy in progress isn't overwritten
+	o.UsePullRequests(func() ([]OpenPullRequest, error) {
+		return []OpenPullRequest{{Number: 12, HeadRefName: "bot/deploy"}}, nil
+	})
+	_, err = o.PushFiles("bot/deploy", "myapp/overlays/production", map[string][]byte{name: content}, "deploy")
+	require.EqualError(t, err, "bot/deploy already has the open pull request #12. Close it to deploy again")
+
+	o.UsePullRequests(func() ([]OpenPullRequest, error) { return nil, nil })
+	_, err = o.PushFiles("bot/deploy", "myapp/overlays/production", map[string][]byte{name: content}, "deploy")
+	require.NoError(t, err)
+	ref, err := r.Reference("refs/heads/bot/deploy", true)
+	require.NoError(t, err)
+	require.NotEqual(t, stale, ref.Hash())
+	c, err := r.CommitObject(ref.Hash())
+	require.NoError(t, err)
+	f, err := c.File(name)
+	require.NoError(t, err)
+	got, err := f.Contents()
+	require.NoError(t, err)
+	require.Equal(t, string(content), got)
+}
+
+func TestGit_Mirror(t *testing.T) {
+	remote := t.TempDir()
+	r, err := git.PlainInit(remote, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	const name = "myapp/overlays/production/kustomization.yaml"
+	require.NoError(t, os.MkdirAll(filepath.Join(remote, filepath.Dir(name)), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(remote, name), []byte("images:\n- name: myapp\n  newTag: aaaaaaa\n"), 0644))
+	_, err = w.Add(name)
+	require.NoError(t, err)
+	master, err := w.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+	mirror := t.TempDir()
+	m, err := git.PlainInit(mirror, true)
+	require.NoError(t, err)
+
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	o.remoteName = "upstream"
+	o.UseMirror(mirror, nil)
+	require.NoError(t, o.Clone())
+	_, err = o.repository.Remote("upstream")
+	require.NoError(t, err)
+
+	_, err = o.PushFiles("bot/deploy", "myapp/overlays/production", map[string][]byte{name: []byte("images:\n- name: myapp\n  newTag: bbbbbbb\n")}, "deploy")
+	require.NoError(t, err)
+	pushed, err := r.Reference("refs/heads/bot/deploy", true)
+	require.NoError(t, err)
+	mirrored, err := m.Reference("refs/heads/bot/deploy", true)
+	require.NoError(t, err)
+	require.Equal(t, pushed.Hash(), mirrored.Hash())
+	mirrored, err = m.Reference("refs/heads/master", true)
+	require.NoError(t, err)
+	require.Equal(t, master, mirrored.Hash())
+
+	// The deploy doesn't fail when the mirror can't follow it
+	var alerted error
+	o.UseMirror(filepath.Join(t.TempDir(), "missing"), func(err error) { alerted = err })
+	o.repository.DeleteRemote(mirrorRemoteName)
+	_, err = o.PushFiles("bot/deploy2", "myapp/overlays/production", map[string][]byte{name: []byte("images:\n- name: myapp\n  newTag: ccccccc\n")}, "deploy")
+	require.NoError(t, err)
+	require.Error(t, alerted)
+}
+
+func TestGit_PreviousImages(t *testing.T) {
+	remote := t.TempDir()
+	r, err := git.PlainInit(remote, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	const name = "myapp/overlays/production/kustomization.yaml"
+	require.NoError(t, os.MkdirAll(filepath.Join(remote, filepath.Dir(name)), 0755))
+	revisions := []string{
+		"images:\n- name: api\n  newTag: a1\n- name: worker\n  newTag: w1\n",
+		"images:\n- name: api\n  newTag: a2\n- name: worker\n  newTag: w1\n",
+		"images:\n- name: api\n  newTag: a2\n- name: worker\n  newTag: w2\n",
+		"images:\n- name: api\n  newTag: a3\n- name: worker\n  newTag: w2\n",
+	}
+	for i, content := range revisions {
+		require.NoError(t, os.WriteFile(filepath.Join(remote, name), []byte(content), 0644))
+		_, err := w.Add(name)
+		require.NoError(t, err)
+		_, err = w.Commit(fmt.Sprintf("deploy %d", i), &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+		require.NoError(t, err)
+	}
+
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	require.NoError(t, o.Clone())
+
+	current, previous, err := o.PreviousImages(name)
+	require.NoError(t, err)
+	require.Equal(t, []types.Image{{Name: "api", NewTag: "a3"}, {Name: "worker", NewTag: "w2"}}, current)
+	require.Equal(t, map[string]types.Image{
+		"api":    {Name: "api", NewTag: "a2"},
+		"worker": {Name: "worker", NewTag: "w1"},
+	}, previous)
+}
+
+func TestGit_LFS(t *testing.T) {
+	remote := t.TempDir()
+	r, err := git.PlainInit(remote, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	pointer := "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n"
+	files := map[string]string{
+		".gitattributes": "*.png filter=lfs diff=lfs merge=lfs -text\n",
+		"myapp/overlays/staging/kustomization.yaml": "images:\n- name: myapp\n  newTag: aaaaaaa\n",
+		"myapp/overlays/staging/logo.png":           pointer,
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Join(remote, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(remote, name), []byte(content), 0644))
+		_, err := w.Add(name)
+		require.NoError(t, err)
+	}
+	_, err = w.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	o.gitRoot = t.TempDir()
+	require.NoError(t, o.Clone())
+
+	// git-lfs smudged the pointer in the clone, which go-git takes for an unstaged change
+	logo := filepath.Join(o.getLocalRepoRoot(), "myapp/overlays/staging/logo.png")
+	require.NoError(t, os.WriteFile(logo, []byte("\x89PNG the content"), 0644))
+
+	wt, err := o.createAndCheckoutNewBranch("bot/test")
+	require.NoError(t, err)
+	b, err := os.ReadFile(logo)
+	require.NoError(t, err)
+	require.Equal(t, pointer, string(b))
+
+	require.NoError(t, os.WriteFile(logo, []byte("\x89PNG the content"), 0644))
+	require.NoError(t, o.commit(wt, "myapp/overlays/staging/kustomization.yaml", KustomizationOverWrite{tag: "bbbbbbb", targetTag: "myapp"}))
+	require.NoError(t, o.verify(wt))
+
+	hash, err := wt.Commit("update", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+	c, err := o.repository.CommitObject(hash)
+	require.NoError(t, err)
+	f, err := c.File("myapp/overlays/staging/logo.png")
+	require.NoError(t, err)
+	content, err := f.Contents()
+	require.NoError(t, err)
+	require.Equal(t, pointer, content)
+
+	// The other files are still rejected
+	require.NoError(t, os.WriteFile(filepath.Join(o.getLocalRepoRoot(), ".gitattributes"), []byte("\n"), 0644))
+	require.Error(t, o.verify(wt))
+}
+
+func TestGit_Submodule(t *testing.T) {
+	commit := func(w *git.Worktree) plumbing.Hash {
+		hash, err := w.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+		require.NoError(t, err)
+		return hash
+	}
+
+	subRemote := t.TempDir()
+	sr, err := git.PlainInit(subRemote, false)
+	require.NoError(t, err)
+	sw, err := sr.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(subRemote, "staging"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(subRemote, "staging/kustomization.yaml"), []byte("images:\n- name: myapp\n  newTag: aaaaaaa\n"), 0644))
+	_, err = sw.Add("staging/kustomization.yaml")
+	require.NoError(t, err)
+	subHash := commit(sw)
+	// The submodule is pushed to like the bare repositories on GitHub
+	subRemote = t.TempDir()
+	sr, err = git.PlainClone(subRemote, true, &git.CloneOptions{URL: sw.Filesystem.Root()})
+	require.NoError(t, err)
+
+	// The superproject includes the overlays of myapp as the submodule
+	remote := t.TempDir()
+	r, err := git.PlainInit(remote, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(remote, ".gitmodules"), []byte(fmt.Sprintf("[submodule \"myapp/overlays\"]\n\tpath = myapp/overlays\n\turl = %s\n\tbranch = master\n", subRemote)), 0644))
+	_, err = w.Add(".gitmodules")
+	require.NoError(t, err)
+	idx, err := r.Storer.Index()
+	require.NoError(t, err)
+	entry := idx.Add("myapp/overlays")
+	entry.Mode = filemode.Submodule
+	entry.Hash = subHash
+	require.NoError(t, r.Storer.SetIndex(idx))
+	commit(w)
+
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	require.NoError(t, o.Clone())
+
+	phase := DeployPhase{Path: "myapp/overlays/staging/kustomization.yaml"}
+	images := []types.Image{{Name: "myapp", NewTag: "bbbbbbb"}}
+	hash, diff, err := o.pushDockerImageTags("bot/test", "", "refs/heads/bot/test", phase, images, "update")
+	require.NoError(t, err)
+	require.Contains(t, diff, "+  newTag: bbbbbbb")
+
+	// The tag is committed to the branch of the submodule, and the superproject points to it
+	ref, err := sr.Reference("refs/heads/bot/test", true)
+	require.NoError(t, err)
+	c, err := sr.CommitObject(ref.Hash())
+	require.NoError(t, err)
+	f, err := c.File("staging/kustomization.yaml")
+	require.NoError(t, err)
+	content, err := f.Contents()
+	require.NoError(t, err)
+	require.Contains(t, content, "newTag: bbbbbbb")
+
+	ref, err = r.Reference("refs/heads/bot/test", true)
+	require.NoError(t, err)
+	require.Equal(t, hash, ref.Hash())
+	c, err = r.CommitObject(hash)
+	require.NoError(t, err)
+	tree, err := c.Tree()
+	require.NoError(t, err)
+	e, err := tree.FindEntry("myapp/overlays")
+	require.NoError(t, err)
+	require.Equal(t, filemode.Submodule, e.Mode)
+	subRef, err := sr.Reference("refs/heads/bot/test", true)
+	require.NoError(t, err)
+	require.Equal(t, subRef.Hash(), e.Hash)
+
+	// The next deploy starts from the submodule updated to the pointer of the default branch
+	wt, err := o.checkoutMainBranch()
+	require.NoError(t, err)
+	b, err := util.ReadFile(wt.Filesystem, "myapp/overlays/staging/kustomization.yaml")
+	require.NoError(t, err)
+	require.Contains(t, string(b), "newTag: aaaaaaa")
+
+	// The default branch of the submodule follows the superproject once the deploy is merged
+	require.NoError(t, r.Storer.SetReference(plumbing.NewHashReference("refs/heads/master", hash)))
+	require.NoError(t, r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, hash)))
+	require.NoError(t, o.AdvanceSubmodule(phase))
+	subMaster, err := sr.Reference("refs/heads/master", true)
+	require.NoError(t, err)
+	require.Equal(t, subRef.Hash(), subMaster.Hash())
+
+	// The direct commit goes straight to the default branch of the submodule
+	_, _, err = o.pushDockerImageTags("bot/direct", "", "refs/heads/master", phase, []types.Image{{Name: "myapp", NewTag: "ccccccc"}}, "update")
+	require.NoError(t, err)
+	subMaster, err = sr.Reference("refs/heads/master", true)
+	require.NoError(t, err)
+	require.NotEqual(t, subRef.Hash(), subMaster.Hash())
+	_, err = sr.Reference("refs/heads/bot/direct", true)
+	require.Error(t, err)
+}
+
+func TestGit_PushDockerImageTagsDirectly(t *testing.T) {
+	const name = "myapp/overlays/sandbox/kustomization.yaml"
+	seed := t.TempDir()
+	sr, err := git.PlainInit(seed, false)
+	require.NoError(t, err)
+	sw, err := sr.Worktree()
+	require.NoError(t, err)
+	commit := func(content string) plumbing.Hash {
+		require.NoError(t, os.MkdirAll(filepath.Join(seed, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(seed, name), []byte(content), 0644))
+		_, err := sw.Add(name)
+		require.NoError(t, err)
+		hash, err := sw.Commit("update", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+		require.NoError(t, err)
+		return hash
+	}
+	base := commit("images:\n- name: myapp\n  newTag: aaaaaaa\n")
+	// The default branch is pushed to like the bare repositories on GitHub
+	remote := t.TempDir()
+	r, err := git.PlainClone(remote, true, &git.CloneOptions{URL: seed})
+	require.NoError(t, err)
+
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	require.NoError(t, o.Clone())
+
+	phase := DeployPhase{Path: name}
+	sha, diff, err := o.PushDockerImageTagsDirectly("bot/docker-image-tag-myapp-sandbox-bbbbbbb", phase, []types.Image{{Name: "myapp", NewTag: "bbbbbbb"}}, "deploy")
+	require.NoError(t, err)
+	require.Contains(t, diff, "+  newTag: bbbbbbb")
+	master, err := r.Reference("refs/heads/master", true)
+	require.NoError(t, err)
+	require.Equal(t, sha, master.Hash().String())
+	c, err := r.CommitObject(master.Hash())
+	require.NoError(t, err)
+	require.Equal(t, []plumbing.Hash{base}, c.ParentHashes)
+	// Nothing but the default branch is pushed
+	_, err = r.Reference("refs/heads/bot/docker-image-tag-myapp-sandbox-bbbbbbb", true)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+
+	// The default branch moves after gocat pulled it, which the HEAD of the remote left behind stands for here
+	_, err = sr.CreateRemote(&gitconfig.RemoteConfig{Name: "bare", URLs: []string{remote}})
+	require.NoError(t, err)
+	require.NoError(t, sr.Fetch(&git.FetchOptions{RemoteName: "bare"}))
+	require.NoError(t, sw.Reset(&git.ResetOptions{Commit: master.Hash(), Mode: git.HardReset}))
+	moved := commit("images:\n- name: myapp\n  newTag: ccccccc\n")
+	require.NoError(t, sr.Push(&git.PushOptions{RemoteName: "bare", RefSpecs: []gitconfig.RefSpec{"refs/heads/master:refs/heads/master"}}))
+	require.NoError(t, r.Storer.SetReference(plumbing.NewHashReference("refs/heads/behind", master.Hash())))
+	require.NoError(t, r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/behind")))
+
+	_, _, err = o.PushDockerImageTagsDirectly("bot/docker-image-tag-myapp-sandbox-ddddddd", phase, []types.Image{{Name: "myapp", NewTag: "ddddddd"}}, "deploy")
+	require.EqualError(t, err, "unable to push to master directly. Check if the branch is protected: non-fast-forward update: refs/heads/master")
+	master, err = r.Reference("refs/heads/master", true)
+	require.NoError(t, err)
+	require.Equal(t, moved, master.Hash())
+}
+
+func TestGit_ConcurrentPushes(t *testing.T) {
+	remote := t.TempDir()
+	r, err := git.PlainInit(remote, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	const name = "myapp/overlays/production/kustomization.yaml"
+	require.NoError(t, os.MkdirAll(filepath.Join(remote, filepath.Dir(name)), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(remote, name), []byte("images:\n- name: myapp\n  newTag: aaaaaaa\n"), 0644))
+	_, err = w.Add(name)
+	require.NoError(t, err)
+	_, err = w.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+
+	o := newGitOperator("gocat", "", remote, "refs/heads/master", "", false, "")
+	require.NoError(t, o.Clone())
+
+	// The deploys of the projects share the worktree of the clone, each of which checks out its own branch
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := []byte(fmt.Sprintf("images:\n- name: myapp\n  newTag: %07d\n", i))
+			_, errs[i] = o.PushFiles(fmt.Sprintf("bot/deploy-%d", i), "myapp/overlays/production", map[string][]byte{name: content}, "deploy")
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		require.NoError(t, err)
+		ref, err := r.Reference(plumbing.NewBranchReferenceName(fmt.Sprintf("bot/deploy-%d", i)), true)
+		require.NoError(t, err)
+		c, err := r.CommitObject(ref.Hash())
+		require.NoError(t, err)
+		f, err := c.File(name)
+		require.NoError(t, err)
+		got, err := f.Contents()
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("images:\n- name: myapp\n  newTag: %07d\n", i), got)
+	}
+}
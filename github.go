@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -29,6 +33,17 @@ type GitHub struct {
 	org           string
 	repo          string
 	defaultBranch string
+	// files caches the files read by GetFile. See gitHubFileCache.
+	files *gitHubFileCache
+	// rateLimiter is shared by the instance and its Background copy.
+	rateLimiter *GitHubRateLimiter
+	// mergeMethod is how the deploy pull requests are merged, which is merge if empty.
+	mergeMethod githubv4.PullRequestMergeMethod
+	// forkOrg and forkRepo are the fork the deploy branches are in, if any. See UseFork.
+	forkOrg  string
+	forkRepo string
+	// metadataRetryInterval is the wait before SetNewPullRequestMetadata retries.
+	metadataRetryInterval time.Duration
 }
 
 type GitHubInput struct {
@@ -40,24 +55,81 @@ func CreateGitHubInstance(token, org, repo, defaultBranch string) GitHub {
 	src := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
-	httpClient := oauth2.NewClient(context.Background(), src)
+	base := oauth2.NewClient(context.Background(), src)
+	limiter := NewGitHubRateLimiter()
+	httpClient := &http.Client{Transport: &gitHubRateLimitTransport{base: base.Transport, limiter: limiter}}
 
 	client := githubv4.NewClient(httpClient)
-	return GitHub{*client, httpClient, org, repo, defaultBranch}
+	return GitHub{client: *client, httpClient: httpClient, org: org, repo: repo, defaultBranch: defaultBranch, files: newGitHubFileCache(), rateLimiter: limiter, metadataRetryInterval: 2 * time.Second}
 }
 
+// Background returns the copy of the instance for background jobs like AutoDeploy.
+// Its requests give way to the interactive ones when the rate limit runs low. See gitHubBackgroundReserve.
+func (g GitHub) Background() GitHub {
+	t, ok := g.httpClient.Transport.(*gitHubRateLimitTransport)
+	if !ok {
+		return g
+	}
+	g.httpClient = &http.Client{Transport: &gitHubRateLimitTransport{base: t.base, limiter: t.limiter, background: true}}
+	g.client = *githubv4.NewClient(g.httpClient)
+	g.files = g.files.link()
+	return g
+}
+
+// InvalidateFiles drops the files cached by the instance and the linked ones, like its Background copy,
+// so that the next reads see the changes gocat itself has just made to the default branch.
+func (g GitHub) InvalidateFiles() {
+	g.files.invalidate()
+}
+
+// UsePolicy makes the instance call the API with the deadline and the retries of the policy.
+// The deadline starts once the rate limiter lets the call go, so the background calls waiting for the reset don't time out,
+// and the retries are counted against the rate limit as GitHub does.
+func (g *GitHub) UsePolicy(p APIClientPolicy) {
+	t, ok := g.httpClient.Transport.(*gitHubRateLimitTransport)
+	if !ok {
+		return
+	}
+	g.httpClient = &http.Client{Transport: &gitHubRateLimitTransport{base: p.transport(t.base), limiter: t.limiter, background: t.background}}
+	g.client = *githubv4.NewClient(g.httpClient)
+}
+
+// RateLimits returns the last rate limits GitHub reported.
+func (g GitHub) RateLimits() []GitHubRateLimit {
+	if g.rateLimiter == nil {
+		return nil
+	}
+	return g.rateLimiter.Limits()
+}
+
+// GetFile reads the file at the path on the default branch of the manifest repository.
+// The file is revalidated with its ETag if it's cached, so that unchanged files don't consume the rate limit.
 func (g GitHub) GetFile(path string) (b []byte, err error) {
+	if b, ok := g.files.fresh(path, time.Now()); ok {
+		return b, nil
+	}
+	cached, hasCache := g.files.get(path)
+
 	req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", g.org, g.repo, path), nil)
 	req.Header.Set("Accept", "application/vnd.github.v3.raw")
+	if hasCache && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
 	resp, err := g.httpClient.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && hasCache {
+		return cached.body, nil
+	}
 	b, err = io.ReadAll(resp.Body)
 	if err != nil {
 		return
 	}
+	if resp.StatusCode == http.StatusOK {
+		g.files.put(path, gitHubFileCacheEntry{etag: resp.Header.Get("ETag"), body: b})
+	}
 	return
 }
 
@@ -88,6 +160,48 @@ func (g GitHub) GetUsers() (users map[string]string, err error) {
 	return
 }
 
+// GetMemberEmails returns the logins of the members of the organization keyed by their lowercased emails,
+// which are the public ones and the ones verified in the domains of the organization.
+func (g GitHub) GetMemberEmails() (map[string]string, error) {
+	emails := map[string]string{}
+	type user struct {
+		Login                            string
+		Email                            string
+		OrganizationVerifiedDomainEmails []string `graphql:"organizationVerifiedDomainEmails(login: $org)"`
+	}
+	var query struct {
+		Organization struct {
+			MembersWithRole struct {
+				Nodes    []user
+				PageInfo struct {
+					EndCursor   githubv4.String
+					HasNextPage bool
+				}
+			} `graphql:"membersWithRole(first: 100, after: $cursor)"`
+		} `graphql:"organization(login: $org)"`
+	}
+	variables := map[string]interface{}{
+		"org":    githubv4.String(g.org),
+		"cursor": (*githubv4.String)(nil),
+	}
+	for {
+		if err := g.client.Query(context.Background(), &query, variables); err != nil {
+			return nil, err
+		}
+		for _, node := range query.Organization.MembersWithRole.Nodes {
+			for _, email := range append(node.OrganizationVerifiedDomainEmails, node.Email) {
+				if email != "" {
+					emails[strings.ToLower(email)] = node.Login
+				}
+			}
+		}
+		if !query.Organization.MembersWithRole.PageInfo.HasNextPage {
+			return emails, nil
+		}
+		variables["cursor"] = githubv4.NewString(query.Organization.MembersWithRole.PageInfo.EndCursor)
+	}
+}
+
 func (g GitHub) GetKustomization(path string) (obj types.Kustomization, err error) {
 	b, err := g.GetFile(path)
 	if err != nil {
@@ -101,6 +215,8 @@ func (g GitHub) GetKustomization(path string) (obj types.Kustomization, err erro
 	return
 }
 
+// ListBranch returns the names of all the branches of the repository, the most recently committed first,
+// following the pages of 100 branches.
 func (g GitHub) ListBranch(name string) ([]string, error) {
 	type refs struct {
 		Name string
@@ -108,24 +224,33 @@ func (g GitHub) ListBranch(name string) ([]string, error) {
 	var query struct {
 		Repository struct {
 			Refs struct {
-				Nodes []refs
-			} `graphql:"refs(first: 50, refPrefix: \"refs/heads/\")"`
+				Nodes    []refs
+				PageInfo struct {
+					EndCursor   githubv4.String
+					HasNextPage bool
+				}
+			} `graphql:"refs(first: 100, after: $cursor, refPrefix: \"refs/heads/\", orderBy: {field: TAG_COMMIT_DATE, direction: DESC})"`
 		} `graphql:"repository(owner: $org, name: $name)"`
 	}
 	variables := map[string]interface{}{
-		"name": githubv4.String(name),
-		"org":  githubv4.String(g.org),
+		"name":   githubv4.String(name),
+		"org":    githubv4.String(g.org),
+		"cursor": (*githubv4.String)(nil),
 	}
 
-	err := g.client.Query(context.Background(), &query, variables)
-	if err != nil {
-		return []string{}, err
-	}
 	var arr []string
-	for _, v := range query.Repository.Refs.Nodes {
-		arr = append(arr, v.Name)
+	for {
+		if err := g.client.Query(context.Background(), &query, variables); err != nil {
+			return []string{}, err
+		}
+		for _, v := range query.Repository.Refs.Nodes {
+			arr = append(arr, v.Name)
+		}
+		if !query.Repository.Refs.PageInfo.HasNextPage {
+			return arr, nil
+		}
+		variables["cursor"] = githubv4.NewString(query.Repository.Refs.PageInfo.EndCursor)
 	}
-	return arr, nil
 }
 
 func (g GitHub) GitHash(branch string) (string, error) {
@@ -213,6 +338,8 @@ func (g GitHub) CreatePullRequest(branch string, title string, description strin
 	if g.defaultBranch != "" {
 		refName = g.defaultBranch
 	}
+	// The branch is in the fork in the fork mode
+	branch = g.headRef(branch)
 	input := githubv4.CreatePullRequestInput{
 		RepositoryID:        repoID,
 		BaseRefName:         githubv4.String(refName),
@@ -241,6 +368,114 @@ func (g GitHub) UpdatePullRequest(prID string, assigneeIDs string) error {
 	return g.client.Mutate(context.Background(), &mutate, input, nil)
 }
 
+func (g GitHub) UpdatePullRequestBody(prID string, body string) error {
+	var mutate struct {
+		UpdatePullRequest struct {
+			PullRequest struct {
+				ID string
+			}
+		} `graphql:"updatePullRequest(input:$input)"`
+	}
+	b := githubv4.String(body)
+	input := githubv4.UpdatePullRequestInput{
+		PullRequestID: prID,
+		Body:          &b,
+	}
+	return g.client.Mutate(context.Background(), &mutate, input, nil)
+}
+
+// UpdatePullRequestTitleAndBody replaces the title and the body of the pull request.
+func (g GitHub) UpdatePullRequestTitleAndBody(prID string, title string, body string) error {
+	var mutate struct {
+		UpdatePullRequest struct {
+			PullRequest struct {
+				ID string
+			}
+		} `graphql:"updatePullRequest(input:$input)"`
+	}
+	t := githubv4.String(title)
+	b := githubv4.String(body)
+	input := githubv4.UpdatePullRequestInput{
+		PullRequestID: prID,
+		Title:         &t,
+		Body:          &b,
+	}
+	return g.client.Mutate(context.Background(), &mutate, input, nil)
+}
+
+// OpenPullRequest is an open pull request of the manifest repository.
+type OpenPullRequest struct {
+	ID          string
+	Number      int
+	HeadRefName string
+	Body        string
+}
+
+// ListOpenPullRequests returns the latest 100 open pull requests of the manifest repository, newest first.
+func (g GitHub) ListOpenPullRequests() ([]OpenPullRequest, error) {
+	var query struct {
+		Repository struct {
+			PullRequests struct {
+				Nodes []OpenPullRequest
+			} `graphql:"pullRequests(first: 100, states: OPEN, orderBy: {field: CREATED_AT, direction: DESC})"`
+		} `graphql:"repository(owner: $org, name: $repo)"`
+	}
+	variables := map[string]interface{}{
+		"repo": githubv4.String(g.repo),
+		"org":  githubv4.String(g.org),
+	}
+	if err := g.client.Query(context.Background(), &query, variables); err != nil {
+		return nil, err
+	}
+	return query.Repository.PullRequests.Nodes, nil
+}
+
+// AddComment posts a comment to the issue or the pull request identified by subjectID.
+func (g GitHub) AddComment(subjectID string, body string) error {
+	var mutate struct {
+		AddComment struct {
+			ClientMutationID string
+		} `graphql:"addComment(input:$input)"`
+	}
+	input := githubv4.AddCommentInput{
+		SubjectID: subjectID,
+		Body:      githubv4.String(body),
+	}
+	return g.client.Mutate(context.Background(), &mutate, input, nil)
+}
+
+// GetPullRequestBody returns the body of the pull request identified by its node ID.
+// Unlike GetPullRequest, this works for pull requests in any repository.
+func (g GitHub) GetPullRequestBody(prID string) (string, error) {
+	var query struct {
+		Node struct {
+			PullRequest struct {
+				Body string
+			} `graphql:"... on PullRequest"`
+		} `graphql:"node(id: $id)"`
+	}
+	variables := map[string]interface{}{
+		"id": githubv4.ID(prID),
+	}
+	if err := g.client.Query(context.Background(), &query, variables); err != nil {
+		return "", err
+	}
+	return query.Node.PullRequest.Body, nil
+}
+
+// ViewerLogin returns the login of the GitHub user gocat acts as.
+func (g GitHub) ViewerLogin() (string, error) {
+	var query struct {
+		Viewer struct {
+			Login string
+		}
+	}
+	if err := g.client.Query(context.Background(), &query, nil); err != nil {
+		return "", err
+	}
+	return query.Viewer.Login, nil
+}
+
 func (g GitHub) RequestReviews(prID string, assigneeIDs string) error {
 	var mutate struct {
 		RequestReviews struct {
@@ -268,7 +503,14 @@ func (g GitHub) MergePullRequest(prID string) error {
 	input := githubv4.MergePullRequestInput{
 		PullRequestID: prID,
 	}
-	return g.client.Mutate(context.Background(), &mutate, input, nil)
+	if g.mergeMethod != "" {
+		input.MergeMethod = &g.mergeMethod
+	}
+	if err := g.client.Mutate(context.Background(), &mutate, input, nil); err != nil {
+		return branchProtectionError(err, "")
+	}
+	g.InvalidateFiles()
+	return nil
 }
 
 func (g GitHub) ClosePullRequest(prID string) error {
@@ -298,8 +540,9 @@ func (g GitHub) ClosePullRequest(prID string) error {
 	return nil
 }
 
+// DeleteBranch deletes the deploy branch, which is in the fork in the fork mode.
 func (g GitHub) DeleteBranch(refName string) error {
-	refID, err := g.BranchID(refName)
+	refID, err := g.headRepository().BranchID(refName)
 	if err != nil {
 		return err
 	}
@@ -314,6 +557,155 @@ func (g GitHub) DeleteBranch(refName string) error {
 	return g.client.Mutate(context.Background(), &mutate, input, nil)
 }
 
+// SetNewPullRequestMetadata sets the metadata of the pull request gocat has just opened, retrying once on failure,
+// and closes the pull request if it still fails, so that nobody is left with a pull request no approval message points to.
+func (g GitHub) SetNewPullRequestMetadata(repo string, prID string, option PullRequestOption) error {
+	err := g.SetPullRequestMetadata(repo, prID, option)
+	if err == nil {
+		return nil
+	}
+	log.Printf("[WARNING] Retrying to set the metadata of the pull request %s: %s", prID, err)
+	time.Sleep(g.metadataRetryInterval)
+	if err = g.SetPullRequestMetadata(repo, prID, option); err == nil {
+		return nil
+	}
+	if cerr := g.ClosePullRequest(prID); cerr != nil {
+		return fmt.Errorf("%w, and the pull request is left open: %s", err, cerr)
+	}
+	return fmt.Errorf("%w, so the pull request is closed", err)
+}
+
+// SetPullRequestMetadata attaches the labels, the milestone, and the project columns
+// specified in the option to the pull request.
+//
+// repo is the name of the repository the pull request belongs to.
+// It defaults to the manifest repository if empty.
+func (g GitHub) SetPullRequestMetadata(repo string, prID string, option PullRequestOption) error {
+	if repo == "" {
+		repo = g.repo
+	}
+
+	if len(option.Labels) > 0 {
+		labelIDs, err := g.labelIDs(repo, option.Labels)
+		if err != nil {
+			return err
+		}
+		var mutate struct {
+			AddLabelsToLabelable struct {
+				ClientMutationID string
+			} `graphql:"addLabelsToLabelable(input:$input)"`
+		}
+		input := githubv4.AddLabelsToLabelableInput{
+			LabelableID: prID,
+			LabelIDs:    labelIDs,
+		}
+		if err := g.client.Mutate(context.Background(), &mutate, input, nil); err != nil {
+			return fmt.Errorf("unable to add labels %v to pull request %s: %w", option.Labels, prID, err)
+		}
+	}
+
+	if option.Milestone != "" {
+		milestoneID, err := g.milestoneID(repo, option.Milestone)
+		if err != nil {
+			return err
+		}
+		var mutate struct {
+			UpdatePullRequest struct {
+				PullRequest struct {
+					ID string
+				}
+			} `graphql:"updatePullRequest(input:$input)"`
+		}
+		input := githubv4.UpdatePullRequestInput{
+			PullRequestID: prID,
+			MilestoneID:   &milestoneID,
+		}
+		if err := g.client.Mutate(context.Background(), &mutate, input, nil); err != nil {
+			return fmt.Errorf("unable to set milestone %q to pull request %s: %w", option.Milestone, prID, err)
+		}
+	}
+
+	for _, column := range option.ProjectColumns {
+		var mutate struct {
+			AddProjectCard struct {
+				ClientMutationID string
+			} `graphql:"addProjectCard(input:$input)"`
+		}
+		contentID := githubv4.ID(prID)
+		input := githubv4.AddProjectCardInput{
+			ProjectColumnID: column,
+			ContentID:       &contentID,
+		}
+		if err := g.client.Mutate(context.Background(), &mutate, input, nil); err != nil {
+			return fmt.Errorf("unable to add pull request %s to project column %s: %w", prID, column, err)
+		}
+	}
+
+	return nil
+}
+
+func (g GitHub) labelIDs(repo string, names []string) ([]githubv4.ID, error) {
+	var query struct {
+		Repository struct {
+			Labels struct {
+				Nodes []struct {
+					ID   string
+					Name string
+				}
+			} `graphql:"labels(first: 100)"`
+		} `graphql:"repository(owner: $org, name: $repo)"`
+	}
+	variables := map[string]interface{}{
+		"repo": githubv4.String(repo),
+		"org":  githubv4.String(g.org),
+	}
+	if err := g.client.Query(context.Background(), &query, variables); err != nil {
+		return nil, err
+	}
+
+	var ids []githubv4.ID
+	for _, name := range names {
+		var found bool
+		for _, l := range query.Repository.Labels.Nodes {
+			if l.Name == name {
+				ids = append(ids, l.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("label %q not found in %s/%s", name, g.org, repo)
+		}
+	}
+	return ids, nil
+}
+
+func (g GitHub) milestoneID(repo string, title string) (githubv4.ID, error) {
+	var query struct {
+		Repository struct {
+			Milestones struct {
+				Nodes []struct {
+					ID    string
+					Title string
+				}
+			} `graphql:"milestones(first: 100, states: OPEN)"`
+		} `graphql:"repository(owner: $org, name: $repo)"`
+	}
+	variables := map[string]interface{}{
+		"repo": githubv4.String(repo),
+		"org":  githubv4.String(g.org),
+	}
+	if err := g.client.Query(context.Background(), &query, variables); err != nil {
+		return nil, err
+	}
+	for _, m := range query.Repository.Milestones.Nodes {
+		if m.Title == title {
+			return m.ID, nil
+		}
+	}
+	return nil, fmt.Errorf("open milestone %q not found in %s/%s", title, g.org, repo)
+}
+
 type Commit struct {
 	ID            string
 	Oid           string
@@ -422,3 +814,175 @@ func (g GitHub) GetPullRequest(input GitHubGetPullRequestInput) (PullRequest, er
 
 	return query.Repository.PullRequest, nil
 }
+
+// ResolveCommit returns the full SHA of the commit rev refers to in the repository.
+// rev can be anything git rev-parse accepts, like an abbreviated SHA or a branch name.
+func (g GitHub) ResolveCommit(repo string, rev string) (string, error) {
+	var query struct {
+		Repository struct {
+			Object struct {
+				Oid string
+			} `graphql:"object(expression: $rev)"`
+		} `graphql:"repository(owner: $org, name: $repo)"`
+	}
+	variables := map[string]interface{}{
+		"repo": githubv4.String(repo),
+		"org":  githubv4.String(g.org),
+		"rev":  githubv4.String(rev),
+	}
+	if err := g.client.Query(context.Background(), &query, variables); err != nil {
+		return "", err
+	}
+	if query.Repository.Object.Oid == "" {
+		return "", fmt.Errorf("commit %q not found in %s/%s", rev, g.org, repo)
+	}
+	return query.Repository.Object.Oid, nil
+}
+
+// FileExists returns true if the file exists at the path on the branch of the repository.
+func (g GitHub) FileExists(repo string, branch string, path string) (bool, error) {
+	var query struct {
+		Repository struct {
+			Object struct {
+				Oid string
+			} `graphql:"object(expression: $expression)"`
+		} `graphql:"repository(owner: $org, name: $repo)"`
+	}
+	variables := map[string]interface{}{
+		"repo":       githubv4.String(repo),
+		"org":        githubv4.String(g.org),
+		"expression": githubv4.String(fmt.Sprintf("%s:%s", branch, path)),
+	}
+	if err := g.client.Query(context.Background(), &query, variables); err != nil {
+		return false, err
+	}
+	return query.Repository.Object.Oid != "", nil
+}
+
+// CreateAnnotatedTag creates an annotated tag pointing at the commit in the repository.
+//
+// GitHub GraphQL API can create lightweight tags only,
+// so we use the REST API to create the tag object and then the ref to it.
+func (g GitHub) CreateAnnotatedTag(repo string, name string, sha string, message string) error {
+	var tag struct {
+		SHA string `json:"sha"`
+	}
+	err := g.postREST(fmt.Sprintf("/repos/%s/%s/git/tags", g.org, repo), map[string]string{
+		"tag":     name,
+		"message": message,
+		"object":  sha,
+		"type":    "commit",
+	}, &tag)
+	if err != nil {
+		return fmt.Errorf("unable to create tag object %s: %w", name, err)
+	}
+	err = g.postREST(fmt.Sprintf("/repos/%s/%s/git/refs", g.org, repo), map[string]string{
+		"ref": "refs/tags/" + name,
+		"sha": tag.SHA,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("unable to create tag ref %s: %w", name, err)
+	}
+	return nil
+}
+
+// CreateRelease creates a GitHub Release, along with the tag, pointing at the commit in the repository.
+func (g GitHub) CreateRelease(repo string, name string, sha string, body string) error {
+	return g.postREST(fmt.Sprintf("/repos/%s/%s/releases", g.org, repo), map[string]string{
+		"tag_name":         name,
+		"target_commitish": sha,
+		"name":             name,
+		"body":             body,
+	}, nil)
+}
+
+// CreateIssue opens the issue in the repository, and returns its number.
+func (g GitHub) CreateIssue(repo string, title string, body string, assignees []string, labels []string) (int, error) {
+	var issue struct {
+		Number int `json:"number"`
+	}
+	err := g.postREST(fmt.Sprintf("/repos/%s/%s/issues", g.org, repo), map[string]interface{}{
+		"title":     title,
+		"body":      body,
+		"assignees": assignees,
+		"labels":    labels,
+	}, &issue)
+	return issue.Number, err
+}
+
+// FindOpenIssues returns the numbers of the open issues of the repository titled title with all the labels, from the newest.
+// It searches the issues, which GitHub indexes within a minute or so after they're opened.
+func (g GitHub) FindOpenIssues(repo string, title string, labels []string) ([]int, error) {
+	q := fmt.Sprintf(`repo:%s/%s is:issue is:open in:title "%s"`, g.org, repo, title)
+	for _, label := range labels {
+		q += fmt.Sprintf(` label:"%s"`, label)
+	}
+	var result struct {
+		Items []struct {
+			Number int    `json:"number"`
+			Title  string `json:"title"`
+		} `json:"items"`
+	}
+	if err := g.sendREST("GET", "/search/issues?sort=created&order=desc&q="+url.QueryEscape(q), nil, &result); err != nil {
+		return nil, err
+	}
+	var numbers []int
+	for _, item := range result.Items {
+		// in:title matches the issues whose titles contain the title
+		if item.Title == title {
+			numbers = append(numbers, item.Number)
+		}
+	}
+	return numbers, nil
+}
+
+// CommentOnIssue adds the comment to the issue of the repository.
+func (g GitHub) CommentOnIssue(repo string, number int, body string) error {
+	return g.postREST(fmt.Sprintf("/repos/%s/%s/issues/%d/comments", g.org, repo, number), map[string]string{"body": body}, nil)
+}
+
+// CloseIssue closes the issue of the repository as completed.
+func (g GitHub) CloseIssue(repo string, number int) error {
+	return g.sendREST("PATCH", fmt.Sprintf("/repos/%s/%s/issues/%d", g.org, repo, number), map[string]string{
+		"state":        "closed",
+		"state_reason": "completed",
+	}, nil)
+}
+
+func (g GitHub) postREST(path string, in interface{}, out interface{}) error {
+	return g.sendREST("POST", path, in, out)
+}
+
+// sendREST sends in as the JSON body, or no body if it's nil, and decodes the response into out unless it's nil.
+func (g GitHub) sendREST(method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, "https://api.github.com"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return withCode(ErrCodeGitHubAPI, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, respBody))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// gitHubFileCache caches the files GitHub.GetFile reads from the manifest repository.
+//
+// Each file is cached along with its ETag so that GetFile can revalidate it with a conditional request,
+// which GitHub doesn't count against the rate limit when the file is unchanged.
+// Files prefetched by GitHub.PrefetchFiles are served without any request until they expire.
+//
+// The background instance has its own cache linked to the one of the interactive instance (see GitHub.Background),
+// so that the files AutoDeploy prefetched are never served to the interactive requests without revalidation.
+// The linked caches are invalidated all at once after gocat's own pushes and merges to the default branch. See GitHub.InvalidateFiles.
+//
+// A nil *gitHubFileCache caches nothing.
+type gitHubFileCache struct {
+	mu      sync.Mutex
+	entries map[string]gitHubFileCacheEntry
+	// generation is shared by the linked caches, and incremented to invalidate them.
+	generation *int64
+}
+
+type gitHubFileCacheEntry struct {
+	etag string
+	body []byte
+	// freshUntil is the time until which the entry is served without revalidation.
+	// It's zero unless the entry is prefetched.
+	freshUntil time.Time
+	// generation is the generation of the cache the entry was put in, which is obsolete once the cache is invalidated.
+	generation int64
+}
+
+func newGitHubFileCache() *gitHubFileCache {
+	return &gitHubFileCache{entries: map[string]gitHubFileCacheEntry{}, generation: new(int64)}
+}
+
+// link returns the new empty cache invalidated along with c.
+func (c *gitHubFileCache) link() *gitHubFileCache {
+	if c == nil {
+		return nil
+	}
+	return &gitHubFileCache{entries: map[string]gitHubFileCacheEntry{}, generation: c.generation}
+}
+
+// invalidate drops the entries of c and the caches linked to it.
+func (c *gitHubFileCache) invalidate() {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(c.generation, 1)
+}
+
+func (c *gitHubFileCache) get(path string) (gitHubFileCacheEntry, bool) {
+	if c == nil {
+		return gitHubFileCacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[path]
+	if ok && e.generation != atomic.LoadInt64(c.generation) {
+		delete(c.entries, path)
+		return gitHubFileCacheEntry{}, false
+	}
+	return e, ok
+}
+
+func (c *gitHubFileCache) put(path string, e gitHubFileCacheEntry) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.generation = atomic.LoadInt64(c.generation)
+	c.entries[path] = e
+}
+
+// fresh returns the body of the file if it's prefetched and not expired yet.
+func (c *gitHubFileCache) fresh(path string, now time.Time) ([]byte, bool) {
+	e, ok := c.get(path)
+	if !ok || now.After(e.freshUntil) {
+		return nil, false
+	}
+	return e.body, true
+}
+
+// PrefetchFiles reads the files at the paths on the default branch of the manifest repository in a single GraphQL query,
+// and caches them for ttl, during which GetFile returns them without any request.
+//
+// AutoDeploy prefetches the overlays of all the phases it tracks once per tick,
+// instead of reading each of them with its own request.
+func (g GitHub) PrefetchFiles(paths []string, ttl time.Duration) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	var fields []string
+	for i, p := range paths {
+		expr, _ := json.Marshal(fmt.Sprintf("%s:%s", g.defaultBranch, p))
+		fields = append(fields, fmt.Sprintf("f%d: object(expression: %s) { ... on Blob { text } }", i, expr))
+	}
+	query := fmt.Sprintf("query { repository(owner: %q, name: %q) { %s } }", g.org, g.repo, strings.Join(fields, " "))
+	reqBody, err := json.Marshal(map[string]string{"query": query})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), "POST", "https://api.github.com/graphql", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to prefetch files: %s: %s", resp.Status, string(b))
+	}
+
+	var result struct {
+		Data struct {
+			Repository map[string]*struct {
+				Text *string `json:"text"`
+			} `json:"repository"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return fmt.Errorf("unable to parse the prefetched files: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("unable to prefetch files: %s", result.Errors[0].Message)
+	}
+
+	freshUntil := time.Now().Add(ttl)
+	for i, p := range paths {
+		obj := result.Data.Repository[fmt.Sprintf("f%d", i)]
+		if obj == nil || obj.Text == nil {
+			// Missing or binary files are left to GetFile
+			continue
+		}
+		e := gitHubFileCacheEntry{body: []byte(*obj.Text), freshUntil: freshUntil}
+		if old, ok := g.files.get(p); ok && string(old.body) == *obj.Text {
+			// The ETag is still valid for the revalidation after the entry expires
+			e.etag = old.etag
+		}
+		g.files.put(p, e)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestGitHub_GetFileCache(t *testing.T) {
+	var requests []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req.Header.Get("If-None-Match"))
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			return &http.Response{StatusCode: http.StatusNotModified, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Etag": []string{`"v1"`}}, Body: io.NopCloser(strings.NewReader("images: []"))}, nil
+	})}
+	g := GitHub{httpClient: client, org: "zaiminc", repo: "manifests", files: newGitHubFileCache()}
+
+	b, err := g.GetFile("overlays/staging/kustomization.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "images: []", string(b))
+
+	b, err = g.GetFile("overlays/staging/kustomization.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "images: []", string(b))
+	require.Equal(t, []string{"", `"v1"`}, requests)
+
+	g.files.put("overlays/production/kustomization.yaml", gitHubFileCacheEntry{body: []byte("prefetched"), freshUntil: time.Now().Add(time.Minute)})
+	b, err = g.GetFile("overlays/production/kustomization.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "prefetched", string(b))
+	require.Len(t, requests, 2)
+
+	// The files prefetched by the background instance aren't served to the interactive one,
+	// and both drop their files after gocat's own pushes and merges
+	bg := g
+	bg.files = g.files.link()
+	bg.files.put("overlays/qa/kustomization.yaml", gitHubFileCacheEntry{body: []byte("prefetched"), freshUntil: time.Now().Add(time.Minute)})
+	_, ok := g.files.get("overlays/qa/kustomization.yaml")
+	require.False(t, ok)
+	g.InvalidateFiles()
+	_, ok = bg.files.get("overlays/qa/kustomization.yaml")
+	require.False(t, ok)
+	b, err = g.GetFile("overlays/staging/kustomization.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "images: []", string(b))
+	require.Equal(t, []string{"", `"v1"`, ""}, requests)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitHubBackgroundReserve is the fraction of the rate limit of each resource reserved for the interactive requests.
+// Background requests, like the ones AutoDeploy sends on each tick, wait for the reset once the remaining falls below it,
+// so that deploys requested in Slack keep working when the polling burns through the limit.
+const gitHubBackgroundReserve = 0.2
+
+// GitHubRateLimiter tracks the rate limits GitHub reports in the X-RateLimit-* response headers,
+// which are separate for each resource like core for the REST API and graphql for the GraphQL API.
+//
+// It's shared by all the GitHub instances, interactive or background. See GitHub.Background.
+type GitHubRateLimiter struct {
+	mu     sync.Mutex
+	limits map[string]GitHubRateLimit
+	// now and sleep are replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// GitHubRateLimit is the last rate limit of a resource GitHub reported.
+type GitHubRateLimit struct {
+	Resource  string
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+func NewGitHubRateLimiter() *GitHubRateLimiter {
+	return &GitHubRateLimiter{limits: map[string]GitHubRateLimit{}, now: time.Now, sleep: time.Sleep}
+}
+
+// Limits returns the last rate limits of all the resources, sorted by the resource name.
+func (l *GitHubRateLimiter) Limits() []GitHubRateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var limits []GitHubRateLimit
+	for _, v := range l.limits {
+		limits = append(limits, v)
+	}
+	sort.Slice(limits, func(i, j int) bool {
+		return limits[i].Resource < limits[j].Resource
+	})
+	return limits
+}
+
+// wait blocks the background request until the reset if the remaining of the resource is below the reserve.
+// It returns an error for the interactive request if the limit is exhausted,
+// as there's no point in letting the user wait for up to an hour.
+func (l *GitHubRateLimiter) wait(resource string, background bool) error {
+	l.mu.Lock()
+	limit, ok := l.limits[resource]
+	now := l.now()
+	l.mu.Unlock()
+	if !ok || !now.Before(limit.Reset) {
+		return nil
+	}
+
+	threshold := 0
+	if background {
+		threshold = int(float64(limit.Limit) * gitHubBackgroundReserve)
+	}
+	if limit.Remaining > threshold {
+		return nil
+	}
+	if !background {
+		return withCode(ErrCodeGitHubRateLimit, fmt.Errorf("GitHub %s rate limit is exhausted until %s", resource, limit.Reset.Format("15:04:05")))
+	}
+	log.Printf("[WARNING] GitHub %s rate limit is %d/%d. Background requests wait until %s", resource, limit.Remaining, limit.Limit, limit.Reset.Format("15:04:05"))
+	l.sleep(limit.Reset.Sub(now))
+	return nil
+}
+
+// observe records the rate limit reported in the response headers.
+func (l *GitHubRateLimiter) observe(h http.Header) {
+	resource := h.Get("X-RateLimit-Resource")
+	limit, err1 := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	reset, err3 := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if resource == "" || err1 != nil || err2 != nil || err3 != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits[resource] = GitHubRateLimit{Resource: resource, Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}
+}
+
+// gitHubRateLimitTransport is the http.RoundTripper of the GitHub instances that consults the rate limiter before each request.
+type gitHubRateLimitTransport struct {
+	base       http.RoundTripper
+	limiter    *GitHubRateLimiter
+	background bool
+}
+
+func (t *gitHubRateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.wait(gitHubRateLimitResource(req), t.background); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.limiter.observe(resp.Header)
+	// No caller can do anything with the rejected token or the exhausted rate limit but to fail, so fail here with the code.
+	// The other 401 and 403, like the ones of the missing permissions, are left to the callers, which may expect them.
+	var code ErrorCode
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		if strings.Contains(peekResponseBody(resp), "Bad credentials") {
+			code = ErrCodeGitHubAuth
+		}
+	case http.StatusForbidden:
+		// The secondary rate limits come with Retry-After instead of the exhausted X-RateLimit-Remaining
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != "" || strings.Contains(peekResponseBody(resp), "rate limit") {
+			code = ErrCodeGitHubRateLimit
+		}
+	case http.StatusTooManyRequests:
+		code = ErrCodeGitHubRateLimit
+	}
+	if code == "" {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return nil, withCode(code, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status))
+}
+
+// peekResponseBody returns the head of the body of the response, which is left intact for the caller.
+func peekResponseBody(resp *http.Response) string {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), resp.Body), resp.Body}
+	return string(b)
+}
+
+// gitHubRateLimitResource returns the rate limit resource the request is counted against.
+func gitHubRateLimitResource(req *http.Request) string {
+	if strings.HasSuffix(req.URL.Path, "/graphql") {
+		return "graphql"
+	}
+	return "core"
+}
+
+// gitHubRateLimitMetricsHandler exposes the rate limits in the Prometheus text format.
+type gitHubRateLimitMetricsHandler struct {
+	limiter *GitHubRateLimiter
+}
+
+func (h gitHubRateLimitMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	limits := h.limiter.Limits()
+	metrics := []struct {
+		name, help string
+		value      func(GitHubRateLimit) int64
+	}{
+		{"gocat_github_rate_limit", "The maximum number of requests per hour.", func(l GitHubRateLimit) int64 { return int64(l.Limit) }},
+		{"gocat_github_rate_limit_remaining", "The number of requests remaining in the current window.", func(l GitHubRateLimit) int64 { return int64(l.Remaining) }},
+		{"gocat_github_rate_limit_reset_timestamp_seconds", "The time the current window resets.", func(l GitHubRateLimit) int64 { return l.Reset.Unix() }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, l := range limits {
+			fmt.Fprintf(w, "%s{resource=%q} %d\n", m.name, l.Resource, m.value(l))
+		}
+	}
+}
+
+// describeGitHubRateLimits returns the rate limits for the ratelimit command.
+func describeGitHubRateLimits(limits []GitHubRateLimit) string {
+	if len(limits) == 0 {
+		return "No GitHub rate limit is observed yet"
+	}
+	var lines []string
+	for _, l := range limits {
+		lines = append(lines, fmt.Sprintf("*%s* %d/%d remaining, resets at %s", l.Resource, l.Remaining, l.Limit, l.Reset.Format("15:04:05")))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubRateLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var slept time.Duration
+	l := NewGitHubRateLimiter()
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) { slept += d }
+
+	// Nothing is observed yet
+	require.NoError(t, l.wait("core", true))
+
+	l.observe(http.Header{
+		"X-Ratelimit-Resource":  []string{"core"},
+		"X-Ratelimit-Limit":     []string{"5000"},
+		"X-Ratelimit-Remaining": []string{"1001"},
+		"X-Ratelimit-Reset":     []string{"1700000600"},
+	})
+	require.NoError(t, l.wait("core", true))
+	require.Zero(t, slept)
+
+	l.observe(http.Header{
+		"X-Ratelimit-Resource":  []string{"core"},
+		"X-Ratelimit-Limit":     []string{"5000"},
+		"X-Ratelimit-Remaining": []string{"1000"},
+		"X-Ratelimit-Reset":     []string{"1700000600"},
+	})
+	// Background requests give way to the interactive ones
+	require.NoError(t, l.wait("core", true))
+	require.Equal(t, 10*time.Minute, slept)
+	require.NoError(t, l.wait("core", false))
+	require.NoError(t, l.wait("graphql", true))
+
+	l.observe(http.Header{
+		"X-Ratelimit-Resource":  []string{"core"},
+		"X-Ratelimit-Limit":     []string{"5000"},
+		"X-Ratelimit-Remaining": []string{"0"},
+		"X-Ratelimit-Reset":     []string{"1700000600"},
+	})
+	require.Error(t, l.wait("core", false))
+
+	require.Equal(t, []GitHubRateLimit{{Resource: "core", Limit: 5000, Remaining: 0, Reset: time.Unix(1700000600, 0)}}, l.Limits())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHub_SetNewPullRequestMetadata(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if strings.Contains(string(b), "closePullRequest") {
+			queries = append(queries, "close")
+			fmt.Fprint(w, `{"data": {"closePullRequest": {"pullRequest": {"id": "PR_1"}}}}`)
+			return
+		}
+		queries = append(queries, "labels")
+		fmt.Fprint(w, `{"errors": [{"message": "Something went wrong"}]}`)
+	}))
+	defer server.Close()
+
+	github := CreateDevGitHubInstance(server.URL, "zaiminc", "manifests", "refs/heads/master")
+	require.NoError(t, github.SetNewPullRequestMetadata("", "PR_1", PullRequestOption{}))
+	require.Empty(t, queries)
+
+	// The pull request is closed once the retry fails as well
+	err := github.SetNewPullRequestMetadata("", "PR_1", PullRequestOption{Labels: []string{"deploy"}})
+	require.ErrorContains(t, err, "so the pull request is closed")
+	require.Equal(t, []string{"labels", "labels", "close"}, queries)
+}
+
+func TestGitHub_ListBranch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if !strings.Contains(string(b), `"cursor":"page2"`) {
+			fmt.Fprint(w, `{"data": {"repository": {"refs": {"nodes": [{"name": "master"}, {"name": "develop"}], "pageInfo": {"endCursor": "page2", "hasNextPage": true}}}}}`)
+			return
+		}
+		fmt.Fprint(w, `{"data": {"repository": {"refs": {"nodes": [{"name": "release/1.0"}], "pageInfo": {"endCursor": "page3", "hasNextPage": false}}}}}`)
+	}))
+	defer server.Close()
+
+	github := CreateDevGitHubInstance(server.URL, "zaiminc", "manifests", "refs/heads/master")
+	branches, err := github.ListBranch("myapp")
+	require.NoError(t, err)
+	require.Equal(t, []string{"master", "develop", "release/1.0"}, branches)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// githubWebhookHandler is a http.Handler that can handle GitHub webhooks.
+// See https://docs.github.com/en/webhooks for more details about webhooks.
+//
+// It keeps the Slack thread the deploy was requested in up to date with what happens on GitHub,
+// so that the Slack thread doesn't keep showing the deploy as pending when it's reviewed, merged, or closed directly on GitHub.
+type githubWebhookHandler struct {
+	// secret is the webhook secret used to verify the X-Hub-Signature-256 header.
+	secret string
+	client *slack.Client
+	// botLogin is the login of the GitHub user gocat acts as.
+	// Events triggered by gocat itself, like merges via the Deploy button, are ignored
+	// as gocat already updates the Slack message and runs the post-deploy hooks in that case.
+	botLogin string
+	// postDeployHooks are run when a deploy pull request is merged on GitHub.
+	postDeployHooks PostDeployHooks
+	// projectList tells if the phase of the merged pull request runs the synthetic checks before it's deployed.
+	projectList *ProjectList
+	// tracer emits the merges and the closes on GitHub as the events of the deploys.
+	tracer *DeployTracer
+}
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+type githubPullRequest struct {
+	NodeID  string `json:"node_id"`
+	HTMLURL string `json:"html_url"`
+	Body    string `json:"body"`
+	Merged  bool   `json:"merged"`
+}
+
+type githubPullRequestEvent struct {
+	Action      string            `json:"action"`
+	PullRequest githubPullRequest `json:"pull_request"`
+	Sender      githubUser        `json:"sender"`
+}
+
+type githubPullRequestReviewEvent struct {
+	Action string `json:"action"`
+	Review struct {
+		State string     `json:"state"`
+		User  githubUser `json:"user"`
+	} `json:"review"`
+	PullRequest githubPullRequest `json:"pull_request"`
+}
+
+func (h githubWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("[ERROR] Failed to read GitHub webhook body: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !h.verifySignature(r.Header.Get("X-Hub-Signature-256"), body) {
+		log.Printf("[ERROR] Invalid GitHub webhook signature")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Header.Get("X-GitHub-Event") {
+	case "pull_request":
+		var ev githubPullRequestEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			log.Printf("[ERROR] Failed to unmarshal pull_request event: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := h.handlePullRequestEvent(ev); err != nil {
+			log.Printf("[ERROR] Failed to handle pull_request event for %s: %s", ev.PullRequest.HTMLURL, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	case "pull_request_review":
+		var ev githubPullRequestReviewEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			log.Printf("[ERROR] Failed to unmarshal pull_request_review event: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := h.handlePullRequestReviewEvent(ev); err != nil {
+			log.Printf("[ERROR] Failed to handle pull_request_review event for %s: %s", ev.PullRequest.HTMLURL, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h githubWebhookHandler) verifySignature(signature string, body []byte) bool {
+	if h.secret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(h.secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// findDeployMetadata returns the metadata of the deploy pull request,
+// or false if the pull request isn't linked to a Slack thread.
+// Pull requests not created by gocat have no metadata, which we silently ignore.
+func findDeployMetadata(pr githubPullRequest) (DeployMetadata, bool) {
+	metadata, err := ParseDeployMetadata(pr.Body)
+	if err != nil || metadata.SlackChannel == "" || metadata.SlackThreadTS == "" {
+		return metadata, false
+	}
+	return metadata, true
+}
+
+func (h githubWebhookHandler) handlePullRequestEvent(ev githubPullRequestEvent) error {
+	if ev.Action != "closed" {
+		return nil
+	}
+	metadata, ok := findDeployMetadata(ev.PullRequest)
+	if !ok {
+		return nil
+	}
+
+	if h.botLogin != "" && strings.EqualFold(ev.Sender.Login, h.botLogin) {
+		return nil
+	}
+
+	switch {
+	case !ev.PullRequest.Merged:
+		h.tracer.EmitFor(metadata, DeployEventSkipped, "closed %s by %s on GitHub", ev.PullRequest.HTMLURL, ev.Sender.Login)
+	case h.projectList.Find(metadata.Project).FindPhase(metadata.Phase).SyntheticChecks.Enabled():
+		// The deploy is recorded as deployed once the synthetic checks pass in the post-deploy hooks
+		h.tracer.Record(metadata.TraceID, "merged %s by %s on GitHub, running the synthetic checks", ev.PullRequest.HTMLURL, ev.Sender.Login)
+	default:
+		h.tracer.EmitFor(metadata, DeployEventDeployed, "merged %s by %s on GitHub", ev.PullRequest.HTMLURL, ev.Sender.Login)
+	}
+	if ev.PullRequest.Merged {
+		// The hooks run in the background as the synthetic checks of the phase may take minutes
+		go h.postDeployHooks.Run(metadata, ev.PullRequest.HTMLURL)
+	}
+
+	action := "closed"
+	if ev.PullRequest.Merged {
+		action = "merged"
+	}
+	text := fmt.Sprintf("%s was %s by %s on GitHub", ev.PullRequest.HTMLURL, action, ev.Sender.Login)
+
+	// Replace the approval message so that nobody clicks the Deploy or Close button of a finished deploy.
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", text, false, false), nil, nil),
+	}
+	if _, _, _, err := h.client.UpdateMessage(metadata.SlackChannel, metadata.SlackThreadTS, slack.MsgOptionBlocks(blocks...)); err != nil {
+		return fmt.Errorf("unable to update the approval message: %w", err)
+	}
+	_, _, err := h.client.PostMessage(
+		metadata.SlackChannel,
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionTS(metadata.SlackThreadTS),
+	)
+	return err
+}
+
+// handlePullRequestReviewEvent reflects approvals and change requests made on GitHub
+// in the Slack approval message, as a reaction to the message and a reply in its thread.
+func (h githubWebhookHandler) handlePullRequestReviewEvent(ev githubPullRequestReviewEvent) error {
+	if ev.Action != "submitted" {
+		return nil
+	}
+	metadata, ok := findDeployMetadata(ev.PullRequest)
+	if !ok {
+		return nil
+	}
+
+	var reaction, state string
+	switch strings.ToLower(ev.Review.State) {
+	case "approved":
+		reaction, state = "white_check_mark", "approved"
+	case "changes_requested":
+		reaction, state = "x", "requested changes on"
+	default:
+		return nil
+	}
+
+	ref := slack.NewRefToMessage(metadata.SlackChannel, metadata.SlackThreadTS)
+	if err := h.client.AddReaction(reaction, ref); err != nil && err.Error() != "already_reacted" {
+		return fmt.Errorf("unable to add a reaction to the approval message: %w", err)
+	}
+	_, _, err := h.client.PostMessage(
+		metadata.SlackChannel,
+		slack.MsgOptionText(fmt.Sprintf("%s %s %s on GitHub", ev.Review.User.Login, state, ev.PullRequest.HTMLURL), false),
+		slack.MsgOptionTS(metadata.SlackThreadTS),
+	)
+	return err
+}
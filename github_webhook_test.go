@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubWebhookHandler_Signature(t *testing.T) {
+	h := githubWebhookHandler{secret: "secret"}
+	body := `{"action":"opened","pull_request":{"body":"no metadata"}}`
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	valid := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	for _, tc := range []struct {
+		signature string
+		want      int
+	}{
+		{signature: valid, want: http.StatusOK},
+		{signature: "sha256=invalid", want: http.StatusUnauthorized},
+		{signature: "", want: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/github", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "pull_request")
+		req.Header.Set("X-Hub-Signature-256", tc.signature)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, tc.want, rec.Code)
+	}
+}
+
+func TestGitHubWebhookHandler_PullRequestEvent(t *testing.T) {
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	}))
+	defer slackServer.Close()
+	stream, err := NewDeployEventStream("http://kafka-rest:8082", "gocat.deploy-events", "json")
+	require.NoError(t, err)
+	tracer := NewDeployTracer()
+	tracer.stream = stream
+	projectList := &ProjectList{items: []DeployProject{{ID: "myapp", Phases: []DeployPhase{{Name: "production"}}}}}
+	h := githubWebhookHandler{client: slack.New("xoxb-test", slack.OptionAPIURL(slackServer.URL+"/")), projectList: projectList, tracer: tracer}
+
+	// The deploys requested before gocat restarted are emitted by their metadata
+	m := DeployMetadata{Project: "myapp", Phase: "production", SlackChannel: "C1", SlackThreadTS: "1.2", TraceID: "deadbeef"}
+	ev := githubPullRequestEvent{Action: "closed", Sender: githubUser{Login: "alice"}}
+	ev.PullRequest.Body = m.String()
+	ev.PullRequest.HTMLURL = "https://github.com/zaiminc/manifests/pull/1"
+	require.NoError(t, h.handlePullRequestEvent(ev))
+
+	events := stream.peek()
+	require.Len(t, events, 1)
+	require.Equal(t, DeployEventSkipped, events[0].Type)
+	require.Equal(t, "deadbeef", events[0].TraceID)
+	require.Equal(t, "myapp", events[0].Project)
+}
@@ -1,7 +1,53 @@
 package main
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // GitOpsPlugin is the extension point for InteractorGitOps
 // It is used to support various GitOps tools.
 type GitOpsPlugin interface {
-	Prepare(pj DeployProject, phase string, branch string, user User, message string) (o GitOpsPrepareOutput, err error)
+	Prepare(pj DeployProject, phase string, option DeployOption) (o GitOpsPrepareOutput, err error)
+}
+
+// GitOpsPluginFactory creates a GitOpsPlugin that uses gocat's GitHub and Git support.
+type GitOpsPluginFactory func(github *GitHub, git *GitOperator) GitOpsPlugin
+
+// gitOpsPlugins is the registry of the GitOpsPlugins by name.
+// A phase, or a project, uses the plugin whose name is its kind.
+var gitOpsPlugins = map[string]GitOpsPluginFactory{}
+
+func init() {
+	RegisterGitOpsPlugin("kustomize", NewGitOpsPluginKustomize)
+	RegisterGitOpsPlugin("kanvas", NewGitOpsPluginKanvas)
+}
+
+// RegisterGitOpsPlugin registers the GitOpsPlugin created by f as the kind name,
+// so that the phases of the kind are deployed via InteractorGitOps and ModelGitOps using the plugin.
+//
+// Third-party plugins compiled into gocat register themselves in their init functions.
+// The plugin reads its own options from DeployPhase.PluginOptions of the phase given to Prepare.
+//
+// It panics if name is already registered or can't be a kind, as that's a programming error.
+func RegisterGitOpsPlugin(name string, f GitOpsPluginFactory) {
+	if name == "" || strings.Contains(name, "_") || strings.Contains(name, "|") {
+		// The name is embedded into the action values of the Slack buttons. See InteractorContext.actionHeader.
+		panic(fmt.Sprintf("invalid gitops plugin name %q: it must be non-empty and contain neither _ nor |", name))
+	}
+	if _, ok := gitOpsPlugins[name]; ok {
+		panic(fmt.Sprintf("gitops plugin %q is already registered", name))
+	}
+	gitOpsPlugins[name] = f
+}
+
+// GitOpsPluginNames returns the names of the registered GitOpsPlugins in alphabetical order.
+func GitOpsPluginNames() []string {
+	var names []string
+	for name := range gitOpsPlugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
@@ -1,17 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
-
-	gogit "github.com/go-git/go-git/v5"
+	"strings"
+	"sync"
 
 	"github.com/davinci-std/kanvas/client"
-	"github.com/davinci-std/kanvas/client/cli"
 )
 
 // GitOpsPluginKanvas is a gocat gitops plugin to prepare
@@ -30,16 +32,18 @@ func NewGitOpsPluginKanvas(github *GitHub, git *GitOperator) GitOpsPlugin {
 	return &GitOpsPluginKanvas{github: github, git: git}
 }
 
-func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, branch string, assigner User, tag string) (GitOpsPrepareOutput, error) {
+func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, option DeployOption) (GitOpsPrepareOutput, error) {
 	var o GitOpsPrepareOutput
 
+	branch, assigner, tag := option.Branch, option.Assigner, option.Tag
+
 	o.status = DeployStatusFail
 	if tag == "" {
 		ecr, err := CreateECRInstance()
 		if err != nil {
 			return o, err
 		}
-		tag, err = ecr.FindImageTagByRegexp(pj.ECRRegistryId(), pj.ECRRepository(), pj.ImageTagRegexp(), pj.TargetRegexp(), ImageTagVars{Branch: branch, Phase: phase})
+		tag, err = ecr.FindImageTag(pj.ImageTagQuery(pj.FindPhase(phase), ImageTagVars{Branch: branch, Phase: phase}))
 		if err != nil {
 			return o, err
 		}
@@ -49,8 +53,12 @@ func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, branch strin
 	if ph.Name == "" {
 		return o, fmt.Errorf("phase %s not found for project %s", phase, pj.ID)
 	}
+	if err := ph.TagPolicy.Check(tag); err != nil {
+		return o, err
+	}
 
-	// The head of the pull request is bot/docker-image-tag-<project_id>-<phase_name>-<tag>.
+	// The head of the pull request is bot/docker-image-tag-<project_id>-<phase_name>-<tag> by default,
+	// which is the same as the branch of the kustomize kind. See DeployProject.DeployBranchName.
 	// And it's used by kanvas to create a pull request against the master or the main branch of the repository
 	// specified in the kanvas.yaml, not the repository that contains kanvas.yaml.
 	//
@@ -62,7 +70,10 @@ func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, branch strin
 	//
 	// In this case, the head of the pull request is bot/docker-image-tag-<project_id>-<phase_name>-<tag>
 	// in the infra repository, not the myapp repository.
-	head := fmt.Sprintf("bot/docker-image-tag-%s-%s-%s", pj.ID, ph.Name, tag)
+	head, err := pj.DeployBranchName(ph.Name, tag, branch, "")
+	if err != nil {
+		return o, err
+	}
 
 	// Treat the kanvas.yaml as the way to generate the desired state of the deployment,
 	// not the desired state itself.
@@ -77,65 +88,66 @@ func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, branch strin
 	// Instead, we let kanvas to create pull requests against the master or the main branch of the repository
 	// as defined in the kanvas.yaml.
 
-	git := *k.git
-	git.repository = nil
-	git.repo = "https://github.com/" + k.github.org + "/" + pj.gitHubRepository + ".git"
-	if err := git.Clone(); err != nil {
-		if !errors.Is(err, gogit.ErrRepositoryAlreadyExists) {
-			return o, fmt.Errorf("failed to clone repository: %w", err)
-		}
+	git, cleanup, err := k.cloneRepository(pj, ph.Name)
+	if err != nil {
+		return o, err
 	}
+	defer cleanup()
 
+	if _, err := git.checkoutMainBranch(); err != nil {
+		return o, err
+	}
+
+	c := kanvasCLI{}
+
+	repoRoot := git.getLocalRepoRoot()
+	tmpdir := filepath.Join(repoRoot, ".kanvastmp")
+
+	if err := os.MkdirAll(tmpdir, 0755); err != nil {
+		return o, fmt.Errorf("failed to create .kanvastmp directory: %w", err)
+	}
 	defer func() {
-		if err := git.Clean(); err != nil {
-			fmt.Println(err)
+		if err := os.RemoveAll(tmpdir); err != nil {
+			fmt.Println("[ERROR] Failed to remove .kanvastmp directory: ", err)
 		}
 	}()
 
-	wt, err := git.checkoutMainBranch()
+	// The components to skip and their outputs default to the gocat conventions.
+	// See KanvasOption for how to configure them per phase.
+	vars := KanvasVars{Project: pj.ID, Phase: ph.Name, Branch: branch, Tag: tag}
+	skipped, err := ph.Kanvas.SkippedComponentsFor(vars)
 	if err != nil {
 		return o, err
 	}
+	envVars, err := ph.Kanvas.EnvVarsFor(vars)
+	if err != nil {
+		return o, err
+	}
+	// This is a hack to make kanvas to use a directory that we can clean up later.
+	// This is necessary to not leave any temporary files in random directories.
+	//
+	// You usually see json files containing information about the pull request created by
+	// kanvs apply command in this directory.
+	//
+	// We assume kanvas recursively creates a directory if it doesn't exist.
+	// That's why we don't create this .kanvastmp directory ourselves here.
+	envVars["TMPDIR"] = tmpdir
+	// kanvas requires the token to be set in the GITHUB_TOKEN envvar,
+	// where gocat expects the token to be set in the CONFIG_GITHUB_ACCESS_TOKEN envvar.
+	envVars["GITHUB_TOKEN"] = os.Getenv("CONFIG_GITHUB_ACCESS_TOKEN")
 
-	c := cli.New()
-
-	tmpdir := filepath.Join(git.getLocalRepoRoot(), ".kanvastmp")
-
-	if err := os.MkdirAll(tmpdir, 0755); err != nil {
-		return o, fmt.Errorf("failed to create .kanvastmp directory: %w", err)
+	// The output is archived along with the one streamed to Slack
+	var applyLog bytes.Buffer
+	var output io.Writer = &applyLog
+	if option.Output != nil {
+		output = io.MultiWriter(option.Output, &applyLog)
 	}
 
 	applyOpts := client.ApplyOptions{
-		SkippedComponents: map[string]map[string]string{
-			// Any kanvas.yaml that can be used by gocat needs to have
-			// "image" component that uses the kanavs's docker provider for building the container image.
-			//
-			// We also assume that the components(like kustomize, argocd app, etc,) that depends on the "image" component uses
-			// either the "tag" or the "id" output of the "image" component for the deployment.
-			"image": {
-				"tag": tag,
-				"id":  tag,
-			},
-			// This is an opinionated convention that we use in gocat.
-			// You can add any component named "prereq" in kanvas.yaml, and it is not used when triggered via gocat.
-			"prereq": {},
-		},
-		GitUserName:     git.username,
-		PullRequestHead: head,
-		EnvVars: map[string]string{
-			// This is a hack to make kanvas to use a directory that we can clean up later.
-			// This is necessary to not leave any temporary files in random directories.
-			//
-			// You usually see json files containing information about the pull request created by
-			// kanvs apply command in this directory.
-			//
-			// We assume kanvas recursively creates a directory if it doesn't exist.
-			// That's why we don't create this .kanvastmp directory ourselves here.
-			"TMPDIR": tmpdir,
-			// kanvas requires the token to be set in the GITHUB_TOKEN envvar,
-			// where gocat expects the token to be set in the CONFIG_GITHUB_ACCESS_TOKEN envvar.
-			"GITHUB_TOKEN": os.Getenv("CONFIG_GITHUB_ACCESS_TOKEN"),
-		},
+		SkippedComponents: skipped,
+		GitUserName:       git.username,
+		PullRequestHead:   head,
+		EnvVars:           envVars,
 	}
 
 	if assigner.GitHubNodeID != "" {
@@ -160,7 +172,7 @@ func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, branch strin
 		path = filepath.Join(path, "kanvas.yaml")
 	}
 
-	realPath := wt.Filesystem.Join(git.getLocalRepoRoot(), path)
+	realPath := filepath.Join(repoRoot, path)
 
 	// We treat gocat "phase" as kanvas "environment".
 	//
@@ -183,7 +195,7 @@ func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, branch strin
 	// 	KANVAS_PULLREQUEST_HEAD=< head > \
 	// 	 kanvas apply --env <phase> --config <path> --skipped-jobs-outputs '{"image":{"id":"<tag>","tag":"<tag>"}}'
 	//
-	r, err := c.Apply(context.Background(), realPath, phase, applyOpts)
+	r, err := c.Apply(context.Background(), realPath, phase, applyOpts, output)
 	if err != nil {
 		return o, err
 	}
@@ -213,6 +225,36 @@ func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, branch strin
 		return o, fmt.Errorf("failed to convert pull request number to int: %w", err)
 	}
 
+	// The pull request is created against the repository specified in the kanvas.yaml,
+	// which can be found in the URL like https://github.com/<org>/<repo>/pull/<number>.
+	var prRepo string
+	if p := strings.Split(strings.TrimPrefix(pr.HTMLURL, "https://github.com/"), "/"); len(p) > 1 {
+		prRepo = p[1]
+	}
+	if err := k.github.SetNewPullRequestMetadata(prRepo, pr.NodeID, ph.PullRequest); err != nil {
+		return o, err
+	}
+
+	// kanvas writes the pull request body by itself,
+	// so we append the gocat metadata afterwards for later use like rollback.
+	created, err := k.github.GetPullRequest(GitHubGetPullRequestInput{GitHubInput: GitHubInput{Repository: prRepo}, Number: prNum})
+	if err != nil {
+		return o, fmt.Errorf("unable to get pull request %s: %w", pr.HTMLURL, err)
+	}
+	metadata := DeployMetadata{
+		Project:          pj.ID,
+		Phase:            ph.Name,
+		Branch:           branch,
+		Tag:              tag,
+		Requester:        assigner.SlackDisplayName,
+		Reason:           option.Reason,
+		RequesterSlackID: assigner.SlackUserID,
+		TraceID:          option.TraceID,
+	}
+	if err := k.github.UpdatePullRequestBody(pr.NodeID, created.Body+"\n\n"+metadata.PullRequestFooter()); err != nil {
+		return o, fmt.Errorf("unable to update pull request %s: %w", pr.HTMLURL, err)
+	}
+
 	o = GitOpsPrepareOutput{
 		PullRequestID:     pr.NodeID,
 		PullRequestNumber: prNum,
@@ -225,7 +267,78 @@ func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, branch strin
 		// specified in the kanvas.yaml.
 		PullRequestHTMLURL: pr.HTMLURL,
 		Branch:             head,
+		Artifacts:          map[string][]byte{"kanvas-apply.log": applyLog.Bytes()},
 		status:             DeployStatusSuccess,
 	}
+	if b, err := json.MarshalIndent(metadata, "", "  "); err == nil {
+		o.Artifacts["metadata.json"] = b
+	}
 	return o, nil
 }
+
+// cloneRepository clones the repository that contains kanvas.yaml for the project,
+// and returns the GitOperator for the clone along with the function to clean it up after the deploy.
+//
+// kanvas is a CLI that needs the repository on the real filesystem.
+// When gitRoot is set, the clone under gitRoot/kanvas/<project>/<phase> is reused across the deploys of the phase and only pulled,
+// leaving its eviction to GitRootJanitor. Each phase has its own clone so that the deploys of the other phases and projects
+// sharing the repository, which may run concurrently, don't check out and write .kanvastmp in the same worktree.
+// The deploys of the same phase, like the ones of two branches, take turns on the clone, which is locked until the cleanup function is called.
+// When gitRoot is empty, which means gocat uses the in-memory filesystem for the manifest repository,
+// the repository is cloned into a temporary directory that is removed by the cleanup function.
+func (k GitOpsPluginKanvas) cloneRepository(pj DeployProject, phase string) (*GitOperator, func(), error) {
+	git := *k.git
+	git.repository = nil
+	// The mirror is the one of the manifest repository
+	git.mirror = ""
+	git.repo = "https://github.com/" + k.github.org + "/" + pj.gitHubRepository + ".git"
+
+	cleanup := func() {}
+	if git.gitRoot != "" {
+		git.gitRoot = filepath.Join(git.gitRoot, "kanvas", pj.ID, phase)
+		cleanup = lockKanvasClone(git.gitRoot)
+	} else {
+		tmp, err := os.MkdirTemp("", "gocat-kanvas-")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create a temporary directory to clone %s: %w", git.repo, err)
+		}
+		git.gitRoot = tmp
+		cleanup = func() {
+			if err := os.RemoveAll(tmp); err != nil {
+				fmt.Println("[ERROR] Failed to remove the temporary clone: ", err)
+			}
+		}
+	}
+
+	if err := git.CloneOrOpen(); err != nil {
+		if k.git.gitRoot != "" {
+			if err := git.Clean(); err != nil {
+				// The cached clone may be broken, like when gocat crashed in the middle of a previous clone.
+				// We remove it so that the next deploy clones it fresh.
+				fmt.Println("[ERROR] Failed to remove the broken clone: ", err)
+			}
+		}
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to clone repository: %w", err)
+	}
+	return &git, cleanup, nil
+}
+
+// kanvasClones are the locks of the clones of cloneRepository under gitRoot, by their paths.
+var kanvasClones = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: map[string]*sync.Mutex{}}
+
+// lockKanvasClone locks the clone at the path until the returned function is called.
+func lockKanvasClone(path string) func() {
+	kanvasClones.Lock()
+	lock, ok := kanvasClones.locks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		kanvasClones.locks[path] = lock
+	}
+	kanvasClones.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
+	"path"
 	"strings"
+
+	"sigs.k8s.io/kustomize/api/types"
 )
 
 // GitOpsPluginKustomize is a gocat gitops plugin to prepare
@@ -13,35 +18,113 @@ import (
 type GitOpsPluginKustomize struct {
 	github *GitHub
 	git    *GitOperator
+	// verifier verifies the images with the imageSignature of the phases.
+	verifier ImageVerifier
+	// sboms fetches the SBOMs of the images for the phases with sbom.
+	sboms SBOMFetcher
+	// copier copies the images for the phases with promotion.
+	copier ImageCopier
+	// digests resolves the tags of the images to the digests verified with the imageSignature of the phases.
+	digests DigestResolver
 }
 
 func NewGitOpsPluginKustomize(github *GitHub, git *GitOperator) GitOpsPlugin {
-	return &GitOpsPluginKustomize{github: github, git: git}
+	return &GitOpsPluginKustomize{github: github, git: git, verifier: cosignCLI{}, sboms: cosignCLI{}, copier: craneCLI{}, digests: craneCLI{}}
 }
 
-func (k GitOpsPluginKustomize) Prepare(pj DeployProject, phase string, branch string, assigner User, tag string) (o GitOpsPrepareOutput, err error) {
+// defaultPullRequestBodyTemplate is the default template of the deploy pull request body.
+// See DeployMessageVars for the available variables.
+const defaultPullRequestBodyTemplate = "`{{.PreviousTag}}` → `{{.Tag}}`\n" +
+	"Requested by {{.Requester}}{{if .SlackURL}} in {{.SlackURL}}{{end}}\n" +
+	"{{if .Reason}}Reason: {{.Reason}}\n{{end}}\n" +
+	"```diff\n{{.Diff}}```\n\n" +
+	"{{.Changelog}}"
+
+func (k GitOpsPluginKustomize) Prepare(pj DeployProject, phase string, option DeployOption) (o GitOpsPrepareOutput, err error) {
+	branch, assigner, tag := option.Branch, option.Assigner, option.Tag
+
 	o.status = DeployStatusFail
-	if tag == "" {
+	ph := pj.FindPhase(phase)
+	if ph.BlueGreen.Enabled() {
+		// Deploys never touch the live color
+		if ph, err = NewBlueGreenSwitcher(k.github, k.git).IdlePhase(ph); err != nil {
+			return
+		}
+	}
+	queries := pj.ImageTagQueries(ph, ImageTagVars{Branch: branch, Phase: phase})
+	images := []types.Image{{Name: queries[0].Image, NewTag: tag}}
+	if len(option.Images) > 0 {
+		images = append([]types.Image{}, option.Images...)
+		tag = images[0].NewTag
+	} else if tag == "" {
+		ecr, err := CreateECRInstance()
+		if err != nil {
+			return o, err
+		}
+		// All the images of the phase are deployed together unless the tag is specified,
+		// in which case it's for the primary image only.
+		tags, err := ecr.FindImageTags(queries)
+		if err != nil {
+			return o, err
+		}
+		images = images[:0]
+		for i, q := range queries {
+			images = append(images, types.Image{Name: q.Image, NewTag: tags[i]})
+		}
+		tag = tags[0]
+	}
+	if len(ph.Architectures) > 0 {
 		ecr, err := CreateECRInstance()
 		if err != nil {
 			return o, err
 		}
-		tag, err = ecr.FindImageTagByRegexp(pj.ECRRegistryId(), pj.ECRRepository(), pj.ImageTagRegexp(), pj.TargetRegexp(), ImageTagVars{Branch: branch, Phase: phase})
+		if err := checkImageArchitectures(ecr, ph, queries, images); err != nil {
+			return o, err
+		}
+	}
+	if ph.PinDigest {
+		ecr, err := CreateECRInstance()
 		if err != nil {
 			return o, err
 		}
+		for i := range images {
+			if images[i].Digest != "" {
+				continue
+			}
+			if images[i].Digest, err = ecr.ImageDigest(queries[i], images[i].NewTag); err != nil {
+				return o, err
+			}
+		}
 	}
+	o.Images = images
 
-	ph := pj.FindPhase(phase)
 	currentTag, err := ph.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: k.github})
 	if err != nil {
 		return
 	}
 
-	if tag == currentTag {
+	images, err = k.changedImages(ph, currentTag, images)
+	if err != nil {
+		return
+	}
+	if len(images) == 0 {
 		o.status = DeployStatusAlready
 		return
 	}
+	images, warnings, err := checkImages(k.verifier, k.digests, ph, images)
+	if err != nil {
+		return
+	}
+	// The images are promoted only after they are verified
+	if err = promoteImages(k.copier, ph, images); err != nil {
+		return
+	}
+	sboms := fetchSBOMs(k.sboms, ph, images)
+	defer func() {
+		if err == nil {
+			o = withSBOMs(o, sboms)
+		}
+	}()
 
 	commits, err := k.github.CommitsBetween(GitHubCommitsBetweenInput{
 		Repository:    pj.GitHubRepository(),
@@ -59,12 +142,76 @@ func (k GitOpsPluginKustomize) Prepare(pj DeployProject, phase string, branch st
 		commitlog = commitlog + "- " + m + "\n"
 	}
 
-	prBranch, err := k.git.PushDockerImageTag(pj.ID, ph, tag, pj.DockerRepository())
+	vars := DeployMessageVars{
+		Project:     pj.ID,
+		Phase:       ph.Name,
+		Path:        ph.Path,
+		PreviousTag: currentTag,
+		Tag:         tag,
+		Branch:      branch,
+		Requester:   assigner.SlackDisplayName,
+		Changelog:   commitlog,
+		Reason:      option.Reason,
+		Warnings:    warnings,
+		SBOMs:       sboms,
+		SlackURL:    option.SlackURL,
+	}
+	commitMessage, err := vars.Parse(pj.CommitMessageTemplate())
+	if err != nil {
+		return o, fmt.Errorf("unable to render the commit message template of %s: %w", pj.ID, err)
+	}
+	title, err := vars.Parse(pj.PullRequestTitleTemplate())
+	if err != nil {
+		return o, fmt.Errorf("unable to render the pull request title template of %s: %w", pj.ID, err)
+	}
+
+	prBranch, err := pj.DeployBranchName(ph.Name, images[0].NewTag, branch, images[0].Digest)
 	if err != nil {
 		return
 	}
+	metadata := DeployMetadata{
+		Project:          pj.ID,
+		Phase:            ph.Name,
+		Branch:           branch,
+		PreviousTag:      currentTag,
+		Tag:              tag,
+		Requester:        assigner.SlackDisplayName,
+		Reason:           option.Reason,
+		RequesterSlackID: assigner.SlackUserID,
+		TraceID:          option.TraceID,
+	}
 
-	prID, prNum, err := k.github.CreatePullRequest(prBranch, fmt.Sprintf("Deploy %s %s", pj.ID, branch), commitlog)
+	switch ph.CommitStrategy {
+	case "", CommitStrategyPullRequest:
+	case CommitStrategyDirect:
+		return k.pushDirectly(ph, prBranch, images, commitMessage, metadata, option.Gate)
+	default:
+		return o, fmt.Errorf("unknown commitStrategy %q for %s %s", ph.CommitStrategy, pj.ID, ph.Name)
+	}
+
+	if ph.StackDeploys {
+		pr, found, err := k.findOpenPullRequest(pj.ID, ph.Name)
+		if err != nil {
+			return o, err
+		}
+		if found {
+			return k.stack(pj, ph, pr, images, vars, title, commitMessage, metadata)
+		}
+	}
+
+	diff, err := k.git.PushDockerImageTags(prBranch, ph, images, commitMessage)
+	if err != nil {
+		return
+	}
+
+	vars.Diff = diff
+	body, err := vars.Parse(pj.PullRequestBodyTemplate())
+	if err != nil {
+		return o, fmt.Errorf("unable to render the pull request body template of %s: %w", pj.ID, err)
+	}
+	body = body + warningLines(vars.Warnings) + sbomLines(vars.SBOMs) + "\n\n" + metadata.PullRequestFooter()
+
+	prID, prNum, err := k.github.CreatePullRequest(prBranch, title, body)
 	if err != nil {
 		return
 	}
@@ -76,11 +223,164 @@ func (k GitOpsPluginKustomize) Prepare(pj DeployProject, phase string, branch st
 		}
 	}
 
+	if err = k.github.SetNewPullRequestMetadata("", prID, ph.PullRequest); err != nil {
+		return
+	}
+
 	o = GitOpsPrepareOutput{
 		PullRequestID:     prID,
 		PullRequestNumber: prNum,
 		Branch:            prBranch,
+		Artifacts:         k.artifacts(ph, prBranch, diff, metadata),
+		Metadata:          metadata,
 		status:            DeployStatusSuccess,
 	}
 	return
 }
+
+// artifacts returns the files archived for the deploy, which are the metadata, the diff, and the overlay of the phase at the deploy commit.
+func (k GitOpsPluginKustomize) artifacts(ph DeployPhase, branch, diff string, metadata DeployMetadata) map[string][]byte {
+	artifacts := map[string][]byte{"diff.patch": []byte(diff)}
+	if b, err := json.MarshalIndent(metadata, "", "  "); err == nil {
+		artifacts["metadata.json"] = b
+	}
+	files, err := k.git.Files(branch, path.Dir(ph.Path))
+	if err != nil {
+		log.Printf("[ERROR] Failed to read the manifests of %s %s to archive: %s", metadata.Project, ph.Name, err)
+	}
+	for name, content := range files {
+		artifacts["manifests/"+name] = content
+	}
+	return artifacts
+}
+
+// pushDirectly pushes the deploy commit straight to the default branch, for the phases whose commitStrategy is direct.
+// The deploy is checked with gate, if any, before it's pushed, as nothing approves it.
+func (k GitOpsPluginKustomize) pushDirectly(ph DeployPhase, branch string, images []types.Image, message string, metadata DeployMetadata, gate func(DeployMetadata) error) (o GitOpsPrepareOutput, err error) {
+	o.status = DeployStatusFail
+	if ph.TwoPersonRule {
+		// A direct commit has nothing to approve, so it would silently bypass the rule
+		return o, fmt.Errorf("commitStrategy direct can't be used with twoPersonRule for %s %s", metadata.Project, ph.Name)
+	}
+	if gate != nil {
+		if err := gate(metadata); err != nil {
+			return o, err
+		}
+	}
+
+	sha, diff, err := k.git.PushDockerImageTagsDirectly(branch, ph, images, message)
+	if err != nil {
+		return o, err
+	}
+
+	return GitOpsPrepareOutput{
+		CommitSHA:     sha,
+		CommitHTMLURL: fmt.Sprintf("https://github.com/%s/%s/commit/%s", k.github.org, k.github.repo, sha),
+		Metadata:      metadata,
+		Artifacts:     k.artifacts(ph, branch, diff, metadata),
+		status:        DeployStatusSuccess,
+	}, nil
+}
+
+// findOpenPullRequest returns the newest open deploy pull request of the phase of the project,
+// which is identified by the metadata embedded in its body.
+func (k GitOpsPluginKustomize) findOpenPullRequest(project, phase string) (OpenPullRequest, bool, error) {
+	prs, err := k.github.ListOpenPullRequests()
+	if err != nil {
+		return OpenPullRequest{}, false, fmt.Errorf("unable to list the open pull requests: %w", err)
+	}
+	for _, pr := range prs {
+		m, err := ParseDeployMetadata(pr.Body)
+		if err != nil || m.Kind != "" {
+			// Not a deploy pull request of gocat, like a rollback
+			continue
+		}
+		if m.Project == project && m.Phase == phase {
+			return pr, true, nil
+		}
+	}
+	return OpenPullRequest{}, false, nil
+}
+
+// stack pushes the deploy commit to the head branch of the open deploy pull request, for the phases with stackDeploys,
+// and updates the title and the body of the pull request to describe the new tag.
+func (k GitOpsPluginKustomize) stack(pj DeployProject, ph DeployPhase, pr OpenPullRequest, images []types.Image, vars DeployMessageVars, title, message string, metadata DeployMetadata) (o GitOpsPrepareOutput, err error) {
+	o.status = DeployStatusFail
+	previous, err := ParseDeployMetadata(pr.Body)
+	if err != nil {
+		return o, err
+	}
+
+	// The pull request ships the deploys stacked before too, so they need an approval of someone other than any of their requesters
+	metadata.StackedRequesterSlackIDs = previous.RequesterSlackIDs()
+	if previous.Requester != "" && previous.Requester != metadata.Requester {
+		metadata.Requester = previous.Requester + ", " + metadata.Requester
+	}
+
+	diff, err := k.git.StackDockerImageTags(pr.HeadRefName, ph, images, message)
+	if err != nil {
+		return o, err
+	}
+
+	comment := fmt.Sprintf("Stacked `%s` → `%s` requested by %s", previous.Tag, metadata.Tag, vars.Requester)
+	vars.Diff = diff
+	vars.Requester = metadata.Requester
+	body, err := vars.Parse(pj.PullRequestBodyTemplate())
+	if err != nil {
+		return o, fmt.Errorf("unable to render the pull request body template of %s: %w", pj.ID, err)
+	}
+	body = body + warningLines(vars.Warnings) + sbomLines(vars.SBOMs) + "\n\n" + metadata.PullRequestFooter()
+	if err := k.github.UpdatePullRequestTitleAndBody(pr.ID, title, body); err != nil {
+		return o, err
+	}
+
+	if err := k.github.AddComment(pr.ID, comment); err != nil {
+		log.Printf("[ERROR] Failed to comment on the pull request #%d: %s", pr.Number, err)
+	}
+
+	return GitOpsPrepareOutput{
+		PullRequestID:     pr.ID,
+		PullRequestNumber: pr.Number,
+		Branch:            pr.HeadRefName,
+		Artifacts:         k.artifacts(ph, pr.HeadRefName, diff, metadata),
+		Metadata:          metadata,
+		Stacked:           &previous,
+		status:            DeployStatusSuccess,
+	}, nil
+}
+
+// changedImages returns the images whose tags differ from the ones currently deployed.
+// The first image is the primary one, whose current tag is given by the destination of the phase.
+// The current tags of the rest are read from the kustomization.yaml of the phase.
+func (k GitOpsPluginKustomize) changedImages(ph DeployPhase, currentTag string, images []types.Image) ([]types.Image, error) {
+	var changed []types.Image
+	if images[0].NewTag != currentTag {
+		changed = append(changed, images[0])
+	}
+	if len(images) == 1 && !ph.PinDigest {
+		return changed, nil
+	}
+
+	kf, err := k.github.GetKustomization(ph.Path)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]types.Image{}
+	for _, image := range kf.Images {
+		current[image.Name] = image
+	}
+	for i, image := range images {
+		c := current[image.Name]
+		if i == 0 {
+			// The tag of the primary image is compared with currentTag above
+			if image.NewTag == currentTag && c.Digest != image.Digest {
+				changed = append(changed, image)
+			}
+			continue
+		}
+		if c.NewTag != image.NewTag || c.Digest != image.Digest {
+			changed = append(changed, image)
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/shurcooL/githubv4"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+type fakeGitOpsPlugin struct {
+	github *GitHub
+	git    *GitOperator
+}
+
+func (p fakeGitOpsPlugin) Prepare(pj DeployProject, phase string, option DeployOption) (GitOpsPrepareOutput, error) {
+	return GitOpsPrepareOutput{Branch: pj.FindPhase(phase).PluginOptions["branch"]}, nil
+}
+
+func TestRegisterGitOpsPlugin(t *testing.T) {
+	RegisterGitOpsPlugin("fake", func(github *GitHub, git *GitOperator) GitOpsPlugin {
+		return fakeGitOpsPlugin{github: github, git: git}
+	})
+	defer delete(gitOpsPlugins, "fake")
+
+	require.Equal(t, []string{"fake", "kanvas", "kustomize"}, GitOpsPluginNames())
+	require.Panics(t, func() {
+		RegisterGitOpsPlugin("fake", nil)
+	})
+	require.Panics(t, func() {
+		RegisterGitOpsPlugin("fake_plugin", nil)
+	})
+
+	f := NewInteractorFactory(InteractorContext{})
+	pj := DeployProject{
+		Kind: "kustomize",
+		Phases: []DeployPhase{
+			{Name: "staging"},
+			{Name: "sandbox", Kind: "fake", PluginOptions: map[string]string{"branch": "sandbox"}},
+		},
+	}
+
+	interactor, ok := f.Get(pj, "sandbox").(InteractorGitOps)
+	require.True(t, ok)
+	require.Equal(t, "fake", interactor.kind)
+	require.Equal(t, interactor, f.GetByParams("deploy_fake_approve"))
+	o, err := interactor.model.Prepare(pj, "sandbox", DeployOption{})
+	require.NoError(t, err)
+	require.Equal(t, "sandbox", o.Branch)
+
+	interactor, ok = f.Get(pj, "staging").(InteractorGitOps)
+	require.True(t, ok)
+	require.Equal(t, "kustomize", interactor.kind)
+
+	model, err := NewDeployModelList(nil, nil, nil).Find("fake")
+	require.NoError(t, err)
+	require.IsType(t, ModelGitOps{}, model)
+}
+
+func TestLockKanvasClone(t *testing.T) {
+	unlock := lockKanvasClone("/var/gocat/kanvas/myapp/staging")
+
+	// The clone of another phase isn't locked
+	lockKanvasClone("/var/gocat/kanvas/myapp/production")()
+
+	locked := make(chan struct{})
+	go func() {
+		defer close(locked)
+		lockKanvasClone("/var/gocat/kanvas/myapp/staging")()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("the deploys of the same phase share the clone")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the clone isn't unlocked")
+	}
+}
+
+func TestGitOpsPluginKustomize_pushDirectly(t *testing.T) {
+	const name = "myapp/overlays/sandbox/kustomization.yaml"
+	seed := t.TempDir()
+	sr, err := git.PlainInit(seed, false)
+	require.NoError(t, err)
+	w, err := sr.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(seed, filepath.Dir(name)), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(seed, name), []byte("images:\n- name: myapp\n  newTag: aaaaaaa\n"), 0644))
+	_, err = w.Add(name)
+	require.NoError(t, err)
+	_, err = w.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+	// The default branch is pushed to like the bare repositories on GitHub
+	remote := t.TempDir()
+	r, err := git.PlainClone(remote, true, &git.CloneOptions{URL: seed})
+	require.NoError(t, err)
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	require.NoError(t, o.Clone())
+
+	// No pull request is opened
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected GitHub API call: %s %s", req.Method, req.URL)
+		return nil, errors.New("unexpected")
+	})}
+	github := &GitHub{client: *githubv4.NewClient(httpClient), httpClient: httpClient, org: "zaiminc", repo: "manifests", files: newGitHubFileCache()}
+	k := GitOpsPluginKustomize{github: github, git: &o}
+	phase := DeployPhase{Name: "sandbox", Path: name, CommitStrategy: CommitStrategyDirect}
+	images := []types.Image{{Name: "myapp", NewTag: "bbbbbbb"}}
+	metadata := DeployMetadata{Project: "myapp", Phase: "sandbox", Tag: "bbbbbbb"}
+
+	var gated []DeployMetadata
+	out, err := k.pushDirectly(phase, "bot/docker-image-tag-myapp-sandbox-bbbbbbb", images, "deploy", metadata, func(m DeployMetadata) error {
+		gated = append(gated, m)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []DeployMetadata{metadata}, gated)
+	require.True(t, out.Direct())
+	require.Equal(t, DeployStatusSuccess, out.Status())
+	require.Empty(t, out.PullRequestID)
+	master, err := r.Reference("refs/heads/master", true)
+	require.NoError(t, err)
+	require.Equal(t, master.Hash().String(), out.CommitSHA)
+	require.Equal(t, "https://github.com/zaiminc/manifests/commit/"+out.CommitSHA, out.CommitHTMLURL)
+
+	// The gate denies the deploy before anything is pushed
+	_, err = k.pushDirectly(phase, "bot/docker-image-tag-myapp-sandbox-ccccccc", []types.Image{{Name: "myapp", NewTag: "ccccccc"}}, "deploy", metadata, func(DeployMetadata) error {
+		return errors.New("denied")
+	})
+	require.EqualError(t, err, "denied")
+
+	// Nothing approves the direct commit, so it would bypass the two-person rule
+	phase.TwoPersonRule = true
+	out, err = k.pushDirectly(phase, "bot/docker-image-tag-myapp-sandbox-ccccccc", []types.Image{{Name: "myapp", NewTag: "ccccccc"}}, "deploy", metadata, nil)
+	require.EqualError(t, err, "commitStrategy direct can't be used with twoPersonRule for myapp sandbox")
+	require.Equal(t, DeployStatusFail, out.Status())
+	after, err := r.Reference("refs/heads/master", true)
+	require.NoError(t, err)
+	require.Equal(t, master.Hash(), after.Hash())
+}
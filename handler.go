@@ -9,6 +9,8 @@ import (
 	"strings"
 
 	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/zaiminc/gocat/deploy"
 )
 
 // interactionHandler is a http.Handler that can handle slack interaction callbacks.
@@ -19,6 +21,37 @@ type interactionHandler struct {
 	projectList       *ProjectList
 	userList          *UserList
 	interactorFactory *InteractorFactory
+	coordinator       *deploy.Coordinator
+	approvalReminder  *ApprovalReminder
+	// github is used to validate the projects added by the project add modal.
+	github *GitHub
+	// rollouts steps the Argo Rollouts by the buttons RolloutController.Follow posts.
+	rollouts  *RolloutController
+	recoverer *PanicRecoverer
+	// commands runs the commands of the buttons suggesting the project names.
+	commands *SlackListener
+	// workspaces routes the interactions to the workspaces they come from, when gocat is installed to more than one.
+	workspaces *SlackWorkspaces
+	// rollbacker opens the pull requests of the rollback modal.
+	rollbacker Rollbacker
+	// tracer finds the deploys the Cancel buttons of the queue command cancel, and the Retry buttons retry.
+	tracer *DeployTracer
+	// refresher reloads the projects the project add modal adds.
+	refresher *ListRefresher
+}
+
+// useWorkspace makes the handler respond in the workspace, by its bot to its users, with the projects available in it.
+// It's called on the copy of the handler for each interaction.
+func (h *interactionHandler) useWorkspace(ws *SlackWorkspace) {
+	h.client = ws.client
+	h.userList = ws.userList
+	h.interactorFactory = ws.interactorFactory
+	h.projectList = h.projectList.InWorkspace(ws.Name)
+	if h.commands != nil {
+		commands := *h.commands
+		commands.useWorkspace(ws)
+		h.commands = &commands
+	}
 }
 
 func getSlackError(system, msg string, user string) []byte {
@@ -53,6 +86,35 @@ func (h interactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write(responseBytes) // not display message on slack
 		return
 	}
+	defer h.recoverer.Recover("the button", interactionRequest.Container.ChannelID)
+	if h.workspaces.Multiple() {
+		ws, ok := h.workspaces.Authenticate(interactionRequest.Team.ID, interactionRequest.Token)
+		if !ok {
+			log.Printf("[ERROR] Invalid verification token for team %s", interactionRequest.Team.ID)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.useWorkspace(ws)
+	}
+
+	switch {
+	case interactionRequest.Type == slack.InteractionTypeWorkflowStepEdit && interactionRequest.CallbackID == workflowStepCallbackID:
+		if err := h.openWorkflowStepConfiguration(interactionRequest); err != nil {
+			log.Printf("[ERROR] Failed to open workflow step configuration: %s", err)
+		}
+		return
+	case interactionRequest.Type == slack.InteractionTypeViewSubmission && interactionRequest.View.CallbackID == workflowStepCallbackID:
+		if err := h.saveWorkflowStepConfiguration(interactionRequest); err != nil {
+			log.Printf("[ERROR] Failed to save workflow step configuration: %s", err)
+		}
+		return
+	case interactionRequest.Type == slack.InteractionTypeViewSubmission && interactionRequest.View.CallbackID == projectAddCallbackID:
+		h.submitProjectAdd(w, interactionRequest)
+		return
+	case interactionRequest.Type == slack.InteractionTypeViewSubmission && interactionRequest.View.CallbackID == rollbackCallbackID:
+		h.submitRollback(w, interactionRequest)
+		return
+	}
 
 	// Get the action from the request, it'll always be the first one provided in my case
 	var actionValue string
@@ -63,6 +125,30 @@ func (h interactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		actionValue = interactionRequest.ActionCallback.BlockActions[0].SelectedOption.Value
 	}
 	userID := interactionRequest.User.ID
+	if actionValue == projectAddActionValue {
+		if err := h.openProjectAdd(interactionRequest); err != nil {
+			log.Printf("[ERROR] Failed to open the project add modal: %s", err)
+		}
+		return
+	}
+	if strings.HasPrefix(actionValue, rollbackActionPrefix) {
+		if err := h.openRollback(interactionRequest, strings.TrimPrefix(actionValue, rollbackActionPrefix)); err != nil {
+			log.Printf("[ERROR] Failed to open the rollback modal: %s", err)
+		}
+		return
+	}
+	if strings.HasPrefix(actionValue, retryActionPrefix) {
+		h.retry(interactionRequest, strings.TrimPrefix(actionValue, retryActionPrefix))
+		return
+	}
+	if strings.HasPrefix(actionValue, queueActionPrefix) {
+		h.cancelQueued(interactionRequest, strings.TrimPrefix(actionValue, queueActionPrefix))
+		return
+	}
+	if strings.HasPrefix(actionValue, projectSuggestionActionPrefix) {
+		h.runSuggestion(interactionRequest, strings.TrimPrefix(actionValue, projectSuggestionActionPrefix))
+		return
+	}
 	// Handle close action
 	if strings.Contains(actionValue, "close") {
 
@@ -81,6 +167,10 @@ func (h interactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("[INFO] Action Value: %s", actionValue)
+	if strings.HasPrefix(actionValue, rolloutActionPrefix) {
+		h.rolloutAction(interactionRequest, actionValue)
+		return
+	}
 	if strings.HasPrefix(actionValue, "deploy") {
 		h.Deploy(w, interactionRequest)
 		return
@@ -110,6 +200,7 @@ func (h interactionHandler) Deploy(w http.ResponseWriter, interactionRequest sla
 		return
 	}
 	interactor := h.interactorFactory.GetByParams(params[0])
+	messageTS := originalMessageTS(interactionRequest)
 	var blocks []slack.Block
 	var err error
 	switch {
@@ -120,29 +211,151 @@ func (h interactionHandler) Deploy(w http.ResponseWriter, interactionRequest sla
 			break
 		}
 		pj := h.projectList.Find(p[0])
+		if err = checkDeployable(h.coordinator, pj.ID, p[1]); err != nil {
+			blocks, err = plainBlocks(err.Error()), nil
+			break
+		}
+		// Buttons can't take a reason, so the phases requiring one are deployed only via the deploy command
+		if err = pj.FindPhase(p[1]).ReasonPolicy.Validate(""); err != nil {
+			blocks, err = plainBlocks(err.Error()), nil
+			break
+		}
+		if r, ok := interactor.(InPlaceRequester); ok && messageTS != "" {
+			blocks, err = r.RequestInPlace(pj, p[1], pj.DefaultBranch(), userID, interactionRequest.Channel.ID, messageTS)
+			break
+		}
 		blocks, err = interactor.Request(pj, p[1], pj.DefaultBranch(), userID, interactionRequest.Channel.ID)
 	case strings.Contains(params[0], "approve"):
-		blocks, err = interactor.Approve(params[1], userID, interactionRequest.Channel.ID)
+		if err = checkEmergencyStop(h.coordinator); err != nil {
+			// Keep the approval message as is so that it can be approved after emergency-resume
+			h.postEphemeral(interactionRequest.ResponseURL, err.Error())
+			return
+		}
+		blocks, err = approveInPlace(interactor, params[1], userID, interactionRequest.Channel.ID, messageTS)
 	case strings.Contains(params[0], "reject"):
 		blocks, err = interactor.Reject(params[1], userID)
 	case strings.Contains(params[0], "selectbranch"):
-		blocks, err = interactor.SelectBranch(params[1], interactionRequest.ActionCallback.BlockActions[0].SelectedOption.Text.Text, userID, interactionRequest.Channel.ID)
+		// The options of the branch list are <project>_<phase>_<n>
+		p := strings.Split(params[1], "_")
+		if len(p) != 3 {
+			err = fmt.Errorf("Invalid Arguments")
+			break
+		}
+		if err = checkDeployable(h.coordinator, p[0], p[1]); err != nil {
+			blocks, err = plainBlocks(err.Error()), nil
+			break
+		}
+		if err = h.projectList.Find(p[0]).FindPhase(p[1]).ReasonPolicy.Validate(""); err != nil {
+			blocks, err = plainBlocks(err.Error()), nil
+			break
+		}
+		branch := interactionRequest.ActionCallback.BlockActions[0].SelectedOption.Text.Text
+		if r, ok := interactor.(InPlaceRequester); ok && messageTS != "" {
+			pj := h.projectList.Find(p[0])
+			blocks, err = r.RequestInPlace(pj, p[1], branch, userID, interactionRequest.Channel.ID, messageTS)
+			break
+		}
+		blocks, err = interactor.SelectBranch(params[1], branch, userID, interactionRequest.Channel.ID)
 	case strings.Contains(params[0], "branchlist"):
 		blocks, err = interactor.BranchListFromRaw(params[1])
 	default:
 		h.postInternalServerError(interactionRequest.ResponseURL, userID)
 		return
 	}
+	if isApprovalHeld(err) {
+		h.postEphemeral(interactionRequest.ResponseURL, err.Error())
+		return
+	}
 	if err != nil {
 		log.Print(err)
+		if errorCodeOf(err) != "" {
+			// The user can act on the error with the hint, unlike the other errors that only admins can look into
+			h.postEphemeral(interactionRequest.ResponseURL, describeError(err))
+			return
+		}
 		h.postInternalServerError(interactionRequest.ResponseURL, userID)
 		return
 	}
+	if err := h.replaceOriginal(interactionRequest, messageTS, blocks); err != nil {
+		log.Printf("[ERROR] Failed to post deploy action response: %v", err)
+		return
+	}
+	if strings.Contains(params[0], "request") {
+		// The response replaces the original message, which is now the approval message
+		p := strings.Split(params[1], "_")
+		h.approvalReminder.Track(h.client, p[0], p[1], interactionRequest.Container.ChannelID, interactionRequest.Container.MessageTs, blocks)
+	}
+}
+
+// retry runs the failed stage of the deploy again, and replaces the failure message the Retry button was clicked in with the result.
+func (h interactionHandler) retry(interactionRequest slack.InteractionCallback, id string) {
+	userID := interactionRequest.User.ID
+	if !h.userList.FindBySlackUserID(userID).IsDeveloper() {
+		h.postForbiddenError(interactionRequest.ResponseURL, userID)
+		return
+	}
+	blocks, err := retryDeploy(h.tracer, h.interactorFactory, h.coordinator, id, userID, interactionRequest.Channel.ID)
+	if err != nil {
+		log.Printf("[ERROR] Failed to retry the deploy %s: %s", id, err)
+		// Keep the failure message as is so that it can be retried again
+		h.postEphemeral(interactionRequest.ResponseURL, describeError(err))
+		return
+	}
+	if err := h.replaceOriginal(interactionRequest, originalMessageTS(interactionRequest), blocks); err != nil {
+		log.Printf("[ERROR] Failed to post retry action response: %v", err)
+	}
+}
+
+// runSuggestion runs the command with the suggested project name as if the user mentioned gocat with it.
+func (h interactionHandler) runSuggestion(interactionRequest slack.InteractionCallback, text string) {
+	if err := h.replaceOriginal(interactionRequest, originalMessageTS(interactionRequest), plainBlocks(fmt.Sprintf("Running `%s`", text))); err != nil {
+		log.Printf("[ERROR] Failed to post suggestion action response: %v", err)
+	}
+	if h.commands == nil {
+		return
+	}
+	if err := h.commands.handleMessageEvent(&slackevents.AppMentionEvent{
+		User:    interactionRequest.User.ID,
+		Text:    text,
+		Channel: interactionRequest.Container.ChannelID,
+	}); err != nil {
+		log.Printf("[ERROR] Failed to run the suggested command %q: %s", text, err)
+	}
+}
+
+// originalMessageTS returns the ts of the message the interaction happened in,
+// or an empty string if the message can't be updated by chat.update, like ephemeral messages.
+func originalMessageTS(interactionRequest slack.InteractionCallback) string {
+	if interactionRequest.Container.IsEphemeral {
+		return ""
+	}
+	return interactionRequest.Container.MessageTs
+}
+
+// replaceOriginal updates the message the interaction happened in with the blocks, so that each step of the flow,
+// from selecting the target and the branch to the final status, happens in a single message instead of flooding the channel.
+// It falls back to the response URL when the message can't be updated by chat.update.
+func (h interactionHandler) replaceOriginal(interactionRequest slack.InteractionCallback, messageTS string, blocks []slack.Block) error {
+	if messageTS != "" {
+		_, _, _, err := h.client.UpdateMessage(interactionRequest.Container.ChannelID, messageTS, slack.MsgOptionBlocks(blocks...))
+		if err == nil {
+			return nil
+		}
+		log.Printf("[WARNING] Failed to update the original message, falling back to the response URL: %s", err)
+	}
 	responseData := slack.NewBlockMessage(blocks...)
 	responseData.ReplaceOriginal = true
 	responseBytes, _ := json.Marshal(responseData)
-	if _, err := http.Post(interactionRequest.ResponseURL, "application/json", bytes.NewBuffer(responseBytes)); err != nil {
-		log.Printf("[ERROR] Failed to post deploy action response: %v", err)
+	_, err := http.Post(interactionRequest.ResponseURL, "application/json", bytes.NewBuffer(responseBytes))
+	return err
+}
+
+// postEphemeral responds with the text visible only to the user, without replacing the original message.
+func (h interactionHandler) postEphemeral(responseURL string, text string) {
+	response := slack.Message{Msg: slack.Msg{ResponseType: slack.ResponseTypeEphemeral, Text: text}}
+	responseBytes, _ := json.Marshal(response)
+	if _, err := http.Post(responseURL, "application/json", bytes.NewBuffer(responseBytes)); err != nil {
+		log.Printf("[ERROR] Failed to post ephemeral response: %v", err)
 	}
 }
 
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInPlaceSlack is the Slack API recording the messages posted and updated, which fails chat.update if updateFails.
+type fakeInPlaceSlack struct {
+	mu          sync.Mutex
+	updateFails bool
+	// calls are the methods called with their channels and timestamps, and the blocks, like chat.update C1 1.1.
+	calls  []string
+	blocks []string
+}
+
+func (s *fakeInPlaceSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case method == "chat.getPermalink":
+		// The pull request isn't linked to the thread, which would call GitHub
+		fmt.Fprint(w, `{"ok": false, "error": "message_not_found"}`)
+		return
+	case method == "chat.update" && s.updateFails:
+		fmt.Fprint(w, `{"ok": false, "error": "cant_update_message"}`)
+	default:
+		fmt.Fprint(w, `{"ok": true, "channel": "C1", "ts": "2.2"}`)
+	}
+	s.calls = append(s.calls, strings.TrimSpace(fmt.Sprintf("%s %s %s", method, r.Form.Get("channel"), r.Form.Get("ts"))))
+	s.blocks = append(s.blocks, r.Form.Get("blocks"))
+}
+
+func (s *fakeInPlaceSlack) take() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls, blocks := s.calls, s.blocks
+	s.calls, s.blocks = nil, nil
+	return calls, blocks
+}
+
+func TestInteractionHandler_DeployRequestInPlace(t *testing.T) {
+	RegisterGitOpsPlugin("inplace", func(github *GitHub, git *GitOperator) GitOpsPlugin {
+		return gitOpsPluginFunc(func(pj DeployProject, phase string, option DeployOption) (GitOpsPrepareOutput, error) {
+			return GitOpsPrepareOutput{PullRequestID: "PR_1", PullRequestNumber: 7, Branch: "bot/deploy", status: DeployStatusSuccess}, nil
+		})
+	})
+	defer delete(gitOpsPlugins, "inplace")
+
+	api := &fakeInPlaceSlack{}
+	slackServer := httptest.NewServer(api)
+	defer slackServer.Close()
+	var mu sync.Mutex
+	var responses []slack.Message
+	responseServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var m slack.Message
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(b, &m))
+		responses = append(responses, m)
+	}))
+	defer responseServer.Close()
+	takeResponses := func() []slack.Message {
+		mu.Lock()
+		defer mu.Unlock()
+		r := responses
+		responses = nil
+		return r
+	}
+
+	client := slack.New("xoxb-test", slack.OptionAPIURL(slackServer.URL+"/"))
+	userList := &UserList{Items: []User{{SlackUserID: "U1", isDeveloper: true}}}
+	projectList := &ProjectList{items: []DeployProject{{ID: "myapp", Kind: "inplace", Phases: []DeployPhase{{Name: "staging", Kind: "inplace"}}}}}
+	factory := NewInteractorFactory(InteractorContext{client: client, userList: userList, projectList: projectList})
+	h := interactionHandler{client: client, userList: userList, projectList: projectList, interactorFactory: &factory}
+	click := func(ephemeral bool) {
+		var callback slack.InteractionCallback
+		callback.User.ID = "U1"
+		callback.Channel.ID = "C1"
+		callback.Container = slack.Container{ChannelID: "C1", MessageTs: "1.1", IsEphemeral: ephemeral}
+		callback.ResponseURL = responseServer.URL
+		callback.ActionCallback.BlockActions = []*slack.BlockAction{{Value: "deploy_inplace_request|myapp_staging"}}
+		h.Deploy(httptest.NewRecorder(), callback)
+	}
+	// waitFor waits for the n calls to Slack, including the ones updating or posting the approval message in the background,
+	// and returns them with the blocks of the last one
+	waitFor := func(n int) ([]string, string) {
+		var calls, blocks []string
+		require.Eventually(t, func() bool {
+			c, b := api.take()
+			calls, blocks = append(calls, c...), append(blocks, b...)
+			return len(calls) >= n
+		}, 5*time.Second, 10*time.Millisecond)
+		return calls, blocks[len(blocks)-1]
+	}
+
+	// The message the button was clicked in becomes the approval message
+	click(false)
+	calls, blocks := waitFor(2)
+	require.Equal(t, []string{"chat.update C1 1.1", "chat.update C1 1.1"}, calls)
+	require.Contains(t, blocks, "deploy_inplace_approve|PR_1_7")
+	require.Empty(t, takeResponses())
+
+	// The response URL replaces the message chat.update can't update
+	api.mu.Lock()
+	api.updateFails = true
+	api.mu.Unlock()
+	click(false)
+	rs := takeResponses()
+	require.Len(t, rs, 1)
+	require.True(t, rs[0].ReplaceOriginal)
+	require.Contains(t, rs[0].Blocks.BlockSet[0].(*slack.SectionBlock).Text.Text, "Now creating pull request...")
+	calls, _ = waitFor(2)
+	require.Equal(t, []string{"chat.update C1 1.1", "chat.update C1 1.1"}, calls)
+	api.mu.Lock()
+	api.updateFails = false
+	api.mu.Unlock()
+
+	// The ephemeral messages can't be updated, so the response URL replaces them, and the approval message is posted anew
+	click(true)
+	rs = takeResponses()
+	require.Len(t, rs, 1)
+	require.True(t, rs[0].ReplaceOriginal)
+	require.Contains(t, rs[0].Blocks.BlockSet[0].(*slack.SectionBlock).Text.Text, "Now creating pull request...")
+	calls, blocks = waitFor(1)
+	require.Equal(t, []string{"chat.postMessage C1"}, calls)
+	require.Contains(t, blocks, "deploy_inplace_approve|PR_1_7")
+}
+
+// gitOpsPluginFunc is a GitOpsPlugin preparing the deploys by the function.
+type gitOpsPluginFunc func(pj DeployProject, phase string, option DeployOption) (GitOpsPrepareOutput, error)
+
+func (f gitOpsPluginFunc) Prepare(pj DeployProject, phase string, option DeployOption) (GitOpsPrepareOutput, error) {
+	return f(pj, phase, option)
+}
+
+func TestInteractionHandler_SelectBranchInvalidArguments(t *testing.T) {
+	var mu sync.Mutex
+	var responses []string
+	responseServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		responses = append(responses, string(b))
+	}))
+	defer responseServer.Close()
+
+	userList := &UserList{Items: []User{{SlackUserID: "U1", isDeveloper: true}}}
+	projectList := &ProjectList{items: []DeployProject{{ID: "myapp", Phases: []DeployPhase{{Name: "staging"}}}}}
+	factory := NewInteractorFactory(InteractorContext{userList: userList, projectList: projectList})
+	h := interactionHandler{userList: userList, projectList: projectList, interactorFactory: &factory}
+	for _, value := range []string{"deploy_kustomize_selectbranch|myapp", "deploy_kustomize_selectbranch|myapp_staging_0_1"} {
+		var callback slack.InteractionCallback
+		callback.User.ID = "U1"
+		callback.Channel.ID = "C1"
+		callback.ResponseURL = responseServer.URL
+		callback.ActionCallback.BlockActions = []*slack.BlockAction{{Value: value, SelectedOption: slack.OptionBlockObject{Text: &slack.TextBlockObject{Text: "master"}}}}
+		require.NotPanics(t, func() { h.Deploy(httptest.NewRecorder(), callback) }, value)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, responses, 2)
+	for _, r := range responses {
+		require.Contains(t, r, "Internal Server Error")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// deployHarness drives the end-to-end deploy scenarios against gocat wired up as main does,
+// talking to the fakes of the dev environment: DevSlack as the source of the events and the clicks,
+// DevGitHub on top of the local bare manifest repository, DevRegistry as ECR, and the fake cluster.
+//
+// The regression tests of the phases write their projects and manifests with AddProject and CommitManifest,
+// push the images with PushImage, and then mention gocat and click the buttons as the dev user would:
+//
+//	h := newDeployHarness(t)
+//	h.CommitManifest("api/overlays/staging/kustomization.yaml", kustomization)
+//	h.AddProject("api", map[string]string{"Kind": "kustomize", ...})
+//	h.PushImage("api", "master", "1a2b3c4")
+//	h.Click(h.Deploy("deploy api staging"), "Deploy")
+//	h.WaitForMessage("merged")
+type deployHarness struct {
+	t      *testing.T
+	env    *DevEnvironment
+	config *CatConfig
+	// timeout is how long the waits wait for gocat, which handles the commands and the clicks in the background.
+	timeout time.Duration
+}
+
+const harnessDefaultBranch = "refs/heads/master"
+
+// newDeployHarness starts gocat with the dev environment, which has the project myapp and the image myapp:master.
+func newDeployHarness(t *testing.T) *deployHarness {
+	t.Helper()
+	env, err := NewDevEnvironment("127.0.0.1:0", harnessDefaultBranch, "myapp=master,1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b", io.Discard)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = env.Close() })
+
+	for k, v := range map[string]string{
+		"SECRET_STORE":                    "",
+		"GOCAT_GITROOT":                   "",
+		"CONFIG_MANIFEST_REPOSITORY":      env.Remote,
+		"CONFIG_GITHUB_DEFAULT_BRANCH":    harnessDefaultBranch,
+		"CONFIG_GITHUB_ACCESS_TOKEN":      "dev",
+		"CONFIG_SLACK_OAUTH_TOKEN":        "dev",
+		"CONFIG_SLACK_VERIFICATION_TOKEN": "dev",
+		"CONFIG_LIST_REFRESH_INTERVAL":    "0",
+	} {
+		t.Setenv(k, v)
+	}
+	config, err := InitConfig()
+	require.NoError(t, err)
+	_, err = newBot(config, env)
+	require.NoError(t, err)
+	return &deployHarness{t: t, env: env, config: config, timeout: 10 * time.Second}
+}
+
+// AddProject adds the project configmap of the name, or replaces it, and reloads the projects.
+func (h *deployHarness) AddProject(name string, data map[string]string) {
+	h.t.Helper()
+	cm := &v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: configNamespace(), Labels: map[string]string{configMapTypeLabel: "project"}},
+		Data:       data,
+	}
+	configMaps := h.env.Kubernetes.CoreV1().ConfigMaps(configNamespace())
+	if _, err := configMaps.Update(context.Background(), cm, meta_v1.UpdateOptions{}); err != nil {
+		_, err = configMaps.Create(context.Background(), cm, meta_v1.CreateOptions{})
+		require.NoError(h.t, err)
+	}
+	h.Mention("reload")
+	h.WaitForMessage("Reloaded")
+}
+
+// CommitManifest commits the file to the default branch of the manifest repository.
+func (h *deployHarness) CommitManifest(path, content string) {
+	h.t.Helper()
+	fs := memfs.New()
+	r, err := git.Clone(memory.NewStorage(), fs, &git.CloneOptions{URL: h.env.Remote, ReferenceName: harnessDefaultBranch})
+	require.NoError(h.t, err)
+	w, err := r.Worktree()
+	require.NoError(h.t, err)
+	require.NoError(h.t, util.WriteFile(fs, path, []byte(content), 0644))
+	_, err = w.Add(path)
+	require.NoError(h.t, err)
+	_, err = w.Commit("Update "+path, &git.CommitOptions{Author: &object.Signature{Name: devUserName, When: time.Now()}})
+	require.NoError(h.t, err)
+	refSpec := gitconfig.RefSpec(fmt.Sprintf("%s:%s", harnessDefaultBranch, harnessDefaultBranch))
+	require.NoError(h.t, r.Push(&git.PushOptions{RefSpecs: []gitconfig.RefSpec{refSpec}}))
+}
+
+// Manifest returns the content of the file on the default branch of the manifest repository.
+func (h *deployHarness) Manifest(path string) string {
+	h.t.Helper()
+	r, err := git.PlainOpen(h.env.Remote)
+	require.NoError(h.t, err)
+	ref, err := r.Reference(plumbing.ReferenceName(harnessDefaultBranch), true)
+	require.NoError(h.t, err)
+	commit, err := r.CommitObject(ref.Hash())
+	require.NoError(h.t, err)
+	file, err := commit.File(path)
+	require.NoError(h.t, err)
+	content, err := file.Contents()
+	require.NoError(h.t, err)
+	return content
+}
+
+// PushImage pushes the image tagged with the tags to the repository of the fake ECR.
+func (h *deployHarness) PushImage(repo string, tags ...string) {
+	h.env.Registry.Push(repo, tags...)
+}
+
+// Mention sends the command to gocat as the mention of the dev user.
+func (h *deployHarness) Mention(command string) {
+	h.env.Slack.Mention(command)
+}
+
+// Deploy mentions gocat with the deploy command, and returns the message asking to approve the deploy.
+func (h *deployHarness) Deploy(command string) devRenderedMessage {
+	h.t.Helper()
+	h.Mention(command)
+	return h.WaitFor("the deploy request of "+command, func(m devRenderedMessage) bool {
+		return m.TS > h.lastMention() && findDevChoice(m, "Deploy") != nil
+	})
+}
+
+// Click clicks the button, or the option of the select, labeled label in the message.
+func (h *deployHarness) Click(m devRenderedMessage, label string) {
+	h.t.Helper()
+	choice := findDevChoice(m, label)
+	require.NotNil(h.t, choice, "no %s in the message %s", label, strings.Join(m.Lines, "\n"))
+	require.NoError(h.t, h.env.Slack.Click(choice.Number))
+}
+
+// WaitForMessage waits for gocat to post, or update, the message containing text after the last mention, and returns it.
+func (h *deployHarness) WaitForMessage(text string) devRenderedMessage {
+	h.t.Helper()
+	since := h.lastMention()
+	return h.WaitFor(fmt.Sprintf("the message containing %q", text), func(m devRenderedMessage) bool {
+		return m.TS > since && strings.Contains(strings.Join(m.Lines, "\n"), text)
+	})
+}
+
+// WaitFor waits for the message of gocat matching the condition described by what.
+func (h *deployHarness) WaitFor(what string, match func(devRenderedMessage) bool) devRenderedMessage {
+	h.t.Helper()
+	deadline := time.Now().Add(h.timeout)
+	for {
+		messages := h.env.Slack.Messages()
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].User == "gocat" && match(messages[i]) {
+				return messages[i]
+			}
+		}
+		if time.Now().After(deadline) {
+			var got []string
+			for _, m := range messages {
+				got = append(got, fmt.Sprintf("[%s] %s: %s", m.TS, m.User, strings.Join(m.Lines, " / ")))
+			}
+			require.FailNow(h.t, "timed out waiting for "+what, "messages:\n%s", strings.Join(got, "\n"))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// lastMention returns the timestamp of the last mention of the dev user.
+func (h *deployHarness) lastMention() string {
+	var ts string
+	for _, m := range h.env.Slack.Messages() {
+		if m.User == devUserName {
+			ts = m.TS
+		}
+	}
+	return ts
+}
+
+func findDevChoice(m devRenderedMessage, label string) *devChoice {
+	for i := range m.Choices {
+		if m.Choices[i].Label == label {
+			return &m.Choices[i]
+		}
+	}
+	return nil
+}
+
+func TestHarness_DeployKustomize(t *testing.T) {
+	h := newDeployHarness(t)
+
+	h.Click(h.Deploy("deploy myapp staging"), "Deploy")
+	h.WaitForMessage("merged")
+	require.Contains(t, h.Manifest("myapp/overlays/staging/kustomization.yaml"), "newTag: 1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b")
+	require.Contains(t, h.Manifest("myapp/overlays/production/kustomization.yaml"), "newTag: "+devSeedTag)
+
+	// The next image pushed to the branch is deployed next
+	h.PushImage("myapp", "master", "5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e")
+	h.Click(h.Deploy("deploy myapp staging"), "Deploy")
+	h.WaitForMessage("merged")
+	require.Contains(t, h.Manifest("myapp/overlays/staging/kustomization.yaml"), "newTag: 5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e")
+}
+
+func TestHarness_CustomPhase(t *testing.T) {
+	h := newDeployHarness(t)
+
+	// The phase deploys the semantic versions instead of the commits of the branch
+	h.CommitManifest("api/overlays/staging/kustomization.yaml", "kind: Kustomization\nimages:\n- name: "+devRegistry+"/api\n  newTag: v1.0.0\n")
+	h.AddProject("api", map[string]string{
+		"Kind":           "kustomize",
+		"Alias":          "api",
+		"DockerRegistry": devRegistry + "/api",
+		"FilterRegexp":   `^v[0-9.]+$`,
+		"TargetRegexp":   `^v[0-9.]+$`,
+		"Phases":         "- name: staging\n  path: api/overlays/staging/kustomization.yaml\n",
+	})
+	h.PushImage("api", "v1.0.0")
+	h.PushImage("api", "v1.1.0")
+
+	h.Click(h.Deploy("deploy api staging"), "Deploy")
+	h.WaitForMessage("merged")
+	require.Contains(t, h.Manifest("api/overlays/staging/kustomization.yaml"), "newTag: v1.1.0")
+}
+
+func TestHarness_SimulateAutoDeploy(t *testing.T) {
+	h := newDeployHarness(t)
+
+	h.Mention("simulate autodeploy myapp staging")
+	m := h.WaitForMessage("Simulated AutoDeploy of myapp staging")
+	require.Contains(t, strings.Join(m.Lines, "\n"), "Decision: would deploy `1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b` over `"+devSeedTag+"`")
+	require.Contains(t, h.Manifest("myapp/overlays/staging/kustomization.yaml"), "newTag: "+devSeedTag)
+
+	h.Click(h.Deploy("deploy myapp staging"), "Deploy")
+	h.WaitForMessage("merged")
+	h.Mention("simulate autodeploy myapp staging")
+	m = h.WaitForMessage("Simulated AutoDeploy of myapp staging")
+	require.Contains(t, strings.Join(m.Lines, "\n"), "Decision: skipped as already deployed")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/slackcmd"
+)
+
+// historyLimit is the number of the latest deploys the history command lists.
+const historyLimit = 10
+
+// history lists the latest deploys of the phase of the project, which are the ones traced since gocat started.
+func (s *SlackListener) history(c *slackcmd.History) ([]slack.Block, error) {
+	pj, err := s.projectList.FindByAlias(c.Project)
+	if err != nil {
+		return nil, err
+	}
+	phase := s.toPhase(c.Env)
+	if pj.FindPhase(phase).Name == "" {
+		return nil, fmt.Errorf("phase %s not found for project %s", phase, pj.ID)
+	}
+	traces := s.tracer.History(pj.ID, phase, historyLimit)
+	if len(traces) == 0 {
+		return plainBlocks(fmt.Sprintf("No deploys of *%s* *%s* since gocat started", pj.ID, phase)), nil
+	}
+	lines := []string{fmt.Sprintf("*The latest deploys of %s %s*", pj.ID, phase)}
+	calendar := pj.Calendar()
+	for _, trace := range traces {
+		var at, summary string
+		if len(trace.Events) > 0 {
+			at = calendar.Format(trace.Events[0].At, "2006-01-02 15:04")
+			summary = trace.Events[0].Text
+		}
+		lines = append(lines, fmt.Sprintf("`%s` %s *%s* %s", trace.ID, at, strings.ReplaceAll(string(trace.State), "_", " "), summary))
+	}
+	lines = append(lines, "Run `trace <id>` for the timeline of each.")
+	return plainBlocks(strings.Join(lines, "\n")), nil
+}
+
+// diff compares the tags deployed to the two phases of the project, with the link to the commits between them.
+func (s *SlackListener) diff(c *slackcmd.Diff) ([]slack.Block, error) {
+	pj, err := s.projectList.FindByAlias(c.Project)
+	if err != nil {
+		return nil, err
+	}
+	from, to := s.toPhase(c.From), s.toPhase(c.To)
+	var tags []string
+	for _, phase := range []string{from, to} {
+		ph := pj.FindPhase(phase)
+		if ph.Name == "" {
+			return nil, fmt.Errorf("phase %s not found for project %s", phase, pj.ID)
+		}
+		tag, err := ph.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: s.github})
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the tag deployed to %s: %w", phase, err)
+		}
+		tags = append(tags, tag)
+	}
+	text := fmt.Sprintf("*%s*\n*%s* `%s`\n*%s* `%s`", pj.ID, from, tags[0], to, tags[1])
+	if tags[0] == tags[1] {
+		text += "\nThe same tag is deployed."
+	} else if url := changelogURL(s.github.org, pj.GitHubRepository(), tags[0], tags[1]); url != "" {
+		text += "\n" + url
+	}
+	return plainBlocks(text), nil
+}
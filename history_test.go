@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployTracer_History(t *testing.T) {
+	tracer := NewDeployTracer()
+	first := tracer.Start("myapp", "production", "requested v1")
+	tracer.Start("myapp", "staging", "requested v2")
+	second := tracer.Start("myapp", "production", "requested v3")
+	third := tracer.Start("myapp", "production", "requested v4")
+
+	// The latest come first, up to the limit
+	traces := tracer.History("myapp", "production", 2)
+	require.Len(t, traces, 2)
+	require.Equal(t, third, traces[0].ID)
+	require.Equal(t, second, traces[1].ID)
+	traces = tracer.History("myapp", "production", historyLimit)
+	require.Len(t, traces, 3)
+	require.Equal(t, first, traces[2].ID)
+	require.Empty(t, tracer.History("other", "production", historyLimit))
+
+	var nilTracer *DeployTracer
+	require.Empty(t, nilTracer.History("myapp", "production", historyLimit))
+}
+
+func TestSlackListener_Viewer(t *testing.T) {
+	s := SlackListener{userList: &UserList{Items: []User{
+		{SlackUserID: "UVIEWER", isViewer: true},
+		{SlackUserID: "UDEV", isDeveloper: true},
+		{SlackUserID: "UBOTH", isViewer: true, isDeveloper: true},
+	}}}
+
+	// Anyone can read unless restricted, but the viewers can't deploy
+	require.NoError(t, s.checkViewer("UNKNOWN"))
+	require.NoError(t, s.checkRequester("UNKNOWN"))
+	require.Error(t, s.checkRequester("UVIEWER"))
+	require.NoError(t, s.checkRequester("UDEV"))
+	require.NoError(t, s.checkRequester("UBOTH"))
+
+	s.restrictReadCommands = true
+	require.Error(t, s.checkViewer("UNKNOWN"))
+	require.Error(t, s.checkRequester("UNKNOWN"))
+	require.NoError(t, s.checkViewer("UVIEWER"))
+	require.Error(t, s.checkRequester("UVIEWER"))
+	require.NoError(t, s.checkViewer("UDEV"))
+	require.NoError(t, s.checkRequester("UDEV"))
+}
+
+func TestChangelogURL(t *testing.T) {
+	require.Equal(t, "https://github.com/zaiminc/myapp/compare/abc1234...def5678", changelogURL("zaiminc", "myapp", "master-abc1234", "master-def5678"))
+	require.Empty(t, changelogURL("", "myapp", "master-abc1234", "master-def5678"))
+	require.Empty(t, changelogURL("zaiminc", "myapp", "v1.0.0", "master-def5678"))
+}
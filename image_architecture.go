@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// imageManifestMediaTypes are the media types of the manifests gocat reads the architectures of the images from,
+// which are the manifest lists and the image indexes of the multi-architecture images, and the manifests of the others.
+var imageManifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// imageManifest is the subset of the manifest, or the manifest list, of an image.
+type imageManifest struct {
+	Manifests []struct {
+		Platform struct {
+			Architecture string `json:"architecture"`
+		} `json:"platform"`
+	} `json:"manifests"`
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+// architectures returns the architectures of the manifest list, or false if it's the manifest of a single image,
+// whose architecture is in its config instead.
+func (m imageManifest) architectures() ([]string, bool) {
+	if len(m.Manifests) == 0 {
+		return nil, false
+	}
+	var archs []string
+	for _, manifest := range m.Manifests {
+		// The attestations, like the provenance of docker buildx, are listed as unknown
+		if arch := manifest.Platform.Architecture; arch != "" && arch != "unknown" {
+			archs = append(archs, arch)
+		}
+	}
+	return archs, true
+}
+
+// ImageArchitectureFinder finds the architectures the image is built for.
+type ImageArchitectureFinder interface {
+	ImageArchitectures(q ImageTagQuery, tag string) ([]string, error)
+}
+
+// ImageArchitectures returns the architectures the image tagged with tag in the repository of the query is built for.
+// It needs ecr:BatchGetImage, and ecr:GetDownloadUrlForLayer for the single-architecture images.
+func (e ECRClient) ImageArchitectures(q ImageTagQuery, tag string) ([]string, error) {
+	registryID, repo := q.registryID(), q.repository()
+	output, err := e.client.BatchGetImage(&ecr.BatchGetImageInput{
+		RegistryId:         &registryID,
+		RepositoryName:     &repo,
+		ImageIds:           []*ecr.ImageIdentifier{{ImageTag: aws.String(tag)}},
+		AcceptedMediaTypes: aws.StringSlice(imageManifestMediaTypes),
+	})
+	if err != nil {
+		return nil, registryError(err)
+	}
+	if len(output.Images) == 0 {
+		return nil, withCode(ErrCodeImageTagNotFound, fmt.Errorf("image %s:%s not found", repo, tag))
+	}
+	var manifest imageManifest
+	if err := json.Unmarshal([]byte(aws.StringValue(output.Images[0].ImageManifest)), &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s:%s: %w", repo, tag, err)
+	}
+	if archs, ok := manifest.architectures(); ok {
+		return archs, nil
+	}
+
+	layer, err := e.client.GetDownloadUrlForLayer(&ecr.GetDownloadUrlForLayerInput{
+		RegistryId:     &registryID,
+		RepositoryName: &repo,
+		LayerDigest:    aws.String(manifest.Config.Digest),
+	})
+	if err != nil {
+		return nil, registryError(err)
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(aws.StringValue(layer.DownloadUrl))
+	if err != nil {
+		return nil, withCode(ErrCodeRegistry, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, withCode(ErrCodeRegistry, fmt.Errorf("unable to download the config of %s:%s: %s", repo, tag, resp.Status))
+	}
+	var config struct {
+		Architecture string `json:"architecture"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid config of %s:%s: %w", repo, tag, err)
+	}
+	return []string{config.Architecture}, nil
+}
+
+// checkImageArchitectures checks all the images are built for the architectures of the phase, if any,
+// so that the deploy fails before the pods fail to pull the images on the nodes of the missing architectures.
+// The images are in the same order as the queries.
+func checkImageArchitectures(finder ImageArchitectureFinder, ph DeployPhase, queries []ImageTagQuery, images []types.Image) error {
+	if len(ph.Architectures) == 0 {
+		return nil
+	}
+	for i, image := range images {
+		archs, err := finder.ImageArchitectures(queries[i], image.NewTag)
+		if err != nil {
+			return err
+		}
+		built := map[string]bool{}
+		for _, arch := range archs {
+			built[arch] = true
+		}
+		var missing []string
+		for _, required := range ph.Architectures {
+			if !built[required] {
+				missing = append(missing, required)
+			}
+		}
+		if len(missing) > 0 {
+			return withCode(ErrCodeImageArchitecture, fmt.Errorf("%s:%s isn't built for %s, only for %s", image.Name, image.NewTag, strings.Join(missing, ", "), strings.Join(archs, ", ")))
+		}
+	}
+	return nil
+}
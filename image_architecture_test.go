@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+type fakeImageArchitectureFinder map[string][]string
+
+func (f fakeImageArchitectureFinder) ImageArchitectures(q ImageTagQuery, tag string) ([]string, error) {
+	return f[q.Image+":"+tag], nil
+}
+
+func TestImageManifest_architectures(t *testing.T) {
+	var index imageManifest
+	require.NoError(t, json.Unmarshal([]byte(`{
+  "mediaType": "application/vnd.oci.image.index.v1+json",
+  "manifests": [
+    {"digest": "sha256:aaa", "platform": {"architecture": "amd64", "os": "linux"}},
+    {"digest": "sha256:bbb", "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}},
+    {"digest": "sha256:ccc", "platform": {"architecture": "unknown", "os": "unknown"}}
+  ]
+}`), &index))
+	archs, ok := index.architectures()
+	require.True(t, ok)
+	require.Equal(t, []string{"amd64", "arm64"}, archs)
+
+	var single imageManifest
+	require.NoError(t, json.Unmarshal([]byte(`{
+  "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+  "config": {"digest": "sha256:ddd"},
+  "layers": [{"digest": "sha256:eee"}]
+}`), &single))
+	_, ok = single.architectures()
+	require.False(t, ok)
+	require.Equal(t, "sha256:ddd", single.Config.Digest)
+}
+
+func TestCheckImageArchitectures(t *testing.T) {
+	finder := fakeImageArchitectureFinder{
+		"registry/api:abc":    {"amd64", "arm64"},
+		"registry/worker:abc": {"amd64"},
+	}
+	queries := []ImageTagQuery{{Image: "registry/api"}, {Image: "registry/worker"}}
+	images := []types.Image{{Name: "registry/api", NewTag: "abc"}, {Name: "registry/worker", NewTag: "abc"}}
+
+	require.NoError(t, checkImageArchitectures(finder, DeployPhase{}, queries, images))
+	require.NoError(t, checkImageArchitectures(finder, DeployPhase{Architectures: []string{"amd64"}}, queries, images))
+	require.NoError(t, checkImageArchitectures(finder, DeployPhase{Architectures: []string{"amd64", "arm64"}}, queries[:1], images[:1]))
+
+	err := checkImageArchitectures(finder, DeployPhase{Architectures: []string{"amd64", "arm64"}}, queries, images)
+	require.EqualError(t, err, "registry/worker:abc isn't built for arm64, only for amd64")
+	require.Equal(t, ErrCodeImageArchitecture, errorCodeOf(err))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// ImagePromotion copies the images deployed to the phase from the registry they are built into, like the staging one,
+// to the production registry before the overlay is edited, for the orgs promoting the images between the registries:
+//
+//	promotion:
+//	  registry: 210987654321.dkr.ecr.ap-northeast-1.amazonaws.com
+//
+// The images keep their repositories and tags, so the overlay of the phase is expected to point the images to the registry with newName.
+// It's supported by the kustomize kind only.
+type ImagePromotion struct {
+	// Registry is the registry the images are copied to, which replaces the registry of the images,
+	// like 210987654321.dkr.ecr.ap-northeast-1.amazonaws.com for 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp.
+	Registry string `yaml:"registry"`
+}
+
+func (p ImagePromotion) Enabled() bool {
+	return p.Registry != ""
+}
+
+func (p ImagePromotion) validate() error {
+	if strings.Contains(p.Registry, "://") || strings.HasSuffix(p.Registry, "/") {
+		return fmt.Errorf("registry must be a host like 210987654321.dkr.ecr.ap-northeast-1.amazonaws.com, not %q", p.Registry)
+	}
+	return nil
+}
+
+// destination returns the name of the image in the registry the image is promoted to.
+// The names with no registry, like myapp or team/myapp of Docker Hub, keep their whole paths.
+func (p ImagePromotion) destination(name string) string {
+	if i := strings.Index(name, "/"); i >= 0 && isRegistryHost(name[:i]) {
+		name = name[i+1:]
+	}
+	return p.Registry + "/" + name
+}
+
+// isRegistryHost returns true if the first part of the name of an image is the host of the registry, as Docker tells it apart.
+func isRegistryHost(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
+
+// cosignTagSuffixes are the suffixes of the tags cosign attaches the signatures, the attestations, and the SBOMs of an image with,
+// which are tagged as sha256-<digest>.<suffix> in the repository of the image.
+var cosignTagSuffixes = []string{".sig", ".att", ".sbom"}
+
+// ImageCopier copies the images between the registries.
+type ImageCopier interface {
+	Copy(src, dst string) error
+	// Digest returns the digest the tag of the image points to, or an error if the tag isn't found.
+	Digest(image string) (string, error)
+}
+
+// craneCLI is the ImageCopier that runs the crane command, which needs to be installed in the gocat container image.
+// The registry credentials are read as crane itself does, like the docker config with the ECR credential helper.
+// The manifests are copied as is, so the digests of the images stay the same across the registries.
+type craneCLI struct {
+	// Command is the path to the crane command. Defaults to "crane".
+	Command string
+}
+
+func (c craneCLI) Copy(src, dst string) error {
+	_, err := c.run("copy", src, dst)
+	return err
+}
+
+// Digest returns the digest the tag of the image points to in the registry.
+func (c craneCLI) Digest(image string) (string, error) {
+	out, err := c.run("digest", image)
+	if err != nil {
+		return "", err
+	}
+	digest := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("command crane digest returned %q for %s", digest, image)
+	}
+	return digest, nil
+}
+
+func (c craneCLI) run(args ...string) ([]byte, error) {
+	bin := c.Command
+	if bin == "" {
+		bin = "crane"
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Env = commandEnv()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, withCode(ErrCodePluginNotInstalled, fmt.Errorf("command crane: %w", err))
+		}
+		return nil, fmt.Errorf("command crane %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// promoteImages copies the images to the registry of the promotion of the phase, if any.
+//
+// The images are copied by their digests, which are resolved if unknown, as the tags may be pushed again while they're promoted.
+// The signatures, the attestations, and the SBOMs cosign attached to them are copied along,
+// so that the images can be verified in the registry they're promoted to as well.
+func promoteImages(copier ImageCopier, ph DeployPhase, images []types.Image) error {
+	if !ph.Promotion.Enabled() {
+		return nil
+	}
+	for _, image := range images {
+		digest := image.Digest
+		if digest == "" {
+			var err error
+			if digest, err = copier.Digest(imageReference(image)); err != nil {
+				return fmt.Errorf("unable to resolve the digest of %s to promote: %w", imageReference(image), err)
+			}
+		}
+		repo := ph.Promotion.destination(image.Name)
+		src := image.Name + "@" + digest
+		dst := repo + ":" + image.NewTag
+		if err := copier.Copy(src, dst); err != nil {
+			return fmt.Errorf("unable to promote %s to %s: %w", src, dst, err)
+		}
+		log.Printf("[INFO] Promoted %s to %s", src, dst)
+
+		for _, suffix := range cosignTagSuffixes {
+			tag := strings.Replace(digest, ":", "-", 1) + suffix
+			if _, err := copier.Digest(image.Name + ":" + tag); err != nil {
+				// The image has nothing of the kind attached
+				continue
+			}
+			if err := copier.Copy(image.Name+":"+tag, repo+":"+tag); err != nil {
+				return fmt.Errorf("unable to promote %s:%s to %s: %w", image.Name, tag, repo, err)
+			}
+			log.Printf("[INFO] Promoted %s:%s to %s", image.Name, tag, repo)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+type fakeImageCopier struct {
+	copied []string
+	failed map[string]bool
+	// tags are the digests of the tags in the registries.
+	tags map[string]string
+}
+
+func (c *fakeImageCopier) Copy(src, dst string) error {
+	if c.failed[src] {
+		return fmt.Errorf("MANIFEST_UNKNOWN")
+	}
+	c.copied = append(c.copied, src+" "+dst)
+	return nil
+}
+
+func (c *fakeImageCopier) Digest(image string) (string, error) {
+	digest, ok := c.tags[image]
+	if !ok {
+		return "", fmt.Errorf("MANIFEST_UNKNOWN")
+	}
+	return digest, nil
+}
+
+func TestPromoteImages(t *testing.T) {
+	const src = "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com"
+	const dst = "210987654321.dkr.ecr.ap-northeast-1.amazonaws.com"
+	images := []types.Image{
+		{Name: src + "/myapp", NewTag: "abc"},
+		{Name: src + "/team/worker", NewTag: "abc", Digest: "sha256:0123"},
+	}
+	tags := map[string]string{
+		src + "/myapp:abc":                   "sha256:4567",
+		src + "/team/worker:sha256-0123.sig": "sha256:89ab",
+		src + "/team/worker:sha256-0123.att": "sha256:cdef",
+		src + "/myapp:sha256-9999.sig":       "sha256:0000",
+	}
+
+	copier := &fakeImageCopier{tags: tags}
+	require.NoError(t, promoteImages(copier, DeployPhase{}, images))
+	require.Empty(t, copier.copied)
+
+	// The images are copied by their digests along with the signatures and the attestations attached to them
+	ph := DeployPhase{Promotion: ImagePromotion{Registry: dst}}
+	require.NoError(t, ph.Promotion.validate())
+	require.NoError(t, promoteImages(copier, ph, images))
+	require.Equal(t, []string{
+		src + "/myapp@sha256:4567 " + dst + "/myapp:abc",
+		src + "/team/worker@sha256:0123 " + dst + "/team/worker:abc",
+		src + "/team/worker:sha256-0123.sig " + dst + "/team/worker:sha256-0123.sig",
+		src + "/team/worker:sha256-0123.att " + dst + "/team/worker:sha256-0123.att",
+	}, copier.copied)
+
+	copier = &fakeImageCopier{tags: tags, failed: map[string]bool{src + "/myapp@sha256:4567": true}}
+	require.EqualError(t, promoteImages(copier, ph, images), "unable to promote "+src+"/myapp@sha256:4567 to "+dst+"/myapp:abc: MANIFEST_UNKNOWN")
+	require.Empty(t, copier.copied)
+
+	// The tags are never copied as they are when their digests are unknown
+	copier = &fakeImageCopier{tags: map[string]string{}}
+	require.EqualError(t, promoteImages(copier, ph, images[:1]), "unable to resolve the digest of "+src+"/myapp:abc to promote: MANIFEST_UNKNOWN")
+	require.Empty(t, copier.copied)
+
+	require.Error(t, ImagePromotion{Registry: "https://registry.example.com"}.validate())
+}
+
+func TestImagePromotion_destination(t *testing.T) {
+	p := ImagePromotion{Registry: "registry.example.com"}
+	require.Equal(t, "registry.example.com/team/myapp", p.destination("123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/team/myapp"))
+	require.Equal(t, "registry.example.com/myapp", p.destination("localhost:5000/myapp"))
+	require.Equal(t, "registry.example.com/myapp", p.destination("myapp"))
+	require.Equal(t, "registry.example.com/team/myapp", p.destination("team/myapp"))
+}
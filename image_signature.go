@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// ImageSignaturePolicy requires the images deployed to the phase to be signed with cosign,
+// or to have the in-toto attestation of the type, before the deploy is prepared.
+// The signatures are verified for the digests, and the images are deployed pinned to the digests verified.
+// It's supported only by imageSignatureKinds.
+type ImageSignaturePolicy struct {
+	// Mode is either warn, which notes the images failing the verification in the deploy pull request,
+	// or block, which fails the deploy. The images aren't verified if empty.
+	Mode string `yaml:"mode"`
+	// Key is the public key the images are signed with, like cosign.pub in the gocat container image or awskms:///alias/cosign.
+	// The images are verified keyless with CertificateIdentity and CertificateOIDCIssuer if empty.
+	Key string `yaml:"key"`
+	// CertificateIdentity is the regexp of the identity of the keyless signatures,
+	// like https://github.com/zaiminc/myapp/.github/workflows/build.yaml@refs/heads/.*.
+	CertificateIdentity string `yaml:"certificateIdentity"`
+	// CertificateOIDCIssuer is the OIDC issuer of the keyless signatures, like https://token.actions.githubusercontent.com.
+	CertificateOIDCIssuer string `yaml:"certificateOIDCIssuer"`
+	// AttestationType is the type of the in-toto attestation to verify instead of the signature, like slsaprovenance.
+	AttestationType string `yaml:"attestationType"`
+}
+
+func (p ImageSignaturePolicy) Enabled() bool {
+	return p.Mode != ""
+}
+
+// imageSignatureKinds are the kinds of the phases supporting imageSignature, which verify the images as they prepare the deploys.
+var imageSignatureKinds = []string{"kustomize"}
+
+func (p ImageSignaturePolicy) validate(kind string) error {
+	switch p.Mode {
+	case "":
+		return nil
+	case "warn", "block":
+	default:
+		return fmt.Errorf("unknown mode %q. It's either warn or block", p.Mode)
+	}
+	// The images of the other kinds would be deployed without being verified
+	if !containsString(imageSignatureKinds, kind) {
+		return fmt.Errorf("kind %s doesn't verify the images. imageSignature is supported only by %s", kind, strings.Join(imageSignatureKinds, ", "))
+	}
+	if !p.hasIdentity() {
+		return fmt.Errorf("either key, or certificateIdentity and certificateOIDCIssuer are required")
+	}
+	return nil
+}
+
+// hasIdentity returns true if the policy has the key or the identity the signatures and the attestations are verified with.
+func (p ImageSignaturePolicy) hasIdentity() bool {
+	return p.Key != "" || (p.CertificateIdentity != "" && p.CertificateOIDCIssuer != "")
+}
+
+// args returns the arguments of cosign verifying the image with the policy.
+func (p ImageSignaturePolicy) args(image string) []string {
+	if p.AttestationType != "" {
+		return p.attestationArgs(p.AttestationType, image)
+	}
+	return append(append([]string{"verify"}, p.identityArgs()...), image)
+}
+
+// attestationArgs returns the arguments of cosign verifying the attestation of the type of the image with the key or the identity of the policy,
+// which prints the verified attestations.
+func (p ImageSignaturePolicy) attestationArgs(predicateType, image string) []string {
+	return append(append([]string{"verify-attestation", "--type", predicateType}, p.identityArgs()...), image)
+}
+
+func (p ImageSignaturePolicy) identityArgs() []string {
+	if p.Key != "" {
+		return []string{"--key", p.Key}
+	}
+	return []string{"--certificate-identity-regexp", p.CertificateIdentity, "--certificate-oidc-issuer", p.CertificateOIDCIssuer}
+}
+
+// ImageVerifier verifies the signatures or the attestations of the images.
+type ImageVerifier interface {
+	Verify(image string, policy ImageSignaturePolicy) error
+}
+
+// DigestResolver resolves the tags of the images to the digests they point to, which are what the signatures are verified for.
+type DigestResolver interface {
+	Digest(image string) (string, error)
+}
+
+// cosignCLI is the ImageVerifier that runs the cosign command, which needs to be installed in the gocat container image.
+// The registry credentials and the KMS keys are read as cosign itself does, like the AWS credentials of gocat.
+type cosignCLI struct {
+	// Command is the path to the cosign command. Defaults to "cosign".
+	Command string
+}
+
+func (c cosignCLI) Verify(image string, policy ImageSignaturePolicy) error {
+	bin := c.Command
+	if bin == "" {
+		bin = "cosign"
+	}
+	args := policy.args(image)
+	cmd := exec.Command(bin, args...)
+	cmd.Env = commandEnv()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return withCode(ErrCodePluginNotInstalled, fmt.Errorf("command cosign: %w", err))
+		}
+		return fmt.Errorf("command cosign %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// imageReference returns the reference of the image to verify, which is pinned to the digest if known.
+func imageReference(image types.Image) string {
+	if image.Digest != "" {
+		return image.Name + "@" + image.Digest
+	}
+	return image.Name + ":" + image.NewTag
+}
+
+// warningLines returns the warnings of the deploy appended to the pull request body, or an empty string if none.
+func warningLines(warnings []string) string {
+	var s string
+	for _, w := range warnings {
+		s += "\n:warning: " + w
+	}
+	return s
+}
+
+// checkImages checks the images with the tagPolicy and the imageSignature of the phase,
+// which is shared by all the paths changing the images of the phase, like the deploys and the rollbacks.
+// It returns the images pinned to the digests verified, which are the ones to deploy,
+// along with the warnings of the images unable to be verified in the warn mode.
+func checkImages(verifier ImageVerifier, digests DigestResolver, ph DeployPhase, images []types.Image) ([]types.Image, []string, error) {
+	if err := checkImageTags(ph, images); err != nil {
+		return nil, nil, err
+	}
+	return verifyImageSignatures(verifier, digests, ph, images)
+}
+
+// verifyImageSignatures verifies the images with the signature policy of the phase.
+//
+// The signatures are verified for the digests, as a tag can be pushed again after it's verified.
+// So the images with no digest are pinned to the digests their tags point to, and the pinned images are returned to be deployed,
+// so that what ships is what was verified.
+// It returns the warnings of the images failing the verification in the warn mode,
+// and an error coded ErrCodeImageUnsigned for the first of them in the block mode, including the ones whose digests can't be resolved.
+func verifyImageSignatures(verifier ImageVerifier, digests DigestResolver, ph DeployPhase, images []types.Image) ([]types.Image, []string, error) {
+	policy := ph.ImageSignature
+	if !policy.Enabled() {
+		return images, nil, nil
+	}
+	pinned := make([]types.Image, len(images))
+	copy(pinned, images)
+	var warnings []string
+	for i, image := range pinned {
+		ref := imageReference(image)
+		var err error
+		if image.Digest == "" {
+			if pinned[i].Digest, err = digests.Digest(ref); err != nil {
+				pinned[i].Digest = ""
+				err = fmt.Errorf("unable to resolve the digest to verify: %w", err)
+			}
+		}
+		if err == nil {
+			ref = imageReference(pinned[i])
+			if err = verifier.Verify(ref, policy); err == nil {
+				log.Printf("[INFO] Verified the signature of %s", ref)
+				continue
+			}
+		}
+		if policy.Mode == "block" {
+			return nil, nil, withCode(ErrCodeImageUnsigned, fmt.Errorf("unable to verify %s: %w", ref, err))
+		}
+		log.Printf("[WARNING] Unable to verify %s: %s", ref, err)
+		warnings = append(warnings, fmt.Sprintf("Unable to verify the signature of `%s`: %s", ref, err))
+	}
+	return pinned, warnings, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+type fakeImageVerifier map[string]bool
+
+func (v fakeImageVerifier) Verify(image string, policy ImageSignaturePolicy) error {
+	if !v[image] {
+		return fmt.Errorf("no matching signatures")
+	}
+	return nil
+}
+
+type fakeDigestResolver map[string]string
+
+func (r fakeDigestResolver) Digest(image string) (string, error) {
+	digest, ok := r[image]
+	if !ok {
+		return "", fmt.Errorf("MANIFEST_UNKNOWN")
+	}
+	return digest, nil
+}
+
+func TestImageSignaturePolicy_args(t *testing.T) {
+	keyed := ImageSignaturePolicy{Mode: "block", Key: "awskms:///alias/cosign"}
+	require.Equal(t, []string{"verify", "--key", "awskms:///alias/cosign", "registry/myapp:abc"}, keyed.args("registry/myapp:abc"))
+
+	keyless := ImageSignaturePolicy{Mode: "warn", CertificateIdentity: "^https://github.com/zaiminc/", CertificateOIDCIssuer: "https://token.actions.githubusercontent.com", AttestationType: "slsaprovenance"}
+	require.NoError(t, keyless.validate("kustomize"))
+	require.Equal(t, []string{
+		"verify-attestation", "--type", "slsaprovenance",
+		"--certificate-identity-regexp", "^https://github.com/zaiminc/",
+		"--certificate-oidc-issuer", "https://token.actions.githubusercontent.com",
+		"registry/myapp:abc",
+	}, keyless.args("registry/myapp:abc"))
+
+	require.Error(t, ImageSignaturePolicy{Mode: "warn"}.validate("kustomize"))
+	// The other kinds would deploy the images without verifying them
+	require.EqualError(t, keyed.validate("kanvas"), "kind kanvas doesn't verify the images. imageSignature is supported only by kustomize")
+	require.NoError(t, ImageSignaturePolicy{}.validate("jenkins"))
+
+	// The attestations of the SBOMs are verified with the same identity
+	require.Equal(t, []string{"verify-attestation", "--type", "spdxjson", "--key", "awskms:///alias/cosign", "registry/myapp@sha256:0123"}, keyed.attestationArgs("spdxjson", "registry/myapp@sha256:0123"))
+}
+
+func TestVerifyImageSignatures(t *testing.T) {
+	verifier := fakeImageVerifier{"registry/api@sha256:aaaa": true, "registry/worker@sha256:0123": true, "registry/batch@sha256:bbbb": false}
+	digests := fakeDigestResolver{"registry/api:abc": "sha256:aaaa", "registry/batch:abc": "sha256:bbbb"}
+	images := []types.Image{
+		{Name: "registry/api", NewTag: "abc"},
+		{Name: "registry/worker", NewTag: "abc", Digest: "sha256:0123"},
+		{Name: "registry/batch", NewTag: "abc"},
+	}
+
+	verified, warnings, err := verifyImageSignatures(verifier, digests, DeployPhase{}, images)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+	require.Equal(t, images, verified)
+
+	// The images are pinned to the digests verified, as the tags can be pushed again after they're verified
+	warn := DeployPhase{ImageSignature: ImageSignaturePolicy{Mode: "warn", Key: "cosign.pub"}}
+	verified, warnings, err = verifyImageSignatures(verifier, digests, warn, images)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Unable to verify the signature of `registry/batch@sha256:bbbb`: no matching signatures"}, warnings)
+	require.Equal(t, []types.Image{
+		{Name: "registry/api", NewTag: "abc", Digest: "sha256:aaaa"},
+		{Name: "registry/worker", NewTag: "abc", Digest: "sha256:0123"},
+		{Name: "registry/batch", NewTag: "abc", Digest: "sha256:bbbb"},
+	}, verified)
+	require.Empty(t, images[0].Digest)
+
+	block := DeployPhase{ImageSignature: ImageSignaturePolicy{Mode: "block", Key: "cosign.pub"}}
+	_, _, err = verifyImageSignatures(verifier, digests, block, images)
+	require.EqualError(t, err, "unable to verify registry/batch@sha256:bbbb: no matching signatures")
+	require.Equal(t, ErrCodeImageUnsigned, errorCodeOf(err))
+
+	// The mutable tags are never verified in place of the digests, failing closed
+	_, _, err = verifyImageSignatures(fakeImageVerifier{"registry/api:abc": true}, fakeDigestResolver{}, block, images[:1])
+	require.EqualError(t, err, "unable to verify registry/api:abc: unable to resolve the digest to verify: MANIFEST_UNKNOWN")
+	require.Equal(t, ErrCodeImageUnsigned, errorCodeOf(err))
+}
+
+func TestCheckImages(t *testing.T) {
+	verifier := fakeImageVerifier{"registry/api@sha256:aaaa": true}
+	digests := fakeDigestResolver{"registry/api:v1.2.3": "sha256:aaaa", "registry/api:v1.2.4": "sha256:cccc"}
+	ph := DeployPhase{
+		TagPolicy:      TagPolicy{Allow: []string{`v\d+\.\d+\.\d+`}},
+		ImageSignature: ImageSignaturePolicy{Mode: "block", Key: "cosign.pub"},
+	}
+
+	images, warnings, err := checkImages(verifier, digests, ph, []types.Image{{Name: "registry/api", NewTag: "v1.2.3"}})
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+	require.Equal(t, []types.Image{{Name: "registry/api", NewTag: "v1.2.3", Digest: "sha256:aaaa"}}, images)
+
+	// The tag policy is checked before the signature, like for the rollbacks to the tags deployed before the policy
+	_, _, err = checkImages(verifier, digests, ph, []types.Image{{Name: "registry/api", NewTag: "1a2b3c4"}})
+	require.Equal(t, ErrCodeTagNotAllowed, errorCodeOf(err))
+
+	_, _, err = checkImages(verifier, digests, ph, []types.Image{{Name: "registry/api", NewTag: "v1.2.4"}})
+	require.Equal(t, ErrCodeImageUnsigned, errorCodeOf(err))
+}
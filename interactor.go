@@ -29,23 +29,59 @@ type DeployUsecase interface {
 	SelectBranch(string, string, string, string) (blocks []slack.Block, err error)
 }
 
+// OptionRequester is implemented by the DeployUsecase implementations
+// that can request a deploy with the options other than the branch, like the image tag resolved from a semver constraint
+// and the reason of the deploy.
+type OptionRequester interface {
+	RequestWithOption(pj DeployProject, phase string, option DeployOption, assigner string, channel string) (blocks []slack.Block, err error)
+}
+
+// InPlaceRequester is implemented by the DeployUsecase implementations
+// that post the approval message asynchronously, and can do so by updating an existing message instead of posting a new one.
+type InPlaceRequester interface {
+	RequestInPlace(pj DeployProject, phase string, branch string, assigner string, channel string, messageTS string) (blocks []slack.Block, err error)
+}
+
+// InPlaceApprover is implemented by the DeployUsecase implementations that deploy as soon as they're approved, like Jenkins,
+// which post the pipeline of the deploy in the thread of the approval message at messageTS.
+type InPlaceApprover interface {
+	ApproveInPlace(params string, userID string, channel string, messageTS string) (blocks []slack.Block, err error)
+}
+
+// approveInPlace approves the deploy of the approval message at messageTS, in place if the interactor can.
+func approveInPlace(interactor DeployUsecase, params string, userID string, channel string, messageTS string) ([]slack.Block, error) {
+	if a, ok := interactor.(InPlaceApprover); ok && messageTS != "" {
+		return a.ApproveInPlace(params, userID, channel, messageTS)
+	}
+	return interactor.Approve(params, userID, channel)
+}
+
+// Retrier is implemented by the DeployUsecase implementations that can retry the failed stage of a deploy,
+// like opening the pull request or merging it, with the inputs the deploy already resolved.
+type Retrier interface {
+	Retry(r DeployRetry, userID string, channel string) (blocks []slack.Block, err error)
+}
+
 type InteractorFactory struct {
-	kanvas    InteractorGitOps
-	kustomize InteractorGitOps
-	jenkins   InteractorJenkins
-	job       InteractorJob
-	lambda    InteractorLambda
-	combine   InteractorCombine
+	// gitops is the InteractorGitOps for each registered GitOpsPlugin, like kanvas and kustomize.
+	gitops  map[string]InteractorGitOps
+	jenkins InteractorJenkins
+	job     InteractorJob
+	lambda  InteractorLambda
+	combine InteractorCombine
 }
 
 func NewInteractorFactory(c InteractorContext) InteractorFactory {
+	gitops := map[string]InteractorGitOps{}
+	for name, f := range gitOpsPlugins {
+		gitops[name] = NewInteractorGitOps(c, name, f)
+	}
 	return InteractorFactory{
-		kanvas:    NewInteractorKanavs(c),
-		kustomize: NewInteractorKustomize(c),
-		jenkins:   NewInteractorJenkins(c),
-		job:       NewInteractorJob(c),
-		lambda:    NewInteractorLambda(c),
-		combine:   NewInteractorCombine(c),
+		gitops:  gitops,
+		jenkins: NewInteractorJenkins(c),
+		job:     NewInteractorJob(c),
+		lambda:  NewInteractorLambda(c),
+		combine: NewInteractorCombine(c),
 	}
 }
 
@@ -57,11 +93,10 @@ func (i InteractorFactory) Get(pj DeployProject, phase string) DeployUsecase {
 }
 
 func (i InteractorFactory) get(kind string) DeployUsecase {
+	if interactor, ok := i.gitops[kind]; ok {
+		return interactor
+	}
 	switch kind {
-	case "kanvas":
-		return i.kanvas
-	case "kustomize":
-		return i.kustomize
 	case "job":
 		return i.job
 	case "lambda":
@@ -74,11 +109,13 @@ func (i InteractorFactory) get(kind string) DeployUsecase {
 }
 
 func (i InteractorFactory) GetByParams(params string) DeployUsecase {
+	// params is deploy_<kind>_<action>. See InteractorContext.actionHeader.
+	if p := strings.Split(params, "_"); len(p) == 3 {
+		if interactor, ok := i.gitops[p[1]]; ok {
+			return interactor
+		}
+	}
 	switch {
-	case strings.Contains(params, "kanvas"):
-		return i.kanvas
-	case strings.Contains(params, "kustomize"):
-		return i.kustomize
 	case strings.Contains(params, "job"):
 		return i.job
 	case strings.Contains(params, "lambda"):
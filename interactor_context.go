@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/slack-go/slack"
 )
@@ -15,12 +16,84 @@ type InteractorContext struct {
 	git         GitOperator
 	client      *slack.Client
 	config      CatConfig
+	// postDeployHooks are run after a deploy pull request is merged via the Deploy button.
+	postDeployHooks PostDeployHooks
+	// approvalReminder follows up on the approval messages the interactor posts.
+	approvalReminder *ApprovalReminder
+	// rollouts follows the Argo Rollouts of the phases after their deploys are merged.
+	rollouts *RolloutController
+	// recoverer recovers from the panics in the goroutines the interactor starts, which outlive the interaction.
+	recoverer *PanicRecoverer
+	// tracer records the timelines of the deploys the interactor handles.
+	tracer *DeployTracer
+	// archiver archives the artifacts of the deploys the interactor prepares.
+	archiver *ArtifactArchiver
+	// prefs are the preferences of the users, which the messages to the requesters honor.
+	prefs *UserPreferenceStore
+	// previewer previews the rollouts of the deploys in their approval messages.
+	previewer *RolloutPreviewer
+	// commandHooks runs the command hooks of the phases.
+	commandHooks *CommandHookRunner
+	// limiter limits the deploys prepared at once.
+	limiter *DeployLimiter
+	// templates override the layout of the deploy confirmation.
+	templates *MessageTemplates
+	// gate checks the deploys right before they ship.
+	gate DeployGate
+}
+
+// archive archives the artifacts of the deploy as it ships, for the kinds with no pull request to merge, like Jenkins,
+// whose deploys ship when they're approved instead of running the post-deploy hooks.
+func (i InteractorContext) archive(m DeployMetadata, artifacts map[string][]byte) {
+	defer i.recoverer.Recover(fmt.Sprintf("archiving the artifacts of %s %s", m.Project, m.Phase), "")
+	i.tracer.SetArtifacts(m.TraceID, artifacts)
+	if err := ArchiveHook(i.archiver, &i.git, i.projectList, i.tracer, cosignCLI{})(m, m.Project+" "+m.Phase); err != nil {
+		log.Printf("[ERROR] %s", err)
+	}
+}
+
+// startTrace starts the trace of the deploy m for the kinds with no pull request to approve, like Jenkins,
+// whose deploys start as soon as the Deploy button is clicked, and sets its ID to m.
+// The pipeline of the deploy is posted in the thread of the approval message at messageTS, if given.
+// The requester is who clicked the button unless the button carries who requested it.
+func (i InteractorContext) startTrace(m *DeployMetadata, userID string, channel string, messageTS string) {
+	requester := m.RequesterSlackID
+	if requester == "" {
+		requester = userID
+	}
+	m.TraceID = i.tracer.Start(m.Project, m.Phase, "requested by <@%s> with the branch %s", requester, m.Branch)
+	i.tracer.SetRequester(m.TraceID, requester)
+	i.tracer.ShowPipeline(m.TraceID, channel, messageTS, directPipelineSteps)
+	i.tracer.Emit(m.TraceID, DeployEventApproved, "deploying %s approved by <@%s>", m.Branch, userID)
+}
+
+// directApproveParams returns the params of the Deploy button of the kinds with no pull request to approve, like Jenkins,
+// which carry the Slack user ID of the requester so that the approval is checked against it at DeployGate, like the twoPersonRule of the phase.
+func directApproveParams(pj DeployProject, phase string, branch string, requester string) string {
+	return fmt.Sprintf("%s_%s_%s@%s", pj.ID, phase, branch, requester)
+}
+
+// parseDirectApproveParams returns the project, the phase, the branch, and the requester in the params of directApproveParams.
+// The requester is empty for the buttons posted before it was put in them.
+func parseDirectApproveParams(params string) (target string, phase string, branch string, requester string, err error) {
+	if n := strings.LastIndex(params, "@"); n >= 0 {
+		params, requester = params[:n], params[n+1:]
+	}
+	p := strings.SplitN(params, "_", 3)
+	if len(p) != 3 {
+		err = fmt.Errorf("Invalid Arguments")
+		return
+	}
+	return p[0], p[1], p[2], requester, nil
 }
 
 func (i InteractorContext) actionHeader(nextFunc string) string {
 	return fmt.Sprintf("deploy_%s_%s", i.kind, nextFunc)
 }
 
+// maxBranchOptions is the maximum number of the options of a select menu of Slack.
+const maxBranchOptions = 100
+
 func (i InteractorContext) branchList(pj DeployProject, phase string) ([]slack.Block, error) {
 	repo := pj.GitHubRepository()
 	arr, err := i.github.ListBranch(repo)
@@ -30,11 +103,18 @@ func (i InteractorContext) branchList(pj DeployProject, phase string) ([]slack.B
 	}
 	var opts []*slack.OptionBlockObject
 	for n, v := range arr {
+		if n == maxBranchOptions {
+			break
+		}
 		txt := slack.NewTextBlockObject("plain_text", v, false, false)
 		opt := slack.NewOptionBlockObject(fmt.Sprintf("%s|%s_%s_%d", i.actionHeader("selectbranch"), pj.ID, phase, n), txt, nil)
 		opts = append(opts, opt)
 	}
-	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s* branch list", repo), false, false)
+	text := fmt.Sprintf("*%s* branch list", repo)
+	if len(arr) > maxBranchOptions {
+		text += fmt.Sprintf(" (the %d most recently committed of %d branches)", maxBranchOptions, len(arr))
+	}
+	txt := slack.NewTextBlockObject("mrkdwn", text, false, false)
 	availableOption := slack.NewOptionsSelectBlockElement("static_select", nil, "", opts...)
 	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(availableOption))
 	fmt.Printf("[INFO] %#v", section)
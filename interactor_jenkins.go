@@ -20,24 +20,21 @@ func NewInteractorJenkins(i InteractorContext) (o InteractorJenkins) {
 }
 
 func (i InteractorJenkins) Request(pj DeployProject, phase string, branch string, assigner string, channel string) ([]slack.Block, error) {
+	prefs := i.prefs.Get(assigner)
 	var txt *slack.TextBlockObject
 	if phase == "production" && branch != pj.DefaultBranch() {
+		stars := strings.Repeat(":star:", 21)
 		txt = slack.NewTextBlockObject(
 			"mrkdwn",
-			fmt.Sprintf(
-				":star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star:\n本番環境に master ブランチ以外をデプロイしようとしています\n:star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star:\n*%s*\n*%s*\n*%s* ブランチ\nをデプロイしますか?",
-				pj.GitHubRepository(),
-				phase,
-				branch,
-			),
+			fmt.Sprintf("%s\n%s\n%s\n*%s*\n*%s*\n%s", stars, prefs.Message("nonDefaultBranch", pj.DefaultBranch()), stars, pj.GitHubRepository(), phase, prefs.Message("confirm", branch)),
 			false,
 			false,
 		)
 	} else {
-		txt = slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n*%s* ブランチ\nをデプロイしますか?", pj.GitHubRepository(), phase, branch), false, false)
+		txt = slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n%s", pj.GitHubRepository(), phase, prefs.Message("confirm", branch)), false, false)
 	}
 	btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%s_%s", i.actionHeader("approve"), pj.ID, phase, branch), btnTxt)
+	btn := slack.NewButtonBlockElement("", i.actionHeader("approve")+"|"+directApproveParams(pj, phase, branch, assigner), btnTxt)
 	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
 	return []slack.Block{section, CloseButton()}, nil
 }
@@ -59,16 +56,35 @@ func (i InteractorJenkins) SelectBranch(params string, branch string, userID str
 }
 
 func (i InteractorJenkins) Approve(params string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	return i.approve(p[0], p[1], p[2], userID)
+	return i.ApproveInPlace(params, userID, channel, "")
+}
+
+func (i InteractorJenkins) ApproveInPlace(params string, userID string, channel string, messageTS string) ([]slack.Block, error) {
+	target, phase, branch, requester, err := parseDirectApproveParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return i.approve(target, phase, branch, requester, userID, channel, messageTS)
 }
 
-func (i InteractorJenkins) approve(target string, phase string, branch string, userID string) (blocks []slack.Block, err error) {
+func (i InteractorJenkins) approve(target string, phase string, branch string, requester string, userID string, channel string, messageTS string) (blocks []slack.Block, err error) {
 	pj := i.projectList.Find(target)
+	m := DeployMetadata{Project: pj.ID, Phase: phase, Branch: branch, RequesterSlackID: requester}
+	if err = i.gate.Check(pj, pj.FindPhase(phase), m, userID); err != nil {
+		return
+	}
+	// The approval is handled while Slack waits for the response, so the deploy fails instead of waiting for a slot
+	release, err := i.limiter.TryAcquire(pj, pj.FindPhase(phase))
+	if err != nil {
+		return
+	}
+	defer release()
+	i.startTrace(&m, userID, channel, messageTS)
 	jobName := pj.JenkinsJob()
 	url := fmt.Sprintf("https://bot:%s@%s/job/%s/buildWithParameters?token=%s&cause=slack-bot&ENV=%s&BRANCH=%s", i.config.JenkinsBotToken, i.config.JenkinsHost, jobName, i.config.JenkinsJobToken, phase, branch)
 	resp, err := http.Get(url)
 	if err != nil {
+		i.tracer.Emit(m.TraceID, DeployEventFailed, "failed to build %s: %s", jobName, err)
 		return
 	}
 	defer resp.Body.Close()
@@ -78,7 +94,12 @@ func (i InteractorJenkins) approve(target string, phase string, branch string, u
 	}
 	if resp.StatusCode != 201 {
 		res = jobName + " Request failed. responsed " + fmt.Sprint(resp.StatusCode)
+		i.tracer.Emit(m.TraceID, DeployEventFailed, "failed to build %s: %d", jobName, resp.StatusCode)
+	} else {
+		i.tracer.Emit(m.TraceID, DeployEventDeployed, "started the build of %s", jobName)
+		go i.archive(m, nil)
 	}
+	res += traceLine(m.TraceID)
 
 	blockObject := slack.NewTextBlockObject("mrkdwn", res, false, false)
 	blocks = append(blocks, slack.NewSectionBlock(blockObject, nil, nil))
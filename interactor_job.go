@@ -22,9 +22,9 @@ func NewInteractorJob(i InteractorContext) (o InteractorJob) {
 func (i InteractorJob) Request(pj DeployProject, phase string, branch string, assigner string, channel string) (blocks []slack.Block, err error) {
 	var txt *slack.TextBlockObject
 	p := pj.FindPhase(phase)
-	txt = slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n*%s* ブランチ\nをデプロイしますか?", p.Path, phase, branch), false, false)
+	txt = slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n%s", p.Path, phase, i.prefs.Get(assigner).Message("confirm", branch)), false, false)
 	btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%s_%s", i.actionHeader("approve"), pj.ID, phase, branch), btnTxt)
+	btn := slack.NewButtonBlockElement("", i.actionHeader("approve")+"|"+directApproveParams(pj, phase, branch, assigner), btnTxt)
 	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
 	return []slack.Block{section, CloseButton()}, nil
 }
@@ -46,18 +46,37 @@ func (i InteractorJob) SelectBranch(params string, branch string, userID string,
 }
 
 func (i InteractorJob) Approve(params string, userID string, channel string) (blocks []slack.Block, err error) {
-	p := strings.Split(params, "_")
-	return i.approve(p[0], p[1], p[2], userID, channel)
+	return i.ApproveInPlace(params, userID, channel, "")
+}
+
+func (i InteractorJob) ApproveInPlace(params string, userID string, channel string, messageTS string) (blocks []slack.Block, err error) {
+	target, phase, branch, requester, err := parseDirectApproveParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return i.approve(target, phase, branch, requester, userID, channel, messageTS)
 }
 
-func (i InteractorJob) approve(target string, phase string, branch string, userID string, channel string) (blocks []slack.Block, err error) {
+func (i InteractorJob) approve(target string, phase string, branch string, requester string, userID string, channel string, messageTS string) (blocks []slack.Block, err error) {
 	pj := i.projectList.Find(target)
+	m := DeployMetadata{Project: pj.ID, Phase: phase, Branch: branch, RequesterSlackID: requester}
+	if err = i.gate.Check(pj, pj.FindPhase(phase), m, userID); err != nil {
+		return
+	}
+	// The approval is handled while Slack waits for the response, so the deploy fails instead of waiting for a slot
+	release, err := i.limiter.TryAcquire(pj, pj.FindPhase(phase))
+	if err != nil {
+		return
+	}
+	i.startTrace(&m, userID, channel, messageTS)
 
 	res, err := i.model.Deploy(pj, phase, DeployOption{Branch: branch})
+	release()
 	if err != nil {
+		i.tracer.Emit(m.TraceID, DeployEventFailed, "failed to deploy: %s", err)
 		fields := []slack.AttachmentField{
 			{Title: "user", Value: "<@" + userID + ">"},
-			{Title: "error", Value: err.Error()},
+			{Title: "error", Value: describeError(err)},
 		}
 		msg := slack.Attachment{Color: "#e01e5a", Title: fmt.Sprintf("Failed to deploy %s %s", pj.ID, phase), Fields: fields}
 		if _, _, err := i.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
@@ -65,6 +84,7 @@ func (i InteractorJob) approve(target string, phase string, branch string, userI
 		}
 		return
 	}
+	go i.archive(m, nil)
 
 	switch do := res.(type) {
 	case ModelJobDeployOutput:
@@ -72,22 +92,26 @@ func (i InteractorJob) approve(target string, phase string, branch string, userI
 			err := i.model.Watch(do.Name, do.Namespace)
 			fields := []slack.AttachmentField{{Title: "user", Value: "<@" + userID + ">"}}
 			if err != nil {
+				i.tracer.Emit(m.TraceID, DeployEventFailed, "%s failed: %s", do.Name, err)
 				msg := slack.Attachment{Color: "#e01e5a", Title: fmt.Sprintf("Failed %s execution", do.Name), Fields: fields}
 				if _, _, err := i.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
 					log.Printf("Failed to post message: %s", err.Error())
 				}
 				return
 			}
+			i.tracer.Emit(m.TraceID, DeployEventDeployed, "%s succeeded", do.Name)
 			msg := slack.Attachment{Color: "#36a64f", Title: fmt.Sprintf("Succeed %s Job execution", do.Name), Fields: fields}
 			if _, _, err := i.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
 				log.Printf("Failed to post message: %s", err.Error())
 			}
 		}()
+	default:
+		i.tracer.Emit(m.TraceID, DeployEventDeployed, "%s", res.Message())
 	}
 
 	blocks = i.plainBlocks(
 		res.Message(),
-		"by <@"+userID+">",
+		"by <@"+userID+">"+traceLine(m.TraceID),
 	)
 	return
 }
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/slack-go/slack"
 )
@@ -15,68 +18,379 @@ type InteractorGitOps struct {
 }
 
 func NewInteractorKustomize(i InteractorContext) (o InteractorGitOps) {
+	return NewInteractorGitOps(i, "kustomize", NewGitOpsPluginKustomize)
+}
+
+// NewInteractorGitOps returns the InteractorGitOps for the kind, which prepares deploys using the plugin created by newPlugin.
+func NewInteractorGitOps(i InteractorContext, kind string, newPlugin GitOpsPluginFactory) (o InteractorGitOps) {
 	o = InteractorGitOps{
 		InteractorContext: i,
-		model:             NewGitOpsPluginKustomize(&o.github, &o.git),
+		model:             newPlugin(&o.github, &o.git),
 	}
-	o.kind = "kustomize"
+	o.kind = kind
 	return
 }
 
 func (i InteractorGitOps) Request(pj DeployProject, phase string, branch string, assigner string, channel string) (blocks []slack.Block, err error) {
+	return i.request(pj, phase, DeployOption{Branch: branch}, assigner, channel, "")
+}
+
+// RequestInPlace is the same as Request, but posts the approval message by updating the message at messageTS,
+// like the one the user selected the project or the branch in.
+func (i InteractorGitOps) RequestInPlace(pj DeployProject, phase string, branch string, assigner string, channel string, messageTS string) (blocks []slack.Block, err error) {
+	return i.request(pj, phase, DeployOption{Branch: branch}, assigner, channel, messageTS)
+}
+
+// RequestWithOption is the same as Request, but with the options like the specific image tag and the reason of the deploy.
+func (i InteractorGitOps) RequestWithOption(pj DeployProject, phase string, option DeployOption, assigner string, channel string) (blocks []slack.Block, err error) {
+	return i.request(pj, phase, option, assigner, channel, "")
+}
+
+func (i InteractorGitOps) request(pj DeployProject, phase string, option DeployOption, assigner string, channel string, messageTS string) (blocks []slack.Block, err error) {
 	user := i.userList.FindBySlackUserID(assigner)
+	branch := option.Branch
+	option.Assigner = user
+	if messageTS != "" {
+		if permalink, err := i.client.GetPermalink(&slack.PermalinkParameters{Channel: channel, Ts: messageTS}); err != nil {
+			log.Printf("[ERROR] Failed to get the permalink of the deploy request of %s %s: %s", pj.ID, phase, err)
+		} else {
+			option.SlackURL = permalink
+		}
+	}
+	if option.TraceID == "" {
+		option.TraceID = i.tracer.Start(pj.ID, phase, "requested by <@%s> with the branch %s", assigner, branch)
+	}
+	trace := option.TraceID
+	i.tracer.SetRequester(trace, assigner)
+	prefs := i.prefs.Get(assigner)
+	// The direct commits have nothing to approve, so they pass the gates, the preDeploy hooks, and the preDeployCommands
+	// of the approvals right before they're pushed
+	option.Gate = func(m DeployMetadata) error {
+		phase := pj.FindPhase(m.Phase)
+		if err := i.gate.Check(pj, phase, m, assigner); err != nil {
+			return err
+		}
+		if err := i.runPreDeployHooks(phase, m); err != nil {
+			return err
+		}
+		m.SlackChannel = channel
+		if err := i.commandHooks.RunAll(pj, phase, phase.Hooks.PreDeployCommands, newWebhookVars("preDeploy", m), threadReporter(i.client, m)); err != nil {
+			return err
+		}
+		// The pull requests changing no image, like the secret rotations, have no migration to wait for
+		if !phase.MigrationGate.Enabled() || m.Tag == "" {
+			return nil
+		}
+		return newMigrationGateRunner(&i.github, &i.git).Run(pj, phase, m.Tag, threadReporter(i.client, m))
+	}
 
 	go func() {
+		defer i.recoverer.Recover(fmt.Sprintf("the deploy request of %s %s", pj.ID, phase), channel)
 		defer func() {
 			log.Printf("[INFO] Exiting the goroutine for Prepare")
 		}()
+		// The blocks returned below are replacing the message meanwhile, so the ones posted here are kept apart
+		var blocks []slack.Block
+
+		i.tracer.ShowPipeline(trace, channel, messageTS, pipelineSteps(pj.FindPhase(phase)))
+		i.tracer.Record(trace, "preparing the deploy of %s", branch)
 
-		log.Printf("[INFO] Preparing to deploy %s %s %s", pj.ID, phase, branch)
+		var output *SlackOutputStream
+		if i.kind == "kanvas" {
+			// kanvas apply can take minutes, so we show its progress in Slack instead of leaving it a black box
+			output = NewSlackOutputStream(i.client, channel, messageTS, fmt.Sprintf("`kanvas apply` for *%s* *%s*", pj.ID, phase))
+			option.Output = output
+		}
 
-		o, err := i.model.Prepare(pj, phase, branch, user, "")
+		ctx, cancel := context.WithTimeout(context.Background(), maxDeploySlotWait)
+		release, err := i.limiter.Acquire(ctx, pj, pj.FindPhase(phase), func(reason string) {
+			i.tracer.Record(trace, "waiting for a deploy slot as %s", reason)
+			text := fmt.Sprintf("The deploy of *%s* *%s* is waiting as %s", pj.ID, phase, reason)
+			if _, _, err := i.client.PostMessage(channel, slack.MsgOptionText(text, false)); err != nil {
+				log.Printf("Failed to post message: %s", err)
+			}
+		})
+		cancel()
+		var o GitOpsPrepareOutput
+		if err == nil {
+			o, err = i.model.Prepare(pj, phase, option)
+			release()
+		}
+		if output != nil {
+			output.Finish(err)
+		}
 		if err != nil {
 			log.Printf("[ERROR] %s", err.Error())
+			i.tracer.Emit(trace, DeployEventFailed, "failed to prepare: %s", err)
+			retry := option
+			retry.Output = nil
+			retry.Images = o.Images
+			i.tracer.SetRetry(trace, DeployRetry{Stage: PipelineStepPrepare, Kind: i.kind, Project: pj.ID, Phase: phase, Option: retry})
 
-			blocks := i.plainBlocks(err.Error())
-			if _, _, err := i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+			blocks := retryBlocks(describeError(err)+traceLine(trace), trace)
+			if respChannel, ts, err := i.postMessage(channel, messageTS, blocks); err != nil {
 				log.Printf("Failed to post message: %s", err)
+			} else {
+				i.tracer.ThreadPipeline(trace, respChannel, ts)
 			}
+			notifyByDM(i.client, i.prefs, assigner, prefs.Message("failed", pj.ID, phase, err))
 			return
 		}
 
 		if o.Status() == DeployStatusAlready {
 			log.Printf("[INFO] Already Deployed in this revision: %s %s %s", pj.ID, phase, branch)
+			i.tracer.Emit(trace, DeployEventSkipped, "already deployed")
 
 			blocks = i.plainBlocks("Already Deployed in this revision")
-			if _, _, err := i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+			if respChannel, ts, err := i.postMessage(channel, messageTS, blocks); err != nil {
 				log.Printf("Failed to post message: %s", err)
+			} else {
+				i.tracer.ThreadPipeline(trace, respChannel, ts)
 			}
 			return
 		}
 
-		log.Printf("[INFO] Prepared to deploy %s %s %s", pj.ID, phase, branch)
+		if o.SBOMSummary != "" {
+			i.tracer.Record(trace, "SBOM %s", o.SBOMSummary)
+		}
+		// The artifacts are archived once the deploy ships, so that the ones closed without being merged are never replayed
+		i.tracer.SetArtifacts(trace, o.Artifacts)
+
+		if o.Direct() {
+			i.tracer.Step(trace, PipelineStepPrepare, PipelineStepDone)
+			i.tracer.Step(trace, PipelineStepApprove, PipelineStepSkipped)
+			i.tracer.Step(trace, PipelineStepMerge, PipelineStepDone)
+			if pj.FindPhase(phase).SyntheticChecks.Enabled() {
+				// The deploy is deploying until the synthetic checks pass in the post-deploy hooks
+				i.tracer.Emit(trace, DeployEventApproved, "pushed %s directly, running the synthetic checks", o.CommitSHA)
+			} else {
+				i.tracer.Emit(trace, DeployEventDeployed, "pushed %s directly", o.CommitSHA)
+			}
+
+			blocks = i.plainBlocks(fmt.Sprintf("<@%s>\n*%s*\n*%s*\n%s\n%s%s", assigner, pj.GitHubRepository(), phase, prefs.Message("deployed", branch), o.CommitHTMLURL, traceLine(trace)))
+			if respChannel, ts, err := i.postMessage(channel, messageTS, blocks); err != nil {
+				log.Printf("Failed to post message: %s", err)
+			} else {
+				i.tracer.ThreadPipeline(trace, respChannel, ts)
+			}
+			go i.followRollout(o.Metadata, channel)
+			notifyByDM(i.client, i.prefs, assigner, fmt.Sprintf("*%s* *%s*: %s\n%s", pj.ID, phase, prefs.Message("deployed", branch), o.CommitHTMLURL))
+			i.postDeployHooks.Run(o.Metadata, o.CommitHTMLURL)
+			return
+		}
 
 		prHTMLURL := o.PullRequestHTMLURL
 		if prHTMLURL == "" {
 			prHTMLURL = fmt.Sprintf("https://github.com/%s/%s/pull/%d", i.github.org, i.github.repo, o.PullRequestNumber)
 		}
+		i.tracer.Emit(trace, DeployEventAwaitingApproval, "opened %s for approval", prHTMLURL)
+		if o.Stacked != nil {
+			i.supersede(*o.Stacked, o.Metadata, prHTMLURL)
+		}
 
-		txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("<@%s>\n*%s*\n*%s*\n*%s* ブランチをデプロイしますか?\n%s", assigner, pj.GitHubRepository(), phase, branch, prHTMLURL), false, false)
-		btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-		btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%d", i.actionHeader("approve"), o.PullRequestID, o.PullRequestNumber), btnTxt)
-		blocks = append(blocks, slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn)))
-
-		closeBtnTxt := slack.NewTextBlockObject("plain_text", "Close", false, false)
-		closeBtn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%d_%s", i.actionHeader("reject"), o.PullRequestID, o.PullRequestNumber, o.Branch), closeBtnTxt)
-		blocks = append(blocks, slack.NewActionBlock("", closeBtn))
-		if _, _, err := i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+		approveValue := fmt.Sprintf("%s|%s_%d", i.actionHeader("approve"), o.PullRequestID, o.PullRequestNumber)
+		if o.Metadata.Tag != "" {
+			// The button approves the tag it was posted for, so that it never approves the ones stacked after it
+			approveValue += "@" + o.Metadata.Tag
+		}
+		closeValue := fmt.Sprintf("%s|%s_%d_%s", i.actionHeader("reject"), o.PullRequestID, o.PullRequestNumber, o.Branch)
+		preview := rolloutPreviewBlocks(i.previewer, pj, pj.FindPhase(phase))
+		vars := deployConfirmationVars(assigner, pj, phase, branch, prefs.Message("confirm", branch), prHTMLURL, trace, approveValue, closeValue)
+		blocks = append(blocks, i.templates.BlocksOr("deploy_confirmation", prefs.Locale, vars, func() []slack.Block {
+			txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("<@%s>\n*%s*\n*%s*\n%s\n%s%s", assigner, pj.GitHubRepository(), phase, prefs.Message("confirm", branch), prHTMLURL, traceLine(trace)), false, false)
+			btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
+			btn := slack.NewButtonBlockElement("", approveValue, btnTxt)
+			closeBtnTxt := slack.NewTextBlockObject("plain_text", "Close", false, false)
+			closeBtn := slack.NewButtonBlockElement("", closeValue, closeBtnTxt)
+			return []slack.Block{slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn)), slack.NewActionBlock("", closeBtn)}
+		})...)
+		// The preview follows the confirmation, as it varies by the phase
+		blocks = append(blocks, preview...)
+		respChannel, ts, err := i.postMessage(channel, messageTS, blocks)
+		if err != nil {
 			log.Printf("Failed to post message: %s", err)
+			return
+		}
+
+		i.approvalReminder.Track(i.client, pj.ID, phase, respChannel, ts, blocks)
+		i.tracer.Queue(trace, assigner, respChannel, ts, closeValue)
+		i.tracer.ThreadPipeline(trace, respChannel, ts)
+
+		if err := i.linkSlackThread(o.PullRequestID, respChannel, ts); err != nil {
+			log.Printf("[ERROR] Failed to link the pull request %s to the Slack thread: %s", prHTMLURL, err)
 		}
 	}()
 
 	return i.plainBlocks("Now creating pull request..."), nil
 }
 
+// pullRequestMetadata returns the metadata of the pull request, which is fetched once per approval and passed through all the gates.
+// The pull request without the metadata is never merged, as the gates can't tell what it deploys.
+func (i InteractorGitOps) pullRequestMetadata(prID string) (DeployMetadata, error) {
+	body, err := i.github.GetPullRequestBody(prID)
+	if err != nil {
+		return DeployMetadata{}, err
+	}
+	m, err := ParseDeployMetadata(body)
+	if err != nil {
+		return m, withCode(ErrCodeMetadataMissing, err)
+	}
+	return m, nil
+}
+
+// postMessage updates the message at messageTS in place if it's given, or posts a new message otherwise.
+func (i InteractorGitOps) postMessage(channel string, messageTS string, blocks []slack.Block) (string, string, error) {
+	if messageTS == "" {
+		return i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...))
+	}
+	respChannel, ts, _, err := i.client.UpdateMessage(channel, messageTS, slack.MsgOptionBlocks(blocks...))
+	return respChannel, ts, err
+}
+
+// linkSlackThread links the deploy pull request and the Slack message the deploy was requested in, bidirectionally.
+//
+// It posts the permalink of the Slack message as a pull request comment,
+// and records the Slack message in the metadata embedded in the pull request body
+// so that githubWebhookHandler can post back to the Slack thread when the pull request is merged or closed on GitHub.
+func (i InteractorGitOps) linkSlackThread(prID string, channel string, ts string) error {
+	permalink, err := i.client.GetPermalink(&slack.PermalinkParameters{Channel: channel, Ts: ts})
+	if err != nil {
+		return fmt.Errorf("unable to get the permalink of the Slack message: %w", err)
+	}
+	if err := i.github.AddComment(prID, fmt.Sprintf("Requested in Slack: %s", permalink)); err != nil {
+		return fmt.Errorf("unable to comment on the pull request: %w", err)
+	}
+
+	body, err := i.github.GetPullRequestBody(prID)
+	if err != nil {
+		return fmt.Errorf("unable to get the pull request body: %w", err)
+	}
+	metadata, err := ParseDeployMetadata(body)
+	if err != nil {
+		return err
+	}
+	metadata.SlackChannel = channel
+	metadata.SlackThreadTS = ts
+	return i.github.UpdatePullRequestBody(prID, ReplaceDeployMetadata(body, metadata))
+}
+
+// runPreDeployHooks calls the preDeploy webhooks of the phase of the pull request before it's merged.
+// It returns ErrPreDeployHookFailed if any of them fails, which aborts the merge.
+func (i InteractorGitOps) runPreDeployHooks(phase DeployPhase, m DeployMetadata) error {
+	if len(phase.Hooks.PreDeploy) == 0 {
+		return nil
+	}
+	if err := NewDeployWebhookRunner().PreDeploy(phase, m); err != nil {
+		i.tracer.Emit(m.TraceID, DeployEventFailed, "%s", err)
+		return err
+	}
+	i.tracer.Record(m.TraceID, "%d preDeploy hooks succeeded", len(phase.Hooks.PreDeploy))
+	return nil
+}
+
+// runPreDeployCommands runs the preDeployCommands of the phase of the pull request, if any, in the background,
+// posting their output to the Slack thread the deploy was requested in, and merges the pull request once all of them succeed.
+// It returns gated as true if the commands are run.
+func (i InteractorGitOps) runPreDeployCommands(pj DeployProject, phase DeployPhase, m DeployMetadata, prID string, prNumber string, userID string, channel string) (blocks []slack.Block, gated bool, err error) {
+	commands := phase.Hooks.PreDeployCommands
+	if len(commands) == 0 {
+		return nil, false, nil
+	}
+	if m.SlackChannel == "" {
+		m.SlackChannel = channel
+	}
+	prURL := fmt.Sprintf("https://github.com/%s/%s/pull/%s", i.github.org, i.github.repo, prNumber)
+	report := threadReporter(i.client, m)
+	go func() {
+		defer i.recoverer.Recover(fmt.Sprintf("the preDeploy commands of %s %s", pj.ID, phase.Name), channel)
+		i.tracer.Record(m.TraceID, "running the preDeploy commands before merging")
+		if err := i.commandHooks.RunAll(pj, phase, commands, newWebhookVars("preDeploy", m), report); err != nil {
+			i.tracer.Emit(m.TraceID, DeployEventFailed, "%s", err)
+			report(fmt.Sprintf("%s is left open. Deploy again once the commands pass.", prURL))
+			return
+		}
+		blocks, err := i.mergeUnlessGated(pj, phase, m, prID, prNumber, userID, channel)
+		if err != nil {
+			log.Printf("[ERROR] Failed to merge %s after the preDeploy commands: %s", prURL, err)
+			report(fmt.Sprintf(":x: Failed to merge %s: %s", prURL, err))
+			return
+		}
+		if _, _, err := i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+			log.Printf("[ERROR] Failed to post the merge of %s: %s", prURL, err)
+		}
+	}()
+	return i.plainBlocks(fmt.Sprintf("Approved by <@%s>. Running the preDeploy commands of %s %s with `%s` before merging %s", userID, pj.ID, phase.Name, m.Tag, prURL)), true, nil
+}
+
+// checkMigrationGate checks the migration gate of the phase of the pull request before it's merged.
+// It returns ErrMigrationPending if the migrations are pending with no job to apply them.
+// If the gate has a job to run, it returns gated as true and merges the pull request in the background once the job succeeds,
+// reporting the progress in the thread of the Slack message the deploy was requested in.
+func (i InteractorGitOps) checkMigrationGate(pj DeployProject, phase DeployPhase, m DeployMetadata, prID string, prNumber string, userID string, channel string) (blocks []slack.Block, gated bool, err error) {
+	// The pull requests changing no image, like the secret rotations, have no migration to wait for
+	if !phase.MigrationGate.Enabled() || m.Tag == "" {
+		return nil, false, nil
+	}
+
+	runner := newMigrationGateRunner(&i.github, &i.git)
+	err = runner.Check(pj, phase, m.Tag)
+	if err == nil {
+		return nil, false, nil
+	}
+	if !errors.Is(err, ErrMigrationPending) || phase.MigrationGate.Job == "" {
+		return nil, false, err
+	}
+
+	threadChannel, threadTS := m.SlackChannel, m.SlackThreadTS
+	if threadChannel == "" {
+		threadChannel = channel
+	}
+	progress := func(text string) {
+		if _, _, err := i.client.PostMessage(threadChannel, slack.MsgOptionText(text, false), slack.MsgOptionTS(threadTS)); err != nil {
+			log.Printf("[ERROR] Failed to post the progress of the migration gate of %s %s: %s", pj.ID, phase.Name, err)
+		}
+	}
+	prURL := fmt.Sprintf("https://github.com/%s/%s/pull/%s", i.github.org, i.github.repo, prNumber)
+	go func() {
+		defer i.recoverer.Recover(fmt.Sprintf("the migration gate of %s %s", pj.ID, phase.Name), channel)
+		i.tracer.Record(m.TraceID, "running the migrations before merging")
+		if err := runner.Run(pj, phase, m.Tag, progress); err != nil {
+			log.Printf("[ERROR] The migration gate of %s %s failed: %s", pj.ID, phase.Name, err)
+			i.tracer.Emit(m.TraceID, DeployEventFailed, "the migration gate failed: %s", err)
+			progress(fmt.Sprintf(":x: %s\n%s is left open. Deploy again once the migrations are applied.", err, prURL))
+			return
+		}
+		blocks, err := i.merge(m, prID, prNumber, userID, channel)
+		if err != nil {
+			log.Printf("[ERROR] Failed to merge %s after the migrations: %s", prURL, err)
+			progress(fmt.Sprintf(":x: Failed to merge %s: %s", prURL, err))
+			return
+		}
+		if _, _, err := i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+			log.Printf("[ERROR] Failed to post the merge of %s: %s", prURL, err)
+		}
+	}()
+	return i.plainBlocks(fmt.Sprintf("Approved by <@%s>. Running the migrations of %s %s with `%s` before merging %s", userID, pj.ID, phase.Name, m.Tag, prURL)), true, nil
+}
+
+// followRollout follows the Argo Rollout of the phase of the merged pull request in the channel, if the phase has one.
+func (i InteractorGitOps) followRollout(m DeployMetadata, channel string) {
+	pj := i.projectList.Find(m.Project)
+	phase := pj.FindPhase(m.Phase)
+	if !phase.Rollout.Enabled() {
+		return
+	}
+	if i.rollouts == nil {
+		i.tracer.Step(m.TraceID, PipelineStepSync, PipelineStepSkipped)
+		return
+	}
+	if err := i.rollouts.Follow(i.client, channel, pj, phase, m.Tag, m.TraceID, 2*time.Hour); err != nil {
+		i.tracer.Step(m.TraceID, PipelineStepSync, PipelineStepFailed)
+		return
+	}
+	i.tracer.Step(m.TraceID, PipelineStepSync, PipelineStepDone)
+}
+
 func (i InteractorGitOps) BranchList(pj DeployProject, phase string) ([]slack.Block, error) {
 	return i.branchList(pj, phase)
 }
@@ -96,6 +410,10 @@ func (i InteractorGitOps) SelectBranch(params string, branch string, userID stri
 func (i InteractorGitOps) Approve(params string, userID string, channel string) (blocks []slack.Block, err error) {
 	prID := ""
 	prNumber := ""
+	approvedTag := ""
+	if n := strings.LastIndex(params, "@"); n >= 0 {
+		params, approvedTag = params[:n], params[n+1:]
+	}
 	p := strings.Split(params, "_")
 	if len(p) == 2 {
 		prID = p[0]
@@ -109,9 +427,115 @@ func (i InteractorGitOps) Approve(params string, userID string, channel string)
 		err = fmt.Errorf("Invalid Arguments")
 		return
 	}
-	if err = i.github.MergePullRequest(prID); err != nil {
+	m, err := i.pullRequestMetadata(prID)
+	if err != nil {
+		return nil, err
+	}
+	if approvedTag != "" && approvedTag != m.Tag {
+		return i.plainBlocks(fmt.Sprintf("https://github.com/%s/%s/pull/%s was updated to `%s` after this approval was requested for `%s`. Approve the latest message to deploy it.", i.github.org, i.github.repo, prNumber, m.Tag, approvedTag)), nil
+	}
+	return i.approve(m, prID, prNumber, userID, channel)
+}
+
+// supersede replaces the approval message of the deploy the new one was stacked onto, whose Deploy button no longer holds,
+// as the pull request now ships the new tag too, which needs a new approval.
+func (i InteractorGitOps) supersede(stacked DeployMetadata, m DeployMetadata, prHTMLURL string) {
+	i.tracer.Emit(stacked.TraceID, DeployEventSkipped, "superseded by %s stacked onto %s", m.Tag, prHTMLURL)
+	if stacked.SlackChannel == "" || stacked.SlackThreadTS == "" {
 		return
 	}
+	text := fmt.Sprintf("`%s` was stacked onto %s, which now ships `%s` too. Approve the latest message to deploy them.", m.Tag, prHTMLURL, stacked.Tag)
+	if _, _, _, err := i.client.UpdateMessage(stacked.SlackChannel, stacked.SlackThreadTS, slack.MsgOptionBlocks(i.plainBlocks(text)...)); err != nil {
+		log.Printf("[ERROR] Failed to replace the approval message of %s superseded by %s: %s", stacked.Tag, m.Tag, err)
+	}
+}
+
+// approve passes the approved pull request of m through the gates in order, and merges it once all of them pass:
+// DeployGate, the preDeploy hooks, the preDeployCommands, and the migration gate.
+//
+// The preDeploy hooks are called in the background, as they can take minutes with the retries, which Slack never waits for.
+// The message telling their failure has the Retry button, which approves the pull request again.
+func (i InteractorGitOps) approve(m DeployMetadata, prID string, prNumber string, userID string, channel string) ([]slack.Block, error) {
+	pj := i.projectList.Find(m.Project)
+	phase := pj.FindPhase(m.Phase)
+	if err := i.gate.Check(pj, phase, m, userID); err != nil {
+		return nil, err
+	}
+	// The deploy is deploying from here, and no longer canceled from the queue, until it's merged or fails
+	i.tracer.Emit(m.TraceID, DeployEventApproved, "approved by <@%s>", userID)
+	if len(phase.Hooks.PreDeploy) == 0 {
+		return i.mergeAfterPreDeployHooks(pj, phase, m, prID, prNumber, userID, channel)
+	}
+
+	prURL := fmt.Sprintf("https://github.com/%s/%s/pull/%s", i.github.org, i.github.repo, prNumber)
+	go func() {
+		defer i.recoverer.Recover(fmt.Sprintf("the preDeploy hooks of %s %s", pj.ID, phase.Name), channel)
+		err := i.runPreDeployHooks(phase, m)
+		blocks := []slack.Block{}
+		if err == nil {
+			blocks, err = i.mergeAfterPreDeployHooks(pj, phase, m, prID, prNumber, userID, channel)
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to merge %s approved by %s: %s", prURL, userID, err)
+			i.tracer.SetRetry(m.TraceID, DeployRetry{Stage: PipelineStepMerge, Kind: i.kind, Project: m.Project, Phase: m.Phase, PullRequestID: prID, PullRequestNumber: prNumber})
+			blocks = retryBlocks(fmt.Sprintf("%s approved by <@%s> is left open\n%s%s", prURL, userID, describeError(err), traceLine(m.TraceID)), m.TraceID)
+		}
+		if _, _, err := i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+			log.Printf("[ERROR] Failed to post the merge of %s: %s", prURL, err)
+		}
+	}()
+	return i.plainBlocks(fmt.Sprintf("Approved by <@%s>. Calling the preDeploy hooks of %s %s before merging %s", userID, pj.ID, phase.Name, prURL)), nil
+}
+
+// mergeAfterPreDeployHooks runs the gates following the preDeploy hooks, and merges the pull request once they pass.
+func (i InteractorGitOps) mergeAfterPreDeployHooks(pj DeployProject, phase DeployPhase, m DeployMetadata, prID string, prNumber string, userID string, channel string) ([]slack.Block, error) {
+	if blocks, gated, err := i.runPreDeployCommands(pj, phase, m, prID, prNumber, userID, channel); gated || err != nil {
+		return blocks, err
+	}
+	return i.mergeUnlessGated(pj, phase, m, prID, prNumber, userID, channel)
+}
+
+// mergeUnlessGated merges the approved pull request unless the migration gate holds it.
+func (i InteractorGitOps) mergeUnlessGated(pj DeployProject, phase DeployPhase, m DeployMetadata, prID string, prNumber string, userID string, channel string) ([]slack.Block, error) {
+	if blocks, gated, err := i.checkMigrationGate(pj, phase, m, prID, prNumber, userID, channel); gated || err != nil {
+		return blocks, err
+	}
+	return i.merge(m, prID, prNumber, userID, channel)
+}
+
+// merge merges the approved pull request, and returns the message replacing the approval message.
+// If the merge fails, the message tells the failure with the Retry button merging the pull request again instead.
+func (i InteractorGitOps) merge(m DeployMetadata, prID string, prNumber string, userID string, channel string) (blocks []slack.Block, err error) {
+	// Another deploy may have been stacked onto the pull request while the gates ran, like the migrations of the approved tag,
+	// in which case the new tag passes the gates with a new approval instead of being merged with this one
+	current, err := i.pullRequestMetadata(prID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Tag != m.Tag {
+		i.tracer.Record(m.TraceID, "#%s was updated to %s after it was approved with %s", prNumber, current.Tag, m.Tag)
+		return i.plainBlocks(fmt.Sprintf("https://github.com/%s/%s/pull/%s was updated to `%s` after <@%s> approved `%s`. Approve it again to deploy `%s`.", i.github.org, i.github.repo, prNumber, current.Tag, userID, m.Tag, current.Tag)), nil
+	}
+	if err = i.github.MergePullRequest(prID); err != nil {
+		log.Printf("[ERROR] Failed to merge #%s: %s", prNumber, err)
+		i.tracer.Emit(m.TraceID, DeployEventFailed, "failed to merge #%s: %s", prNumber, err)
+		i.tracer.SetRetry(m.TraceID, DeployRetry{Stage: PipelineStepMerge, Kind: i.kind, Project: m.Project, Phase: m.Phase, PullRequestID: prID, PullRequestNumber: prNumber})
+		text := fmt.Sprintf("Failed to merge https://github.com/%s/%s/pull/%s approved by <@%s>\n%s%s", i.github.org, i.github.repo, prNumber, userID, describeError(err), traceLine(m.TraceID))
+		return retryBlocks(text, m.TraceID), nil
+	}
+	go i.postDeployHooks.Run(m, fmt.Sprintf("https://github.com/%s/%s/pull/%s", i.github.org, i.github.repo, prNumber))
+	go i.followRollout(m, channel)
+
+	i.tracer.Step(m.TraceID, PipelineStepApprove, PipelineStepDone)
+	i.tracer.Step(m.TraceID, PipelineStepMerge, PipelineStepDone)
+	if i.projectList.Find(m.Project).FindPhase(m.Phase).SyntheticChecks.Enabled() {
+		// The deploy is recorded as deployed once the synthetic checks pass in the post-deploy hooks
+		i.tracer.Record(m.TraceID, "merged #%s approved by <@%s>, running the synthetic checks", prNumber, userID)
+	} else {
+		i.tracer.Emit(m.TraceID, DeployEventDeployed, "merged #%s approved by <@%s>", prNumber, userID)
+	}
+	prURL := fmt.Sprintf("https://github.com/%s/%s/pull/%s", i.github.org, i.github.repo, prNumber)
+	notifyByDM(i.client, i.prefs, m.RequesterSlackID, i.prefs.Get(m.RequesterSlackID).Message("merged", m.Project, m.Phase, prURL, userID))
 
 	blockObject := slack.NewTextBlockObject("mrkdwn", i.config.ArgoCDHost+"/applications", false, false)
 	blocks = append(blocks, slack.NewSectionBlock(blockObject, nil, nil))
@@ -140,7 +564,49 @@ func (i InteractorGitOps) Approve(params string, userID string, channel string)
 	return
 }
 
+// Retry runs the failed stage of the deploy again.
+// Prepare is retried with the option and the images the deploy was prepared with, on behalf of the requester of the deploy,
+// so that the user retrying it neither ships newer images nor becomes the requester, who the two-person rule checks the approver against.
+// Merge passes the approved pull request through all the gates again, as approving it does, for the user retrying it,
+// who merges it in place of the approver.
+func (i InteractorGitOps) Retry(r DeployRetry, userID string, channel string) (blocks []slack.Block, err error) {
+	switch r.Stage {
+	case PipelineStepPrepare:
+		i.tracer.Emit(r.TraceID, DeployEventRequested, "retrying Prepare, requested by <@%s>", userID)
+		option := r.Option
+		option.TraceID = r.TraceID
+		requester := option.Assigner.SlackUserID
+		if requester == "" {
+			requester = userID
+		}
+		return i.request(i.projectList.Find(r.Project), r.Phase, option, requester, channel, "")
+	case PipelineStepMerge:
+		m, err := i.pullRequestMetadata(r.PullRequestID)
+		if err != nil {
+			i.tracer.SetRetry(r.TraceID, r)
+			return nil, err
+		}
+		i.tracer.Record(r.TraceID, "retrying Merge by <@%s>", userID)
+		i.tracer.Step(r.TraceID, PipelineStepMerge, PipelineStepRunning)
+		blocks, err := i.approve(m, r.PullRequestID, r.PullRequestNumber, userID, channel)
+		if err != nil {
+			// Keep the failed stage so that someone else can retry it
+			i.tracer.SetRetry(r.TraceID, r)
+		}
+		return blocks, err
+	default:
+		return nil, fmt.Errorf("unable to retry %s of the deploy %s", r.Stage, r.TraceID)
+	}
+}
+
 func (i InteractorGitOps) Reject(params string, userID string) ([]slack.Block, error) {
+	prID, prNum, branch := parseRejectParams(params)
+	return i.reject(prID, prNum, branch, userID)
+}
+
+// parseRejectParams splits the params of the close button into the ID and the number of the pull request, and the branch,
+// which may contain _ as the branch name template renders it.
+func parseRejectParams(params string) (prID string, prNum string, branch string) {
 	p := strings.Split(params, "_")
 
 	if strings.HasPrefix(params, "PR") {
@@ -169,7 +635,7 @@ func (i InteractorGitOps) Reject(params string, userID string) ([]slack.Block, e
 		p = a
 	}
 
-	return i.reject(p[0], p[1], p[2], userID)
+	return p[0], p[1], strings.Join(p[2:], "_")
 }
 
 func (i InteractorGitOps) reject(prID string, prNum string, branch string, userID string) (blocks []slack.Block, err error) {
@@ -179,8 +645,26 @@ func (i InteractorGitOps) reject(prID string, prNum string, branch string, userI
 	if err = i.github.DeleteBranch(branch); err != nil {
 		return
 	}
+	if body, err := i.github.GetPullRequestBody(prID); err == nil {
+		if m, err := ParseDeployMetadata(body); err == nil {
+			i.tracer.Emit(m.TraceID, DeployEventSkipped, "closed #%s by <@%s>", prNum, userID)
+		}
+	}
 
 	blockObject := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("closed https://github.com/%s/%s/pull/%s\nby <@%s>", i.github.org, i.github.repo, prNum, userID), false, false)
 	blocks = append(blocks, slack.NewSectionBlock(blockObject, nil, nil))
 	return
 }
+
+// pullRequestApprovalBlocks returns the message asking the question about the pull request,
+// whose buttons merge or close it through InteractorGitOps as the ones of the deploy approval message do.
+func pullRequestApprovalBlocks(github *GitHub, userID string, pj DeployProject, phase string, question string, buttonLabel string, prID string, prNumber int, branch string) []slack.Block {
+	const interactor = "deploy_kustomize"
+	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("<@%s>\n*%s*\n*%s*\n%s\nhttps://github.com/%s/%s/pull/%d", userID, pj.ID, phase, question, github.org, github.repo, prNumber), false, false)
+	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s_approve|%s_%d", interactor, prID, prNumber), slack.NewTextBlockObject("plain_text", buttonLabel, false, false))
+	closeBtn := slack.NewButtonBlockElement("", fmt.Sprintf("%s_reject|%s_%d_%s", interactor, prID, prNumber, branch), slack.NewTextBlockObject("plain_text", "Close", false, false))
+	return []slack.Block{
+		slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn)),
+		slack.NewActionBlock("", closeBtn),
+	}
+}
@@ -19,7 +19,7 @@ func TestNewInteractorKustomize(t *testing.T) {
 	want.kind = "kustomize"
 	want.git = git
 	want.github = github
-	want.model = &GitOpsPluginKustomize{github: &github, git: &git}
+	want.model = &GitOpsPluginKustomize{github: &github, git: &git, verifier: cosignCLI{}, sboms: cosignCLI{}, copier: craneCLI{}, digests: craneCLI{}}
 
 	require.Equal(t, want, got)
 }
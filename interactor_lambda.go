@@ -20,27 +20,49 @@ func NewInteractorLambda(i InteractorContext) (o InteractorLambda) {
 }
 
 func (self InteractorLambda) Request(pj DeployProject, phase string, branch string, assigner string, channel string) ([]slack.Block, error) {
-	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n*%s* ブランチをデプロイしますか?", pj.ID, phase, branch), false, false)
+	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n%s", pj.ID, phase, self.prefs.Get(assigner).Message("confirm", branch)), false, false)
 	btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%s_%s", self.actionHeader("approve"), pj.ID, phase, branch), btnTxt)
+	btn := slack.NewButtonBlockElement("", self.actionHeader("approve")+"|"+directApproveParams(pj, phase, branch, assigner), btnTxt)
 	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
 	return []slack.Block{section, CloseButton()}, nil
 }
 
 func (self InteractorLambda) Approve(params string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	return self.approve(p[0], p[1], p[2], userID, channel)
+	return self.ApproveInPlace(params, userID, channel, "")
+}
+
+func (self InteractorLambda) ApproveInPlace(params string, userID string, channel string, messageTS string) ([]slack.Block, error) {
+	target, phase, branch, requester, err := parseDirectApproveParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return self.approve(target, phase, branch, requester, userID, channel, messageTS)
 }
 
-func (self InteractorLambda) approve(target string, phase string, branch string, userID string, channel string) (blocks []slack.Block, err error) {
+func (self InteractorLambda) approve(target string, phase string, branch string, requester string, userID string, channel string, messageTS string) (blocks []slack.Block, err error) {
 	pj := self.projectList.Find(target)
+	m := DeployMetadata{Project: pj.ID, Phase: phase, Branch: branch, RequesterSlackID: requester}
+	if err = self.gate.Check(pj, pj.FindPhase(phase), m, userID); err != nil {
+		return
+	}
+	// The approval is handled while Slack waits for the response, so the deploy fails instead of waiting for a slot
+	release, err := self.limiter.TryAcquire(pj, pj.FindPhase(phase))
+	if err != nil {
+		return
+	}
+	self.startTrace(&m, userID, channel, messageTS)
 
 	go func() {
+		defer release()
 		res, err := self.model.Deploy(pj, phase, DeployOption{Branch: branch})
-		if err != nil || res.Status() == DeployStatusFail {
+		if err == nil && res.Status() == DeployStatusFail {
+			err = fmt.Errorf("the deploy of %s finished with the failed status", branch)
+		}
+		if err != nil {
+			self.tracer.Emit(m.TraceID, DeployEventFailed, "failed to deploy %s: %s", branch, err)
 			fields := []slack.AttachmentField{
 				{Title: "user", Value: "<@" + userID + ">"},
-				{Title: "error", Value: err.Error()},
+				{Title: "error", Value: describeError(err)},
 			}
 			msg := slack.Attachment{Color: "#e01e5a", Title: fmt.Sprintf("Failed to deploy %s %s", pj.ID, phase), Fields: fields}
 			if _, _, err := self.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
@@ -48,6 +70,8 @@ func (self InteractorLambda) approve(target string, phase string, branch string,
 			}
 			return
 		}
+		self.tracer.Emit(m.TraceID, DeployEventDeployed, "deployed %s", branch)
+		self.archive(m, nil)
 
 		msg := slack.Attachment{Color: "#36a64f", Title: fmt.Sprintf("Succeed to deploy %s %s", pj.ID, phase)}
 		msg.Fields = []slack.AttachmentField{
@@ -64,7 +88,7 @@ func (self InteractorLambda) approve(target string, phase string, branch string,
 	}()
 
 	blocks = self.plainBlocks("Now deploying ...")
-	userObject := slack.NewTextBlockObject("mrkdwn", "by <@"+userID+">", false, false)
+	userObject := slack.NewTextBlockObject("mrkdwn", "by <@"+userID+">"+traceLine(m.TraceID), false, false)
 	blocks = append(blocks, slack.NewSectionBlock(userObject, nil, nil))
 	return
 }
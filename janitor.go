@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// gitRootJanitorMinAge is the minimum age of a clone or a .kanvastmp directory
+// to be evicted by the janitor.
+// Anything modified more recently than this is considered in use.
+const gitRootJanitorMinAge = time.Hour
+
+// GitRootJanitor enforces the disk quota under GOCAT_GITROOT.
+//
+// gocat clones the manifest repository and, in case of the kanvas plugin, the application repositories under gitRoot.
+// The kanvas plugin reuses the clones across deploys and removes the .kanvastmp directory after each Prepare,
+// but a crash in the middle of kanvas apply leaves the .kanvastmp directory behind,
+// and the clones of the application repositories accumulate over time.
+//
+// The janitor removes stale .kanvastmp directories, and then evicts the least-recently-used clones
+// until the total size of gitRoot fits in the quota.
+type GitRootJanitor struct {
+	gitRoot string
+	// quota is the maximum total size of gitRoot in bytes.
+	quota int64
+	// keep is the list of local repository roots that are never evicted,
+	// like the one for the manifest repository that gocat keeps using for its lifetime.
+	keep []string
+}
+
+func NewGitRootJanitor(gitRoot string, quota int64, keep ...string) GitRootJanitor {
+	return GitRootJanitor{gitRoot: gitRoot, quota: quota, keep: keep}
+}
+
+func (j GitRootJanitor) Watch(sec int64) {
+	log.Printf("[INFO] GitRoot Janitor is started. Interval is %d seconds. Quota is %d bytes.", sec, j.quota)
+	go func() {
+		// We don't stop the ticker as this is a long-running process
+		// with no way to cancel it.
+		t := time.NewTicker(time.Duration(sec) * time.Second)
+		for range t.C {
+			j.Run()
+		}
+	}()
+}
+
+type gitRootClone struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Run removes stale .kanvastmp directories and evicts clones exceeding the quota.
+func (j GitRootJanitor) Run() {
+	clones, err := j.findClones()
+	if err != nil {
+		log.Printf("[ERROR] Failed to find clones under %s: %s", j.gitRoot, err)
+		return
+	}
+
+	var total int64
+	for i, c := range clones {
+		tmp := filepath.Join(c.path, ".kanvastmp")
+		if info, err := os.Stat(tmp); err == nil && time.Since(info.ModTime()) > gitRootJanitorMinAge {
+			log.Printf("[INFO] Removing stale %s", tmp)
+			if err := os.RemoveAll(tmp); err != nil {
+				log.Printf("[ERROR] Failed to remove %s: %s", tmp, err)
+			} else {
+				clones[i].size, _ = dirSize(c.path)
+			}
+		}
+		total += clones[i].size
+	}
+
+	if j.quota <= 0 || total <= j.quota {
+		return
+	}
+
+	// Least-recently-used first
+	sort.Slice(clones, func(a, b int) bool {
+		return clones[a].modTime.Before(clones[b].modTime)
+	})
+	for _, c := range clones {
+		if total <= j.quota {
+			return
+		}
+		if j.isKept(c.path) || time.Since(c.modTime) < gitRootJanitorMinAge {
+			continue
+		}
+		log.Printf("[INFO] Evicting %s (%d bytes) to fit in the quota of %s", c.path, c.size, j.gitRoot)
+		if err := os.RemoveAll(c.path); err != nil {
+			log.Printf("[ERROR] Failed to remove %s: %s", c.path, err)
+			continue
+		}
+		total -= c.size
+	}
+	log.Printf("[WARNING] %s still exceeds the quota: %d > %d bytes", j.gitRoot, total, j.quota)
+}
+
+func (j GitRootJanitor) isKept(path string) bool {
+	for _, k := range j.keep {
+		if filepath.Clean(k) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// findClones returns the local repository roots under gitRoot,
+// which are the directories containing a .git directory.
+//
+// The modTime of a clone is the last time gocat used it.
+// See GitOperator.touch for more details.
+func (j GitRootJanitor) findClones() ([]gitRootClone, error) {
+	var clones []gitRootClone
+	err := filepath.WalkDir(j.gitRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, err := dirSize(path)
+		if err != nil {
+			return err
+		}
+		clones = append(clones, gitRootClone{path: path, size: size, modTime: info.ModTime()})
+		return filepath.SkipDir
+	})
+	return clones, err
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
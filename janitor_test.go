@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitRootJanitor(t *testing.T) {
+	root := t.TempDir()
+	old := time.Now().Add(-2 * gitRootJanitorMinAge)
+
+	mkClone := func(name string, size int, modTime time.Time) string {
+		p := filepath.Join(root, "github.com", "zaiminc", name)
+		require.NoError(t, os.MkdirAll(filepath.Join(p, ".git"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(p, "data"), make([]byte, size), 0644))
+		require.NoError(t, os.Chtimes(p, modTime, modTime))
+		return p
+	}
+
+	manifests := mkClone("manifests", 100, old.Add(-time.Hour))
+	oldest := mkClone("app1", 100, old)
+	recent := mkClone("app2", 100, time.Now())
+
+	tmp := filepath.Join(recent, ".kanvastmp")
+	require.NoError(t, os.MkdirAll(tmp, 0755))
+	require.NoError(t, os.Chtimes(tmp, old, old))
+
+	NewGitRootJanitor(root, 250, manifests).Run()
+
+	require.DirExists(t, manifests)
+	require.NoDirExists(t, oldest)
+	require.DirExists(t, recent)
+	require.NoDirExists(t, tmp)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// JobTemplateVars is the set of variables available in the Job manifest template and the path of PhaseJob.
+type JobTemplateVars struct {
+	Project string
+	Phase   string
+	Job     string
+	// Tag is the image tag deployed to the phase, or the one given to the run command.
+	Tag string
+	// Image is the docker repository of the primary image of the phase, without the tag.
+	Image string
+	// Suffix is a random string to make the name of each run unique, as a Job can't be updated once created.
+	Suffix string
+}
+
+func (self JobTemplateVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+// JobRunner runs the one-off jobs of the phases, like database migrations, with the image tag deployed to the phase,
+// instead of having teams hand-edit the Job manifests to match the tag.
+type JobRunner struct {
+	github *GitHub
+	git    *GitOperator
+}
+
+func NewJobRunner(github *GitHub, git *GitOperator) JobRunner {
+	return JobRunner{github: github, git: git}
+}
+
+// JobRunOutput is the result of JobRunner.Run.
+type JobRunOutput struct {
+	// Namespace and Name identify the Job created in the cluster, if the job is applied directly.
+	Namespace string
+	Name      string
+	// Path and CommitSHA are the file and the commit the manifest is committed to, if the job is committed.
+	Path      string
+	CommitSHA string
+	Tag       string
+}
+
+// Run renders the Job manifest of the job with the tag, which defaults to the one deployed to the phase,
+// and either commits it to the manifest repository or creates it in the cluster.
+func (r JobRunner) Run(pj DeployProject, phase DeployPhase, job PhaseJob, tag string) (JobRunOutput, error) {
+	var o JobRunOutput
+	if tag == "" {
+		current, err := phase.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: r.github})
+		if err != nil {
+			return o, fmt.Errorf("unable to find the tag deployed to %s %s: %w", pj.ID, phase.Name, err)
+		}
+		if current == "" {
+			return o, fmt.Errorf("no tag is deployed to %s %s. Specify the tag to run %s with", pj.ID, phase.Name, job.Name)
+		}
+		tag = current
+	}
+	o.Tag = tag
+
+	vars := JobTemplateVars{
+		Project: pj.ID,
+		Phase:   phase.Name,
+		Job:     job.Name,
+		Tag:     tag,
+		Image:   pj.ImageTagQuery(phase, ImageTagVars{}).Image,
+		Suffix:  RandString(5),
+	}
+	tmpl, err := r.github.GetFile(job.Template)
+	if err != nil {
+		return o, err
+	}
+	manifest, err := vars.Parse(string(tmpl))
+	if err != nil {
+		return o, fmt.Errorf("unable to render the template of the job %s: %w", job.Name, err)
+	}
+	k8sJob, err := parseJobManifest(manifest)
+	if err != nil {
+		return o, fmt.Errorf("the rendered manifest of the job %s is invalid: %w", job.Name, err)
+	}
+
+	if job.Apply {
+		if k8sJob.Namespace == "" {
+			k8sJob.Namespace = "default"
+		}
+		if err := createJob(&k8sJob); err != nil {
+			return o, err
+		}
+		o.Namespace, o.Name = k8sJob.Namespace, k8sJob.Name
+		return o, nil
+	}
+
+	if job.Path == "" {
+		return o, fmt.Errorf("the job %s has neither path to commit to nor apply", job.Name)
+	}
+	o.Path, err = vars.Parse(job.Path)
+	if err != nil {
+		return o, fmt.Errorf("unable to render the path of the job %s: %w", job.Name, err)
+	}
+	branch := fmt.Sprintf("bot/job-%s-%s-%s-%s", pj.ID, phase.Name, job.Name, vars.Suffix)
+	message := fmt.Sprintf("Run job %s. project: %s, phase: %s, tag: %s.", job.Name, pj.ID, phase.Name, tag)
+	o.CommitSHA, err = r.git.PushFileDirectly(branch, o.Path, []byte(manifest), message)
+	if err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// parseJobManifest parses the manifest as a Job, checking that it has a name.
+func parseJobManifest(manifest string) (batchv1.Job, error) {
+	job := batchv1.Job{}
+	j, err := yaml.ToJSON([]byte(manifest))
+	if err != nil {
+		return job, err
+	}
+	if err := json.Unmarshal(j, &job); err != nil {
+		return job, err
+	}
+	if job.Kind != "Job" || strings.TrimSpace(job.Name) == "" {
+		return job, fmt.Errorf("kind must be Job and metadata.name is required")
+	}
+	return job, nil
+}
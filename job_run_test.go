@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobTemplate(t *testing.T) {
+	vars := JobTemplateVars{Project: "myapp", Phase: "production", Job: "migrate", Tag: "v1.2.3", Image: "example.com/myapp", Suffix: "abcde"}
+	manifest, err := vars.Parse(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: {{.Project}}-{{.Job}}-{{.Suffix}}
+spec:
+  template:
+    spec:
+      containers:
+      - name: migrate
+        image: {{.Image}}:{{.Tag}}
+      restartPolicy: Never
+`)
+	require.NoError(t, err)
+
+	job, err := parseJobManifest(manifest)
+	require.NoError(t, err)
+	require.Equal(t, "myapp-migrate-abcde", job.Name)
+	require.Equal(t, "example.com/myapp:v1.2.3", job.Spec.Template.Spec.Containers[0].Image)
+
+	_, err = parseJobManifest("apiVersion: v1\nkind: Pod\nmetadata:\n  name: myapp\n")
+	require.Error(t, err)
+}
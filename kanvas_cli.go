@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/davinci-std/kanvas/client"
+)
+
+// kanvasCLI runs the kanvas command like the client/cli package of kanvas does,
+// but also copies the progress output of kanvas, which is written to stderr, to an io.Writer
+// so that we can stream it to Slack while kanvas apply is running.
+type kanvasCLI struct {
+	// Command is the path to the kanvas command.
+	// Defaults to "kanvas".
+	Command []string
+}
+
+// Apply runs kanvas apply for the environment env using the configuration file at config,
+// which looks like path/to/kanvas.yaml.
+// output receives the progress output of kanvas, and can be nil.
+func (c kanvasCLI) Apply(ctx context.Context, config, env string, opts client.ApplyOptions, output io.Writer) (*client.ApplyResult, error) {
+	configDir, configName := filepath.Split(config)
+
+	args := []string{"--config", configName, "--env", env, "apply"}
+	if skip := opts.GetSkip(); skip != nil {
+		args = append(args, "--skip", strings.Join(skip, ","))
+	}
+	if skipped := opts.GetSkippedComponents(); skipped != nil {
+		b, err := json.Marshal(skipped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal skipped components: %w", err)
+		}
+		args = append(args, "--skipped-jobs-outputs", string(b))
+	}
+
+	bin := c.Command
+	if len(bin) == 0 {
+		bin = []string{"kanvas"}
+	}
+	args = append(append([]string{}, bin[1:]...), args...)
+
+	cmd := exec.CommandContext(ctx, bin[0], args...)
+	cmd.Dir = configDir
+	cmd.Env = commandEnv()
+	for k, v := range opts.GetEnvVars() {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if output != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, output)
+	}
+
+	if err := cmd.Run(); err != nil {
+		code := ErrCodePluginFailed
+		if errors.Is(err, exec.ErrNotFound) {
+			code = ErrCodePluginNotInstalled
+		}
+		return nil, withCode(code, fmt.Errorf("command kanvas %v: %w: %s", args, err, stderr.String()))
+	}
+
+	var r client.ApplyResult
+	if err := json.Unmarshal(stdout.Bytes(), &r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json: %w\n%s", err, stdout.String())
+	}
+	return &r, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/davinci-std/kanvas/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKanvasCLI_Apply(t *testing.T) {
+	// The script runs as "sh -c <script> kanvas --config ..." so that we can see the arguments kanvas receives
+	c := kanvasCLI{Command: []string{"sh", "-c", `echo "applying $2 $4" >&2; echo '{}'`, "kanvas"}}
+
+	var output bytes.Buffer
+	r, err := c.Apply(context.Background(), filepath.Join(t.TempDir(), "kanvas.yaml"), "production", client.ApplyOptions{}, &output)
+	require.NoError(t, err)
+	require.Empty(t, r.GetPullRequests())
+	require.Equal(t, "applying kanvas.yaml production\n", output.String())
+}
+
+func TestKanvasCLI_Apply_Error(t *testing.T) {
+	c := kanvasCLI{Command: []string{"sh", "-c", `echo "something went wrong" >&2; exit 1`, "kanvas"}}
+
+	var output bytes.Buffer
+	_, err := c.Apply(context.Background(), filepath.Join(t.TempDir(), "kanvas.yaml"), "staging", client.ApplyOptions{}, &output)
+	require.ErrorContains(t, err, "something went wrong")
+	require.Equal(t, "something went wrong\n", output.String())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+)
+
+// defaultKanvasSkippedComponents is the skipped components of the phases with no kanvas.skippedComponents.
+//
+// Any kanvas.yaml that can be used by gocat with the default needs to have
+// "image" component that uses the kanavs's docker provider for building the container image.
+//
+// We also assume that the components(like kustomize, argocd app, etc,) that depends on the "image" component uses
+// either the "tag" or the "id" output of the "image" component for the deployment.
+//
+// "prereq" is an opinionated convention that we use in gocat.
+// You can add any component named "prereq" in kanvas.yaml, and it is not used when triggered via gocat.
+var defaultKanvasSkippedComponents = map[string]map[string]string{
+	"image": {
+		"tag": "{{.Tag}}",
+		"id":  "{{.Tag}}",
+	},
+	"prereq": {},
+}
+
+// KanvasOption configures how the kanvas plugin runs kanvas apply for the phase,
+// so that kanvas.yaml not following the gocat conventions can be deployed too.
+//
+// The values of SkippedComponents and EnvVars are Go templates rendered with KanvasVars.
+//
+//	kanvas:
+//	  skippedComponents:
+//	    build:
+//	      image: "{{.Tag}}"
+//	    terraform-bootstrap: {}
+//	  envVars:
+//	    APP_VERSION: "{{.Tag}}"
+type KanvasOption struct {
+	// SkippedComponents is the map of the names of the components not to run to their outputs,
+	// which the components depending on them use instead.
+	// Defaults to defaultKanvasSkippedComponents.
+	SkippedComponents map[string]map[string]string `yaml:"skippedComponents"`
+	// EnvVars is the extra environment variables for kanvas.
+	// The ones gocat sets to run kanvas, like TMPDIR and GITHUB_TOKEN, can't be overridden.
+	EnvVars map[string]string `yaml:"envVars"`
+}
+
+// KanvasVars is the set of variables available in KanvasOption.
+type KanvasVars struct {
+	Project string
+	Phase   string
+	Branch  string
+	// Tag is the image tag to deploy.
+	Tag string
+}
+
+func (self KanvasVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+// SkippedComponentsFor returns the skipped components with the outputs rendered with vars.
+func (o KanvasOption) SkippedComponentsFor(vars KanvasVars) (map[string]map[string]string, error) {
+	components := o.SkippedComponents
+	if len(components) == 0 {
+		components = defaultKanvasSkippedComponents
+	}
+
+	skipped := map[string]map[string]string{}
+	for name, outputs := range components {
+		rendered, err := renderKanvasValues(outputs, vars)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outputs of the skipped component %s: %w", name, err)
+		}
+		skipped[name] = rendered
+	}
+	return skipped, nil
+}
+
+// EnvVarsFor returns the extra environment variables rendered with vars.
+func (o KanvasOption) EnvVarsFor(vars KanvasVars) (map[string]string, error) {
+	envVars, err := renderKanvasValues(o.EnvVars, vars)
+	if err != nil {
+		return nil, fmt.Errorf("invalid envVars: %w", err)
+	}
+	return envVars, nil
+}
+
+func renderKanvasValues(values map[string]string, vars KanvasVars) (map[string]string, error) {
+	rendered := map[string]string{}
+	for k, v := range values {
+		s, err := vars.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		rendered[k] = s
+	}
+	return rendered, nil
+}
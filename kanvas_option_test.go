@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKanvasOption(t *testing.T) {
+	vars := KanvasVars{Project: "myproject1", Phase: "production", Branch: "main", Tag: "v1.2.3"}
+
+	skipped, err := KanvasOption{}.SkippedComponentsFor(vars)
+	require.NoError(t, err)
+	require.Equal(t, map[string]map[string]string{
+		"image":  {"tag": "v1.2.3", "id": "v1.2.3"},
+		"prereq": {},
+	}, skipped)
+
+	o := KanvasOption{
+		SkippedComponents: map[string]map[string]string{
+			"build":     {"image": "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myproject1:{{.Tag}}"},
+			"bootstrap": {},
+		},
+		EnvVars: map[string]string{
+			"APP_VERSION": "{{.Tag}}",
+			"APP_ENV":     "{{.Phase}}",
+		},
+	}
+	skipped, err = o.SkippedComponentsFor(vars)
+	require.NoError(t, err)
+	require.Equal(t, map[string]map[string]string{
+		"build":     {"image": "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myproject1:v1.2.3"},
+		"bootstrap": {},
+	}, skipped)
+
+	envVars, err := o.EnvVarsFor(vars)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"APP_VERSION": "v1.2.3", "APP_ENV": "production"}, envVars)
+
+	_, err = KanvasOption{EnvVars: map[string]string{"APP_VERSION": "{{.Tag"}}.EnvVarsFor(vars)
+	require.Error(t, err)
+}
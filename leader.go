@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElector elects one of the gocat replicas as the leader with the Lease of the name in the namespace of the configmaps,
+// for the jobs only one replica should do, like posting the changes of the project configs every replica sees on reload.
+//
+// The methods are safe to call on nil, which is the leader all the time, as a single replica is.
+type LeaderElector struct {
+	lease    string
+	identity string
+	leading  int32
+}
+
+// NewLeaderElector returns the elector of the Lease, or nil if lease is empty.
+func NewLeaderElector(lease string) *LeaderElector {
+	if lease == "" {
+		return nil
+	}
+	identity, err := os.Hostname()
+	if err != nil || identity == "" {
+		identity = "gocat"
+	}
+	return &LeaderElector{lease: lease, identity: identity}
+}
+
+// IsLeader returns true if the replica is the leader now.
+func (l *LeaderElector) IsLeader() bool {
+	return l == nil || atomic.LoadInt32(&l.leading) == 1
+}
+
+// Start runs the election in the background for the lifetime of gocat.
+func (l *LeaderElector) Start() {
+	if l == nil {
+		return
+	}
+	client, err := newKubernetesClient()
+	if err != nil {
+		log.Printf("[ERROR] Unable to elect the leader with the Lease %s: %s", l.lease, err)
+		return
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  meta_v1.ObjectMeta{Name: l.lease, Namespace: configNamespace()},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: l.identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   30 * time.Second,
+		RenewDeadline:   20 * time.Second,
+		RetryPeriod:     5 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.Printf("[INFO] %s is the leader", l.identity)
+				atomic.StoreInt32(&l.leading, 1)
+			},
+			OnStoppedLeading: func() {
+				log.Printf("[INFO] %s is no longer the leader", l.identity)
+				atomic.StoreInt32(&l.leading, 0)
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("[ERROR] Unable to elect the leader with the Lease %s: %s", l.lease, err)
+		return
+	}
+	go func() {
+		// The election is run again whenever the replica loses the lease, as Run returns then
+		for {
+			elector.Run(context.Background())
+		}
+	}()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// defaultListRefreshInterval is the interval the lists are refreshed at unless CONFIG_LIST_REFRESH_INTERVAL is set.
+const defaultListRefreshInterval = 5 * time.Minute
+
+// ListRefresher refreshes the projects, the users of all the workspaces, and the command aliases in the background,
+// so that the commands read the cached lists instead of reloading them from Kubernetes, Slack, and GitHub on every message.
+// All the reloads, like the ones of the reload command, the config API, and the project add modal, go through Refresh,
+// so that they're serialized and the changes of the project configs are posted.
+//
+// The methods are safe to call on nil, which refreshes nothing.
+type ListRefresher struct {
+	projectList *ProjectList
+	workspaces  *SlackWorkspaces
+	aliases     *CommandAliasList
+	// mu serializes the refreshes, like the one the reload command forces during the one in the background.
+	mu sync.Mutex
+	// refreshed is when the lists were refreshed last.
+	refreshed time.Time
+	now       func() time.Time
+	// client posts the changes of the project configs to adminChannel. They aren't posted if adminChannel is empty.
+	client       *slack.Client
+	adminChannel string
+	// leader is the elector of the replica posting the changes, which every replica sees on its own reload.
+	leader *LeaderElector
+}
+
+func NewListRefresher(projectList *ProjectList, workspaces *SlackWorkspaces, aliases *CommandAliasList) *ListRefresher {
+	return &ListRefresher{projectList: projectList, workspaces: workspaces, aliases: aliases, now: time.Now}
+}
+
+// Refresh reloads all the lists immediately.
+func (r *ListRefresher) Refresh() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	before := r.projectList.Configs()
+	r.projectList.Reload()
+	r.postConfigDiff(before, r.projectList.Configs())
+	for _, ws := range r.workspaces.All() {
+		ws.userList.Reload()
+	}
+	r.aliases.Reload()
+	r.refreshed = r.now()
+}
+
+// postConfigDiff posts the summary of the changes of the project configs to the admin channel, if any.
+// Nothing is posted on the first load, which would list all the projects as added.
+// Only the leader posts it, whose refresh in the background sees the changes the other replicas reloaded first.
+func (r *ListRefresher) postConfigDiff(before, after map[string]map[string]string) {
+	if r.adminChannel == "" || before == nil || !r.leader.IsLeader() {
+		return
+	}
+	diff := diffProjectConfigs(before, after)
+	if diff.Empty() {
+		return
+	}
+	log.Printf("[INFO] The project configs changed: %+v", diff)
+	if _, _, err := r.client.PostMessage(r.adminChannel, slack.MsgOptionText(diff.Text(), false)); err != nil {
+		log.Println("[ERROR] Failed to post the changes of the project configs: ", err)
+	}
+}
+
+// refreshIfStale reloads the lists if they were refreshed longer than the interval ago.
+func (r *ListRefresher) refreshIfStale(interval time.Duration) bool {
+	r.mu.Lock()
+	stale := r.now().Sub(r.refreshed) >= interval
+	r.mu.Unlock()
+	if stale {
+		r.Refresh()
+	}
+	return stale
+}
+
+// Start loads the lists on start, as nothing else loads the users the commands read, and then watches them unless interval is zero.
+func (r *ListRefresher) Start(interval time.Duration) {
+	r.Refresh()
+	if interval > 0 {
+		r.Watch(interval)
+	}
+}
+
+// Watch refreshes the lists every interval in the background. The refresh the reload command forces postpones the next one.
+func (r *ListRefresher) Watch(interval time.Duration) {
+	log.Printf("[INFO] List Refresher is started. Interval is %s.", interval)
+	go func() {
+		// We don't stop the ticker as this is a long-running process
+		// with no way to cancel it.
+		t := time.NewTicker(interval / 10)
+		for range t.C {
+			r.refreshIfStale(interval)
+		}
+	}()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestListRefresher(t *testing.T) {
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	projectList := &ProjectList{items: []DeployProject{{ID: "myapp"}}}
+	r := NewListRefresher(projectList, nil, &CommandAliasList{})
+	r.now = func() time.Time { return now }
+	r.refreshed = now
+
+	require.False(t, r.refreshIfStale(5*time.Minute))
+	now = now.Add(5 * time.Minute)
+	require.True(t, r.refreshIfStale(5*time.Minute))
+	require.Equal(t, now, r.refreshed)
+	// The projects loaded before are kept when Kubernetes is unavailable
+	require.Equal(t, "myapp", projectList.Find("myapp").ID)
+
+	var nilRefresher *ListRefresher
+	require.NotPanics(t, nilRefresher.Refresh)
+
+	// Only the leader posts the changes, with the client nil here
+	require.True(t, (*LeaderElector)(nil).IsLeader())
+	r.adminChannel, r.leader = "C0123", &LeaderElector{}
+	require.False(t, r.leader.IsLeader())
+	require.NotPanics(t, func() {
+		r.postConfigDiff(map[string]map[string]string{}, map[string]map[string]string{"myapp": {"Kind": "kustomize"}})
+	})
+}
+
+func TestListRefresher_RefreshPostsConfigDiff(t *testing.T) {
+	var posts []string
+	slackFails := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		posts = append(posts, r.Form.Get("channel")+" "+r.Form.Get("text"))
+		if slackFails {
+			fmt.Fprint(w, `{"ok": false, "error": "channel_not_found"}`)
+			return
+		}
+		fmt.Fprint(w, `{"ok": true, "channel": "C0123", "ts": "1.1"}`)
+	}))
+	defer server.Close()
+
+	projectConfigMap := func(name string, data map[string]string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: configNamespace(), Labels: map[string]string{configMapTypeLabel: "project"}},
+			Data:       data,
+		}
+	}
+	clientset := fake.NewSimpleClientset(projectConfigMap("myapp", map[string]string{"Kind": "kustomize", "Phases": "- name: staging\n"}))
+	devKubernetesClient = clientset
+	defer func() { devKubernetesClient = nil }()
+
+	projectList := &ProjectList{}
+	r := NewListRefresher(projectList, nil, &CommandAliasList{})
+	r.client = slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/"))
+	r.adminChannel = "C0123"
+
+	// Nothing is posted on the first load
+	r.Refresh()
+	require.Equal(t, "myapp", projectList.Find("myapp").ID)
+	require.Empty(t, posts)
+
+	configMaps := clientset.CoreV1().ConfigMaps(configNamespace())
+	_, err := configMaps.Update(context.Background(), projectConfigMap("myapp", map[string]string{"Kind": "kustomize", "Phases": "- name: staging\n- name: production\n"}), meta_v1.UpdateOptions{})
+	require.NoError(t, err)
+	_, err = configMaps.Create(context.Background(), projectConfigMap("worker", map[string]string{"Kind": "kustomize"}), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+	r.Refresh()
+	require.Equal(t, []string{"C0123 :memo: The project configs changed on reload\n" +
+		"• *worker* added\n" +
+		"• *myapp* changed: Phases (added: production)"}, posts)
+
+	// The refresh with no change posts nothing
+	r.Refresh()
+	require.Len(t, posts, 1)
+
+	// The projects are reloaded even if the changes fail to be posted
+	slackFails = true
+	require.NoError(t, configMaps.Delete(context.Background(), "worker", meta_v1.DeleteOptions{}))
+	r.Refresh()
+	require.Len(t, posts, 2)
+	require.Empty(t, projectList.Find("worker").ID)
+
+	// The projects loaded before are kept, and nothing is posted, when Kubernetes fails
+	clientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	r.Refresh()
+	require.Len(t, posts, 2)
+	require.Equal(t, "myapp", projectList.Find("myapp").ID)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/slack-go/slack"
+)
+
+// messageTemplateSpec is the variables of the template of a message, which the template is checked with when it's loaded.
+type messageTemplateSpec struct {
+	vars []string
+	// buttons are the variables of the values of the buttons the template must have, like the one approving the deploy,
+	// without which the message can't be acted on.
+	buttons []string
+}
+
+// messageTemplateSpecs are the messages whose Block Kit layouts can be overridden by the templates.
+var messageTemplateSpecs = map[string]messageTemplateSpec{
+	// help is the help command, which has no variables.
+	"help": {},
+	// deploy_confirmation is the message asking to approve the deploy pull request. See deployConfirmationVars.
+	"deploy_confirmation": {
+		vars:    []string{"Requester", "Project", "Repository", "Phase", "Branch", "Message", "PullRequestURL", "TraceID", "ApproveValue", "CloseValue"},
+		buttons: []string{"ApproveValue", "CloseValue"},
+	},
+	// announcement is the summary of a production deploy in the announcement channel. See Announcer.Announce.
+	"announcement": {vars: []string{"Project", "Phase", "Tag", "PreviousTag", "Requester", "Link", "Changelog", "Reason", "TraceID"}},
+	// deploy_notification is the success of a deploy in the notifyChannel of the phase. See deployNotificationVars.
+	"deploy_notification": {vars: deployNotificationVarNames},
+	// deploy_failure is the failure of a deploy in the notifyChannel of the phase. See deployNotificationVars.
+	"deploy_failure": {vars: deployNotificationVarNames},
+	// manual_deploy asks to deploy the tag out of the autoDeployConstraint of the phase. See deployNotificationVars.
+	"manual_deploy": {vars: deployNotificationVarNames},
+}
+
+// messageTemplateNamesText lists the names of the messages for the errors.
+const messageTemplateNamesText = "help, deploy_confirmation, announcement, deploy_notification, deploy_failure, and manual_deploy"
+
+// MessageTemplates are the Block Kit layouts of the messages loaded from CONFIG_MESSAGE_TEMPLATE_DIR,
+// to brand or localize the messages without changing gocat.
+//
+// Each template is a JSON file named after the message, like deploy_confirmation.json, which is either the array of the blocks
+// or the object with the blocks like the Block Kit Builder exports. The template for a locale of the user preferences, like
+// deploy_confirmation.en.json, takes precedence for the users of the locale.
+//
+// The files are Go templates, where the variables like {{.Project}} are escaped for JSON strings, so they're
+// meant to be used inside the strings of the JSON. The messages without the templates keep the built-in layouts.
+//
+// The methods are safe to call on nil, which has no templates.
+type MessageTemplates struct {
+	// templates are keyed by the name of the message, followed by the locale if any, like deploy_confirmation.en.
+	templates map[string]*template.Template
+}
+
+// LoadMessageTemplates parses the templates in the directory. It fails on the files other than the templates of the known messages,
+// which are likely typos, so that they don't silently fall back to the built-in layouts.
+func LoadMessageTemplates(dir string) (*MessageTemplates, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	t := &MessageTemplates{templates: map[string]*template.Template{}}
+	for _, file := range files {
+		key := strings.TrimSuffix(filepath.Base(file), ".json")
+		name, _, _ := strings.Cut(key, ".")
+		spec, ok := messageTemplateSpecs[name]
+		if !ok {
+			return nil, fmt.Errorf("%s is not the template of any message. The messages are %s", file, messageTemplateNamesText)
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", file, err)
+		}
+		t.templates[key] = tmpl
+		if err := t.check(key, spec); err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", file, err)
+		}
+	}
+	return t, nil
+}
+
+// check renders the template with the placeholders of the variables of the message, so that the template
+// referring to an unknown variable, rendering invalid Block Kit JSON, or missing the buttons fails on load, not when it's posted.
+func (t *MessageTemplates) check(key string, spec messageTemplateSpec) error {
+	vars := map[string]string{}
+	for _, v := range spec.vars {
+		vars[v] = "gocat-template-" + v
+	}
+	name, locale, _ := strings.Cut(key, ".")
+	blocks, _, err := t.Blocks(name, locale, vars)
+	if err != nil {
+		return err
+	}
+	values := map[string]bool{}
+	for _, b := range blocks {
+		for _, button := range blockButtons(b) {
+			values[button.Value] = true
+		}
+	}
+	for _, v := range spec.buttons {
+		if !values[vars[v]] {
+			return fmt.Errorf("the message must have the button whose value is {{.%s}}", v)
+		}
+	}
+	return nil
+}
+
+// blockButtons returns the buttons in the block, either the accessory of the section or the elements of the actions.
+func blockButtons(b slack.Block) []*slack.ButtonBlockElement {
+	var buttons []*slack.ButtonBlockElement
+	switch b := b.(type) {
+	case *slack.SectionBlock:
+		if b.Accessory != nil && b.Accessory.ButtonElement != nil {
+			buttons = append(buttons, b.Accessory.ButtonElement)
+		}
+	case *slack.ActionBlock:
+		if b.Elements != nil {
+			for _, e := range b.Elements.ElementSet {
+				if button, ok := e.(*slack.ButtonBlockElement); ok {
+					buttons = append(buttons, button)
+				}
+			}
+		}
+	}
+	return buttons
+}
+
+// Blocks renders the template of the message in the locale with the variables.
+// It returns false if there's no template for the message, in which case the caller uses its built-in layout.
+func (t *MessageTemplates) Blocks(name, locale string, vars map[string]string) ([]slack.Block, bool, error) {
+	if t == nil {
+		return nil, false, nil
+	}
+	tmpl, ok := t.templates[name+"."+locale]
+	if !ok {
+		if tmpl, ok = t.templates[name]; !ok {
+			return nil, false, nil
+		}
+	}
+	escaped := map[string]string{}
+	for k, v := range vars {
+		b, _ := json.Marshal(v)
+		escaped[k] = string(b[1 : len(b)-1])
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, escaped); err != nil {
+		return nil, true, err
+	}
+	rendered := bytes.TrimSpace(buf.Bytes())
+	var blocks slack.Blocks
+	if bytes.HasPrefix(rendered, []byte("{")) {
+		var message struct {
+			Blocks slack.Blocks `json:"blocks"`
+		}
+		if err := json.Unmarshal(rendered, &message); err != nil {
+			return nil, true, fmt.Errorf("the template %s rendered invalid Block Kit JSON: %w", tmpl.Name(), err)
+		}
+		blocks = message.Blocks
+	} else if err := json.Unmarshal(rendered, &blocks); err != nil {
+		return nil, true, fmt.Errorf("the template %s rendered invalid Block Kit JSON: %w", tmpl.Name(), err)
+	}
+	return blocks.BlockSet, true, nil
+}
+
+// BlocksOr renders the template of the message like Blocks, and returns the built-in layout if there's no template,
+// or if the template fails to render, which is logged as it's a mistake of the template.
+func (t *MessageTemplates) BlocksOr(name, locale string, vars map[string]string, builtin func() []slack.Block) []slack.Block {
+	blocks, ok, err := t.Blocks(name, locale, vars)
+	if err != nil {
+		log.Printf("[ERROR] Failed to render the template of %s, falling back to the built-in layout: %s", name, err)
+		return builtin()
+	}
+	if !ok {
+		return builtin()
+	}
+	return blocks
+}
+
+// MessageOr is BlocksOr for the messages whose built-in layouts are the attachments, like the notifications.
+// It returns the option posting the blocks of the template, or the built-in message.
+func (t *MessageTemplates) MessageOr(name, locale string, vars map[string]string, builtin func() slack.MsgOption) slack.MsgOption {
+	var msg slack.MsgOption
+	blocks := t.BlocksOr(name, locale, vars, func() []slack.Block {
+		msg = builtin()
+		return nil
+	})
+	if msg != nil {
+		return msg
+	}
+	return slack.MsgOptionBlocks(blocks...)
+}
+
+// deployConfirmationVars are the variables of the deploy_confirmation template:
+//
+//   - Requester, the Slack user ID of the requester, to be mentioned like <@{{.Requester}}>
+//   - Project, Repository, Phase, and Branch of the deploy
+//   - Message, the confirmation in the locale of the requester
+//   - PullRequestURL, the pull request to approve
+//   - TraceID, the ID of the deploy timeline
+//   - ApproveValue and CloseValue, the values of the buttons to approve and close the deploy, which the template must have
+func deployConfirmationVars(requester string, pj DeployProject, phase, branch, message, prURL, trace, approveValue, closeValue string) map[string]string {
+	return map[string]string{
+		"Requester":      requester,
+		"Project":        pj.ID,
+		"Repository":     pj.GitHubRepository(),
+		"Phase":          phase,
+		"Branch":         branch,
+		"Message":        message,
+		"PullRequestURL": prURL,
+		"TraceID":        trace,
+		"ApproveValue":   approveValue,
+		"CloseValue":     closeValue,
+	}
+}
+
+// deployNotificationVarNames are the variables of the notifications in the notifyChannel of the phase:
+//
+//   - Project, Phase, and Tag of the deploy
+//   - Requester, the Slack user ID of the requester, or AutoDeploy
+//   - Link, the URL of the deploy, like the one of the pull request, which can be empty
+//   - TraceID, the ID of the deploy timeline, which can be empty
+//   - Error, why the deploy failed, for deploy_failure
+//   - Mentions, the mentions of onFailure of the phase, for deploy_failure
+//   - Constraint, the autoDeployConstraint of the phase the tag is out of, for manual_deploy
+var deployNotificationVarNames = []string{"Project", "Phase", "Tag", "Requester", "Link", "TraceID", "Error", "Mentions", "Constraint"}
+
+// deployNotificationVars returns the variables of the notifications with the ones not given empty,
+// so that the templates can refer to all of them.
+func deployNotificationVars(vars map[string]string) map[string]string {
+	all := map[string]string{}
+	for _, name := range deployNotificationVarNames {
+		all[name] = vars[name]
+	}
+	return all
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func writeMessageTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestMessageTemplates(t *testing.T) {
+	dir := writeMessageTemplates(t, map[string]string{
+		"deploy_confirmation.json":    `[{"type":"section","text":{"type":"mrkdwn","text":"Deploy {{.Project}} to {{.Phase}}?"}}` + confirmationButtons + `]`,
+		"deploy_confirmation.ja.json": `[{"type":"section","text":{"type":"mrkdwn","text":"{{.Project}} を {{.Phase}} にデプロイしますか?"}}` + confirmationButtons + `]`,
+		"announcement.json":           `{"blocks":[{"type":"section","text":{"type":"mrkdwn","text":"{{.Project}}: {{.Reason}}"}}]}`,
+	})
+	templates, err := LoadMessageTemplates(dir)
+	require.NoError(t, err)
+
+	text := func(blocks []slack.Block) string {
+		require.NotEmpty(t, blocks)
+		return blocks[0].(*slack.SectionBlock).Text.Text
+	}
+
+	// The template of the locale takes precedence
+	vars := map[string]string{"Project": "api", "Phase": "staging", "ApproveValue": "approve", "CloseValue": "close"}
+	blocks, ok, err := templates.Blocks("deploy_confirmation", "ja", vars)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "api を staging にデプロイしますか?", text(blocks))
+	require.Equal(t, "close", blocks[1].(*slack.ActionBlock).Elements.ElementSet[1].(*slack.ButtonBlockElement).Value)
+	blocks, _, err = templates.Blocks("deploy_confirmation", "en", vars)
+	require.NoError(t, err)
+	require.Equal(t, "Deploy api to staging?", text(blocks))
+
+	// The variables are escaped for JSON strings, and the object exported by the Block Kit Builder is accepted
+	blocks, _, err = templates.Blocks("announcement", "", map[string]string{"Project": "api", "Reason": "fix \"the\" bug\nurgently"})
+	require.NoError(t, err)
+	require.Equal(t, "api: fix \"the\" bug\nurgently", text(blocks))
+
+	// The missing variables fail the template, so the built-in layout is used
+	builtin := func() []slack.Block {
+		return []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", "built-in", false, false), nil, nil)}
+	}
+	_, _, err = templates.Blocks("deploy_confirmation", "", map[string]string{"Project": "api"})
+	require.Error(t, err)
+	require.Equal(t, "built-in", text(templates.BlocksOr("deploy_confirmation", "", map[string]string{"Project": "api"}, builtin)))
+
+	// The messages without the templates, and nil, keep the built-in layouts
+	_, ok, err = templates.Blocks("help", "en", nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+	var none *MessageTemplates
+	require.Equal(t, "built-in", text(none.BlocksOr("help", "en", nil, builtin)))
+}
+
+// confirmationButtons are the buttons the deploy_confirmation template must have.
+const confirmationButtons = `,{"type":"actions","elements":[` +
+	`{"type":"button","text":{"type":"plain_text","text":"Deploy"},"value":"{{.ApproveValue}}"},` +
+	`{"type":"button","text":{"type":"plain_text","text":"Close"},"value":"{{.CloseValue}}"}]}`
+
+func TestLoadMessageTemplates_Invalid(t *testing.T) {
+	_, err := LoadMessageTemplates(writeMessageTemplates(t, map[string]string{"deploy_confirm.json": `[]`}))
+	require.Error(t, err)
+	_, err = LoadMessageTemplates(writeMessageTemplates(t, map[string]string{"help.json": `[{{.Project]`}))
+	require.Error(t, err)
+	// The unknown variables, and the deploy confirmation without the buttons to approve and close it, fail on load
+	_, err = LoadMessageTemplates(writeMessageTemplates(t, map[string]string{"announcement.json": `[{"type":"section","text":{"type":"mrkdwn","text":"{{.Projcet}}"}}]`}))
+	require.Error(t, err)
+	_, err = LoadMessageTemplates(writeMessageTemplates(t, map[string]string{"deploy_confirmation.json": `[{"type":"section","text":{"type":"mrkdwn","text":"{{.Project}}"}}]`}))
+	require.ErrorContains(t, err, "the message must have the button whose value is {{.ApproveValue}}")
+	_, err = LoadMessageTemplates(writeMessageTemplates(t, map[string]string{"deploy_notification.json": `[{"type":"section","text":{"type":"mrkdwn","text":"{{.Project}} {{.Tag}} {{.Link}}"}}]`}))
+	require.NoError(t, err)
+}
+
+func TestMessageTemplates_MessageOr(t *testing.T) {
+	dir := writeMessageTemplates(t, map[string]string{
+		"deploy_notification.json": `[{"type":"section","text":{"type":"mrkdwn","text":"{{.Project}} {{.Phase}}: {{.Tag}}"}}]`,
+	})
+	templates, err := LoadMessageTemplates(dir)
+	require.NoError(t, err)
+
+	builtinCalled := false
+	builtin := func() slack.MsgOption {
+		builtinCalled = true
+		return slack.MsgOptionText("built-in", false)
+	}
+	vars := deployNotificationVars(map[string]string{"Project": "api", "Phase": "production", "Tag": "v1.2.3"})
+	_, values, err := slack.UnsafeApplyMsgOptions("xoxb-test", "C1", "https://slack.com/api/", templates.MessageOr("deploy_notification", "", vars, builtin))
+	require.NoError(t, err)
+	require.False(t, builtinCalled)
+	require.Contains(t, values.Get("blocks"), "api production: v1.2.3")
+
+	_, values, err = slack.UnsafeApplyMsgOptions("xoxb-test", "C1", "https://slack.com/api/", templates.MessageOr("deploy_failure", "", vars, builtin))
+	require.NoError(t, err)
+	require.True(t, builtinCalled)
+	require.Equal(t, "built-in", values.Get("text"))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// DeployMetadata is the machine-readable summary of a deploy
+// that gocat embeds into the body of each deploy pull request.
+//
+// It's embedded as an HTML comment so that it's invisible on GitHub,
+// and parsed back by gocat later, for example to rollback to the previous tag.
+type DeployMetadata struct {
+	Project     string `json:"project"`
+	Phase       string `json:"phase"`
+	Branch      string `json:"branch"`
+	PreviousTag string `json:"previousTag"`
+	Tag         string `json:"tag"`
+	Requester   string `json:"requester"`
+	// RequesterSlackID is the Slack user ID of the requester, used to enforce the twoPersonRule of the phase.
+	RequesterSlackID string `json:"requesterSlackId,omitempty"`
+	// StackedRequesterSlackIDs are the Slack user IDs of the requesters of the deploys stacked before by stackDeploys,
+	// which the pull request ships together with this one.
+	StackedRequesterSlackIDs []string `json:"stackedRequesterSlackIds,omitempty"`
+	// SlackChannel and SlackThreadTS identify the Slack message the deploy was requested in.
+	// They are used to post back to the Slack thread when the pull request is merged or closed on GitHub.
+	SlackChannel  string `json:"slackChannel,omitempty"`
+	SlackThreadTS string `json:"slackThreadTs,omitempty"`
+	// Reason is the reason of the deploy given by the requester.
+	Reason string `json:"reason,omitempty"`
+	// TraceID is the correlation ID of the deploy. See DeployTrace.
+	TraceID string `json:"traceId,omitempty"`
+	// Kind is what the pull request changes other than the images, like redeploy for the replays of the archived deploys.
+	// It's empty for the deploys, which are the only ones stacked by stackDeploys.
+	Kind string `json:"kind,omitempty"`
+}
+
+// The kinds of the pull requests gocat opens other than the deploys. See DeployMetadata.Kind.
+const (
+	DeployKindRedeploy       = "redeploy"
+	DeployKindRollback       = "rollback"
+	DeployKindSecretRotation = "secret-rotation"
+	DeployKindEnv            = "env"
+	// DeployKindBlueGreenSwitch is the switch of the live color of the blue/green phase. See BlueGreenOption.
+	DeployKindBlueGreenSwitch = "blue-green-switch"
+)
+
+const deployMetadataPrefix = "<!-- gocat:metadata "
+
+var deployMetadataPattern = regexp.MustCompile(`<!-- gocat:metadata (\{.*\}) -->`)
+
+// String returns the metadata as an HTML comment to be embedded into the pull request body.
+func (m DeployMetadata) String() string {
+	b, _ := json.Marshal(m)
+	return deployMetadataPrefix + string(b) + " -->"
+}
+
+// RequesterSlackIDs returns the Slack user IDs of the requesters of all the deploys the pull request ships,
+// including the ones stacked before.
+func (m DeployMetadata) RequesterSlackIDs() []string {
+	var ids []string
+	for _, id := range append(append([]string{}, m.StackedRequesterSlackIDs...), m.RequesterSlackID) {
+		if id != "" && !containsString(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PullRequestFooter returns what's appended to the body of the deploy pull request,
+// which is the trace ID visible on GitHub, if any, and the metadata.
+func (m DeployMetadata) PullRequestFooter() string {
+	if m.TraceID == "" {
+		return m.String()
+	}
+	return fmt.Sprintf("gocat trace: `%s`\n\n%s", m.TraceID, m.String())
+}
+
+// ParseDeployMetadata finds and parses the metadata embedded in the pull request body.
+func ParseDeployMetadata(body string) (DeployMetadata, error) {
+	var m DeployMetadata
+	match := deployMetadataPattern.FindStringSubmatch(body)
+	if match == nil {
+		return m, fmt.Errorf("gocat metadata not found in the pull request body")
+	}
+	if err := json.Unmarshal([]byte(match[1]), &m); err != nil {
+		return m, fmt.Errorf("unable to parse gocat metadata: %w", err)
+	}
+	return m, nil
+}
+
+// ReplaceDeployMetadata replaces the metadata embedded in the pull request body with m.
+// m is appended to the body if the body has no metadata yet.
+func ReplaceDeployMetadata(body string, m DeployMetadata) string {
+	if !deployMetadataPattern.MatchString(body) {
+		return body + "\n\n" + m.String()
+	}
+	return deployMetadataPattern.ReplaceAllLiteralString(body, m.String())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeployMetadata(t *testing.T) {
+	want := DeployMetadata{
+		Project:     "myapp",
+		Phase:       "production",
+		Branch:      "master",
+		PreviousTag: "aaaaaaa",
+		Tag:         "bbbbbbb",
+		Requester:   "alice",
+	}
+	body := "`aaaaaaa` → `bbbbbbb`\n\n*Commit Log*\n- fix\n\n" + want.String()
+
+	got, err := ParseDeployMetadata(body)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	_, err = ParseDeployMetadata("from bot\n\nno metadata")
+	require.Error(t, err)
+}
+
+func TestReplaceDeployMetadata(t *testing.T) {
+	m := DeployMetadata{Project: "myapp", Phase: "production", Tag: "bbbbbbb"}
+	body := "description\n\n" + m.String()
+
+	m.SlackChannel = "C0123"
+	m.SlackThreadTS = "1700000000.000100"
+	replaced := ReplaceDeployMetadata(body, m)
+	require.Equal(t, "description\n\n"+m.String(), replaced)
+
+	got, err := ParseDeployMetadata(replaced)
+	require.NoError(t, err)
+	require.Equal(t, m, got)
+
+	require.Equal(t, "description\n\n"+m.String(), ReplaceDeployMetadata("description", m))
+}
+
+func TestDeployMetadataPullRequestFooter(t *testing.T) {
+	m := DeployMetadata{Project: "myapp", Phase: "production", Tag: "bbbbbbb"}
+	require.Equal(t, m.String(), m.PullRequestFooter())
+
+	m.TraceID = "1a2b3c4d"
+	require.Equal(t, "gocat trace: `1a2b3c4d`\n\n"+m.String(), m.PullRequestFooter())
+	got, err := ParseDeployMetadata("description\n\n" + m.PullRequestFooter())
+	require.NoError(t, err)
+	require.Equal(t, m, got)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrMigrationPending is returned when the deploy pull request can't be merged yet
+// as the database migrations the new image tag needs aren't applied.
+var ErrMigrationPending = errors.New("migration pending")
+
+// MigrationGate holds the merge of the deploy pull requests of a phase until the database migrations are applied,
+// so that the new image never runs against the old schema.
+//
+// It's checked when the deploy is approved in Slack, before AutoDeploy merges its pull request,
+// and before the phases with the direct commit strategy push their commits.
+type MigrationGate struct {
+	// CheckURL is the URL that responds with a 2xx status once the migrations the tag needs are applied,
+	// and with any other status, along with the pending migrations in the body, otherwise.
+	// It's a Go template rendered with MigrationGateVars, like https://myapp.internal/migrations?tag={{.Tag}}.
+	CheckURL string `yaml:"checkURL"`
+	// Job is the name of the job of the phase, with apply enabled, that applies the migrations.
+	// gocat runs it with the new tag and waits for it to succeed when CheckURL reports pending migrations,
+	// or before every merge if CheckURL is empty.
+	Job string `yaml:"job"`
+}
+
+func (g MigrationGate) Enabled() bool {
+	return g.CheckURL != "" || g.Job != ""
+}
+
+// MigrationGateVars is the set of variables available in MigrationGate.CheckURL.
+type MigrationGateVars struct {
+	Project string
+	Phase   string
+	Tag     string
+}
+
+func (self MigrationGateVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+// migrationGateRunner runs the migration gate of a phase.
+type migrationGateRunner struct {
+	jobRunner  JobRunner
+	httpClient *http.Client
+	// watch waits for the Job to complete. It's replaced in tests.
+	watch func(name, namespace string) error
+}
+
+func newMigrationGateRunner(github *GitHub, git *GitOperator) migrationGateRunner {
+	return migrationGateRunner{
+		jobRunner:  NewJobRunner(github, git),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		watch:      NewModelJob(github).Watch,
+	}
+}
+
+// Check returns nil if the migrations the tag needs are applied, or ErrMigrationPending otherwise.
+// A gate with no CheckURL always reports the migrations as pending, as only its job can tell.
+func (r migrationGateRunner) Check(pj DeployProject, phase DeployPhase, tag string) error {
+	gate := phase.MigrationGate
+	if gate.CheckURL == "" {
+		return fmt.Errorf("%w: %s %s runs the job %s before each deploy", ErrMigrationPending, pj.ID, phase.Name, gate.Job)
+	}
+	url, err := MigrationGateVars{Project: pj.ID, Phase: phase.Name, Tag: tag}.Parse(gate.CheckURL)
+	if err != nil {
+		return fmt.Errorf("unable to render the checkURL of the migration gate of %s %s: %w", pj.ID, phase.Name, err)
+	}
+	resp, err := r.httpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("unable to check the migrations of %s %s: %w", pj.ID, phase.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+	detail := strings.TrimSpace(string(b))
+	if detail == "" {
+		detail = resp.Status
+	}
+	return fmt.Errorf("%w: %s %s with `%s`: %s", ErrMigrationPending, pj.ID, phase.Name, tag, detail)
+}
+
+// Run makes sure the migrations the tag needs are applied, running the job of the gate with the tag if they are pending.
+// progress is called with the message of each step to report it in the Slack thread or the log.
+func (r migrationGateRunner) Run(pj DeployProject, phase DeployPhase, tag string, progress func(string)) error {
+	gate := phase.MigrationGate
+	err := r.Check(pj, phase, tag)
+	if err == nil {
+		progress(fmt.Sprintf("The migrations of %s %s with `%s` are applied", pj.ID, phase.Name, tag))
+		return nil
+	}
+	if !errors.Is(err, ErrMigrationPending) || gate.Job == "" {
+		return err
+	}
+	if gate.CheckURL != "" {
+		progress(err.Error())
+	}
+
+	job, ok := phase.FindJob(gate.Job)
+	if !ok {
+		return fmt.Errorf("the migration job %s is not found in %s %s", gate.Job, pj.ID, phase.Name)
+	}
+	if !job.Apply {
+		return fmt.Errorf("the migration job %s of %s %s must be applied for gocat to wait for it", job.Name, pj.ID, phase.Name)
+	}
+	o, err := r.jobRunner.Run(pj, phase, job, tag)
+	if err != nil {
+		return err
+	}
+	progress(fmt.Sprintf("Running the migration job %s/%s with `%s`", o.Namespace, o.Name, tag))
+	if err := r.watch(o.Name, o.Namespace); err != nil {
+		return fmt.Errorf("the migration job %s/%s failed: %w", o.Namespace, o.Name, err)
+	}
+	progress(fmt.Sprintf("The migration job %s/%s succeeded", o.Namespace, o.Name))
+
+	if gate.CheckURL == "" {
+		return nil
+	}
+	return r.Check(pj, phase, tag)
+}
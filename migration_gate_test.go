@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationGateRunnerCheck(t *testing.T) {
+	var gotTag string
+	applied := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTag = r.URL.Query().Get("tag")
+		if !applied {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte("20240101_add_users_email"))
+		}
+	}))
+	defer srv.Close()
+
+	r := migrationGateRunner{httpClient: srv.Client()}
+	pj := DeployProject{ID: "myapp"}
+	phase := DeployPhase{Name: "production", MigrationGate: MigrationGate{CheckURL: srv.URL + "/migrations?tag={{.Tag}}"}}
+
+	err := r.Check(pj, phase, "v1.2.3")
+	require.True(t, errors.Is(err, ErrMigrationPending))
+	require.Contains(t, err.Error(), "20240101_add_users_email")
+	require.Equal(t, "v1.2.3", gotTag)
+
+	applied = true
+	require.NoError(t, r.Check(pj, phase, "v1.2.3"))
+}
+
+func TestMigrationGateRunnerRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer srv.Close()
+
+	r := migrationGateRunner{httpClient: srv.Client()}
+	pj := DeployProject{ID: "myapp"}
+	var progress []string
+	report := func(text string) { progress = append(progress, text) }
+
+	// Pending with no job to apply the migrations
+	phase := DeployPhase{Name: "production", MigrationGate: MigrationGate{CheckURL: srv.URL}}
+	require.True(t, errors.Is(r.Run(pj, phase, "v1.2.3", report), ErrMigrationPending))
+	require.Empty(t, progress)
+
+	phase.MigrationGate.Job = "migrate"
+	require.EqualError(t, r.Run(pj, phase, "v1.2.3", report), "the migration job migrate is not found in myapp production")
+
+	phase.Jobs = []PhaseJob{{Name: "migrate", Template: "jobs/migrate.yaml", Path: "jobs/migrate-{{.Suffix}}.yaml"}}
+	require.EqualError(t, r.Run(pj, phase, "v1.2.3", report), "the migration job migrate of myapp production must be applied for gocat to wait for it")
+	require.Len(t, progress, 2)
+}
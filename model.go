@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io"
+
+	"sigs.k8s.io/kustomize/api/types"
 )
 
 // DeployModel, or more simply, a deploy model, is a model that can be deployed.
@@ -25,6 +28,23 @@ type DeployOption struct {
 	Assigner User
 	Tag      string
 	Wait     bool
+	// SlackURL is the permalink of the Slack message the deploy is requested in, which the deploy commit and pull request link to.
+	// It's empty for deploys not triggered via Slack, like AutoDeploy, and for the requests whose message is posted after the pull request
+	// is opened, which linkSlackThread links to the pull request once it's posted.
+	SlackURL string
+	// Reason is the reason of the deploy, like a ticket reference, required by the reasonPolicy of the phase.
+	Reason string
+	// Output receives the progress output of the deploy tool, like kanvas, if the plugin runs one.
+	// It can be nil.
+	Output io.Writer
+	// TraceID is the correlation ID of the deploy. See DeployTrace.
+	TraceID string
+	// Gate is called with the metadata of the deploy before the plugin ships it with nothing to approve, like by the direct commit,
+	// which is aborted if it returns an error. It's nil for AutoDeploy, which checks the deploys by itself.
+	Gate func(DeployMetadata) error
+	// Images are the images the failed Prepare of the deploy being retried resolved the tags and the digests of,
+	// which are deployed as they are instead of being resolved again, so that the retry never ships newer images than requested.
+	Images []types.Image
 }
 
 type DeployStatus uint
@@ -49,22 +69,22 @@ type DeployOutput interface {
 type DeployModelList map[string]DeployModel
 
 func NewDeployModelList(github *GitHub, git *GitOperator, projectList *ProjectList) *DeployModelList {
-	return &DeployModelList{
-		"lambda":    NewModelLambda(),
-		"kustomize": NewModelKustomize(github, git),
-		"kanvas":    NewModelKanvas(github, git),
-		"combine":   NewModelCombine(github, git, projectList),
-		"job":       NewModelJob(github),
-	}
+	l := NewDeployModelListWithoutCombine(github, git)
+	(*l)["combine"] = NewModelCombine(github, git, projectList)
+	return l
 }
 
+// NewDeployModelListWithoutCombine returns the models of all the kinds but combine,
+// including the ModelGitOps for each registered GitOpsPlugin.
 func NewDeployModelListWithoutCombine(github *GitHub, git *GitOperator) *DeployModelList {
-	return &DeployModelList{
-		"lambda":    NewModelLambda(),
-		"kustomize": NewModelKustomize(github, git),
-		"kanvas":    NewModelKanvas(github, git),
-		"job":       NewModelJob(github),
+	l := DeployModelList{
+		"lambda": NewModelLambda(),
+		"job":    NewModelJob(github),
+	}
+	for name, f := range gitOpsPlugins {
+		l[name] = ModelGitOps{github: github, git: git, plugin: f(github, git)}
 	}
+	return &l
 }
 
 func (self DeployModelList) Find(kind string) (DeployModel, error) {
@@ -1,5 +1,12 @@
 package main
 
+import (
+	"fmt"
+	"log"
+
+	"sigs.k8s.io/kustomize/api/types"
+)
+
 type ModelGitOps struct {
 	github *GitHub
 	git    *GitOperator
@@ -16,7 +23,29 @@ type GitOpsPrepareOutput struct {
 	PullRequestNumber  int
 	PullRequestHTMLURL string
 	Branch             string
-	status             DeployStatus
+	// CommitSHA and CommitHTMLURL identify the commit pushed straight to the default branch
+	// by the phases whose commitStrategy is direct, in which case no pull request is created
+	// and the deploy is already done.
+	CommitSHA     string
+	CommitHTMLURL string
+	// Metadata is the metadata of the deploy, which is embedded into the pull request body,
+	// or kept only here for the direct commit, which has no pull request body to embed it into.
+	Metadata DeployMetadata
+	// Artifacts are the files archived to reconstruct what the deploy shipped, like the diff and the manifests, keyed by their names.
+	Artifacts map[string][]byte
+	// SBOMSummary summarizes the SBOMs of the images of the deploy, which are archived in Artifacts.
+	SBOMSummary string
+	// Images are the images whose tags Prepare resolved, which are set even if it fails after resolving them. See DeployOption.Images.
+	Images []types.Image
+	// Stacked is the metadata of the deploy the pull request had before stackDeploys stacked this one onto it,
+	// whose approval message no longer holds.
+	Stacked *DeployMetadata
+	status  DeployStatus
+}
+
+// Direct returns true if the change was pushed straight to the default branch with no pull request to merge.
+func (self GitOpsPrepareOutput) Direct() bool {
+	return self.CommitSHA != ""
 }
 
 func (self GitOpsPrepareOutput) Status() DeployStatus {
@@ -33,11 +62,14 @@ func (self ModelGitOps) Commit(pullRequestID string) error {
 }
 
 func (self ModelGitOps) Deploy(pj DeployProject, phase string, option DeployOption) (do DeployOutput, err error) {
-	o, err := self.plugin.Prepare(pj, phase, option.Branch, option.Assigner, option.Tag)
+	o, err := self.plugin.Prepare(pj, phase, option)
 	if err != nil {
 		return
 	}
-	if o.Status() == DeployStatusSuccess {
+	if o.Status() == DeployStatusSuccess && !o.Direct() {
+		if err = self.checkMigrationGate(pj, phase, o); err != nil {
+			return
+		}
 		err = self.Commit(o.PullRequestID)
 		if err != nil {
 			return
@@ -45,3 +77,23 @@ func (self ModelGitOps) Deploy(pj DeployProject, phase string, option DeployOpti
 	}
 	return o, nil
 }
+
+// checkMigrationGate runs the migration gate of the phase, if any, with the tag of the pull request before it's merged.
+// The pull request is left open if the migrations aren't applied.
+func (self ModelGitOps) checkMigrationGate(pj DeployProject, phase string, o GitOpsPrepareOutput) error {
+	p := pj.FindPhase(phase)
+	if !p.MigrationGate.Enabled() {
+		return nil
+	}
+	body, err := self.github.GetPullRequestBody(o.PullRequestID)
+	if err != nil {
+		return err
+	}
+	m, err := ParseDeployMetadata(body)
+	if err != nil {
+		return fmt.Errorf("unable to find the tag to check the migrations of %s: %w", o.PullRequestHTMLURL, err)
+	}
+	return newMigrationGateRunner(self.github, self.git).Run(pj, p, m.Tag, func(text string) {
+		log.Printf("[INFO] %s", text)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// OutboundConfig is the configuration of the HTTP connections gocat makes to the outside, like Slack, GitHub, the registries, and the clones of go-git,
+// for the egress through a corporate proxy with a private CA.
+//
+// The connections to the Kubernetes API, which client-go makes with its own transport, are not affected.
+type OutboundConfig struct {
+	// ProxyURL is the proxy all the connections go through, like http://proxy.internal:3128.
+	// The proxy of the environment variables, like HTTPS_PROXY, is used if empty.
+	ProxyURL string
+	// NoProxy is the comma-separated hosts connected to directly, in the same format as NO_PROXY, like in-cluster Prometheus.
+	NoProxy string
+	// CABundle is the path to the PEM file of the CAs trusted in addition to the ones of the system, like the one of the proxy inspecting TLS.
+	CABundle string
+	// Timeout is the timeout of connecting to the hosts and of waiting for their response headers. The defaults of Go are kept if zero.
+	// It doesn't limit reading the response bodies, so the long downloads like the ones of the clones aren't cut off.
+	Timeout time.Duration
+}
+
+// transport returns the transport of the configuration, based on http.DefaultTransport.
+func (c OutboundConfig) transport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if c.ProxyURL != "" {
+		proxy := (&httpproxy.Config{HTTPProxy: c.ProxyURL, HTTPSProxy: c.ProxyURL, NoProxy: c.NoProxy}).ProxyFunc()
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
+	if c.CABundle != "" {
+		pem, err := os.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the CA bundle %s", c.CABundle)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	if c.Timeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: c.Timeout, KeepAlive: 30 * time.Second}).DialContext
+		t.TLSHandshakeTimeout = c.Timeout
+		t.ResponseHeaderTimeout = c.Timeout
+	}
+	return t, nil
+}
+
+// env returns the environment variables passing the configuration to the commands gocat runs, like cosign and crane,
+// which connect to the registries with their own transports.
+// The CA bundle is passed as SSL_CERT_FILE, which the commands written in Go trust along with the CAs in the certificate directories of the system.
+func (c OutboundConfig) env() []string {
+	var env []string
+	if c.ProxyURL != "" {
+		for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY", "https_proxy", "http_proxy"} {
+			env = append(env, name+"="+c.ProxyURL)
+		}
+	}
+	if c.NoProxy != "" {
+		env = append(env, "NO_PROXY="+c.NoProxy, "no_proxy="+c.NoProxy)
+	}
+	if c.CABundle != "" {
+		env = append(env, "SSL_CERT_FILE="+c.CABundle)
+	}
+	return env
+}
+
+// outboundEnv is the environment variables of the outbound configuration applied, which commandEnv adds to the commands.
+var outboundEnv []string
+
+// commandEnv returns the environment of the commands gocat runs, which is the one of gocat with the outbound configuration applied,
+// so that the commands connect to the outside as gocat does.
+// The variables later in the list take precedence, as exec.Cmd keeps the last value of each.
+func commandEnv() []string {
+	return append(os.Environ(), outboundEnv...)
+}
+
+// ApplyOutboundConfig makes all the outbound connections use the configuration.
+// It replaces http.DefaultTransport, which the clients of Slack, GitHub, and AWS, and the other clients of gocat use unless given their own,
+// installs the HTTP client of go-git, which has taken the original transport, and passes it to the commands gocat runs. See commandEnv.
+// It needs to be called before any connection is made.
+func ApplyOutboundConfig(c OutboundConfig) error {
+	if c == (OutboundConfig{}) {
+		return nil
+	}
+	t, err := c.transport()
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = t
+	outboundEnv = c.env()
+	git := githttp.NewClient(&http.Client{Transport: t})
+	client.InstallProtocol("http", git)
+	client.InstallProtocol("https", git)
+	return nil
+}
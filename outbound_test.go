@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboundConfig_Transport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+
+	// The server is trusted only with the CA bundle
+	tr, err := OutboundConfig{}.transport()
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: tr}).Get(server.URL)
+	require.Error(t, err)
+	tr, err = OutboundConfig{CABundle: bundle, Timeout: 5 * time.Second}.transport()
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 5*time.Second, tr.ResponseHeaderTimeout)
+
+	tr, err = OutboundConfig{ProxyURL: "http://proxy.internal:3128", NoProxy: ".svc,prometheus.internal"}.transport()
+	require.NoError(t, err)
+	proxy := func(u string) string {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		p, err := tr.Proxy(req)
+		require.NoError(t, err)
+		if p == nil {
+			return ""
+		}
+		return p.String()
+	}
+	require.Equal(t, "http://proxy.internal:3128", proxy("https://slack.com/api/chat.postMessage"))
+	require.Equal(t, "http://proxy.internal:3128", proxy("https://api.github.com/graphql"))
+	require.Equal(t, "", proxy("http://prometheus.internal:9090/api/v1/query"))
+	require.Equal(t, "", proxy("http://opa.policy.svc/v1/data"))
+
+	require.NoError(t, os.WriteFile(bundle, []byte("not a certificate"), 0644))
+	_, err = OutboundConfig{CABundle: bundle}.transport()
+	require.EqualError(t, err, "no certificates found in the CA bundle "+bundle)
+}
+
+func TestOutboundConfig_Env(t *testing.T) {
+	require.Empty(t, OutboundConfig{Timeout: time.Second}.env())
+	require.Equal(t, []string{
+		"HTTPS_PROXY=http://proxy.internal:3128", "HTTP_PROXY=http://proxy.internal:3128", "https_proxy=http://proxy.internal:3128", "http_proxy=http://proxy.internal:3128",
+		"NO_PROXY=.svc", "no_proxy=.svc",
+		"SSL_CERT_FILE=/etc/gocat/ca.pem",
+	}, OutboundConfig{ProxyURL: "http://proxy.internal:3128", NoProxy: ".svc", CABundle: "/etc/gocat/ca.pem"}.env())
+
+	// The commands take the configuration over the environment of gocat
+	t.Setenv("HTTPS_PROXY", "http://other.internal:8080")
+	outboundEnv = []string{"HTTPS_PROXY=http://proxy.internal:3128"}
+	defer func() { outboundEnv = nil }()
+	env := commandEnv()
+	require.Equal(t, "HTTPS_PROXY=http://proxy.internal:3128", env[len(env)-1])
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// ErrorReporter sends the panics gocat recovered from to the error tracker.
+type ErrorReporter interface {
+	Report(where string, value interface{}, stack []byte) error
+}
+
+// NewErrorReporter returns the ErrorReporter for the DSN, which is either the DSN of the Sentry project
+// like https://<key>@o0.ingest.sentry.io/<project>, or rollbar://<access token> for Rollbar.
+// It returns nil for an empty DSN, which disables the reporting.
+func NewErrorReporter(dsn string) (ErrorReporter, error) {
+	if dsn == "" {
+		return nil, nil
+	}
+	if token := strings.TrimPrefix(dsn, "rollbar://"); token != dsn {
+		return rollbarReporter{endpoint: "https://api.rollbar.com/api/1/item/", token: token, httpClient: http.DefaultClient}, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || u.Path[i+1:] == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: the project ID is missing")
+	}
+	endpoint := fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], u.Path[i+1:])
+	return sentryReporter{endpoint: endpoint, key: u.User.Username(), httpClient: http.DefaultClient}, nil
+}
+
+// sentryReporter sends the events to the store endpoint of Sentry.
+type sentryReporter struct {
+	endpoint   string
+	key        string
+	httpClient *http.Client
+}
+
+func (r sentryReporter) Report(where string, value interface{}, stack []byte) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	event := map[string]interface{}{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"platform":  "go",
+		"level":     "fatal",
+		"logger":    "gocat",
+		"message":   fmt.Sprintf("panic in %s: %v", where, value),
+		"tags":      map[string]string{"where": where},
+		"extra":     map[string]string{"stack": string(stack)},
+	}
+	return postErrorReport(r.httpClient, r.endpoint, event, map[string]string{
+		"X-Sentry-Auth": fmt.Sprintf("Sentry sentry_version=7, sentry_client=gocat/1.0, sentry_key=%s", r.key),
+	})
+}
+
+// rollbarReporter sends the items to the API of Rollbar.
+type rollbarReporter struct {
+	endpoint   string
+	token      string
+	httpClient *http.Client
+}
+
+func (r rollbarReporter) Report(where string, value interface{}, stack []byte) error {
+	item := map[string]interface{}{
+		"data": map[string]interface{}{
+			"environment": "production",
+			"platform":    "go",
+			"level":       "critical",
+			"context":     where,
+			"body": map[string]interface{}{
+				"message": map[string]string{
+					"body":  fmt.Sprintf("panic in %s: %v", where, value),
+					"stack": string(stack),
+				},
+			},
+		},
+	}
+	return postErrorReport(r.httpClient, r.endpoint, item, map[string]string{"X-Rollbar-Access-Token": r.token})
+}
+
+func postErrorReport(httpClient *http.Client, endpoint string, body interface{}, headers map[string]string) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST %s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// PanicRecoverer keeps gocat running when a handler or a watcher goroutine panics, like on a bad project config,
+// by recovering from the panic, reporting it with the stack trace, and posting a notice that gocat is degraded.
+//
+// The methods are safe to call on nil, which only recovers and logs.
+type PanicRecoverer struct {
+	reporter ErrorReporter
+	client   *slack.Client
+	// channel is where the notices go when the panic isn't in response to a message, like the announcement channel.
+	channel string
+	mu      sync.Mutex
+	// notified is when each place was last noticed in Slack, so that a watcher panicking on every tick doesn't flood the channel.
+	notified map[string]time.Time
+}
+
+// panicNoticeInterval is how long the notices of the same place are held back after one is posted.
+// The panics are reported to the error tracker every time, which groups them by itself.
+const panicNoticeInterval = time.Hour
+
+func NewPanicRecoverer(reporter ErrorReporter, client *slack.Client, channel string) *PanicRecoverer {
+	return &PanicRecoverer{reporter: reporter, client: client, channel: channel, notified: map[string]time.Time{}}
+}
+
+// Recover recovers from the panic, if any. It needs to be deferred directly, like:
+//
+//	defer s.recoverer.Recover("deploy command", ev.Channel)
+//
+// where describes what panicked. The notice goes to channel, or the default channel if empty.
+func (r *PanicRecoverer) Recover(where, channel string) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	stack := debug.Stack()
+	log.Printf("[ERROR] Recovered from the panic in %s: %v\n%s", where, value, stack)
+	if r == nil {
+		return
+	}
+	if r.reporter != nil {
+		if err := r.reporter.Report(where, value, stack); err != nil {
+			log.Printf("[ERROR] Failed to report the panic: %s", err)
+		}
+	}
+	if !r.shouldNotice(where+"|"+channel, time.Now()) {
+		return
+	}
+	if channel == "" {
+		channel = r.channel
+	}
+	if channel == "" || r.client == nil {
+		return
+	}
+	text := fmt.Sprintf(":warning: gocat recovered from an unexpected error in %s and keeps running in degraded mode. "+
+		"It may fail the same way until an admin fixes the cause, like the project config.", where)
+	if _, _, err := r.client.PostMessage(channel, slack.MsgOptionText(text, false)); err != nil {
+		log.Printf("[ERROR] Failed to post the degraded notice: %s", err)
+	}
+}
+
+// shouldNotice returns true if the place hasn't been noticed in panicNoticeInterval, and records it as noticed now if so.
+func (r *PanicRecoverer) shouldNotice(key string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.notified[key]; ok && now.Sub(last) < panicNoticeInterval {
+		return false
+	}
+	r.notified[key] = now
+	return true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeErrorReporter struct {
+	reports []string
+}
+
+func (f *fakeErrorReporter) Report(where string, value interface{}, stack []byte) error {
+	f.reports = append(f.reports, where)
+	return nil
+}
+
+func TestNewErrorReporter(t *testing.T) {
+	r, err := NewErrorReporter("https://abc123@o42.ingest.sentry.io/7")
+	require.NoError(t, err)
+	require.Equal(t, sentryReporter{endpoint: "https://o42.ingest.sentry.io/api/7/store/", key: "abc123", httpClient: r.(sentryReporter).httpClient}, r)
+
+	r, err = NewErrorReporter("https://abc123@sentry.example.com/prefix/7")
+	require.NoError(t, err)
+	require.Equal(t, "https://sentry.example.com/prefix/api/7/store/", r.(sentryReporter).endpoint)
+
+	r, err = NewErrorReporter("rollbar://token")
+	require.NoError(t, err)
+	require.Equal(t, "token", r.(rollbarReporter).token)
+
+	r, err = NewErrorReporter("")
+	require.NoError(t, err)
+	require.Nil(t, r)
+
+	_, err = NewErrorReporter("https://sentry.example.com/7")
+	require.Error(t, err)
+}
+
+func TestPanicRecoverer(t *testing.T) {
+	reporter := &fakeErrorReporter{}
+	recoverer := NewPanicRecoverer(reporter, nil, "")
+	tick := func() {
+		defer recoverer.Recover("AutoDeploy of myapp production", "")
+		var phases map[string]DeployPhase
+		phases["production"] = DeployPhase{}
+	}
+	require.NotPanics(t, tick)
+	require.NotPanics(t, tick)
+	// Reported every time it panics, while the notice is posted once in the interval
+	require.Equal(t, []string{"AutoDeploy of myapp production", "AutoDeploy of myapp production"}, reporter.reports)
+	key := "AutoDeploy of myapp production|"
+	require.False(t, recoverer.shouldNotice(key, time.Now()))
+	require.True(t, recoverer.shouldNotice(key, time.Now().Add(panicNoticeInterval)))
+	require.True(t, recoverer.shouldNotice("the command|C123", time.Now()))
+
+	var nilRecoverer *PanicRecoverer
+	require.NotPanics(t, func() {
+		defer nilRecoverer.Recover("the command", "C123")
+		panic("boom")
+	})
+}
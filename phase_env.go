@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// PhaseEnv is the configmap of the environment values of a phase in the manifest repository, which the env set command edits:
+//
+//	env:
+//	  path: myapp/overlays/production/configmap.yaml
+//	  keys: [FEATURE_X_ENABLED, LOG_LEVEL]
+//
+// Only the keys listed are editable in Slack, so the values the app can't run without stay reviewed in the manifest repository.
+type PhaseEnv struct {
+	// Path is the path of the manifest of the configmap in the manifest repository.
+	Path string `yaml:"path"`
+	// Keys are the keys of the data of the configmap editable by the env set command.
+	Keys []string `yaml:"keys"`
+}
+
+func (e PhaseEnv) Enabled() bool {
+	return e.Path != ""
+}
+
+func (e PhaseEnv) validate() error {
+	if e.Path == "" && len(e.Keys) > 0 {
+		return fmt.Errorf("path is required")
+	}
+	if e.Path != "" && len(e.Keys) == 0 {
+		return fmt.Errorf("keys are required")
+	}
+	return nil
+}
+
+// Editable returns true if the key is allowed to be edited by the env set command.
+func (e PhaseEnv) Editable(key string) bool {
+	for _, k := range e.Keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvSetter sets the environment values of the phases through pull requests to the manifest repository,
+// so that the changes are reviewed and approved in Slack just like the deploys.
+type EnvSetter struct {
+	github *GitHub
+	git    *GitOperator
+}
+
+func NewEnvSetter(github *GitHub, git *GitOperator) EnvSetter {
+	return EnvSetter{github: github, git: git}
+}
+
+// EnvSetOutput is the result of EnvSetter.Set.
+type EnvSetOutput struct {
+	PullRequestID     string
+	PullRequestNumber int
+	Branch            string
+}
+
+// Set opens the pull request setting the value of the key in the configmap of the phase.
+// The metadata is embedded so that the change passes the same gates as the deploys when it's approved.
+func (e EnvSetter) Set(pj DeployProject, phase DeployPhase, key, value string, requester User) (EnvSetOutput, error) {
+	var o EnvSetOutput
+	o.Branch = fmt.Sprintf("bot/env-%s-%s-%s-%s", pj.ID, phase.Name, strings.ToLower(strings.ReplaceAll(key, "_", "-")), RandString(5))
+	message := fmt.Sprintf("Set %s. project: %s, phase: %s.", key, pj.ID, phase.Name)
+	diff, err := e.git.PushOverWrite(o.Branch, phase.Env.Path, ConfigMapDataOverWrite{key: key, value: value}, message)
+	if err != nil {
+		return o, err
+	}
+
+	title := fmt.Sprintf("Set %s of %s %s", key, pj.ID, phase.Name)
+	metadata := DeployMetadata{
+		Project:          pj.ID,
+		Phase:            phase.Name,
+		Requester:        requester.SlackDisplayName,
+		RequesterSlackID: requester.SlackUserID,
+		Kind:             DeployKindEnv,
+	}
+	body := fmt.Sprintf("Set `%s` of %s %s\nRequested by %s\n\n```diff\n%s```\n\n%s", key, pj.ID, phase.Name, requester.SlackDisplayName, diff, metadata.PullRequestFooter())
+	o.PullRequestID, o.PullRequestNumber, err = e.github.CreatePullRequest(o.Branch, title, body)
+	if err != nil {
+		return o, err
+	}
+	if err := e.github.SetNewPullRequestMetadata("", o.PullRequestID, phase.PullRequest); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// ConfigMapDataOverWrite sets the value of the key in the data of the configmap, keeping the comments and the order of the keys intact.
+type ConfigMapDataOverWrite struct {
+	key   string
+	value string
+}
+
+func (o ConfigMapDataOverWrite) Update(b []byte) (interface{}, error) {
+	docs, err := decodeYAMLDocuments(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) != 1 || yamlMapping(docs[0]) == nil {
+		return nil, fmt.Errorf("the manifest must be a single ConfigMap")
+	}
+	obj := yamlMapping(docs[0])
+	if kind := yamlString(obj, "kind"); kind != "" && kind != "ConfigMap" {
+		return nil, fmt.Errorf("the manifest is a %v, not a ConfigMap", kind)
+	}
+	setYAMLStrings(obj, []string{"data"}, map[string]string{o.key: o.value})
+	return encodeYAMLDocuments(docs)
+}
+
+// envSetBlocks returns the approval message of the change.
+func envSetBlocks(github *GitHub, userID string, pj DeployProject, phase, key, value string, o EnvSetOutput) []slack.Block {
+	question := fmt.Sprintf("`%s` を `%s` に変更しますか?", key, value)
+	return pullRequestApprovalBlocks(github, userID, pj, phase, question, "Apply", o.PullRequestID, o.PullRequestNumber, o.Branch)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigMapDataOverWrite(t *testing.T) {
+	configMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myapp
+data:
+  APP_NAME: myapp
+  # debug is too noisy for production
+  LOG_LEVEL: info
+`
+	obj, err := ConfigMapDataOverWrite{key: "LOG_LEVEL", value: "debug"}.Update([]byte(configMap))
+	require.NoError(t, err)
+	b, err := marshalOverWrite(obj)
+	require.NoError(t, err)
+	require.Equal(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myapp
+data:
+  APP_NAME: myapp
+  # debug is too noisy for production
+  LOG_LEVEL: debug
+`, string(b))
+
+	// The missing keys are added, and the values looking like the other types stay strings
+	obj, err = ConfigMapDataOverWrite{key: "FEATURE_X_ENABLED", value: "true"}.Update([]byte(configMap))
+	require.NoError(t, err)
+	b, err = marshalOverWrite(obj)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "  LOG_LEVEL: info\n  FEATURE_X_ENABLED: \"true\"\n")
+
+	_, err = ConfigMapDataOverWrite{key: "LOG_LEVEL", value: "debug"}.Update([]byte("kind: Secret\ndata: {}\n"))
+	require.Error(t, err)
+}
+
+func TestPhaseEnv(t *testing.T) {
+	env := PhaseEnv{Path: "myapp/overlays/production/configmap.yaml", Keys: []string{"LOG_LEVEL"}}
+	require.NoError(t, env.validate())
+	require.True(t, env.Editable("LOG_LEVEL"))
+	require.False(t, env.Editable("DATABASE_URL"))
+
+	require.NoError(t, PhaseEnv{}.validate())
+	require.Error(t, PhaseEnv{Path: env.Path}.validate())
+	require.Error(t, PhaseEnv{Keys: env.Keys}.validate())
+}
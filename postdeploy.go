@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	yaml "gopkg.in/yaml.v2"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// PostDeployHook is called with the metadata and the URL of the deploy pull request
+// after the pull request is merged.
+type PostDeployHook func(m DeployMetadata, prURL string) error
+
+// PostDeployHooks is the list of hooks run after each deploy pull request is merged,
+// either via the Deploy button on Slack or directly on GitHub.
+type PostDeployHooks []PostDeployHook
+
+// The notifications are posted by the bot of the workspace of each project, or client if no workspace is known for it.
+func NewPostDeployHooks(client *slack.Client, workspaces *SlackWorkspaces, templates *MessageTemplates, github *GitHub, git *GitOperator, projectList *ProjectList, announcer Announcer, tracer *DeployTracer, syntheticChecks SyntheticCheckRunner, commandHooks *CommandHookRunner, archiver *ArtifactArchiver) PostDeployHooks {
+	return PostDeployHooks{
+		SubmoduleHook(git, projectList),
+		ArchiveHook(archiver, git, projectList, tracer, cosignCLI{}),
+		SyntheticCheckHook(client, workspaces, templates, projectList, tracer, syntheticChecks),
+		WebhookHook(projectList, NewDeployWebhookRunner()),
+		CommandHook(client, workspaces, projectList, commandHooks),
+		NotifyPhaseChannelHook(client, workspaces, templates, projectList),
+		AppRepoTagHook(github, projectList),
+		AnnouncementHook(announcer),
+	}
+}
+
+// Run runs all the hooks.
+// A failing hook doesn't prevent the subsequent hooks from running, except the failing synthetic checks,
+// after which the success of the deploy isn't notified.
+func (hs PostDeployHooks) Run(m DeployMetadata, prURL string) {
+	for _, hook := range hs {
+		if err := hook(m, prURL); err != nil {
+			log.Printf("[ERROR] Post-deploy hook failed for %s: %s", prURL, err)
+			if errors.Is(err, errSyntheticCheckFailed) {
+				return
+			}
+		}
+	}
+}
+
+// SubmoduleHook returns a PostDeployHook that fast-forwards the default branch of the submodule
+// the overlay of the phase lives in to the commit the merged deploy points to. See AdvanceSubmodule.
+func SubmoduleHook(git *GitOperator, projectList *ProjectList) PostDeployHook {
+	return func(m DeployMetadata, prURL string) error {
+		phase := projectList.Find(m.Project).FindPhase(m.Phase)
+		if phase.Kind != "kustomize" || git == nil {
+			return nil
+		}
+		return git.AdvanceSubmodule(phase)
+	}
+}
+
+// ArchiveHook returns a PostDeployHook that archives the artifacts of the deploy as it ships,
+// so that only the deploys that were merged or pushed can be replayed.
+//
+// The artifacts are the ones prepared for the deploy, its metadata, and for the phases of Kustomize,
+// the overlay and the bases at the default branch along with the manifests rendered from them and the SBOMs of their images.
+func ArchiveHook(archiver *ArtifactArchiver, git *GitOperator, projectList *ProjectList, tracer *DeployTracer, sboms SBOMFetcher) PostDeployHook {
+	return func(m DeployMetadata, prURL string) error {
+		if archiver == nil {
+			return nil
+		}
+		artifacts := tracer.TakeArtifacts(m.TraceID)
+		if artifacts == nil {
+			artifacts = map[string][]byte{}
+		}
+		if b, err := json.MarshalIndent(m, "", "  "); err == nil {
+			artifacts["metadata.json"] = b
+		}
+		if phase := projectList.Find(m.Project).FindPhase(m.Phase); phase.Kind == "kustomize" && phase.Path != "" {
+			shipped, err := shippedManifests(git, sboms, phase)
+			if err != nil {
+				log.Printf("[ERROR] Failed to collect the manifests of %s %s to archive: %s", m.Project, m.Phase, err)
+			}
+			for name, content := range shipped {
+				artifacts[name] = content
+			}
+		}
+		url, err := archiver.Archive(m.Project, m.Phase, m.TraceID, artifacts)
+		if err != nil {
+			return fmt.Errorf("unable to archive the artifacts of %s: %w", prURL, err)
+		}
+		tracer.Record(m.TraceID, "archived the artifacts to %s", url)
+		return nil
+	}
+}
+
+// shippedManifests returns the artifacts of the manifests of the phase at the head of the default branch:
+// the overlay and the bases as manifests/<path>, the manifests rendered from them as rendered.yaml,
+// and the SBOMs of the images of the overlay as sbom/<image name>.json.
+// It returns the ones collected so far along with the error.
+func shippedManifests(git *GitOperator, sboms SBOMFetcher, phase DeployPhase) (map[string][]byte, error) {
+	ref, err := git.FetchDefaultBranch()
+	if err != nil {
+		return nil, err
+	}
+	overlay := path.Dir(phase.Path)
+	files, err := phaseManifests(func(dir string) (map[string][]byte, error) { return git.Files(ref, dir) }, overlay)
+	if err != nil {
+		return nil, err
+	}
+	artifacts := map[string][]byte{}
+	for name, content := range files {
+		artifacts["manifests/"+name] = content
+	}
+	var k types.Kustomization
+	if err := yaml.Unmarshal(files[phase.Path], &k); err == nil {
+		artifacts = withSBOMs(GitOpsPrepareOutput{Artifacts: artifacts}, fetchSBOMs(sboms, phase, k.Images)).Artifacts
+	}
+	rendered, err := renderManifests(files, overlay)
+	if err != nil {
+		return artifacts, err
+	}
+	artifacts["rendered.yaml"] = rendered
+	return artifacts, nil
+}
+
+// NotifyPhaseChannelHook returns a PostDeployHook that notifies the notifyChannel of the phase, if any,
+// the same way AutoDeploy does, in the layout of the deploy_notification template if any.
+func NotifyPhaseChannelHook(client *slack.Client, workspaces *SlackWorkspaces, templates *MessageTemplates, projectList *ProjectList) PostDeployHook {
+	return func(m DeployMetadata, prURL string) error {
+		pj := projectList.Find(m.Project)
+		phase := pj.FindPhase(m.Phase)
+		if phase.NotifyChannel == "" {
+			return nil
+		}
+		vars := deployNotificationVars(map[string]string{"Project": m.Project, "Phase": m.Phase, "Tag": m.Tag, "Requester": m.RequesterSlackID, "Link": prURL, "TraceID": m.TraceID})
+		msg := templates.MessageOr("deploy_notification", "", vars, func() slack.MsgOption {
+			fields := []slack.AttachmentField{
+				{Title: "Project", Value: m.Project, Short: true},
+				{Title: "Phase", Value: m.Phase, Short: true},
+				{Title: "Tag", Value: m.Tag, Short: true},
+			}
+			return slack.MsgOptionAttachments(slack.Attachment{Color: "#36a64f", Title: ":white_check_mark: Succeed to deploy", TitleLink: prURL, Fields: fields})
+		})
+		_, _, err := workspaces.ClientFor(pj, client).PostMessage(phase.NotifyChannel, msg)
+		return err
+	}
+}
+
+var commitSHAPattern = regexp.MustCompile(`\b[0-9a-f]{7,40}\b`)
+
+// findCommitSHA returns the first commit SHA in the image tag, like abc1234 of master-abc1234, or an empty string if it has none.
+// The words of only digits, like the dates and the build numbers of 20240102-1234567, are not taken as the SHAs.
+func findCommitSHA(tag string) string {
+	for _, s := range commitSHAPattern.FindAllString(tag, -1) {
+		if strings.IndexAny(s, "abcdef") >= 0 {
+			return s
+		}
+	}
+	return ""
+}
+
+// AppRepoTagHook returns a PostDeployHook that tags the deployed commit on the application repository
+// when appRepoTag is enabled for the phase.
+//
+// The deployed commit is the one whose SHA, abbreviated or not, is contained in the image tag.
+func AppRepoTagHook(github *GitHub, projectList *ProjectList) PostDeployHook {
+	return func(m DeployMetadata, prURL string) error {
+		pj := projectList.Find(m.Project)
+		option := pj.FindPhase(m.Phase).AppRepoTag
+		if !option.Enabled {
+			return nil
+		}
+
+		rev := findCommitSHA(m.Tag)
+		if rev == "" {
+			return fmt.Errorf("unable to find a commit SHA in the image tag %q", m.Tag)
+		}
+		sha, err := github.ResolveCommit(pj.GitHubRepository(), rev)
+		if err != nil {
+			return err
+		}
+
+		name, err := AppRepoTagVars{
+			Project:  m.Project,
+			Phase:    m.Phase,
+			Tag:      m.Tag,
+			SHA:      sha,
+			ShortSHA: sha[:7],
+			Date:     time.Now().Format("2006-01-02"),
+		}.Parse(option.NameTemplate())
+		if err != nil {
+			return fmt.Errorf("unable to render the appRepoTag name of %s: %w", m.Project, err)
+		}
+
+		message := fmt.Sprintf("Deployed %s to %s by %s\n%s", m.Tag, m.Phase, m.Requester, prURL)
+		if option.Release {
+			return github.CreateRelease(pj.GitHubRepository(), name, sha, message)
+		}
+		return github.CreateAnnotatedTag(pj.GitHubRepository(), name, sha, message)
+	}
+}
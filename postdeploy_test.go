@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindCommitSHA(t *testing.T) {
+	require.Equal(t, "abc1234", findCommitSHA("master-abc1234"))
+	require.Equal(t, "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567", findCommitSHA("0a1b2c3d4e5f60718293a4b5c6d7e8f901234567"))
+	require.Equal(t, "abc1234", findCommitSHA("20240102-1234567-abc1234"))
+	require.Empty(t, findCommitSHA("20240102-1234567"))
+	require.Empty(t, findCommitSHA("v1.2.3"))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/slack-go/slack"
+)
+
+// PreflightCheck is one of the checks `gocat preflight` runs,
+// which tell whether gocat can reach the services it needs with the config before it starts serving.
+type PreflightCheck struct {
+	Name string
+	// Optional is true for the checks of what only some projects need, like kanvas,
+	// whose failures are reported as warnings and don't fail the preflight.
+	Optional bool
+	// Run returns what it found, like the user authenticated as, or the error.
+	Run func() (string, error)
+}
+
+// preflightChecks returns the checks of the connectivity to Slack, GitHub, the manifest repository, ECR, and kanvas.
+func preflightChecks(config *CatConfig) []PreflightCheck {
+	apiPolicy := APIClientPolicy{Timeout: config.APITimeout, Retries: config.APIRetries}
+	return []PreflightCheck{
+		{Name: "Slack", Run: func() (string, error) {
+			client := slack.New(config.SlackOAuthToken, slack.OptionHTTPClient(apiPolicy.httpClient()))
+			resp, err := client.AuthTest()
+			if err != nil {
+				return "", fmt.Errorf("auth.test: %w", err)
+			}
+			return fmt.Sprintf("authenticated as %s in %s", resp.User, resp.Team), nil
+		}},
+		{Name: "GitHub", Run: func() (string, error) {
+			github := CreateGitHubInstance(config.GitHubAccessToken, config.ManifestRepositoryOrg, config.ManifestRepositoryName, config.GitHubDefaultBranch)
+			github.UsePolicy(apiPolicy)
+			scopes, err := github.TokenScopes()
+			if err != nil {
+				return "", err
+			}
+			return checkTokenScopes(scopes)
+		}},
+		{Name: "Manifest repository", Run: func() (string, error) {
+			github := CreateGitHubInstance(config.GitHubAccessToken, config.ManifestRepositoryOrg, config.ManifestRepositoryName, config.GitHubDefaultBranch)
+			github.UsePolicy(apiPolicy)
+			gitRoot := selectGitRoot(config.GitRoot, config.GitStorage, config.MemFSMaxRepoSize, github.RepositoryDiskUsage)
+			git := newGitOperator(config.GitHubUserName, config.GitHubAccessToken, config.ManifestRepository, config.GitHubDefaultBranch, gitRoot, config.EnableSparseCheckout, config.ManifestRemoteName)
+			if err := git.CloneOrOpen(); err != nil {
+				return "", fmt.Errorf("unable to clone %s: %w", config.ManifestRepository, err)
+			}
+			if gitRoot == "" {
+				return fmt.Sprintf("cloned %s into memory", config.ManifestRepository), nil
+			}
+			return fmt.Sprintf("cloned %s into %s", config.ManifestRepository, git.getLocalRepoRoot()), nil
+		}},
+		{Name: "ECR", Run: func() (string, error) {
+			e, err := CreateECRInstance()
+			if err != nil {
+				return "", err
+			}
+			if _, err := e.client.DescribeRepositories(&ecr.DescribeRepositoriesInput{MaxResults: aws.Int64(1)}); err != nil {
+				return "", fmt.Errorf("ecr:DescribeRepositories: %w", err)
+			}
+			return "described the repositories", nil
+		}},
+		{Name: "kanvas", Optional: true, Run: func() (string, error) {
+			path, err := exec.LookPath("kanvas")
+			if err != nil {
+				return "", fmt.Errorf("%w, which the projects of the kanvas kind need", err)
+			}
+			return path, nil
+		}},
+	}
+}
+
+// runPreflight runs the checks in order and writes the report to w, like:
+//
+//	[OK]   Slack: authenticated as gocat in myorg
+//	[FAIL] ECR: ecr:DescribeRepositories: AccessDeniedException: ...
+//	[WARN] kanvas: exec: "kanvas": executable file not found in $PATH, which the projects of the kanvas kind need
+//
+// It returns false if any of the checks but the optional ones failed.
+func runPreflight(w io.Writer, checks []PreflightCheck) bool {
+	ok := true
+	for _, c := range checks {
+		detail, err := c.Run()
+		switch {
+		case err == nil:
+			fmt.Fprintf(w, "[OK]   %s: %s\n", c.Name, detail)
+		case c.Optional:
+			fmt.Fprintf(w, "[WARN] %s: %s\n", c.Name, err)
+		default:
+			fmt.Fprintf(w, "[FAIL] %s: %s\n", c.Name, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// TokenScopes returns the OAuth scopes of the token, or nil for the tokens without the scopes,
+// like the fine-grained personal access tokens and the ones of the GitHub Apps.
+func (g GitHub) TokenScopes() ([]string, error) {
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to authenticate to GitHub: %s", resp.Status)
+	}
+	if _, ok := resp.Header["X-Oauth-Scopes"]; !ok {
+		return nil, nil
+	}
+	scopes := []string{}
+	for _, s := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, nil
+}
+
+// checkTokenScopes checks the token has the repo scope gocat needs to push the branches and merge the pull requests.
+// The tokens without the scopes are only checked by the clone of the manifest repository.
+func checkTokenScopes(scopes []string) (string, error) {
+	if scopes == nil {
+		return "authenticated with a token without OAuth scopes", nil
+	}
+	for _, s := range scopes {
+		if s == "repo" {
+			return "token scopes: " + strings.Join(scopes, ", "), nil
+		}
+	}
+	return "", fmt.Errorf("the token lacks the repo scope, only has %q", strings.Join(scopes, ", "))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunPreflight(t *testing.T) {
+	ok := func(detail string) func() (string, error) {
+		return func() (string, error) { return detail, nil }
+	}
+	fail := func() (string, error) { return "", errors.New("not found") }
+
+	var out bytes.Buffer
+	require.True(t, runPreflight(&out, []PreflightCheck{
+		{Name: "Slack", Run: ok("authenticated as gocat in myorg")},
+		{Name: "kanvas", Optional: true, Run: fail},
+	}))
+	require.Equal(t, "[OK]   Slack: authenticated as gocat in myorg\n[WARN] kanvas: not found\n", out.String())
+
+	out.Reset()
+	require.False(t, runPreflight(&out, []PreflightCheck{
+		{Name: "ECR", Run: fail},
+		{Name: "Slack", Run: ok("authenticated as gocat in myorg")},
+	}))
+	require.Equal(t, "[FAIL] ECR: not found\n[OK]   Slack: authenticated as gocat in myorg\n", out.String())
+}
+
+func TestCheckTokenScopes(t *testing.T) {
+	detail, err := checkTokenScopes([]string{"read:org", "repo"})
+	require.NoError(t, err)
+	require.Equal(t, "token scopes: read:org, repo", detail)
+
+	_, err = checkTokenScopes([]string{"read:org"})
+	require.EqualError(t, err, `the token lacks the repo scope, only has "read:org"`)
+
+	_, err = checkTokenScopes([]string{})
+	require.Error(t, err)
+	_, err = checkTokenScopes(nil)
+	require.NoError(t, err)
+}
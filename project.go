@@ -2,12 +2,20 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
+	"k8s.io/api/core/v1"
 )
 
 type PayloadVars struct {
@@ -24,14 +32,291 @@ func (self PayloadVars) Parse(s string) (string, error) {
 	return b.String(), err
 }
 
+// renderTemplate renders the Go template s with vars, which the sets of the variables of the templates in the config,
+// like the queries of the SLO gates and the URLs of the migration gates, share.
+func renderTemplate(s string, vars interface{}) (string, error) {
+	b := bytes.NewBuffer([]byte(""))
+	tmpl, err := template.New("").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if err := tmpl.Execute(b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// DeployMessageVars is the set of variables available in the commit message
+// and the pull request title and body templates of a deploy.
+type DeployMessageVars struct {
+	Project     string
+	Phase       string
+	Path        string
+	PreviousTag string
+	Tag         string
+	Branch      string
+	Requester   string
+	Changelog   string
+	// SlackURL is the permalink of the Slack message the deploy is requested in, if any.
+	SlackURL string
+	// Reason is the reason of the deploy given by the requester, if any.
+	Reason string
+	// Diff is the unified diff of the gitops commit.
+	// It's available only in the pull request body template.
+	Diff string
+	// Warnings are the warnings of the deploy, like the images failing the signature verification,
+	// which are appended to the pull request body whatever its template is.
+	Warnings []string
+	// SBOMs are the SBOMs of the images, whose summaries are appended to the pull request body as the warnings are.
+	SBOMs []ImageSBOM
+}
+
+func (self DeployMessageVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+// defaultBranchNameTemplate is the default template of the name of the branch gocat pushes the deploy commit to.
+const defaultBranchNameTemplate = "bot/docker-image-tag-{{.Project}}-{{.Phase}}-{{.Tag}}"
+
+// BranchNameVars is the set of variables available in the branch name template of a deploy.
+type BranchNameVars struct {
+	Project string
+	Phase   string
+	// Tag is the image tag to deploy.
+	Tag string
+	// Branch is the branch of the application repository the image is built from.
+	Branch string
+	// BranchSlug is Branch lowercased, with non-alphanumeric characters replaced with hyphens.
+	BranchSlug string
+}
+
+func (self BranchNameVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+// PullRequestOption is the per-phase metadata attached to the deploy pull requests,
+// so that downstream automation and dashboards can filter them.
+type PullRequestOption struct {
+	// Labels is the list of label names. Each label must exist in the repository.
+	Labels []string `yaml:"labels"`
+	// Milestone is the title of an open milestone in the repository.
+	Milestone string `yaml:"milestone"`
+	// ProjectColumns is the list of node IDs of the GitHub Projects columns to add the pull request to.
+	ProjectColumns []string `yaml:"projectColumns"`
+}
+
+// AppRepoTagOption configures the tag gocat creates on the application repository
+// after a successful deploy of the phase, for traceability.
+// It's typically enabled for the production phase only.
+type AppRepoTagOption struct {
+	Enabled bool `yaml:"enabled"`
+	// Name is the template of the tag name. See AppRepoTagVars for the available variables.
+	// Defaults to "deploy/{{.Phase}}/{{.Date}}-{{.ShortSHA}}".
+	Name string `yaml:"name"`
+	// Release creates a GitHub Release instead of an annotated tag.
+	Release bool `yaml:"release"`
+}
+
+func (o AppRepoTagOption) NameTemplate() string {
+	if o.Name == "" {
+		return "deploy/{{.Phase}}/{{.Date}}-{{.ShortSHA}}"
+	}
+	return o.Name
+}
+
+// AppRepoTagVars is the set of variables available in AppRepoTagOption.Name.
+type AppRepoTagVars struct {
+	Project string
+	Phase   string
+	// Tag is the deployed image tag.
+	Tag string
+	// SHA is the full SHA of the deployed commit, and ShortSHA is its first 7 characters.
+	SHA      string
+	ShortSHA string
+	// Date is the date of the deploy in the YYYY-MM-DD format.
+	Date string
+}
+
+func (self AppRepoTagVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+// PhaseImage is one of the images deployed together in a phase,
+// typically one of the many images built from a monorepo.
+type PhaseImage struct {
+	// Name is the docker repository of the image, which is also the image name in kustomization.yaml.
+	Name string `yaml:"name"`
+	// Path is the path of the component in the monorepo the image is built from.
+	// It's available as {{.Path}} in the regexps.
+	Path string `yaml:"path"`
+	// FilterRegexp and TargetRegexp override the ones of the phase for this image.
+	FilterRegexp string `yaml:"filterRegexp"`
+	TargetRegexp string `yaml:"targetRegexp"`
+}
+
 type DeployPhase struct {
-	Name          string      `yaml:"name"`
-	Kind          string      `yaml:"kind"`
-	Path          string      `yaml:"path"` // for job
-	AutoDeploy    bool        `yaml:"autoDeploy"`
-	NotifyChannel string      `yaml:"notifyChannel"`
-	Payload       string      `yaml:"payload"`
-	Destination   Destination `yaml:"destination"`
+	Name          string            `yaml:"name"`
+	Kind          string            `yaml:"kind"`
+	Path          string            `yaml:"path"` // for job
+	AutoDeploy    bool              `yaml:"autoDeploy"`
+	NotifyChannel string            `yaml:"notifyChannel"`
+	Payload       string            `yaml:"payload"`
+	Destination   Destination       `yaml:"destination"`
+	PullRequest   PullRequestOption `yaml:"pullRequest"`
+	AppRepoTag    AppRepoTagOption  `yaml:"appRepoTag"`
+	// FilterRegexp and TargetRegexp override the ones of the project for this phase.
+	FilterRegexp string `yaml:"filterRegexp"`
+	TargetRegexp string `yaml:"targetRegexp"`
+	// Images is the list of images deployed together in this phase.
+	// The project's docker registry is the only image if empty.
+	Images []PhaseImage `yaml:"images"`
+	// AutoDeployConstraint is the semver constraint, like ~1.4, of the tags AutoDeploy deploys.
+	// Newer tags not satisfying it are notified to NotifyChannel to be deployed manually.
+	AutoDeployConstraint string `yaml:"autoDeployConstraint"`
+	// Staleness configures the notification when this phase lags behind another phase for too long.
+	Staleness StalenessOption `yaml:"staleness"`
+	// ApprovalReminder configures the follow-ups on the deploys of this phase nobody approves.
+	ApprovalReminder ApprovalReminderOption `yaml:"approvalReminder"`
+	// ReasonPolicy requires the deploys of this phase to have a reason.
+	ReasonPolicy DeployReasonPolicy `yaml:"reasonPolicy"`
+	// TwoPersonRule requires the deploys of this phase to be approved by someone other than the requester.
+	// The requester of the kinds creating pull requests, like kustomize and kanvas, is read from the pull request,
+	// and the one of the other kinds, like Jenkins, from the Deploy button.
+	TwoPersonRule bool `yaml:"twoPersonRule"`
+	// BusinessDaysOnly allows the deploys of this phase, including AutoDeploy, only on the business days of the calendar of the project,
+	// which are the weekdays other than the holidays of HolidayCalendar and Holidays.
+	BusinessDaysOnly bool `yaml:"businessDaysOnly"`
+	// TrackBranch is the branch AutoDeploy deploys to this phase, like develop for staging.
+	// It can be a glob like release/*, in which case the latest branch in version order, like release/1.10 over release/1.9, is deployed.
+	// The default branch of the project is deployed if empty.
+	TrackBranch string `yaml:"trackBranch"`
+	// PinDigest writes the image digest along with the tag into kustomization.yaml,
+	// and makes AutoDeploy compare the digests, so that mutable tags like latest are deployed when they're pushed again.
+	PinDigest bool `yaml:"pinDigest"`
+	// preserveYAML makes the deploys edit kustomization.yaml as the YAML nodes. See FeaturePreserveYAML.
+	preserveYAML bool
+	// Kanvas configures the components kanvas skips and the extra environment variables for the kanvas kind.
+	Kanvas KanvasOption `yaml:"kanvas"`
+	// PluginOptions is the options for the third-party GitOpsPlugin of the kind of this phase.
+	// Their meaning is up to the plugin. See RegisterGitOpsPlugin.
+	PluginOptions map[string]string `yaml:"pluginOptions"`
+	// CommitStrategy is either pullRequest, the default, or direct.
+	// direct pushes the deploy commit straight to the default branch of the manifest repository with no pull request nor approval,
+	// which is meant for the phases like sandbox. It's supported by the kustomize kind only.
+	CommitStrategy string `yaml:"commitStrategy"`
+	// StackDeploys pushes the deploy commit to the open deploy pull request of the phase, if any,
+	// instead of opening another pull request in parallel, so that the review history stays in one place.
+	// It's supported by the kustomize kind only.
+	StackDeploys bool `yaml:"stackDeploys"`
+	// Jobs are the one-off jobs, like database migrations, run by the run command with the image tag deployed to this phase.
+	Jobs []PhaseJob `yaml:"jobs"`
+	// MigrationGate holds the merge of the deploy pull requests until the database migrations are applied.
+	MigrationGate MigrationGate `yaml:"migrationGate"`
+	// ImageSignature requires the images deployed to this phase to be signed with cosign.
+	// It's supported by the kustomize kind only.
+	ImageSignature ImageSignaturePolicy `yaml:"imageSignature"`
+	// Architectures are the architectures of the nodes of the cluster, like [amd64, arm64], which the images deployed to this phase
+	// must all be built for. It's supported by the kustomize kind only.
+	Architectures []string `yaml:"architectures"`
+	// Promotion copies the images deployed to this phase to another registry before the overlay is edited. See ImagePromotion.
+	Promotion ImagePromotion `yaml:"promotion"`
+	// SBOM fetches the SBOMs of the images deployed to this phase from their cosign attestations verified with the key or the identity
+	// of ImageSignature, which is required, summarizes them in the deploy pull request, and archives them along with the other artifacts
+	// as compliance evidence. The deploy isn't blocked by the images without an SBOM. It's supported by the kustomize kind only.
+	SBOM bool `yaml:"sbom"`
+	// SLOGate holds the deploys while the error budget of the service is exhausted.
+	SLOGate SLOGate `yaml:"sloGate"`
+	// BlueGreen makes the deploys update the overlay of the idle color, which the switch command puts into service.
+	// It's supported by the kustomize kind only.
+	BlueGreen BlueGreenOption `yaml:"blueGreen"`
+	// SyntheticChecks are the checks run after the deploys of this phase, which must pass before the deploys are notified as successful.
+	SyntheticChecks SyntheticCheckOption `yaml:"syntheticChecks"`
+	// Hooks are the webhooks called before and after the deploys of this phase.
+	Hooks PhaseHooks `yaml:"hooks"`
+	// MaxConcurrentDeploys is the maximum number of the deploys of this phase prepared at once. 0 means unlimited.
+	// The deploys requested in Slack beyond it wait for the running ones, while AutoDeploy skips the phase until its next check.
+	MaxConcurrentDeploys int `yaml:"maxConcurrentDeploys"`
+	// Rollout is the Argo Rollouts Rollout the deploys of this phase update, which gocat follows in Slack after the deploy is merged.
+	Rollout RolloutOption `yaml:"rollout"`
+	// RolloutPreview is the namespace of the Deployments of this phase previewed in the approval messages. See RolloutPreviewOption.
+	RolloutPreview RolloutPreviewOption `yaml:"rolloutPreview"`
+	// Secrets are the secrets of this phase the rotate-secret command rotates.
+	Secrets []PhaseSecret `yaml:"secrets"`
+	// Env is the configmap of this phase the env set command edits. See PhaseEnv.
+	Env PhaseEnv `yaml:"env"`
+	// TagPolicy restricts the image tags deployable to this phase. See TagPolicy.
+	TagPolicy TagPolicy `yaml:"tagPolicy"`
+	// FailureIssue opens a GitHub issue in the repository of the project when a deploy of this phase fails. See FailureIssueOption.
+	FailureIssue FailureIssueOption `yaml:"failureIssue"`
+	// OnFailure are the Slack user IDs or the handles of the user groups, like @payments-oncall,
+	// mentioned in NotifyChannel when AutoDeploy fails to deploy this phase.
+	// The current members of the user groups, like the on-call, are DMed as well.
+	// Each can be qualified with the workspace, like dev/@payments-oncall, to DM the users of another workspace.
+	OnFailure []string `yaml:"onFailure"`
+}
+
+// FindSecret returns the secret of the phase by its name.
+func (p DeployPhase) FindSecret(name string) (PhaseSecret, bool) {
+	for _, secret := range p.Secrets {
+		if secret.Name == name {
+			return secret, true
+		}
+	}
+	return PhaseSecret{}, false
+}
+
+// FindJob returns the job of the phase by its name.
+func (p DeployPhase) FindJob(name string) (PhaseJob, bool) {
+	for _, job := range p.Jobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return PhaseJob{}, false
+}
+
+// PhaseJob is a one-off job of a phase. See JobRunner.
+type PhaseJob struct {
+	Name string `yaml:"name"`
+	// Template is the path of the Job manifest template in the manifest repository.
+	// It's a Go template rendered with JobTemplateVars.
+	Template string `yaml:"template"`
+	// Path is the path the rendered manifest is committed to in the manifest repository, rendered with JobTemplateVars as well,
+	// so that the GitOps tool, like Argo CD, applies it.
+	Path string `yaml:"path"`
+	// Apply creates the Job in the cluster gocat runs in, instead of committing it to Path.
+	Apply bool `yaml:"apply"`
+}
+
+// PhaseSecret is a secret of a phase managed in the manifest repository. See SecretRotator.
+type PhaseSecret struct {
+	Name string `yaml:"name"`
+	// Path is the path of the manifest of the secret in the manifest repository,
+	// which must be an ExternalSecret to be rotated.
+	Path string `yaml:"path"`
+}
+
+const (
+	CommitStrategyPullRequest = "pullRequest"
+	CommitStrategyDirect      = "direct"
+)
+
+// validateCommitStrategy returns an error if the phase can't push its deploys as CommitStrategy says.
+func (p DeployPhase) validateCommitStrategy() error {
+	switch p.CommitStrategy {
+	case "", CommitStrategyPullRequest:
+		return nil
+	case CommitStrategyDirect:
+		if p.Kind != "kustomize" {
+			return fmt.Errorf("direct is supported only by kustomize, not %s", p.Kind)
+		}
+		if p.TwoPersonRule {
+			return errors.New("direct can't be used with twoPersonRule, as nothing approves the deploys")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown strategy %s. It's either %s or %s", p.CommitStrategy, CommitStrategyPullRequest, CommitStrategyDirect)
+	}
 }
 
 type DeployProject struct {
@@ -45,10 +330,61 @@ type DeployProject struct {
 	dockerRegistry      string
 	filterRegexp        string
 	targetRegexp        string
+	tagStrategy         string
 	DisableBranchDeploy bool
 	steps               []string
 	Alias               string
-	Phases              []DeployPhase
+	// commitMessageTemplate, pullRequestTitleTemplate, and pullRequestBodyTemplate are
+	// Go templates rendered with DeployMessageVars.
+	commitMessageTemplate    string
+	pullRequestTitleTemplate string
+	pullRequestBodyTemplate  string
+	// branchNameTemplate is the Go template of the branch name rendered with BranchNameVars.
+	branchNameTemplate string
+	// calendar is the time zone and the holidays of the project.
+	calendar BusinessCalendar
+	Phases   []DeployPhase
+	// Workspaces are the names of the Slack workspaces the project is available in, like prod-ops.
+	// The project is available in all the workspaces if empty. Its notifications go to the first one.
+	Workspaces []string
+	// MaxConcurrentDeploys is the maximum number of the deploys of all the phases of the project prepared at once. 0 means unlimited.
+	MaxConcurrentDeploys int
+	// features are the names of the features enabled for the project. See FeatureFlags.
+	features []string
+}
+
+// FeatureEnabled returns true if the feature is enabled for the project by CONFIG_FEATURE_FLAGS or its Features key.
+func (p DeployProject) FeatureEnabled(name string) bool {
+	for _, f := range p.features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pinnedPhases returns the names of the phases pinning the image digests.
+func (p DeployProject) pinnedPhases() []string {
+	var names []string
+	for _, phase := range p.Phases {
+		if phase.PinDigest {
+			names = append(names, phase.Name)
+		}
+	}
+	return names
+}
+
+// InWorkspace returns true if the project is available in the Slack workspace.
+func (p DeployProject) InWorkspace(name string) bool {
+	if len(p.Workspaces) == 0 {
+		return true
+	}
+	for _, ws := range p.Workspaces {
+		if ws == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (p DeployProject) FindPhase(name string) DeployPhase {
@@ -81,23 +417,163 @@ func (pj DeployProject) Steps() []string {
 // The regexp is parsed as a template, and the following variables are available:
 //
 //	{{.Branch}}: The branch name of the target commit.
+//	{{.BranchSlug}}: The branch name lowercased, with non-alphanumeric characters replaced with hyphens.
 //	{{.Phase}}: The phase name.
+//	{{.Path}}: The component path of the image. See PhaseImage.
 //
+// It can be overridden per phase and per image. See ImageTagQueries.
 // See ECRClient.FindImageTagByRegexp for more details on how the regexp is used.
 func (pj DeployProject) ImageTagRegexp() string {
 	if pj.filterRegexp == "" {
-		return "^{{.Branch}}$"
+		return pj.TagStrategy().FilterRegexp
 	}
 	return pj.filterRegexp
 }
 
 func (pj DeployProject) TargetRegexp() string {
 	if pj.targetRegexp == "" {
-		return `\b[0-9a-f]{5,40}\b`
+		return pj.TagStrategy().TargetRegexp
 	}
 	return pj.targetRegexp
 }
 
+// TagStrategy returns the preset of the regexps and the order to find the image tag to deploy.
+// See tagStrategies for the available presets.
+func (pj DeployProject) TagStrategy() TagStrategy {
+	if s, ok := tagStrategies[pj.tagStrategy]; ok {
+		return s
+	}
+	return tagStrategies[defaultTagStrategy]
+}
+
+// ImageTagQueries returns the queries to find the image tags to deploy in the phase,
+// one per image of the phase.
+//
+// Regexps are looked up in the image, the phase, and the project, in this order.
+func (pj DeployProject) ImageTagQueries(phase DeployPhase, vars ImageTagVars) []ImageTagQuery {
+	filterRegexp, targetRegexp, order := pj.ImageTagRegexp(), pj.TargetRegexp(), pj.TagStrategy().Order
+	if phase.FilterRegexp != "" {
+		filterRegexp = phase.FilterRegexp
+	}
+	if phase.TargetRegexp != "" {
+		targetRegexp = phase.TargetRegexp
+	}
+	if phase.Name != "" {
+		vars.Phase = phase.Name
+	}
+
+	if len(phase.Images) == 0 {
+		return []ImageTagQuery{{Image: pj.DockerRepository(), FilterRegexp: filterRegexp, TargetRegexp: targetRegexp, Order: order, Vars: vars}}
+	}
+
+	var queries []ImageTagQuery
+	for _, image := range phase.Images {
+		q := ImageTagQuery{Image: image.Name, FilterRegexp: filterRegexp, TargetRegexp: targetRegexp, Order: order, Vars: vars}
+		q.Vars.Path = image.Path
+		if image.FilterRegexp != "" {
+			q.FilterRegexp = image.FilterRegexp
+		}
+		if image.TargetRegexp != "" {
+			q.TargetRegexp = image.TargetRegexp
+		}
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+// ImageTagQuery returns the query for the primary image of the phase,
+// which is the first one when the phase has multiple images.
+func (pj DeployProject) ImageTagQuery(phase DeployPhase, vars ImageTagVars) ImageTagQuery {
+	return pj.ImageTagQueries(phase, vars)[0]
+}
+
+// SemverTagQuery returns the query for the highest semver tag of the primary image satisfying the constraint.
+//
+// The regexps of the semver-latest strategy are used unless the project already uses it,
+// so that the project's regexps, if any, are respected.
+func (pj DeployProject) SemverTagQuery(phase DeployPhase, constraint string) ImageTagQuery {
+	q := pj.ImageTagQuery(phase, ImageTagVars{Branch: pj.DefaultBranch()})
+	if q.Order != TagOrderSemver {
+		s := tagStrategies["semver-latest"]
+		q.FilterRegexp, q.TargetRegexp, q.Order = s.FilterRegexp, s.TargetRegexp, s.Order
+	}
+	q.Constraint = constraint
+	return q
+}
+
+// CommitMessageTemplate returns the template of the commit message for the gitops commit.
+// See DeployMessageVars for the available variables.
+func (pj DeployProject) CommitMessageTemplate() string {
+	if pj.commitMessageTemplate == "" {
+		return "Change docker image tag. target: {{.Path}}, phase: {{.Phase}}, tag: {{.Tag}}."
+	}
+	return pj.commitMessageTemplate
+}
+
+// PullRequestTitleTemplate returns the template of the deploy pull request title.
+// See DeployMessageVars for the available variables.
+func (pj DeployProject) PullRequestTitleTemplate() string {
+	if pj.pullRequestTitleTemplate == "" {
+		return "Deploy {{.Project}} {{.Branch}}"
+	}
+	return pj.pullRequestTitleTemplate
+}
+
+// PullRequestBodyTemplate returns the template of the deploy pull request body.
+// See DeployMessageVars for the available variables.
+func (pj DeployProject) PullRequestBodyTemplate() string {
+	if pj.pullRequestBodyTemplate == "" {
+		return defaultPullRequestBodyTemplate
+	}
+	return pj.pullRequestBodyTemplate
+}
+
+// BranchNameTemplate returns the template of the name of the branch the deploy is pushed to.
+// See BranchNameVars for the available variables.
+func (pj DeployProject) BranchNameTemplate() string {
+	if pj.branchNameTemplate == "" {
+		return defaultBranchNameTemplate
+	}
+	return pj.branchNameTemplate
+}
+
+// DeployBranchName returns the name of the branch the deploy of the tag to the phase is pushed to,
+// which is the head of the deploy pull request.
+// All the gitops plugins, like kustomize and kanvas, name the branch with this so that they never drift apart.
+//
+// digest is the digest of the image, which is appended to the branch name if given,
+// as mutable tags like latest are deployed repeatedly with different digests.
+func (pj DeployProject) DeployBranchName(phase, tag, branch, digest string) (string, error) {
+	name, err := BranchNameVars{
+		Project:    pj.ID,
+		Phase:      phase,
+		Tag:        tag,
+		Branch:     branch,
+		BranchSlug: branchSlug(branch),
+	}.Parse(pj.BranchNameTemplate())
+	if err != nil {
+		return "", fmt.Errorf("unable to render the branch name template of %s: %w", pj.ID, err)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, " \t\n~^:?*[\\") || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid branch name %q rendered from the branch name template of %s", name, pj.ID)
+	}
+	if d := strings.TrimPrefix(digest, "sha256:"); len(d) >= 12 {
+		name += "-" + d[:12]
+	}
+	return name, nil
+}
+
+// Calendar returns the time zone and the holidays of the project, configured by the TimeZone,
+// HolidayCalendar, and Holidays keys of the project configmap.
+// It's in the server's local time zone with no holidays if none of them is configured.
+func (pj DeployProject) Calendar() BusinessCalendar {
+	if pj.calendar.Location == nil {
+		return BusinessCalendar{Location: time.Local}
+	}
+	return pj.calendar
+}
+
 func (pj DeployProject) DockerRepository() string {
 	return pj.dockerRegistry
 }
@@ -125,62 +601,348 @@ func (pj DeployProject) ECRRegistryId() string {
 	return path[0]
 }
 
+// ProjectList is the list of the projects, which ListRefresher reloads in the background while the commands read it.
+// Its projects are read via All, Find, and the like, which hold the lock the reload replaces them under.
 type ProjectList struct {
-	Items []DeployProject
+	items []DeployProject
+	// mu guards the projects, the errors, and the configs. It's shared by the lists scoped by InWorkspace,
+	// and nil for the lists made without NewProjectList, like in the tests, which are never reloaded concurrently.
+	mu *sync.RWMutex
+	// base is the list of all the projects the list is scoped from by InWorkspace, which Reload reloads.
+	base      *ProjectList
+	workspace string
+	// errors are the validation errors of the projects in the last reload.
+	errors map[string]error
+	// configs are the data of the configmaps of the projects in the last reload, keyed by the project IDs.
+	configs map[string]map[string]string
+	// flags are the feature flags the projects are parsed with.
+	flags FeatureFlags
 }
 
-func NewProjectList() (pl ProjectList) {
+func NewProjectList(flags FeatureFlags) (pl ProjectList) {
+	pl.mu = &sync.RWMutex{}
+	pl.flags = flags
 	pl.Reload()
 	return
 }
 
+// lock locks the list for writing, and returns the function to unlock it.
+func (p *ProjectList) lock() func() {
+	if p.mu == nil {
+		return func() {}
+	}
+	p.mu.Lock()
+	return p.mu.Unlock
+}
+
+// rlock locks the list for reading, and returns the function to unlock it.
+func (p *ProjectList) rlock() func() {
+	if p.mu == nil {
+		return func() {}
+	}
+	p.mu.RLock()
+	return p.mu.RUnlock
+}
+
+// All returns the projects. The slice is replaced by the reloads, never modified in place, so it can be iterated without the lock.
+func (p *ProjectList) All() []DeployProject {
+	defer p.rlock()()
+	return p.items
+}
+
+// InWorkspace returns the list of the projects available in the Slack workspace.
+func (p *ProjectList) InWorkspace(name string) *ProjectList {
+	scoped := &ProjectList{base: p, workspace: name, mu: p.mu, flags: p.flags}
+	scoped.filter()
+	return scoped
+}
+
+func (p *ProjectList) filter() {
+	defer p.lock()()
+	var items []DeployProject
+	for _, pj := range p.base.items {
+		if pj.InWorkspace(p.workspace) {
+			items = append(items, pj)
+		}
+	}
+	p.items = items
+}
+
 func (p *ProjectList) Reload() {
-	var tmp []DeployProject
+	if p.base != nil {
+		p.base.Reload()
+		p.filter()
+		return
+	}
 	cml := getConfigMapList("project")
-	for _, cm := range cml.Items {
-		pj := DeployProject{}
-		pj.ID = cm.Name
-		pj.Kind = cm.Data["Kind"]
-		pj.jenkinsJob = cm.Data["JenkinsJob"]
-		pj.gitHubRepository = cm.Data["GitHubRepository"]
-		pj.dockerRegistry = cm.Data["DockerRegistry"]
-		pj.defaultBranch = cm.Data["DefaultBranch"]
-		pj.filterRegexp = cm.Data["FilterRegexp"]
-		pj.targetRegexp = cm.Data["TargetRegexp"]
-		pj.funcName = cm.Data["FuncName"]
-		pj.Alias = cm.Data["Alias"]
-		pj.DisableBranchDeploy = cm.Data["DisableBranchDeploy"] == "true"
-		if err := yaml.Unmarshal([]byte(cm.Data["Steps"]), &pj.steps); err != nil {
-			fmt.Printf("[ERROR] Failed to parse steps for %s: %s\n", pj.ID, err)
-		}
-		if err := yaml.Unmarshal([]byte(cm.Data["Phases"]), &pj.Phases); err != nil {
-			fmt.Printf("[ERROR] Failed to parse phases for %s: %s\n", pj.ID, err)
-		}
-		for i, phase := range pj.Phases {
-			if phase.Kind == "" {
-				pj.Phases[i].Kind = pj.Kind
-			}
-			if phase.Destination.Kind == "" {
-				pj.Phases[i].Destination.Kind = pj.Phases[i].Kind
-			}
-			if phase.Destination.Kustomize.Path == "" {
-				pj.Phases[i].Destination.Kustomize.Path = phase.Path
-			}
-			if phase.Destination.Kustomize.Image == "" {
-				pj.Phases[i].Destination.Kustomize.Image = pj.DockerRepository()
+	if cml == nil {
+		log.Printf("[ERROR] Failed to list the projects. Keeping the %d projects loaded before", len(p.All()))
+		return
+	}
+	for _, cm := range p.reload(cml.Items) {
+		if err := annotateConfigMap(cm.Name, lastKnownGoodAnnotation, cm.Annotations[lastKnownGoodAnnotation]); err != nil {
+			log.Printf("[ERROR] Failed to record the last-known-good definition of project %s: %s", cm.Name, err)
+		}
+	}
+}
+
+// lastKnownGoodAnnotation holds the api version and the data of the latest valid definition of the project configmap,
+// which gocat falls back to when the configmap becomes invalid, even after a restart.
+const lastKnownGoodAnnotation = "gocat.zaim.net/last-known-good"
+
+type lastKnownGood struct {
+	APIVersion string            `json:"apiVersion"`
+	Data       map[string]string `json:"data"`
+}
+
+// reload replaces the projects with the ones parsed from the configmaps.
+// The projects failing the validation fall back to the definitions loaded before, or to the ones recorded
+// in the last-known-good annotation, or are left out if they have neither, so that one malformed project never breaks the others.
+// It returns the valid configmaps whose annotation is outdated, with the annotation updated for the caller to record.
+func (p *ProjectList) reload(cms []v1.ConfigMap) (outdated []v1.ConfigMap) {
+	var tmp []DeployProject
+	errs := map[string]error{}
+	configs := map[string]map[string]string{}
+	for _, cm := range cms {
+		configs[cm.Name] = cm.Data
+		pj, err := safeParseProject(cm, p.flags)
+		if err == nil {
+			tmp = append(tmp, pj)
+			if lkg, ok := newLastKnownGood(cm); ok {
+				cm.Annotations = mergeStringMaps(cm.Annotations, map[string]string{lastKnownGoodAnnotation: lkg})
+				outdated = append(outdated, cm)
 			}
-			if phase.Destination.ECS.Image == "" {
-				pj.Phases[i].Destination.ECS.Image = pj.DockerRepository()
+			continue
+		}
+		errs[cm.Name] = err
+		if pj := p.Find(cm.Name); pj.ID != "" {
+			log.Printf("[ERROR] Project %s is invalid. Keeping the definition loaded before: %s", cm.Name, err)
+			tmp = append(tmp, pj)
+		} else if pj, ok := parseLastKnownGood(cm, p.flags); ok {
+			log.Printf("[ERROR] Project %s is invalid. Falling back to the last-known-good definition: %s", cm.Name, err)
+			tmp = append(tmp, pj)
+		} else {
+			log.Printf("[ERROR] Project %s is invalid and not loaded: %s", cm.Name, err)
+		}
+	}
+	defer p.lock()()
+	p.items = tmp
+	p.errors = errs
+	p.configs = configs
+	return outdated
+}
+
+// safeParseProject parses the configmap like parseProject, turning a panic on a malformed configmap into the error.
+func safeParseProject(cm v1.ConfigMap, flags FeatureFlags) (pj DeployProject, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panicked: %v", v)
+		}
+	}()
+	return parseProject(cm, flags)
+}
+
+// newLastKnownGood returns the last-known-good annotation for the valid configmap, and whether it differs from the recorded one.
+func newLastKnownGood(cm v1.ConfigMap) (string, bool) {
+	b, err := json.Marshal(lastKnownGood{APIVersion: configMapAPIVersion(cm), Data: cm.Data})
+	if err != nil {
+		return "", false
+	}
+	return string(b), cm.Annotations[lastKnownGoodAnnotation] != string(b)
+}
+
+// parseLastKnownGood parses the definition recorded in the last-known-good annotation of the configmap.
+func parseLastKnownGood(cm v1.ConfigMap, flags FeatureFlags) (DeployProject, bool) {
+	var lkg lastKnownGood
+	if err := json.Unmarshal([]byte(cm.Annotations[lastKnownGoodAnnotation]), &lkg); err != nil {
+		return DeployProject{}, false
+	}
+	cm.Annotations = mergeStringMaps(cm.Annotations, map[string]string{configAPIVersionAnnotation: lkg.APIVersion})
+	cm.Data = lkg.Data
+	pj, err := safeParseProject(cm, flags)
+	return pj, err == nil
+}
+
+func mergeStringMaps(a, b map[string]string) map[string]string {
+	m := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
+
+// FeatureFlags returns the feature flags the projects are parsed with.
+func (p *ProjectList) FeatureFlags() FeatureFlags {
+	return p.flags
+}
+
+// Errors returns the validation errors of the projects in the last reload, keyed by the project IDs.
+func (p *ProjectList) Errors() map[string]error {
+	if p.base != nil {
+		return p.base.Errors()
+	}
+	defer p.rlock()()
+	return p.errors
+}
+
+// Configs returns the data of the configmaps of the projects in the last reload, keyed by the project IDs,
+// or nil if the projects were never loaded from Kubernetes.
+func (p *ProjectList) Configs() map[string]map[string]string {
+	if p.base != nil {
+		return p.base.Configs()
+	}
+	defer p.rlock()()
+	return p.configs
+}
+
+// projectErrorsText returns the text reporting the validation errors of the projects, or empty if none.
+func projectErrorsText(errs map[string]error) string {
+	if len(errs) == 0 {
+		return ""
+	}
+	ids := make([]string, 0, len(errs))
+	for id := range errs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	text := "\n:warning: Some projects are invalid. The last-known-good definitions are used for them, if any:"
+	for _, id := range ids {
+		text += fmt.Sprintf("\n• *%s*: %s", id, errs[id])
+	}
+	return text
+}
+
+// parseProject returns the project the configmap defines, or the errors of all the invalid keys of it.
+// The features of the project are resolved from the flags of CONFIG_FEATURE_FLAGS and its Features key.
+func parseProject(cm v1.ConfigMap, flags FeatureFlags) (DeployProject, error) {
+	var errs []string
+	pj := DeployProject{}
+	pj.ID = cm.Name
+	if err := checkConfigVersion("project", configMapAPIVersion(cm), cm.Data); err != nil {
+		return pj, err
+	}
+	pj.Kind = cm.Data["Kind"]
+	pj.jenkinsJob = cm.Data["JenkinsJob"]
+	pj.gitHubRepository = cm.Data["GitHubRepository"]
+	pj.dockerRegistry = cm.Data["DockerRegistry"]
+	pj.defaultBranch = cm.Data["DefaultBranch"]
+	pj.filterRegexp = cm.Data["FilterRegexp"]
+	pj.targetRegexp = cm.Data["TargetRegexp"]
+	pj.tagStrategy = cm.Data["TagStrategy"]
+	if _, ok := tagStrategies[pj.tagStrategy]; pj.tagStrategy != "" && !ok {
+		errs = append(errs, fmt.Sprintf("unknown tag strategy %s", pj.tagStrategy))
+	}
+	pj.funcName = cm.Data["FuncName"]
+	pj.Alias = cm.Data["Alias"]
+	if _, err := regexp.Compile(pj.Alias); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid Alias: %s", err))
+	}
+	pj.DisableBranchDeploy = cm.Data["DisableBranchDeploy"] == "true"
+	pj.commitMessageTemplate = cm.Data["CommitMessageTemplate"]
+	pj.pullRequestTitleTemplate = cm.Data["PullRequestTitleTemplate"]
+	pj.pullRequestBodyTemplate = cm.Data["PullRequestBodyTemplate"]
+	pj.branchNameTemplate = cm.Data["BranchNameTemplate"]
+	for _, ws := range strings.Split(cm.Data["Workspaces"], ",") {
+		if ws = strings.TrimSpace(ws); ws != "" {
+			pj.Workspaces = append(pj.Workspaces, ws)
+		}
+	}
+	if v := cm.Data["MaxConcurrentDeploys"]; v != "" {
+		max, err := strconv.Atoi(v)
+		if err != nil || max < 0 {
+			errs = append(errs, fmt.Sprintf("invalid MaxConcurrentDeploys: %s", v))
+		}
+		pj.MaxConcurrentDeploys = max
+	}
+	overrides, err := parseProjectFeatures(cm.Data["Features"])
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("invalid Features: %s", err))
+	}
+	pj.features = resolveFeatures(flags, pj.ID, overrides)
+	calendar, err := NewBusinessCalendar(cm.Data["TimeZone"], cm.Data["HolidayCalendar"], strings.Split(cm.Data["Holidays"], "\n"))
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("invalid calendar: %s", err))
+	}
+	pj.calendar = calendar
+	if err := yaml.Unmarshal([]byte(cm.Data["Steps"]), &pj.steps); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid Steps: %s", err))
+	}
+	if err := yaml.Unmarshal([]byte(cm.Data["Phases"]), &pj.Phases); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid Phases: %s", err))
+	}
+	for i, phase := range pj.Phases {
+		if phase.Kind == "" {
+			pj.Phases[i].Kind = pj.Kind
+		}
+		if pj.FeatureEnabled(FeaturePinDigest) {
+			pj.Phases[i].PinDigest = true
+		} else if enabled, ok := overrides[FeaturePinDigest]; ok && !enabled {
+			pj.Phases[i].PinDigest = false
+		}
+		pj.Phases[i].preserveYAML = pj.FeatureEnabled(FeaturePreserveYAML)
+		if phase.Destination.Kind == "" {
+			pj.Phases[i].Destination.Kind = pj.Phases[i].Kind
+		}
+		if phase.Destination.Kustomize.Path == "" {
+			pj.Phases[i].Destination.Kustomize.Path = phase.Path
+		}
+		if phase.Destination.Kustomize.Image == "" {
+			pj.Phases[i].Destination.Kustomize.Image = pj.DockerRepository()
+			if len(phase.Images) > 0 {
+				pj.Phases[i].Destination.Kustomize.Image = phase.Images[0].Name
 			}
 		}
-		tmp = append(tmp, pj)
+		if phase.Destination.ECS.Image == "" {
+			pj.Phases[i].Destination.ECS.Image = pj.DockerRepository()
+		}
+		if err := phase.SLOGate.validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid sloGate of %s: %s", phase.Name, err))
+		}
+		if err := phase.ImageSignature.validate(pj.Phases[i].Kind); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid imageSignature of %s: %s", phase.Name, err))
+		}
+		if phase.SBOM && !phase.ImageSignature.hasIdentity() {
+			errs = append(errs, fmt.Sprintf("invalid sbom of %s: the key, or certificateIdentity and certificateOIDCIssuer of imageSignature are required to verify the attestations of the SBOMs", phase.Name))
+		}
+		if err := phase.Promotion.validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid promotion of %s: %s", phase.Name, err))
+		}
+		if err := phase.SyntheticChecks.validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid syntheticChecks of %s: %s", phase.Name, err))
+		}
+		if err := phase.BlueGreen.validate(pj.Phases[i].Kind); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid blueGreen of %s: %s", phase.Name, err))
+		}
+		if err := phase.Hooks.validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid hooks of %s: %s", phase.Name, err))
+		}
+		if err := phase.TagPolicy.validate(pj.Phases[i].Kind); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid tagPolicy of %s: %s", phase.Name, err))
+		} else if phase.TagPolicy.Enabled() {
+			pj.Phases[i].TagPolicy, _ = phase.TagPolicy.compile()
+		}
+		if err := phase.Env.validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid env of %s: %s", phase.Name, err))
+		}
+		if err := pj.Phases[i].validateCommitStrategy(); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid commitStrategy of %s: %s", phase.Name, err))
+		}
+		if phase.MaxConcurrentDeploys < 0 {
+			errs = append(errs, fmt.Sprintf("invalid maxConcurrentDeploys of %s: %d", phase.Name, phase.MaxConcurrentDeploys))
+		}
+	}
+	if len(errs) > 0 {
+		return pj, errors.New(strings.Join(errs, "; "))
 	}
-	p.Items = tmp
+	return pj, nil
 }
 
-func (p ProjectList) FindAll(ids []string) (o []DeployProject) {
+func (p *ProjectList) FindAll(ids []string) (o []DeployProject) {
 	for _, id := range ids {
-		for _, pj := range p.Items {
+		for _, pj := range p.All() {
 			if pj.ID == id {
 				o = append(o, pj)
 			}
@@ -189,8 +951,8 @@ func (p ProjectList) FindAll(ids []string) (o []DeployProject) {
 	return o
 }
 
-func (p ProjectList) Find(id string) DeployProject {
-	for _, pj := range p.Items {
+func (p *ProjectList) Find(id string) DeployProject {
+	for _, pj := range p.All() {
 		if pj.ID == id {
 			return pj
 		}
@@ -199,11 +961,11 @@ func (p ProjectList) Find(id string) DeployProject {
 	return DeployProject{}
 }
 
-func (p ProjectList) FindByAlias(id string) (DeployProject, error) {
-	for _, pj := range p.Items {
+func (p *ProjectList) FindByAlias(id string) (DeployProject, error) {
+	for _, pj := range p.All() {
 		if regexp.MustCompile(pj.Alias).Match([]byte(id)) {
 			return pj, nil
 		}
 	}
-	return DeployProject{}, fmt.Errorf("[ERROR] No Such Project. ID: %s", id)
+	return DeployProject{}, &ProjectNotFoundError{ID: id, Suggestions: p.suggestProjects(id)}
 }
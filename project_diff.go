@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ProjectConfigDiff is the summary of the changes of the project configmaps between two reloads,
+// which is posted to the admin channel so that the config drift is visible and reviewable in Slack.
+type ProjectConfigDiff struct {
+	Added   []string
+	Removed []string
+	// Changed is the map from the IDs of the changed projects to the keys of the configmaps changed,
+	// with the names of the phases changed for Phases, like "Phases (changed: production)".
+	Changed map[string][]string
+}
+
+// diffProjectConfigs compares the data of the project configmaps before and after the reload.
+func diffProjectConfigs(before, after map[string]map[string]string) ProjectConfigDiff {
+	diff := ProjectConfigDiff{Changed: map[string][]string{}}
+	for id, data := range after {
+		old, ok := before[id]
+		if !ok {
+			diff.Added = append(diff.Added, id)
+			continue
+		}
+		if fields := diffConfigFields(old, data); len(fields) > 0 {
+			diff.Changed[id] = fields
+		}
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// diffConfigFields returns the keys of the configmap changed, in order.
+func diffConfigFields(before, after map[string]string) []string {
+	var fields []string
+	for key, value := range after {
+		if old, ok := before[key]; !ok || old != value {
+			fields = append(fields, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	for i, key := range fields {
+		if key == "Phases" {
+			fields[i] += diffPhases(before[key], after[key])
+		}
+	}
+	return fields
+}
+
+// diffPhases details the change of Phases with the names of the phases added, removed, and changed,
+// or returns empty if either is malformed, which the validation of the projects reports instead.
+func diffPhases(before, after string) string {
+	parse := func(s string) (map[string]interface{}, bool) {
+		var phases []map[string]interface{}
+		if err := yaml.Unmarshal([]byte(s), &phases); err != nil {
+			return nil, false
+		}
+		byName := map[string]interface{}{}
+		for _, phase := range phases {
+			byName[fmt.Sprint(phase["name"])] = phase
+		}
+		return byName, true
+	}
+	prev, ok := parse(before)
+	if !ok {
+		return ""
+	}
+	next, ok := parse(after)
+	if !ok {
+		return ""
+	}
+	var added, removed, changed []string
+	for name, phase := range next {
+		if p, ok := prev[name]; !ok {
+			added = append(added, name)
+		} else if !reflect.DeepEqual(p, phase) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := next[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	var details []string
+	for _, d := range []struct {
+		label string
+		names []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		if len(d.names) > 0 {
+			sort.Strings(d.names)
+			details = append(details, d.label+": "+strings.Join(d.names, ", "))
+		}
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(details, "; ") + ")"
+}
+
+func (d ProjectConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Text returns the summary in mrkdwn.
+func (d ProjectConfigDiff) Text() string {
+	lines := []string{":memo: The project configs changed on reload"}
+	for _, id := range d.Added {
+		lines = append(lines, fmt.Sprintf("• *%s* added", id))
+	}
+	for _, id := range d.Removed {
+		lines = append(lines, fmt.Sprintf("• *%s* removed", id))
+	}
+	ids := make([]string, 0, len(d.Changed))
+	for id := range d.Changed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		lines = append(lines, fmt.Sprintf("• *%s* changed: %s", id, strings.Join(d.Changed[id], ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiffProjectConfigs(t *testing.T) {
+	before := map[string]map[string]string{
+		"api": {
+			"Kind":   "kustomize",
+			"Alias":  "api",
+			"Phases": "- name: staging\n  path: staging\n- name: production\n  path: production\n- name: sandbox\n",
+		},
+		"web":    {"Kind": "kustomize"},
+		"legacy": {"Kind": "jenkins"},
+	}
+	after := map[string]map[string]string{
+		"api": {
+			"Kind":           "kustomize",
+			"DockerRegistry": "registry.example.com",
+			"Phases":         "- name: staging\n  path: staging\n- name: production\n  path: production/v2\n- name: canary\n",
+		},
+		"web":    {"Kind": "kustomize"},
+		"worker": {"Kind": "kustomize"},
+	}
+
+	diff := diffProjectConfigs(before, after)
+	require.False(t, diff.Empty())
+	require.Equal(t, []string{"worker"}, diff.Added)
+	require.Equal(t, []string{"legacy"}, diff.Removed)
+	require.Equal(t, map[string][]string{
+		"api": {"Alias", "DockerRegistry", "Phases (added: canary; removed: sandbox; changed: production)"},
+	}, diff.Changed)
+	require.Equal(t, ":memo: The project configs changed on reload\n"+
+		"• *worker* added\n"+
+		"• *legacy* removed\n"+
+		"• *api* changed: Alias, DockerRegistry, Phases (added: canary; removed: sandbox; changed: production)", diff.Text())
+
+	// The malformed phases are only reported as changed
+	require.Equal(t, []string{"Phases"}, diffConfigFields(map[string]string{"Phases": "- name: staging"}, map[string]string{"Phases": "{"}))
+	require.True(t, diffProjectConfigs(after, after).Empty())
+}
+
+func TestProjectList_Configs(t *testing.T) {
+	list := &ProjectList{}
+	require.Nil(t, list.Configs())
+	list.reload([]v1.ConfigMap{{ObjectMeta: metav1.ObjectMeta{Name: "myapp"}, Data: map[string]string{"Kind": "kustomize"}}})
+	require.Equal(t, map[string]map[string]string{"myapp": {"Kind": "kustomize"}}, list.Configs())
+	require.Equal(t, list.Configs(), list.InWorkspace("").Configs())
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// projectAddCallbackID is the callback ID of the modal the project add command opens.
+const projectAddCallbackID = "gocat_project_add"
+
+// projectAddActionValue is the value of the button that opens the modal.
+// Slack gives no trigger ID to app mentions, so the command posts the button instead of opening the modal by itself.
+const projectAddActionValue = "project_add_open"
+
+// projectAddInputs are the inputs of the project add modal.
+// Each of them becomes the key of the project configmap of the same name, except for phases.
+var projectAddInputs = []struct {
+	name        string
+	label       string
+	placeholder string
+	multiline   bool
+	optional    bool
+}{
+	{"id", "Project ID", "Name of the configmap, like myapp", false, false},
+	{"GitHubRepository", "GitHub repository", "Repository name in the organization of the manifest repository", false, false},
+	{"DockerRegistry", "ECR repository", "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp", false, false},
+	{"phases", "Phases", "One phase per line, like: staging overlays/staging/kustomization.yaml", true, false},
+	{"DefaultBranch", "Default branch", "master if empty", false, true},
+	{"Alias", "Alias", "Regexp matching the project name in commands. Project ID if empty", false, true},
+	{"FilterRegexp", "Image tag regexp", "Regexp of the image tags to deploy. The default tag strategy is used if empty", false, true},
+}
+
+var projectIDPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// projectAddBlocks returns the message with the button that opens the project add modal.
+func projectAddBlocks() []slack.Block {
+	text := slack.NewTextBlockObject("mrkdwn", "Add a new kustomize project. The inputs are validated against GitHub and ECR before the project is saved.", false, false)
+	btn := slack.NewButtonBlockElement("", projectAddActionValue, slack.NewTextBlockObject("plain_text", "Add project", false, false))
+	return []slack.Block{slack.NewSectionBlock(text, nil, slack.NewAccessory(btn))}
+}
+
+// openProjectAdd opens the project add modal.
+// The channel of the button is kept in the private metadata of the modal to report the result to.
+func (h interactionHandler) openProjectAdd(callback slack.InteractionCallback) error {
+	if err := h.checkAdmin(callback.User.ID); err != nil {
+		h.postForbiddenError(callback.ResponseURL, callback.User.ID)
+		return nil
+	}
+
+	var blocks []slack.Block
+	for _, in := range projectAddInputs {
+		element := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", in.placeholder, false, false), in.name)
+		element.Multiline = in.multiline
+		block := slack.NewInputBlock(in.name, slack.NewTextBlockObject("plain_text", in.label, false, false), nil, element)
+		block.Optional = in.optional
+		blocks = append(blocks, block)
+	}
+
+	modal := slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		Title:           slack.NewTextBlockObject("plain_text", "Add project", false, false),
+		Submit:          slack.NewTextBlockObject("plain_text", "Add", false, false),
+		Close:           slack.NewTextBlockObject("plain_text", "Cancel", false, false),
+		Blocks:          slack.Blocks{BlockSet: blocks},
+		CallbackID:      projectAddCallbackID,
+		PrivateMetadata: callback.Channel.ID,
+	}
+	_, err := h.client.OpenView(callback.TriggerID, modal)
+	return err
+}
+
+// checkAdmin returns an error if the user isn't allowed to add the projects, like SlackListener.checkAdmin
+// but with the users of the workspace the interaction comes from.
+func (h interactionHandler) checkAdmin(userID string) error {
+	if !h.userList.FindBySlackUserID(userID).IsAdmin() {
+		return fmt.Errorf("<@%s> is not allowed to add the projects. Please contact admin.", userID)
+	}
+	return nil
+}
+
+// submitProjectAdd validates the inputs of the project add modal, and saves the project as a configmap.
+// Invalid inputs are shown in the modal so that the user can fix them without starting over.
+// The user is checked again on submit, as the role may have been revoked since the modal was opened.
+func (h interactionHandler) submitProjectAdd(w http.ResponseWriter, callback slack.InteractionCallback) {
+	if err := h.checkAdmin(callback.User.ID); err != nil {
+		log.Printf("[WARNING] The project add by %s is rejected: %s", callback.User.ID, err)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(slack.NewErrorsViewSubmissionResponse(map[string]string{"id": err.Error()}))
+		return
+	}
+
+	values := map[string]string{}
+	for _, in := range projectAddInputs {
+		values[in.name] = strings.TrimSpace(callback.View.State.Values[in.name][in.name].Value)
+	}
+
+	id, data, errs := parseProjectAddInputs(values)
+	if len(errs) == 0 && h.projectList.Find(id).ID != "" {
+		errs = map[string]string{"id": fmt.Sprintf("%s already exists", id)}
+	}
+	if len(errs) == 0 {
+		errs = h.validateProject(id, data)
+	}
+	if len(errs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(slack.NewErrorsViewSubmissionResponse(errs)); err != nil {
+			log.Printf("[ERROR] Failed to respond to the project add modal: %s", err)
+		}
+		return
+	}
+
+	if err := createConfigMap(id, "project", data); err != nil {
+		log.Printf("[ERROR] Failed to create the project %s: %s", id, err)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(slack.NewErrorsViewSubmissionResponse(map[string]string{"id": fmt.Sprintf("Failed to save the project: %s", err)}))
+		return
+	}
+	h.refresher.Refresh()
+	log.Printf("[INFO] Project %s is added by %s", id, callback.User.ID)
+
+	if channel := callback.View.PrivateMetadata; channel != "" {
+		text := fmt.Sprintf("<@%s> added the project *%s* (%s)", callback.User.ID, id, data["GitHubRepository"])
+		if _, _, err := h.client.PostMessage(channel, slack.MsgOptionText(text, false)); err != nil {
+			log.Printf("[ERROR] Failed to post the result of the project add: %s", err)
+		}
+	}
+}
+
+// parseProjectAddInputs converts the inputs of the project add modal into the data of the project configmap.
+// It returns the errors keyed by the block ID of the invalid inputs.
+func parseProjectAddInputs(values map[string]string) (string, map[string]string, map[string]string) {
+	errs := map[string]string{}
+	id := values["id"]
+	if !projectIDPattern.MatchString(id) {
+		errs["id"] = "Use lowercase alphanumeric characters and hyphens only"
+	}
+
+	data := map[string]string{"Kind": "kustomize"}
+	for _, in := range projectAddInputs {
+		if in.name == "id" || in.name == "phases" || values[in.name] == "" {
+			continue
+		}
+		data[in.name] = values[in.name]
+	}
+	if data["Alias"] == "" {
+		data["Alias"] = id
+	}
+	for _, key := range []string{"Alias", "FilterRegexp"} {
+		if _, err := regexp.Compile(data[key]); err != nil {
+			errs[key] = fmt.Sprintf("Invalid regexp: %s", err)
+		}
+	}
+
+	type phase struct {
+		Name string `yaml:"name"`
+		Path string `yaml:"path"`
+	}
+	var phases []phase
+	for _, line := range strings.Split(values["phases"], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			errs["phases"] = fmt.Sprintf("Invalid line %q. Write the name and the path of kustomization.yaml separated by a space", line)
+			break
+		}
+		p := phase{Name: fields[0], Path: fields[1]}
+		if !strings.HasSuffix(p.Path, ".yaml") {
+			p.Path = strings.TrimSuffix(p.Path, "/") + "/kustomization.yaml"
+		}
+		phases = append(phases, p)
+	}
+	if len(phases) == 0 && errs["phases"] == "" {
+		errs["phases"] = "At least one phase is required"
+	}
+	b, err := yaml.Marshal(phases)
+	if err != nil {
+		errs["phases"] = err.Error()
+	}
+	data["Phases"] = string(b)
+
+	if len(errs) > 0 {
+		return id, nil, errs
+	}
+	return id, data, nil
+}
+
+// validateProject checks that the repository is reachable, the overlay of each phase exists in the manifest repository,
+// and the image tag regexp matches an image in the ECR repository.
+func (h interactionHandler) validateProject(id string, data map[string]string) map[string]string {
+	errs := map[string]string{}
+	pj := DeployProject{
+		ID:               id,
+		gitHubRepository: data["GitHubRepository"],
+		dockerRegistry:   data["DockerRegistry"],
+		defaultBranch:    data["DefaultBranch"],
+		filterRegexp:     data["FilterRegexp"],
+	}
+	if err := yaml.Unmarshal([]byte(data["Phases"]), &pj.Phases); err != nil {
+		errs["phases"] = err.Error()
+		return errs
+	}
+
+	if _, err := h.github.ResolveCommit(pj.GitHubRepository(), pj.DefaultBranch()); err != nil {
+		errs["GitHubRepository"] = fmt.Sprintf("Unable to find the branch %s of %s/%s: %s", pj.DefaultBranch(), h.github.org, pj.GitHubRepository(), err)
+	}
+
+	var missing []string
+	for _, phase := range pj.Phases {
+		ok, err := h.github.FileExists(h.github.repo, h.github.defaultBranch, phase.Path)
+		if err != nil {
+			errs["phases"] = fmt.Sprintf("Unable to check %s: %s", phase.Path, err)
+			break
+		}
+		if !ok {
+			missing = append(missing, phase.Path)
+		}
+	}
+	if len(missing) > 0 {
+		errs["phases"] = fmt.Sprintf("Not found in %s/%s: %s", h.github.org, h.github.repo, strings.Join(missing, ", "))
+	}
+
+	if pj.ECRRepository() == "" {
+		errs["DockerRegistry"] = "Invalid ECR repository"
+		return errs
+	}
+	ecr, err := CreateECRInstance()
+	if err != nil {
+		errs["DockerRegistry"] = err.Error()
+		return errs
+	}
+	if _, err := ecr.FindImageTag(pj.ImageTagQuery(pj.Phases[0], ImageTagVars{Branch: pj.DefaultBranch()})); err != nil {
+		key := "DockerRegistry"
+		if pj.filterRegexp != "" {
+			key = "FilterRegexp"
+		}
+		errs[key] = fmt.Sprintf("No image tag of the branch %s matches: %s", pj.DefaultBranch(), err)
+	}
+	return errs
+}
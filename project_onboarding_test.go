@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProjectAddInputs(t *testing.T) {
+	id, data, errs := parseProjectAddInputs(map[string]string{
+		"id":               "myapp",
+		"GitHubRepository": "myapp",
+		"DockerRegistry":   "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp",
+		"phases":           "staging overlays/staging\n\nproduction overlays/production/kustomization.yaml\n",
+	})
+	require.Nil(t, errs)
+	require.Equal(t, "myapp", id)
+	require.Equal(t, map[string]string{
+		"Kind":             "kustomize",
+		"GitHubRepository": "myapp",
+		"DockerRegistry":   "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp",
+		"Alias":            "myapp",
+		"Phases":           "- name: staging\n  path: overlays/staging/kustomization.yaml\n- name: production\n  path: overlays/production/kustomization.yaml\n",
+	}, data)
+
+	_, data, errs = parseProjectAddInputs(map[string]string{
+		"id":           "MyApp",
+		"FilterRegexp": "(",
+		"phases":       "staging",
+	})
+	require.Nil(t, data)
+	require.Equal(t, []string{"FilterRegexp", "id", "phases"}, sortedKeys(errs))
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestInteractionHandler_SubmitProjectAdd_NotAdmin(t *testing.T) {
+	h := interactionHandler{userList: &UserList{Items: []User{{SlackUserID: "U1"}, {SlackUserID: "U2", isAdmin: true}}}}
+	require.Error(t, h.checkAdmin("U1"))
+	require.NoError(t, h.checkAdmin("U2"))
+
+	w := httptest.NewRecorder()
+	h.submitProjectAdd(w, slack.InteractionCallback{User: slack.User{ID: "U1"}})
+	var res slack.ViewSubmissionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.Equal(t, slack.RAErrors, res.ResponseAction)
+	require.Contains(t, res.Errors["id"], "not allowed")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// maxProjectSuggestions is the number of the project names suggested for a misspelled one.
+const maxProjectSuggestions = 3
+
+// projectSuggestionActionPrefix is the prefix of the values of the buttons running the command again with a suggested project name.
+const projectSuggestionActionPrefix = "suggest|"
+
+// ProjectNotFoundError is the error of FindByAlias, with the project names close to the one not found.
+type ProjectNotFoundError struct {
+	ID          string
+	Suggestions []string
+}
+
+func (e *ProjectNotFoundError) Error() string {
+	msg := fmt.Sprintf("[ERROR] No Such Project. ID: %s", e.ID)
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf(". Did you mean %s?", strings.Join(e.Suggestions, ", "))
+	}
+	return msg
+}
+
+// suggestProjects returns the IDs of the projects close to id in the Levenshtein distance, from the closest.
+// The projects farther than a third of the length of id, or 2 for short ones, aren't suggested.
+func (p *ProjectList) suggestProjects(id string) []string {
+	maxDistance := len(id) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	type candidate struct {
+		id       string
+		distance int
+	}
+	var candidates []candidate
+	for _, pj := range p.All() {
+		if d := levenshtein(strings.ToLower(id), strings.ToLower(pj.ID)); d <= maxDistance {
+			candidates = append(candidates, candidate{pj.ID, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].id < candidates[j].id
+	})
+	var ids []string
+	for i := 0; i < len(candidates) && i < maxProjectSuggestions; i++ {
+		ids = append(ids, candidates[i].id)
+	}
+	return ids
+}
+
+// levenshtein returns the number of the single-character edits to change a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+var mentionPattern = regexp.MustCompile(`<@[^>]+>\s*`)
+
+// projectSuggestionBlocks returns the error message with the buttons running the command text again
+// with each of the suggested project names in place of the one not found.
+func projectSuggestionBlocks(e *ProjectNotFoundError, text string) []slack.Block {
+	blocks := plainBlocks(describeError(e))
+	command := strings.TrimSpace(mentionPattern.ReplaceAllString(text, ""))
+	idPattern := regexp.MustCompile(`(^|\s)` + regexp.QuoteMeta(e.ID) + `(\s|$)`)
+	var buttons []slack.BlockElement
+	for _, s := range e.Suggestions {
+		loc := idPattern.FindStringSubmatchIndex(command)
+		if loc == nil {
+			break
+		}
+		suggested := command[:loc[3]] + s + command[loc[4]:]
+		buttons = append(buttons, slack.NewButtonBlockElement("", projectSuggestionActionPrefix+suggested, slack.NewTextBlockObject("plain_text", suggested, false, false)))
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, slack.NewActionBlock("", buttons...))
+	}
+	return blocks
+}
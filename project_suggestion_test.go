@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindByAliasSuggestions(t *testing.T) {
+	require.Equal(t, 0, levenshtein("payments", "payments"))
+	require.Equal(t, 2, levenshtein("paymnets", "payments"))
+	require.Equal(t, 3, levenshtein("kitten", "sitting"))
+
+	list := ProjectList{items: []DeployProject{
+		{ID: "payments", Alias: "^payments$"},
+		{ID: "payment-worker", Alias: "^payment-worker$"},
+		{ID: "api", Alias: "^api$"},
+	}}
+	_, err := list.FindByAlias("paymnets")
+	var notFound *ProjectNotFoundError
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, []string{"payments"}, notFound.Suggestions)
+	require.EqualError(t, err, "[ERROR] No Such Project. ID: paymnets. Did you mean payments?")
+
+	_, err = list.FindByAlias("zzz")
+	require.EqualError(t, err, "[ERROR] No Such Project. ID: zzz")
+
+	blocks := projectSuggestionBlocks(notFound, "<@U0BOT> deploy paymnets production")
+	require.Len(t, blocks, 2)
+	btn := blocks[1].(*slack.ActionBlock).Elements.ElementSet[0].(*slack.ButtonBlockElement)
+	require.Equal(t, "suggest|deploy payments production", btn.Value)
+}
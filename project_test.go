@@ -1,14 +1,17 @@
 package main
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestProjectFind(t *testing.T) {
 	pl := &ProjectList{
-		Items: []DeployProject{
+		items: []DeployProject{
 			{
 				ID:   "testid",
 				Kind: "testkind",
@@ -22,3 +25,156 @@ func TestProjectFind(t *testing.T) {
 	got := pl.Find("testid")
 	require.Equal(t, want, got)
 }
+
+func TestDeployMessageVarsParse(t *testing.T) {
+	vars := DeployMessageVars{
+		Project: "myapp",
+		Phase:   "staging",
+		Path:    "myapp/overlays/staging/kustomization.yaml",
+		Tag:     "abcdef0",
+		Branch:  "master",
+	}
+	pj := DeployProject{pullRequestTitleTemplate: "[{{.Phase}}] {{.Project}}@{{.Tag}}"}
+
+	got, err := vars.Parse(pj.CommitMessageTemplate())
+	require.NoError(t, err)
+	require.Equal(t, "Change docker image tag. target: myapp/overlays/staging/kustomization.yaml, phase: staging, tag: abcdef0.", got)
+
+	got, err = vars.Parse(pj.PullRequestTitleTemplate())
+	require.NoError(t, err)
+	require.Equal(t, "[staging] myapp@abcdef0", got)
+}
+
+func TestDeployBranchName(t *testing.T) {
+	pj := DeployProject{ID: "myapp"}
+
+	got, err := pj.DeployBranchName("staging", "abcdef0", "master", "")
+	require.NoError(t, err)
+	require.Equal(t, "bot/docker-image-tag-myapp-staging-abcdef0", got)
+
+	got, err = pj.DeployBranchName("staging", "latest", "master", "sha256:0123456789abcdef0123")
+	require.NoError(t, err)
+	require.Equal(t, "bot/docker-image-tag-myapp-staging-latest-0123456789ab", got)
+
+	pj.branchNameTemplate = "deploy/{{.Phase}}/{{.BranchSlug}}/{{.Tag}}"
+	got, err = pj.DeployBranchName("production", "abcdef0", "feature/Foo_bar", "")
+	require.NoError(t, err)
+	require.Equal(t, "deploy/production/feature-foo-bar/abcdef0", got)
+
+	pj.branchNameTemplate = "deploy {{.Tag}}"
+	_, err = pj.DeployBranchName("production", "abcdef0", "master", "")
+	require.Error(t, err)
+
+	// The close button keeps the branch containing _ intact
+	pj.branchNameTemplate = "deploy/{{.Project}}_{{.Phase}}_{{.Tag}}"
+	got, err = pj.DeployBranchName("production", "abcdef0", "master", "")
+	require.NoError(t, err)
+	require.Equal(t, "deploy/myapp_production_abcdef0", got)
+	prID, prNum, branch := parseRejectParams("PR_kwDOA_2_" + got)
+	require.Equal(t, []string{"PR_kwDOA", "2", got}, []string{prID, prNum, branch})
+}
+
+func TestParseProject_CommitStrategy(t *testing.T) {
+	parse := func(phases string) error {
+		_, err := parseProject(v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "myapp"}, Data: map[string]string{"Kind": "kustomize", "Phases": phases}}, nil)
+		return err
+	}
+	require.NoError(t, parse("- name: sandbox\n  commitStrategy: direct\n- name: production\n  commitStrategy: pullRequest\n"))
+	require.EqualError(t, parse("- name: sandbox\n  commitStrategy: drect\n"), "invalid commitStrategy of sandbox: unknown strategy drect. It's either pullRequest or direct")
+	require.EqualError(t, parse("- name: sandbox\n  kind: kanvas\n  commitStrategy: direct\n"), "invalid commitStrategy of sandbox: direct is supported only by kustomize, not kanvas")
+	require.EqualError(t, parse("- name: sandbox\n  commitStrategy: direct\n  twoPersonRule: true\n"), "invalid commitStrategy of sandbox: direct can't be used with twoPersonRule, as nothing approves the deploys")
+}
+
+func TestImageTagQueries(t *testing.T) {
+	pj := DeployProject{dockerRegistry: "123.dkr.ecr.ap-northeast-1.amazonaws.com/myapp"}
+
+	got := pj.ImageTagQueries(DeployPhase{Name: "staging"}, ImageTagVars{Branch: "master"})
+	require.Equal(t, []ImageTagQuery{{
+		Image:        "123.dkr.ecr.ap-northeast-1.amazonaws.com/myapp",
+		FilterRegexp: "^{{.Branch}}$",
+		TargetRegexp: `\b[0-9a-f]{5,40}\b`,
+		Vars:         ImageTagVars{Branch: "master", Phase: "staging"},
+	}}, got)
+
+	phase := DeployPhase{
+		Name:         "production",
+		FilterRegexp: "^{{.Path}}-{{.Branch}}$",
+		Images: []PhaseImage{
+			{Name: "123.dkr.ecr.ap-northeast-1.amazonaws.com/api", Path: "services/api"},
+			{Name: "123.dkr.ecr.ap-northeast-1.amazonaws.com/web", Path: "services/web", TargetRegexp: "^web-[0-9a-f]{7}$"},
+		},
+	}
+	got = pj.ImageTagQueries(phase, ImageTagVars{Branch: "master"})
+	require.Len(t, got, 2)
+	require.Equal(t, "^{{.Path}}-{{.Branch}}$", got[0].FilterRegexp)
+	require.Equal(t, `\b[0-9a-f]{5,40}\b`, got[0].TargetRegexp)
+	require.Equal(t, ImageTagVars{Branch: "master", Phase: "production", Path: "services/api"}, got[0].Vars)
+	require.Equal(t, "^web-[0-9a-f]{7}$", got[1].TargetRegexp)
+	require.Equal(t, "services/web", got[1].Vars.Path)
+	require.Equal(t, "api", got[0].repository())
+	require.Equal(t, "123", got[0].registryID())
+}
+
+func TestProjectListReload(t *testing.T) {
+	cm := func(name string, data map[string]string) v1.ConfigMap {
+		return v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: name}, Data: data}
+	}
+	pl := &ProjectList{}
+	pl.reload([]v1.ConfigMap{
+		cm("myapp", map[string]string{"Kind": "kustomize", "Alias": "myapp", "Phases": "- name: staging\n"}),
+		cm("other", map[string]string{"Kind": "kustomize", "Alias": "other"}),
+	})
+	require.Len(t, pl.items, 2)
+	require.Empty(t, pl.Errors())
+
+	// myapp keeps the definition loaded before, and broken is left out as it has none
+	outdated := pl.reload([]v1.ConfigMap{
+		cm("myapp", map[string]string{"Kind": "kustomize", "Alias": "myapp(", "Phases": "- name: [staging"}),
+		cm("other", map[string]string{"Kind": "jenkins", "Alias": "other"}),
+		cm("broken", map[string]string{"TagStrategy": "unknown"}),
+	})
+	require.Len(t, outdated, 1)
+	require.Equal(t, "other", outdated[0].Name)
+	require.JSONEq(t, `{"apiVersion":"v0","data":{"Kind":"jenkins","Alias":"other"}}`, outdated[0].Annotations[lastKnownGoodAnnotation])
+	require.Equal(t, []string{"myapp", "other"}, []string{pl.items[0].ID, pl.items[1].ID})
+	require.Equal(t, "staging", pl.Find("myapp").Phases[0].Name)
+	require.Equal(t, "jenkins", pl.Find("other").Kind)
+	require.Len(t, pl.Errors(), 2)
+	require.Contains(t, pl.Errors()["myapp"].Error(), "invalid Alias")
+	require.Contains(t, pl.Errors()["myapp"].Error(), "invalid Phases")
+	require.EqualError(t, pl.Errors()["broken"], "unknown tag strategy unknown")
+	require.Contains(t, projectErrorsText(pl.Errors()), "• *broken*: unknown tag strategy unknown\n• *myapp*:")
+	require.Empty(t, projectErrorsText(nil))
+
+	// After a restart, the invalid project falls back to the last-known-good annotation, which is not rewritten
+	recorded := outdated[0]
+	recorded.Data = map[string]string{"Kind": "jenkins", "Alias": "other("}
+	require.Empty(t, (&ProjectList{}).reload([]v1.ConfigMap{outdated[0]}))
+	restarted := &ProjectList{}
+	require.Empty(t, restarted.reload([]v1.ConfigMap{recorded}))
+	require.Equal(t, "jenkins", restarted.Find("other").Kind)
+	require.Contains(t, restarted.Errors()["other"].Error(), "invalid Alias")
+}
+
+func TestProjectListReload_Concurrent(t *testing.T) {
+	cms := []v1.ConfigMap{{ObjectMeta: meta_v1.ObjectMeta{Name: "myapp"}, Data: map[string]string{"Kind": "kustomize", "Alias": "myapp"}}}
+	pl := &ProjectList{mu: &sync.RWMutex{}}
+	scoped := pl.InWorkspace("")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			pl.reload(cms)
+			scoped.filter()
+		}
+	}()
+	// The readers never race with the reloads, which go test -race detects
+	for i := 0; i < 100; i++ {
+		pl.Find("myapp")
+		scoped.All()
+		pl.Errors()
+	}
+	wg.Wait()
+	require.Equal(t, "myapp", pl.Find("myapp").ID)
+}
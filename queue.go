@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// queueActionPrefix is the prefix of the values of the Cancel buttons of the queue command, like queue_cancel|1a2b3c4d.
+const queueActionPrefix = "queue_cancel|"
+
+// queueStates describes the states of the deploys in flight.
+var queueStates = map[DeployEventType]string{
+	DeployEventRequested:        "preparing",
+	DeployEventAwaitingApproval: "awaiting approval",
+	DeployEventApproved:         "deploying",
+}
+
+// canCancel returns true if the user can cancel the deploy from the queue command:
+// the deploy is awaiting approval, and the user requested it or is an admin.
+func canCancel(trace DeployTrace, user User, userID string) bool {
+	if trace.State != DeployEventAwaitingApproval || trace.cancelValue == "" {
+		return false
+	}
+	return trace.Requester == userID || user.IsAdmin()
+}
+
+// queueBlocks lists the deploys in flight from the oldest, with the Cancel buttons of the ones the user can cancel.
+func queueBlocks(traces []DeployTrace, user User, userID string, now time.Time) []slack.Block {
+	if len(traces) == 0 {
+		return plainBlocks("No deploys are in flight.")
+	}
+	blocks := plainBlocks(fmt.Sprintf("*%d deploys in flight*", len(traces)))
+	for _, t := range traces {
+		text := fmt.Sprintf("*%s* *%s* %s", t.Project, t.Phase, queueStates[t.State])
+		if t.Requester != "" {
+			text += fmt.Sprintf(", requested by <@%s>", t.Requester)
+		}
+		if len(t.Events) > 0 {
+			text += fmt.Sprintf(" %s ago", now.Sub(t.Events[0].At).Round(time.Minute))
+		}
+		text += fmt.Sprintf("\nTrace: `%s`", t.ID)
+		var accessory *slack.Accessory
+		if canCancel(t, user, userID) {
+			label := "Cancel"
+			if t.Requester != userID {
+				label = "Cancel (admin)"
+			}
+			btn := slack.NewButtonBlockElement("", queueActionPrefix+t.ID, slack.NewTextBlockObject("plain_text", label, false, false))
+			btn.Style = slack.StyleDanger
+			accessory = slack.NewAccessory(btn)
+		}
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", text, false, false), nil, accessory))
+	}
+	return blocks
+}
+
+// inFlightDeploys returns the deploys in flight of the projects in the list, which are the ones of the workspace.
+func inFlightDeploys(tracer *DeployTracer, projectList *ProjectList) []DeployTrace {
+	var traces []DeployTrace
+	for _, t := range tracer.InFlight() {
+		if projectList.Find(t.Project).ID != "" {
+			traces = append(traces, t)
+		}
+	}
+	return traces
+}
+
+// queue lists the deploys in flight of the workspace.
+func (s *SlackListener) queue(userID string) []slack.Block {
+	return queueBlocks(inFlightDeploys(s.tracer, s.projectList), s.userList.FindBySlackUserID(userID), userID, time.Now())
+}
+
+// cancelQueued cancels the deploy awaiting approval as clicking the Close button of its approval message does,
+// and refreshes the queue the Cancel button was clicked in.
+func (h interactionHandler) cancelQueued(callback slack.InteractionCallback, id string) {
+	userID := callback.User.ID
+	user := h.userList.FindBySlackUserID(userID)
+	trace, ok := h.tracer.Get(id)
+	if !ok || !trace.InFlight() {
+		h.postEphemeral(callback.ResponseURL, fmt.Sprintf("The deploy %s is no longer in flight", id))
+		return
+	}
+	if !canCancel(trace, user, userID) {
+		h.postEphemeral(callback.ResponseURL, fmt.Sprintf("<@%s> can't cancel the deploy %s. Only its requester and admins can cancel it while it's awaiting approval.", userID, id))
+		return
+	}
+	params := strings.Split(trace.cancelValue, "|")
+	if len(params) != 2 {
+		h.postInternalServerError(callback.ResponseURL, userID)
+		return
+	}
+	blocks, err := h.interactorFactory.GetByParams(params[0]).Reject(params[1], userID)
+	if err != nil {
+		log.Printf("[ERROR] Failed to cancel the deploy %s: %s", id, err)
+		h.postEphemeral(callback.ResponseURL, err.Error())
+		return
+	}
+	log.Printf("[INFO] The deploy %s of %s %s is canceled by %s from the queue", id, trace.Project, trace.Phase, userID)
+	if trace.approvalTS != "" {
+		if _, _, _, err := h.client.UpdateMessage(trace.approvalChannel, trace.approvalTS, slack.MsgOptionBlocks(blocks...)); err != nil {
+			log.Printf("[ERROR] Failed to update the approval message of the deploy %s: %s", id, err)
+		}
+	}
+	blocks = queueBlocks(inFlightDeploys(h.tracer, h.projectList), user, userID, time.Now())
+	if err := h.replaceOriginal(callback, originalMessageTS(callback), blocks); err != nil {
+		log.Printf("[ERROR] Failed to refresh the queue: %s", err)
+	}
+}
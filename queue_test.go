@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueBlocks(t *testing.T) {
+	now := time.Date(2024, 3, 8, 9, 30, 0, 0, time.UTC)
+	tracer := NewDeployTracer()
+	tracer.now = func() time.Time { return now.Add(-15 * time.Minute) }
+
+	deployed := tracer.Start("myapp", "staging", "requested")
+	tracer.Emit(deployed, DeployEventDeployed, "merged")
+	mine := tracer.Start("myapp", "production", "requested")
+	tracer.Emit(mine, DeployEventAwaitingApproval, "opened the pull request")
+	tracer.Queue(mine, "U1", "C1", "1700000000.000100", "deploy_kustomize_reject|PR_a_1_branch")
+	others := tracer.Start("api", "production", "requested")
+	tracer.Emit(others, DeployEventAwaitingApproval, "opened the pull request")
+	tracer.Queue(others, "U2", "C1", "1700000000.000200", "deploy_kustomize_reject|PR_b_2_branch")
+	preparing := tracer.Start("api", "staging", "requested")
+
+	traces := tracer.InFlight()
+	require.Len(t, traces, 3)
+	require.Equal(t, []string{mine, others, preparing}, []string{traces[0].ID, traces[1].ID, traces[2].ID})
+
+	cancelButton := func(b slack.Block) string {
+		s := b.(*slack.SectionBlock)
+		if s.Accessory == nil {
+			return ""
+		}
+		return s.Accessory.ButtonElement.Text.Text
+	}
+
+	// The developers can cancel only their own deploys awaiting approval
+	blocks := queueBlocks(traces, User{}, "U1", now)
+	require.Len(t, blocks, 4)
+	require.Equal(t, "*myapp* *production* awaiting approval, requested by <@U1> 15m0s ago\nTrace: `"+mine+"`", blocks[1].(*slack.SectionBlock).Text.Text)
+	require.Equal(t, "Cancel", cancelButton(blocks[1]))
+	require.Equal(t, "", cancelButton(blocks[2]))
+	require.Equal(t, "", cancelButton(blocks[3]))
+
+	// The admins can cancel any
+	blocks = queueBlocks(traces, User{isAdmin: true}, "U3", now)
+	require.Equal(t, "Cancel (admin)", cancelButton(blocks[1]))
+	require.Equal(t, "Cancel (admin)", cancelButton(blocks[2]))
+	require.Equal(t, "", cancelButton(blocks[3]))
+
+	tracer.Emit(others, DeployEventSkipped, "closed")
+	require.Len(t, tracer.InFlight(), 2)
+	require.Equal(t, plainBlocks("No deploys are in flight."), queueBlocks(nil, User{}, "U1", now))
+}
+
+func TestInteractorContext_StartTrace(t *testing.T) {
+	i := InteractorContext{tracer: NewDeployTracer()}
+	m := DeployMetadata{Project: "myapp", Phase: "production", Branch: "master"}
+	i.startTrace(&m, "U1", "C1", "")
+	trace, ok := i.tracer.Get(m.TraceID)
+	require.True(t, ok)
+	require.Equal(t, DeployEventApproved, trace.State)
+	require.Equal(t, "U1", trace.Requester)
+
+	// The deploys of the kinds with no pull request are listed as deploying, with no Cancel button
+	blocks := queueBlocks(i.tracer.InFlight(), User{isAdmin: true}, "U2", time.Now())
+	require.Len(t, blocks, 2)
+	require.Contains(t, blocks[1].(*slack.SectionBlock).Text.Text, "*myapp* *production* deploying, requested by <@U1>")
+	require.Nil(t, blocks[1].(*slack.SectionBlock).Accessory)
+}
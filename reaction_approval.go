@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// handleReactionAddedEvent approves the deploy when an authorized approver reacts to the approval message
+// with the approval reaction, in the same way as clicking the Deploy button of the message.
+// It's handy on mobile where tapping a small button is harder than adding a reaction.
+func (s *SlackListener) handleReactionAddedEvent(ev *slackevents.ReactionAddedEvent) error {
+	defer s.recoverer.Recover("the approval by the reaction", ev.Item.Channel)
+	if s.approvalReaction == "" || ev.Item.Type != "message" {
+		return nil
+	}
+	// Skin tone variants like +1::skin-tone-2 count as the same reaction
+	if reaction := strings.SplitN(ev.Reaction, "::", 2)[0]; reaction != s.approvalReaction {
+		return nil
+	}
+
+	history, err := s.client.GetConversationHistory(&slack.GetConversationHistoryParameters{
+		ChannelID: ev.Item.Channel,
+		Latest:    ev.Item.Timestamp,
+		Inclusive: true,
+		Limit:     1,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to get the reacted message: %w", err)
+	}
+	if len(history.Messages) == 0 {
+		return nil
+	}
+	actionValue := findApproveActionValue(history.Messages[0].Blocks)
+	if actionValue == "" {
+		// Not an approval message, or already approved or closed
+		return nil
+	}
+
+	if !s.userList.FindBySlackUserID(ev.User).IsDeveloper() {
+		log.Printf("[INFO] Ignoring the approval reaction by %s who is not allowed to approve", ev.User)
+		return nil
+	}
+
+	params := strings.Split(actionValue, "|")
+	if len(params) != 2 {
+		return fmt.Errorf("invalid action value: %s", actionValue)
+	}
+	if err := checkEmergencyStop(s.coordinator); err != nil {
+		_, err := s.client.PostEphemeral(ev.Item.Channel, ev.User, slack.MsgOptionText(err.Error(), false))
+		return err
+	}
+	log.Printf("[INFO] Approved by the reaction of %s: %s", ev.User, actionValue)
+	interactor := s.interactorFactory.GetByParams(params[0])
+	blocks, err := approveInPlace(interactor, params[1], ev.User, ev.Item.Channel, ev.Item.Timestamp)
+	if isApprovalHeld(err) {
+		_, err := s.client.PostEphemeral(ev.Item.Channel, ev.User, slack.MsgOptionText(err.Error(), false))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	// Replace the approval message as clicking the Deploy button does, so that the deploy isn't approved twice.
+	_, _, _, err = s.client.UpdateMessage(ev.Item.Channel, ev.Item.Timestamp, slack.MsgOptionBlocks(blocks...))
+	return err
+}
+
+// findApproveActionValue returns the value of the Deploy button in the blocks of an approval message,
+// or an empty string if there's none.
+func findApproveActionValue(blocks slack.Blocks) string {
+	isApprove := func(e *slack.ButtonBlockElement) bool {
+		return e != nil && strings.HasPrefix(e.Value, "deploy_") && strings.Contains(strings.Split(e.Value, "|")[0], "_approve")
+	}
+	for _, block := range blocks.BlockSet {
+		switch b := block.(type) {
+		case *slack.SectionBlock:
+			if b.Accessory != nil && isApprove(b.Accessory.ButtonElement) {
+				return b.Accessory.ButtonElement.Value
+			}
+		case *slack.ActionBlock:
+			if b.Elements == nil {
+				continue
+			}
+			for _, e := range b.Elements.ElementSet {
+				if btn, ok := e.(*slack.ButtonBlockElement); ok && isApprove(btn) {
+					return btn.Value
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlackListener_ReactionAddedEvent(t *testing.T) {
+	release := make(chan struct{})
+	requested := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- r.URL.Path
+		<-release
+		w.Write([]byte(`{"ok": true, "messages": []}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s := SlackListener{
+		client:            slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/")),
+		verificationToken: "token",
+		approvalReaction:  "+1",
+	}
+	body := `{"token": "token", "team_id": "T1", "type": "event_callback", "event": {"type": "reaction_added", "user": "U1", "reaction": "+1", "item": {"type": "message", "channel": "C1", "ts": "1.1"}}}`
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+
+	// The event is acknowledged while the reacted message is still being read
+	assert.Equal(t, http.StatusOK, rec.Code)
+	select {
+	case path := <-requested:
+		assert.Equal(t, "/conversations.history", path)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reaction is not processed")
+	}
+}
+
+func TestFindApproveActionValue(t *testing.T) {
+	txt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
+	approve := slack.NewButtonBlockElement("", "deploy_kustomize_approve|PR_kwDO_12", txt)
+	reject := slack.NewButtonBlockElement("", "deploy_kustomize_reject|PR_kwDO_12_bot/docker-image-tag", txt)
+
+	assert.Equal(t, "deploy_kustomize_approve|PR_kwDO_12", findApproveActionValue(slack.Blocks{BlockSet: []slack.Block{
+		slack.NewSectionBlock(txt, nil, slack.NewAccessory(approve)),
+		slack.NewActionBlock("", reject),
+	}}))
+	assert.Equal(t, "deploy_jenkins_approve|myapp_staging_main", findApproveActionValue(slack.Blocks{BlockSet: []slack.Block{
+		slack.NewActionBlock("", reject, slack.NewButtonBlockElement("", "deploy_jenkins_approve|myapp_staging_main", txt)),
+	}}))
+	assert.Equal(t, "", findApproveActionValue(slack.Blocks{BlockSet: []slack.Block{
+		slack.NewSectionBlock(txt, nil, nil),
+		slack.NewActionBlock("", reject),
+	}}))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// DeployReplayer replays the archived deploys through pull requests to the manifest repository, restoring the overlay
+// of the phase to the one at the deploy commit, like after a cluster restore or an accidental revert in the repository.
+//
+// Only the deploys whose overlays are archived, which are the ones of Kustomize, can be replayed.
+type DeployReplayer struct {
+	github   *GitHub
+	git      *GitOperator
+	archiver *ArtifactArchiver
+}
+
+func NewDeployReplayer(github *GitHub, git *GitOperator, archiver *ArtifactArchiver) DeployReplayer {
+	return DeployReplayer{github: github, git: git, archiver: archiver}
+}
+
+// DeployReplayOutput is the result of DeployReplayer.Replay.
+type DeployReplayOutput struct {
+	PullRequestID     string
+	PullRequestNumber int
+	Branch            string
+	// Tag is the tag the archived deploy shipped, if recorded.
+	Tag string
+}
+
+// replayManifests returns the archived manifests in the overlay directory, keyed by their paths in the manifest repository.
+func replayManifests(d ArchivedDeploy, dir string) map[string][]byte {
+	files := map[string][]byte{}
+	for name, content := range d.Artifacts {
+		p := strings.TrimPrefix(name, "manifests/")
+		if p != name && strings.HasPrefix(p, dir+"/") {
+			files[p] = content
+		}
+	}
+	return files
+}
+
+// Replay opens the pull request restoring the overlay of the phase to the one of the archived deploy.
+// The pull request embeds the metadata of the replay traced by traceID, which the gates of the phase check when it's approved.
+func (r DeployReplayer) Replay(pj DeployProject, phase DeployPhase, d ArchivedDeploy, requester User, traceID string) (DeployReplayOutput, error) {
+	var o DeployReplayOutput
+	dir := path.Dir(phase.Path)
+	files := replayManifests(d, dir)
+	if len(files) == 0 {
+		return o, fmt.Errorf("the deploy %s has no archived manifests in %s to replay. Only the deploys of Kustomize can be replayed", d.TraceID, dir)
+	}
+	var archived DeployMetadata
+	if b, ok := d.Artifacts["metadata.json"]; ok {
+		if err := json.Unmarshal(b, &archived); err == nil {
+			o.Tag = archived.Tag
+		}
+	}
+	currentTag, err := phase.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: r.github})
+	if err != nil {
+		return o, err
+	}
+	metadata := DeployMetadata{
+		Project:          pj.ID,
+		Phase:            phase.Name,
+		Branch:           archived.Branch,
+		PreviousTag:      currentTag,
+		Tag:              o.Tag,
+		Requester:        requester.SlackDisplayName,
+		RequesterSlackID: requester.SlackUserID,
+		TraceID:          traceID,
+		Kind:             DeployKindRedeploy,
+	}
+
+	o.Branch = fmt.Sprintf("bot/redeploy-%s-%s-%s-%s", pj.ID, phase.Name, d.TraceID, RandString(5))
+	message := fmt.Sprintf("Redeploy %s. project: %s, phase: %s, tag: %s.", d.TraceID, pj.ID, phase.Name, o.Tag)
+	diff, err := r.git.PushFiles(o.Branch, dir, files, message)
+	if err != nil {
+		return o, err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	title := fmt.Sprintf("Redeploy %s %s to %s", pj.ID, phase.Name, o.Tag)
+	body := fmt.Sprintf("Restore %s to the deploy %s of %s %s\nRequested by %s\n\nFiles:\n- %s\n\n```diff\n%s```\n\n%s",
+		dir, d.TraceID, pj.ID, phase.Name, requester.SlackDisplayName, strings.Join(names, "\n- "), diff, metadata.PullRequestFooter())
+	o.PullRequestID, o.PullRequestNumber, err = r.github.CreatePullRequest(o.Branch, title, body)
+	if err != nil {
+		return o, err
+	}
+	if err := r.github.SetNewPullRequestMetadata("", o.PullRequestID, phase.PullRequest); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// deployReplayBlocks returns the approval message of the replay.
+func deployReplayBlocks(github *GitHub, userID string, pj DeployProject, phase string, d ArchivedDeploy, o DeployReplayOutput) []slack.Block {
+	question := fmt.Sprintf("デプロイ `%s` (%s) を再適用しますか?", d.TraceID, o.Tag)
+	return pullRequestApprovalBlocks(github, userID, pj, phase, question, "Redeploy", o.PullRequestID, o.PullRequestNumber, o.Branch)
+}
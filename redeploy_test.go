@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/shurcooL/githubv4"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/slackcmd"
+)
+
+func TestDeployReplayer_Replay(t *testing.T) {
+	const overlay = "myapp/overlays/production/kustomization.yaml"
+	current := "images:\n- name: myapp\n  newTag: bbbbbbb\n"
+	remote := t.TempDir()
+	r, err := git.PlainInit(remote, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(remote, filepath.Dir(overlay)), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(remote, overlay), []byte(current), 0644))
+	_, err = w.Add(overlay)
+	require.NoError(t, err)
+	_, err = w.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+	var o GitOperator
+	o.repo = remote
+	o.defaultBranch = "refs/heads/master"
+	require.NoError(t, o.Clone())
+
+	var prBody string
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		respond := func(body string) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}, nil
+		}
+		if strings.HasSuffix(req.URL.Path, "/contents/"+overlay) {
+			return respond(current)
+		}
+		var q struct {
+			Query     string `json:"query"`
+			Variables struct {
+				Input struct {
+					Body string `json:"body"`
+				} `json:"input"`
+			} `json:"variables"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&q); err != nil {
+			return nil, err
+		}
+		if strings.Contains(q.Query, "createPullRequest") {
+			prBody = q.Variables.Input.Body
+			return respond(`{"data": {"createPullRequest": {"pullRequest": {"id": "PR_1", "number": 7}}}}`)
+		}
+		return respond(`{"data": {"repository": {"id": "R_1"}}}`)
+	})}
+	github := &GitHub{client: *githubv4.NewClient(httpClient), httpClient: httpClient, org: "zaiminc", repo: "manifests", files: newGitHubFileCache()}
+
+	archiver := &ArtifactArchiver{bucket: "artifacts", client: &fakeS3{objects: map[string]string{}}, now: time.Now}
+	_, err = archiver.Archive("myapp", "production", "abc123", map[string][]byte{
+		"metadata.json":           []byte(`{"branch": "master", "tag": "aaaaaaa"}`),
+		"manifests/" + overlay:    []byte("images:\n- name: myapp\n  newTag: aaaaaaa\n"),
+		"manifests/other/app.yml": []byte("kind: Deployment\n"),
+	})
+	require.NoError(t, err)
+	_, err = archiver.Archive("myapp", "production", "jenkins1", map[string][]byte{"metadata.json": []byte(`{"branch": "master"}`)})
+	require.NoError(t, err)
+
+	pj := DeployProject{ID: "myapp"}
+	phase := DeployPhase{Name: "production", Path: overlay, Destination: Destination{Kind: "kustomize", Kustomize: DestinationKustomize{Path: overlay, Image: "myapp"}}}
+	replayer := NewDeployReplayer(github, &o, archiver)
+
+	d, err := archiver.Find("abc123")
+	require.NoError(t, err)
+	out, err := replayer.Replay(pj, phase, d, User{SlackDisplayName: "alice", SlackUserID: "U1"}, "def456")
+	require.NoError(t, err)
+	require.Equal(t, "aaaaaaa", out.Tag)
+	require.Equal(t, "PR_1", out.PullRequestID)
+	require.Equal(t, 7, out.PullRequestNumber)
+	require.True(t, strings.HasPrefix(out.Branch, "bot/redeploy-myapp-production-abc123-"), out.Branch)
+	restored, err := o.Files(out.Branch, "myapp/overlays/production")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{overlay: []byte("images:\n- name: myapp\n  newTag: aaaaaaa\n")}, restored)
+
+	// The gates of the phase check the replay by the metadata in the pull request
+	m, err := ParseDeployMetadata(prBody)
+	require.NoError(t, err)
+	require.Equal(t, DeployMetadata{Project: "myapp", Phase: "production", Branch: "master", PreviousTag: "bbbbbbb", Tag: "aaaaaaa", Requester: "alice", RequesterSlackID: "U1", TraceID: "def456", Kind: DeployKindRedeploy}, m)
+
+	// The deploys of the kinds other than Kustomize have no overlay archived
+	d, err = archiver.Find("jenkins1")
+	require.NoError(t, err)
+	_, err = replayer.Replay(pj, phase, d, User{}, "def457")
+	require.EqualError(t, err, "the deploy jenkins1 has no archived manifests in myapp/overlays/production to replay. Only the deploys of Kustomize can be replayed")
+}
+
+func TestSlackListener_redeployWithoutArchiver(t *testing.T) {
+	s := &SlackListener{replayer: NewDeployReplayer(nil, nil, nil)}
+	_, err := s.redeploy(&slackcmd.Redeploy{ID: "abc123"}, "U1")
+	require.EqualError(t, err, "the artifacts of the deploys aren't archived. Set CONFIG_ARTIFACT_S3_BUCKET to archive them")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// RepoURL is the location of a git repository parsed from its URL,
+// which may be https://github.com/owner/repo.git, ssh://git@github.com/owner/repo.git, git@github.com:owner/repo.git,
+// or the path to the local repository like /path/to/repo or C:\path\to\repo, which developers use to run gocat locally.
+type RepoURL struct {
+	// Host is the host of the remote without the port, which is empty for the local repositories.
+	Host string
+	// Owner is the owner of the repository, which is the path to its parent directory for the local repositories.
+	// It may contain slashes, like the nested groups of GitLab.
+	Owner string
+	// Name is the name of the repository without the .git suffix.
+	Name string
+}
+
+// windowsPath matches the absolute paths on Windows, like C:\path\to\repo or C:/path/to/repo,
+// which go-git would take for the scp-like URLs of the host C.
+var windowsPath = regexp.MustCompile(`^[A-Za-z]:[\\/]`)
+
+// parseRepoURL parses the URL of the repository. It rejects the URLs without the name of the repository,
+// and the ones with .. in the path, which would escape gitRoot.
+func parseRepoURL(repo string) (RepoURL, error) {
+	repo = strings.TrimSpace(repo)
+	if repo == "" {
+		return RepoURL{}, fmt.Errorf("the repository URL is empty")
+	}
+	var host, p string
+	if windowsPath.MatchString(repo) {
+		// The drive letter is kept as a directory so that the repositories on different drives don't collide
+		p = repo[:1] + repo[2:]
+	} else {
+		e, err := transport.NewEndpoint(repo)
+		if err != nil {
+			return RepoURL{}, fmt.Errorf("invalid repository URL %q: %w", repo, err)
+		}
+		host, p = strings.ToLower(e.Host), e.Path
+	}
+	var segments []string
+	for _, s := range strings.Split(strings.ReplaceAll(p, `\`, "/"), "/") {
+		switch s {
+		case "", ".":
+			continue
+		case "..":
+			return RepoURL{}, fmt.Errorf("invalid repository URL %q: the path must not contain ..", repo)
+		}
+		segments = append(segments, s)
+	}
+	if len(segments) == 0 {
+		return RepoURL{}, fmt.Errorf("invalid repository URL %q: the repository name is missing", repo)
+	}
+	name := strings.TrimSuffix(segments[len(segments)-1], ".git")
+	if name == "" {
+		return RepoURL{}, fmt.Errorf("invalid repository URL %q: the repository name is missing", repo)
+	}
+	return RepoURL{
+		Host:  host,
+		Owner: strings.Join(segments[:len(segments)-1], "/"),
+		Name:  name,
+	}, nil
+}
+
+// Dir returns the directory under root to clone the repository into, which is root/host/owner/name
+// with the separators of the OS gocat runs on.
+func (u RepoURL) Dir(root string) string {
+	return filepath.Join(root, u.Host, filepath.FromSlash(u.Owner), u.Name)
+}
+
+// expandGitRoot expands the leading ~ of gitRoot to the home directory, and cleans the path,
+// so that the same GOCAT_GITROOT like ~/gocat works on Linux, macOS, and Windows.
+func expandGitRoot(gitRoot string) (string, error) {
+	if gitRoot == "" {
+		return "", nil
+	}
+	if gitRoot == "~" || strings.HasPrefix(gitRoot, "~/") || strings.HasPrefix(gitRoot, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to expand %s: %w", gitRoot, err)
+		}
+		gitRoot = filepath.Join(home, gitRoot[1:])
+	}
+	return filepath.Clean(filepath.FromSlash(gitRoot)), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepoURL(t *testing.T) {
+	tests := []struct {
+		repo string
+		want RepoURL
+	}{
+		{"https://github.com/zaiminc/gocat.git", RepoURL{Host: "github.com", Owner: "zaiminc", Name: "gocat"}},
+		{"https://github.com/zaiminc/gocat", RepoURL{Host: "github.com", Owner: "zaiminc", Name: "gocat"}},
+		{"ssh://git@github.com:22/zaiminc/gocat.git", RepoURL{Host: "github.com", Owner: "zaiminc", Name: "gocat"}},
+		{"git@github.com:zaiminc/gocat.git", RepoURL{Host: "github.com", Owner: "zaiminc", Name: "gocat"}},
+		{"https://gitlab.example.com/group/subgroup/manifests.git", RepoURL{Host: "gitlab.example.com", Owner: "group/subgroup", Name: "manifests"}},
+		{"/home/dev/src/manifests", RepoURL{Owner: "home/dev/src", Name: "manifests"}},
+		{"file:///home/dev/src/manifests", RepoURL{Owner: "home/dev/src", Name: "manifests"}},
+		{`C:\Users\dev\manifests`, RepoURL{Owner: "C/Users/dev", Name: "manifests"}},
+		{"C:/Users/dev/manifests.git", RepoURL{Owner: "C/Users/dev", Name: "manifests"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			got, err := parseRepoURL(tt.repo)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, repo := range []string{"", "https://github.com/", "https://github.com/zaiminc/../../etc"} {
+		_, err := parseRepoURL(repo)
+		require.Error(t, err, repo)
+	}
+}
+
+func TestGit_LocalRepoRoot(t *testing.T) {
+	root := t.TempDir()
+	for _, repo := range []string{"https://github.com/zaiminc/gocat.git", "ssh://git@github.com/zaiminc/gocat.git", "git@github.com:zaiminc/gocat.git"} {
+		g := GitOperator{repo: repo, gitRoot: root}
+		require.Equal(t, filepath.Join(root, "github.com", "zaiminc", "gocat"), g.getLocalRepoRoot(), repo)
+	}
+	require.Equal(t, "", (&GitOperator{repo: "https://github.com/zaiminc/gocat.git"}).getLocalRepoRoot())
+}
+
+func TestExpandGitRoot(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+	got, err := expandGitRoot("~/gocat")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(home, "gocat"), got)
+
+	got, err = expandGitRoot("path/to/gitroot/")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("path", "to", "gitroot"), got)
+
+	got, err = expandGitRoot("")
+	require.NoError(t, err)
+	require.Equal(t, "", got)
+}
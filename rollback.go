@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// rollbackCallbackID is the callback ID of the modal the Rollback button opens.
+const rollbackCallbackID = "gocat_rollback"
+
+// rollbackActionPrefix is the prefix of the value of the button that opens the rollback modal, followed by <project>_<phase>.
+// Slack gives no trigger ID to app mentions, so the rollback command posts the button instead of opening the modal by itself.
+const rollbackActionPrefix = "rollback_open|"
+
+// rollbackImagesBlockID is the block ID, and the action ID, of the checkboxes of the images in the rollback modal.
+const rollbackImagesBlockID = "images"
+
+// Rollbacker rolls back the images of the phases of Kustomize to their previous tags through pull requests
+// to the manifest repository, which are approved in Slack just like the deploys.
+// Each image is rolled back independently, so that only the broken one, like the worker, can be rolled back
+// leaving the others of the same deploy.
+type Rollbacker struct {
+	github *GitHub
+	git    *GitOperator
+	// verifier verifies the images rolled back to with the imageSignature of the phase, as the deploys do.
+	verifier ImageVerifier
+	// digests resolves the tags of the images rolled back to, which are pinned to the digests verified.
+	digests DigestResolver
+}
+
+func NewRollbacker(github *GitHub, git *GitOperator) Rollbacker {
+	return Rollbacker{github: github, git: git, verifier: cosignCLI{}, digests: craneCLI{}}
+}
+
+// RollbackCandidate is the image of the phase that can be rolled back.
+type RollbackCandidate struct {
+	Current  types.Image
+	Previous types.Image
+}
+
+func (c RollbackCandidate) label() string {
+	return fmt.Sprintf("%s: %s → %s", c.Current.Name, imageVersion(c.Current), imageVersion(c.Previous))
+}
+
+func imageVersion(image types.Image) string {
+	if d := strings.TrimPrefix(image.Digest, "sha256:"); len(d) >= 12 {
+		return image.NewTag + "@" + d[:12]
+	}
+	return image.NewTag
+}
+
+// Candidates returns the images of the phase with their previous tags.
+func (r Rollbacker) Candidates(phase DeployPhase) ([]RollbackCandidate, error) {
+	current, previous, err := r.git.PreviousImages(phase.Path)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []RollbackCandidate
+	for _, image := range current {
+		if prev, ok := previous[image.Name]; ok {
+			candidates = append(candidates, RollbackCandidate{Current: image, Previous: prev})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no image of %s has a previous tag to roll back to", phase.Path)
+	}
+	return candidates, nil
+}
+
+// RollbackOutput is the result of Rollbacker.Rollback.
+type RollbackOutput struct {
+	PullRequestID     string
+	PullRequestNumber int
+	Branch            string
+}
+
+// Rollback opens the pull request rolling back the images to the previous ones.
+// The images rolled back to are checked with the tagPolicy and the imageSignature of the phase as the deployed ones are,
+// and the metadata is embedded so that the rollback passes the same gates as the deploys when it's approved.
+func (r Rollbacker) Rollback(pj DeployProject, phase DeployPhase, images []RollbackCandidate, requester User, traceID string) (RollbackOutput, error) {
+	var o RollbackOutput
+	var targets []types.Image
+	var labels []string
+	for _, c := range images {
+		targets = append(targets, c.Previous)
+		labels = append(labels, c.label())
+	}
+	targets, warnings, err := checkImages(r.verifier, r.digests, phase, targets)
+	if err != nil {
+		return o, err
+	}
+	o.Branch = fmt.Sprintf("bot/rollback-%s-%s-%s", pj.ID, phase.Name, RandString(5))
+	message := fmt.Sprintf("Rollback %s. project: %s, phase: %s.", strings.Join(labels, ", "), pj.ID, phase.Name)
+	diff, err := r.git.PushDockerImageTags(o.Branch, phase, targets, message)
+	if err != nil {
+		return o, err
+	}
+
+	metadata := DeployMetadata{
+		Project:          pj.ID,
+		Phase:            phase.Name,
+		PreviousTag:      images[0].Current.NewTag,
+		Tag:              images[0].Previous.NewTag,
+		Requester:        requester.SlackDisplayName,
+		RequesterSlackID: requester.SlackUserID,
+		TraceID:          traceID,
+		Kind:             DeployKindRollback,
+	}
+	title := fmt.Sprintf("Rollback %s %s", pj.ID, phase.Name)
+	body := fmt.Sprintf("Roll back the images of %s %s\nRequested by %s\n\n- %s\n\n```diff\n%s```", pj.ID, phase.Name, requester.SlackDisplayName, strings.Join(labels, "\n- "), diff)
+	body = body + warningLines(warnings) + "\n\n" + metadata.PullRequestFooter()
+	o.PullRequestID, o.PullRequestNumber, err = r.github.CreatePullRequest(o.Branch, title, body)
+	if err != nil {
+		return o, err
+	}
+	if err := r.github.SetNewPullRequestMetadata("", o.PullRequestID, phase.PullRequest); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// rollbackBlocks returns the message with the button that opens the rollback modal of the phase.
+func rollbackBlocks(pj DeployProject, phase string) []slack.Block {
+	text := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("Roll back the images of *%s* *%s*. You can choose the images to roll back.", pj.ID, phase), false, false)
+	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s%s_%s", rollbackActionPrefix, pj.ID, phase), slack.NewTextBlockObject("plain_text", "Rollback", false, false))
+	return []slack.Block{slack.NewSectionBlock(text, nil, slack.NewAccessory(btn)), CloseButton()}
+}
+
+// rollbackApprovalBlocks returns the approval message of the rollback.
+func rollbackApprovalBlocks(github *GitHub, userID string, pj DeployProject, phase string, images []RollbackCandidate, o RollbackOutput) []slack.Block {
+	var labels []string
+	for _, c := range images {
+		labels = append(labels, c.label())
+	}
+	question := fmt.Sprintf("%s\nをロールバックしますか?", strings.Join(labels, "\n"))
+	return pullRequestApprovalBlocks(github, userID, pj, phase, question, "Rollback", o.PullRequestID, o.PullRequestNumber, o.Branch)
+}
+
+// rollbackModalMetadata is the private metadata of the rollback modal.
+type rollbackModalMetadata struct {
+	Project string `json:"project"`
+	Phase   string `json:"phase"`
+	Channel string `json:"channel"`
+}
+
+// rollbackModal returns the modal with the checkboxes of the images, all of which are checked initially.
+func rollbackModal(candidates []RollbackCandidate, metadata rollbackModalMetadata) (slack.ModalViewRequest, error) {
+	var options []*slack.OptionBlockObject
+	for _, c := range candidates {
+		options = append(options, slack.NewOptionBlockObject(c.Current.Name, slack.NewTextBlockObject("plain_text", c.label(), false, false), nil))
+	}
+	checkboxes := slack.NewCheckboxGroupsBlockElement(rollbackImagesBlockID, options...)
+	checkboxes.InitialOptions = options
+	label := fmt.Sprintf("Images of %s %s to roll back", metadata.Project, metadata.Phase)
+	block := slack.NewInputBlock(rollbackImagesBlockID, slack.NewTextBlockObject("plain_text", label, false, false), nil, checkboxes)
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return slack.ModalViewRequest{}, err
+	}
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		Title:           slack.NewTextBlockObject("plain_text", "Rollback", false, false),
+		Submit:          slack.NewTextBlockObject("plain_text", "Open PR", false, false),
+		Close:           slack.NewTextBlockObject("plain_text", "Cancel", false, false),
+		Blocks:          slack.Blocks{BlockSet: []slack.Block{block}},
+		CallbackID:      rollbackCallbackID,
+		PrivateMetadata: string(b),
+	}, nil
+}
+
+// selectedRollbackCandidates returns the candidates of the images checked in the modal.
+func selectedRollbackCandidates(candidates []RollbackCandidate, selected []slack.OptionBlockObject) []RollbackCandidate {
+	var o []RollbackCandidate
+	for _, c := range candidates {
+		for _, opt := range selected {
+			if opt.Value == c.Current.Name {
+				o = append(o, c)
+			}
+		}
+	}
+	return o
+}
+
+// openRollback opens the rollback modal of the phase of the button.
+func (h interactionHandler) openRollback(callback slack.InteractionCallback, target string) error {
+	userID := callback.User.ID
+	if !h.userList.FindBySlackUserID(userID).IsDeveloper() {
+		h.postForbiddenError(callback.ResponseURL, userID)
+		return nil
+	}
+	i := strings.LastIndex(target, "_")
+	if i < 0 {
+		return fmt.Errorf("invalid rollback target %s", target)
+	}
+	pj := h.projectList.Find(target[:i])
+	phase := pj.FindPhase(target[i+1:])
+	if phase.Name == "" {
+		return fmt.Errorf("phase %s not found for project %s", target[i+1:], target[:i])
+	}
+	candidates, err := h.rollbacker.Candidates(phase)
+	if err != nil {
+		if _, _, err := h.client.PostMessage(callback.Channel.ID, slack.MsgOptionBlocks(plainBlocks(describeError(err))...)); err != nil {
+			log.Printf("[ERROR] Failed to post the rollback error: %s", err)
+		}
+		return nil
+	}
+	modal, err := rollbackModal(candidates, rollbackModalMetadata{Project: pj.ID, Phase: phase.Name, Channel: callback.Channel.ID})
+	if err != nil {
+		return err
+	}
+	_, err = h.client.OpenView(callback.TriggerID, modal)
+	return err
+}
+
+// submitRollback opens the pull request rolling back the images checked in the rollback modal,
+// and posts its approval message to the channel of the button.
+func (h interactionHandler) submitRollback(w http.ResponseWriter, callback slack.InteractionCallback) {
+	respondErrors := func(msg string) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(slack.NewErrorsViewSubmissionResponse(map[string]string{rollbackImagesBlockID: msg})); err != nil {
+			log.Printf("[ERROR] Failed to respond to the rollback modal: %s", err)
+		}
+	}
+	var metadata rollbackModalMetadata
+	if err := json.Unmarshal([]byte(callback.View.PrivateMetadata), &metadata); err != nil {
+		respondErrors(fmt.Sprintf("Invalid modal: %s", err))
+		return
+	}
+	userID := callback.User.ID
+	user := h.userList.FindBySlackUserID(userID)
+	if !user.IsDeveloper() {
+		respondErrors(fmt.Sprintf("<@%s> is not allowed to roll back. Please contact admin.", userID))
+		return
+	}
+	if err := checkDeployable(h.coordinator, metadata.Project, metadata.Phase); err != nil {
+		respondErrors(err.Error())
+		return
+	}
+	pj := h.projectList.Find(metadata.Project)
+	phase := pj.FindPhase(metadata.Phase)
+	candidates, err := h.rollbacker.Candidates(phase)
+	if err != nil {
+		respondErrors(err.Error())
+		return
+	}
+	images := selectedRollbackCandidates(candidates, callback.View.State.Values[rollbackImagesBlockID][rollbackImagesBlockID].SelectedOptions)
+	if len(images) == 0 {
+		respondErrors("Choose the images to roll back")
+		return
+	}
+
+	// The modal is closed by the empty response, and the pull request is opened in the background
+	go func() {
+		defer h.recoverer.Recover(fmt.Sprintf("the rollback of %s %s", pj.ID, phase.Name), metadata.Channel)
+		var blocks []slack.Block
+		trace := h.tracer.Start(pj.ID, phase.Name, "rollback requested by <@%s>", userID)
+		h.tracer.SetRequester(trace, userID)
+		o, err := h.rollbacker.Rollback(pj, phase, images, user, trace)
+		if err != nil {
+			log.Printf("[ERROR] Failed to roll back %s %s: %s", pj.ID, phase.Name, err)
+			h.tracer.Emit(trace, DeployEventFailed, "%s", err)
+			blocks = plainBlocks(describeError(err))
+		} else {
+			log.Printf("[INFO] Rollback of %s %s is requested by %s", pj.ID, phase.Name, userID)
+			h.tracer.Emit(trace, DeployEventAwaitingApproval, "#%d", o.PullRequestNumber)
+			blocks = rollbackApprovalBlocks(h.github, userID, pj, phase.Name, images, o)
+		}
+		if _, _, err := h.client.PostMessage(metadata.Channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+			log.Printf("[ERROR] Failed to post the result of the rollback: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+func TestRollbackModal(t *testing.T) {
+	candidates := []RollbackCandidate{
+		{Current: types.Image{Name: "api", NewTag: "a3"}, Previous: types.Image{Name: "api", NewTag: "a2"}},
+		{Current: types.Image{Name: "worker", NewTag: "w2"}, Previous: types.Image{Name: "worker", NewTag: "w1", Digest: "sha256:0123456789abcdef"}},
+	}
+	modal, err := rollbackModal(candidates, rollbackModalMetadata{Project: "myapp", Phase: "production", Channel: "C1"})
+	require.NoError(t, err)
+	require.Equal(t, rollbackCallbackID, modal.CallbackID)
+
+	var metadata rollbackModalMetadata
+	require.NoError(t, json.Unmarshal([]byte(modal.PrivateMetadata), &metadata))
+	require.Equal(t, rollbackModalMetadata{Project: "myapp", Phase: "production", Channel: "C1"}, metadata)
+
+	input := modal.Blocks.BlockSet[0].(*slack.InputBlock)
+	checkboxes := input.Element.(*slack.CheckboxGroupsBlockElement)
+	require.Len(t, checkboxes.Options, 2)
+	require.Len(t, checkboxes.InitialOptions, 2)
+	require.Equal(t, "worker: w2 → w1@0123456789ab", checkboxes.Options[1].Text.Text)
+
+	// Only the worker is checked
+	selected := selectedRollbackCandidates(candidates, []slack.OptionBlockObject{{Value: "worker"}})
+	require.Equal(t, candidates[1:], selected)
+}
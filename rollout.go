@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var rolloutGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+
+// rolloutActionPrefix is the prefix of the values of the buttons controlling a rollout, like rollout_promote|myapp_production|<trace ID>.
+const rolloutActionPrefix = "rollout_"
+
+// RolloutOption is the Argo Rollouts Rollout the deploys of a phase update.
+//
+// The deploy pull request sets the new image on the Rollout through the kustomize images of the overlay,
+// and once it's merged, gocat follows the steps of the Rollout in Slack with the buttons to pause, promote, and abort it.
+// The deploys of AutoDeploy are followed in notifyChannel of the phase.
+//
+// Anyone who can deploy can pause and abort the Rollout, while promoting it is a deploy, which is blocked by emergency-stop and the pins,
+// and by twoPersonRule for the requester of the deploy.
+type RolloutOption struct {
+	Name string `yaml:"name"`
+	// Namespace is the namespace of the Rollout. It's default if empty.
+	Namespace string `yaml:"namespace"`
+}
+
+func (o RolloutOption) Enabled() bool {
+	return o.Name != ""
+}
+
+func (o RolloutOption) namespace() string {
+	if o.Namespace == "" {
+		return "default"
+	}
+	return o.Namespace
+}
+
+// RolloutStatus is the progress of a Rollout.
+type RolloutStatus struct {
+	// Phase is one of Healthy, Progressing, Paused, and Degraded.
+	Phase   string
+	Message string
+	// Step is the index of the current canary step, which equals Steps once all the steps are done.
+	Step  int
+	Steps int
+	// Paused is true if the Rollout is paused by the user or by a pause step.
+	Paused  bool
+	Aborted bool
+	// Images are the images of the containers of the Rollout.
+	Images []string
+}
+
+// Done returns true if the Rollout has nothing more to do, either completed, aborted, or failed.
+func (s RolloutStatus) Done() bool {
+	return s.Aborted || s.Phase == "Degraded" || (s.Phase == "Healthy" && s.Step >= s.Steps)
+}
+
+// HasTag returns true if any of the containers runs the image tag.
+func (s RolloutStatus) HasTag(tag string) bool {
+	for _, image := range s.Images {
+		if strings.HasSuffix(image, ":"+tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRolloutStatus(u *unstructured.Unstructured) RolloutStatus {
+	var s RolloutStatus
+	s.Phase, _, _ = unstructured.NestedString(u.Object, "status", "phase")
+	s.Message, _, _ = unstructured.NestedString(u.Object, "status", "message")
+	step, found, _ := unstructured.NestedInt64(u.Object, "status", "currentStepIndex")
+	steps, _, _ := unstructured.NestedSlice(u.Object, "spec", "strategy", "canary", "steps")
+	s.Steps = len(steps)
+	s.Step = int(step)
+	if !found {
+		s.Step = s.Steps
+	}
+	paused, _, _ := unstructured.NestedBool(u.Object, "spec", "paused")
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "pauseConditions")
+	s.Paused = paused || len(conditions) > 0
+	s.Aborted, _, _ = unstructured.NestedBool(u.Object, "status", "abort")
+	containers, _, _ := unstructured.NestedSlice(u.Object, "spec", "template", "spec", "containers")
+	for _, c := range containers {
+		if m, ok := c.(map[string]interface{}); ok {
+			if image, ok := m["image"].(string); ok {
+				s.Images = append(s.Images, image)
+			}
+		}
+	}
+	return s
+}
+
+// RolloutController reads and steps the Argo Rollouts of the phases.
+type RolloutController struct {
+	// mu guards client, which is created on the first use.
+	mu     sync.Mutex
+	client dynamic.Interface
+	// interval is the interval Follow checks the Rollout at
+	interval time.Duration
+}
+
+func NewRolloutController() *RolloutController {
+	return &RolloutController{interval: 15 * time.Second}
+}
+
+func (c *RolloutController) dynamicClient() (dynamic.Interface, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	client, err := newDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+	return client, nil
+}
+
+func (c *RolloutController) Status(ctx context.Context, o RolloutOption) (RolloutStatus, error) {
+	client, err := c.dynamicClient()
+	if err != nil {
+		return RolloutStatus{}, err
+	}
+	u, err := client.Resource(rolloutGVR).Namespace(o.namespace()).Get(ctx, o.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return RolloutStatus{}, fmt.Errorf("unable to get the rollout %s/%s: %w", o.namespace(), o.Name, err)
+	}
+	return parseRolloutStatus(u), nil
+}
+
+// Pause pauses the Rollout at the current step.
+func (c *RolloutController) Pause(ctx context.Context, o RolloutOption) error {
+	return c.patch(ctx, o, `{"spec":{"paused":true}}`, "")
+}
+
+// Promote resumes the Rollout paused by the user or by a pause step, which proceeds to the next step.
+func (c *RolloutController) Promote(ctx context.Context, o RolloutOption) error {
+	return c.patch(ctx, o, `{"spec":{"paused":false}}`, `{"status":{"pauseConditions":null}}`)
+}
+
+// Abort aborts the Rollout, which scales the canary down and sends all the traffic back to the stable version.
+func (c *RolloutController) Abort(ctx context.Context, o RolloutOption) error {
+	return c.patch(ctx, o, "", `{"status":{"abort":true}}`)
+}
+
+// patch applies the merge patches to the spec and the status of the Rollout, as kubectl argo rollouts does.
+func (c *RolloutController) patch(ctx context.Context, o RolloutOption, spec string, status string) error {
+	client, err := c.dynamicClient()
+	if err != nil {
+		return err
+	}
+	rollouts := client.Resource(rolloutGVR).Namespace(o.namespace())
+	if spec != "" {
+		if _, err := rollouts.Patch(ctx, o.Name, types.MergePatchType, []byte(spec), meta_v1.PatchOptions{}); err != nil {
+			return fmt.Errorf("unable to patch the rollout %s/%s: %w", o.namespace(), o.Name, err)
+		}
+	}
+	if status != "" {
+		if _, err := rollouts.Patch(ctx, o.Name, types.MergePatchType, []byte(status), meta_v1.PatchOptions{}, "status"); err != nil {
+			return fmt.Errorf("unable to patch the status of the rollout %s/%s: %w", o.namespace(), o.Name, err)
+		}
+	}
+	return nil
+}
+
+// Follow posts the progress of the Rollout of the phase deploying the tag by the deploy of the trace to the channel,
+// and keeps the message updated until the Rollout is done, or gives up after the timeout, which is returned as the error.
+func (c *RolloutController) Follow(client *slack.Client, channel string, pj DeployProject, phase DeployPhase, tag, traceID string, timeout time.Duration) error {
+	ctx := context.Background()
+	deadline := time.Now().Add(timeout)
+	var ts, last string
+	for {
+		s, err := c.Status(ctx, phase.Rollout)
+		if err != nil {
+			log.Printf("[ERROR] Quit following the rollout of %s %s: %s", pj.ID, phase.Name, err)
+			return err
+		}
+		synced := s.HasTag(tag)
+		blocks := rolloutBlocks(pj.ID, phase.Name, tag, traceID, s, synced)
+		text := rolloutText(pj.ID, phase.Name, tag, s, synced)
+		if text != last {
+			if ts == "" {
+				_, ts, err = client.PostMessage(channel, slack.MsgOptionBlocks(blocks...))
+			} else {
+				_, _, _, err = client.UpdateMessage(channel, ts, slack.MsgOptionBlocks(blocks...))
+			}
+			if err != nil {
+				log.Printf("[ERROR] Failed to post the rollout of %s %s: %s", pj.ID, phase.Name, err)
+			}
+			last = text
+		}
+		if synced && s.Done() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			log.Printf("[WARNING] Gave up following the rollout of %s %s after %s", pj.ID, phase.Name, timeout)
+			return fmt.Errorf("the rollout of %s %s didn't finish in %s", pj.ID, phase.Name, timeout)
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+// rolloutText describes the progress of the Rollout deploying the tag.
+func rolloutText(project, phase, tag string, s RolloutStatus, synced bool) string {
+	if !synced {
+		return fmt.Sprintf("*Rollout* %s %s: waiting for `%s` to be synced", project, phase, tag)
+	}
+	state := s.Phase
+	switch {
+	case s.Aborted:
+		state = "Aborted"
+	case s.Paused && s.Phase != "Paused":
+		state += " (paused)"
+	}
+	text := fmt.Sprintf("*Rollout* %s %s with `%s`: %s", project, phase, tag, state)
+	if s.Steps > 0 {
+		text += fmt.Sprintf(", step %d/%d", s.Step, s.Steps)
+	}
+	if s.Message != "" {
+		text += "\n" + s.Message
+	}
+	return text
+}
+
+// rolloutBlocks returns the progress of the Rollout with the buttons to control it until it's done.
+// The buttons carry the trace ID of the deploy, which tells rolloutAction its requester.
+func rolloutBlocks(project, phase, tag, traceID string, s RolloutStatus, synced bool) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", rolloutText(project, phase, tag, s, synced), false, false), nil, nil),
+	}
+	if !synced || s.Done() {
+		return blocks
+	}
+
+	value := func(op string) string {
+		return fmt.Sprintf("%s%s|%s_%s|%s", rolloutActionPrefix, op, project, phase, traceID)
+	}
+	pause := slack.NewButtonBlockElement("", value("pause"), slack.NewTextBlockObject("plain_text", "Pause", false, false))
+	promote := slack.NewButtonBlockElement("", value("promote"), slack.NewTextBlockObject("plain_text", "Promote", false, false))
+	promote.Style = slack.StylePrimary
+	abort := slack.NewButtonBlockElement("", value("abort"), slack.NewTextBlockObject("plain_text", "Abort", false, false))
+	abort.Style = slack.StyleDanger
+	abort.Confirm = slack.NewConfirmationBlockObject(
+		slack.NewTextBlockObject("plain_text", "Abort the rollout?", false, false),
+		slack.NewTextBlockObject("plain_text", fmt.Sprintf("All the traffic of %s %s goes back to the stable version.", project, phase), false, false),
+		slack.NewTextBlockObject("plain_text", "Abort", false, false),
+		slack.NewTextBlockObject("plain_text", "Cancel", false, false),
+	)
+	return append(blocks, slack.NewActionBlock("", pause, promote, abort))
+}
+
+// rolloutAction pauses, promotes, or aborts the Rollout of the phase by the button in the message Follow posts,
+// and records who did it in the thread of the message.
+func (h interactionHandler) rolloutAction(callback slack.InteractionCallback, actionValue string) {
+	userID := callback.User.ID
+	if !h.userList.FindBySlackUserID(userID).IsDeveloper() {
+		h.postForbiddenError(callback.ResponseURL, userID)
+		return
+	}
+	params := strings.Split(strings.TrimPrefix(actionValue, rolloutActionPrefix), "|")
+	if len(params) != 2 && len(params) != 3 {
+		h.postInternalServerError(callback.ResponseURL, userID)
+		return
+	}
+	p := strings.Split(params[1], "_")
+	if len(p) != 2 {
+		h.postInternalServerError(callback.ResponseURL, userID)
+		return
+	}
+	phase := h.projectList.Find(p[0]).FindPhase(p[1])
+	if phase.Name == "" {
+		h.postEphemeral(callback.ResponseURL, fmt.Sprintf("phase %s not found for project %s", p[1], p[0]))
+		return
+	}
+	if !phase.Rollout.Enabled() {
+		h.postEphemeral(callback.ResponseURL, fmt.Sprintf("%s %s has no rollout", p[0], p[1]))
+		return
+	}
+	if params[0] == "promote" {
+		var traceID string
+		if len(params) == 3 {
+			traceID = params[2]
+		}
+		if err := h.checkPromote(p[0], p[1], traceID, userID); err != nil {
+			h.postEphemeral(callback.ResponseURL, err.Error())
+			return
+		}
+	}
+
+	ctx := context.Background()
+	var err error
+	done := map[string]string{"pause": "paused", "promote": "promoted", "abort": "aborted"}[params[0]]
+	switch params[0] {
+	case "pause":
+		err = h.rollouts.Pause(ctx, phase.Rollout)
+	case "promote":
+		err = h.rollouts.Promote(ctx, phase.Rollout)
+	case "abort":
+		err = h.rollouts.Abort(ctx, phase.Rollout)
+	default:
+		err = fmt.Errorf("unknown rollout action %s", params[0])
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to %s the rollout of %s %s: %s", params[0], p[0], p[1], err)
+		h.postEphemeral(callback.ResponseURL, err.Error())
+		return
+	}
+	log.Printf("[INFO] The rollout of %s %s is %s by %s", p[0], p[1], done, userID)
+	text := fmt.Sprintf("<@%s> %s the rollout", userID, done)
+	if _, _, err := h.client.PostMessage(callback.Channel.ID, slack.MsgOptionText(text, false), slack.MsgOptionTS(callback.Container.MessageTs)); err != nil {
+		log.Printf("[ERROR] Failed to post the rollout action: %s", err)
+	}
+}
+
+// checkPromote returns an error if the user can't promote the Rollout of the phase deploying the deploy of the trace,
+// which is a deploy of the phase held by emergency-stop and the pins, and by twoPersonRule for its requester.
+// Pausing and aborting the Rollout aren't checked, as they only stop the deploy.
+func (h interactionHandler) checkPromote(project, phase, traceID, userID string) error {
+	if err := checkDeployable(h.coordinator, project, phase); err != nil {
+		return err
+	}
+	var requester string
+	if trace, ok := h.tracer.Get(traceID); ok {
+		requester = trace.Requester
+	}
+	return checkTwoPersonRule(h.projectList, DeployMetadata{Project: project, Phase: phase, RequesterSlackID: requester}, userID)
+}
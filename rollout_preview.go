@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/slack-go/slack"
+	appsv1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DeploymentRolloutPreview is how a Deployment the deploy updates is rolled out, along with the PodDisruptionBudgets covering its pods.
+type DeploymentRolloutPreview struct {
+	Namespace string
+	Name      string
+	Replicas  int32
+	// Strategy is either RollingUpdate or Recreate.
+	Strategy string
+	// MaxSurge and MaxUnavailable are the ones of the rolling update, like 25% or 1.
+	MaxSurge       string
+	MaxUnavailable string
+	// PDBs describe the PodDisruptionBudgets selecting the pods of the Deployment.
+	PDBs []string
+	// Warnings are the reasons the rollout could cause downtime.
+	Warnings []string
+}
+
+// RolloutPreviewOption scopes the preview of the rollouts of a phase to the Deployments of its namespace,
+// so that the Deployments of the other phases and projects running the same images aren't mistaken for the ones of the phase:
+//
+//	rolloutPreview:
+//	  namespace: myapp-production
+//	  selector: app.kubernetes.io/part-of=myapp
+//
+// The namespace of rollout is used if empty, and the phases with neither aren't previewed.
+type RolloutPreviewOption struct {
+	Namespace string `yaml:"namespace"`
+	// Selector is the label selector narrowing down the Deployments in the namespace, like app=myapp.
+	Selector string `yaml:"selector"`
+}
+
+// previewScope returns the namespace and the label selector of the Deployments of the phase, or an empty namespace if the phase has neither.
+func previewScope(phase DeployPhase) (string, string) {
+	if phase.RolloutPreview.Namespace != "" {
+		return phase.RolloutPreview.Namespace, phase.RolloutPreview.Selector
+	}
+	if phase.Rollout.Namespace != "" {
+		return phase.Rollout.Namespace, phase.RolloutPreview.Selector
+	}
+	return "", ""
+}
+
+// RolloutPreviewer previews the rollouts of the Deployments running the images of a deploy before it's merged,
+// warning when the deploy could cause downtime, like a single replica with maxUnavailable 1.
+// It only reads the Deployments and the PodDisruptionBudgets of the namespaces of the phases in the cluster gocat runs in.
+//
+// The methods are safe to call on nil, which previews nothing.
+type RolloutPreviewer struct {
+	clientset kubernetes.Interface
+}
+
+func NewRolloutPreviewer() *RolloutPreviewer {
+	return &RolloutPreviewer{}
+}
+
+func (p *RolloutPreviewer) client() (kubernetes.Interface, error) {
+	if p.clientset != nil {
+		return p.clientset, nil
+	}
+	client, err := newKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	p.clientset = client
+	return client, nil
+}
+
+// imageRepository returns the image without its tag and digest, like 123.dkr.ecr.ap-northeast-1.amazonaws.com/myapp.
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// Preview returns the previews of the Deployments in the namespace matching the label selector with a container running any of the images,
+// which are the names of the images in kustomization.yaml.
+func (p *RolloutPreviewer) Preview(ctx context.Context, namespace, selector string, images []string) ([]DeploymentRolloutPreview, error) {
+	if p == nil || namespace == "" || len(images) == 0 {
+		return nil, nil
+	}
+	if _, err := labels.Parse(selector); err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+	}
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	deployments, err := client.AppsV1().Deployments(namespace).List(ctx, meta_v1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the deployments of %s: %w", namespace, err)
+	}
+	pdbs, err := client.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the pod disruption budgets of %s: %w", namespace, err)
+	}
+	var previews []DeploymentRolloutPreview
+	for _, d := range deployments.Items {
+		if !runsAnyImage(d, images) {
+			continue
+		}
+		preview := previewDeploymentRollout(d)
+		for _, pdb := range pdbs.Items {
+			selector, err := meta_v1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil || selector.Empty() || !selector.Matches(labels.Set(d.Spec.Template.Labels)) {
+				continue
+			}
+			var budget string
+			switch {
+			case pdb.Spec.MinAvailable != nil:
+				budget = "minAvailable " + pdb.Spec.MinAvailable.String()
+			case pdb.Spec.MaxUnavailable != nil:
+				budget = "maxUnavailable " + pdb.Spec.MaxUnavailable.String()
+			}
+			preview.PDBs = append(preview.PDBs, fmt.Sprintf("%s (%s, %d disruptions allowed)", pdb.Name, budget, pdb.Status.DisruptionsAllowed))
+			if pdb.Status.DisruptionsAllowed == 0 {
+				preview.Warnings = append(preview.Warnings, fmt.Sprintf("the PodDisruptionBudget %s allows no disruptions, which blocks the node drains during the rollout", pdb.Name))
+			}
+		}
+		previews = append(previews, preview)
+	}
+	return previews, nil
+}
+
+func runsAnyImage(d appsv1.Deployment, images []string) bool {
+	for _, c := range d.Spec.Template.Spec.Containers {
+		for _, image := range images {
+			if imageRepository(c.Image) == imageRepository(image) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// previewDeploymentRollout returns the preview of the Deployment with the defaults of Kubernetes applied,
+// and the warnings of the rollouts that can make all the replicas unavailable at once.
+func previewDeploymentRollout(d appsv1.Deployment) DeploymentRolloutPreview {
+	p := DeploymentRolloutPreview{Namespace: d.Namespace, Name: d.Name, Replicas: 1, Strategy: string(d.Spec.Strategy.Type)}
+	if d.Spec.Replicas != nil {
+		p.Replicas = *d.Spec.Replicas
+	}
+	if p.Strategy == "" {
+		p.Strategy = string(appsv1.RollingUpdateDeploymentStrategyType)
+	}
+	if p.Strategy == string(appsv1.RecreateDeploymentStrategyType) {
+		p.Warnings = append(p.Warnings, "the Recreate strategy terminates all the pods before starting the new ones")
+		return p
+	}
+
+	defaultPercent := intstr.FromString("25%")
+	maxSurge, maxUnavailable := &defaultPercent, &defaultPercent
+	if ru := d.Spec.Strategy.RollingUpdate; ru != nil {
+		if ru.MaxSurge != nil {
+			maxSurge = ru.MaxSurge
+		}
+		if ru.MaxUnavailable != nil {
+			maxUnavailable = ru.MaxUnavailable
+		}
+	}
+	p.MaxSurge, p.MaxUnavailable = maxSurge.String(), maxUnavailable.String()
+	// Kubernetes rounds maxUnavailable down
+	unavailable, err := intstr.GetScaledValueFromIntOrPercent(maxUnavailable, int(p.Replicas), false)
+	if err != nil {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("invalid maxUnavailable %s", maxUnavailable.String()))
+		return p
+	}
+	if p.Replicas > 0 && unavailable >= int(p.Replicas) {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("maxUnavailable %s lets all the %d replicas be unavailable at once", maxUnavailable.String(), p.Replicas))
+	}
+	return p
+}
+
+func (p DeploymentRolloutPreview) String() string {
+	s := fmt.Sprintf("`%s/%s`: %d replicas, %s", p.Namespace, p.Name, p.Replicas, p.Strategy)
+	if p.Strategy == string(appsv1.RollingUpdateDeploymentStrategyType) {
+		s += fmt.Sprintf(" (maxSurge %s, maxUnavailable %s)", p.MaxSurge, p.MaxUnavailable)
+	}
+	if len(p.PDBs) > 0 {
+		s += ", PDB " + strings.Join(p.PDBs, ", ")
+	}
+	for _, w := range p.Warnings {
+		s += "\n:warning: " + w
+	}
+	return s
+}
+
+// rolloutPreviewBlocks returns the blocks previewing the rollouts of the images of the phase for the approval message,
+// or nothing if the phase has no namespace to preview, no Deployment runs them, or the preview fails, as it's only informative.
+func rolloutPreviewBlocks(p *RolloutPreviewer, pj DeployProject, phase DeployPhase) []slack.Block {
+	namespace, selector := previewScope(phase)
+	if p == nil || namespace == "" {
+		return nil
+	}
+	images := []string{phase.Destination.Kustomize.Image}
+	for _, image := range phase.Images {
+		images = append(images, image.Name)
+	}
+	previews, err := p.Preview(context.Background(), namespace, selector, images)
+	if err != nil {
+		log.Printf("[ERROR] Failed to preview the rollout of %s %s: %s", pj.ID, phase.Name, err)
+		return nil
+	}
+	if len(previews) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, preview := range previews {
+		lines = append(lines, preview.String())
+	}
+	text := slack.NewTextBlockObject("mrkdwn", "*Rollout*\n"+strings.Join(lines, "\n"), false, false)
+	return []slack.Block{slack.NewContextBlock("", text)}
+}
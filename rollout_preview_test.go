@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testDeployment(name string, replicas int32, image string, strategy appsv1.DeploymentStrategy) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "myapp", Labels: map[string]string{"app": name}},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Strategy: strategy,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: meta_v1.ObjectMeta{Labels: map[string]string{"app": name}},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: name, Image: image}}},
+			},
+		},
+	}
+}
+
+func TestRolloutPreviewer_Preview(t *testing.T) {
+	one := intstr.FromInt(1)
+	minAvailable := intstr.FromInt(3)
+	p := &RolloutPreviewer{clientset: fake.NewSimpleClientset(
+		testDeployment("api", 3, "registry/myapp:abc1234", appsv1.DeploymentStrategy{}),
+		testDeployment("worker", 1, "registry/myapp@sha256:0123", appsv1.DeploymentStrategy{
+			Type:          appsv1.RollingUpdateDeploymentStrategyType,
+			RollingUpdate: &appsv1.RollingUpdateDeployment{MaxUnavailable: &one},
+		}),
+		testDeployment("cron", 1, "registry/myapp:abc1234", appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}),
+		testDeployment("other", 1, "registry/other:abc1234", appsv1.DeploymentStrategy{}),
+		&appsv1.Deployment{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "api", Namespace: "myapp-staging"},
+			Spec: appsv1.DeploymentSpec{Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{Containers: []v1.Container{{Name: "api", Image: "registry/myapp:abc1234"}}},
+			}},
+		},
+		&policyv1.PodDisruptionBudget{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "api", Namespace: "myapp"},
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				MinAvailable: &minAvailable,
+				Selector:     &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+			},
+		},
+	)}
+
+	previews, err := p.Preview(context.Background(), "myapp", "", []string{"registry/myapp"})
+	require.NoError(t, err)
+	require.Equal(t, []DeploymentRolloutPreview{
+		{
+			Namespace: "myapp", Name: "api", Replicas: 3, Strategy: "RollingUpdate", MaxSurge: "25%", MaxUnavailable: "25%",
+			PDBs:     []string{"api (minAvailable 3, 0 disruptions allowed)"},
+			Warnings: []string{"the PodDisruptionBudget api allows no disruptions, which blocks the node drains during the rollout"},
+		},
+		{Namespace: "myapp", Name: "cron", Replicas: 1, Strategy: "Recreate", Warnings: []string{"the Recreate strategy terminates all the pods before starting the new ones"}},
+		{
+			Namespace: "myapp", Name: "worker", Replicas: 1, Strategy: "RollingUpdate", MaxSurge: "25%", MaxUnavailable: "1",
+			Warnings: []string{"maxUnavailable 1 lets all the 1 replicas be unavailable at once"},
+		},
+	}, previews)
+
+	// The label selector narrows down the Deployments in the namespace
+	previews, err = p.Preview(context.Background(), "myapp", "app in (api, other)", []string{"registry/myapp"})
+	require.NoError(t, err)
+	require.Len(t, previews, 1)
+	require.Equal(t, "api", previews[0].Name)
+
+	// The phases with no namespace aren't previewed
+	previews, err = p.Preview(context.Background(), "", "", []string{"registry/myapp"})
+	require.NoError(t, err)
+	require.Empty(t, previews)
+
+	_, err = p.Preview(context.Background(), "myapp", "app in", []string{"registry/myapp"})
+	require.Error(t, err)
+
+	var nilPreviewer *RolloutPreviewer
+	previews, err = nilPreviewer.Preview(context.Background(), "myapp", "", []string{"registry/myapp"})
+	require.NoError(t, err)
+	require.Empty(t, previews)
+}
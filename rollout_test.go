@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestRolloutController(t *testing.T) {
+	rollout := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"metadata":   map[string]interface{}{"name": "myapp", "namespace": "myapp"},
+		"spec": map[string]interface{}{
+			"strategy": map[string]interface{}{"canary": map[string]interface{}{"steps": []interface{}{
+				map[string]interface{}{"setWeight": int64(20)},
+				map[string]interface{}{"pause": map[string]interface{}{}},
+				map[string]interface{}{"setWeight": int64(100)},
+			}}},
+			"template": map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp:v1.2.3"},
+			}}},
+		},
+		"status": map[string]interface{}{
+			"phase":            "Paused",
+			"message":          "CanaryPauseStep",
+			"currentStepIndex": int64(1),
+			"pauseConditions":  []interface{}{map[string]interface{}{"reason": "CanaryPauseStep"}},
+		},
+	}}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{rolloutGVR: "RolloutList"}, rollout)
+	c := &RolloutController{client: client}
+	o := RolloutOption{Name: "myapp", Namespace: "myapp"}
+	ctx := context.Background()
+
+	s, err := c.Status(ctx, o)
+	require.NoError(t, err)
+	require.Equal(t, RolloutStatus{
+		Phase:   "Paused",
+		Message: "CanaryPauseStep",
+		Step:    1,
+		Steps:   3,
+		Paused:  true,
+		Images:  []string{"123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp:v1.2.3"},
+	}, s)
+	require.True(t, s.HasTag("v1.2.3"))
+	require.False(t, s.HasTag("v1.2"))
+	require.False(t, s.Done())
+	require.Equal(t, "*Rollout* myapp production with `v1.2.3`: Paused, step 1/3\nCanaryPauseStep", rolloutText("myapp", "production", "v1.2.3", s, true))
+	require.Len(t, rolloutBlocks("myapp", "production", "v1.2.3", "1a2b3c4d", s, true), 2)
+
+	require.NoError(t, c.Promote(ctx, o))
+	s, err = c.Status(ctx, o)
+	require.NoError(t, err)
+	require.False(t, s.Paused)
+
+	require.NoError(t, c.Abort(ctx, o))
+	s, err = c.Status(ctx, o)
+	require.NoError(t, err)
+	require.True(t, s.Aborted)
+	require.True(t, s.Done())
+	require.Len(t, rolloutBlocks("myapp", "production", "v1.2.3", "1a2b3c4d", s, true), 1)
+}
+
+func TestInteractionHandler_CheckPromote(t *testing.T) {
+	pj := DeployProject{ID: "myapp", Phases: []DeployPhase{{Name: "production", TwoPersonRule: true}, {Name: "staging"}}}
+	tracer := NewDeployTracer()
+	h := interactionHandler{projectList: &ProjectList{items: []DeployProject{pj}}, tracer: tracer}
+	id := tracer.Start("myapp", "production", "requested")
+	tracer.SetRequester(id, "U1")
+
+	// The requester can't promote the rollout of their own deploy to the phase with the two-person rule
+	require.ErrorIs(t, h.checkPromote("myapp", "production", id, "U1"), ErrTwoPersonRule)
+	require.NoError(t, h.checkPromote("myapp", "production", id, "U2"))
+	require.NoError(t, h.checkPromote("myapp", "staging", id, "U1"))
+	// The deploys of AutoDeploy have no requester
+	require.NoError(t, h.checkPromote("myapp", "production", "", "U1"))
+}
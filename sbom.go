@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// SBOMFetcher fetches the SBOMs of the images.
+type SBOMFetcher interface {
+	// FetchSBOM returns the SBOM document of the image, either SPDX or CycloneDX in JSON,
+	// from the attestation verified with the key or the identity of the policy.
+	FetchSBOM(image string, policy ImageSignaturePolicy) ([]byte, error)
+}
+
+// sbomPredicateTypes are the types of the in-toto attestations the SBOMs are looked up in, in order.
+var sbomPredicateTypes = []string{"spdxjson", "cyclonedx"}
+
+// FetchSBOM returns the SBOM of the image from its attestations verified with the key or the identity of the policy.
+// The SBOMs merely attached to the images, or attested by anyone else, are never trusted, as anyone able to push to the registry can attach them.
+func (c cosignCLI) FetchSBOM(image string, policy ImageSignaturePolicy) ([]byte, error) {
+	var lastErr error
+	for _, t := range sbomPredicateTypes {
+		out, err := c.run(policy.attestationArgs(t, image)...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(bytes.TrimSpace(out)) == 0 {
+			continue
+		}
+		return sbomFromAttestation(out)
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("no SBOM is attested: %w", lastErr)
+	}
+	return nil, fmt.Errorf("no SBOM is attested")
+}
+
+func (c cosignCLI) run(args ...string) ([]byte, error) {
+	bin := c.Command
+	if bin == "" {
+		bin = "cosign"
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Env = commandEnv()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("command cosign %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// sbomFromAttestation returns the predicate of the first of the DSSE envelopes cosign downloads, one in each line,
+// which is the SBOM document.
+func sbomFromAttestation(out []byte) ([]byte, error) {
+	line, _, _ := bufio.NewReader(bytes.NewReader(out)).ReadLine()
+	var envelope struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid attestation: %w", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload of the attestation: %w", err)
+	}
+	var statement struct {
+		Predicate json.RawMessage `json:"predicate"`
+	}
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return nil, fmt.Errorf("invalid in-toto statement: %w", err)
+	}
+	return statement.Predicate, nil
+}
+
+// SBOMSummary is the summary of an SBOM shown in the deploy pull request.
+type SBOMSummary struct {
+	// Format is the format of the SBOM and its version, like SPDX-2.3 or CycloneDX 1.5.
+	Format   string
+	Packages int
+	// Licenses is the number of the packages of each license.
+	Licenses map[string]int
+}
+
+// parseSBOM summarizes the SBOM document in SPDX or CycloneDX.
+func parseSBOM(doc []byte) (SBOMSummary, error) {
+	var sbom struct {
+		// SPDX
+		SPDXVersion string `json:"spdxVersion"`
+		Packages    []struct {
+			LicenseConcluded string `json:"licenseConcluded"`
+			LicenseDeclared  string `json:"licenseDeclared"`
+		} `json:"packages"`
+		// CycloneDX
+		BOMFormat   string `json:"bomFormat"`
+		SpecVersion string `json:"specVersion"`
+		Components  []struct {
+			Licenses []struct {
+				License struct {
+					ID   string `json:"id"`
+					Name string `json:"name"`
+				} `json:"license"`
+				Expression string `json:"expression"`
+			} `json:"licenses"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(doc, &sbom); err != nil {
+		return SBOMSummary{}, fmt.Errorf("invalid SBOM: %w", err)
+	}
+	s := SBOMSummary{Licenses: map[string]int{}}
+	count := func(license string) {
+		if license == "" || license == "NOASSERTION" {
+			license = "unknown"
+		}
+		s.Licenses[license]++
+	}
+	switch {
+	case sbom.SPDXVersion != "":
+		s.Format = sbom.SPDXVersion
+		s.Packages = len(sbom.Packages)
+		for _, p := range sbom.Packages {
+			license := p.LicenseConcluded
+			if license == "" || license == "NOASSERTION" {
+				license = p.LicenseDeclared
+			}
+			count(license)
+		}
+	case sbom.BOMFormat == "CycloneDX":
+		s.Format = "CycloneDX " + sbom.SpecVersion
+		s.Packages = len(sbom.Components)
+		for _, c := range sbom.Components {
+			var license string
+			for _, l := range c.Licenses {
+				for _, v := range []string{l.Expression, l.License.ID, l.License.Name} {
+					if license == "" {
+						license = v
+					}
+				}
+			}
+			count(license)
+		}
+	default:
+		return SBOMSummary{}, fmt.Errorf("unknown format of SBOM. It's either SPDX or CycloneDX in JSON")
+	}
+	return s, nil
+}
+
+// sbomTopLicenses is the number of the licenses shown in the summary, most used first.
+const sbomTopLicenses = 5
+
+func (s SBOMSummary) String() string {
+	licenses := make([]string, 0, len(s.Licenses))
+	for l := range s.Licenses {
+		licenses = append(licenses, l)
+	}
+	sort.Slice(licenses, func(i, j int) bool {
+		if s.Licenses[licenses[i]] != s.Licenses[licenses[j]] {
+			return s.Licenses[licenses[i]] > s.Licenses[licenses[j]]
+		}
+		return licenses[i] < licenses[j]
+	})
+	var top []string
+	for i, l := range licenses {
+		if i == sbomTopLicenses {
+			top = append(top, fmt.Sprintf("%d more", len(licenses)-i))
+			break
+		}
+		top = append(top, fmt.Sprintf("%s %d", l, s.Licenses[l]))
+	}
+	return fmt.Sprintf("%s, %d packages (%s)", s.Format, s.Packages, strings.Join(top, ", "))
+}
+
+// ImageSBOM is the SBOM of an image of a deploy.
+type ImageSBOM struct {
+	// Image is the reference of the image, pinned to the digest if known.
+	Image    string
+	Document []byte
+	Summary  SBOMSummary
+	// Err is why the SBOM isn't available, which doesn't fail the deploy.
+	Err error
+}
+
+// fetchSBOMs fetches the SBOMs of the images if the phase requires them.
+func fetchSBOMs(fetcher SBOMFetcher, ph DeployPhase, images []types.Image) []ImageSBOM {
+	if !ph.SBOM {
+		return nil
+	}
+	var sboms []ImageSBOM
+	for _, image := range images {
+		s := ImageSBOM{Image: imageReference(image)}
+		s.Document, s.Err = fetcher.FetchSBOM(s.Image, ph.ImageSignature)
+		if s.Err == nil {
+			s.Summary, s.Err = parseSBOM(s.Document)
+		}
+		if s.Err != nil {
+			log.Printf("[WARNING] Unable to fetch the SBOM of %s: %s", s.Image, s.Err)
+		}
+		sboms = append(sboms, s)
+	}
+	return sboms
+}
+
+// sbomLines returns the summaries of the SBOMs appended to the pull request body, or an empty string if none.
+func sbomLines(sboms []ImageSBOM) string {
+	if len(sboms) == 0 {
+		return ""
+	}
+	s := "\n\n*SBOM*"
+	for _, sbom := range sboms {
+		if sbom.Err != nil {
+			s += fmt.Sprintf("\n- `%s`: unavailable: %s", sbom.Image, sbom.Err)
+			continue
+		}
+		s += fmt.Sprintf("\n- `%s`: %s", sbom.Image, sbom.Summary)
+	}
+	return s
+}
+
+// withSBOMs adds the SBOMs of the deploy to its archived artifacts, as sbom/<image name>.json, and to its summary.
+func withSBOMs(o GitOpsPrepareOutput, sboms []ImageSBOM) GitOpsPrepareOutput {
+	var summaries []string
+	for _, s := range sboms {
+		if s.Err != nil {
+			continue
+		}
+		name := path.Base(imageRepository(s.Image))
+		if o.Artifacts == nil {
+			o.Artifacts = map[string][]byte{}
+		}
+		o.Artifacts["sbom/"+name+".json"] = s.Document
+		summaries = append(summaries, fmt.Sprintf("%s: %s", s.Image, s.Summary))
+	}
+	o.SBOMSummary = strings.Join(summaries, "; ")
+	return o
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+type fakeSBOMFetcher map[string]string
+
+func (f fakeSBOMFetcher) FetchSBOM(image string, policy ImageSignaturePolicy) ([]byte, error) {
+	doc, ok := f[image]
+	if !ok || !policy.hasIdentity() {
+		return nil, fmt.Errorf("no SBOM is attested")
+	}
+	return []byte(doc), nil
+}
+
+const testSPDX = `{"spdxVersion":"SPDX-2.3","packages":[
+	{"name":"a","licenseConcluded":"MIT"},
+	{"name":"b","licenseConcluded":"NOASSERTION","licenseDeclared":"Apache-2.0"},
+	{"name":"c","licenseConcluded":"MIT"},
+	{"name":"d"}
+]}`
+
+func TestParseSBOM(t *testing.T) {
+	s, err := parseSBOM([]byte(testSPDX))
+	require.NoError(t, err)
+	require.Equal(t, "SPDX-2.3, 4 packages (MIT 2, Apache-2.0 1, unknown 1)", s.String())
+
+	s, err = parseSBOM([]byte(`{"bomFormat":"CycloneDX","specVersion":"1.5","components":[
+		{"name":"a","licenses":[{"license":{"id":"BSD-3-Clause"}}]},
+		{"name":"b","licenses":[{"expression":"MIT OR Apache-2.0"}]}
+	]}`))
+	require.NoError(t, err)
+	require.Equal(t, "CycloneDX 1.5, 2 packages (BSD-3-Clause 1, MIT OR Apache-2.0 1)", s.String())
+
+	_, err = parseSBOM([]byte(`{"predicateType":"https://slsa.dev/provenance/v1"}`))
+	require.Error(t, err)
+}
+
+func TestSBOMFromAttestation(t *testing.T) {
+	payload := base64.StdEncoding.EncodeToString([]byte(`{"_type":"https://in-toto.io/Statement/v0.1","predicate":` + testSPDX + `}`))
+	out := `{"payloadType":"application/vnd.in-toto+json","payload":"` + payload + `"}` + "\n" + `{"payload":"second"}` + "\n"
+	doc, err := sbomFromAttestation([]byte(out))
+	require.NoError(t, err)
+	require.JSONEq(t, testSPDX, string(doc))
+}
+
+func TestFetchSBOMs(t *testing.T) {
+	fetcher := fakeSBOMFetcher{"registry/api@sha256:0123": testSPDX}
+	images := []types.Image{
+		{Name: "registry/api", NewTag: "abc", Digest: "sha256:0123"},
+		{Name: "registry/worker", NewTag: "abc"},
+	}
+	require.Nil(t, fetchSBOMs(fetcher, DeployPhase{}, images))
+
+	sboms := fetchSBOMs(fetcher, DeployPhase{SBOM: true, ImageSignature: ImageSignaturePolicy{Key: "cosign.pub"}}, images)
+	require.Len(t, sboms, 2)
+	require.Equal(t, "\n\n*SBOM*"+
+		"\n- `registry/api@sha256:0123`: SPDX-2.3, 4 packages (MIT 2, Apache-2.0 1, unknown 1)"+
+		"\n- `registry/worker:abc`: unavailable: no SBOM is attested", sbomLines(sboms))
+
+	o := withSBOMs(GitOpsPrepareOutput{Artifacts: map[string][]byte{"diff.patch": nil}}, sboms)
+	require.Equal(t, []byte(testSPDX), o.Artifacts["sbom/api.json"])
+	require.NotContains(t, o.Artifacts, "sbom/worker.json")
+	require.Equal(t, "registry/api@sha256:0123: SPDX-2.3, 4 packages (MIT 2, Apache-2.0 1, unknown 1)", o.SBOMSummary)
+}
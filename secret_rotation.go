@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	// secretRotatedAtAnnotation records when gocat rotated the secret last.
+	secretRotatedAtAnnotation = "gocat.zaim.net/rotated-at"
+	// externalSecretForceSyncAnnotation makes External Secrets Operator fetch the secret from the provider again.
+	externalSecretForceSyncAnnotation = "force-sync"
+)
+
+// SecretRotator rotates the secrets of the phases through pull requests to the manifest repository,
+// so that the rotations are reviewed and approved in Slack just like the deploys.
+//
+// It bumps the annotations of the manifest of the secret, instead of encrypting the new value by itself.
+// External Secrets Operator fetches the current value from the secrets provider on the change of force-sync,
+// which updates the Secret and lets the reloaders, like stakater/Reloader, restart the workloads using it.
+// The other kinds, like SealedSecret, hold the values in the manifest, which the annotations never change,
+// so they're refused instead of being rotated in name only.
+type SecretRotator struct {
+	github *GitHub
+	git    *GitOperator
+}
+
+func NewSecretRotator(github *GitHub, git *GitOperator) SecretRotator {
+	return SecretRotator{github: github, git: git}
+}
+
+// SecretRotationOutput is the result of SecretRotator.Rotate.
+type SecretRotationOutput struct {
+	PullRequestID     string
+	PullRequestNumber int
+	Branch            string
+}
+
+// Rotate opens the pull request bumping the annotations of the secret.
+func (r SecretRotator) Rotate(pj DeployProject, phase DeployPhase, secret PhaseSecret, requester User, now time.Time) (SecretRotationOutput, error) {
+	var o SecretRotationOutput
+	manifest, err := r.github.GetFile(secret.Path)
+	if err != nil {
+		return o, fmt.Errorf("unable to read the secret %s at %s: %w", secret.Name, secret.Path, err)
+	}
+	kind, annotations, err := secretRotationAnnotations(manifest, now)
+	if err != nil {
+		return o, fmt.Errorf("the secret %s at %s can't be rotated: %w", secret.Name, secret.Path, err)
+	}
+
+	o.Branch = fmt.Sprintf("bot/rotate-secret-%s-%s-%s-%s", pj.ID, phase.Name, secret.Name, RandString(5))
+	message := fmt.Sprintf("Rotate secret %s. project: %s, phase: %s.", secret.Name, pj.ID, phase.Name)
+	diff, err := r.git.PushOverWrite(o.Branch, secret.Path, AnnotationOverWrite{kind: kind, annotations: annotations}, message)
+	if err != nil {
+		return o, err
+	}
+
+	metadata := DeployMetadata{
+		Project:          pj.ID,
+		Phase:            phase.Name,
+		Requester:        requester.SlackDisplayName,
+		RequesterSlackID: requester.SlackUserID,
+		Kind:             DeployKindSecretRotation,
+	}
+	title := fmt.Sprintf("Rotate %s of %s %s", secret.Name, pj.ID, phase.Name)
+	body := fmt.Sprintf("Rotate the secret `%s` of %s %s\nRequested by %s\n\n```diff\n%s```\n\n%s", secret.Name, pj.ID, phase.Name, requester.SlackDisplayName, diff, metadata.PullRequestFooter())
+	o.PullRequestID, o.PullRequestNumber, err = r.github.CreatePullRequest(o.Branch, title, body)
+	if err != nil {
+		return o, err
+	}
+	if err := r.github.SetNewPullRequestMetadata("", o.PullRequestID, phase.PullRequest); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// secretRotationAnnotations returns the kind of the secret in the manifest and the annotations rotating it.
+// The manifest may have the other documents, like the Secret the ExternalSecret templates, which are kept as they are.
+func secretRotationAnnotations(manifest []byte, now time.Time) (string, map[string]string, error) {
+	docs, err := decodeYAMLDocuments(manifest)
+	if err != nil {
+		return "", nil, err
+	}
+	var kinds []string
+	for _, doc := range docs {
+		if kind := yamlString(yamlMapping(doc), "kind"); kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	for _, kind := range kinds {
+		if kind == "ExternalSecret" {
+			return kind, map[string]string{
+				secretRotatedAtAnnotation:         now.UTC().Format(time.RFC3339),
+				externalSecretForceSyncAnnotation: strconv.FormatInt(now.Unix(), 10),
+			}, nil
+		}
+	}
+	switch {
+	case len(kinds) == 0:
+		return "", nil, fmt.Errorf("kind is missing")
+	case kinds[0] == "SealedSecret":
+		return "", nil, fmt.Errorf("the values of SealedSecret are sealed in the manifest. Seal the new values with kubeseal and open the pull request instead")
+	default:
+		return "", nil, fmt.Errorf("%s is not supported. Only ExternalSecret is rotated by fetching the current value from the secrets provider", kinds[0])
+	}
+}
+
+// secretRotationBlocks returns the approval message of the rotation.
+func secretRotationBlocks(github *GitHub, userID string, pj DeployProject, phase string, secret PhaseSecret, o SecretRotationOutput) []slack.Block {
+	question := fmt.Sprintf("secret *%s* をローテーションしますか?", secret.Name)
+	return pullRequestApprovalBlocks(github, userID, pj, phase, question, "Rotate", o.PullRequestID, o.PullRequestNumber, o.Branch)
+}
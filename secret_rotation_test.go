@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretRotationAnnotations(t *testing.T) {
+	now := time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+	externalSecret := `# The password of the primary DB
+apiVersion: external-secrets.io/v1beta1
+kind: ExternalSecret
+metadata:
+  name: db-password
+spec:
+  refreshInterval: 1h # hourly
+  target:
+    name: db-password
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: db
+`
+	kind, annotations, err := secretRotationAnnotations([]byte(externalSecret), now)
+	require.NoError(t, err)
+	require.Equal(t, "ExternalSecret", kind)
+	require.Equal(t, map[string]string{"gocat.zaim.net/rotated-at": "2024-04-01T09:00:00Z", "force-sync": "1711962000"}, annotations)
+
+	// The comments and the other documents are kept
+	obj, err := AnnotationOverWrite{kind: kind, annotations: annotations}.Update([]byte(externalSecret))
+	require.NoError(t, err)
+	b, err := marshalOverWrite(obj)
+	require.NoError(t, err)
+	require.Equal(t, `# The password of the primary DB
+apiVersion: external-secrets.io/v1beta1
+kind: ExternalSecret
+metadata:
+  name: db-password
+  annotations:
+    force-sync: "1711962000"
+    gocat.zaim.net/rotated-at: "2024-04-01T09:00:00Z"
+spec:
+  refreshInterval: 1h # hourly
+  target:
+    name: db-password
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: db
+`, string(b))
+
+	// The annotations are updated in place
+	obj, err = AnnotationOverWrite{kind: kind, annotations: map[string]string{"force-sync": "1711965600"}}.Update(b)
+	require.NoError(t, err)
+	b, err = marshalOverWrite(obj)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "  annotations:\n    force-sync: \"1711965600\"\n    gocat.zaim.net/rotated-at: \"2024-04-01T09:00:00Z\"\n")
+
+	_, err = AnnotationOverWrite{kind: "ExternalSecret", annotations: annotations}.Update([]byte("kind: ConfigMap\n"))
+	require.EqualError(t, err, "no ExternalSecret found in the manifest")
+
+	sealedSecret := `apiVersion: bitnami.com/v1alpha1
+kind: SealedSecret
+metadata:
+  name: api-key
+spec:
+  encryptedData:
+    API_KEY: AgBy3i4OJSWK
+`
+	_, _, err = secretRotationAnnotations([]byte(sealedSecret), now)
+	require.EqualError(t, err, "the values of SealedSecret are sealed in the manifest. Seal the new values with kubeseal and open the pull request instead")
+
+	_, _, err = secretRotationAnnotations([]byte("kind: Secret\n"), now)
+	require.EqualError(t, err, "Secret is not supported. Only ExternalSecret is rotated by fetching the current value from the secrets provider")
+
+	_, _, err = secretRotationAnnotations([]byte("data: {}\n"), now)
+	require.EqualError(t, err, "kind is missing")
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Semver is a semantic version parsed from an image tag like v1.2.3 or 1.2.3-rc.1.
+// Build metadata is ignored.
+type Semver struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+var semverPattern = regexp.MustCompile(`^v?([0-9]+)\.([0-9]+)\.([0-9]+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// ParseSemver parses the tag as a semantic version.
+// It returns false if the tag isn't a semantic version.
+func ParseSemver(tag string) (Semver, bool) {
+	m := semverPattern.FindStringSubmatch(tag)
+	if m == nil {
+		return Semver{}, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	patch, _ := strconv.Atoi(m[3])
+	return Semver{Major: major, Minor: minor, Patch: patch, Prerelease: m[4]}, true
+}
+
+// Compare returns -1, 0, or 1 if v is lower than, equal to, or greater than o respectively,
+// following the precedence rules of https://semver.org.
+func (v Semver) Compare(o Semver) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+func comparePrerelease(a, b string) int {
+	// A version without prerelease has higher precedence than one with it.
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			// Numeric identifiers have lower precedence than alphanumeric ones.
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// SemverConstraint is a range of semantic versions like ~1.4, ^1.2.0, 1.4.x, or >=1.2.0 <2.0.0.
+//
+// Space-separated comparators are ANDed, and || separates alternatives.
+// Prerelease versions satisfy a constraint only when it's an exact version.
+type SemverConstraint struct {
+	raw          string
+	alternatives [][]semverComparator
+}
+
+type semverComparator struct {
+	op      string
+	version Semver
+}
+
+var semverComparatorPattern = regexp.MustCompile(`^(~|\^|>=|<=|>|<|=)?v?([0-9]+|[xX*])(?:\.([0-9]+|[xX*]))?(?:\.([0-9]+|[xX*]))?(?:-([0-9A-Za-z.-]+))?$`)
+
+// ParseSemverConstraint parses the constraint.
+func ParseSemverConstraint(s string) (SemverConstraint, error) {
+	c := SemverConstraint{raw: s}
+	for _, alt := range strings.Split(s, "||") {
+		var comparators []semverComparator
+		for _, f := range strings.Fields(alt) {
+			cs, err := parseSemverComparator(f)
+			if err != nil {
+				return c, fmt.Errorf("invalid semver constraint %q: %w", s, err)
+			}
+			comparators = append(comparators, cs...)
+		}
+		if len(comparators) == 0 {
+			return c, fmt.Errorf("invalid semver constraint %q", s)
+		}
+		c.alternatives = append(c.alternatives, comparators)
+	}
+	return c, nil
+}
+
+// parseSemverComparator parses a comparator, expanding ranges like ~1.4 into a pair of comparators.
+func parseSemverComparator(s string) ([]semverComparator, error) {
+	m := semverComparatorPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("unable to parse %q", s)
+	}
+	op, prerelease := m[1], m[5]
+	var parts []int
+	for _, p := range m[2:5] {
+		if p == "" || p == "x" || p == "X" || p == "*" {
+			break
+		}
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+	v := Semver{Prerelease: prerelease}
+	for i, n := range parts {
+		switch i {
+		case 0:
+			v.Major = n
+		case 1:
+			v.Minor = n
+		case 2:
+			v.Patch = n
+		}
+	}
+
+	// upper returns the exclusive upper bound incrementing the i-th part.
+	upper := func(i int) Semver {
+		switch i {
+		case 0:
+			return Semver{Major: v.Major + 1}
+		case 1:
+			return Semver{Major: v.Major, Minor: v.Minor + 1}
+		default:
+			return Semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+		}
+	}
+	lower := semverComparator{op: ">=", version: Semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch}}
+
+	switch op {
+	case "~":
+		// ~1 is >=1.0.0 <2.0.0, and ~1.4 and ~1.4.2 allow patch-level changes only.
+		i := 1
+		if len(parts) == 1 {
+			i = 0
+		}
+		return []semverComparator{lower, {op: "<", version: upper(i)}}, nil
+	case "^":
+		// ^1.2.3 allows changes that don't modify the left-most non-zero part.
+		i := 0
+		for i < len(parts)-1 && parts[i] == 0 {
+			i++
+		}
+		return []semverComparator{lower, {op: "<", version: upper(i)}}, nil
+	case "", "=":
+		if len(parts) == 0 {
+			return []semverComparator{{op: ">=", version: Semver{}}}, nil
+		}
+		if len(parts) < 3 {
+			// 1.4 and 1.4.x are the same as ~1.4
+			return []semverComparator{lower, {op: "<", version: upper(len(parts) - 1)}}, nil
+		}
+		return []semverComparator{{op: "=", version: v}}, nil
+	default:
+		return []semverComparator{{op: op, version: v}}, nil
+	}
+}
+
+func (c semverComparator) check(v Semver) bool {
+	d := v.Compare(c.version)
+	switch c.op {
+	case "=":
+		return d == 0
+	case ">":
+		return d > 0
+	case ">=":
+		return d >= 0
+	case "<":
+		return d < 0
+	case "<=":
+		return d <= 0
+	}
+	return false
+}
+
+// Check returns true if the version satisfies the constraint.
+func (c SemverConstraint) Check(v Semver) bool {
+	for _, comparators := range c.alternatives {
+		ok := true
+		for _, cmp := range comparators {
+			if v.Prerelease != "" && cmp.op != "=" {
+				ok = false
+				break
+			}
+			if !cmp.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c SemverConstraint) String() string {
+	return c.raw
+}
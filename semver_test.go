@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "v1.0.1", "1.2.0", "1.10.0"}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, ok := ParseSemver(ordered[i])
+		require.True(t, ok, ordered[i])
+		b, ok := ParseSemver(ordered[i+1])
+		require.True(t, ok, ordered[i+1])
+		require.Equal(t, -1, a.Compare(b), "%s < %s", ordered[i], ordered[i+1])
+		require.Equal(t, 1, b.Compare(a), "%s > %s", ordered[i+1], ordered[i])
+	}
+
+	_, ok := ParseSemver("master")
+	require.False(t, ok)
+}
+
+func TestSemverConstraint(t *testing.T) {
+	testcases := []struct {
+		constraint string
+		match      []string
+		unmatch    []string
+	}{
+		{constraint: "~1.4", match: []string{"1.4.0", "v1.4.9"}, unmatch: []string{"1.3.9", "1.5.0", "1.4.1-rc.1"}},
+		{constraint: "~1.4.2", match: []string{"1.4.2", "1.4.10"}, unmatch: []string{"1.4.1", "1.5.0"}},
+		{constraint: "^1.2", match: []string{"1.2.0", "1.9.9"}, unmatch: []string{"1.1.9", "2.0.0"}},
+		{constraint: "^0.2.3", match: []string{"0.2.3", "0.2.9"}, unmatch: []string{"0.3.0"}},
+		{constraint: "1.4.x", match: []string{"1.4.0", "1.4.7"}, unmatch: []string{"1.5.0"}},
+		{constraint: "1.4.2", match: []string{"1.4.2", "v1.4.2"}, unmatch: []string{"1.4.3"}},
+		{constraint: "1.5.0-rc.1", match: []string{"1.5.0-rc.1"}, unmatch: []string{"1.5.0"}},
+		{constraint: ">=1.2.0 <2.0.0", match: []string{"1.2.0", "1.99.0"}, unmatch: []string{"1.1.0", "2.0.0"}},
+		{constraint: "~1.4 || ~2.1", match: []string{"1.4.3", "2.1.0"}, unmatch: []string{"2.0.0", "1.5.0"}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.constraint, func(t *testing.T) {
+			c, err := ParseSemverConstraint(tc.constraint)
+			require.NoError(t, err)
+			for _, m := range tc.match {
+				v, ok := ParseSemver(m)
+				require.True(t, ok)
+				require.True(t, c.Check(v), m)
+			}
+			for _, m := range tc.unmatch {
+				v, ok := ParseSemver(m)
+				require.True(t, ok)
+				require.False(t, c.Check(v), m)
+			}
+		})
+	}
+
+	_, err := ParseSemverConstraint("latest")
+	require.Error(t, err)
+}
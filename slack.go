@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
+	"github.com/zaiminc/gocat/deploy"
 	"github.com/zaiminc/gocat/slackcmd"
 )
 
@@ -23,6 +25,51 @@ type SlackListener struct {
 	projectList       *ProjectList
 	userList          *UserList
 	interactorFactory *InteractorFactory
+	coordinator       *deploy.Coordinator
+	// approvalReaction is the name of the reaction, like +1, that approves the deploy when added to the approval message.
+	// Approval by reactions is disabled if empty.
+	approvalReaction string
+	approvalReminder *ApprovalReminder
+	// ephemeralResponses makes the responses only the requester needs, like help, ls, and errors,
+	// visible only to the requester, so that shared deploy channels aren't flooded.
+	ephemeralResponses bool
+	autoDeployHistory  *AutoDeployHistory
+	autoDeploy         *AutoDeploy
+	announcer          Announcer
+	// github is used to report its rate limits by the ratelimit command, and to read the config to import.
+	github      *GitHub
+	configStore *ConfigStore
+	jobRunner   JobRunner
+	blueGreen   BlueGreenSwitcher
+	// secretRotator opens the pull requests of the rotate-secret command.
+	secretRotator SecretRotator
+	// envSetter opens the pull requests of the env set command.
+	envSetter EnvSetter
+	// replayer opens the pull requests of the redeploy command.
+	replayer  DeployReplayer
+	recoverer *PanicRecoverer
+	// tracer keeps the timelines of the deploys for the trace command.
+	tracer  *DeployTracer
+	aliases *CommandAliasList
+	// workspaces routes the events to the workspaces they come from, when gocat is installed to more than one.
+	workspaces *SlackWorkspaces
+	// refresher refreshes the lists the commands read, which the reload command forces.
+	refresher *ListRefresher
+	// templates override the layout of the help.
+	templates *MessageTemplates
+	// prefs are the preferences of the users set by the prefs command.
+	prefs *UserPreferenceStore
+	// restrictReadCommands restricts the read-only commands to the users with the roles, including the viewers.
+	restrictReadCommands bool
+}
+
+// useWorkspace makes the listener respond in the workspace, by its bot to its users, with the projects available in it.
+// It's called on the copy of the listener for each event.
+func (s *SlackListener) useWorkspace(ws *SlackWorkspace) {
+	s.client = ws.client
+	s.userList = ws.userList
+	s.interactorFactory = ws.interactorFactory
+	s.projectList = s.projectList.InWorkspace(ws.Name)
 }
 
 func (s SlackListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -42,12 +89,26 @@ func (s SlackListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	eventsAPIEvent, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionVerifyToken(&slackevents.TokenComparator{VerificationToken: s.verificationToken}))
+	verify := slackevents.OptionVerifyToken(&slackevents.TokenComparator{VerificationToken: s.verificationToken})
+	if s.workspaces.Multiple() {
+		// The token is verified against the workspace of the event below
+		verify = slackevents.OptionNoVerifyToken()
+	}
+	eventsAPIEvent, err := slackevents.ParseEvent(json.RawMessage(body), verify)
 	if err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if s.workspaces.Multiple() {
+		ws, ok := s.workspaces.Authenticate(eventsAPIEvent.TeamID, eventsAPIEvent.Token)
+		if !ok {
+			log.Printf("[ERROR] Invalid verification token for team %s", eventsAPIEvent.TeamID)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.useWorkspace(ws)
+	}
 	if eventsAPIEvent.Type == slackevents.URLVerification {
 		var r *slackevents.ChallengeResponse
 		err = json.Unmarshal([]byte(body), &r)
@@ -72,55 +133,118 @@ func (s SlackListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				log.Println("[ERROR] ", err)
 				w.WriteHeader(http.StatusInternalServerError)
 			}
+		case *slackevents.MessageEvent:
+			if ev.ChannelType != slack.TYPE_IM {
+				return
+			}
+			if err := s.handleDirectMessageEvent(ev); err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		case *slackevents.ReactionAddedEvent:
+			// The approval merges the pull request or starts the deploy, which takes longer than Slack waits for the response,
+			// so the event is acknowledged before it's processed instead of being retried by Slack.
+			go func() {
+				if err := s.handleReactionAddedEvent(ev); err != nil {
+					log.Println("[ERROR] ", err)
+				}
+			}()
+		case *slackevents.WorkflowStepExecuteEvent:
+			if ev.CallbackID != workflowStepCallbackID {
+				return
+			}
+			// Deploy requests can take longer than Slack waits for the response.
+			// The result is reported back to the workflow via workflows.stepCompleted or workflows.stepFailed.
+			go s.handleWorkflowStepExecuteEvent(ev)
 		}
 	}
 }
 
+// The patterns of the commands taking no argument, which must be the first word after the mention,
+// so that the other commands containing them, like env set with the value false containing ls, aren't taken for them.
+var (
+	helpPattern   = regexp.MustCompile(`^\s*(<@\w+>\s*)?help\b`)
+	lsPattern     = regexp.MustCompile(`^\s*(<@\w+>\s*)?ls\b`)
+	reloadPattern = regexp.MustCompile(`^\s*(<@\w+>\s*)?reload\b`)
+)
+
 func (s *SlackListener) handleMessageEvent(ev *slackevents.AppMentionEvent) error {
+	defer s.recoverer.Recover("the command", ev.Channel)
 	// Only response mention to bot. Ignore else.
 	log.Print(ev.Text)
-	if regexp.MustCompile(`help`).MatchString(ev.Text) {
-		if _, _, err := s.client.PostMessage(ev.Channel, s.helpMessage()); err != nil {
+	expanded, err := s.aliases.Expand(ev.Text)
+	if err != nil {
+		if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorMessage(err.Error())); err != nil {
 			log.Println("[ERROR] ", err)
 		}
 		return nil
 	}
-	if regexp.MustCompile(`ls`).MatchString(ev.Text) {
-		if _, _, err := s.client.PostMessage(ev.Channel, s.projectListMessage()); err != nil {
+	if expanded != ev.Text {
+		log.Printf("[INFO] Expanded the alias: %s", expanded)
+		e := *ev
+		e.Text = expanded
+		ev = &e
+	}
+	text, reason := parseDeployReason(ev.Text)
+	text = s.withDefaultPhase(text, ev.User)
+	if helpPattern.MatchString(text) {
+		if _, _, err := s.postQuietly(ev.Channel, ev.User, s.helpMessage(ev.User)); err != nil {
+			log.Println("[ERROR] ", err)
+		}
+		return nil
+	}
+	if lsPattern.MatchString(text) {
+		message := s.projectListMessage()
+		if err := s.checkViewer(ev.User); err != nil {
+			message = s.errorMessage(err.Error())
+		}
+		if _, _, err := s.postQuietly(ev.Channel, ev.User, message); err != nil {
 			log.Println("[ERROR] ", err)
 		}
 		return nil
 	}
-	if regexp.MustCompile(`reload`).MatchString(ev.Text) {
-		s.projectList.Reload()
-		s.userList.Reload()
-		section := slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", "Deploy Projects and Users is Reloaded", false, false), nil, nil)
+	if reloadPattern.MatchString(text) {
+		s.refresher.Refresh()
+		section := slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", "Deploy Projects and Users is Reloaded"+projectErrorsText(s.projectList.Errors()), false, false), nil, nil)
 		if _, _, err := s.client.PostMessage(ev.Channel, slack.MsgOptionBlocks(section)); err != nil {
 			log.Println("[ERROR] ", err)
 		}
 		return nil
 	}
 
-	s.projectList.Reload()
-	s.userList.Reload()
-	if match := regexp.MustCompile(`deploy ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd) branch`).FindAllStringSubmatch(ev.Text, -1); match != nil {
+	if regexp.MustCompile(`(^|\s)deploy\s`).MatchString(text) {
+		if err := s.checkRequester(ev.User); err != nil {
+			if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorResponse(err, ev.Text)); err != nil {
+				log.Println("[ERROR] ", err)
+			}
+			return nil
+		}
+	}
+	if match := regexp.MustCompile(`\bdeploy ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd) branch`).FindAllStringSubmatch(text, -1); match != nil {
 		log.Println("[INFO] Deploy command is Called")
 		commands := strings.Split(match[0][0], " ")
 		target, err := s.projectList.FindByAlias(commands[1])
 		if err != nil {
 			log.Println("[ERROR] ", err)
-			if _, _, err := s.client.PostMessage(ev.Channel, s.errorMessage(err.Error())); err != nil {
+			if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorResponse(err, ev.Text)); err != nil {
 				log.Println("[ERROR] ", err)
 			}
 			return nil
 		}
 
 		phase := s.toPhase(commands[2])
+		if err := checkDeployable(s.coordinator, target.ID, phase); err != nil {
+			log.Println("[ERROR] ", err)
+			if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorResponse(err, ev.Text)); err != nil {
+				log.Println("[ERROR] ", err)
+			}
+			return nil
+		}
 		interactor := s.interactorFactory.Get(target, phase)
 		blocks, err := interactor.BranchList(target, phase)
 		if err != nil {
 			log.Println("[ERROR] ", err)
-			if _, _, err := s.client.PostMessage(ev.Channel, s.errorMessage(err.Error())); err != nil {
+			if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorResponse(err, ev.Text)); err != nil {
 				log.Println("[ERROR] ", err)
 			}
 			return nil
@@ -131,49 +255,69 @@ func (s *SlackListener) handleMessageEvent(ev *slackevents.AppMentionEvent) erro
 		}
 		return nil
 	}
-	if match := regexp.MustCompile(`deploy ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)`).FindAllStringSubmatch(ev.Text, -1); match != nil {
+	if match := regexp.MustCompile(`\bdeploy ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd) ([~^<>=vxX*0-9].*)`).FindStringSubmatch(text); match != nil {
+		log.Println("[INFO] Deploy command with semver constraint is Called")
+		if err := s.deployBySemverConstraint(match[1], s.toPhase(match[2]), strings.TrimSpace(match[3]), reason, ev.User, ev.Channel); err != nil {
+			log.Println("[ERROR] ", err)
+			if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorResponse(err, ev.Text)); err != nil {
+				log.Println("[ERROR] ", err)
+			}
+		}
+		return nil
+	}
+	if match := regexp.MustCompile(`\bdeploy ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)`).FindAllStringSubmatch(text, -1); match != nil {
 		log.Println("[INFO] Deploy command is Called")
 		commands := strings.Split(match[0][0], " ")
 		target, err := s.projectList.FindByAlias(commands[1])
 		if err != nil {
 			log.Println("[ERROR] ", err)
-			if _, _, err := s.client.PostMessage(ev.Channel, s.errorMessage(err.Error())); err != nil {
+			if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorResponse(err, ev.Text)); err != nil {
 				log.Println("[ERROR] ", err)
 			}
 			return nil
 		}
 
 		phase := s.toPhase(commands[2])
+		if err := checkDeployable(s.coordinator, target.ID, phase); err != nil {
+			log.Println("[ERROR] ", err)
+			if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorResponse(err, ev.Text)); err != nil {
+				log.Println("[ERROR] ", err)
+			}
+			return nil
+		}
 		interactor := s.interactorFactory.Get(target, phase)
-		blocks, err := interactor.Request(target, phase, target.DefaultBranch(), ev.User, ev.Channel)
+		blocks, err := requestDeploy(interactor, target, phase, DeployOption{Branch: target.DefaultBranch(), Reason: reason}, ev.User, ev.Channel)
 		if err != nil {
 			log.Println("[ERROR] ", err)
-			if _, _, err := s.client.PostMessage(ev.Channel, s.errorMessage(err.Error())); err != nil {
+			if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorResponse(err, ev.Text)); err != nil {
 				log.Println("[ERROR] ", err)
 			}
 			return nil
 		}
 
-		if _, _, err := s.client.PostMessage(ev.Channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+		channel, ts, err := s.client.PostMessage(ev.Channel, slack.MsgOptionBlocks(blocks...))
+		if err != nil {
 			log.Println("[ERROR] ", err)
+			return nil
 		}
+		s.approvalReminder.Track(s.client, target.ID, phase, channel, ts, blocks)
 		return nil
 	}
-	if regexp.MustCompile(`deploy staging`).MatchString(ev.Text) {
+	if regexp.MustCompile(`\bdeploy staging`).MatchString(text) {
 		msgOpt := s.SelectDeployTarget("staging")
 		if _, _, err := s.client.PostMessage(ev.Channel, msgOpt); err != nil {
 			log.Println("[ERROR] ", err)
 		}
 		return nil
 	}
-	if regexp.MustCompile(`deploy production`).MatchString(ev.Text) {
+	if regexp.MustCompile(`\bdeploy production`).MatchString(text) {
 		msgOpt := s.SelectDeployTarget("production")
 		if _, _, err := s.client.PostMessage(ev.Channel, msgOpt); err != nil {
 			log.Println("[ERROR] ", err)
 		}
 		return nil
 	}
-	if regexp.MustCompile(`deploy sandbox`).MatchString(ev.Text) {
+	if regexp.MustCompile(`\bdeploy sandbox`).MatchString(text) {
 		msgOpt := s.SelectDeployTarget("sandbox")
 		if _, _, err := s.client.PostMessage(ev.Channel, msgOpt); err != nil {
 			log.Println("[ERROR] ", err)
@@ -182,33 +326,181 @@ func (s *SlackListener) handleMessageEvent(ev *slackevents.AppMentionEvent) erro
 	}
 	if cmd, _ := slackcmd.Parse(ev.Text); cmd != nil {
 		log.Printf("[INFO] %s command is Called", cmd.Name())
-		// TODO run the command and post the result to slack
+		blocks, err := s.runCommand(cmd, ev.User, ev.Channel)
+		if err != nil {
+			log.Println("[ERROR] ", err)
+			if _, _, err := s.postQuietly(ev.Channel, ev.User, s.errorResponse(err, ev.Text)); err != nil {
+				log.Println("[ERROR] ", err)
+			}
+			return nil
+		}
+		if _, _, err := s.client.PostMessage(ev.Channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+			log.Println("[ERROR] ", err)
+		}
 		return nil
 	}
 	return nil
 }
 
-func (s *SlackListener) helpMessage() slack.MsgOption {
+// defaultPhaseDeployPattern matches the deploy command without the phase, like deploy myapp.
+var defaultPhaseDeployPattern = regexp.MustCompile(`\bdeploy ([0-9a-zA-Z-]+)\s*$`)
+
+// withDefaultPhase appends the default phase of the user to the deploy command without the phase.
+func (s *SlackListener) withDefaultPhase(text, user string) string {
+	match := defaultPhaseDeployPattern.FindStringSubmatch(text)
+	if match == nil || regexp.MustCompile(`^(staging|production|sandbox|stg|pro|prd)$`).MatchString(match[1]) {
+		return text
+	}
+	phase := s.prefs.Get(user).DefaultPhase
+	if phase == "" {
+		return text
+	}
+	return strings.TrimRight(text, " \t") + " " + phase
+}
+
+// deployBySemverConstraint requests the deploy of the highest semver tag satisfying the constraint, like ~1.4 or 1.4.2.
+func (s *SlackListener) deployBySemverConstraint(project, phase, constraint, reason, user, channel string) error {
+	target, err := s.projectList.FindByAlias(project)
+	if err != nil {
+		return err
+	}
+	if _, err := ParseSemverConstraint(constraint); err != nil {
+		return err
+	}
+	if err := checkDeployable(s.coordinator, target.ID, phase); err != nil {
+		return err
+	}
+	interactor := s.interactorFactory.Get(target, phase)
+	if _, ok := interactor.(OptionRequester); !ok {
+		return fmt.Errorf("deploying %s by semver constraint is not supported", target.ID)
+	}
+
+	ecr, err := CreateECRInstance()
+	if err != nil {
+		return err
+	}
+	tag, err := ecr.FindImageTag(target.SemverTagQuery(target.FindPhase(phase), constraint))
+	if err != nil {
+		return fmt.Errorf("no tag of %s satisfies %s: %w", target.ID, constraint, err)
+	}
+
+	blocks, err := requestDeploy(interactor, target, phase, DeployOption{Branch: target.DefaultBranch(), Tag: tag, Reason: reason}, user, channel)
+	if err != nil {
+		return err
+	}
+	channel, ts, err := s.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...))
+	if err != nil {
+		return err
+	}
+	s.approvalReminder.Track(s.client, target.ID, phase, channel, ts, blocks)
+	return nil
+}
+
+// helpMessage returns the help in the layout of the help template for the locale of the user, or the built-in one.
+func (s *SlackListener) helpMessage(user string) slack.MsgOption {
+	return slack.MsgOptionBlocks(s.templates.BlocksOr("help", s.prefs.Get(user).Locale, nil, builtinHelpBlocks)...)
+}
+
+func builtinHelpBlocks() []slack.Block {
 	deployMasterText := slack.NewTextBlockObject("mrkdwn", "*masterのデプロイ*\n`@bot-name deploy api staging`\napiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。\nコマンド入力後にデプロイするかの確認ボタンが出てきます。", false, false)
 	deployMasterSection := slack.NewSectionBlock(deployMasterText, nil, nil)
 
 	deployBranchText := slack.NewTextBlockObject("mrkdwn", "*ブランチのデプロイ*\n`@bot-name deploy api staging branch`\napiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。\nブランチを選択するドロップダウンが出てきます。\nブランチ選択後にデプロイするかの確認ボタンが出てきます。", false, false)
 	deployBranchSection := slack.NewSectionBlock(deployBranchText, nil, nil)
 
+	deploySemverText := slack.NewTextBlockObject("mrkdwn", "*バージョンを指定したデプロイ*\n`@bot-name deploy api production ~1.4`\n`~1.4` の部分には `1.4.2` や `^1.2` 、`>=1.2.0 <2.0.0` などのsemverの範囲を指定できます。\n範囲を満たす最新のタグがデプロイされます。", false, false)
+	deploySemverSection := slack.NewSectionBlock(deploySemverText, nil, nil)
+
+	reasonText := slack.NewTextBlockObject("mrkdwn", "*理由を添えたデプロイ*\n`@bot-name deploy api production --reason \"JIRA-1234 の修正\"`\n理由はPull Requestに記録されます。理由が必須の環境ではボタンからのデプロイはできません。", false, false)
+	reasonSection := slack.NewSectionBlock(reasonText, nil, nil)
+
+	pinText := slack.NewTextBlockObject("mrkdwn", "*タグの固定*\n`@bot-name pin api production v1.2.3 for 障害調査`\n`unpin` するまで、AutoDeployを含むデプロイがブロックされます。\n`@bot-name unpin api production` で解除し、`@bot-name status api` で状態を確認できます。", false, false)
+	pinSection := slack.NewSectionBlock(pinText, nil, nil)
+
+	autoDeployLogText := slack.NewTextBlockObject("mrkdwn", "*AutoDeployの履歴*\n`@bot-name autodeploy log api production`\n直近のAutoDeployの判定結果(検出したタグ、現在のタグ、デプロイしたかどうかとその理由)を表示します。\n`@bot-name simulate autodeploy api production`\nAutoDeployと同じ判定を一度だけ実行し、追跡するブランチ、現在のタグ、検出したタグと、デプロイするかどうかとその理由を表示します。実際にはデプロイしません。", false, false)
+	autoDeployLogSection := slack.NewSectionBlock(autoDeployLogText, nil, nil)
+
+	emergencyText := slack.NewTextBlockObject("mrkdwn", "*全デプロイの緊急停止*\n`@bot-name emergency-stop for 大規模障害対応`\n`@bot-name emergency-resume` で再開するまで、AutoDeployを含むすべてのデプロイと承認がブロックされます。\n停止と再開はアナウンスチャンネルに通知されます。Adminのみ実行できます。", false, false)
+	emergencySection := slack.NewSectionBlock(emergencyText, nil, nil)
+
+	rateLimitText := slack.NewTextBlockObject("mrkdwn", "*GitHubのRate Limit*\n`@bot-name ratelimit`\nGitHub APIの残りリクエスト数とリセット時刻を表示します。Adminのみ実行できます。\n残りが少なくなると、AutoDeployなどのバックグラウンド処理はSlackからのデプロイを優先してリセットまで待機します。", false, false)
+	rateLimitSection := slack.NewSectionBlock(rateLimitText, nil, nil)
+
+	projectAddText := slack.NewTextBlockObject("mrkdwn", "*プロジェクトの追加*\n`@bot-name project add`\nボタンから開くフォームにリポジトリ、ECRリポジトリ、フェーズとパスを入力すると、GitHubとECRで検証した上でプロジェクトが追加されます。Adminのみ実行できます。", false, false)
+	projectAddSection := slack.NewSectionBlock(projectAddText, nil, nil)
+
+	configText := slack.NewTextBlockObject("mrkdwn", "*設定のエクスポートとインポート*\n`@bot-name config export`\nプロジェクトとユーザーの設定をYAMLファイルとしてアップロードします。\n`@bot-name config diff gocat/config.yaml` でマニフェストリポジトリ上のYAMLとの差分を確認し、`@bot-name config apply gocat/config.yaml` で適用します。Adminのみ実行できます。", false, false)
+	configSection := slack.NewSectionBlock(configText, nil, nil)
+
+	featuresText := slack.NewTextBlockObject("mrkdwn", "*フィーチャーフラグ*\n`@bot-name features`\n新しい機能と、それが有効になっているプロジェクトを表示します。`@bot-name features api` でプロジェクトごとに確認できます。\n機能は`CONFIG_FEATURE_FLAGS`か、プロジェクトのConfigMapの`Features`キーで有効にします。Adminのみ実行できます。", false, false)
+	featuresSection := slack.NewSectionBlock(featuresText, nil, nil)
+
+	runJobText := slack.NewTextBlockObject("mrkdwn", "*ジョブの実行*\n`@bot-name run api production job migrate`\nフェーズに設定したJobのテンプレートを、デプロイ中のイメージタグでレンダリングして、マニフェストリポジトリにコミットするかクラスタに直接作成します。\n末尾にタグを付けると、そのタグで実行します。", false, false)
+	runJobSection := slack.NewSectionBlock(runJobText, nil, nil)
+
+	switchText := slack.NewTextBlockObject("mrkdwn", "*ブルー/グリーンの切り替え*\n`@bot-name switch api production`\nデプロイ先になっている待機中の色を検証し、問題がなければServiceやIngressを切り替えて本番に投入します。", false, false)
+	switchSection := slack.NewSectionBlock(switchText, nil, nil)
+
+	rotateSecretText := slack.NewTextBlockObject("mrkdwn", "*シークレットのローテーション*\n`@bot-name rotate-secret api production db-password`\nフェーズに設定したシークレットのマニフェストのアノテーションを更新するPRを作成します。\nExternalSecretはシークレットプロバイダから値を取得し直します。", false, false)
+	rotateSecretSection := slack.NewSectionBlock(rotateSecretText, nil, nil)
+	envSetText := slack.NewTextBlockObject("mrkdwn", "*環境変数の変更*\n`@bot-name env set api production LOG_LEVEL=debug`\nフェーズの`env`に設定したConfigMapの値を変更するPRを作成します。変更できるのは`env`の`keys`に含まれるキーのみです。", false, false)
+	envSetSection := slack.NewSectionBlock(envSetText, nil, nil)
+
+	explainText := slack.NewTextBlockObject("mrkdwn", "*デプロイ内容の確認*\n`@bot-name explain api production`\nデプロイした場合に変更されるリポジトリ、ブランチ、ファイルとYAMLのパスを、現在の設定から表示します。実際にはデプロイしません。", false, false)
+	explainSection := slack.NewSectionBlock(explainText, nil, nil)
+	traceText := slack.NewTextBlockObject("mrkdwn", "*デプロイのタイムライン*\n`@bot-name trace 1a2b3c4d`\nデプロイのメッセージやPull Requestに表示されるTrace IDを指定して、リクエストからマージまでの経過を表示します。gocatの起動以降の直近のデプロイのみ記録されています。", false, false)
+	traceSection := slack.NewSectionBlock(traceText, nil, nil)
+	historyText := slack.NewTextBlockObject("mrkdwn", "*デプロイの履歴*\n`@bot-name history api production`\nフェーズの直近のデプロイを、Trace IDと状態とともに新しい順に表示します。\n`@bot-name diff api staging production`\n二つのフェーズにデプロイされているタグと、その間の変更履歴のリンクを表示します。\nrolebindingの`Viewer`にはこれらや`ls`、`status`などの参照系のコマンドのみ許可され、デプロイやロックはできません。", false, false)
+	historySection := slack.NewSectionBlock(historyText, nil, nil)
+	queueText := slack.NewTextBlockObject("mrkdwn", "*デプロイのキュー*\n`@bot-name queue`\n全プロジェクトの準備中、承認待ち、デプロイ中のデプロイを表示します。承認待ちのデプロイは、リクエストした本人はCancelボタンで取り消せます。Adminはすべて取り消せます。", false, false)
+	queueSection := slack.NewSectionBlock(queueText, nil, nil)
+	redeployText := slack.NewTextBlockObject("mrkdwn", "*過去のデプロイの再適用*\n`@bot-name redeploy 1a2b3c4d`\nS3にアーカイブされたデプロイのTrace IDを指定して、そのデプロイ時点のoverlayに戻すPRを作成します。クラスタの復元後や、マニフェストリポジトリの誤ったrevertの復旧に使えます。Kustomizeのデプロイのみ対応しています。", false, false)
+	redeploySection := slack.NewSectionBlock(redeployText, nil, nil)
+	retryText := slack.NewTextBlockObject("mrkdwn", "*失敗したデプロイのリトライ*\n`@bot-name retry 1a2b3c4d`\n失敗したデプロイのTrace IDを指定して、失敗した段階 (PRの作成かマージ) だけを、解決済みのブランチやタグなどの入力のまま再実行します。失敗メッセージのRetryボタンでも同じことができます。Kustomizeなど、PRでデプロイするフェーズのみ対応しています。", false, false)
+	retrySection := slack.NewSectionBlock(retryText, nil, nil)
+	rollbackText := slack.NewTextBlockObject("mrkdwn", "*ロールバック*\n`@bot-name rollback api production`\nイメージごとに、マニフェストの履歴から一つ前のタグに戻すPRを作成します。複数のイメージをデプロイするフェーズでは、workerだけなど、戻すイメージをモーダルで選べます。", false, false)
+	rollbackSection := slack.NewSectionBlock(rollbackText, nil, nil)
+	prefsText := slack.NewTextBlockObject("mrkdwn", "*個人設定*\n`@bot-name prefs set notify dm`\n`notify` (`channel` か `dm`)、`default-phase` (`deploy api` でフェーズを省略した時のフェーズ)、`locale` (`ja` か `en`) を設定します。`dm` にすると、リクエストしたデプロイの結果がDMでも届きます。`prefs` で現在の設定を、`prefs unset notify` で初期値に戻します。", false, false)
+	prefsSection := slack.NewSectionBlock(prefsText, nil, nil)
+	directMessageText := slack.NewTextBlockObject("mrkdwn", "*DMでの利用*\nbotにDMで、メンションなしで同じコマンドを送ることもできます。DMで実行したデプロイのコマンドは、透明性のためにphaseのnotifyChannelにも投稿されます。", false, false)
+	directMessageSection := slack.NewSectionBlock(directMessageText, nil, nil)
+
 	deployText := slack.NewTextBlockObject("mrkdwn", "*デプロイ対象の選択をSlackのUIから選択するデプロイ手法*\n`@bot-name deploy staging`\nstagingの部分はproductionやsandboxに置換可能です。\nデプロイ対象の選択後にデプロイするブランチの選択肢が出てきます。", false, false)
 	deploySection := slack.NewSectionBlock(deployText, nil, nil)
 
-	return slack.MsgOptionBlocks(
+	return []slack.Block{
 		deployMasterSection,
 		deployBranchSection,
+		deploySemverSection,
+		reasonSection,
 		deploySection,
+		pinSection,
+		autoDeployLogSection,
+		emergencySection,
+		rateLimitSection,
+		projectAddSection,
+		configSection,
+		featuresSection,
+		runJobSection,
+		switchSection,
+		rotateSecretSection,
+		envSetSection,
+		explainSection,
+		traceSection,
+		historySection,
+		queueSection,
+		redeploySection,
+		retrySection,
+		rollbackSection,
+		prefsSection,
+		directMessageSection,
 		CloseButton(),
-	)
+	}
 }
 
 func (s *SlackListener) projectListMessage() slack.MsgOption {
 	text := ""
-	for _, pj := range s.projectList.Items {
+	for _, pj := range s.projectList.All() {
 		text = text + fmt.Sprintf("*%s* (%s)\n", pj.ID, pj.GitHubRepository())
 	}
 
@@ -225,9 +517,9 @@ func (s *SlackListener) projectListMessage() slack.MsgOption {
 func (s *SlackListener) SelectDeployTarget(phase string) slack.MsgOption {
 	headerText := slack.NewTextBlockObject("mrkdwn", ":cat:", false, false)
 	headerSection := slack.NewSectionBlock(headerText, nil, nil)
-	sections := make([]slack.Block, len(s.projectList.Items)+2)
+	sections := make([]slack.Block, len(s.projectList.All())+2)
 	sections[0] = headerSection
-	for i, pj := range s.projectList.Items {
+	for i, pj := range s.projectList.All() {
 		sections[i+1] = createDeployButtonSection(pj, phase)
 	}
 	sections[len(sections)-1] = CloseButton()
@@ -247,6 +539,26 @@ func createDeployButtonSection(pj DeployProject, phaseName string) *slack.Sectio
 	return section
 }
 
+// postQuietly posts the message only visible to the user if ephemeralResponses is enabled,
+// or visible to everyone in the channel otherwise.
+func (s *SlackListener) postQuietly(channel, user string, options ...slack.MsgOption) (string, string, error) {
+	if s.ephemeralResponses {
+		ts, err := s.client.PostEphemeral(channel, user, options...)
+		return channel, ts, err
+	}
+	return s.client.PostMessage(channel, options...)
+}
+
+// errorResponse returns the message of the error of the command text,
+// with the buttons running the command again with the suggested project names if the project isn't found.
+func (s *SlackListener) errorResponse(err error, text string) slack.MsgOption {
+	var notFound *ProjectNotFoundError
+	if errors.As(err, &notFound) && len(notFound.Suggestions) > 0 {
+		return slack.MsgOptionBlocks(projectSuggestionBlocks(notFound, text)...)
+	}
+	return s.errorMessage(describeError(err))
+}
+
 func (s *SlackListener) errorMessage(message string) slack.MsgOption {
 	txt := slack.NewTextBlockObject("mrkdwn", message, false, false)
 	section := slack.NewSectionBlock(txt, nil, nil)
@@ -265,3 +577,12 @@ func (s *SlackListener) toPhase(str string) string {
 		return "staging"
 	}
 }
+
+// isPhaseAlias returns true if str is one of the names toPhase resolves, instead of falling back to staging.
+func isPhaseAlias(str string) bool {
+	switch str {
+	case "pro", "prd", "production", "stg", "staging", "sandbox":
+		return true
+	}
+	return false
+}
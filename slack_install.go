@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const slackInstallationConfigMapType = "slack-installation"
+
+// slackInstallStateTTL is how long the install link is valid, from clicking Add to Slack to approving the install.
+const slackInstallStateTTL = 10 * time.Minute
+
+// slackBotScopes are the bot token scopes gocat asks for on the install.
+var slackBotScopes = []string{
+	"app_mentions:read",
+	"channels:history",
+	"chat:write",
+	"groups:history",
+	"im:history",
+	"reactions:read",
+	"usergroups:read",
+	"users:read",
+	"users:read.email",
+	"workflow.steps:execute",
+}
+
+// TokenCipher encrypts the bot tokens of the installed workspaces with AES-GCM,
+// so that the configmaps they're stored in don't leak the tokens to the readers of the configmaps.
+type TokenCipher struct {
+	aead cipher.AEAD
+}
+
+// NewTokenCipher returns the TokenCipher of the base64-encoded 32-byte key, like the output of `openssl rand -base64 32`.
+func NewTokenCipher(key string) (*TokenCipher, error) {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("the encryption key isn't base64: %w", err)
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("the encryption key must be 32 bytes, not %d", len(b))
+	}
+	block, err := aes.NewCipher(b)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenCipher{aead: aead}, nil
+}
+
+// Encrypt returns the base64-encoded nonce followed by the ciphertext of the token of the team.
+// The team ID is authenticated as the associated data, so that the token can't be decrypted as the one of another team,
+// like when it's copied to the configmap of another installation.
+func (c *TokenCipher) Encrypt(token string, teamID string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(token), []byte(teamID))), nil
+}
+
+// Decrypt decrypts the token Encrypt encrypted for the team.
+func (c *TokenCipher) Decrypt(encrypted string, teamID string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(b) < c.aead.NonceSize() {
+		return "", fmt.Errorf("the encrypted token is too short")
+	}
+	plain, err := c.aead.Open(nil, b[:c.aead.NonceSize()], b[c.aead.NonceSize():], []byte(teamID))
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt the token of %s: %w", teamID, err)
+	}
+	return string(plain), nil
+}
+
+// SlackInstallation is the workspace gocat is installed to by the Add to Slack button.
+type SlackInstallation struct {
+	TeamID   string
+	TeamName string
+	Token    string
+}
+
+// SlackInstallationStore stores the installations in the configmaps of the slack-installation type, with the tokens encrypted.
+type SlackInstallationStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	cipher    *TokenCipher
+	// allowedTeams are the IDs of the teams gocat can be installed to. No team can be if empty.
+	allowedTeams []string
+}
+
+func NewSlackInstallationStore(namespace string, cipher *TokenCipher, allowedTeams []string) *SlackInstallationStore {
+	return &SlackInstallationStore{namespace: namespace, cipher: cipher, allowedTeams: allowedTeams}
+}
+
+// Allows returns true if gocat can be installed to the team.
+func (s *SlackInstallationStore) Allows(teamID string) bool {
+	return teamID != "" && containsString(s.allowedTeams, teamID)
+}
+
+func (s *SlackInstallationStore) client() (kubernetes.Interface, error) {
+	if s.clientset != nil {
+		return s.clientset, nil
+	}
+	client, err := newKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	s.clientset = client
+	return client, nil
+}
+
+// Save creates or updates the configmap of the installation.
+func (s *SlackInstallationStore) Save(ctx context.Context, inst SlackInstallation) error {
+	if !s.Allows(inst.TeamID) {
+		return fmt.Errorf("team %s isn't allowed to install gocat", inst.TeamID)
+	}
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	token, err := s.cipher.Encrypt(inst.Token, inst.TeamID)
+	if err != nil {
+		return err
+	}
+	data := map[string]string{"TeamID": inst.TeamID, "TeamName": inst.TeamName, "Token": token}
+	name := "slack-installation-" + strings.ToLower(inst.TeamID)
+	configMaps := client.CoreV1().ConfigMaps(s.namespace)
+	cm, err := configMaps.Get(ctx, name, meta_v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: map[string]string{configMapTypeLabel: slackInstallationConfigMapType}},
+			Data:       data,
+		}
+		if _, err := configMaps.Create(ctx, cm, meta_v1.CreateOptions{}); err != nil {
+			return fmt.Errorf("unable to create %s: %w", name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get %s: %w", name, err)
+	}
+	cm.Data = data
+	if _, err := configMaps.Update(ctx, cm, meta_v1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("unable to update %s: %w", name, err)
+	}
+	return nil
+}
+
+// List returns the installations. The ones of the teams no longer allowed, and the ones failed to decrypt,
+// like the ones encrypted with the previous key or for another team, are skipped.
+func (s *SlackInstallationStore) List(ctx context.Context) ([]SlackInstallation, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	cml, err := client.CoreV1().ConfigMaps(s.namespace).List(ctx, meta_v1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", configMapTypeLabel, slackInstallationConfigMapType)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the installations: %w", err)
+	}
+	var installations []SlackInstallation
+	for _, cm := range cml.Items {
+		if !s.Allows(cm.Data["TeamID"]) {
+			log.Printf("[WARNING] Skipped the installation %s, as team %s isn't allowed", cm.Name, cm.Data["TeamID"])
+			continue
+		}
+		token, err := s.cipher.Decrypt(cm.Data["Token"], cm.Data["TeamID"])
+		if err != nil {
+			log.Printf("[ERROR] Skipped the installation %s: %s", cm.Name, err)
+			continue
+		}
+		installations = append(installations, SlackInstallation{TeamID: cm.Data["TeamID"], TeamName: cm.Data["TeamName"], Token: token})
+	}
+	return installations, nil
+}
+
+// slackInstallHandler is a http.Handler of the OAuth v2 flow that installs gocat to a workspace by the Add to Slack button.
+//
+//	GET /slack/install         redirects to the authorization page of Slack.
+//	GET /slack/oauth_redirect  exchanges the code for the bot token, and starts serving the workspace.
+//
+// The installed workspaces are named by their team IDs, like T0123/alice in rolebindings.
+type slackInstallHandler struct {
+	clientID     string
+	clientSecret string
+	// redirectURL is the URL of /slack/oauth_redirect registered in the Slack app.
+	redirectURL string
+	store       *SlackInstallationStore
+	workspaces  *SlackWorkspaces
+	// newWorkspace builds the workspace of the bot token.
+	newWorkspace func(name, token string) *SlackWorkspace
+	// states is the map from the state parameter of each install link to its expiry, against the CSRF.
+	states *sync.Map
+	// exchange and revoke are replaced in tests
+	exchange func(code string) (*slack.OAuthV2Response, error)
+	revoke   func(token string) error
+}
+
+func newSlackInstallHandler(clientID, clientSecret, redirectURL string, store *SlackInstallationStore, workspaces *SlackWorkspaces, newWorkspace func(name, token string) *SlackWorkspace) slackInstallHandler {
+	h := slackInstallHandler{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		store:        store,
+		workspaces:   workspaces,
+		newWorkspace: newWorkspace,
+		states:       &sync.Map{},
+	}
+	h.exchange = func(code string) (*slack.OAuthV2Response, error) {
+		return slack.GetOAuthV2Response(http.DefaultClient, clientID, clientSecret, code, redirectURL)
+	}
+	h.revoke = func(token string) error {
+		_, err := slack.New(token).SendAuthRevoke(token)
+		return err
+	}
+	return h
+}
+
+func (h slackInstallHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/slack/install":
+		h.install(w, r)
+	case "/slack/oauth_redirect":
+		h.callback(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h slackInstallHandler) install(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+	h.purgeStates()
+	h.states.Store(state, time.Now().Add(slackInstallStateTTL))
+	q := url.Values{
+		"client_id":    {h.clientID},
+		"scope":        {strings.Join(slackBotScopes, ",")},
+		"redirect_uri": {h.redirectURL},
+		"state":        {state},
+	}
+	http.Redirect(w, r, "https://slack.com/oauth/v2/authorize?"+q.Encode(), http.StatusFound)
+}
+
+// purgeStates deletes the states of the install links expired without being used, so that they don't pile up.
+func (h slackInstallHandler) purgeStates() {
+	now := time.Now()
+	h.states.Range(func(state, expiry interface{}) bool {
+		if now.After(expiry.(time.Time)) {
+			h.states.Delete(state)
+		}
+		return true
+	})
+}
+
+func (h slackInstallHandler) callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	expiry, ok := h.states.LoadAndDelete(q.Get("state"))
+	if !ok || time.Now().After(expiry.(time.Time)) {
+		http.Error(w, "The install link is invalid or expired. Start over from Add to Slack.", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		http.Error(w, fmt.Sprintf("The install was canceled: %s", e), http.StatusBadRequest)
+		return
+	}
+	resp, err := h.exchange(q.Get("code"))
+	if err != nil {
+		log.Printf("[ERROR] Failed to exchange the OAuth code: %s", err)
+		http.Error(w, "Failed to install gocat. Try again later.", http.StatusInternalServerError)
+		return
+	}
+	inst := SlackInstallation{TeamID: resp.Team.ID, TeamName: resp.Team.Name, Token: resp.AccessToken}
+	if !h.store.Allows(inst.TeamID) {
+		log.Printf("[WARNING] Refused the install to %s (%s), which isn't allowed", inst.TeamName, inst.TeamID)
+		if err := h.revoke(inst.Token); err != nil {
+			log.Printf("[ERROR] Failed to revoke the token of the refused install to %s: %s", inst.TeamID, err)
+		}
+		http.Error(w, "gocat can't be installed to this workspace.", http.StatusForbidden)
+		return
+	}
+	if err := h.store.Save(r.Context(), inst); err != nil {
+		log.Printf("[ERROR] Failed to save the installation of %s: %s", inst.TeamID, err)
+		http.Error(w, "Failed to install gocat. Try again later.", http.StatusInternalServerError)
+		return
+	}
+	ws := h.newWorkspace(inst.TeamID, inst.Token)
+	ws.TeamID = inst.TeamID
+	h.workspaces.Add(ws)
+	if err := h.workspaces.ResolveTeamIDs(); err != nil {
+		log.Printf("[ERROR] Failed to resolve the team IDs: %s", err)
+	}
+	log.Printf("[INFO] gocat is installed to %s (%s)", inst.TeamName, inst.TeamID)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<p>gocat is installed to %s. Qualify the users of this workspace like <code>%s/alice</code> in rolebindings.</p>", html.EscapeString(inst.TeamName), html.EscapeString(inst.TeamID))
+}
+
+// LoadSlackInstallations adds the workspaces installed by the Add to Slack button so far.
+func LoadSlackInstallations(ctx context.Context, store *SlackInstallationStore, workspaces *SlackWorkspaces, newWorkspace func(name, token string) *SlackWorkspace) error {
+	installations, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, inst := range installations {
+		ws := newWorkspace(inst.TeamID, inst.Token)
+		ws.TeamID = inst.TeamID
+		workspaces.Add(ws)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testTokenEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func TestTokenCipher(t *testing.T) {
+	c, err := NewTokenCipher(testTokenEncryptionKey)
+	require.NoError(t, err)
+	encrypted, err := c.Encrypt("xoxb-secret", "T1")
+	require.NoError(t, err)
+	require.NotContains(t, encrypted, "xoxb")
+	decrypted, err := c.Decrypt(encrypted, "T1")
+	require.NoError(t, err)
+	require.Equal(t, "xoxb-secret", decrypted)
+	// The token is bound to the team
+	_, err = c.Decrypt(encrypted, "T2")
+	require.Error(t, err)
+
+	other, err := NewTokenCipher("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	require.NoError(t, err)
+	_, err = other.Decrypt(encrypted, "T1")
+	require.Error(t, err)
+
+	_, err = NewTokenCipher("c2hvcnQ=")
+	require.Error(t, err)
+}
+
+func TestSlackInstallHandler(t *testing.T) {
+	c, err := NewTokenCipher(testTokenEncryptionKey)
+	require.NoError(t, err)
+	store := NewSlackInstallationStore("default", c, []string{"T2"})
+	store.clientset = fake.NewSimpleClientset()
+	workspaces := NewSlackWorkspaces(&SlackWorkspace{Name: "default", TeamID: "T1"})
+	newWorkspace := func(name, token string) *SlackWorkspace {
+		return &SlackWorkspace{Name: name, verificationToken: token}
+	}
+	h := newSlackInstallHandler("client-id", "client-secret", "https://gocat.example.com/slack/oauth_redirect", store, workspaces, newWorkspace)
+	h.exchange = func(code string) (*slack.OAuthV2Response, error) {
+		if code == "other-code" {
+			return &slack.OAuthV2Response{AccessToken: "xoxb-other", Team: slack.OAuthV2ResponseTeam{ID: "T3", Name: "Other"}}, nil
+		}
+		require.Equal(t, "the-code", code)
+		return &slack.OAuthV2Response{AccessToken: "xoxb-installed", Team: slack.OAuthV2ResponseTeam{ID: "T2", Name: "Dev"}}, nil
+	}
+	var revoked []string
+	h.revoke = func(token string) error {
+		revoked = append(revoked, token)
+		return nil
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slack/install", nil))
+	require.Equal(t, http.StatusFound, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, "slack.com", location.Host)
+	state := location.Query().Get("state")
+	require.NotEmpty(t, state)
+
+	// Unknown state
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slack/oauth_redirect?code=the-code&state=forged", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slack/oauth_redirect?code=the-code&state="+state, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, strings.Contains(rec.Body.String(), "Dev"))
+	require.Equal(t, "T2", workspaces.ByName("T2").TeamID)
+
+	// The state can't be reused
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slack/oauth_redirect?code=the-code&state="+state, nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	cm, err := store.clientset.CoreV1().ConfigMaps("default").Get(context.Background(), "slack-installation-t2", meta_v1.GetOptions{})
+	require.NoError(t, err)
+	require.NotContains(t, cm.Data["Token"], "xoxb")
+
+	installations, err := store.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []SlackInstallation{{TeamID: "T2", TeamName: "Dev", Token: "xoxb-installed"}}, installations)
+
+	// The installs to the teams not allowed are refused with the tokens revoked
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slack/install", nil))
+	location, err = url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slack/oauth_redirect?code=other-code&state="+location.Query().Get("state"), nil))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Equal(t, []string{"xoxb-other"}, revoked)
+	require.Nil(t, workspaces.ByName("T3"))
+
+	// The token copied to another installation isn't decrypted as the one of its team
+	cm.Data["TeamID"] = "T4"
+	store.allowedTeams = append(store.allowedTeams, "T4")
+	_, err = store.clientset.CoreV1().ConfigMaps("default").Update(context.Background(), cm, meta_v1.UpdateOptions{})
+	require.NoError(t, err)
+	installations, err = store.List(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, installations)
+
+	// The expired states are purged
+	h.states.Store("expired", time.Now().Add(-time.Minute))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slack/install", nil))
+	_, ok := h.states.Load("expired")
+	require.False(t, ok)
+}
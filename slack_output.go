@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	// slackOutputFlushInterval is the minimum interval between the updates of the progress message,
+	// not to hit the rate limit of chat.update.
+	slackOutputFlushInterval = 5 * time.Second
+	// slackOutputTailLines is the number of the last lines of the output shown in the progress message.
+	slackOutputTailLines = 20
+	// slackOutputMaxInline is the maximum size of the output shown in a message.
+	// The full output is attached as a file when it exceeds this,
+	// as Slack truncates the text of a section block longer than 3000 characters.
+	slackOutputMaxInline = 2800
+)
+
+// SlackOutputStream is an io.Writer that streams the output of a long-running deploy tool, like kanvas apply, to Slack.
+//
+// It posts a progress message showing the last lines of the output on the first write,
+// and keeps updating it at most once per slackOutputFlushInterval.
+// Finish replaces the progress message with the final output, attaching the full output as a file if it's too long.
+// Nothing is posted if nothing is written.
+//
+// Slack is called in the background without holding the lock, so that the deploy tool never waits for Slack to write its output.
+type SlackOutputStream struct {
+	client  *slack.Client
+	channel string
+	// threadTS is the ts of the message to post the progress message in the thread of.
+	// The progress message is posted to the channel if empty.
+	threadTS string
+	title    string
+
+	// mu guards buf, ts, lastFlush, and flushing.
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	ts        string
+	lastFlush time.Time
+	// flushing is true while the progress message is being posted or updated, during which the writes don't flush again,
+	// so that the progress message is posted only once.
+	flushing bool
+	// inflight is the flush in the background, which Finish waits for so that the final output isn't overwritten by it.
+	inflight sync.WaitGroup
+}
+
+func NewSlackOutputStream(client *slack.Client, channel, threadTS, title string) *SlackOutputStream {
+	return &SlackOutputStream{client: client, channel: channel, threadTS: threadTS, title: title}
+}
+
+func (s *SlackOutputStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	s.buf.Write(p)
+	due := !s.flushing && time.Since(s.lastFlush) >= slackOutputFlushInterval
+	var out string
+	if due {
+		s.flushing = true
+		s.lastFlush = time.Now()
+		out = tailLines(s.buf.String(), slackOutputTailLines)
+		s.inflight.Add(1)
+	}
+	s.mu.Unlock()
+
+	if due {
+		go func() {
+			defer s.inflight.Done()
+			s.flush(fmt.Sprintf(":hourglass_flowing_sand: %s is running...", s.title), out)
+			s.mu.Lock()
+			s.flushing = false
+			s.mu.Unlock()
+		}()
+	}
+	// Never fail the deploy tool because of Slack
+	return len(p), nil
+}
+
+// Finish updates the progress message with the final output of the deploy tool, which exited with err.
+func (s *SlackOutputStream) Finish(err error) {
+	s.inflight.Wait()
+	s.mu.Lock()
+	out := s.buf.String()
+	s.mu.Unlock()
+
+	if out == "" {
+		return
+	}
+
+	header := fmt.Sprintf(":white_check_mark: %s finished", s.title)
+	if err != nil {
+		header = fmt.Sprintf(":x: %s failed", s.title)
+	}
+
+	if len(out) <= slackOutputMaxInline {
+		s.flush(header, out)
+		return
+	}
+
+	ts := s.flush(header+". See the attached file for the full output", tailLines(out, slackOutputTailLines))
+	threadTS := s.threadTS
+	if threadTS == "" {
+		threadTS = ts
+	}
+	if _, err := s.client.UploadFile(slack.FileUploadParameters{
+		Content:         out,
+		Filename:        "output.log",
+		Title:           s.title,
+		Channels:        []string{s.channel},
+		ThreadTimestamp: threadTS,
+	}); err != nil {
+		log.Printf("[ERROR] Failed to upload the output of %s: %s", s.title, err)
+	}
+}
+
+// flush posts or updates the progress message, and returns its ts. The caller must not hold mu,
+// and must not flush concurrently, which the flushing flag and Finish waiting for inflight ensure.
+func (s *SlackOutputStream) flush(header, out string) string {
+	text := fmt.Sprintf("%s\n```%s```", header, strings.TrimSpace(tailBytes(out, slackOutputMaxInline)))
+	opts := []slack.MsgOption{slack.MsgOptionText(text, false)}
+
+	s.mu.Lock()
+	ts := s.ts
+	s.mu.Unlock()
+	if ts != "" {
+		if _, _, _, err := s.client.UpdateMessage(s.channel, ts, opts...); err != nil {
+			log.Printf("[ERROR] Failed to update the progress message of %s: %s", s.title, err)
+		}
+		return ts
+	}
+	if s.threadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(s.threadTS))
+	}
+	_, ts, err := s.client.PostMessage(s.channel, opts...)
+	if err != nil {
+		log.Printf("[ERROR] Failed to post the progress message of %s: %s", s.title, err)
+		return ""
+	}
+	s.mu.Lock()
+	s.ts = ts
+	s.mu.Unlock()
+	return ts
+}
+
+// tailBytes returns the last n bytes of s at most, without cutting a UTF-8 character in the middle.
+func tailBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	start := len(s) - n
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return s[start:]
+}
+
+// tailLines returns the last n lines of s.
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailLines(t *testing.T) {
+	require.Equal(t, "c\nd", tailLines("a\nb\nc\nd\n", 2))
+	require.Equal(t, "a\nb", tailLines("a\nb", 5))
+}
+
+func TestTailBytes(t *testing.T) {
+	require.Equal(t, "abc", tailBytes("abc", 5))
+	require.Equal(t, "bc", tailBytes("abc", 2))
+	// The character cut in the middle is dropped, as "デ" is 3 bytes
+	tail := tailBytes("デプロイ", 7)
+	require.True(t, utf8.ValidString(tail))
+	require.Equal(t, "ロイ", tail)
+}
+
+func TestSlackOutputStream(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	// Slack blocks until it's released, which the writes must not wait for
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		require.NoError(t, r.ParseForm())
+		mu.Lock()
+		requests = append(requests, r.URL.Path+" "+r.Form.Get("text"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok": true, "channel": "C1", "ts": "1.2"}`))
+	}))
+	defer server.Close()
+
+	s := NewSlackOutputStream(slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/")), "C1", "", "kanvas apply")
+	written := make(chan struct{})
+	go func() {
+		_, _ = s.Write([]byte("applying\n"))
+		_, _ = s.Write([]byte(strings.Repeat("デプロイ中\n", 100)))
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the writes waited for Slack")
+	}
+
+	close(release)
+	s.Finish(nil)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, requests, 2)
+	require.True(t, strings.HasPrefix(requests[0], "/chat.postMessage :hourglass_flowing_sand: kanvas apply is running..."))
+	// The final output updates the progress message posted by the first write
+	require.True(t, strings.HasPrefix(requests[1], "/chat.update :white_check_mark: kanvas apply finished"))
+	require.True(t, utf8.ValidString(requests[1]))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandPatterns(t *testing.T) {
+	require.True(t, helpPattern.MatchString("<@U0BOT> help"))
+	require.True(t, lsPattern.MatchString("<@U0BOT> ls"))
+	require.True(t, reloadPattern.MatchString("<@U0BOT>reload"))
+
+	// The commands containing them aren't taken for them
+	for _, text := range []string{
+		"<@U0BOT> env set myapp production FEATURE_X_ENABLED false",
+		"<@U0BOT> deploy helpdesk staging",
+		"<@U0BOT> env set myapp production LOG_LEVEL reload",
+	} {
+		require.False(t, helpPattern.MatchString(text), text)
+		require.False(t, lsPattern.MatchString(text), text)
+		require.False(t, reloadPattern.MatchString(text), text)
+	}
+}
+
+func TestSlackListener_postQuietly(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		calls = append(calls, strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/")+" "+r.Form.Get("user")))
+		fmt.Fprint(w, `{"ok": true, "channel": "C1", "ts": "1.1", "message_ts": "1.1"}`)
+	}))
+	defer server.Close()
+
+	for _, c := range []struct {
+		ephemeral bool
+		want      string
+	}{
+		{ephemeral: true, want: "chat.postEphemeral U1"},
+		{ephemeral: false, want: "chat.postMessage"},
+	} {
+		s := &SlackListener{
+			client:             slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/")),
+			projectList:        &ProjectList{items: []DeployProject{{ID: "myapp", Alias: "^myapp$", Phases: []DeployPhase{{Name: "staging"}}}}},
+			userList:           &UserList{Items: []User{{SlackUserID: "U1", isDeveloper: true}}},
+			ephemeralResponses: c.ephemeral,
+		}
+		// help, ls, and the errors
+		for _, text := range []string{"<@U0BOT> help", "<@U0BOT> ls", "<@U0BOT> deploy yourapp staging branch"} {
+			calls = nil
+			require.NoError(t, s.handleMessageEvent(&slackevents.AppMentionEvent{User: "U1", Channel: "C1", Text: text}))
+			require.Equal(t, []string{c.want}, calls, text)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package slackcmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var aliasArgPattern = regexp.MustCompile(`\$(\d+)`)
+
+// Aliases are the workspace-specific shortcuts of the commands, from the name of the alias to the command it expands to,
+// like ship to "deploy $1 production".
+//
+// $1, $2, and so on are replaced with the arguments following the alias,
+// and the arguments not referenced are appended to the command, like the reason of the deploy.
+type Aliases map[string]string
+
+// Expand expands the alias the text starts with, after the mentions, if any.
+// The text is returned as is if it doesn't start with an alias.
+func (a Aliases) Expand(text string) (string, error) {
+	fields := strings.Fields(text)
+	i := 0
+	for i < len(fields) && strings.HasPrefix(fields[i], "<@") {
+		i++
+	}
+	if i == len(fields) {
+		return text, nil
+	}
+	name := fields[i]
+	expansion, ok := a[name]
+	if !ok {
+		return text, nil
+	}
+
+	args := fields[i+1:]
+	used := make([]bool, len(args))
+	var missing int
+	expanded := aliasArgPattern.ReplaceAllStringFunc(expansion, func(m string) string {
+		n, _ := strconv.Atoi(m[1:])
+		if n < 1 || n > len(args) {
+			if n > missing {
+				missing = n
+			}
+			return m
+		}
+		used[n-1] = true
+		return args[n-1]
+	})
+	if missing > 0 {
+		return "", fmt.Errorf("invalid command %q: %s expects %d argument(s) for %q", text, name, missing, expansion)
+	}
+	rest := append([]string{}, fields[:i]...)
+	rest = append(rest, expanded)
+	for j, arg := range args {
+		if !used[j] {
+			rest = append(rest, arg)
+		}
+	}
+	return strings.Join(rest, " "), nil
+}
@@ -0,0 +1,30 @@
+package slackcmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAliasesExpand(t *testing.T) {
+	aliases := Aliases{
+		"ship": "deploy $1 production",
+		"qa":   "deploy $1 staging branch",
+	}
+
+	for text, want := range map[string]string{
+		"<@U0BOT> ship myapp":           "<@U0BOT> deploy myapp production",
+		"ship myapp for JIRA-123":       "deploy myapp production for JIRA-123",
+		"<@U0BOT> qa myapp":             "<@U0BOT> deploy myapp staging branch",
+		"<@U0BOT> deploy myapp staging": "<@U0BOT> deploy myapp staging",
+		"<@U0BOT> status ship":          "<@U0BOT> status ship",
+		"<@U0BOT>":                      "<@U0BOT>",
+	} {
+		got, err := aliases.Expand(text)
+		require.NoError(t, err)
+		require.Equal(t, want, got, text)
+	}
+
+	_, err := aliases.Expand("<@U0BOT> ship")
+	require.EqualError(t, err, `invalid command "<@U0BOT> ship": ship expects 1 argument(s) for "deploy $1 production"`)
+}
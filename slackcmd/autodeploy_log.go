@@ -0,0 +1,20 @@
+package slackcmd
+
+type AutoDeployLog struct {
+	Project string
+	Env     string
+}
+
+func (a *AutoDeployLog) Name() string {
+	return "AutoDeployLog"
+}
+
+// SimulateAutoDeploy runs the checks of AutoDeploy against the phase once, and shows what it would do without deploying.
+type SimulateAutoDeploy struct {
+	Project string
+	Env     string
+}
+
+func (s *SimulateAutoDeploy) Name() string {
+	return "SimulateAutoDeploy"
+}
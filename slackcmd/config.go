@@ -0,0 +1,19 @@
+package slackcmd
+
+// ConfigExport exports the projects and the users as YAML.
+type ConfigExport struct{}
+
+func (c *ConfigExport) Name() string {
+	return "ConfigExport"
+}
+
+// ConfigImport shows the diff the YAML at Path in the manifest repository makes to the config,
+// and applies it if Apply is true.
+type ConfigImport struct {
+	Path  string
+	Apply bool
+}
+
+func (c *ConfigImport) Name() string {
+	return "ConfigImport"
+}
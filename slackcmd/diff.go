@@ -0,0 +1,12 @@
+package slackcmd
+
+// Diff compares the tags deployed to the two environments of the project.
+type Diff struct {
+	Project string
+	From    string
+	To      string
+}
+
+func (d *Diff) Name() string {
+	return "Diff"
+}
@@ -0,0 +1,17 @@
+package slackcmd
+
+// EmergencyStop stops all the deployments, including AutoDeploy, until EmergencyResume.
+type EmergencyStop struct {
+	Reason string
+}
+
+func (e *EmergencyStop) Name() string {
+	return "EmergencyStop"
+}
+
+// EmergencyResume resumes the deployments stopped by EmergencyStop.
+type EmergencyResume struct{}
+
+func (e *EmergencyResume) Name() string {
+	return "EmergencyResume"
+}
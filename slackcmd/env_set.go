@@ -0,0 +1,13 @@
+package slackcmd
+
+// EnvSet opens the pull request setting the environment value of the project and the environment.
+type EnvSet struct {
+	Project string
+	Env     string
+	Key     string
+	Value   string
+}
+
+func (e *EnvSet) Name() string {
+	return "EnvSet"
+}
@@ -0,0 +1,11 @@
+package slackcmd
+
+// Explain describes what a deploy of the project to the environment would touch, without deploying.
+type Explain struct {
+	Project string
+	Env     string
+}
+
+func (e *Explain) Name() string {
+	return "Explain"
+}
@@ -0,0 +1,10 @@
+package slackcmd
+
+// Features shows the feature flags and the projects they're enabled for, or the ones of the Project only if it's not empty.
+type Features struct {
+	Project string
+}
+
+func (f *Features) Name() string {
+	return "Features"
+}
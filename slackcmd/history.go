@@ -0,0 +1,11 @@
+package slackcmd
+
+// History lists the latest deploys of the project to the environment.
+type History struct {
+	Project string
+	Env     string
+}
+
+func (h *History) Name() string {
+	return "History"
+}
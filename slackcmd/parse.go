@@ -2,13 +2,183 @@ package slackcmd
 
 import (
 	"fmt"
+	"html"
 	"regexp"
 	"strings"
 )
 
 var lockUnlockPattern = regexp.MustCompile(`(unlock|lock) ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\s*(.*)`)
 
+var pinPattern = regexp.MustCompile(`\bpin ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd) (\S+)\s*(.*)`)
+
+var unpinPattern = regexp.MustCompile(`\bunpin ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\s*$`)
+
+var simulateAutoDeployPattern = regexp.MustCompile(`\bsimulate autodeploy ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\s*$`)
+
+var autoDeployLogPattern = regexp.MustCompile(`\bautodeploy log ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\s*$`)
+
+var emergencyStopPattern = regexp.MustCompile(`\bemergency-stop(?:\s+(.*?))?\s*$`)
+
+var emergencyResumePattern = regexp.MustCompile(`\bemergency-resume\s*$`)
+
+var rateLimitPattern = regexp.MustCompile(`\bratelimit\s*$`)
+
+var queuePattern = regexp.MustCompile(`\bqueue\s*$`)
+
+var projectAddPattern = regexp.MustCompile(`\bproject add\s*$`)
+
+var configExportPattern = regexp.MustCompile(`\bconfig export\s*$`)
+
+var featuresPattern = regexp.MustCompile(`\bfeatures(?: ([0-9a-zA-Z-]+))?\s*$`)
+
+var configImportPattern = regexp.MustCompile(`\bconfig (diff|apply) (\S+)\s*$`)
+
+var runJobPattern = regexp.MustCompile(`\brun ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd) job ([0-9a-zA-Z_-]+)(?: (\S+))?\s*$`)
+
+var switchPattern = regexp.MustCompile(`\bswitch ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\s*$`)
+
+var rotateSecretPattern = regexp.MustCompile(`\brotate-secret ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd) ([0-9a-zA-Z.-]+)\s*$`)
+
+var envSetPattern = regexp.MustCompile(`\benv set ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd) ([A-Za-z_][0-9A-Za-z_.-]*)=(.*?)\s*$`)
+
+// slackLinkPattern matches the value Slack turned into a link, like <https://example.com> or <mailto:a@example.com|a@example.com>.
+var slackLinkPattern = regexp.MustCompile(`^<([^|>]+)(?:\|([^>]*))?>$`)
+
+var explainPattern = regexp.MustCompile(`\bexplain ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\s*$`)
+
+var tracePattern = regexp.MustCompile(`\btrace ([0-9a-f]{8})\s*$`)
+
+var rollbackPattern = regexp.MustCompile(`\brollback ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\s*$`)
+
+var redeployPattern = regexp.MustCompile(`\bredeploy ([0-9a-f]{8})\s*$`)
+
+var retryPattern = regexp.MustCompile(`\bretry ([0-9a-f]{8})\s*$`)
+
+var prefsPattern = regexp.MustCompile(`\bprefs(?: (set|unset) ([0-9a-z-]+)(?: (\S+))?)?\s*$`)
+
+var historyPattern = regexp.MustCompile(`\bhistory ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\s*$`)
+
+var diffPattern = regexp.MustCompile(`\bdiff ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd) (staging|production|sandbox|stg|pro|prd)\s*$`)
+
+var statusPattern = regexp.MustCompile(`\bstatus ([0-9a-zA-Z-]+)(?: (staging|production|sandbox|stg|pro|prd))?\s*$`)
+
 func Parse(text string) (Command, error) {
+	if cmd, err := parseEmergency(text); cmd != nil || err != nil {
+		return cmd, err
+	}
+
+	if cmd, err := parsePinUnpinStatus(text); cmd != nil || err != nil {
+		return cmd, err
+	}
+
+	if rateLimitPattern.MatchString(text) {
+		return &RateLimit{}, nil
+	}
+
+	if queuePattern.MatchString(text) {
+		return &Queue{}, nil
+	}
+
+	if projectAddPattern.MatchString(text) {
+		return &ProjectAdd{}, nil
+	}
+
+	if match := runJobPattern.FindStringSubmatch(text); match != nil {
+		return &RunJob{
+			Project: match[1],
+			Env:     match[2],
+			Job:     match[3],
+			Tag:     match[4],
+		}, nil
+	}
+
+	if match := switchPattern.FindStringSubmatch(text); match != nil {
+		return &Switch{
+			Project: match[1],
+			Env:     match[2],
+		}, nil
+	}
+
+	if match := rotateSecretPattern.FindStringSubmatch(text); match != nil {
+		return &RotateSecret{
+			Project: match[1],
+			Env:     match[2],
+			Secret:  match[3],
+		}, nil
+	}
+
+	if match := envSetPattern.FindStringSubmatch(text); match != nil {
+		return &EnvSet{
+			Project: match[1],
+			Env:     match[2],
+			Key:     match[3],
+			Value:   plainValue(match[4]),
+		}, nil
+	}
+
+	if match := explainPattern.FindStringSubmatch(text); match != nil {
+		return &Explain{
+			Project: match[1],
+			Env:     match[2],
+		}, nil
+	}
+
+	if match := tracePattern.FindStringSubmatch(text); match != nil {
+		return &Trace{ID: match[1]}, nil
+	}
+
+	if match := historyPattern.FindStringSubmatch(text); match != nil {
+		return &History{
+			Project: match[1],
+			Env:     match[2],
+		}, nil
+	}
+
+	if match := diffPattern.FindStringSubmatch(text); match != nil {
+		return &Diff{
+			Project: match[1],
+			From:    match[2],
+			To:      match[3],
+		}, nil
+	}
+
+	if match := rollbackPattern.FindStringSubmatch(text); match != nil {
+		return &Rollback{
+			Project: match[1],
+			Env:     match[2],
+		}, nil
+	}
+
+	if match := redeployPattern.FindStringSubmatch(text); match != nil {
+		return &Redeploy{ID: match[1]}, nil
+	}
+
+	if match := retryPattern.FindStringSubmatch(text); match != nil {
+		return &Retry{ID: match[1]}, nil
+	}
+
+	if match := prefsPattern.FindStringSubmatch(text); match != nil {
+		if (match[1] == "set") != (match[3] != "") {
+			return nil, fmt.Errorf("invalid command %q: valid pattern is 'prefs [set <key> <value>|unset <key>]'", text)
+		}
+		return &Prefs{Key: match[2], Value: match[3]}, nil
+	}
+
+	if match := featuresPattern.FindStringSubmatch(text); match != nil {
+		return &Features{Project: match[1]}, nil
+	}
+
+	if configExportPattern.MatchString(text) {
+		return &ConfigExport{}, nil
+	}
+
+	if match := configImportPattern.FindStringSubmatch(text); match != nil {
+		return &ConfigImport{
+			Path:  match[2],
+			Apply: match[1] == "apply",
+		}, nil
+	}
+
 	match := findLockUnlock(text)
 	if match == nil {
 		return nil, fmt.Errorf("invalid command %q: valid pattern is 'lock|unlock <project> <env> [for <reason>]", text)
@@ -55,3 +225,90 @@ func Parse(text string) (Command, error) {
 func findLockUnlock(text string) [][]string {
 	return lockUnlockPattern.FindAllStringSubmatch(text, -1)
 }
+
+// parsePinUnpinStatus parses the pin, unpin, status, and autodeploy log commands.
+// It returns nil without an error if the text is none of them.
+func parsePinUnpinStatus(text string) (Command, error) {
+	if match := pinPattern.FindStringSubmatch(text); match != nil {
+		reason := match[4]
+		if reason != "" {
+			if !strings.HasPrefix(reason, "for ") {
+				return nil, fmt.Errorf("invalid command %q: reason must start with 'for'", text)
+			}
+			reason = strings.TrimPrefix(reason, "for ")
+		}
+
+		return &Pin{
+			Project: match[1],
+			Env:     match[2],
+			Tag:     match[3],
+			Reason:  reason,
+		}, nil
+	}
+
+	if match := unpinPattern.FindStringSubmatch(text); match != nil {
+		return &Unpin{
+			Project: match[1],
+			Env:     match[2],
+		}, nil
+	}
+
+	if match := simulateAutoDeployPattern.FindStringSubmatch(text); match != nil {
+		return &SimulateAutoDeploy{
+			Project: match[1],
+			Env:     match[2],
+		}, nil
+	}
+
+	if match := autoDeployLogPattern.FindStringSubmatch(text); match != nil {
+		return &AutoDeployLog{
+			Project: match[1],
+			Env:     match[2],
+		}, nil
+	}
+
+	if match := statusPattern.FindStringSubmatch(text); match != nil {
+		return &Status{
+			Project: match[1],
+			Env:     match[2],
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// parseEmergency parses the emergency-stop and emergency-resume commands.
+// It returns nil without an error if the text is neither of them.
+func parseEmergency(text string) (Command, error) {
+	if match := emergencyStopPattern.FindStringSubmatch(text); match != nil {
+		reason := match[1]
+		if reason != "" {
+			if !strings.HasPrefix(reason, "for ") {
+				return nil, fmt.Errorf("invalid command %q: reason must start with 'for'", text)
+			}
+			reason = strings.TrimPrefix(reason, "for ")
+		}
+
+		return &EmergencyStop{
+			Reason: reason,
+		}, nil
+	}
+
+	if emergencyResumePattern.MatchString(text) {
+		return &EmergencyResume{}, nil
+	}
+
+	return nil, nil
+}
+
+// plainValue returns the value as typed, undoing the escapes and the links Slack adds to the message.
+func plainValue(s string) string {
+	if match := slackLinkPattern.FindStringSubmatch(s); match != nil {
+		if match[2] != "" {
+			s = match[2]
+		} else {
+			s = match[1]
+		}
+	}
+	return html.UnescapeString(s)
+}
@@ -82,6 +82,234 @@ func TestParse(t *testing.T) {
 		err:  fmt.Errorf("invalid command %q: valid pattern is 'lock|unlock <project> <env> [for <reason>]", "unknown myproject1 production for deployment of revision a"),
 	})
 
+	tests = append(tests, test{
+		name: "pin",
+		text: "pin myproject1 production v1.2.3",
+		want: &Pin{Project: "myproject1", Env: "production", Tag: "v1.2.3"},
+	})
+
+	tests = append(tests, test{
+		name: "pin with reason",
+		text: "pin myproject1 prd abcdef0 for incident investigation",
+		want: &Pin{Project: "myproject1", Env: "prd", Tag: "abcdef0", Reason: "incident investigation"},
+	})
+
+	tests = append(tests, test{
+		name: "pin with invalid reason",
+		text: "pin myproject1 prd abcdef0 because of incident",
+		err:  fmt.Errorf("invalid command %q: reason must start with 'for'", "pin myproject1 prd abcdef0 because of incident"),
+	})
+
+	tests = append(tests, test{
+		name: "unpin",
+		text: "unpin myproject1 production",
+		want: &Unpin{Project: "myproject1", Env: "production"},
+	})
+
+	tests = append(tests, test{
+		name: "status of all envs",
+		text: "status myproject1",
+		want: &Status{Project: "myproject1"},
+	})
+
+	tests = append(tests, test{
+		name: "status of env",
+		text: "status myproject1 stg",
+		want: &Status{Project: "myproject1", Env: "stg"},
+	})
+
+	tests = append(tests, test{
+		name: "simulate autodeploy",
+		text: "simulate autodeploy myproject1 prd",
+		want: &SimulateAutoDeploy{Project: "myproject1", Env: "prd"},
+	})
+
+	tests = append(tests, test{
+		name: "autodeploy log",
+		text: "autodeploy log myproject1 production",
+		want: &AutoDeployLog{Project: "myproject1", Env: "production"},
+	})
+
+	tests = append(tests, test{
+		name: "emergency-stop",
+		text: "emergency-stop",
+		want: &EmergencyStop{},
+	})
+
+	tests = append(tests, test{
+		name: "emergency-stop with reason",
+		text: "emergency-stop for database outage",
+		want: &EmergencyStop{Reason: "database outage"},
+	})
+
+	tests = append(tests, test{
+		name: "emergency-stop with invalid reason",
+		text: "emergency-stop database outage",
+		err:  fmt.Errorf("invalid command %q: reason must start with 'for'", "emergency-stop database outage"),
+	})
+
+	tests = append(tests, test{
+		name: "emergency-resume",
+		text: "emergency-resume",
+		want: &EmergencyResume{},
+	})
+
+	tests = append(tests, test{
+		name: "ratelimit",
+		text: "ratelimit",
+		want: &RateLimit{},
+	})
+
+	tests = append(tests, test{
+		name: "queue",
+		text: "queue",
+		want: &Queue{},
+	})
+
+	tests = append(tests, test{
+		name: "project add",
+		text: "project add",
+		want: &ProjectAdd{},
+	})
+
+	tests = append(tests, test{
+		name: "run job",
+		text: "run myapp production job migrate",
+		want: &RunJob{Project: "myapp", Env: "production", Job: "migrate"},
+	})
+
+	tests = append(tests, test{
+		name: "run job with tag",
+		text: "run myapp stg job db_migrate v1.2.3",
+		want: &RunJob{Project: "myapp", Env: "stg", Job: "db_migrate", Tag: "v1.2.3"},
+	})
+
+	tests = append(tests, test{
+		name: "switch",
+		text: "switch myapp prd",
+		want: &Switch{Project: "myapp", Env: "prd"},
+	})
+
+	tests = append(tests, test{
+		name: "rotate secret",
+		text: "rotate-secret myapp production db-password",
+		want: &RotateSecret{Project: "myapp", Env: "production", Secret: "db-password"},
+	})
+
+	tests = append(tests, test{
+		name: "env set",
+		text: "env set myapp production FEATURE_X=enabled",
+		want: &EnvSet{Project: "myapp", Env: "production", Key: "FEATURE_X", Value: "enabled"},
+	})
+
+	tests = append(tests, test{
+		name: "env set with the value Slack escaped",
+		text: "env set myapp stg API_URL=<https://api.example.com/v1?a=1&amp;b=2>",
+		want: &EnvSet{Project: "myapp", Env: "stg", Key: "API_URL", Value: "https://api.example.com/v1?a=1&b=2"},
+	})
+
+	tests = append(tests, test{
+		name: "env set with an empty value",
+		text: "env set myapp stg FEATURE_X=",
+		want: &EnvSet{Project: "myapp", Env: "stg", Key: "FEATURE_X", Value: ""},
+	})
+
+	tests = append(tests, test{
+		name: "explain",
+		text: "explain myapp stg",
+		want: &Explain{Project: "myapp", Env: "stg"},
+	})
+
+	tests = append(tests, test{
+		name: "trace",
+		text: "trace 1a2b3c4d",
+		want: &Trace{ID: "1a2b3c4d"},
+	})
+
+	tests = append(tests, test{
+		name: "history",
+		text: "history myapp production",
+		want: &History{Project: "myapp", Env: "production"},
+	})
+
+	tests = append(tests, test{
+		name: "diff",
+		text: "diff myapp stg prd",
+		want: &Diff{Project: "myapp", From: "stg", To: "prd"},
+	})
+
+	tests = append(tests, test{
+		name: "rollback",
+		text: "rollback myapp prd",
+		want: &Rollback{Project: "myapp", Env: "prd"},
+	})
+
+	tests = append(tests, test{
+		name: "redeploy",
+		text: "redeploy 1a2b3c4d",
+		want: &Redeploy{ID: "1a2b3c4d"},
+	})
+
+	tests = append(tests, test{
+		name: "retry",
+		text: "retry 1a2b3c4d",
+		want: &Retry{ID: "1a2b3c4d"},
+	})
+
+	tests = append(tests, test{
+		name: "features",
+		text: "features",
+		want: &Features{},
+	})
+
+	tests = append(tests, test{
+		name: "features of project",
+		text: "features myapp",
+		want: &Features{Project: "myapp"},
+	})
+
+	tests = append(tests, test{
+		name: "prefs",
+		text: "prefs",
+		want: &Prefs{},
+	})
+
+	tests = append(tests, test{
+		name: "prefs set",
+		text: "prefs set notify dm",
+		want: &Prefs{Key: "notify", Value: "dm"},
+	})
+
+	tests = append(tests, test{
+		name: "prefs unset",
+		text: "prefs unset default-phase",
+		want: &Prefs{Key: "default-phase"},
+	})
+
+	tests = append(tests, test{
+		name: "prefs set without value",
+		text: "prefs set notify",
+		err:  fmt.Errorf("invalid command %q: valid pattern is 'prefs [set <key> <value>|unset <key>]'", "prefs set notify"),
+	})
+
+	tests = append(tests, test{
+		name: "config export",
+		text: "config export",
+		want: &ConfigExport{},
+	})
+
+	tests = append(tests, test{
+		name: "config diff",
+		text: "config diff gocat/config.yaml",
+		want: &ConfigImport{Path: "gocat/config.yaml"},
+	})
+
+	tests = append(tests, test{
+		name: "config apply",
+		text: "config apply gocat/config.yaml",
+		want: &ConfigImport{Path: "gocat/config.yaml", Apply: true},
+	})
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got, err := Parse(tt.text)
@@ -0,0 +1,12 @@
+package slackcmd
+
+type Pin struct {
+	Project string
+	Env     string
+	Tag     string
+	Reason  string
+}
+
+func (p *Pin) Name() string {
+	return "Pin"
+}
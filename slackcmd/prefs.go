@@ -0,0 +1,12 @@
+package slackcmd
+
+// Prefs shows the preferences of the user, or sets the one of the key to the value if the key is given.
+// An empty value resets it to the default.
+type Prefs struct {
+	Key   string
+	Value string
+}
+
+func (p *Prefs) Name() string {
+	return "Prefs"
+}
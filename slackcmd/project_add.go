@@ -0,0 +1,8 @@
+package slackcmd
+
+// ProjectAdd starts the onboarding of a new project in a Slack modal.
+type ProjectAdd struct{}
+
+func (p *ProjectAdd) Name() string {
+	return "ProjectAdd"
+}
@@ -0,0 +1,8 @@
+package slackcmd
+
+// Queue shows the deploys in flight across all the projects, with the buttons to cancel the ones awaiting approval.
+type Queue struct{}
+
+func (q *Queue) Name() string {
+	return "Queue"
+}
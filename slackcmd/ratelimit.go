@@ -0,0 +1,8 @@
+package slackcmd
+
+// RateLimit shows the GitHub rate limits gocat observed.
+type RateLimit struct{}
+
+func (r *RateLimit) Name() string {
+	return "RateLimit"
+}
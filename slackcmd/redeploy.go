@@ -0,0 +1,10 @@
+package slackcmd
+
+// Redeploy replays the archived deploy with the trace ID.
+type Redeploy struct {
+	ID string
+}
+
+func (r *Redeploy) Name() string {
+	return "Redeploy"
+}
@@ -0,0 +1,10 @@
+package slackcmd
+
+// Retry runs the failed stage of the deploy with the trace ID again, like opening or merging its pull request.
+type Retry struct {
+	ID string
+}
+
+func (r *Retry) Name() string {
+	return "Retry"
+}
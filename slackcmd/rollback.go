@@ -0,0 +1,11 @@
+package slackcmd
+
+// Rollback posts the button that opens the modal to roll back the images of the project and the environment.
+type Rollback struct {
+	Project string
+	Env     string
+}
+
+func (r *Rollback) Name() string {
+	return "Rollback"
+}
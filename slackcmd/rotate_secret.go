@@ -0,0 +1,12 @@
+package slackcmd
+
+// RotateSecret opens the pull request rotating the secret of the project and the environment.
+type RotateSecret struct {
+	Project string
+	Env     string
+	Secret  string
+}
+
+func (r *RotateSecret) Name() string {
+	return "RotateSecret"
+}
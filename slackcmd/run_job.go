@@ -0,0 +1,14 @@
+package slackcmd
+
+// RunJob runs the one-off job, like a database migration, of the project and the environment.
+type RunJob struct {
+	Project string
+	Env     string
+	Job     string
+	// Tag is the image tag to run the job with. The tag deployed to the environment is used if empty.
+	Tag string
+}
+
+func (r *RunJob) Name() string {
+	return "RunJob"
+}
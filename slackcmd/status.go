@@ -0,0 +1,11 @@
+package slackcmd
+
+type Status struct {
+	Project string
+	// Env is empty when the status of all the environments of the project is requested.
+	Env string
+}
+
+func (s *Status) Name() string {
+	return "Status"
+}
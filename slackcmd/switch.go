@@ -0,0 +1,11 @@
+package slackcmd
+
+// Switch puts the idle color of the blue/green project and environment into service.
+type Switch struct {
+	Project string
+	Env     string
+}
+
+func (s *Switch) Name() string {
+	return "Switch"
+}
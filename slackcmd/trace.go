@@ -0,0 +1,10 @@
+package slackcmd
+
+// Trace dumps the timeline of the deploy with the trace ID.
+type Trace struct {
+	ID string
+}
+
+func (t *Trace) Name() string {
+	return "Trace"
+}
@@ -0,0 +1,10 @@
+package slackcmd
+
+type Unpin struct {
+	Project string
+	Env     string
+}
+
+func (u *Unpin) Name() string {
+	return "Unpin"
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrErrorBudgetExhausted is returned when the deploy is blocked, or needs the extra approval,
+// as the error budget of the service is exhausted.
+var ErrErrorBudgetExhausted = errors.New("error budget exhausted")
+
+// SLOGate holds the deploys of a phase, typically the production one, while the error budget of the service is exhausted,
+// so that the deploys don't burn the budget further until the service is reliable again.
+//
+// It's checked at DeployGate, so it gates all the deploys, including the ones of the phases with the direct commit strategy,
+// which are blocked unless the requester is one of Approvers.
+type SLOGate struct {
+	// Provider is where Query is run, which is either prometheus or datadog.
+	// See CONFIG_PROMETHEUS_URL and CONFIG_DATADOG_API_KEY.
+	Provider string `yaml:"provider"`
+	// Query returns the remaining error budget of the service as a ratio, 1 being untouched and 0 or less being exhausted,
+	// like a PromQL expression or a Datadog metric query.
+	// It's a Go template rendered with SLOGateVars, like 1 - slo:error_budget_burn:ratio{service="{{.Project}}"}.
+	Query string `yaml:"query"`
+	// MinRemaining is the remaining ratio at or below which the budget is considered exhausted, like 0.1 to stop at 10% left.
+	MinRemaining float64 `yaml:"minRemaining"`
+	// OnExhausted is either block, the default, which blocks the deploys, or approval, which lets one of Approvers approve them.
+	// AutoDeploy skips the phase in either case as it has nobody to approve.
+	OnExhausted string `yaml:"onExhausted"`
+	// Approvers are the Slack user IDs allowed to approve the deploys while the budget is exhausted when OnExhausted is approval.
+	// Anyone but the requester can approve them if empty.
+	Approvers []string `yaml:"approvers"`
+}
+
+func (g SLOGate) Enabled() bool {
+	return g.Query != ""
+}
+
+func (g SLOGate) validate() error {
+	if !g.Enabled() {
+		return nil
+	}
+	switch g.Provider {
+	case "prometheus", "datadog":
+	default:
+		return fmt.Errorf("unknown provider %q. It's either prometheus or datadog", g.Provider)
+	}
+	switch g.OnExhausted {
+	case "", "block", "approval":
+	default:
+		return fmt.Errorf("unknown onExhausted %q. It's either block or approval", g.OnExhausted)
+	}
+	return nil
+}
+
+// requiresApproval returns true if the gate lets the deploys be approved while the budget is exhausted.
+func (g SLOGate) requiresApproval() bool {
+	return g.OnExhausted == "approval"
+}
+
+// canApprove returns true if the approver can approve the deploy requested by the requesters while the budget is exhausted.
+func (g SLOGate) canApprove(requesters []string, approver string) bool {
+	if !g.requiresApproval() {
+		return false
+	}
+	if len(g.Approvers) == 0 {
+		return !containsString(requesters, approver)
+	}
+	for _, a := range g.Approvers {
+		if a == approver {
+			return true
+		}
+	}
+	return false
+}
+
+// approvers describes who can approve the deploys while the budget is exhausted.
+func (g SLOGate) approvers() string {
+	if len(g.Approvers) == 0 {
+		return "someone other than the requester"
+	}
+	var mentions []string
+	for _, a := range g.Approvers {
+		mentions = append(mentions, fmt.Sprintf("<@%s>", a))
+	}
+	return strings.Join(mentions, ", ")
+}
+
+// SLOGateVars is the set of variables available in SLOGate.Query.
+type SLOGateVars struct {
+	Project string
+	Phase   string
+}
+
+func (self SLOGateVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+// ErrorBudgetClient queries the remaining error budgets of the SLO gates.
+type ErrorBudgetClient struct {
+	prometheusURL string
+	// datadogURL is the URL of the API of the Datadog site.
+	datadogURL    string
+	datadogAPIKey string
+	datadogAppKey string
+	httpClient    *http.Client
+	now           func() time.Time
+}
+
+func NewErrorBudgetClient(config CatConfig) ErrorBudgetClient {
+	return ErrorBudgetClient{
+		prometheusURL: strings.TrimSuffix(config.PrometheusURL, "/"),
+		datadogURL:    "https://api." + config.DatadogSite,
+		datadogAPIKey: config.DatadogAPIKey,
+		datadogAppKey: config.DatadogAppKey,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		now:           time.Now,
+	}
+}
+
+// Check returns nil if the error budget of the phase is left, or ErrErrorBudgetExhausted otherwise.
+// The budget that can't be queried is considered exhausted, as the gate can't tell it's safe to deploy.
+func (c ErrorBudgetClient) Check(pj DeployProject, phase DeployPhase) error {
+	gate := phase.SLOGate
+	remaining, err := c.Remaining(pj, phase)
+	if err != nil {
+		return fmt.Errorf("%w: unable to query the error budget of %s %s: %s", ErrErrorBudgetExhausted, pj.ID, phase.Name, err)
+	}
+	if remaining > gate.MinRemaining {
+		return nil
+	}
+	return fmt.Errorf("%w: %.1f%% of the error budget of %s %s is left, which is at or below %.1f%%",
+		ErrErrorBudgetExhausted, remaining*100, pj.ID, phase.Name, gate.MinRemaining*100)
+}
+
+// Remaining returns the remaining error budget of the phase as a ratio.
+func (c ErrorBudgetClient) Remaining(pj DeployProject, phase DeployPhase) (float64, error) {
+	gate := phase.SLOGate
+	query, err := SLOGateVars{Project: pj.ID, Phase: phase.Name}.Parse(gate.Query)
+	if err != nil {
+		return 0, fmt.Errorf("unable to render the query: %w", err)
+	}
+	switch gate.Provider {
+	case "prometheus":
+		return c.queryPrometheus(query)
+	case "datadog":
+		return c.queryDatadog(query)
+	}
+	return 0, fmt.Errorf("unknown provider %q", gate.Provider)
+}
+
+func (c ErrorBudgetClient) get(req *http.Request, v interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c ErrorBudgetClient) queryPrometheus(query string) (float64, error) {
+	if c.prometheusURL == "" {
+		return 0, fmt.Errorf("set CONFIG_PROMETHEUS_URL to query Prometheus")
+	}
+	req, err := http.NewRequest(http.MethodGet, c.prometheusURL+"/api/v1/query?"+url.Values{"query": {query}}.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	var body struct {
+		Data struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := c.get(req, &body); err != nil {
+		return 0, err
+	}
+	// A sample is [<time>, "<value>"], which is the result itself for the scalar results
+	var sample []interface{}
+	switch body.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(body.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(body.Data.Result, &vector); err != nil {
+			return 0, err
+		}
+		if len(vector) == 0 {
+			return 0, fmt.Errorf("the query %s returned no series", query)
+		}
+		sample = vector[0].Value
+	default:
+		return 0, fmt.Errorf("the query %s returned the unsupported %s", query, body.Data.ResultType)
+	}
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("the query %s returned an invalid sample", query)
+	}
+	s, _ := sample[1].(string)
+	return strconv.ParseFloat(s, 64)
+}
+
+func (c ErrorBudgetClient) queryDatadog(query string) (float64, error) {
+	if c.datadogAPIKey == "" || c.datadogAppKey == "" {
+		return 0, fmt.Errorf("set CONFIG_DATADOG_API_KEY and CONFIG_DATADOG_APP_KEY to query Datadog")
+	}
+	now := c.now()
+	params := url.Values{
+		"query": {query},
+		"from":  {strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)},
+		"to":    {strconv.FormatInt(now.Unix(), 10)},
+	}
+	req, err := http.NewRequest(http.MethodGet, c.datadogURL+"/api/v1/query?"+params.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("DD-API-KEY", c.datadogAPIKey)
+	req.Header.Set("DD-APPLICATION-KEY", c.datadogAppKey)
+	var body struct {
+		Series []struct {
+			Pointlist [][]*float64 `json:"pointlist"`
+		} `json:"series"`
+	}
+	if err := c.get(req, &body); err != nil {
+		return 0, err
+	}
+	if len(body.Series) == 0 {
+		return 0, fmt.Errorf("the query %s returned no series", query)
+	}
+	// The latest point of the series, skipping the ones with no value
+	points := body.Series[0].Pointlist
+	for i := len(points) - 1; i >= 0; i-- {
+		if len(points[i]) == 2 && points[i][1] != nil {
+			return *points[i][1], nil
+		}
+	}
+	return 0, fmt.Errorf("the query %s returned no points in the last 10 minutes", query)
+}
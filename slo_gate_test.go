@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorBudgetClient_Check(t *testing.T) {
+	var queries []string
+	remaining := "0.25"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/query":
+			queries = append(queries, r.URL.Query().Get("query"))
+			if r.Header.Get("DD-API-KEY") != "" {
+				w.Write([]byte(`{"series": [{"pointlist": [[1700000000000, 0.5], [1700000060000, ` + remaining + `], [1700000120000, null]]}]}`))
+				return
+			}
+			w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1700000000, "` + remaining + `"]}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	pj := DeployProject{ID: "myapp"}
+	phase := DeployPhase{Name: "production", SLOGate: SLOGate{Provider: "prometheus", Query: `slo:error_budget_remaining:ratio{service="{{.Project}}"}`, MinRemaining: 0.1}}
+	c := ErrorBudgetClient{prometheusURL: server.URL, httpClient: server.Client(), now: time.Now}
+	require.NoError(t, c.Check(pj, phase))
+	require.Equal(t, []string{`slo:error_budget_remaining:ratio{service="myapp"}`}, queries)
+
+	remaining = "0.05"
+	err := c.Check(pj, phase)
+	require.True(t, errors.Is(err, ErrErrorBudgetExhausted))
+	require.Contains(t, err.Error(), "5.0% of the error budget of myapp production is left")
+
+	// Datadog is queried with the keys, and the latest point with a value counts
+	dd := ErrorBudgetClient{datadogURL: server.URL, datadogAPIKey: "api", datadogAppKey: "app", httpClient: server.Client(), now: time.Now}
+	phase.SLOGate = SLOGate{Provider: "datadog", Query: "slo.remaining{service:{{.Project}}}"}
+	remaining = "0.3"
+	v, err := dd.Remaining(pj, phase)
+	require.NoError(t, err)
+	require.Equal(t, 0.3, v)
+	require.Equal(t, "slo.remaining{service:myapp}", queries[len(queries)-1])
+
+	// The budget that can't be queried is exhausted
+	c.prometheusURL = server.URL + "/broken"
+	phase.SLOGate.Provider = "prometheus"
+	phase.SLOGate.MinRemaining = 0
+	require.True(t, errors.Is(c.Check(pj, phase), ErrErrorBudgetExhausted))
+}
+
+func TestSLOGate_canApprove(t *testing.T) {
+	block := SLOGate{Query: "q"}
+	require.False(t, block.canApprove([]string{"U1"}, "U2"))
+
+	anyone := SLOGate{Query: "q", OnExhausted: "approval"}
+	require.True(t, anyone.canApprove([]string{"U1"}, "U2"))
+	require.False(t, anyone.canApprove([]string{"U1"}, "U1"))
+
+	listed := SLOGate{Query: "q", OnExhausted: "approval", Approvers: []string{"USRE"}}
+	require.True(t, listed.canApprove([]string{"U1"}, "USRE"))
+	require.False(t, listed.canApprove([]string{"U1"}, "U2"))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// SOPSClient decrypts the SOPS-encrypted YAML files in the manifest repository, and sets the values in them.
+type SOPSClient interface {
+	Decrypt(encrypted []byte) ([]byte, error)
+	// Set sets the value, encoded in JSON, at the path like ["data"]["KEY"] of the encrypted file.
+	// The keys and the rules in the sops metadata of the file, like key_groups and shamir_threshold, are kept as they are,
+	// and the other values aren't encrypted again.
+	Set(encrypted []byte, path string, value []byte) ([]byte, error)
+}
+
+// isSOPSEncrypted returns true if the file is encrypted by SOPS.
+func isSOPSEncrypted(b []byte) bool {
+	var obj struct {
+		SOPS *struct {
+			MAC string `yaml:"mac"`
+		} `yaml:"sops"`
+	}
+	return yaml.Unmarshal(b, &obj) == nil && obj.SOPS != nil && obj.SOPS.MAC != ""
+}
+
+// sopsChange is a value the OverWrite changed in the decrypted file.
+type sopsChange struct {
+	path  string
+	value []byte
+}
+
+// sopsChanges returns the values changed from before to after, at the deepest paths possible so that only them get encrypted again.
+func sopsChanges(before, after interface{}, path string) ([]sopsChange, error) {
+	switch a := after.(type) {
+	case map[string]interface{}:
+		b, ok := before.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				return nil, fmt.Errorf("unable to remove %s[%q] from the SOPS-encrypted file", path, k)
+			}
+		}
+		keys := make([]string, 0, len(a))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var changes []sopsChange
+		for _, k := range keys {
+			c, err := sopsChanges(b[k], a[k], fmt.Sprintf("%s[%q]", path, k))
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, c...)
+		}
+		return changes, nil
+	case []interface{}:
+		b, ok := before.([]interface{})
+		if !ok || len(a) != len(b) {
+			break
+		}
+		var changes []sopsChange
+		for i := range a {
+			c, err := sopsChanges(b[i], a[i], fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, c...)
+		}
+		return changes, nil
+	}
+	if reflect.DeepEqual(before, after) {
+		return nil, nil
+	}
+	if path == "" {
+		return nil, fmt.Errorf("unable to replace the whole SOPS-encrypted file")
+	}
+	value, err := json.Marshal(after)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode the value of %s: %w", path, err)
+	}
+	return []sopsChange{{path: path, value: value}}, nil
+}
+
+// SOPSOverWrite applies the OverWrite to the decrypted content of a SOPS-encrypted file,
+// and sets only the changed values in the encrypted file, so that the plaintext never gets committed
+// and the sops metadata and the other values stay intact.
+type SOPSOverWrite struct {
+	overWrite OverWrite
+	sops      SOPSClient
+}
+
+func (o SOPSOverWrite) Update(b []byte) (interface{}, error) {
+	plain, err := o.sops.Decrypt(b)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := o.overWrite.Update(plain)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := marshalOverWrite(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after interface{}
+	if err := yamlv3.Unmarshal(plain, &before); err != nil {
+		return nil, fmt.Errorf("unable to parse the decrypted file: %w", err)
+	}
+	if err := yamlv3.Unmarshal(updated, &after); err != nil {
+		return nil, fmt.Errorf("unable to parse the updated file: %w", err)
+	}
+	changes, err := sopsChanges(before, after, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		if b, err = o.sops.Set(b, c.path, c.value); err != nil {
+			return nil, err
+		}
+	}
+	// The encrypted file is committed as sops wrote it
+	return b, nil
+}
+
+// sopsCLI is the SOPSClient that runs the sops command, which needs to be installed in the gocat container image.
+//
+// The keys to decrypt the files are configured as sops itself does,
+// like SOPS_AGE_KEY_FILE for age, and the AWS credentials of gocat for KMS.
+type sopsCLI struct {
+	// Command is the path to the sops command. Defaults to "sops".
+	Command string
+}
+
+func (c sopsCLI) Decrypt(encrypted []byte) ([]byte, error) {
+	f, err := writeSOPSTemp(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f)
+	return c.run("--decrypt", "--input-type", "yaml", "--output-type", "yaml", f)
+}
+
+func (c sopsCLI) Set(encrypted []byte, path string, value []byte) ([]byte, error) {
+	f, err := writeSOPSTemp(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f)
+	// sops set updates the file in place, and tells the format from the extension
+	if _, err := c.run("set", f, path, string(value)); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(f)
+}
+
+// writeSOPSTemp writes the input to the temporary file, as sops reads files only.
+func writeSOPSTemp(input []byte) (string, error) {
+	f, err := os.CreateTemp("", "gocat-sops-*.yaml")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(input); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (c sopsCLI) run(args ...string) ([]byte, error) {
+	bin := c.Command
+	if bin == "" {
+		bin = "sops"
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Env = commandEnv()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("command sops %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSOPS "encrypts" the values by wrapping them in ENC[...] like sops does, without any key.
+type fakeSOPS struct {
+	paths []string
+}
+
+func (f *fakeSOPS) Decrypt(encrypted []byte) ([]byte, error) {
+	var lines []string
+	for _, line := range strings.Split(string(encrypted), "\n") {
+		if strings.HasPrefix(line, "sops:") {
+			break
+		}
+		line = strings.Replace(line, "ENC[", "", 1)
+		lines = append(lines, strings.Replace(line, "]", "", 1))
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func (f *fakeSOPS) Set(encrypted []byte, path string, value []byte) ([]byte, error) {
+	f.paths = append(f.paths, path)
+	m := regexp.MustCompile(`^\["data"\]\["(\w+)"\]$`).FindStringSubmatch(path)
+	if m == nil {
+		return nil, fmt.Errorf("unexpected path %s", path)
+	}
+	line := regexp.MustCompile(`(?m)^  ` + m[1] + `: .*$`)
+	b := line.ReplaceAll(encrypted, []byte("  "+m[1]+": ENC["+strings.Trim(string(value), `"`)+"]"))
+	return []byte(strings.Replace(string(b), "mac: ENC[old]", "mac: ENC[new]", 1)), nil
+}
+
+func TestSOPSOverWrite(t *testing.T) {
+	encrypted := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myapp
+data:
+  MEMCACHED_PREFIX: ENC[2024-01-01T00:00:00]
+  PASSWORD: ENC[secret]
+sops:
+  key_groups:
+  - age:
+    - recipient: age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+  shamir_threshold: 1
+  encrypted_regex: ^(MEMCACHED_PREFIX|PASSWORD)$
+  mac: ENC[old]
+`
+	require.True(t, isSOPSEncrypted([]byte(encrypted)))
+	require.False(t, isSOPSEncrypted([]byte("apiVersion: v1\nkind: ConfigMap\n")))
+
+	sops := &fakeSOPS{}
+	obj, err := SOPSOverWrite{overWrite: MemcachedOverWrite{}, sops: sops}.Update([]byte(encrypted))
+	require.NoError(t, err)
+	require.Equal(t, []string{`["data"]["MEMCACHED_PREFIX"]`}, sops.paths)
+
+	b, err := marshalOverWrite(obj)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "2024-01-01T00:00:00")
+	require.Regexp(t, `(?m)^  MEMCACHED_PREFIX: ENC\[\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\]$`, string(b))
+	require.Contains(t, string(b), "  PASSWORD: ENC[secret]\n")
+	require.Contains(t, string(b), "  shamir_threshold: 1\n")
+	require.Contains(t, string(b), "  mac: ENC[new]\n")
+}
+
+func TestSOPSChanges(t *testing.T) {
+	before := map[string]interface{}{"data": map[string]interface{}{"A": "1", "B": "2"}, "list": []interface{}{"x", "y"}}
+	after := map[string]interface{}{"data": map[string]interface{}{"A": "1", "B": "3", "C": map[string]interface{}{"D": 4}}, "list": []interface{}{"x", "z"}}
+	changes, err := sopsChanges(before, after, "")
+	require.NoError(t, err)
+	require.Equal(t, []sopsChange{
+		{path: `["data"]["B"]`, value: []byte(`"3"`)},
+		{path: `["data"]["C"]`, value: []byte(`{"D":4}`)},
+		{path: `["list"][1]`, value: []byte(`"z"`)},
+	}, changes)
+
+	_, err = sopsChanges(before, map[string]interface{}{"data": map[string]interface{}{"A": "1"}, "list": []interface{}{"x", "y"}}, "")
+	require.Error(t, err)
+}
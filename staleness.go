@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// StalenessOption configures the StalenessWatcher for a phase, typically production.
+type StalenessOption struct {
+	// Source is the name of the phase whose tag is expected to reach this phase, typically staging.
+	Source string `yaml:"source"`
+	// MaxDays is the number of days this phase can lag behind the source phase. 0 means unlimited.
+	MaxDays int `yaml:"maxDays"`
+	// MaxCommits is the number of commits this phase can lag behind the source phase. 0 means unlimited.
+	MaxCommits int `yaml:"maxCommits"`
+}
+
+// StalenessWatcher notifies the notifyChannel of a phase when the phase hasn't received
+// the tag deployed to its source phase for too long, nudging the team to ship.
+//
+// The lag in days is measured from the oldest undeployed commit when the tags are commit SHAs,
+// and from the time the watcher first saw the phases diverge otherwise.
+type StalenessWatcher struct {
+	client      *slack.Client
+	github      *GitHub
+	projectList *ProjectList
+	// divergedSince is the map from "<project>/<phase>" to the time the watcher first saw the phase diverge from its source.
+	divergedSince *sync.Map
+	// notified is the map from "<project>/<phase>" to the source tag last notified, so that each tag is notified only once.
+	notified *sync.Map
+	// now returns the current time, which the lag is measured until.
+	now func() time.Time
+}
+
+func NewStalenessWatcher(client *slack.Client, github *GitHub, projectList *ProjectList) StalenessWatcher {
+	return StalenessWatcher{client, github, projectList, &sync.Map{}, &sync.Map{}, time.Now}
+}
+
+func (w StalenessWatcher) Watch(sec int64) {
+	log.Printf("[INFO] Staleness Watcher is started. Interval is %d seconds.", sec)
+	go func() {
+		// We don't stop the ticker as this is a long-running process
+		// with no way to cancel it.
+		t := time.NewTicker(time.Duration(sec) * time.Second)
+		for range t.C {
+			w.Run()
+		}
+	}()
+}
+
+func (w StalenessWatcher) Run() {
+	for _, pj := range w.projectList.All() {
+		for _, phase := range pj.Phases {
+			if phase.Staleness.Source == "" || phase.NotifyChannel == "" {
+				continue
+			}
+			if err := w.check(pj, phase); err != nil {
+				log.Printf("[ERROR] Failed to check staleness of %s:%s: %s", pj.ID, phase.Name, err)
+			}
+		}
+	}
+}
+
+func (w StalenessWatcher) check(pj DeployProject, phase DeployPhase) error {
+	key := pj.ID + "/" + phase.Name
+	source := pj.FindPhase(phase.Staleness.Source)
+	if source.Name == "" {
+		return fmt.Errorf("source phase %s not found", phase.Staleness.Source)
+	}
+
+	sourceTag, err := source.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: w.github})
+	if err != nil {
+		return err
+	}
+	currentTag, err := phase.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: w.github})
+	if err != nil {
+		return err
+	}
+	if sourceTag == currentTag {
+		w.divergedSince.Delete(key)
+		return nil
+	}
+
+	since, _ := w.divergedSince.LoadOrStore(key, w.now())
+	lagSince := since.(time.Time)
+	commits, err := w.github.CommitsBetween(GitHubCommitsBetweenInput{
+		Repository:    pj.GitHubRepository(),
+		Branch:        pj.DefaultBranch(),
+		FirstCommitID: currentTag,
+		LastCommitID:  sourceTag,
+	})
+	if err != nil {
+		return err
+	}
+	if len(commits) > 0 {
+		// Commits are ordered from the newest to the oldest
+		lagSince = commits[len(commits)-1].CommittedDate
+	}
+	days := int(w.now().Sub(lagSince).Hours() / 24)
+
+	stale := (phase.Staleness.MaxDays > 0 && days >= phase.Staleness.MaxDays) ||
+		(phase.Staleness.MaxCommits > 0 && len(commits) >= phase.Staleness.MaxCommits)
+	if !stale {
+		return nil
+	}
+	if last, ok := w.notified.Load(key); ok && last == sourceTag {
+		return nil
+	}
+	w.notified.Store(key, sourceTag)
+
+	fields := []slack.AttachmentField{
+		{Title: "Project", Value: pj.ID, Short: true},
+		{Title: "Phase", Value: phase.Name, Short: true},
+		{Title: phase.Name, Value: currentTag, Short: true},
+		{Title: source.Name, Value: sourceTag, Short: true},
+		{Title: "Days behind", Value: fmt.Sprint(days), Short: true},
+		{Title: "Commits behind", Value: fmt.Sprint(len(commits)), Short: true},
+	}
+	msg := slack.Attachment{
+		Color:  "#daa038",
+		Title:  fmt.Sprintf(":hourglass: %s %s is behind %s", pj.ID, phase.Name, source.Name),
+		Text:   fmt.Sprintf("Run `deploy %s %s` to ship `%s`.", pj.ID, phase.Name, sourceTag),
+		Fields: fields,
+	}
+	_, _, err = w.client.PostMessage(phase.NotifyChannel, slack.MsgOptionAttachments(msg))
+	return err
+}
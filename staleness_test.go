@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStalenessGitHub serves the kustomizations of the phases deployed with tags, and the history of the default branch.
+type fakeStalenessGitHub struct {
+	// tags are the tags deployed to the phases by the paths of their kustomizations.
+	tags map[string]string
+	// commits are the commits of the default branch from the newest to the oldest.
+	commits []Commit
+}
+
+func (f *fakeStalenessGitHub) RoundTrip(req *http.Request) (*http.Response, error) {
+	respond := func(body string) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+	if path := strings.TrimPrefix(req.URL.Path, "/repos/zaiminc/manifests/contents/"); path != req.URL.Path {
+		return respond(fmt.Sprintf("images:\n- name: myapp\n  newTag: %s\n", f.tags[path]))
+	}
+	type edge struct {
+		Node Commit `json:"node"`
+	}
+	var edges []edge
+	for _, c := range f.commits {
+		edges = append(edges, edge{c})
+	}
+	b, _ := json.Marshal(edges)
+	return respond(fmt.Sprintf(`{"data": {"repository": {"ref": {"target": {"history": {"edges": %s}}}}}}`, b))
+}
+
+func TestStalenessWatcher_check(t *testing.T) {
+	var posts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		posts = append(posts, r.Form.Get("attachments"))
+		fmt.Fprint(w, `{"ok": true, "channel": "C1", "ts": "1.1"}`)
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)
+	commits := []Commit{
+		{Oid: "c3", CommittedDate: now.Add(-24 * time.Hour)},
+		{Oid: "c2", CommittedDate: now.Add(-50 * time.Hour)},
+		{Oid: "c1", CommittedDate: now.Add(-72 * time.Hour)},
+		{Oid: "c0", CommittedDate: now.Add(-96 * time.Hour)},
+	}
+	for _, c := range []struct {
+		name      string
+		staleness StalenessOption
+		// production and staging are the tags deployed to the phases at each check.
+		production, staging []string
+		// elapsed is the time since the first check at each check.
+		elapsed []time.Duration
+		// notified is whether each check notifies the channel.
+		notified []bool
+	}{
+		{
+			name:       "up to date",
+			staleness:  StalenessOption{Source: "staging", MaxCommits: 1},
+			production: []string{"c3"},
+			staging:    []string{"c3"},
+			elapsed:    []time.Duration{0},
+			notified:   []bool{false},
+		},
+		{
+			name:       "under maxCommits",
+			staleness:  StalenessOption{Source: "staging", MaxCommits: 3},
+			production: []string{"c1"},
+			staging:    []string{"c3"},
+			elapsed:    []time.Duration{0},
+			notified:   []bool{false},
+		},
+		{
+			name:       "maxCommits notified once per source tag",
+			staleness:  StalenessOption{Source: "staging", MaxCommits: 1},
+			production: []string{"c1", "c1", "c1"},
+			staging:    []string{"c2", "c2", "c3"},
+			elapsed:    []time.Duration{0, time.Hour, 2 * time.Hour},
+			notified:   []bool{true, false, true},
+		},
+		{
+			// The oldest undeployed commit c2 was committed 50 hours ago
+			name:       "maxDays from the oldest undeployed commit",
+			staleness:  StalenessOption{Source: "staging", MaxDays: 2},
+			production: []string{"c1"},
+			staging:    []string{"c3"},
+			elapsed:    []time.Duration{0},
+			notified:   []bool{true},
+		},
+		{
+			name:       "under maxDays",
+			staleness:  StalenessOption{Source: "staging", MaxDays: 3},
+			production: []string{"c1"},
+			staging:    []string{"c3"},
+			elapsed:    []time.Duration{0},
+			notified:   []bool{false},
+		},
+		{
+			// The tags other than the commit SHAs lag from the time the watcher first saw them diverge
+			name:       "maxDays from the divergence",
+			staleness:  StalenessOption{Source: "staging", MaxDays: 2},
+			production: []string{"v1.0.0", "v1.0.0"},
+			staging:    []string{"v1.1.0", "v1.1.0"},
+			elapsed:    []time.Duration{0, 48 * time.Hour},
+			notified:   []bool{false, true},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			posts = nil
+			gh := &fakeStalenessGitHub{tags: map[string]string{}, commits: commits}
+			httpClient := &http.Client{Transport: gh}
+			github := &GitHub{client: *githubv4.NewClient(httpClient), httpClient: httpClient, org: "zaiminc", repo: "manifests", files: newGitHubFileCache()}
+			pj := DeployProject{ID: "myapp", Phases: []DeployPhase{
+				{Name: "staging", Destination: Destination{Kind: "kustomize", Kustomize: DestinationKustomize{Path: "staging/kustomization.yaml", Image: "myapp"}}},
+				{Name: "production", NotifyChannel: "C1", Staleness: c.staleness, Destination: Destination{Kind: "kustomize", Kustomize: DestinationKustomize{Path: "production/kustomization.yaml", Image: "myapp"}}},
+			}}
+			w := NewStalenessWatcher(slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/")), github, &ProjectList{items: []DeployProject{pj}})
+			for n := range c.elapsed {
+				gh.tags["staging/kustomization.yaml"] = c.staging[n]
+				gh.tags["production/kustomization.yaml"] = c.production[n]
+				w.now = func() time.Time { return now.Add(c.elapsed[n]) }
+				before := len(posts)
+				require.NoError(t, w.check(pj, pj.FindPhase("production")))
+				require.Equal(t, c.notified[n], len(posts) > before, "check %d", n)
+				if c.notified[n] {
+					require.Contains(t, posts[len(posts)-1], fmt.Sprintf("Run `deploy myapp production` to ship `%s`.", c.staging[n]))
+				}
+			}
+		})
+	}
+}
+
+func TestStalenessWatcher_checkUnknownSource(t *testing.T) {
+	pj := DeployProject{ID: "myapp", Phases: []DeployPhase{{Name: "production", NotifyChannel: "C1", Staleness: StalenessOption{Source: "qa", MaxDays: 1}}}}
+	w := NewStalenessWatcher(nil, nil, &ProjectList{items: []DeployProject{pj}})
+	require.EqualError(t, w.check(pj, pj.FindPhase("production")), "source phase qa not found")
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// defaultSyntheticCheckTimeout is how long the synthetic checks of a phase are waited on unless its timeout is set.
+const defaultSyntheticCheckTimeout = 10 * time.Minute
+
+// errSyntheticCheckFailed is returned by SyntheticCheckHook to stop the subsequent post-deploy hooks,
+// which notify the success of the deploy.
+var errSyntheticCheckFailed = errors.New("synthetic checks failed")
+
+// SyntheticCheckOption is the synthetic checks gocat runs after a deploy of the phase, which must pass
+// before the deploy is recorded and notified as successful.
+type SyntheticCheckOption struct {
+	Checks []SyntheticCheck `yaml:"checks"`
+	// Timeout is the duration gocat waits for all the checks to pass, including the rollout of the new image.
+	// Defaults to 10m.
+	Timeout string `yaml:"timeout"`
+}
+
+func (o SyntheticCheckOption) Enabled() bool {
+	return len(o.Checks) > 0
+}
+
+func (o SyntheticCheckOption) validate() error {
+	for _, c := range o.Checks {
+		switch c.kind() {
+		case "http":
+			if c.URL == "" {
+				return fmt.Errorf("the http check %s has no url", c.name())
+			}
+		case "checkly", "datadog":
+			if c.ID == "" {
+				return fmt.Errorf("the %s check %s has no id", c.kind(), c.name())
+			}
+		default:
+			return fmt.Errorf("unknown kind %q of %s. It's http, checkly, or datadog", c.Kind, c.name())
+		}
+	}
+	if _, err := time.ParseDuration(o.Timeout); o.Timeout != "" && err != nil {
+		return fmt.Errorf("invalid timeout: %w", err)
+	}
+	return nil
+}
+
+func (o SyntheticCheckOption) timeout() time.Duration {
+	if d := parseOptionalDuration(o.Timeout); d > 0 {
+		return d
+	}
+	return defaultSyntheticCheckTimeout
+}
+
+// SyntheticCheck is a synthetic check of a phase.
+type SyntheticCheck struct {
+	Name string `yaml:"name"`
+	// Kind is http, the default, checkly, or datadog.
+	// http requests URL until it responds with Status and a body matching Body.
+	// checkly and datadog trigger the check of Checkly or the test of Datadog Synthetics with ID, and wait for its result.
+	Kind string `yaml:"kind"`
+	// URL is the URL the http check requests. It's a Go template rendered with SyntheticCheckVars,
+	// like https://myapp.example.com/version?expect={{.Tag}}.
+	URL string `yaml:"url"`
+	// Status is the status the http check expects. Defaults to 200.
+	Status int `yaml:"status"`
+	// Body is the regexp the body of the response of the http check must match, like "{{.Tag}}". It's rendered as URL is.
+	Body string `yaml:"body"`
+	// ID is the ID of the check of Checkly, or the public ID of the test of Datadog Synthetics.
+	ID string `yaml:"id"`
+}
+
+func (c SyntheticCheck) kind() string {
+	if c.Kind == "" {
+		return "http"
+	}
+	return c.Kind
+}
+
+func (c SyntheticCheck) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if c.kind() == "http" {
+		return c.URL
+	}
+	return c.kind() + " " + c.ID
+}
+
+// SyntheticCheckVars is the set of variables available in SyntheticCheck.URL and SyntheticCheck.Body.
+type SyntheticCheckVars struct {
+	Project string
+	Phase   string
+	Tag     string
+}
+
+func (self SyntheticCheckVars) Parse(s string) (string, error) {
+	return renderTemplate(s, self)
+}
+
+// SyntheticCheckRunner runs the synthetic checks of the phases.
+type SyntheticCheckRunner struct {
+	httpClient       *http.Client
+	checklyURL       string
+	checklyAPIKey    string
+	checklyAccountID string
+	datadogURL       string
+	datadogAPIKey    string
+	datadogAppKey    string
+	// interval is the interval the checks are retried, or their results are polled, at.
+	interval time.Duration
+}
+
+func NewSyntheticCheckRunner(config CatConfig) SyntheticCheckRunner {
+	return SyntheticCheckRunner{
+		httpClient:       &http.Client{Timeout: 30 * time.Second},
+		checklyURL:       "https://api.checklyhq.com",
+		checklyAPIKey:    config.ChecklyAPIKey,
+		checklyAccountID: config.ChecklyAccountID,
+		datadogURL:       "https://api." + config.DatadogSite,
+		datadogAPIKey:    config.DatadogAPIKey,
+		datadogAppKey:    config.DatadogAppKey,
+		interval:         10 * time.Second,
+	}
+}
+
+// Run runs all the checks of the phase against the tag, and returns the error of the first check that doesn't pass within the timeout.
+// The http checks are retried until they pass, as the new image may not be rolled out yet.
+func (r SyntheticCheckRunner) Run(pj DeployProject, phase DeployPhase, tag string) error {
+	vars := SyntheticCheckVars{Project: pj.ID, Phase: phase.Name, Tag: tag}
+	deadline := time.Now().Add(phase.SyntheticChecks.timeout())
+	for _, c := range phase.SyntheticChecks.Checks {
+		var err error
+		switch c.kind() {
+		case "http":
+			err = r.runHTTP(c, vars, deadline)
+		case "checkly":
+			err = r.runCheckly(c, deadline)
+		case "datadog":
+			err = r.runDatadog(c, deadline)
+		default:
+			err = fmt.Errorf("unknown kind %q", c.Kind)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s of %s %s with `%s`: %s", errSyntheticCheckFailed, c.name(), pj.ID, phase.Name, tag, err)
+		}
+		log.Printf("[INFO] The synthetic check %s of %s %s with %s passed", c.name(), pj.ID, phase.Name, tag)
+	}
+	return nil
+}
+
+// poll calls f every interval until it returns done, an error, or the deadline passes.
+func (r SyntheticCheckRunner) poll(deadline time.Time, f func() (done bool, err error)) error {
+	for {
+		done, err := f()
+		if done || err != nil {
+			return err
+		}
+		if time.Now().Add(r.interval).After(deadline) {
+			return fmt.Errorf("timed out")
+		}
+		time.Sleep(r.interval)
+	}
+}
+
+func (r SyntheticCheckRunner) runHTTP(c SyntheticCheck, vars SyntheticCheckVars, deadline time.Time) error {
+	url, err := vars.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("unable to render the url: %w", err)
+	}
+	pattern, err := vars.Parse(c.Body)
+	if err != nil {
+		return fmt.Errorf("unable to render the body: %w", err)
+	}
+	body, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid body: %w", err)
+	}
+	status := c.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	var last string
+	err = r.poll(deadline, func() (bool, error) {
+		resp, err := r.httpClient.Get(url)
+		if err != nil {
+			last = err.Error()
+			return false, nil
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if resp.StatusCode != status {
+			last = fmt.Sprintf("%s responded with %s, not %d", url, resp.Status, status)
+			return false, nil
+		}
+		if !body.Match(b) {
+			last = fmt.Sprintf("the body of %s didn't match %s", url, pattern)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && last != "" {
+		return fmt.Errorf("%s: %s", err, last)
+	}
+	return err
+}
+
+func (r SyntheticCheckRunner) do(method, url string, header map[string]string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// runCheckly triggers the check session of the check of Checkly and waits for it to finish.
+func (r SyntheticCheckRunner) runCheckly(c SyntheticCheck, deadline time.Time) error {
+	if r.checklyAPIKey == "" || r.checklyAccountID == "" {
+		return fmt.Errorf("set CONFIG_CHECKLY_API_KEY and CONFIG_CHECKLY_ACCOUNT_ID to run the checks of Checkly")
+	}
+	header := map[string]string{"Authorization": "Bearer " + r.checklyAPIKey, "X-Checkly-Account": r.checklyAccountID}
+	var triggered struct {
+		CheckSessions []struct {
+			CheckSessionID string `json:"checkSessionId"`
+		} `json:"checkSessions"`
+	}
+	in := map[string]interface{}{"target": map[string]interface{}{"checkId": []string{c.ID}}}
+	if err := r.do(http.MethodPost, r.checklyURL+"/v1/check-sessions/trigger", header, in, &triggered); err != nil {
+		return err
+	}
+	if len(triggered.CheckSessions) == 0 {
+		return fmt.Errorf("the check %s of Checkly is not found", c.ID)
+	}
+	id := triggered.CheckSessions[0].CheckSessionID
+	return r.poll(deadline, func() (bool, error) {
+		var session struct {
+			Status string `json:"status"`
+		}
+		if err := r.do(http.MethodGet, r.checklyURL+"/v1/check-sessions/"+id, header, nil, &session); err != nil {
+			return false, err
+		}
+		switch session.Status {
+		case "PASSED":
+			return true, nil
+		case "FAILED", "TIMED_OUT":
+			return true, fmt.Errorf("the check session %s of Checkly %s", id, strings.ToLower(session.Status))
+		}
+		return false, nil
+	})
+}
+
+// runDatadog triggers the test of Datadog Synthetics and waits for its result.
+func (r SyntheticCheckRunner) runDatadog(c SyntheticCheck, deadline time.Time) error {
+	if r.datadogAPIKey == "" || r.datadogAppKey == "" {
+		return fmt.Errorf("set CONFIG_DATADOG_API_KEY and CONFIG_DATADOG_APP_KEY to run the tests of Datadog Synthetics")
+	}
+	header := map[string]string{"DD-API-KEY": r.datadogAPIKey, "DD-APPLICATION-KEY": r.datadogAppKey}
+	var triggered struct {
+		Results []struct {
+			ResultID string `json:"result_id"`
+		} `json:"results"`
+	}
+	in := map[string]interface{}{"tests": []map[string]string{{"public_id": c.ID}}}
+	if err := r.do(http.MethodPost, r.datadogURL+"/api/v1/synthetics/tests/trigger", header, in, &triggered); err != nil {
+		return err
+	}
+	if len(triggered.Results) == 0 {
+		return fmt.Errorf("the test %s of Datadog Synthetics is not found", c.ID)
+	}
+	resultURL := fmt.Sprintf("%s/api/v1/synthetics/tests/%s/results/%s", r.datadogURL, c.ID, triggered.Results[0].ResultID)
+	return r.poll(deadline, func() (bool, error) {
+		var result struct {
+			Result struct {
+				Passed *bool `json:"passed"`
+			} `json:"result"`
+		}
+		if err := r.do(http.MethodGet, resultURL, header, nil, &result); err != nil {
+			// The result isn't available until the test finishes
+			return false, nil
+		}
+		if result.Result.Passed == nil {
+			return false, nil
+		}
+		if !*result.Result.Passed {
+			return true, fmt.Errorf("the test %s of Datadog Synthetics failed", c.ID)
+		}
+		return true, nil
+	})
+}
+
+// SyntheticCheckHook returns a PostDeployHook that runs the synthetic checks of the phase, if any, before the hooks after it,
+// which notify the success of the deploy. The deploy is recorded as deployed only once the checks pass.
+// The failure is notified to the notifyChannel of the phase in the layout of the deploy_failure template if any,
+// and stops the hooks after it.
+func SyntheticCheckHook(client *slack.Client, workspaces *SlackWorkspaces, templates *MessageTemplates, projectList *ProjectList, tracer *DeployTracer, runner SyntheticCheckRunner) PostDeployHook {
+	return func(m DeployMetadata, prURL string) error {
+		pj := projectList.Find(m.Project)
+		phase := pj.FindPhase(m.Phase)
+		if !phase.SyntheticChecks.Enabled() {
+			return nil
+		}
+		tracer.Step(m.TraceID, PipelineStepVerify, PipelineStepRunning)
+		if err := runner.Run(pj, phase, m.Tag); err != nil {
+			tracer.Emit(m.TraceID, DeployEventFailed, "%s", err)
+			if phase.NotifyChannel != "" {
+				vars := deployNotificationVars(map[string]string{"Project": m.Project, "Phase": m.Phase, "Tag": m.Tag, "Requester": m.RequesterSlackID, "Link": prURL, "TraceID": m.TraceID, "Error": err.Error()})
+				msg := templates.MessageOr("deploy_failure", "", vars, func() slack.MsgOption {
+					fields := []slack.AttachmentField{
+						{Title: "Project", Value: m.Project, Short: true},
+						{Title: "Phase", Value: m.Phase, Short: true},
+						{Title: "Tag", Value: m.Tag, Short: true},
+					}
+					return slack.MsgOptionAttachments(slack.Attachment{Color: "#e01e5a", Title: ":x: Synthetic checks failed after the deploy", TitleLink: prURL, Text: err.Error(), Fields: fields})
+				})
+				if _, _, err := workspaces.ClientFor(pj, client).PostMessage(phase.NotifyChannel, msg); err != nil {
+					log.Print(err)
+				}
+			}
+			return err
+		}
+		tracer.Step(m.TraceID, PipelineStepVerify, PipelineStepDone)
+		tracer.Emit(m.TraceID, DeployEventDeployed, "%d synthetic checks passed after %s", len(phase.SyntheticChecks.Checks), prURL)
+		return nil
+	}
+}
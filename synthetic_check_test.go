@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyntheticCheckRunner_Run(t *testing.T) {
+	// The new version is served from the third request, as if it were rolled out by then
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/version":
+			requests++
+			if requests < 3 {
+				w.Write([]byte(`{"version": "old"}`))
+				return
+			}
+			w.Write([]byte(`{"version": "abc1234"}`))
+		case "/v1/check-sessions/trigger":
+			require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+			w.Write([]byte(`{"checkSessions": [{"checkSessionId": "s1"}]}`))
+		case "/v1/check-sessions/s1":
+			w.Write([]byte(`{"status": "FAILED"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r := SyntheticCheckRunner{httpClient: server.Client(), checklyURL: server.URL, checklyAPIKey: "key", checklyAccountID: "a1", interval: time.Millisecond}
+	pj := DeployProject{ID: "myapp"}
+	phase := DeployPhase{Name: "production", SyntheticChecks: SyntheticCheckOption{Checks: []SyntheticCheck{
+		{Name: "version", URL: server.URL + "/version", Body: `"{{.Tag}}"`},
+	}}}
+	require.NoError(t, phase.SyntheticChecks.validate())
+	require.NoError(t, r.Run(pj, phase, "abc1234"))
+	require.Equal(t, 3, requests)
+
+	phase.SyntheticChecks.Checks = append(phase.SyntheticChecks.Checks, SyntheticCheck{Name: "checkout", Kind: "checkly", ID: "c1"})
+	err := r.Run(pj, phase, "abc1234")
+	require.True(t, errors.Is(err, errSyntheticCheckFailed))
+	require.Contains(t, err.Error(), "checkout of myapp production with `abc1234`: the check session s1 of Checkly failed")
+
+	phase.SyntheticChecks = SyntheticCheckOption{Checks: []SyntheticCheck{{Name: "missing", URL: server.URL + "/missing"}}, Timeout: "10ms"}
+	err = r.Run(pj, phase, "abc1234")
+	require.Contains(t, err.Error(), "timed out")
+	require.Contains(t, err.Error(), "404 Not Found")
+}
+
+func TestPostDeployHooks_RunStopsOnSyntheticCheckFailure(t *testing.T) {
+	var ran []string
+	hooks := PostDeployHooks{
+		func(m DeployMetadata, prURL string) error {
+			ran = append(ran, "checks")
+			return errSyntheticCheckFailed
+		},
+		func(m DeployMetadata, prURL string) error {
+			ran = append(ran, "notify")
+			return nil
+		},
+	}
+	hooks.Run(DeployMetadata{}, "")
+	require.Equal(t, []string{"checks"}, ran)
+}
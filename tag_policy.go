@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// TagPolicy restricts the image tags deployable to a phase, like production accepting only the release versions and never the release candidates:
+//
+//	tagPolicy:
+//	  allow: ['v\d+\.\d+\.\d+']
+//	  deny: ['-rc']
+//
+// It's enforced for the deploys requested in Slack, the ones triggered by the API, and AutoDeploy alike,
+// and by GitOperator for all the image tags it pushes, whichever path pushes them.
+// It's supported only by tagPolicyKinds, the kinds deploying the image tags.
+type TagPolicy struct {
+	// Allow are the regexps of the tags allowed to be deployed, which must match the whole tag.
+	// All the tags are allowed if empty.
+	Allow []string `yaml:"allow"`
+	// Deny are the regexps of the tags never deployed, which match anywhere in the tag.
+	// They take precedence over Allow.
+	Deny []string `yaml:"deny"`
+
+	// allow and deny are Allow and Deny compiled when the project is parsed. See compile.
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// tagPolicyKinds are the kinds of the phases supporting tagPolicy.
+var tagPolicyKinds = []string{"kustomize", "kanvas"}
+
+func (p TagPolicy) Enabled() bool {
+	return len(p.Allow) > 0 || len(p.Deny) > 0
+}
+
+// compile returns the policy with its patterns compiled, or the error of the invalid pattern.
+func (p TagPolicy) compile() (TagPolicy, error) {
+	p.allow, p.deny = nil, nil
+	for _, pattern := range p.Allow {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return p, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		p.allow = append(p.allow, re)
+	}
+	for _, pattern := range p.Deny {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return p, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		p.deny = append(p.deny, re)
+	}
+	return p, nil
+}
+
+// validate returns the error if the phase of the kind can't have the policy.
+func (p TagPolicy) validate(kind string) error {
+	if !p.Enabled() {
+		return nil
+	}
+	for _, k := range tagPolicyKinds {
+		if k == kind {
+			_, err := p.compile()
+			return err
+		}
+	}
+	return fmt.Errorf("kind %s doesn't deploy the image tags. tagPolicy is supported only by %s", kind, strings.Join(tagPolicyKinds, ", "))
+}
+
+// Check returns an error coded ErrCodeTagNotAllowed if the tag isn't allowed to be deployed.
+func (p TagPolicy) Check(tag string) error {
+	if !p.Enabled() {
+		return nil
+	}
+	if p.allow == nil && p.deny == nil {
+		// The policy isn't parsed from the configmap, like the one in the tests
+		compiled, err := p.compile()
+		if err != nil {
+			return err
+		}
+		p = compiled
+	}
+	for i, re := range p.deny {
+		if re.MatchString(tag) {
+			return withCode(ErrCodeTagNotAllowed, fmt.Errorf("the tag %s is denied by `%s` of the tagPolicy", tag, p.Deny[i]))
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, re := range p.allow {
+		if re.MatchString(tag) {
+			return nil
+		}
+	}
+	return withCode(ErrCodeTagNotAllowed, fmt.Errorf("the tag %s matches none of the allowed patterns of the tagPolicy", tag))
+}
+
+// checkImageTags checks the tags of all the images deployed together against the tagPolicy of the phase.
+// The images changing only their digests, like the ones pinned by pinDigest, keep their tags, which were checked when deployed.
+func checkImageTags(ph DeployPhase, images []types.Image) error {
+	for _, image := range images {
+		if image.NewTag == "" {
+			continue
+		}
+		if err := ph.TagPolicy.Check(image.NewTag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
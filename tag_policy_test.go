@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+func TestTagPolicy(t *testing.T) {
+	policy := TagPolicy{Allow: []string{`v\d+\.\d+\.\d+`}, Deny: []string{`-rc`}}
+	require.True(t, policy.Enabled())
+	require.NoError(t, policy.Check("v1.2.3"))
+
+	// Allow matches the whole tag, while Deny matches anywhere
+	for _, tag := range []string{"v1.2.3-beta", "1a2b3c4", "release-v1.2.3"} {
+		err := policy.Check(tag)
+		require.Error(t, err, tag)
+		require.Equal(t, ErrCodeTagNotAllowed, errorCodeOf(err))
+	}
+	require.Error(t, TagPolicy{Allow: []string{`v.*`}, Deny: []string{`-rc`}}.Check("v1.2.3-rc1"))
+
+	// No policy allows all the tags
+	require.False(t, TagPolicy{}.Enabled())
+	require.NoError(t, TagPolicy{}.Check("anything"))
+	require.NoError(t, TagPolicy{Deny: []string{`-rc`}}.Check("1a2b3c4"))
+
+	// All the images deployed together are checked
+	ph := DeployPhase{TagPolicy: policy}
+	require.NoError(t, checkImageTags(ph, []types.Image{{Name: "api", NewTag: "v1.2.3"}, {Name: "worker", NewTag: "v1.2.3"}}))
+	require.Error(t, checkImageTags(ph, []types.Image{{Name: "api", NewTag: "v1.2.3"}, {Name: "worker", NewTag: "v1.2.3-rc1"}}))
+
+	require.Error(t, TagPolicy{Allow: []string{`v(`}}.validate("kustomize"))
+	require.NoError(t, policy.validate("kustomize"))
+	require.EqualError(t, policy.validate("jenkins"), "kind jenkins doesn't deploy the image tags. tagPolicy is supported only by kustomize, kanvas")
+	require.NoError(t, TagPolicy{}.validate("jenkins"))
+
+	// The patterns are compiled once when the project is parsed
+	compiled, err := policy.compile()
+	require.NoError(t, err)
+	require.Len(t, compiled.allow, 1)
+	require.Len(t, compiled.deny, 1)
+	require.NoError(t, compiled.Check("v1.2.3"))
+	require.Error(t, compiled.Check("v1.2.3-rc1"))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// TagOrder is how to choose the image tag to deploy among the ones matching the regexps.
+type TagOrder string
+
+const (
+	// TagOrderNone chooses the first match in the order ECR returns the images.
+	// This is fine when the filter regexp matches a single image, like a tag named after the branch.
+	TagOrderNone TagOrder = ""
+	// TagOrderPushedAt chooses the most recently pushed image.
+	TagOrderPushedAt TagOrder = "pushedAt"
+	// TagOrderSemver chooses the highest semantic version.
+	TagOrderSemver TagOrder = "semver"
+	// TagOrderLexical chooses the lexically greatest tag, which is the latest one for date-based tags.
+	TagOrderLexical TagOrder = "lexical"
+)
+
+// TagStrategy is a preset of the regexps and the order to find the image tag to deploy,
+// for the common tag conventions.
+// Projects select one by the TagStrategy key of the configmap,
+// and can still override the regexps by FilterRegexp and TargetRegexp.
+type TagStrategy struct {
+	FilterRegexp string
+	TargetRegexp string
+	Order        TagOrder
+}
+
+var tagStrategies = map[string]TagStrategy{
+	// Images are tagged with both the branch name and the commit SHA.
+	// This is the default.
+	"git-sha": {
+		FilterRegexp: "^{{.Branch}}$",
+		TargetRegexp: `\b[0-9a-f]{5,40}\b`,
+		Order:        TagOrderNone,
+	},
+	// Images are tagged like feature-foo-0123abc.
+	"branch-slug-sha": {
+		FilterRegexp: "^{{.BranchSlug}}-[0-9a-f]{7,40}$",
+		TargetRegexp: "^{{.BranchSlug}}-[0-9a-f]{7,40}$",
+		Order:        TagOrderPushedAt,
+	},
+	// Images are tagged like v1.2.3.
+	"semver-latest": {
+		FilterRegexp: `^v?[0-9]+\.[0-9]+\.[0-9]+$`,
+		TargetRegexp: `^v?[0-9]+\.[0-9]+\.[0-9]+$`,
+		Order:        TagOrderSemver,
+	},
+	// Images are tagged like 2024-06-01, 20240601, or 20240601-0123abc.
+	"date-based": {
+		FilterRegexp: `^[0-9]{4}-?[0-9]{2}-?[0-9]{2}\b`,
+		TargetRegexp: `^[0-9]{4}-?[0-9]{2}-?[0-9]{2}\b`,
+		Order:        TagOrderLexical,
+	},
+}
+
+const defaultTagStrategy = "git-sha"
+
+// imageTagCandidate is an image tag matching the regexps, along with the time the image was pushed.
+type imageTagCandidate struct {
+	tag      string
+	pushedAt time.Time
+}
+
+// sortImageTagCandidates sorts the candidates so that the one to deploy comes first.
+func sortImageTagCandidates(candidates []imageTagCandidate, order TagOrder) {
+	switch order {
+	case TagOrderPushedAt:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].pushedAt.After(candidates[j].pushedAt)
+		})
+	case TagOrderSemver:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			a, _ := ParseSemver(candidates[i].tag)
+			b, _ := ParseSemver(candidates[j].tag)
+			return a.Compare(b) > 0
+		})
+	case TagOrderLexical:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].tag > candidates[j].tag
+		})
+	}
+}
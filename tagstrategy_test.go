@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/stretchr/testify/require"
+)
+
+func imageDetail(pushedAt time.Time, tags ...string) *ecr.ImageDetail {
+	return &ecr.ImageDetail{ImagePushedAt: aws.Time(pushedAt), ImageTags: aws.StringSlice(tags)}
+}
+
+func TestFindImageTag_TagStrategies(t *testing.T) {
+	now := time.Now()
+	details := []*ecr.ImageDetail{
+		imageDetail(now.Add(-3*time.Hour), "feature-foo-1111111"),
+		imageDetail(now.Add(-1*time.Hour), "feature-foo-2222222"),
+		imageDetail(now.Add(-2*time.Hour), "master", "3333333"),
+		imageDetail(now.Add(-5*time.Hour), "v1.10.0"),
+		imageDetail(now.Add(-4*time.Hour), "v1.9.3"),
+		imageDetail(now.Add(-6*time.Hour), "v1.10.0-rc.1"),
+		imageDetail(now.Add(-8*time.Hour), "20240601-aaaaaaa"),
+		imageDetail(now.Add(-7*time.Hour), "20240531-bbbbbbb"),
+	}
+
+	testcases := []struct {
+		strategy string
+		branch   string
+		want     string
+	}{
+		{strategy: "git-sha", branch: "master", want: "3333333"},
+		{strategy: "branch-slug-sha", branch: "Feature/Foo", want: "feature-foo-2222222"},
+		{strategy: "semver-latest", want: "v1.10.0"},
+		{strategy: "date-based", want: "20240601-aaaaaaa"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.strategy, func(t *testing.T) {
+			pj := DeployProject{tagStrategy: tc.strategy}
+			q := pj.ImageTagQuery(DeployPhase{}, ImageTagVars{Branch: tc.branch})
+			got, err := findImageTag(details, q)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDeployTraces is the number of the latest deploys whose timelines are kept.
+const maxDeployTraces = 200
+
+// DeployTrace is the timeline of a deploy, from the request to the merge, identified by the trace ID.
+//
+// The trace ID is the correlation ID of the deploy shown in the log lines, the Slack messages,
+// and the pull request body, so that they can be matched up and the timeline can be dumped by the trace command.
+type DeployTrace struct {
+	ID      string
+	Project string
+	Phase   string
+	Events  []DeployTraceEvent
+	// State is the latest step of the lifecycle of the deploy.
+	State DeployEventType
+	// Requester is the Slack user ID of the requester, or empty for AutoDeploy.
+	Requester string
+	// approvalChannel and approvalTS are the approval message of the deploy queued,
+	// and cancelValue is the value of its Close button, which the queue command cancels the deploy by.
+	approvalChannel string
+	approvalTS      string
+	cancelValue     string
+	// retry is the failed stage of the deploy, which the retry command runs again.
+	retry *DeployRetry
+	// artifacts are the artifacts prepared for the deploy, like the diff and the SBOMs, which are archived when it ships.
+	artifacts map[string][]byte
+}
+
+// InFlight returns true if the deploy is neither deployed, failed, nor skipped yet.
+func (t DeployTrace) InFlight() bool {
+	return t.State == DeployEventRequested || t.State == DeployEventAwaitingApproval || t.State == DeployEventApproved
+}
+
+type DeployTraceEvent struct {
+	At   time.Time
+	Text string
+}
+
+// Format returns the timeline with the times in the time zone of the calendar.
+func (t DeployTrace) Format(calendar BusinessCalendar) string {
+	lines := []string{fmt.Sprintf("*Trace %s of %s %s*", t.ID, t.Project, t.Phase)}
+	for _, e := range t.Events {
+		lines = append(lines, fmt.Sprintf("%s %s", calendar.Format(e.At, "2006-01-02 15:04:05"), e.Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DeployTracer keeps the timelines of the latest deploys in memory, and writes each event to the log with the trace ID.
+//
+// The methods are safe to call on nil, which only logs.
+type DeployTracer struct {
+	mu     sync.Mutex
+	traces map[string]*DeployTrace
+	// order is the trace IDs from the oldest, to evict the oldest beyond maxDeployTraces.
+	order []string
+	// now is replaced in tests
+	now func() time.Time
+	// stream is where the events of the lifecycle of the deploys are emitted to, like Kafka.
+	stream *DeployEventStream
+	// pipelines shows the steps of the deploys as the checklists in Slack, if enabled.
+	pipelines *DeployPipelineBoard
+	// issues opens the GitHub issues of the failed deploys of the phases with failureIssue.
+	issues *FailureIssueReporter
+}
+
+func NewDeployTracer() *DeployTracer {
+	return &DeployTracer{traces: map[string]*DeployTrace{}, now: time.Now}
+}
+
+// newTraceID returns a random short ID, which is short enough to type in the trace command.
+func newTraceID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Start starts the trace of the deploy of the project to the phase with the first event, and returns the trace ID.
+func (t *DeployTracer) Start(project, phase string, format string, args ...interface{}) string {
+	id := newTraceID()
+	if t != nil {
+		t.mu.Lock()
+		t.traces[id] = &DeployTrace{ID: id, Project: project, Phase: phase, State: DeployEventRequested}
+		t.order = append(t.order, id)
+		if n := len(t.order); n > maxDeployTraces {
+			for _, old := range t.order[:n-maxDeployTraces] {
+				delete(t.traces, old)
+			}
+			t.order = t.order[n-maxDeployTraces:]
+		}
+		t.mu.Unlock()
+	}
+	text := fmt.Sprintf(format, args...)
+	log.Printf("[INFO] [trace:%s] %s %s: %s", id, project, phase, text)
+	t.add(id, text)
+	if t != nil && t.stream != nil {
+		t.stream.Emit(DeployEvent{Type: DeployEventRequested, TraceID: id, Project: project, Phase: phase, Message: text, Time: t.now().UnixMilli()})
+	}
+	return id
+}
+
+// Record adds the event to the trace. It does nothing for an empty ID,
+// like the one of the deploys requested before gocat started tracing.
+func (t *DeployTracer) Record(id string, format string, args ...interface{}) {
+	if id == "" {
+		return
+	}
+	text := fmt.Sprintf(format, args...)
+	log.Printf("[INFO] [trace:%s] %s", id, text)
+	t.add(id, text)
+}
+
+// Emit records the event as Record does, and emits it as the step of the lifecycle of the deploy to the event stream.
+func (t *DeployTracer) Emit(id string, typ DeployEventType, format string, args ...interface{}) {
+	t.Record(id, format, args...)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if trace, ok := t.traces[id]; ok {
+		trace.State = typ
+	}
+	t.mu.Unlock()
+	t.pipelines.Apply(id, typ)
+	trace, ok := t.Get(id)
+	if !ok {
+		return
+	}
+	t.issues.Apply(trace, typ, fmt.Sprintf(format, args...))
+	if t.stream == nil {
+		return
+	}
+	t.stream.Emit(DeployEvent{Type: typ, TraceID: id, Project: trace.Project, Phase: trace.Phase, Message: fmt.Sprintf(format, args...), Time: t.now().UnixMilli()})
+}
+
+// EmitFor emits the event of the deploy m as Emit does. If the trace of m isn't known, like the one of the deploy
+// requested before gocat restarted, the event is emitted to the event stream with the project and the phase of m,
+// so that the deploys finished outside of gocat, like the ones merged on GitHub, are counted.
+func (t *DeployTracer) EmitFor(m DeployMetadata, typ DeployEventType, format string, args ...interface{}) {
+	if _, ok := t.Get(m.TraceID); ok {
+		t.Emit(m.TraceID, typ, format, args...)
+		return
+	}
+	if t == nil || t.stream == nil {
+		return
+	}
+	t.stream.Emit(DeployEvent{Type: typ, TraceID: m.TraceID, Project: m.Project, Phase: m.Phase, Message: fmt.Sprintf(format, args...), Time: t.now().UnixMilli()})
+}
+
+// SetRequester records the Slack user ID of the requester of the deploy.
+func (t *DeployTracer) SetRequester(id, requester string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if trace, ok := t.traces[id]; ok {
+		trace.Requester = requester
+	}
+}
+
+// SetRetry records the failed stage of the deploy so that it can be retried.
+func (t *DeployTracer) SetRetry(id string, r DeployRetry) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if trace, ok := t.traces[id]; ok {
+		r.TraceID = id
+		trace.retry = &r
+	}
+}
+
+// TakeRetry returns the failed stage of the deploy and clears it, so that the stage is retried only once at a time.
+// It returns false unless the deploy has failed at the stage recorded by SetRetry.
+func (t *DeployTracer) TakeRetry(id string) (DeployRetry, bool) {
+	if t == nil {
+		return DeployRetry{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace, ok := t.traces[id]
+	if !ok || trace.retry == nil || trace.State != DeployEventFailed {
+		return DeployRetry{}, false
+	}
+	r := *trace.retry
+	trace.retry = nil
+	return r, true
+}
+
+// SetArtifacts keeps the artifacts prepared for the deploy until it ships, when TakeArtifacts takes them to archive.
+// They're kept as long as the trace is, so the deploys prepared before gocat restarted ship with no prepared artifacts.
+func (t *DeployTracer) SetArtifacts(id string, artifacts map[string][]byte) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if trace, ok := t.traces[id]; ok {
+		trace.artifacts = artifacts
+	}
+}
+
+// TakeArtifacts returns the artifacts prepared for the deploy and clears them, or nil if none.
+func (t *DeployTracer) TakeArtifacts(id string) map[string][]byte {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace, ok := t.traces[id]
+	if !ok {
+		return nil
+	}
+	artifacts := trace.artifacts
+	trace.artifacts = nil
+	return artifacts
+}
+
+// Queue records the approval message of the deploy awaiting approval, and the value of its Close button,
+// so that the requester or an admin can cancel it from the queue command.
+func (t *DeployTracer) Queue(id, requester, channel, ts, cancelValue string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if trace, ok := t.traces[id]; ok {
+		trace.Requester = requester
+		trace.approvalChannel = channel
+		trace.approvalTS = ts
+		trace.cancelValue = cancelValue
+	}
+}
+
+// InFlight returns the copies of the traces of the deploys in flight, from the oldest.
+func (t *DeployTracer) InFlight() []DeployTrace {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var traces []DeployTrace
+	for _, id := range t.order {
+		if trace := t.traces[id]; trace.InFlight() {
+			c := *trace
+			c.Events = append([]DeployTraceEvent(nil), trace.Events...)
+			traces = append(traces, c)
+		}
+	}
+	return traces
+}
+
+// History returns the copies of the traces of the latest n deploys of the project to the phase, from the newest.
+func (t *DeployTracer) History(project, phase string, n int) []DeployTrace {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var traces []DeployTrace
+	for i := len(t.order) - 1; i >= 0 && len(traces) < n; i-- {
+		if trace := t.traces[t.order[i]]; trace.Project == project && trace.Phase == phase {
+			c := *trace
+			c.Events = append([]DeployTraceEvent(nil), trace.Events...)
+			traces = append(traces, c)
+		}
+	}
+	return traces
+}
+
+// ShowPipeline starts the pipeline of the deploy with the steps in the channel, if the pipelines are enabled.
+// It's posted in the thread of threadTS if given, or of the message ThreadPipeline tells later.
+func (t *DeployTracer) ShowPipeline(id, channel, threadTS string, steps []PipelineStep) {
+	if t == nil || t.pipelines == nil {
+		return
+	}
+	trace, ok := t.Get(id)
+	if !ok {
+		return
+	}
+	t.pipelines.Show(id, channel, threadTS, trace.Project, trace.Phase, steps)
+}
+
+// ThreadPipeline posts the pipeline of the deploy in the thread of the message of the deploy, like the approval message.
+func (t *DeployTracer) ThreadPipeline(id, channel, ts string) {
+	if t == nil {
+		return
+	}
+	t.pipelines.Thread(id, channel, ts)
+}
+
+// Step sets the status of the step in the pipeline of the deploy, if it's shown.
+func (t *DeployTracer) Step(id string, step PipelineStep, status PipelineStepStatus) {
+	if t == nil {
+		return
+	}
+	t.pipelines.Set(id, step, status)
+}
+
+func (t *DeployTracer) add(id, text string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if trace, ok := t.traces[id]; ok {
+		trace.Events = append(trace.Events, DeployTraceEvent{At: t.now(), Text: text})
+	}
+}
+
+// Get returns the copy of the trace.
+func (t *DeployTracer) Get(id string) (DeployTrace, bool) {
+	if t == nil {
+		return DeployTrace{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace, ok := t.traces[id]
+	if !ok {
+		return DeployTrace{}, false
+	}
+	c := *trace
+	c.Events = append([]DeployTraceEvent(nil), trace.Events...)
+	return c, true
+}
+
+// traceLine returns the line of the Slack message showing the trace ID, or an empty string if there's no ID.
+func traceLine(id string) string {
+	if id == "" {
+		return ""
+	}
+	return fmt.Sprintf("\nTrace: `%s`", id)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployTracer(t *testing.T) {
+	tracer := NewDeployTracer()
+	now := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	tracer.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+
+	id := tracer.Start("myapp", "production", "requested by <@U1> with the branch %s", "master")
+	require.Regexp(t, `^[0-9a-f]{8}$`, id)
+	tracer.Record(id, "opened %s for approval", "https://github.com/zaiminc/manifests/pull/1")
+	tracer.Record("", "ignored")
+
+	trace, ok := tracer.Get(id)
+	require.True(t, ok)
+	calendar, err := NewBusinessCalendar("Asia/Tokyo", "", nil)
+	require.NoError(t, err)
+	require.Equal(t, "*Trace "+id+" of myapp production*\n"+
+		"2024-04-01 09:01:00 JST requested by <@U1> with the branch master\n"+
+		"2024-04-01 09:02:00 JST opened https://github.com/zaiminc/manifests/pull/1 for approval", trace.Format(calendar))
+
+	for i := 0; i < maxDeployTraces; i++ {
+		tracer.Start("myapp", "staging", "requested")
+	}
+	_, ok = tracer.Get(id)
+	require.False(t, ok)
+
+	var nilTracer *DeployTracer
+	require.NotEmpty(t, nilTracer.Start("myapp", "production", "requested"))
+}
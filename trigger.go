@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// DeployTrigger is the message requesting a deploy from outside of Slack, like the build system on a successful build.
+// It goes through the same pipeline as the deploy command, so the deploy still needs the approval in Slack.
+//
+//	{"project": "myapp", "phase": "staging", "tag": "v1.2.3", "reason": "build #42", "channel": "C0123"}
+type DeployTrigger struct {
+	Project string `json:"project"`
+	Phase   string `json:"phase"`
+	// Branch is the branch to deploy, which is the default branch of the project if empty.
+	Branch string `json:"branch"`
+	Tag    string `json:"tag"`
+	Reason string `json:"reason"`
+	// Requester is the Slack user ID the deploy is requested as, which is the one Slack authenticated, like the user running the workflow step,
+	// and never read from the message, as anyone sending it could claim to be anyone and approve their own deploy.
+	// The deploy is requested as gocat if empty.
+	Requester string `json:"-"`
+	// Sender is who the message queue authenticated the sender of the trigger as, like the IAM role sending the SQS message,
+	// which is told in the messages of the deploy as it's requested as gocat.
+	Sender string `json:"-"`
+	// Channel is the Slack channel ID the approval message is posted to, which is the notifyChannel of the phase if empty.
+	Channel string `json:"channel"`
+}
+
+// ParseDeployTrigger parses the JSON message of DeployTrigger.
+func ParseDeployTrigger(b []byte) (DeployTrigger, error) {
+	var t DeployTrigger
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("invalid deploy trigger: %w", err)
+	}
+	if t.Project == "" || t.Phase == "" {
+		return t, fmt.Errorf("invalid deploy trigger: project and phase are required")
+	}
+	return t, nil
+}
+
+// errTriggerDeferred tells the deploy of the trigger can't be requested for now, like while the phase is pinned,
+// in which case the trigger is left to be redelivered instead of being acknowledged.
+var errTriggerDeferred = errors.New("deferred")
+
+// triggerDeferredError is errTriggerDeferred telling the error deferring the trigger as is.
+type triggerDeferredError struct{ error }
+
+func (e triggerDeferredError) Unwrap() error { return e.error }
+
+func (e triggerDeferredError) Is(target error) bool { return target == errTriggerDeferred }
+
+// TriggerMessage is the message of a deploy trigger consumed from a message queue.
+type TriggerMessage struct {
+	Body []byte
+	// Sender is who the message queue authenticated the sender as. It's empty if the queue tells none. See DeployTrigger.Sender.
+	Sender string
+}
+
+// TriggerSource is a message queue the deploy triggers are consumed from.
+type TriggerSource interface {
+	Name() string
+	// Run consumes the messages and calls handle with each, until the source fails.
+	// A message is acknowledged once handle returns nil, and left to be redelivered by the queue otherwise.
+	Run(handle func(m TriggerMessage) error) error
+}
+
+// TriggerIngester feeds the deploy triggers consumed from the message queues into the deploy pipeline,
+// so that the automations other than Slack, like the build system, can request deploys.
+type TriggerIngester struct {
+	listener *SlackListener
+	sources  []TriggerSource
+}
+
+func NewTriggerIngester(listener *SlackListener, sources ...TriggerSource) TriggerIngester {
+	return TriggerIngester{listener: listener, sources: sources}
+}
+
+// Watch consumes each source in the background, restarting it after the interval when it fails.
+func (i TriggerIngester) Watch(sec int64) {
+	for _, source := range i.sources {
+		source := source
+		log.Printf("[INFO] Deploy trigger ingestion from %s is started.", source.Name())
+		go func() {
+			for {
+				if err := source.Run(i.handle); err != nil {
+					log.Printf("[ERROR] Deploy trigger ingestion from %s failed: %s", source.Name(), err)
+				}
+				time.Sleep(time.Duration(sec) * time.Second)
+			}
+		}()
+	}
+}
+
+// handle requests the deploy of the trigger, and returns nil once the deploy is accepted, or rejected for good,
+// like the invalid triggers, which fail the same way however many times they're retried.
+// It returns the error for the triggers to be redelivered, like the ones deferred while the phase is pinned.
+func (i TriggerIngester) handle(m TriggerMessage) error {
+	defer i.listener.recoverer.Recover("the deploy trigger", "")
+	t, err := ParseDeployTrigger(m.Body)
+	if err != nil {
+		log.Printf("[ERROR] %s: %s", err, m.Body)
+		return nil
+	}
+	t.Sender = m.Sender
+	if err := i.listener.requestTriggeredDeploy(t); err != nil {
+		if errors.Is(err, errTriggerDeferred) {
+			log.Printf("[INFO] The deploy of %s %s triggered by %s is left to be redelivered: %s", t.Project, t.Phase, t.sender(), err)
+			return err
+		}
+		log.Printf("[ERROR] Failed to request the deploy of %s %s by the trigger: %s", t.Project, t.Phase, err)
+		if channel := i.listener.triggerChannel(t); channel != "" {
+			text := fmt.Sprintf(":x: The deploy of *%s* *%s* triggered by %s failed: %s", t.Project, t.Phase, t.sender(), describeError(err))
+			if _, _, err := i.listener.client.PostMessage(channel, slack.MsgOptionText(text, false)); err != nil {
+				log.Println("[ERROR] ", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t DeployTrigger) requester() string {
+	if t.Requester == "" {
+		return "gocat"
+	}
+	return t.Requester
+}
+
+// sender describes who sent the trigger.
+func (t DeployTrigger) sender() string {
+	if t.Sender != "" {
+		return t.Sender
+	}
+	if t.Requester != "" {
+		return fmt.Sprintf("<@%s>", t.Requester)
+	}
+	return "gocat"
+}
+
+// triggerChannel returns the channel of the approval message of the trigger.
+func (s *SlackListener) triggerChannel(t DeployTrigger) string {
+	if t.Channel != "" {
+		return t.Channel
+	}
+	pj, err := s.projectList.FindByAlias(t.Project)
+	if err != nil {
+		return ""
+	}
+	return pj.FindPhase(s.toPhase(t.Phase)).NotifyChannel
+}
+
+// requestTriggeredDeploy requests the deploy of the trigger in the same way as the deploy command,
+// and posts the approval message to the channel of the trigger.
+func (s *SlackListener) requestTriggeredDeploy(t DeployTrigger) error {
+	pj, err := s.projectList.FindByAlias(t.Project)
+	if err != nil {
+		return err
+	}
+	phase := s.toPhase(t.Phase)
+	if err := checkDeployable(s.coordinator, pj.ID, phase); err != nil {
+		return triggerDeferredError{err}
+	}
+	channel := s.triggerChannel(t)
+	if channel == "" {
+		return fmt.Errorf("channel is required as %s %s has no notifyChannel", pj.ID, phase)
+	}
+	branch := t.Branch
+	if branch == "" {
+		branch = pj.DefaultBranch()
+	}
+
+	if t.Sender != "" {
+		log.Printf("[INFO] Deploy of %s %s is triggered by %s", pj.ID, phase, t.Sender)
+	}
+	interactor := s.interactorFactory.Get(pj, phase)
+	blocks, err := requestDeploy(interactor, pj, phase, DeployOption{Branch: branch, Tag: t.Tag, Reason: t.Reason}, t.requester(), channel)
+	if err != nil {
+		return err
+	}
+	channel, ts, err := s.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...))
+	if err != nil {
+		return err
+	}
+	s.approvalReminder.Track(s.client, pj.ID, phase, channel, ts, blocks)
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// sqsTriggerSource consumes the deploy triggers from the SQS queue by long polling.
+// A message is deleted once the deploy is accepted, or is left to be received again after the visibility timeout of the queue,
+// which moves it to the dead-letter queue of the redrive policy after the retries.
+type sqsTriggerSource struct {
+	queueURL string
+	client   sqsAPI
+}
+
+type sqsAPI interface {
+	ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
+}
+
+func NewSQSTriggerSource(queueURL string) (TriggerSource, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String("ap-northeast-1")},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return sqsTriggerSource{queueURL: queueURL, client: sqs.New(sess)}, nil
+}
+
+func (s sqsTriggerSource) Name() string {
+	return "SQS " + s.queueURL
+}
+
+func (s sqsTriggerSource) Run(handle func(m TriggerMessage) error) error {
+	for {
+		out, err := s.client.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(s.queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+			AttributeNames:      []*string{aws.String(sqs.MessageSystemAttributeNameSenderId)},
+		})
+		if err != nil {
+			return err
+		}
+		for _, m := range out.Messages {
+			// SenderId is the IAM user or role SQS authenticated the sender as
+			sender := aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameSenderId])
+			if err := handle(TriggerMessage{Body: []byte(aws.StringValue(m.Body)), Sender: sender}); err != nil {
+				continue
+			}
+			if _, err := s.client.DeleteMessage(&sqs.DeleteMessageInput{QueueUrl: aws.String(s.queueURL), ReceiptHandle: m.ReceiptHandle}); err != nil {
+				return err
+			}
+		}
+	}
+}
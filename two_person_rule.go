@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTwoPersonRule is returned when the requester approves their own deploy under twoPersonRule.
+var ErrTwoPersonRule = errors.New("two-person rule")
+
+// checkTwoPersonRule returns ErrTwoPersonRule if the approver requested the deploy, or any deploy stacked into it.
+// The deploys without a recorded requester are allowed.
+func checkTwoPersonRule(projectList *ProjectList, m DeployMetadata, approver string) error {
+	if !projectList.Find(m.Project).FindPhase(m.Phase).TwoPersonRule {
+		return nil
+	}
+	if !containsString(m.RequesterSlackIDs(), approver) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s %s must be approved by someone other than the requester <@%s>", ErrTwoPersonRule, m.Project, m.Phase, approver)
+}
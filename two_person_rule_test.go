@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTwoPersonRule(t *testing.T) {
+	pl := &ProjectList{
+		items: []DeployProject{
+			{
+				ID: "myapp",
+				Phases: []DeployPhase{
+					{Name: "staging"},
+					{Name: "production", TwoPersonRule: true},
+				},
+			},
+		},
+	}
+
+	m := DeployMetadata{Project: "myapp", Phase: "production", RequesterSlackID: "U1"}
+	require.True(t, errors.Is(checkTwoPersonRule(pl, m, "U1"), ErrTwoPersonRule))
+	require.NoError(t, checkTwoPersonRule(pl, m, "U2"))
+
+	// Nobody requesting any of the stacked deploys can approve them
+	m.StackedRequesterSlackIDs = []string{"U2"}
+	require.True(t, errors.Is(checkTwoPersonRule(pl, m, "U2"), ErrTwoPersonRule))
+	require.NoError(t, checkTwoPersonRule(pl, m, "U3"))
+	require.Equal(t, []string{"U2", "U1"}, m.RequesterSlackIDs())
+
+	m.RequesterSlackID = ""
+	m.StackedRequesterSlackIDs = nil
+	require.NoError(t, checkTwoPersonRule(pl, m, "U1"))
+
+	m = DeployMetadata{Project: "myapp", Phase: "staging", RequesterSlackID: "U1"}
+	require.NoError(t, checkTwoPersonRule(pl, m, "U1"))
+}
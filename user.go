@@ -5,28 +5,68 @@ import (
 	"strings"
 
 	"github.com/slack-go/slack"
+	"k8s.io/api/core/v1"
 )
 
 type User struct {
 	SlackUserID      string
 	SlackDisplayName string
-	GitHubUserName   string
-	GitHubNodeID     string
-	isDeveloper      bool
+	// Workspace is the name of the Slack workspace of the user.
+	Workspace      string
+	GitHubUserName string
+	GitHubNodeID   string
+	isDeveloper    bool
+	isAdmin        bool
+	isViewer       bool
 }
 
 func (u User) IsDeveloper() bool {
 	return u.isDeveloper
 }
 
+// IsAdmin returns true if the user is allowed to run the commands affecting all the projects, like emergency-stop.
+func (u User) IsAdmin() bool {
+	return u.isAdmin
+}
+
+// IsViewer returns true if the user is bound to the read-only Viewer role, which is allowed to run the read-only commands
+// like ls, status, history, and diff, but not to deploy nor lock, unless the user is a developer as well.
+func (u User) IsViewer() bool {
+	return u.isViewer
+}
+
+// CanView returns true if the user has any role, all of which are allowed to run the read-only commands.
+func (u User) CanView() bool {
+	return u.isViewer || u.isDeveloper || u.isAdmin
+}
+
 type UserList struct {
 	Items       []User
 	github      GitHub
 	slackClient *slack.Client
+	// workspace is the name of the Slack workspace of the users, and primary is true for the one of CONFIG_SLACK_OAUTH_TOKEN.
+	workspace string
+	primary   bool
+	// syncByEmail maps the users missing in the githubuser-mapping configmaps to the members of the GitHub organization
+	// with the same emails as their Slack profiles.
+	syncByEmail bool
+}
+
+// bound returns true if the entry of the rolebinding is the user. The entry is either the display name qualified with the workspace,
+// like dev/alice, or the unqualified display name, which is only of the primary workspace, so that the user with the same name
+// in another workspace doesn't get the role.
+func (ul UserList) bound(entry string, user User) bool {
+	workspace, name := splitWorkspaceRef(entry)
+	if workspace == "" {
+		return ul.primary && name == user.SlackDisplayName
+	}
+	return workspace == ul.workspace && name == user.SlackDisplayName
 }
 
+// Reload replaces the users with the ones loaded from Slack, GitHub, and the configmaps.
+// The users loaded before are kept if any of them fails, as the list is read while reloaded in the background.
 func (ul *UserList) Reload() {
-	ul.Items = []User{}
+	items := []User{}
 
 	slackUsers, err := ul.slackClient.GetUsers()
 	if err != nil {
@@ -42,31 +82,67 @@ func (ul *UserList) Reload() {
 
 	cml := getConfigMapList("githubuser-mapping")
 	rolebindings := getConfigMapList("rolebinding")
+	if cml == nil || rolebindings == nil {
+		fmt.Println("[ERROR] Cannot load the GitHub user mappings and the rolebindings")
+		return
+	}
+	cml.Items = readableConfigMaps("githubuser-mapping", cml.Items)
+	rolebindings.Items = readableConfigMaps("rolebinding", rolebindings.Items)
+
+	var emails map[string]string
+	if ul.syncByEmail {
+		if emails, err = ul.github.GetMemberEmails(); err != nil {
+			// The users are still mapped by the configmaps
+			fmt.Println("[ERROR] Cannot load the emails of GitHub users: ", err)
+		}
+	}
 
 	for _, slackUser := range slackUsers {
 		if slackUser.IsBot || slackUser.Deleted {
 			continue
 		}
-		user := User{SlackUserID: slackUser.ID, SlackDisplayName: slackUser.Profile.DisplayName}
-		for _, cm := range cml.Items {
-			if cm.Data[user.SlackDisplayName] != "" {
-				user.GitHubUserName = cm.Data[user.SlackDisplayName]
-				break
-			}
-		}
+		user := User{SlackUserID: slackUser.ID, SlackDisplayName: slackUser.Profile.DisplayName, Workspace: ul.workspace}
+		user.GitHubUserName = githubUserName(user.SlackDisplayName, slackUser.Profile.Email, cml.Items, emails)
 		user.GitHubNodeID = githubUsers[user.GitHubUserName]
 		for _, rolebinding := range rolebindings.Items {
 			raw := rolebinding.Data["Developer"]
 			userNames := strings.Split(raw, "\n")
 			for _, userName := range userNames {
-				if user.SlackDisplayName == userName {
+				if ul.bound(userName, user) {
 					user.isDeveloper = true
 					break
 				}
 			}
+			for _, userName := range strings.Split(rolebinding.Data["Admin"], "\n") {
+				if ul.bound(userName, user) {
+					user.isAdmin = true
+					break
+				}
+			}
+			for _, userName := range strings.Split(rolebinding.Data["Viewer"], "\n") {
+				if ul.bound(userName, user) {
+					user.isViewer = true
+					break
+				}
+			}
+		}
+		items = append(items, user)
+	}
+	ul.Items = items
+}
+
+// githubUserName returns the GitHub login of the Slack user, mapped by the githubuser-mapping configmaps,
+// or by the email of the Slack profile if it's missing in them.
+func githubUserName(displayName, email string, mappings []v1.ConfigMap, emails map[string]string) string {
+	for _, cm := range mappings {
+		if cm.Data[displayName] != "" {
+			return cm.Data[displayName]
 		}
-		ul.Items = append(ul.Items, user)
 	}
+	if email == "" {
+		return ""
+	}
+	return emails[strings.ToLower(email)]
 }
 
 func (ul UserList) FindBySlackUserID(slackUserID string) User {
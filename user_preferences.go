@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// userPreferencesConfigMapType is the type of the configmaps the preferences of the users are stored in, one for each user.
+const userPreferencesConfigMapType = "user-preferences"
+
+// UserPreferences are the preferences each user sets by the prefs command.
+type UserPreferences struct {
+	// Notify is where the results of the deploys the user requested are notified to, which is channel or dm.
+	// dm sends them to the user by DM in addition to the channel the deploy was requested in.
+	Notify string
+	// DefaultPhase is the phase deployed by `deploy <project>` without the phase.
+	DefaultPhase string
+	// Locale is the language of the messages of the deploys the user requested, which is ja or en.
+	Locale string
+}
+
+// userPreferenceKeys are the keys of the prefs command, with the values they accept if limited.
+var userPreferenceKeys = map[string][]string{
+	"notify":        {"channel", "dm"},
+	"default-phase": {"staging", "production", "sandbox"},
+	"locale":        {"ja", "en"},
+}
+
+// Set sets the preference of the key, like notify, to the value. An empty value resets it to the default.
+func (p *UserPreferences) Set(key, value string) error {
+	values, ok := userPreferenceKeys[key]
+	if !ok {
+		var keys []string
+		for k := range userPreferenceKeys {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return fmt.Errorf("unknown preference %s. Available preferences: %s", key, strings.Join(keys, ", "))
+	}
+	valid := value == ""
+	for _, v := range values {
+		valid = valid || v == value
+	}
+	if !valid {
+		return fmt.Errorf("invalid %s %s. It's one of %s", key, value, strings.Join(values, ", "))
+	}
+	switch key {
+	case "notify":
+		p.Notify = value
+	case "default-phase":
+		p.DefaultPhase = value
+	case "locale":
+		p.Locale = value
+	}
+	return nil
+}
+
+func (p UserPreferences) data() map[string]string {
+	return map[string]string{"Notify": p.Notify, "DefaultPhase": p.DefaultPhase, "Locale": p.Locale}
+}
+
+func (p UserPreferences) String() string {
+	or := func(v, d string) string {
+		if v == "" {
+			return d + " (default)"
+		}
+		return v
+	}
+	return fmt.Sprintf("• notify: %s\n• default-phase: %s\n• locale: %s", or(p.Notify, "channel"), or(p.DefaultPhase, "none"), or(p.Locale, "ja"))
+}
+
+// userMessages are the messages of the deploys in the locales of UserPreferences, formatted with fmt.Sprintf.
+// Every locale has the same keys and takes the same arguments, which the ones in a different order refer to by their indexes.
+var userMessages = map[string]map[string]string{
+	"ja": {
+		// confirm asks to approve the deploy of the branch, for all the kinds.
+		"confirm": "*%s* ブランチをデプロイしますか?",
+		// nonDefaultBranch warns of the deploy of the branch other than the default branch to production.
+		"nonDefaultBranch": "本番環境に %s ブランチ以外をデプロイしようとしています",
+		"deployed":         "*%s* ブランチをデプロイしました",
+		// failed is sent by DM with the project, the phase, and the error.
+		"failed": "*%s* *%s* のデプロイに失敗しました: %s",
+		// merged is sent by DM with the project, the phase, the pull request, and the approver.
+		"merged": "*%[1]s* *%[2]s*: <@%[4]s> が %[3]s をマージしました",
+	},
+	"en": {
+		"confirm":          "Deploy the *%s* branch?",
+		"nonDefaultBranch": "You're deploying a branch other than %s to production",
+		"deployed":         "Deployed the *%s* branch",
+		"failed":           "The deploy of *%s* *%s* failed: %s",
+		"merged":           "*%s* *%s*: merged %s by <@%s>",
+	},
+}
+
+// Message returns the message of the key in the locale of the user.
+func (p UserPreferences) Message(key string, args ...interface{}) string {
+	messages, ok := userMessages[p.Locale]
+	if !ok {
+		messages = userMessages["ja"]
+	}
+	return fmt.Sprintf(messages[key], args...)
+}
+
+// UserPreferenceStore stores the preferences of the users in the configmaps, caching them in memory.
+//
+// The methods are safe to call on nil, which returns the default preferences.
+type UserPreferenceStore struct {
+	// clientMu guards clientset, which is created on the first use, as the preferences are saved by the commands run concurrently.
+	clientMu  sync.Mutex
+	clientset kubernetes.Interface
+	namespace string
+	mu        sync.RWMutex
+	cache     map[string]UserPreferences
+}
+
+func NewUserPreferenceStore(namespace string) *UserPreferenceStore {
+	return &UserPreferenceStore{namespace: namespace, cache: map[string]UserPreferences{}}
+}
+
+func (s *UserPreferenceStore) client() (kubernetes.Interface, error) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if s.clientset != nil {
+		return s.clientset, nil
+	}
+	client, err := newKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	s.clientset = client
+	return client, nil
+}
+
+func userPreferencesConfigMapName(userID string) string {
+	return "user-preferences-" + strings.ToLower(userID)
+}
+
+// Load loads the preferences of all the users into the cache.
+func (s *UserPreferenceStore) Load(ctx context.Context) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	cml, err := client.CoreV1().ConfigMaps(s.namespace).List(ctx, meta_v1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", configMapTypeLabel, userPreferencesConfigMapType)})
+	if err != nil {
+		return fmt.Errorf("unable to list the user preferences: %w", err)
+	}
+	cache := map[string]UserPreferences{}
+	for _, cm := range cml.Items {
+		cache[cm.Data["UserID"]] = UserPreferences{Notify: cm.Data["Notify"], DefaultPhase: cm.Data["DefaultPhase"], Locale: cm.Data["Locale"]}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = cache
+	return nil
+}
+
+// Get returns the preferences of the user.
+func (s *UserPreferenceStore) Get(userID string) UserPreferences {
+	if s == nil {
+		return UserPreferences{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cache[userID]
+}
+
+// Save creates or updates the configmap of the preferences of the user.
+func (s *UserPreferenceStore) Save(ctx context.Context, userID string, p UserPreferences) error {
+	if s == nil {
+		return fmt.Errorf("the user preferences aren't available")
+	}
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	data := p.data()
+	data["UserID"] = userID
+	name := userPreferencesConfigMapName(userID)
+	configMaps := client.CoreV1().ConfigMaps(s.namespace)
+	cm, err := configMaps.Get(ctx, name, meta_v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: map[string]string{configMapTypeLabel: userPreferencesConfigMapType}},
+			Data:       data,
+		}
+		if _, err := configMaps.Create(ctx, cm, meta_v1.CreateOptions{}); err != nil {
+			return fmt.Errorf("unable to create %s: %w", name, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("unable to get %s: %w", name, err)
+	} else {
+		cm.Data = data
+		if _, err := configMaps.Update(ctx, cm, meta_v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("unable to update %s: %w", name, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[userID] = p
+	return nil
+}
+
+// notifyByDM sends the text to the user by DM if the user prefers it, logging the failure
+// as it's only a copy of the message posted to the channel.
+func notifyByDM(client *slack.Client, prefs *UserPreferenceStore, userID, text string) {
+	if userID == "" || prefs.Get(userID).Notify != "dm" {
+		return
+	}
+	if _, _, err := client.PostMessage(userID, slack.MsgOptionText(text, false)); err != nil {
+		log.Printf("[ERROR] Failed to notify %s by DM: %s", userID, err)
+	}
+}
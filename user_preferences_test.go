@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestUserPreferenceStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewUserPreferenceStore("default")
+	store.clientset = fake.NewSimpleClientset()
+
+	p := store.Get("U1")
+	require.NoError(t, p.Set("notify", "dm"))
+	require.NoError(t, p.Set("locale", "en"))
+	require.Error(t, p.Set("locale", "fr"))
+	require.EqualError(t, p.Set("color", "red"), "unknown preference color. Available preferences: default-phase, locale, notify")
+	require.NoError(t, store.Save(ctx, "U1", p))
+	require.NoError(t, p.Set("locale", ""))
+	require.NoError(t, store.Save(ctx, "U1", p))
+
+	// The preferences are persisted in the configmaps
+	loaded := NewUserPreferenceStore("default")
+	loaded.clientset = store.clientset
+	require.NoError(t, loaded.Load(ctx))
+	require.Equal(t, UserPreferences{Notify: "dm"}, loaded.Get("U1"))
+	require.Equal(t, UserPreferences{}, loaded.Get("U2"))
+	require.Equal(t, "*main* ブランチをデプロイしますか?", loaded.Get("U1").Message("confirm", "main"))
+	require.Equal(t, "Deploy the *main* branch?", UserPreferences{Locale: "en"}.Message("confirm", "main"))
+
+	var nilStore *UserPreferenceStore
+	require.Equal(t, UserPreferences{}, nilStore.Get("U1"))
+}
+
+func TestUserMessages(t *testing.T) {
+	// Every locale has the messages of the others
+	for locale, messages := range userMessages {
+		for other, otherMessages := range userMessages {
+			for key := range otherMessages {
+				require.Contains(t, messages, key, "%s lacks %s of %s", locale, key, other)
+			}
+		}
+	}
+	url := "https://github.com/zaiminc/manifests/pull/1"
+	require.Equal(t, "*myapp* *production*: merged "+url+" by <@U1>", UserPreferences{Locale: "en"}.Message("merged", "myapp", "production", url, "U1"))
+	require.Equal(t, "*myapp* *production*: <@U1> が "+url+" をマージしました", UserPreferences{}.Message("merged", "myapp", "production", url, "U1"))
+}
+
+func TestWithDefaultPhase(t *testing.T) {
+	prefs := NewUserPreferenceStore("default")
+	prefs.cache["U1"] = UserPreferences{DefaultPhase: "staging"}
+	s := &SlackListener{prefs: prefs}
+
+	require.Equal(t, "<@UBOT> deploy myapp staging", s.withDefaultPhase("<@UBOT> deploy myapp ", "U1"))
+	require.Equal(t, "<@UBOT> deploy myapp production", s.withDefaultPhase("<@UBOT> deploy myapp production", "U1"))
+	require.Equal(t, "<@UBOT> deploy production", s.withDefaultPhase("<@UBOT> deploy production", "U1"))
+	require.Equal(t, "<@UBOT> deploy myapp", s.withDefaultPhase("<@UBOT> deploy myapp", "U2"))
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+)
+
+func TestGitHubUserName(t *testing.T) {
+	mappings := []v1.ConfigMap{{Data: map[string]string{"alice": "alice-gh"}}}
+	emails := map[string]string{"alice@example.com": "alice-by-email", "bob@example.com": "bob-gh"}
+
+	// The configmaps take precedence over the emails
+	require.Equal(t, "alice-gh", githubUserName("alice", "alice@example.com", mappings, emails))
+	require.Equal(t, "bob-gh", githubUserName("bob", "Bob@Example.com", mappings, emails))
+	require.Equal(t, "", githubUserName("carol", "carol@example.com", mappings, emails))
+	require.Equal(t, "", githubUserName("dave", "", mappings, map[string]string{"": "nobody"}))
+	require.Equal(t, "", githubUserName("bob", "bob@example.com", mappings, nil))
+}
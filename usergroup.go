@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// slackUserGroupsTTL is how long the user groups are cached.
+// It's short as the members of the on-call groups change with the rotation.
+const slackUserGroupsTTL = 5 * time.Minute
+
+type slackUserGroupsAPI interface {
+	GetUserGroups(options ...slack.GetUserGroupsOption) ([]slack.UserGroup, error)
+}
+
+// SlackUserGroups resolves the references to the Slack users in the notification config, like escalateTo,
+// which are either the user IDs, like U0123, or the handles of the user groups, like @payments-oncall.
+//
+// The user groups are expanded to their current members, so that the on-call of the rotation synced to a group,
+// like by PagerDuty, gets the DMs. It needs the usergroups:read scope.
+//
+// The methods are safe to call on nil, which leaves the handles unresolved.
+type SlackUserGroups struct {
+	client    slackUserGroupsAPI
+	mu        sync.Mutex
+	groups    []slack.UserGroup
+	fetchedAt time.Time
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewSlackUserGroups(client slackUserGroupsAPI) *SlackUserGroups {
+	return &SlackUserGroups{client: client, now: time.Now}
+}
+
+// isUserGroupRef returns true if the reference is the handle of a user group.
+func isUserGroupRef(ref string) bool {
+	return strings.HasPrefix(ref, "@")
+}
+
+// find returns the user group of the handle, fetching the user groups if the cache is expired.
+func (g *SlackUserGroups) find(handle string) (slack.UserGroup, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.groups == nil || g.now().Sub(g.fetchedAt) > slackUserGroupsTTL {
+		groups, err := g.client.GetUserGroups(slack.GetUserGroupsOptionIncludeUsers(true))
+		if err != nil {
+			return slack.UserGroup{}, fmt.Errorf("unable to get the user groups: %w", err)
+		}
+		g.groups, g.fetchedAt = groups, g.now()
+	}
+	for _, group := range g.groups {
+		if group.Handle == strings.TrimPrefix(handle, "@") {
+			return group, nil
+		}
+	}
+	return slack.UserGroup{}, fmt.Errorf("user group %s not found", handle)
+}
+
+// Members returns the user IDs of the references with the user groups expanded to their current members, without duplicates.
+// The user groups failed to resolve are skipped, and the last error is returned along with the rest of the members.
+func (g *SlackUserGroups) Members(refs []string) ([]string, error) {
+	var (
+		users   []string
+		lastErr error
+	)
+	seen := map[string]bool{}
+	add := func(user string) {
+		if !seen[user] {
+			seen[user] = true
+			users = append(users, user)
+		}
+	}
+	for _, ref := range refs {
+		if !isUserGroupRef(ref) {
+			add(ref)
+			continue
+		}
+		if g == nil {
+			lastErr = fmt.Errorf("user group %s can't be resolved", ref)
+			continue
+		}
+		group, err := g.find(ref)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, user := range group.Users {
+			add(user)
+		}
+	}
+	return users, lastErr
+}
+
+// Mentions returns the mentions of the references, which notify the user groups as a whole.
+// The user groups failed to resolve are left as their handles.
+func (g *SlackUserGroups) Mentions(refs []string) string {
+	var ms []string
+	for _, ref := range refs {
+		if !isUserGroupRef(ref) {
+			ms = append(ms, fmt.Sprintf("<@%s>", ref))
+			continue
+		}
+		if g == nil {
+			ms = append(ms, ref)
+			continue
+		}
+		group, err := g.find(ref)
+		if err != nil {
+			ms = append(ms, ref)
+			continue
+		}
+		ms = append(ms, fmt.Sprintf("<!subteam^%s|%s>", group.ID, ref))
+	}
+	return strings.Join(ms, ", ")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSlackUserGroups struct {
+	groups []slack.UserGroup
+	calls  int
+}
+
+func (f *fakeSlackUserGroups) GetUserGroups(options ...slack.GetUserGroupsOption) ([]slack.UserGroup, error) {
+	f.calls++
+	return f.groups, nil
+}
+
+func TestSlackUserGroups(t *testing.T) {
+	api := &fakeSlackUserGroups{groups: []slack.UserGroup{
+		{ID: "S01", Handle: "payments-oncall", Users: []string{"U02"}},
+	}}
+	g := NewSlackUserGroups(api)
+	now := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+
+	users, err := g.Members([]string{"U01", "@payments-oncall", "U02"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"U01", "U02"}, users)
+	require.Equal(t, "<@U01>, <!subteam^S01|@payments-oncall>", g.Mentions([]string{"U01", "@payments-oncall"}))
+	require.Equal(t, 1, api.calls)
+
+	// The on-call rotates
+	api.groups[0].Users = []string{"U03"}
+	now = now.Add(slackUserGroupsTTL + time.Second)
+	users, err = g.Members([]string{"@payments-oncall"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"U03"}, users)
+
+	users, err = g.Members([]string{"U01", "@unknown"})
+	require.EqualError(t, err, "user group @unknown not found")
+	require.Equal(t, []string{"U01"}, users)
+	require.Equal(t, "@unknown", g.Mentions([]string{"@unknown"}))
+
+	var nilGroups *SlackUserGroups
+	require.Equal(t, "<@U01>, @payments-oncall", nilGroups.Mentions([]string{"U01", "@payments-oncall"}))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// workflowStepCallbackID is the callback ID of the gocat deploy step registered in the Slack app manifest.
+// See https://api.slack.com/workflows/steps for more details about workflow steps.
+const workflowStepCallbackID = "gocat_deploy"
+
+// workflowStepInputs are the inputs of the gocat deploy step.
+// Each of them is configurable in Workflow Builder, either as a fixed value or a workflow variable,
+// like "Person who clicked" for the requester.
+var workflowStepInputs = []struct {
+	name        string
+	label       string
+	placeholder string
+	optional    bool
+}{
+	{"project", "Project", "Project ID or alias", false},
+	{"phase", "Phase", "staging, production, ...", false},
+	{"branch", "Branch", "Default branch of the project if empty", true},
+	{"requester", "Requester", "User requesting the deploy", false},
+	{"channel", "Channel", "Channel to post the approval message to", false},
+	{"reason", "Reason", "Reason of the deploy, like a ticket reference", true},
+}
+
+// openWorkflowStepConfiguration opens the configuration modal of the gocat deploy step
+// when it's added to or edited in Workflow Builder.
+func (h interactionHandler) openWorkflowStepConfiguration(callback slack.InteractionCallback) error {
+	var inputs slack.WorkflowStepInputs
+	if callback.WorkflowStep.Inputs != nil {
+		inputs = *callback.WorkflowStep.Inputs
+	}
+
+	var blocks []slack.Block
+	for _, in := range workflowStepInputs {
+		element := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", in.placeholder, false, false), in.name)
+		element.InitialValue = inputs[in.name].Value
+		block := slack.NewInputBlock(in.name, slack.NewTextBlockObject("plain_text", in.label, false, false), nil, element)
+		block.Optional = in.optional
+		blocks = append(blocks, block)
+	}
+
+	modal := slack.NewConfigurationModalRequest(slack.Blocks{BlockSet: blocks}, "", "")
+	modal.CallbackID = workflowStepCallbackID
+	_, err := h.client.OpenView(callback.TriggerID, modal.ModalViewRequest)
+	return err
+}
+
+// saveWorkflowStepConfiguration saves the inputs submitted in the configuration modal to the step.
+func (h interactionHandler) saveWorkflowStepConfiguration(callback slack.InteractionCallback) error {
+	inputs := slack.WorkflowStepInputs{}
+	for _, in := range workflowStepInputs {
+		value := callback.View.State.Values[in.name][in.name].Value
+		if value == "" {
+			continue
+		}
+		inputs[in.name] = slack.WorkflowStepInputElement{Value: value}
+	}
+	return h.client.SaveWorkflowStepConfiguration(callback.WorkflowStep.WorkflowStepEditID, &inputs, &[]slack.WorkflowStepOutput{})
+}
+
+// handleWorkflowStepExecuteEvent requests the deploy configured in the step, in the same way as the deploy command,
+// and reports the result back to the workflow.
+func (s *SlackListener) handleWorkflowStepExecuteEvent(ev *slackevents.WorkflowStepExecuteEvent) {
+	defer s.recoverer.Recover("the workflow step", "")
+	step := ev.WorkflowStep
+	if err := s.executeWorkflowStep(step); err != nil {
+		log.Printf("[ERROR] Failed to execute workflow step %s: %s", step.WorkflowStepExecuteID, err)
+		if err := s.client.WorkflowStepFailed(step.WorkflowStepExecuteID, err.Error()); err != nil {
+			log.Println("[ERROR] ", err)
+		}
+		return
+	}
+	if err := s.client.WorkflowStepCompleted(step.WorkflowStepExecuteID); err != nil {
+		log.Println("[ERROR] ", err)
+	}
+}
+
+func (s *SlackListener) executeWorkflowStep(step slackevents.EventWorkflowStep) error {
+	t, err := parseWorkflowStepInputs(step)
+	if err != nil {
+		return err
+	}
+	pj, err := s.projectList.FindByAlias(t.Project)
+	if err != nil {
+		return err
+	}
+	// The phase is configured once in Workflow Builder, so a typo fails the step instead of falling back to staging as toPhase does
+	if phase := s.toPhase(t.Phase); !isPhaseAlias(t.Phase) || pj.FindPhase(phase).Name == "" {
+		return fmt.Errorf("phase %s not found for project %s", t.Phase, pj.ID)
+	}
+	return s.requestTriggeredDeploy(t)
+}
+
+// parseWorkflowStepInputs returns the deploy the inputs of the step configure.
+func parseWorkflowStepInputs(step slackevents.EventWorkflowStep) (DeployTrigger, error) {
+	if step.Inputs == nil {
+		return DeployTrigger{}, fmt.Errorf("the step has no inputs. Please configure it in Workflow Builder")
+	}
+	inputs := *step.Inputs
+	requester := slackID(inputs["requester"].Value)
+	channel := slackID(inputs["channel"].Value)
+	if requester == "" || channel == "" {
+		return DeployTrigger{}, fmt.Errorf("requester and channel are required")
+	}
+	return DeployTrigger{
+		Project:   strings.TrimSpace(inputs["project"].Value),
+		Phase:     strings.TrimSpace(inputs["phase"].Value),
+		Branch:    strings.TrimSpace(inputs["branch"].Value),
+		Reason:    inputs["reason"].Value,
+		Requester: requester,
+		Channel:   channel,
+	}, nil
+}
+
+// slackID returns the ID of the user or the channel referenced by s,
+// which is either a bare ID or a mention like <@U0123456> or <#C0123456|general> given by workflow variables.
+func slackID(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "<")
+	s = strings.TrimSuffix(s, ">")
+	s = strings.TrimLeft(s, "@#!")
+	if i := strings.Index(s, "|"); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
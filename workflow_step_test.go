@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/stretchr/testify/require"
+)
+
+func workflowStep(inputs map[string]string) slackevents.EventWorkflowStep {
+	in := slack.WorkflowStepInputs{}
+	for name, value := range inputs {
+		in[name] = slack.WorkflowStepInputElement{Value: value}
+	}
+	return slackevents.EventWorkflowStep{WorkflowStepExecuteID: "E1", Inputs: &in}
+}
+
+func TestParseWorkflowStepInputs(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		step   slackevents.EventWorkflowStep
+		want   DeployTrigger
+		errMsg string
+	}{
+		{
+			name: "mentions of the workflow variables",
+			step: workflowStep(map[string]string{"project": "myapp", "phase": "production", "branch": "release/1.0", "requester": "<@U0123456>", "channel": "<#C0123456|deploys>", "reason": "JIRA-1"}),
+			want: DeployTrigger{Project: "myapp", Phase: "production", Branch: "release/1.0", Reason: "JIRA-1", Requester: "U0123456", Channel: "C0123456"},
+		},
+		{
+			name: "bare IDs with the default branch",
+			step: workflowStep(map[string]string{"project": "myapp", "phase": "stg", "requester": "U0123456", "channel": "C0123456"}),
+			want: DeployTrigger{Project: "myapp", Phase: "stg", Requester: "U0123456", Channel: "C0123456"},
+		},
+		{
+			name:   "not configured",
+			step:   slackevents.EventWorkflowStep{WorkflowStepExecuteID: "E1"},
+			errMsg: "the step has no inputs. Please configure it in Workflow Builder",
+		},
+		{
+			name:   "no requester",
+			step:   workflowStep(map[string]string{"project": "myapp", "phase": "production", "channel": "C0123456"}),
+			errMsg: "requester and channel are required",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseWorkflowStepInputs(c.step)
+			if c.errMsg != "" {
+				require.EqualError(t, err, c.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestSlackListener_executeWorkflowStepUnknownTarget(t *testing.T) {
+	s := &SlackListener{projectList: &ProjectList{items: []DeployProject{{ID: "myapp", Alias: "^myapp$", Phases: []DeployPhase{{Name: "staging"}}}}}}
+
+	err := s.executeWorkflowStep(workflowStep(map[string]string{"project": "yourapp", "phase": "staging", "requester": "U1", "channel": "C1"}))
+	var notFound *ProjectNotFoundError
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, "yourapp", notFound.ID)
+
+	// The unknown phases aren't taken for staging
+	err = s.executeWorkflowStep(workflowStep(map[string]string{"project": "myapp", "phase": "qa", "requester": "U1", "channel": "C1"}))
+	require.EqualError(t, err, "phase qa not found for project myapp")
+
+	err = s.executeWorkflowStep(workflowStep(map[string]string{"project": "myapp", "phase": "production", "requester": "U1", "channel": "C1"}))
+	require.EqualError(t, err, "phase production not found for project myapp")
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// defaultSlackWorkspaceName is the name of the workspace of CONFIG_SLACK_OAUTH_TOKEN when CONFIG_SLACK_WORKSPACE_NAME is empty.
+const defaultSlackWorkspaceName = "default"
+
+// SlackWorkspaceConfig is an entry of CONFIG_SLACK_WORKSPACES, which installs gocat to the workspaces
+// other than the one of CONFIG_SLACK_OAUTH_TOKEN, like the one for the development environments.
+type SlackWorkspaceConfig struct {
+	// Name is the name of the workspace the projects, the rolebindings, and the notifications are qualified with, like dev.
+	Name              string `json:"name"`
+	OAuthToken        string `json:"oauthToken"`
+	VerificationToken string `json:"verificationToken"`
+	// AnnouncementChannel is the ID of the channel the production deploys of the projects of the workspace are announced in,
+	// like CONFIG_ANNOUNCEMENT_CHANNEL is for the primary workspace. They're announced in CONFIG_ANNOUNCEMENT_CHANNEL if empty.
+	AnnouncementChannel string `json:"announcementChannel"`
+}
+
+// ParseSlackWorkspaceConfigs parses the JSON array of SlackWorkspaceConfig. It returns nil for an empty string.
+func ParseSlackWorkspaceConfigs(s string) ([]SlackWorkspaceConfig, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var configs []SlackWorkspaceConfig
+	if err := json.Unmarshal([]byte(s), &configs); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, c := range configs {
+		if c.Name == "" || strings.Contains(c.Name, "/") {
+			return nil, fmt.Errorf("invalid workspace name %q", c.Name)
+		}
+		if c.OAuthToken == "" || c.VerificationToken == "" {
+			return nil, fmt.Errorf("oauthToken and verificationToken of workspace %s are required", c.Name)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("duplicate workspace name %s", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	return configs, nil
+}
+
+// SlackWorkspace is a Slack workspace gocat is installed to, with its own bot and users.
+type SlackWorkspace struct {
+	Name string
+	// TeamID is the ID of the workspace the events and the interactions are routed by.
+	TeamID            string
+	client            *slack.Client
+	verificationToken string
+	// announcementChannel is the channel the production deploys of the projects of the workspace are announced in. See Announcer.
+	announcementChannel string
+	userList            *UserList
+	userGroups          *SlackUserGroups
+	interactorFactory   *InteractorFactory
+}
+
+// SlackWorkspaces is the set of the workspaces gocat is installed to.
+// The first one is the primary workspace of CONFIG_SLACK_OAUTH_TOKEN, which the unqualified rolebindings,
+// the unscoped projects, and the announcements belong to.
+//
+// The methods are safe to call on nil, which means gocat is installed to a single workspace.
+type SlackWorkspaces struct {
+	// mu guards items, which the workspaces installed by the Add to Slack button are added to while serving.
+	mu    sync.RWMutex
+	items []*SlackWorkspace
+}
+
+func NewSlackWorkspaces(primary *SlackWorkspace) *SlackWorkspaces {
+	return &SlackWorkspaces{items: []*SlackWorkspace{primary}}
+}
+
+// Add adds the workspace, or replaces the one of the same name, like the one reinstalled with a new token.
+func (w *SlackWorkspaces) Add(ws *SlackWorkspace) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, item := range w.items {
+		if item.Name == ws.Name {
+			w.items[i] = ws
+			return
+		}
+	}
+	w.items = append(w.items, ws)
+}
+
+// All returns the workspaces, with the primary one first.
+func (w *SlackWorkspaces) All() []*SlackWorkspace {
+	if w == nil {
+		return nil
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return append([]*SlackWorkspace(nil), w.items...)
+}
+
+// Multiple returns true if gocat is installed to more than one workspace,
+// in which case the requests are authenticated and routed by their team IDs.
+func (w *SlackWorkspaces) Multiple() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.items) > 1
+}
+
+// ResolveTeamIDs fills the team IDs of the workspaces missing them by their bot tokens.
+// The lock isn't held while asking Slack, so that the events of the other workspaces are routed meanwhile.
+func (w *SlackWorkspaces) ResolveTeamIDs() error {
+	w.mu.RLock()
+	var unresolved []*SlackWorkspace
+	for _, ws := range w.items {
+		if ws.TeamID == "" {
+			unresolved = append(unresolved, ws)
+		}
+	}
+	w.mu.RUnlock()
+
+	for _, ws := range unresolved {
+		resp, err := ws.client.AuthTest()
+		if err != nil {
+			return fmt.Errorf("unable to get the team ID of workspace %s: %w", ws.Name, err)
+		}
+		w.mu.Lock()
+		ws.TeamID = resp.TeamID
+		w.mu.Unlock()
+		log.Printf("[INFO] Slack workspace %s is %s (%s)", ws.Name, resp.Team, resp.TeamID)
+	}
+	return nil
+}
+
+func (w *SlackWorkspaces) Primary() *SlackWorkspace {
+	if w == nil {
+		return nil
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if len(w.items) == 0 {
+		return nil
+	}
+	return w.items[0]
+}
+
+func (w *SlackWorkspaces) ByName(name string) *SlackWorkspace {
+	if w == nil {
+		return nil
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, ws := range w.items {
+		if ws.Name == name {
+			return ws
+		}
+	}
+	return nil
+}
+
+// ByClient returns the workspace of the bot client.
+func (w *SlackWorkspaces) ByClient(client *slack.Client) *SlackWorkspace {
+	if w == nil {
+		return nil
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, ws := range w.items {
+		if ws.client == client {
+			return ws
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the workspace of the team ID if the verification token is the one of the workspace.
+// The team ID is empty for the URL verification, which is accepted with the token of any workspace.
+func (w *SlackWorkspaces) Authenticate(teamID, token string) (*SlackWorkspace, bool) {
+	if w == nil {
+		return nil, false
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, ws := range w.items {
+		if (teamID == "" || ws.TeamID == teamID) && ws.verificationToken == token {
+			return ws, true
+		}
+	}
+	return nil, false
+}
+
+// ForProject returns the workspace the notifications of the project go to, which is the first workspace
+// the project is scoped to, or the primary workspace for the unscoped projects.
+func (w *SlackWorkspaces) ForProject(pj DeployProject) *SlackWorkspace {
+	if len(pj.Workspaces) > 0 {
+		if ws := w.ByName(pj.Workspaces[0]); ws != nil {
+			return ws
+		}
+	}
+	return w.Primary()
+}
+
+// ClientFor returns the bot client of the workspace the notifications of the project go to. See ForProject.
+// It returns fallback if no workspace is known, like in the tests.
+func (w *SlackWorkspaces) ClientFor(pj DeployProject, fallback *slack.Client) *slack.Client {
+	if ws := w.ForProject(pj); ws != nil && ws.client != nil {
+		return ws.client
+	}
+	return fallback
+}
+
+// splitWorkspaceRef splits the reference to a user or a user group qualified with the workspace, like dev/@oncall,
+// into the workspace and the reference. The workspace is empty for the unqualified references.
+func splitWorkspaceRef(ref string) (string, string) {
+	if i := strings.Index(ref, "/"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return "", ref
+}
+
+// group groups the references by their workspaces, where the unqualified ones belong to home.
+// The references to the unknown workspaces are returned separately.
+func (w *SlackWorkspaces) group(home *SlackWorkspace, refs []string) (map[*SlackWorkspace][]string, []*SlackWorkspace, []string) {
+	groups := map[*SlackWorkspace][]string{}
+	var (
+		order   []*SlackWorkspace
+		unknown []string
+	)
+	for _, ref := range refs {
+		ws := home
+		name, r := splitWorkspaceRef(ref)
+		if name != "" {
+			if ws = w.ByName(name); ws == nil {
+				unknown = append(unknown, ref)
+				continue
+			}
+		}
+		if _, ok := groups[ws]; !ok {
+			order = append(order, ws)
+		}
+		groups[ws] = append(groups[ws], r)
+	}
+	return groups, order, unknown
+}
+
+// GitHubLogins returns the GitHub logins of the users of the references, with the user groups expanded to their current members,
+// looked up in the user lists of their workspaces. The users with no GitHub login are skipped.
+func (w *SlackWorkspaces) GitHubLogins(home *SlackWorkspace, refs []string) ([]string, error) {
+	var (
+		logins  []string
+		lastErr error
+	)
+	groups, order, unknown := w.group(home, refs)
+	for _, ref := range unknown {
+		lastErr = fmt.Errorf("workspace of %s not found", ref)
+	}
+	for _, ws := range order {
+		if ws == nil || ws.userList == nil {
+			continue
+		}
+		users, err := ws.userGroups.Members(groups[ws])
+		if err != nil {
+			lastErr = err
+		}
+		for _, user := range users {
+			if login := ws.userList.FindBySlackUserID(user).GitHubUserName; login != "" {
+				logins = append(logins, login)
+			}
+		}
+	}
+	return logins, lastErr
+}
+
+// Mentions returns the mentions of the references in home. The references to the other workspaces,
+// which can't be mentioned in home, are left as they are.
+func (w *SlackWorkspaces) Mentions(home *SlackWorkspace, refs []string) string {
+	var ms []string
+	for _, ref := range refs {
+		name, r := splitWorkspaceRef(ref)
+		if name == "" || w.ByName(name) == home {
+			ms = append(ms, home.userGroups.Mentions([]string{r}))
+			continue
+		}
+		ms = append(ms, ref)
+	}
+	return strings.Join(ms, ", ")
+}
+
+// DM sends the text to the members of the references by the bot of the workspace each reference is qualified with,
+// or of home if unqualified. It returns the last error along the way, like the one of an unknown workspace.
+func (w *SlackWorkspaces) DM(home *SlackWorkspace, refs []string, text string) error {
+	var lastErr error
+	groups, order, unknown := w.group(home, refs)
+	for _, ref := range unknown {
+		lastErr = fmt.Errorf("workspace of %s not found", ref)
+	}
+	for _, ws := range order {
+		users, err := ws.userGroups.Members(groups[ws])
+		if err != nil {
+			lastErr = err
+		}
+		for _, user := range users {
+			if _, _, err := ws.client.PostMessage(user, slack.MsgOptionText(text, false)); err != nil {
+				log.Printf("[ERROR] Failed to DM %s in workspace %s: %s", user, ws.Name, err)
+			}
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSlackWorkspaceConfigs(t *testing.T) {
+	configs, err := ParseSlackWorkspaceConfigs(`[{"name": "dev", "oauthToken": "xoxb-1", "verificationToken": "v1"}]`)
+	require.NoError(t, err)
+	require.Equal(t, []SlackWorkspaceConfig{{Name: "dev", OAuthToken: "xoxb-1", VerificationToken: "v1"}}, configs)
+
+	configs, err = ParseSlackWorkspaceConfigs("")
+	require.NoError(t, err)
+	require.Nil(t, configs)
+
+	_, err = ParseSlackWorkspaceConfigs(`[{"name": "dev/ops", "oauthToken": "xoxb-1", "verificationToken": "v1"}]`)
+	require.Error(t, err)
+	_, err = ParseSlackWorkspaceConfigs(`[{"name": "dev", "oauthToken": "xoxb-1"}]`)
+	require.Error(t, err)
+	_, err = ParseSlackWorkspaceConfigs(`[{"name": "dev", "oauthToken": "xoxb-1", "verificationToken": "v1"}, {"name": "dev", "oauthToken": "xoxb-2", "verificationToken": "v2"}]`)
+	require.Error(t, err)
+}
+
+func TestSlackWorkspaces(t *testing.T) {
+	prod := &SlackWorkspace{Name: "prod-ops", TeamID: "T1", verificationToken: "v1"}
+	dev := &SlackWorkspace{Name: "dev", TeamID: "T2", verificationToken: "v2"}
+	workspaces := NewSlackWorkspaces(prod)
+	require.False(t, workspaces.Multiple())
+	workspaces.Add(dev)
+	require.True(t, workspaces.Multiple())
+
+	ws, ok := workspaces.Authenticate("T2", "v2")
+	require.True(t, ok)
+	require.Equal(t, dev, ws)
+	_, ok = workspaces.Authenticate("T2", "v1")
+	require.False(t, ok)
+	// URL verification has no team ID
+	_, ok = workspaces.Authenticate("", "v1")
+	require.True(t, ok)
+
+	require.Equal(t, prod, workspaces.ForProject(DeployProject{}))
+	require.Equal(t, dev, workspaces.ForProject(DeployProject{Workspaces: []string{"dev", "prod-ops"}}))
+
+	fallback := slack.New("xoxb-primary")
+	dev.client = slack.New("xoxb-dev")
+	require.Equal(t, dev.client, workspaces.ClientFor(DeployProject{Workspaces: []string{"dev"}}, fallback))
+	require.Equal(t, fallback, workspaces.ClientFor(DeployProject{}, fallback))
+	require.Equal(t, fallback, (*SlackWorkspaces)(nil).ClientFor(DeployProject{Workspaces: []string{"dev"}}, fallback))
+
+	groups, order, unknown := workspaces.group(prod, []string{"U1", "dev/@oncall", "staging/U2", "dev/U3"})
+	require.Equal(t, []*SlackWorkspace{prod, dev}, order)
+	require.Equal(t, []string{"U1"}, groups[prod])
+	require.Equal(t, []string{"@oncall", "U3"}, groups[dev])
+	require.Equal(t, []string{"staging/U2"}, unknown)
+
+	require.Equal(t, "<@U1>, dev/U3, <@U4>", workspaces.Mentions(prod, []string{"U1", "dev/U3", "prod-ops/U4"}))
+
+	var single *SlackWorkspaces
+	require.False(t, single.Multiple())
+	require.Nil(t, single.ForProject(DeployProject{Workspaces: []string{"dev"}}))
+}
+
+func TestProjectListInWorkspace(t *testing.T) {
+	all := &ProjectList{items: []DeployProject{
+		{ID: "shared"},
+		{ID: "payments", Workspaces: []string{"prod-ops"}},
+		{ID: "sandbox", Workspaces: []string{"dev"}},
+	}}
+	var ids []string
+	for _, pj := range all.InWorkspace("dev").All() {
+		ids = append(ids, pj.ID)
+	}
+	require.Equal(t, []string{"shared", "sandbox"}, ids)
+}
+
+func TestUserListBound(t *testing.T) {
+	alice := User{SlackDisplayName: "alice"}
+	primary := UserList{workspace: "prod-ops", primary: true}
+	dev := UserList{workspace: "dev"}
+
+	require.True(t, primary.bound("alice", alice))
+	require.True(t, primary.bound("prod-ops/alice", alice))
+	require.False(t, primary.bound("dev/alice", alice))
+	// The unqualified names are of the primary workspace only
+	require.False(t, dev.bound("alice", alice))
+	require.True(t, dev.bound("dev/alice", alice))
+	require.False(t, dev.bound("dev/bob", alice))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// The OverWrites returning the bytes of the file instead of the object to marshal, like the ones editing the YAML nodes,
+// keep the comments, the order of the keys, and the other documents of the file intact.
+// See marshalOverWrite.
+
+// marshalOverWrite returns the content of the file the OverWrite updated to obj.
+func marshalOverWrite(obj interface{}) ([]byte, error) {
+	if b, ok := obj.([]byte); ok {
+		return b, nil
+	}
+	return yaml.Marshal(&obj)
+}
+
+// decodeYAMLDocuments parses all the documents of the file into the nodes.
+func decodeYAMLDocuments(b []byte) ([]*yamlv3.Node, error) {
+	var docs []*yamlv3.Node
+	dec := yamlv3.NewDecoder(bytes.NewReader(b))
+	for {
+		var doc yamlv3.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, &doc)
+	}
+}
+
+// encodeYAMLDocuments renders the documents back into a file, separated by ---.
+func encodeYAMLDocuments(docs []*yamlv3.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlMapping returns the mapping of the document, or nil if it's not a mapping.
+func yamlMapping(doc *yamlv3.Node) *yamlv3.Node {
+	if doc.Kind == yamlv3.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	if doc.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	return doc
+}
+
+// yamlValue returns the value of the key of the mapping, or nil if it's missing.
+func yamlValue(m *yamlv3.Node, key string) *yamlv3.Node {
+	if m == nil || m.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlString returns the string value of the key of the mapping, or empty if it's missing or not a string.
+func yamlString(m *yamlv3.Node, key string) string {
+	if v := yamlValue(m, key); v != nil && v.Kind == yamlv3.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
+
+// deleteYAMLKey removes the key and its value from the mapping, if any.
+func deleteYAMLKey(m *yamlv3.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// setYAMLStrings sets the values in the mapping at the path as strings, creating the missing mappings along the way.
+func setYAMLStrings(m *yamlv3.Node, path []string, values map[string]string) {
+	for _, key := range path {
+		child := yamlValue(m, key)
+		if child == nil || child.Kind != yamlv3.MappingNode {
+			if child == nil {
+				child = &yamlv3.Node{}
+				m.Content = append(m.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: key}, child)
+			}
+			// Replaces the empty values, like annotations: {} or annotations: null
+			*child = yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		}
+		if len(child.Content) == 0 {
+			child.Style = 0
+		}
+		m = child
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: values[k]}
+		if v := yamlValue(m, k); v != nil {
+			value.HeadComment, value.LineComment, value.FootComment = v.HeadComment, v.LineComment, v.FootComment
+			*v = *value
+			continue
+		}
+		m.Content = append(m.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: k}, value)
+	}
+}